@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"gin-service/internal/config"
+	"gin-service/internal/database"
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+	"gin-service/internal/seed"
+	"gin-service/internal/services"
+
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
+	"golang.org/x/term"
+)
+
+// version is the version of the binary, set at build time with
+// -ldflags "-X main.version=..."
+var version = "dev"
+
+// command is a subcommand of the binary
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+// commands are the subcommands of the binary; serve is the default
+var commands = []command{
+	{"serve", "Run the server (the default), migrating the database first", serveCommand},
+	{"migrate", "Migrate the database: migrate [up | down N | version]", migrateCommand},
+	{"seed", "Load demo users into a database without regular users", seedCommand},
+	{"create-admin", "Create an admin user, prompting for missing credentials", createAdminCommand},
+	{"version", "Print the version", versionCommand},
+}
+
+// splitCommand splits the command line arguments into the command name and
+// its arguments. Without a command, as when only flags are given, the
+// arguments are serve's, so that invocations predating commands still work.
+func splitCommand(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "serve", args
+	}
+	return args[0], args[1:]
+}
+
+// findCommand returns the command called name
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// printUsage lists the commands on w
+func printUsage(w io.Writer) {
+	binary := filepath.Base(os.Args[0])
+	fmt.Fprintf(w, "Usage: %s [command] [flags]\n\nCommands:\n", binary)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-13s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", binary)
+}
+
+// setup loads the configuration and sets up the logger and password hasher,
+// as every command but version starts
+func setup() (*config.Config, *zap.Logger) {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Failed to load config: ", err)
+	}
+
+	logger, err := initLogger(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize logger: ", err)
+	}
+
+	// Passwords are hashed with the configured algorithm, by the server as
+	// by the commands creating users; older hashes are upgraded as users
+	// log in
+	models.SetPasswordHasher(newPasswordHasher(cfg.Password))
+	return cfg, logger
+}
+
+// newPasswordHasher creates the password hasher configured by cfg
+func newPasswordHasher(cfg config.PasswordConfig) models.PasswordHasher {
+	if cfg.Algorithm == "argon2id" {
+		return models.NewArgon2idHasher(models.Argon2idParams{
+			Time:       cfg.Argon2.Time,
+			Memory:     cfg.Argon2.Memory,
+			Threads:    cfg.Argon2.Threads,
+			KeyLength:  cfg.Argon2.KeyLength,
+			SaltLength: cfg.Argon2.SaltLength,
+		})
+	}
+	return models.NewBcryptHasher(cfg.BcryptCost)
+}
+
+// connect connects to the database and applies pending migrations under the
+// configured failure policy, for commands working on the database
+func connect(cfg *config.Config, logger *zap.Logger) *database.DB {
+	db, err := database.Initialize(cfg)
+	if err != nil {
+		logger.Fatal("Failed to initialize database", zap.Error(err))
+	}
+
+	migrator := database.NewMigrator(cfg.Database.URL, cfg.Migration.Path)
+	if err := migrator.RunMigrationsWithPolicy(cfg.Migration.OnFailure, cfg.Service.Environment, logger); err != nil {
+		logger.Fatal("Failed to run migrations", zap.Error(err))
+	}
+	return db
+}
+
+// migrateCommand applies pending migrations, rolls back the last ones or
+// prints the current version
+func migrateCommand(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s migrate [up | down N | version]\n", filepath.Base(os.Args[0]))
+	}
+	flags.Parse(args)
+
+	cfg, logger := setup()
+	defer logger.Sync()
+	migrator := database.NewMigrator(cfg.Database.URL, cfg.Migration.Path)
+
+	switch action := flags.Arg(0); action {
+	case "", "up":
+		if err := migrator.RunMigrations(); err != nil {
+			logger.Fatal("Failed to run migrations", zap.Error(err))
+		}
+	case "down":
+		steps, err := strconv.Atoi(flags.Arg(1))
+		if err != nil {
+			logger.Fatal("migrate down takes the number of migrations to roll back", zap.String("steps", flags.Arg(1)))
+		}
+		if err := migrator.RunMigrationsDown(steps); err != nil {
+			logger.Fatal("Failed to roll back migrations", zap.Error(err))
+		}
+	case "version":
+		version, dirty, err := migrator.MigrationVersion()
+		if err != nil {
+			logger.Fatal("Failed to get migration version", zap.Error(err))
+		}
+		if dirty {
+			fmt.Printf("%d (dirty)\n", version)
+		} else {
+			fmt.Println(version)
+		}
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+}
+
+// seedCommand loads the demo users, as serve does with --seed
+func seedCommand(args []string) {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	flags.Parse(args)
+
+	cfg, logger := setup()
+	defer logger.Sync()
+	db := connect(cfg, logger)
+	defer db.Close()
+
+	if err := runSeed(db, logger, cfg.Service.Environment); err != nil {
+		logger.Fatal("Failed to load demo data", zap.Error(err))
+	}
+}
+
+// createAdminCommand creates an admin user from flags or the environment,
+// prompting on a terminal for whatever is missing
+func createAdminCommand(args []string) {
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	username := flags.String("username", os.Getenv("ADMIN_USERNAME"), "Admin username (env ADMIN_USERNAME)")
+	email := flags.String("email", os.Getenv("ADMIN_EMAIL"), "Admin email (env ADMIN_EMAIL)")
+	password := flags.String("password", os.Getenv("ADMIN_PASSWORD"), "Admin password (env ADMIN_PASSWORD); prompted for without echo when omitted")
+	force := flags.Bool("force", false, "Create the admin user even if an admin already exists")
+	flags.Parse(args)
+
+	req := &models.CreateUserRequest{Username: *username, Email: *email, Password: *password}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		readPassword := func() (string, error) {
+			password, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			return string(password), err
+		}
+		if err := promptCredentials(req, os.Stdin, os.Stderr, readPassword); err != nil {
+			log.Fatal("Failed to read admin credentials: ", err)
+		}
+	}
+
+	cfg, logger := setup()
+	defer logger.Sync()
+	db := connect(cfg, logger)
+	defer db.Close()
+
+	if err := runCreateAdmin(db, logger, req, *force); err != nil {
+		logger.Fatal("Failed to create admin user", zap.Error(err))
+	}
+}
+
+// promptCredentials asks on out for the fields of req left empty, reading
+// the answers from in. The password is read with readPassword when set, so
+// that a terminal doesn't echo it.
+func promptCredentials(req *models.CreateUserRequest, in io.Reader, out io.Writer, readPassword func() (string, error)) error {
+	reader := bufio.NewReader(in)
+	readLine := func() (string, error) {
+		line, err := reader.ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			return "", err
+		}
+		return strings.TrimSpace(line), nil
+	}
+	if readPassword == nil {
+		readPassword = readLine
+	}
+
+	fields := []struct {
+		label string
+		value *string
+		read  func() (string, error)
+	}{
+		{"Username", &req.Username, readLine},
+		{"Email", &req.Email, readLine},
+		{"Password", &req.Password, readPassword},
+	}
+	for _, field := range fields {
+		if *field.value != "" {
+			continue
+		}
+		fmt.Fprintf(out, "%s: ", field.label)
+		value, err := field.read()
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToLower(field.label), err)
+		}
+		*field.value = value
+	}
+	return nil
+}
+
+// versionCommand prints the version of the binary, with the commit it was
+// built from when known
+func versionCommand(args []string) {
+	flags := flag.NewFlagSet("version", flag.ExitOnError)
+	flags.Parse(args)
+
+	revision := ""
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = ", commit " + setting.Value
+			}
+		}
+	}
+	fmt.Printf("gin-service %s (%s%s)\n", version, runtime.Version(), revision)
+}
+
+// runCreateAdmin creates the initial admin user, skipping if one already exists
+func runCreateAdmin(db database.DBInterface, logger *zap.Logger, req *models.CreateUserRequest, force bool) error {
+	if err := binding.Validator.ValidateStruct(req); err != nil {
+		return fmt.Errorf("invalid admin user: %w", err)
+	}
+
+	userService := services.NewUserService(repository.NewSQLStore(db), logger)
+	user, err := userService.CreateAdmin(req, force)
+	if errors.Is(err, services.ErrAdminExists) {
+		logger.Info("Admin user already exists, skipping (use --force to create another)")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Admin user created", zap.Int("user_id", user.ID), zap.String("username", user.Username))
+	return nil
+}
+
+// runSeed loads the embedded demo users if the database has no users
+func runSeed(db database.DBInterface, logger *zap.Logger, environment string) error {
+	users, err := seed.DefaultUsers()
+	if err != nil {
+		return err
+	}
+
+	userService := services.NewUserService(repository.NewSQLStore(db), logger)
+	return seed.NewSeeder(userService, logger).Run(environment, users)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gin-service/internal/config"
+	"gin-service/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantName string
+		wantArgs []string
+	}{
+		{nil, "serve", nil},
+		// Flags alone are serve's, as before commands existed
+		{[]string{"--seed"}, "serve", []string{"--seed"}},
+		{[]string{"--create-admin", "--admin-username", "root"}, "serve", []string{"--create-admin", "--admin-username", "root"}},
+		{[]string{"migrate", "down", "1"}, "migrate", []string{"down", "1"}},
+		{[]string{"version"}, "version", []string{}},
+	}
+
+	for _, tt := range tests {
+		name, args := splitCommand(tt.args)
+		assert.Equal(t, tt.wantName, name, tt.args)
+		assert.Equal(t, tt.wantArgs, args, tt.args)
+	}
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+	argon2 := config.Argon2idConfig{Time: 1, Memory: 1024, Threads: 1, KeyLength: 32, SaltLength: 16}
+	hasher := newPasswordHasher(config.PasswordConfig{Algorithm: "argon2id", BcryptCost: 12, Argon2: argon2})
+	hash, err := hasher.Hash("password123")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), hash)
+
+	hasher = newPasswordHasher(config.PasswordConfig{Algorithm: "bcrypt", BcryptCost: 5, Argon2: argon2})
+	hash, err = hasher.Hash("password123")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$2a$05$"), hash)
+}
+
+func TestFindCommand(t *testing.T) {
+	for _, name := range []string{"serve", "migrate", "seed", "create-admin", "version"} {
+		cmd, ok := findCommand(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, name, cmd.name)
+	}
+
+	_, ok := findCommand("serve-forever")
+	assert.False(t, ok)
+}
+
+func TestPromptCredentials(t *testing.T) {
+	req := &models.CreateUserRequest{Email: "admin@example.com"}
+	var out bytes.Buffer
+	readPassword := func() (string, error) { return "password123", nil }
+
+	err := promptCredentials(req, strings.NewReader("  root \n"), &out, readPassword)
+	require.NoError(t, err)
+
+	// Only the missing fields are asked for
+	assert.Equal(t, "Username: Password: ", out.String())
+	assert.Equal(t, "root", req.Username)
+	assert.Equal(t, "admin@example.com", req.Email)
+	assert.Equal(t, "password123", req.Password)
+}
+
+func TestPromptCredentials_ReadsPasswordLine(t *testing.T) {
+	req := &models.CreateUserRequest{}
+	var out bytes.Buffer
+
+	err := promptCredentials(req, strings.NewReader("root\nadmin@example.com\npassword123"), &out, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "password123", req.Password)
+
+	// Input running out is an error
+	err = promptCredentials(&models.CreateUserRequest{}, strings.NewReader("root\n"), &out, nil)
+	assert.EqualError(t, err, "email: EOF")
+}
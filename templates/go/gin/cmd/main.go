@@ -2,8 +2,9 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,11 +12,20 @@ import (
 	"time"
 
 	"gin-service/internal/api"
+	"gin-service/internal/cache"
 	"gin-service/internal/config"
 	"gin-service/internal/database"
+	"gin-service/internal/events"
+	"gin-service/internal/httpserver"
+	"gin-service/internal/lifecycle"
+	"gin-service/internal/logging"
+	"gin-service/internal/models"
+	"gin-service/internal/outbox"
+	"gin-service/internal/repository"
+	"gin-service/internal/services"
+	"gin-service/internal/storage"
 
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 )
 
 // @title Gin REST API
@@ -39,17 +49,38 @@ import (
 // @description Type "Bearer" followed by a space and JWT token.
 
 func main() {
-	// Load configuration
-	cfg, err := config.Load()
-	if err != nil {
-		log.Fatal("Failed to load config: ", err)
+	name, args := splitCommand(os.Args[1:])
+	if name == "help" {
+		printUsage(os.Stdout)
+		return
 	}
-
-	// Initialize logger
-	logger, err := initLogger(cfg)
-	if err != nil {
-		log.Fatal("Failed to initialize logger: ", err)
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		os.Exit(2)
 	}
+	cmd.run(args)
+}
+
+// serveCommand runs the server until SIGINT or SIGTERM, after migrating the
+// database
+func serveCommand(args []string) {
+	// Startup is timed from here, phase by phase, to diagnose slow boots
+	startedAt := time.Now()
+
+	// Parse command line flags; the admin flags predate the create-admin
+	// command and are kept for existing deployments
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	seedData := flags.Bool("seed", os.Getenv("SEED_DATA") == "true", "Load demo users into an empty database (env SEED_DATA=true)")
+	createAdmin := flags.Bool("create-admin", false, "Deprecated: use the create-admin command")
+	adminUsername := flags.String("admin-username", os.Getenv("ADMIN_USERNAME"), "Deprecated: use the create-admin command")
+	adminEmail := flags.String("admin-email", os.Getenv("ADMIN_EMAIL"), "Deprecated: use the create-admin command")
+	adminPassword := flags.String("admin-password", os.Getenv("ADMIN_PASSWORD"), "Deprecated: use the create-admin command")
+	force := flags.Bool("force", false, "Deprecated: use the create-admin command")
+	flags.Parse(args)
+
+	cfg, logger := setup()
 	defer logger.Sync()
 
 	logger.Info("Starting Gin service",
@@ -60,94 +91,261 @@ func main() {
 	)
 
 	// Initialize database
+	phaseStart := time.Now()
 	db, err := database.Initialize(cfg)
 	if err != nil {
 		logger.Fatal("Failed to initialize database", zap.Error(err))
 	}
 	defer db.Close()
 
-	logger.Info("Database connection established")
+	logger.Info("Database connection established", zap.Duration("duration", time.Since(phaseStart)))
+
+	// Readiness fails until the server listens
+	phases := lifecycle.NewState()
 
 	// Run migrations
-	if err := database.RunMigrations(cfg.Database.URL); err != nil {
+	phases.Set(lifecycle.PhaseMigrating)
+	phaseStart = time.Now()
+	migrator := database.NewMigrator(cfg.Database.URL, cfg.Migration.Path)
+	if err := migrator.RunMigrationsWithPolicy(cfg.Migration.OnFailure, cfg.Service.Environment, logger); err != nil {
 		logger.Fatal("Failed to run migrations", zap.Error(err))
 	}
+	logger.Info("Migrations complete", zap.Duration("duration", time.Since(phaseStart)))
+
+	if *createAdmin {
+		req := &models.CreateUserRequest{
+			Username: *adminUsername,
+			Email:    *adminEmail,
+			Password: *adminPassword,
+		}
+		if err := runCreateAdmin(db, logger, req, *force); err != nil {
+			logger.Fatal("Failed to create admin user", zap.Error(err))
+		}
+		return
+	}
+
+	if *seedData {
+		if err := runSeed(db, logger, cfg.Service.Environment); err != nil {
+			logger.Fatal("Failed to load demo data", zap.Error(err))
+		}
+	}
+
+	// Publish outbox events until shutdown
+	pollerCtx, stopPoller := context.WithCancel(context.Background())
+	defer stopPoller()
+	var poller *outbox.Poller
+	if cfg.Outbox.Enabled {
+		bus := events.NewBus()
+
+		// Deliver user events to the registered webhooks
+		if cfg.Webhooks.Enabled {
+			webhookService := services.NewWebhookService(repository.NewSQLStore(db), logger)
+			if err := webhookService.EnsureWebhooks(configuredWebhooks(cfg.Webhooks)); err != nil {
+				logger.Fatal("Failed to register configured webhooks", zap.Error(err))
+			}
+			for _, eventType := range services.WebhookEventTypes {
+				bus.Subscribe(eventType, webhookService.HandleEvent)
+			}
+			worker := services.NewWebhookWorker(repository.NewSQLStore(db).Webhooks(), services.WebhookDeliveryOptions{
+				BatchSize:   cfg.Webhooks.BatchSize,
+				Concurrency: cfg.Webhooks.Concurrency,
+				Timeout:     cfg.Webhooks.Timeout.Duration(),
+				MaxAttempts: cfg.Webhooks.MaxAttempts,
+				BaseBackoff: cfg.Webhooks.BaseBackoff.Duration(),
+				MaxBackoff:  cfg.Webhooks.MaxBackoff.Duration(),
+			}, cfg.Webhooks.PollInterval.Duration(), logger)
+			go worker.Run(pollerCtx)
+		}
+
+		poller = outbox.NewPoller(repository.NewSQLStore(db).Outbox(), bus, cfg.Outbox, logger)
+		go poller.Run(pollerCtx)
+	}
+
+	// Cache users looked up by ID; every user service writing users shares
+	// the cache, so that it sees their writes
+	var userCache *services.UserCache
+	if cfg.Cache.Enabled {
+		options := services.UserCacheOptions{
+			TTL:     cfg.Cache.TTL.Duration(),
+			ListTTL: cfg.Cache.ListTTL.Duration(),
+		}
+		if cfg.Cache.Backend == config.CacheBackendMemory {
+			// Other instances' writes are learnt of by notification; those
+			// missed while the listener reconnects go unnoticed until the
+			// users expire
+			memoryCache := cache.NewMemoryCache()
+			go memoryCache.Run(pollerCtx, cfg.Cache.SweepInterval.Duration())
+			userCache = services.NewUserCache(memoryCache, options, logger)
+			listener := database.NewListener(cfg.Database.URL, services.UserChangesChannel, logger)
+			go listener.Run(pollerCtx, userCache.HandleUserChange)
+		} else {
+			redisCache := cache.NewRedisCache(cfg.Redis, cfg.Cache.Timeout.Duration())
+			defer redisCache.Close()
+			if err := redisCache.Ping(context.Background()); err != nil {
+				logger.Warn("Redis is unavailable, users are read from the database until it is back", zap.Error(err))
+			}
+			userCache = services.NewUserCache(redisCache, options, logger)
+		}
+	}
+
+	// Delete accounts past their deletion grace period until shutdown
+	reaperService := services.NewUserService(repository.NewSQLStore(db), logger)
+	reaperService.SetCache(userCache)
+	reaperService.SetAvatarStore(storage.NewLocalStore(cfg.Storage.LocalDir, cfg.Storage.BaseURL), services.AvatarLimits{})
+	reaper := services.NewDeletionReaper(reaperService, cfg.Deletion.ReapInterval.Duration(), logger)
+	go reaper.Run(pollerCtx)
 
 	// Initialize router
-	router := api.NewRouter(cfg, db, logger)
-
-	// Create HTTP server
-	server := &http.Server{
-		Addr:         ":" + cfg.Server.Port,
-		Handler:      router,
-		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
-		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
-		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout) * time.Second,
+	phaseStart = time.Now()
+	router := api.NewRouter(cfg, db, userCache, phases, logger)
+	logger.Info("Router set up", zap.Duration("duration", time.Since(phaseStart)))
+
+	// Create HTTP server, counting requests in flight to report how many
+	// shutdown drains
+	requests := httpserver.CountRequests(router)
+	server := httpserver.New(cfg.Server, requests)
+
+	// Terminate TLS when configured, otherwise serve plain HTTP
+	var redirectServer *http.Server
+	if cfg.Server.TLS.Enabled {
+		tlsConfig, certReloader, err := httpserver.NewTLSConfig(cfg.Server.TLS)
+		if err != nil {
+			logger.Fatal("Failed to configure TLS", zap.Error(err))
+		}
+		server.TLSConfig = tlsConfig
+		if certReloader == nil {
+			logger.Warn("Serving a self-signed certificate, for development only")
+		} else {
+			// Reload the certificate on SIGHUP, so that renewing it needs no
+			// restart
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			go func() {
+				for range hup {
+					if err := certReloader.Reload(); err != nil {
+						logger.Error("Failed to reload TLS certificate, keeping the current one", zap.Error(err))
+						continue
+					}
+					logger.Info("TLS certificate reloaded")
+				}
+			}()
+		}
+
+		if cfg.Server.TLS.RedirectPort != "" {
+			redirectServer = &http.Server{
+				Addr:              ":" + cfg.Server.TLS.RedirectPort,
+				Handler:           httpserver.RedirectToHTTPS(cfg.Server.Port),
+				ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout.Duration(),
+			}
+			go func() {
+				logger.Info("HTTPS redirect starting", zap.String("address", redirectServer.Addr))
+				if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					logger.Fatal("Failed to start HTTPS redirect", zap.Error(err))
+				}
+			}()
+		}
 	}
 
-	// Start server in a goroutine
+	// Listen before reporting ready, then serve in a goroutine
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		logger.Fatal("Failed to start server", zap.Error(err))
+	}
 	go func() {
-		logger.Info("Server starting", zap.String("address", server.Addr))
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Info("Server starting", zap.String("address", server.Addr), zap.Bool("tls", server.TLSConfig != nil))
+
+		var err error
+		if server.TLSConfig != nil {
+			// The certificate is already in TLSConfig; HTTP/2 is negotiated
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Fatal("Failed to start server", zap.Error(err))
 		}
 	}()
+	phases.Set(lifecycle.PhaseReady)
+	logger.Info("Startup complete", zap.Duration("duration", time.Since(startedAt)))
 
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	logger.Info("Server shutting down...")
+	// Fail readiness first, so load balancers stop sending traffic before
+	// connections close
+	phases.Set(lifecycle.PhaseDraining)
+	shutdownStart := time.Now()
+	logger.Info("Server shutting down...", zap.Duration("drain_delay", cfg.Server.DrainDelay.Duration()))
+	time.Sleep(cfg.Server.DrainDelay.Duration())
 
 	// Give outstanding requests 30 seconds to complete
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	if redirectServer != nil {
+		if err := redirectServer.Shutdown(ctx); err != nil {
+			logger.Warn("HTTPS redirect forced to shutdown", zap.Error(err))
+		}
+	}
+
+	inFlight := requests.InFlight()
 	if err := server.Shutdown(ctx); err != nil {
-		logger.Fatal("Server forced to shutdown", zap.Error(err))
+		logger.Fatal("Server forced to shutdown", zap.Error(err), zap.Int64("in_flight", requests.InFlight()))
 	}
+	logger.Info("Connections drained", zap.Int64("requests", inFlight))
+
+	// Requests write outbox events until the server has drained, so the
+	// poller and webhook workers run until then
+	stopPoller()
 
-	logger.Info("Server exited")
+	// Publish the events of the last requests now rather than on the next
+	// start; whatever isn't published stays in the outbox
+	if poller != nil {
+		published, err := poller.Drain(ctx)
+		if err != nil {
+			logger.Warn("Outbox not fully drained", zap.Error(err), zap.Int("published", published))
+		} else {
+			logger.Info("Outbox drained", zap.Int("published", published))
+		}
+	}
+
+	logger.Info("Server exited", zap.Duration("shutdown_duration", time.Since(shutdownStart)))
 }
 
-func initLogger(cfg *config.Config) (*zap.Logger, error) {
-	var logger *zap.Logger
-	var err error
-
-	if cfg.Service.Environment == "production" {
-		// Production logger with JSON format
-		config := zap.NewProductionConfig()
-		config.Level = zap.NewAtomicLevelAt(parseLogLevel(cfg.Log.Level))
-		logger, err = config.Build()
-	} else {
-		// Development logger with console format
-		config := zap.NewDevelopmentConfig()
-		config.Level = zap.NewAtomicLevelAt(parseLogLevel(cfg.Log.Level))
-		logger, err = config.Build()
+// configuredWebhooks returns the webhooks of the config file as requests to
+// register them
+func configuredWebhooks(cfg config.WebhookConfig) []models.CreateWebhookRequest {
+	reqs := make([]models.CreateWebhookRequest, len(cfg.Endpoints))
+	for i, endpoint := range cfg.Endpoints {
+		reqs[i] = models.CreateWebhookRequest{
+			URL:        endpoint.URL,
+			Secret:     endpoint.Secret,
+			EventTypes: endpoint.EventTypes,
+		}
 	}
+	return reqs
+}
 
+func initLogger(cfg *config.Config) (*zap.Logger, error) {
+	level := zap.NewAtomicLevelAt(logging.ParseLevel(cfg.Log.Level))
+	logger, err := logging.NewAtLevel(cfg.Log, cfg.Service.Environment, level)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build logger: %w", err)
+		return nil, err
 	}
 
 	// Set global logger
 	zap.ReplaceGlobals(logger)
 
-	return logger, nil
-}
+	// The level follows the config file; invalid reloads are logged by the
+	// router's watcher
+	config.Watch(func(reloaded *config.Config) {
+		if next := logging.ParseLevel(reloaded.Log.Level); next != level.Level() {
+			logger.Warn("Log level changed by config reload", zap.Stringer("level", next))
+			level.SetLevel(next)
+		}
+	}, func(error) {})
 
-func parseLogLevel(level string) zapcore.Level {
-	switch level {
-	case "debug":
-		return zap.DebugLevel
-	case "info":
-		return zap.InfoLevel
-	case "warn":
-		return zap.WarnLevel
-	case "error":
-		return zap.ErrorLevel
-	default:
-		return zap.InfoLevel
-	}
+	return logger, nil
 }
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"gin-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userFields are the fields a user response may be narrowed to, the JSON
+// names of models.UserResponse. Credentials such as the password hash are
+// not part of the response, so they can never be selected.
+var userFields = jsonFieldNames(reflect.TypeOf(models.UserResponse{}))
+
+// jsonFieldNames returns the JSON names of the fields of the struct type t
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// parseUserFields parses the fields query parameter, a comma-separated list
+// of the user fields to respond with. Without the parameter it returns nil,
+// selecting every field. On an unknown field it responds with 400 and
+// returns false.
+func parseUserFields(c *gin.Context) ([]string, bool) {
+	param, ok := c.GetQuery("fields")
+	if !ok {
+		return nil, true
+	}
+
+	var fields []string
+	for _, field := range strings.Split(param, ",") {
+		field = strings.TrimSpace(field)
+		if !userFields[field] {
+			RespondError(c, http.StatusBadRequest, "invalid_fields", fmt.Sprintf("Unknown field %q", field))
+			return nil, false
+		}
+		fields = append(fields, field)
+	}
+	return fields, true
+}
+
+// selectFields returns the struct v, or the struct it points to, narrowed to
+// fields as a map keyed by their JSON names, or v itself when fields is nil.
+// The values keep their types, so the map encodes as v would, in JSON as in
+// MessagePack. Selected fields omitted from v stay omitted.
+func selectFields(v interface{}, fields []string) (interface{}, error) {
+	if fields == nil {
+		return v, nil
+	}
+
+	object := reflect.Indirect(reflect.ValueOf(v))
+	if object.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot select fields of %T", v)
+	}
+	wanted := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		wanted[field] = true
+	}
+
+	selected := make(map[string]interface{}, len(fields))
+	for i := 0; i < object.NumField(); i++ {
+		name, options, _ := strings.Cut(object.Type().Field(i).Tag.Get("json"), ",")
+		value := object.Field(i)
+		if !wanted[name] || (strings.Contains(options, "omitempty") && isEmptyJSONValue(value)) {
+			continue
+		}
+		selected[name] = value.Interface()
+	}
+	return selected, nil
+}
+
+// isEmptyJSONValue reports whether v is empty as encoding/json defines it
+// for omitempty
+func isEmptyJSONValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
+
+// respondFields writes v narrowed to fields as a response with status
+func respondFields(c *gin.Context, status int, v interface{}, fields []string) {
+	response, err := selectFields(v, fields)
+	if err != nil {
+		RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to encode response")
+		return
+	}
+	Respond(c, status, response)
+}
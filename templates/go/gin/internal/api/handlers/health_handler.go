@@ -1,27 +1,242 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"runtime"
+	"sync"
 	"time"
 
 	"gin-service/internal/database"
+	"gin-service/internal/lifecycle"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
+// MigrationVersioner reports the current schema migration version
+type MigrationVersioner interface {
+	MigrationVersion() (uint, bool, error)
+}
+
+// reconnector is implemented by databases that can re-establish their connections
+type reconnector interface {
+	Reconnect() error
+}
+
+// ProcessStats reports resource usage of the running process
+type ProcessStats interface {
+	MemoryBytes() uint64
+	Goroutines() int
+}
+
+// runtimeStats reads process stats from the Go runtime
+type runtimeStats struct{}
+
+// MemoryBytes returns the memory obtained from the OS, approximating RSS
+func (runtimeStats) MemoryBytes() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.Sys
+}
+
+// Goroutines returns the number of running goroutines
+func (runtimeStats) Goroutines() int {
+	return runtime.NumGoroutine()
+}
+
+// ProcessLimits holds thresholds for the process resource checks. A zero
+// threshold disables its check.
+type ProcessLimits struct {
+	MaxMemoryBytes uint64
+	MaxGoroutines  int
+	// FailReadiness makes Readiness fail while a threshold is exceeded
+	FailReadiness bool
+}
+
+// defaultCheckTimeout bounds each dependency check unless SetCheckTimeout
+// says otherwise
+const defaultCheckTimeout = 2 * time.Second
+
 // HealthHandler handles health check requests
 type HealthHandler struct {
-	db     database.DBInterface
-	logger *zap.Logger
+	db           database.DBInterface
+	migrator     MigrationVersioner
+	breaker      *database.CircuitBreaker
+	stats        ProcessStats
+	limits       ProcessLimits
+	phases       *lifecycle.State
+	checkTimeout time.Duration
+	logger       *zap.Logger
 }
 
 // NewHealthHandler creates a new health handler
 func NewHealthHandler(db database.DBInterface, logger *zap.Logger) *HealthHandler {
 	return &HealthHandler{
-		db:     db,
-		logger: logger,
+		db:           db,
+		stats:        runtimeStats{},
+		checkTimeout: defaultCheckTimeout,
+		logger:       logger,
+	}
+}
+
+// SetLifecycle makes Readiness fail outside of the ready phase, that is
+// while the service starts and while it drains on shutdown
+func (h *HealthHandler) SetLifecycle(phases *lifecycle.State) {
+	h.phases = phases
+}
+
+// SetMigrator enables the schema check, which reports the migration version
+// in DetailedHealth and fails Readiness while the schema is dirty. The
+// migrator is asked on every probe, so it should query through the pool like
+// *database.DB does, rather than open a migrate connection each time.
+func (h *HealthHandler) SetMigrator(migrator MigrationVersioner) {
+	h.migrator = migrator
+}
+
+// SetCircuitBreaker makes DetailedHealth report the state of the database
+// circuit breaker
+func (h *HealthHandler) SetCircuitBreaker(breaker *database.CircuitBreaker) {
+	h.breaker = breaker
+}
+
+// SetProcessLimits enables the memory and goroutine checks
+func (h *HealthHandler) SetProcessLimits(limits ProcessLimits) {
+	h.limits = limits
+}
+
+// SetCheckTimeout bounds each dependency check. The checks run
+// concurrently, so it also bounds the health endpoints.
+func (h *HealthHandler) SetCheckTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		h.checkTimeout = timeout
+	}
+}
+
+// checkResult is the outcome of a dependency check
+type checkResult struct {
+	// status is reported in the response's checks
+	status string
+	// ok is false when the dependency makes the service unhealthy
+	ok bool
+}
+
+// dependencyCheck checks a dependency. It may ignore ctx, in which case it
+// is abandoned once ctx is done and left to finish in the background.
+type dependencyCheck func(ctx context.Context) checkResult
+
+// runChecks runs each check in its own goroutine, bounded by the check
+// timeout, and returns their results by name. A check that doesn't finish
+// in time reports "unhealthy: timeout", so a hung dependency can't hold up
+// the response beyond the timeout.
+func (h *HealthHandler) runChecks(ctx context.Context, checks map[string]dependencyCheck) map[string]checkResult {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(map[string]checkResult, len(checks))
+	)
+	for name, check := range checks {
+		name, check := name, check
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, h.checkTimeout)
+			defer cancel()
+
+			// Buffered so an abandoned check can still finish
+			done := make(chan checkResult, 1)
+			go func() { done <- check(checkCtx) }()
+
+			var result checkResult
+			select {
+			case result = <-done:
+			case <-checkCtx.Done():
+				result = checkResult{status: "unhealthy: timeout"}
+				h.logger.Warn("Health check timed out", zap.String("check", name), zap.Duration("timeout", h.checkTimeout))
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = result
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// dependencyChecks returns the checks of the service's dependencies: the
+// database and, when enabled, the schema
+func (h *HealthHandler) dependencyChecks(reconnect bool) map[string]dependencyCheck {
+	checks := map[string]dependencyCheck{
+		"database": func(ctx context.Context) checkResult { return h.checkDatabase(reconnect) },
+	}
+	if h.migrator != nil {
+		checks["schema"] = func(ctx context.Context) checkResult { return h.checkSchema() }
 	}
+	return checks
+}
+
+// checkDatabase checks the database connection, attempting a reconnect on
+// failure when asked to
+func (h *HealthHandler) checkDatabase(reconnect bool) checkResult {
+	err := h.db.Health()
+	if r, ok := h.db.(reconnector); ok && reconnect && err != nil {
+		h.logger.Warn("Database health check failed, reconnecting", zap.Error(err))
+		if err = r.Reconnect(); err == nil {
+			h.logger.Info("Database reconnected")
+		}
+	}
+	if err != nil {
+		h.logger.Warn("Database health check failed", zap.Error(err))
+		return checkResult{status: "unhealthy: " + err.Error()}
+	}
+	return checkResult{status: "healthy", ok: true}
+}
+
+// checkSchema runs the schema check. The schema is usable unless dirty: a
+// dirty schema is left half-migrated by a failed migration, while a version
+// that can't be read is only reported.
+func (h *HealthHandler) checkSchema() checkResult {
+	version, dirty, err := h.migrator.MigrationVersion()
+	switch {
+	case err != nil:
+		h.logger.Warn("Schema version check failed", zap.Error(err))
+		return checkResult{status: "unknown: " + err.Error(), ok: true}
+	case dirty:
+		h.logger.Warn("Database schema is dirty", zap.Uint("version", version))
+		return checkResult{status: fmt.Sprintf("unhealthy: version %d is dirty", version)}
+	default:
+		return checkResult{status: fmt.Sprintf("version %d", version), ok: true}
+	}
+}
+
+// checkProcess runs the process resource checks, adding their results to
+// checks. It reports whether every threshold is respected.
+func (h *HealthHandler) checkProcess(checks map[string]string) bool {
+	ok := true
+
+	if h.limits.MaxMemoryBytes > 0 {
+		memory := h.stats.MemoryBytes()
+		if memory > h.limits.MaxMemoryBytes {
+			checks["memory"] = fmt.Sprintf("unhealthy: %d bytes exceeds %d", memory, h.limits.MaxMemoryBytes)
+			ok = false
+		} else {
+			checks["memory"] = "healthy"
+		}
+	}
+
+	if h.limits.MaxGoroutines > 0 {
+		goroutines := h.stats.Goroutines()
+		if goroutines > h.limits.MaxGoroutines {
+			checks["goroutines"] = fmt.Sprintf("unhealthy: %d goroutines exceeds %d", goroutines, h.limits.MaxGoroutines)
+			ok = false
+		} else {
+			checks["goroutines"] = "healthy"
+		}
+	}
+
+	return ok
 }
 
 // HealthResponse represents a health check response
@@ -41,7 +256,7 @@ type HealthResponse struct {
 // @Success 200 {object} HealthResponse
 // @Router /health [get]
 func (h *HealthHandler) BasicHealth(c *gin.Context) {
-	c.JSON(http.StatusOK, HealthResponse{
+	Respond(c, http.StatusOK, HealthResponse{
 		Status:    "healthy",
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 		Service:   "gin-service",
@@ -61,24 +276,34 @@ func (h *HealthHandler) DetailedHealth(c *gin.Context) {
 	checks := make(map[string]string)
 	overallStatus := "healthy"
 
-	// Check database connection
-	if err := h.db.Health(); err != nil {
-		checks["database"] = "unhealthy: " + err.Error()
-		overallStatus = "unhealthy"
-		h.logger.Warn("Database health check failed", zap.Error(err))
-	} else {
-		checks["database"] = "healthy"
+	// Check the dependencies concurrently, attempting a database reconnect
+	// on failure. The schema check reports the migration version; a dirty
+	// schema needs manual repair, so it makes the service unhealthy.
+	for name, result := range h.runChecks(c.Request.Context(), h.dependencyChecks(true)) {
+		checks[name] = result.status
+		if !result.ok {
+			overallStatus = "unhealthy"
+		}
+	}
+
+	// The breaker's state is only reported: while it is open the database
+	// check tells whether the database is back
+	if h.breaker != nil {
+		checks["database_circuit"] = h.breaker.State().String()
 	}
 
-	// You can add more health checks here
-	// For example: Redis, external APIs, etc.
+	// Process resource checks only degrade the service
+	if !h.checkProcess(checks) && overallStatus == "healthy" {
+		overallStatus = "degraded"
+		h.logger.Warn("Process resource check failed", zap.Any("checks", checks))
+	}
 
 	statusCode := http.StatusOK
 	if overallStatus == "unhealthy" {
 		statusCode = http.StatusServiceUnavailable
 	}
 
-	c.JSON(statusCode, HealthResponse{
+	Respond(c, statusCode, HealthResponse{
 		Status:    overallStatus,
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 		Service:   "gin-service",
@@ -96,19 +321,59 @@ func (h *HealthHandler) DetailedHealth(c *gin.Context) {
 // @Failure 503 {object} HealthResponse
 // @Router /ready [get]
 func (h *HealthHandler) Readiness(c *gin.Context) {
+	// Stay out of rotation while starting up and draining
+	if h.phases != nil && !h.phases.Ready() {
+		Respond(c, http.StatusServiceUnavailable, HealthResponse{
+			Status:    "not ready",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Service:   "gin-service",
+			Version:   "1.0.0",
+			Checks:    map[string]string{"phase": h.phases.Phase().String()},
+		})
+		return
+	}
+
 	// Check critical dependencies
-	if err := h.db.Health(); err != nil {
-		h.logger.Warn("Readiness check failed - database unhealthy", zap.Error(err))
-		c.JSON(http.StatusServiceUnavailable, HealthResponse{
+	results := h.runChecks(c.Request.Context(), h.dependencyChecks(false))
+	if database := results["database"]; !database.ok {
+		h.logger.Warn("Readiness check failed - database unhealthy", zap.String("database", database.status))
+		Respond(c, http.StatusServiceUnavailable, HealthResponse{
+			Status:    "not ready",
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Service:   "gin-service",
+			Version:   "1.0.0",
+		})
+		return
+	}
+
+	if schema, ok := results["schema"]; ok && !schema.ok {
+		h.logger.Warn("Readiness check failed - database schema dirty")
+		Respond(c, http.StatusServiceUnavailable, HealthResponse{
 			Status:    "not ready",
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
 			Service:   "gin-service",
 			Version:   "1.0.0",
+			Checks:    map[string]string{"schema": schema.status},
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, HealthResponse{
+	if h.limits.FailReadiness {
+		checks := make(map[string]string)
+		if !h.checkProcess(checks) {
+			h.logger.Warn("Readiness check failed - process resources exceeded", zap.Any("checks", checks))
+			Respond(c, http.StatusServiceUnavailable, HealthResponse{
+				Status:    "not ready",
+				Timestamp: time.Now().UTC().Format(time.RFC3339),
+				Service:   "gin-service",
+				Version:   "1.0.0",
+				Checks:    checks,
+			})
+			return
+		}
+	}
+
+	Respond(c, http.StatusOK, HealthResponse{
 		Status:    "ready",
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 		Service:   "gin-service",
@@ -124,7 +389,7 @@ func (h *HealthHandler) Readiness(c *gin.Context) {
 // @Success 200 {object} HealthResponse
 // @Router /live [get]
 func (h *HealthHandler) Liveness(c *gin.Context) {
-	c.JSON(http.StatusOK, HealthResponse{
+	Respond(c, http.StatusOK, HealthResponse{
 		Status:    "alive",
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 		Service:   "gin-service",
@@ -3,9 +3,14 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
+
+	"gin-service/internal/database"
+	"gin-service/internal/lifecycle"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
@@ -19,6 +24,8 @@ type MockDB struct {
 	mock.Mock
 }
 
+var _ database.DBInterface = (*MockDB)(nil)
+
 func (m *MockDB) Get(dest interface{}, query string, args ...interface{}) error {
 	mockArgs := m.Called(dest, query, args)
 	return mockArgs.Error(0)
@@ -272,4 +279,325 @@ func TestHealthHandler_Liveness(t *testing.T) {
 	assert.Equal(t, "gin-service", response.Service)
 	assert.Equal(t, "1.0.0", response.Version)
 	assert.NotEmpty(t, response.Timestamp)
-}
\ No newline at end of file
+}
+
+// fakeMigrator is a MigrationVersioner returning a fixed version
+type fakeMigrator struct {
+	version uint
+	dirty   bool
+	err     error
+}
+
+func (f *fakeMigrator) MigrationVersion() (uint, bool, error) {
+	return f.version, f.dirty, f.err
+}
+
+func TestHealthHandler_DetailedHealth_CircuitBreaker(t *testing.T) {
+	handler, mockDB := setupHealthHandler()
+	mockDB.On("Health").Return(nil)
+
+	breaker := database.NewCircuitBreaker(1, time.Minute)
+	handler.SetCircuitBreaker(breaker)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/health/detailed", handler.DetailedHealth)
+
+	detailed := func() HealthResponse {
+		req, _ := http.NewRequest("GET", "/health/detailed", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var response HealthResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	assert.Equal(t, "closed", detailed().Checks["database_circuit"])
+
+	// An open breaker is reported without failing the check
+	_ = breaker.Do(func() error { return sql.ErrConnDone })
+	response := detailed()
+	assert.Equal(t, "open", response.Checks["database_circuit"])
+	assert.Equal(t, "healthy", response.Status)
+}
+
+func TestHealthHandler_DetailedHealth_MigrationVersion(t *testing.T) {
+	handler, mockDB := setupHealthHandler()
+	handler.SetMigrator(&fakeMigrator{version: 3})
+
+	mockDB.On("Health").Return(nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/health/detailed", handler.DetailedHealth)
+
+	req, _ := http.NewRequest("GET", "/health/detailed", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert response
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response HealthResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "version 3", response.Checks["schema"])
+
+	mockDB.AssertExpectations(t)
+}
+
+func TestHealthHandler_DirtySchema(t *testing.T) {
+	handler, mockDB := setupHealthHandler()
+	handler.SetMigrator(&fakeMigrator{version: 4, dirty: true})
+
+	mockDB.On("Health").Return(nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/health/detailed", handler.DetailedHealth)
+	router.GET("/ready", handler.Readiness)
+
+	for _, path := range []string{"/health/detailed", "/ready"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, path)
+
+		var response HealthResponse
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, "unhealthy: version 4 is dirty", response.Checks["schema"], path)
+	}
+}
+
+func TestHealthHandler_Readiness_UnknownSchemaVersion(t *testing.T) {
+	handler, mockDB := setupHealthHandler()
+	handler.SetMigrator(&fakeMigrator{err: errors.New("permission denied")})
+
+	mockDB.On("Health").Return(nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ready", handler.Readiness)
+
+	req, _ := http.NewRequest("GET", "/ready", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// A version that can't be read doesn't take the instance out of rotation
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// reconnectingDB is a MockDB that can reconnect
+type reconnectingDB struct {
+	MockDB
+}
+
+func (m *reconnectingDB) Reconnect() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func TestHealthHandler_DetailedHealth_Reconnects(t *testing.T) {
+	mockDB := &reconnectingDB{}
+	handler := NewHealthHandler(mockDB, zap.NewNop())
+
+	// Health fails, but the reconnect succeeds
+	mockDB.On("Health").Return(assert.AnError)
+	mockDB.On("Reconnect").Return(nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/health/detailed", handler.DetailedHealth)
+
+	req, _ := http.NewRequest("GET", "/health/detailed", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert response
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response HealthResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "healthy", response.Checks["database"])
+
+	mockDB.AssertExpectations(t)
+}
+
+func TestHealthHandler_CheckTimeout(t *testing.T) {
+	handler, mockDB := setupHealthHandler()
+	handler.SetMigrator(&fakeMigrator{version: 3})
+	handler.SetCheckTimeout(50 * time.Millisecond)
+
+	// The database hangs well past the check timeout
+	mockDB.On("Health").Return(nil).After(2 * time.Second)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/health/detailed", handler.DetailedHealth)
+	router.GET("/ready", handler.Readiness)
+
+	for _, path := range []string{"/health/detailed", "/ready"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		start := time.Now()
+		router.ServeHTTP(w, req)
+
+		assert.Less(t, time.Since(start), time.Second, path)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, path)
+	}
+
+	req, _ := http.NewRequest("GET", "/health/detailed", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response HealthResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "unhealthy", response.Status)
+	assert.Equal(t, "unhealthy: timeout", response.Checks["database"])
+	// The other checks still report
+	assert.Equal(t, "version 3", response.Checks["schema"])
+}
+
+// fakeStats is a ProcessStats returning fixed values
+type fakeStats struct {
+	memory     uint64
+	goroutines int
+}
+
+func (f *fakeStats) MemoryBytes() uint64 {
+	return f.memory
+}
+
+func (f *fakeStats) Goroutines() int {
+	return f.goroutines
+}
+
+func TestHealthHandler_DetailedHealth_ProcessThresholdsExceeded(t *testing.T) {
+	handler, mockDB := setupHealthHandler()
+	handler.stats = &fakeStats{memory: 600 << 20, goroutines: 20000}
+	handler.SetProcessLimits(ProcessLimits{MaxMemoryBytes: 512 << 20, MaxGoroutines: 10000})
+
+	mockDB.On("Health").Return(nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/health/detailed", handler.DetailedHealth)
+
+	req, _ := http.NewRequest("GET", "/health/detailed", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Soft checks degrade the service without failing the request
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response HealthResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "degraded", response.Status)
+	assert.Contains(t, response.Checks["memory"], "unhealthy")
+	assert.Contains(t, response.Checks["goroutines"], "unhealthy")
+	assert.Equal(t, "healthy", response.Checks["database"])
+
+	mockDB.AssertExpectations(t)
+}
+
+func TestHealthHandler_DetailedHealth_ProcessWithinThresholds(t *testing.T) {
+	handler, mockDB := setupHealthHandler()
+	handler.stats = &fakeStats{memory: 100 << 20, goroutines: 50}
+	handler.SetProcessLimits(ProcessLimits{MaxMemoryBytes: 512 << 20, MaxGoroutines: 10000})
+
+	mockDB.On("Health").Return(nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/health/detailed", handler.DetailedHealth)
+
+	req, _ := http.NewRequest("GET", "/health/detailed", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response HealthResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "healthy", response.Status)
+	assert.Equal(t, "healthy", response.Checks["memory"])
+	assert.Equal(t, "healthy", response.Checks["goroutines"])
+}
+
+func TestHealthHandler_Readiness_ProcessThresholds(t *testing.T) {
+	tests := []struct {
+		name          string
+		failReadiness bool
+		expectedCode  int
+	}{
+		{name: "soft by default", failReadiness: false, expectedCode: http.StatusOK},
+		{name: "fails when configured", failReadiness: true, expectedCode: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mockDB := setupHealthHandler()
+			handler.stats = &fakeStats{goroutines: 20000}
+			handler.SetProcessLimits(ProcessLimits{MaxGoroutines: 10000, FailReadiness: tt.failReadiness})
+
+			mockDB.On("Health").Return(nil)
+
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.GET("/ready", handler.Readiness)
+
+			req, _ := http.NewRequest("GET", "/ready", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestHealthHandler_Readiness_LifecyclePhases(t *testing.T) {
+	tests := []struct {
+		phase        lifecycle.Phase
+		expectedCode int
+		expectedBody string
+	}{
+		{phase: lifecycle.PhaseStarting, expectedCode: http.StatusServiceUnavailable, expectedBody: "not ready"},
+		{phase: lifecycle.PhaseMigrating, expectedCode: http.StatusServiceUnavailable, expectedBody: "not ready"},
+		{phase: lifecycle.PhaseReady, expectedCode: http.StatusOK, expectedBody: "ready"},
+		{phase: lifecycle.PhaseDraining, expectedCode: http.StatusServiceUnavailable, expectedBody: "not ready"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.phase.String(), func(t *testing.T) {
+			handler, mockDB := setupHealthHandler()
+			phases := lifecycle.NewState()
+			phases.Set(tt.phase)
+			handler.SetLifecycle(phases)
+
+			mockDB.On("Health").Return(nil).Maybe()
+
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.GET("/ready", handler.Readiness)
+
+			req, _ := http.NewRequest("GET", "/ready", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			var response HealthResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.expectedBody, response.Status)
+			if tt.phase != lifecycle.PhaseReady {
+				assert.Equal(t, tt.phase.String(), response.Checks["phase"])
+			}
+		})
+	}
+}
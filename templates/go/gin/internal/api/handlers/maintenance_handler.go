@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+
+	"gin-service/internal/api/middleware"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// MaintenanceRequest switches maintenance mode on or off, optionally
+// replacing the message sent with 503 responses. An empty message restores
+// the default one.
+type MaintenanceRequest struct {
+	Enabled *bool   `json:"enabled" binding:"required"`
+	Message *string `json:"message,omitempty" binding:"omitempty,max=500"`
+}
+
+// MaintenanceResponse reports whether maintenance mode is on, and the
+// message sent with 503 responses
+type MaintenanceResponse struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+}
+
+// MaintenanceHandler handles the maintenance mode switch
+type MaintenanceHandler struct {
+	maintenance *middleware.Maintenance
+}
+
+// NewMaintenanceHandler creates a new maintenance handler
+func NewMaintenanceHandler(maintenance *middleware.Maintenance) *MaintenanceHandler {
+	return &MaintenanceHandler{maintenance: maintenance}
+}
+
+// GetMaintenance godoc
+// @Summary Get maintenance mode
+// @Description Report whether the service is in maintenance (admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} MaintenanceResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/maintenance [get]
+func (h *MaintenanceHandler) GetMaintenance(c *gin.Context) {
+	Respond(c, http.StatusOK, MaintenanceResponse{Enabled: h.maintenance.Enabled(), Message: h.maintenance.Message()})
+}
+
+// SetMaintenance godoc
+// @Summary Switch maintenance mode
+// @Description Turn maintenance mode on or off at runtime (admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param maintenance body MaintenanceRequest true "Maintenance mode"
+// @Success 200 {object} MaintenanceResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /admin/maintenance [put]
+func (h *MaintenanceHandler) SetMaintenance(c *gin.Context) {
+	var req MaintenanceRequest
+	if err := bindBody(c, &req); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	if req.Message != nil {
+		h.maintenance.SetMessage(*req.Message)
+	}
+	h.maintenance.SetEnabled(*req.Enabled)
+
+	middleware.Logger(c).Warn("Maintenance mode switched", zap.Bool("enabled", *req.Enabled), zap.String("message", h.maintenance.Message()))
+	Respond(c, http.StatusOK, MaintenanceResponse{Enabled: *req.Enabled, Message: h.maintenance.Message()})
+}
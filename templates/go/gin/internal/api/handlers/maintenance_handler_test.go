@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gin-service/internal/api/middleware"
+	"gin-service/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceHandler_SetMaintenance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	maintenance := middleware.NewMaintenance(config.MaintenanceConfig{})
+	handler := NewMaintenanceHandler(maintenance)
+
+	router := gin.New()
+	router.GET("/maintenance", handler.GetMaintenance)
+	router.PUT("/maintenance", handler.SetMaintenance)
+
+	req, _ := http.NewRequest("PUT", "/maintenance", bytes.NewBufferString(`{"enabled":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.True(t, maintenance.Enabled())
+
+	req, _ = http.NewRequest("GET", "/maintenance", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response MaintenanceResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.True(t, response.Enabled)
+	assert.Equal(t, "The service is down for maintenance. Please try again later.", response.Message)
+
+	// The message can be replaced along the way
+	req, _ = http.NewRequest("PUT", "/maintenance", bytes.NewBufferString(`{"enabled":true,"message":"Back at 14:00 UTC."}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "Back at 14:00 UTC.", response.Message)
+	assert.Equal(t, "Back at 14:00 UTC.", maintenance.Message())
+}
+
+func TestMaintenanceHandler_SetMaintenance_MissingEnabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	maintenance := middleware.NewMaintenance(config.MaintenanceConfig{Enabled: true})
+	handler := NewMaintenanceHandler(maintenance)
+
+	router := gin.New()
+	router.PUT("/maintenance", handler.SetMaintenance)
+
+	req, _ := http.NewRequest("PUT", "/maintenance", bytes.NewBufferString(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.True(t, maintenance.Enabled())
+}
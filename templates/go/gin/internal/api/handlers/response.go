@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"gin-service/internal/api/middleware"
+	"gin-service/internal/database"
+	"gin-service/internal/models"
+	"gin-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/ugorji/go/codec"
+	"go.uber.org/zap"
+)
+
+// ErrorResponse represents an error response
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	// Field names the request field at fault, for clients to highlight the
+	// matching form input
+	Field string `json:"field,omitempty"`
+	// RequestID is the ID echoed in the X-Request-ID header, for quoting in
+	// support requests
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// ErrorEnvelope is an error response wrapped in an envelope, mirroring the
+// Envelope of successful responses
+type ErrorEnvelope struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail is the error of an ErrorEnvelope, carrying what ErrorResponse
+// does with the code under "code"
+type ErrorDetail struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Field     string `json:"field,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// errorResponse returns the body of an error response, enveloped when the
+// request's responses are. The field is left out when empty.
+func errorResponse(c *gin.Context, code, message, field string) interface{} {
+	requestID := middleware.GetRequestID(c)
+	if middleware.IsEnveloped(c) {
+		return ErrorEnvelope{Error: ErrorDetail{Code: code, Message: message, Field: field, RequestID: requestID}}
+	}
+	return ErrorResponse{Error: code, Message: message, Field: field, RequestID: requestID}
+}
+
+// msgpackHandle encodes and decodes MessagePack to the current spec, with
+// str and bin types and times as timestamps. Maps decode with string keys so
+// that they convert to JSON.
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{WriteExt: true}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}()
+
+// msgpackContentType is the Content-Type of MessagePack responses
+const msgpackContentType = "application/msgpack"
+
+// isMsgpack reports whether contentType, without parameters, is MessagePack
+func isMsgpack(contentType string) bool {
+	return contentType == binding.MIMEMSGPACK || contentType == binding.MIMEMSGPACK2
+}
+
+// Envelope wraps successful responses, always under API v2 and otherwise
+// when asked for, with metadata such as pagination beside the data
+type Envelope struct {
+	Data interface{}            `json:"data"`
+	Meta map[string]interface{} `json:"meta,omitempty"`
+}
+
+// Respond writes data as a response with status, wrapped in an Envelope when
+// middleware.IsEnveloped says so. The pagination of a
+// database.PaginatedResponse then goes in the envelope's meta. Handlers thus
+// keep the bare responses of API v1 by default.
+func Respond(c *gin.Context, status int, data interface{}) {
+	if middleware.IsEnveloped(c) {
+		data = envelope(data)
+	}
+	render(c, status, data)
+}
+
+// RespondCreated writes data as a 201 response like Respond, with a Location
+// header pointing at the created resource
+func RespondCreated(c *gin.Context, location string, data interface{}) {
+	c.Header("Location", location)
+	Respond(c, http.StatusCreated, data)
+}
+
+// envelope wraps data in an Envelope
+func envelope(data interface{}) Envelope {
+	if page, ok := data.(database.PaginatedResponse); ok {
+		return Envelope{Data: page.Data, Meta: map[string]interface{}{"pagination": page.Pagination}}
+	}
+	return Envelope{Data: data}
+}
+
+// render writes data as a response with status, encoded as MessagePack when
+// the Accept header asks for it and as JSON otherwise. Fields are named by
+// their JSON tags in both.
+func render(c *gin.Context, status int, data interface{}) {
+	if !isMsgpack(c.NegotiateFormat(binding.MIMEJSON, binding.MIMEMSGPACK2, binding.MIMEMSGPACK)) {
+		c.JSON(status, data)
+		return
+	}
+
+	var body []byte
+	if err := codec.NewEncoderBytes(&body, msgpackHandle).Encode(data); err != nil {
+		middleware.Logger(c).Error("Failed to encode MessagePack response", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, errorResponse(c, "internal_error", "Failed to encode response", ""))
+		return
+	}
+	c.Data(status, msgpackContentType, body)
+}
+
+// RespondError writes an error response with the machine error code, the
+// message and the request ID, in an ErrorEnvelope when successful responses
+// are enveloped. The code is recorded on the request so the request log line
+// carries the same code the client sees.
+func RespondError(c *gin.Context, status int, code, message string) {
+	middleware.SetErrorCode(c, code)
+	render(c, status, errorResponse(c, code, message, ""))
+}
+
+// respondConflict writes the 409 Conflict response for a username or email
+// taken by another user, naming the field
+func respondConflict(c *gin.Context, conflict *services.ConflictError) {
+	middleware.SetErrorCode(c, "conflict")
+	render(c, http.StatusConflict, errorResponse(c, "conflict", conflict.Error(), conflict.Field))
+}
+
+// respondPasswordError writes the 400 validation error for a password the
+// service rejected under the password policy, reporting whether err was one
+func respondPasswordError(c *gin.Context, err error) bool {
+	var invalid *models.PasswordError
+	if !errors.As(err, &invalid) {
+		return false
+	}
+	RespondError(c, http.StatusBadRequest, "validation_error", invalid.Error())
+	return true
+}
+
+// RequestTooLarge writes the error response for a request body over the size limit
+func RequestTooLarge(c *gin.Context, maxSize int64) {
+	RespondError(c, http.StatusRequestEntityTooLarge, "request_too_large", fmt.Sprintf("Request body too large. Maximum size is %d bytes", maxSize))
+}
+
+// unknownFieldError reports a field of a strictly bound JSON body that the
+// request type does not declare
+type unknownFieldError struct {
+	field string
+}
+
+func (e *unknownFieldError) Error() string {
+	return fmt.Sprintf("unknown field %q", e.field)
+}
+
+// bindBody binds the request body into obj and validates it like bindJSON.
+// MessagePack bodies are converted to JSON first, so that they are bound by
+// the same rules, down to nullable fields and unknown fields on strict
+// routes.
+func bindBody(c *gin.Context, obj interface{}) error {
+	if c.Request.Body != nil && isMsgpack(c.ContentType()) {
+		// Read the body whole first, so that the size limit's error isn't
+		// wrapped by the decoder
+		data, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return err
+		}
+		var value interface{}
+		if err := codec.NewDecoderBytes(data, msgpackHandle).Decode(&value); err != nil {
+			return fmt.Errorf("invalid MessagePack body: %w", err)
+		}
+		body, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("invalid MessagePack body: %w", err)
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return bindJSON(c, obj)
+}
+
+// bindJSON binds the JSON body into obj and validates it like
+// c.ShouldBindJSON. On routes using middleware.StrictJSON, the body is
+// decoded as it is read and fields obj does not declare are rejected.
+func bindJSON(c *gin.Context, obj interface{}) error {
+	if !middleware.IsStrictJSON(c) {
+		return c.ShouldBindJSON(obj)
+	}
+	if c.Request.Body == nil {
+		return errors.New("invalid request")
+	}
+
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(obj); err != nil {
+		// The json package reports unknown fields only by message
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return &unknownFieldError{field: strings.Trim(field, `"`)}
+		}
+		return err
+	}
+	return binding.Validator.ValidateStruct(obj)
+}
+
+// respondBindingError writes the response for a request binding error.
+// Bodies cut off by the size limit yield 413 instead of a validation error.
+func respondBindingError(c *gin.Context, err error) {
+	if middleware.IsRequestTooLarge(err) {
+		if maxSize, ok := middleware.MaxSize(c); ok {
+			RequestTooLarge(c, maxSize)
+			return
+		}
+		RespondError(c, http.StatusRequestEntityTooLarge, "request_too_large", "Request body too large")
+		return
+	}
+	var unknownField *unknownFieldError
+	if errors.As(err, &unknownField) {
+		RespondError(c, http.StatusBadRequest, "unknown_field", fmt.Sprintf("Request body contains unknown field %q", unknownField.field))
+		return
+	}
+	RespondError(c, http.StatusBadRequest, "validation_error", err.Error())
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gin-service/internal/api/middleware"
+	"gin-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+)
+
+func TestRespondError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var code string
+	router := gin.New()
+	router.Use(middleware.RequestID())
+	router.GET("/fail", func(c *gin.Context) {
+		RespondError(c, http.StatusNotFound, "user_not_found", "User not found")
+		code, _ = middleware.GetErrorCode(c)
+	})
+
+	req, _ := http.NewRequest("GET", "/fail", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	var response ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, ErrorResponse{Error: "user_not_found", Message: "User not found", RequestID: "req-123"}, response)
+	assert.Equal(t, "user_not_found", code)
+}
+
+func TestRespondError_WithoutRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/fail", func(c *gin.Context) {
+		RespondError(c, http.StatusBadRequest, "validation_error", "invalid")
+	})
+
+	req, _ := http.NewRequest("GET", "/fail", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.JSONEq(t, `{"error":"validation_error","message":"invalid"}`, w.Body.String())
+}
+
+func TestRespond_NegotiatesFormat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	router := gin.New()
+	router.GET("/user", func(c *gin.Context) {
+		Respond(c, http.StatusOK, models.UserResponse{ID: 7, Username: "testuser", CreatedAt: createdAt})
+	})
+	get := func(accept string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/user", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// JSON unless MessagePack is asked for
+	for _, accept := range []string{"", "*/*", "application/json", "text/html"} {
+		w := get(accept)
+		assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"), accept)
+		assert.Contains(t, w.Body.String(), `"username":"testuser"`, accept)
+	}
+
+	for _, accept := range []string{"application/msgpack", "application/x-msgpack", "application/msgpack, application/json"} {
+		w := get(accept)
+		require.Equal(t, http.StatusOK, w.Code, accept)
+		assert.Equal(t, "application/msgpack", w.Header().Get("Content-Type"), accept)
+
+		// Fields are named by their JSON tags, and omitted alike
+		var response map[string]interface{}
+		require.NoError(t, codec.NewDecoderBytes(w.Body.Bytes(), msgpackHandle).Decode(&response), accept)
+		assert.EqualValues(t, 7, response["id"])
+		assert.Equal(t, "testuser", response["username"])
+		assert.Equal(t, createdAt, response["created_at"].(time.Time).UTC())
+		assert.NotContains(t, response, "full_name")
+	}
+}
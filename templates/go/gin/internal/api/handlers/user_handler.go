@@ -1,8 +1,16 @@
 package handlers
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"gin-service/internal/api/middleware"
 	"gin-service/internal/database"
@@ -10,22 +18,51 @@ import (
 	"gin-service/internal/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"go.uber.org/zap"
 )
 
 // UserHandler handles user-related HTTP requests
 type UserHandler struct {
-	userService services.UserServiceInterface
-	jwtService  middleware.JWTServiceInterface
-	logger      *zap.Logger
+	userService    services.UserServiceInterface
+	jwtService     middleware.JWTServiceInterface
+	logger         *zap.Logger
+	batchGetMaxIDs int
+	// batchDeleteMaxIDs is the most IDs a batch delete may ask for
+	batchDeleteMaxIDs int
 }
 
+// defaultBatchGetMaxIDs is the most IDs a batch get may ask for when no
+// limit is set
+const defaultBatchGetMaxIDs = 100
+
+// defaultBatchDeleteMaxIDs is the most IDs a batch delete may ask for when
+// no limit is set
+const defaultBatchDeleteMaxIDs = 100
+
 // NewUserHandler creates a new user handler
 func NewUserHandler(userService services.UserServiceInterface, jwtService middleware.JWTServiceInterface, logger *zap.Logger) *UserHandler {
 	return &UserHandler{
-		userService: userService,
-		jwtService:  jwtService,
-		logger:      logger,
+		userService:    userService,
+		jwtService:     jwtService,
+		logger:         logger,
+		batchGetMaxIDs: defaultBatchGetMaxIDs,
+
+		batchDeleteMaxIDs: defaultBatchDeleteMaxIDs,
+	}
+}
+
+// SetBatchGetMaxIDs sets the most IDs a batch get may ask for
+func (h *UserHandler) SetBatchGetMaxIDs(n int) {
+	if n > 0 {
+		h.batchGetMaxIDs = n
+	}
+}
+
+// SetBatchDeleteMaxIDs sets the most IDs a batch delete may ask for
+func (h *UserHandler) SetBatchDeleteMaxIDs(n int) {
+	if n > 0 {
+		h.batchDeleteMaxIDs = n
 	}
 }
 
@@ -37,37 +74,37 @@ func NewUserHandler(userService services.UserServiceInterface, jwtService middle
 // @Produce json
 // @Param user body models.CreateUserRequest true "User registration data"
 // @Success 201 {object} models.UserResponse
+// @Header 201 {string} Location "Path of the created user"
 // @Failure 400 {object} ErrorResponse
 // @Failure 409 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /auth/register [post]
 func (h *UserHandler) Register(c *gin.Context) {
 	var req models.CreateUserRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		h.logger.Warn("Invalid registration request", zap.Error(err))
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "validation_error",
-			Message: err.Error(),
-		})
+	if err := bindBody(c, &req); err != nil {
+		middleware.Logger(c).Warn("Invalid registration request", zap.Error(err))
+		respondBindingError(c, err)
 		return
 	}
 
-	user, err := h.userService.Create(&req)
+	user, err := h.users(c).Create(&req)
 	if err != nil {
-		h.logger.Error("Failed to create user", zap.Error(err))
-		status := http.StatusInternalServerError
-		if err.Error() == "username already exists" || err.Error() == "email already exists" {
-			status = http.StatusConflict
+		var conflict *services.ConflictError
+		if errors.As(err, &conflict) {
+			middleware.Logger(c).Warn("Registration conflicts with an existing user", zap.String("field", conflict.Field))
+			respondConflict(c, conflict)
+			return
 		}
-		c.JSON(status, ErrorResponse{
-			Error:   "registration_failed",
-			Message: err.Error(),
-		})
+		if respondPasswordError(c, err) {
+			return
+		}
+		middleware.Logger(c).Error("Failed to create user", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "registration_failed", err.Error())
 		return
 	}
 
-	h.logger.Info("User registered successfully", zap.Int("user_id", user.ID))
-	c.JSON(http.StatusCreated, user.ToResponse())
+	middleware.Logger(c).Info("User registered successfully", zap.Int("user_id", user.ID))
+	RespondCreated(c, fmt.Sprintf("/api/v1/users/%d", user.ID), user.ToResponse())
 }
 
 // Login godoc
@@ -80,377 +117,1445 @@ func (h *UserHandler) Register(c *gin.Context) {
 // @Success 200 {object} models.LoginResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} models.DeletionPendingResponse
+// @Failure 429 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /auth/login [post]
 func (h *UserHandler) Login(c *gin.Context) {
 	var req models.LoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		h.logger.Warn("Invalid login request", zap.Error(err))
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "validation_error",
-			Message: err.Error(),
-		})
+	if err := bindBody(c, &req); err != nil {
+		middleware.Logger(c).Warn("Invalid login request", zap.Error(err))
+		respondBindingError(c, err)
+		return
+	}
+
+	identifier, identifierType := req.LoginIdentifier()
+	user, err := h.users(c).Authenticate(identifier, identifierType, req.Password)
+	if err != nil {
+		var throttled *services.LoginThrottledError
+		if errors.As(err, &throttled) {
+			respondLoginThrottled(c, throttled)
+			return
+		}
+
+		var pending *services.DeletionPendingError
+		if errors.As(err, &pending) {
+			// The second factor is still required before the account's
+			// deletion can be cancelled
+			if pending.User.TOTPEnabled {
+				h.issueChallenge(c, pending.User)
+				return
+			}
+			h.respondDeletionPending(c, pending.User)
+			return
+		}
+
+		middleware.Logger(c).Warn("Authentication failed", zap.Error(err), zap.String("identifier", identifier))
+		switch {
+		case errors.Is(err, services.ErrAccountSuspended):
+			RespondError(c, http.StatusForbidden, "account_suspended", "User account is suspended")
+		case errors.Is(err, services.ErrAccountInactive):
+			RespondError(c, http.StatusForbidden, "account_inactive", "User account is inactive")
+		default:
+			RespondError(c, http.StatusUnauthorized, "authentication_failed", "Invalid credentials")
+		}
 		return
 	}
 
-	user, err := h.userService.Authenticate(req.Username, req.Password)
+	if user.TOTPEnabled {
+		h.issueChallenge(c, user)
+		return
+	}
+
+	h.completeLogin(c, user)
+}
+
+// respondLoginThrottled writes the 429 Too Many Requests response for a login
+// attempt rejected by the login throttle
+func respondLoginThrottled(c *gin.Context, throttled *services.LoginThrottledError) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+	RespondError(c, http.StatusTooManyRequests, "too_many_login_attempts", "Too many login attempts. Please try again later.")
+}
+
+// issueChallenge responds to a correct password from a user with 2FA
+// enabled with a challenge token, to be exchanged at VerifyTwoFactor
+func (h *UserHandler) issueChallenge(c *gin.Context, user *models.User) {
+	token, err := h.jwtService.GenerateChallengeToken(user)
 	if err != nil {
-		h.logger.Warn("Authentication failed", zap.Error(err), zap.String("username", req.Username))
-		c.JSON(http.StatusUnauthorized, ErrorResponse{
-			Error:   "authentication_failed",
-			Message: "Invalid credentials",
-		})
+		middleware.Logger(c).Error("Failed to generate challenge token", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "token_generation_failed", "Failed to generate authentication token")
 		return
 	}
 
+	middleware.Logger(c).Info("Two-factor challenge issued", zap.Int("target_user_id", user.ID))
+	Respond(c, http.StatusOK, models.TwoFactorChallengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresIn:         int(h.jwtService.ChallengeTTL().Seconds()),
+	})
+}
+
+// completeLogin responds with an access token for a fully authenticated user
+func (h *UserHandler) completeLogin(c *gin.Context, user *models.User) {
 	token, err := h.jwtService.GenerateToken(user)
 	if err != nil {
-		h.logger.Error("Failed to generate token", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error:   "token_generation_failed",
-			Message: "Failed to generate authentication token",
-		})
+		middleware.Logger(c).Error("Failed to generate token", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "token_generation_failed", "Failed to generate authentication token")
 		return
 	}
 
-	h.logger.Info("User logged in successfully", zap.Int("user_id", user.ID))
-	c.JSON(http.StatusOK, models.LoginResponse{
+	middleware.Logger(c).Info("User logged in successfully", zap.Int("user_id", user.ID))
+	Respond(c, http.StatusOK, models.LoginResponse{
 		User:  user.ToResponse(),
 		Token: token,
 	})
 }
 
+// respondDeletionPending refuses the login of a user whose account is
+// pending deletion, handing out a token only good for cancelling it
+func (h *UserHandler) respondDeletionPending(c *gin.Context, user *models.User) {
+	token, err := h.jwtService.GenerateCancelDeletionToken(user)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to generate cancel deletion token", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "token_generation_failed", "Failed to generate authentication token")
+		return
+	}
+
+	middleware.Logger(c).Info("Login refused for account pending deletion", zap.Int("target_user_id", user.ID))
+	middleware.SetErrorCode(c, "account_deletion_scheduled")
+	Respond(c, http.StatusForbidden, models.DeletionPendingResponse{
+		Error:               "account_deletion_scheduled",
+		Message:             "User account is scheduled for deletion, cancel it with the cancel token to log in",
+		DeletionScheduledAt: *user.DeletionScheduledAt,
+		CancelToken:         token,
+		ExpiresIn:           int(h.jwtService.ChallengeTTL().Seconds()),
+	})
+}
+
+// VerifyTwoFactor godoc
+// @Summary Complete a two-factor login
+// @Description Exchange the challenge token from login and a TOTP or recovery code for a JWT token
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param verification body models.TwoFactorVerifyRequest true "Challenge token and code"
+// @Success 200 {object} models.LoginResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} models.DeletionPendingResponse
+// @Failure 429 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /auth/2fa/verify [post]
+func (h *UserHandler) VerifyTwoFactor(c *gin.Context) {
+	var req models.TwoFactorVerifyRequest
+	if err := bindBody(c, &req); err != nil {
+		middleware.Logger(c).Warn("Invalid two-factor verification request", zap.Error(err))
+		respondBindingError(c, err)
+		return
+	}
+
+	claims, err := h.jwtService.ValidateChallengeToken(req.ChallengeToken)
+	if err != nil || claims.ID == "" {
+		RespondError(c, http.StatusUnauthorized, "invalid_challenge", "Invalid or expired challenge token")
+		return
+	}
+
+	user, err := h.users(c).VerifyTwoFactor(claims.UserID, claims.ID, req.Code)
+	if err != nil {
+		var throttled *services.LoginThrottledError
+		var pending *services.DeletionPendingError
+		switch {
+		case errors.As(err, &pending):
+			h.respondDeletionPending(c, pending.User)
+		case errors.Is(err, services.ErrInvalidTwoFactorCode):
+			middleware.Logger(c).Warn("Two-factor verification failed", zap.Int("target_user_id", claims.UserID))
+			RespondError(c, http.StatusUnauthorized, "invalid_two_factor_code", "Invalid two-factor code")
+		case errors.Is(err, services.ErrTwoFactorChallengeUsed):
+			RespondError(c, http.StatusUnauthorized, "invalid_challenge", "Challenge token already used, log in again")
+		case errors.As(err, &throttled):
+			respondLoginThrottled(c, throttled)
+		default:
+			respondTwoFactorError(c, err)
+		}
+		return
+	}
+
+	h.completeLogin(c, user)
+}
+
+// WhoAmI godoc
+// @Summary Inspect the current token
+// @Description Return the claims the server decoded from the access token, with its remaining validity
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.WhoAmIResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /auth/whoami [get]
+func (h *UserHandler) WhoAmI(c *gin.Context) {
+	claims, exists := middleware.GetClaims(c)
+	if !exists {
+		RespondError(c, http.StatusUnauthorized, "unauthorized", "User not authenticated")
+		return
+	}
+
+	response := models.WhoAmIResponse{
+		UserID:         claims.UserID,
+		Username:       claims.Username,
+		Email:          claims.Email,
+		IsAdmin:        claims.IsAdmin,
+		ImpersonatedBy: claims.ImpersonatedBy,
+		Issuer:         claims.Issuer,
+		Audience:       claims.Audience,
+	}
+	if claims.IssuedAt != nil {
+		response.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		response.ExpiresAt = claims.ExpiresAt.Time
+		response.ExpiresIn = max(int(time.Until(claims.ExpiresAt.Time).Seconds()), 0)
+	}
+
+	Respond(c, http.StatusOK, response)
+}
+
 // GetProfile godoc
 // @Summary Get current user profile
 // @Description Get the profile of the currently authenticated user
 // @Tags users
 // @Produce json
 // @Security BearerAuth
+// @Param fields query string false "Comma-separated fields to return, e.g. id,username"
 // @Success 200 {object} models.UserResponse
+// @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /users/profile [get]
 func (h *UserHandler) GetProfile(c *gin.Context) {
 	userID, exists := middleware.GetUserID(c)
 	if !exists {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{
-			Error:   "unauthorized",
-			Message: "User not authenticated",
-		})
+		RespondError(c, http.StatusUnauthorized, "unauthorized", "User not authenticated")
+		return
+	}
+	fields, ok := parseUserFields(c)
+	if !ok {
 		return
 	}
 
-	user, err := h.userService.GetByID(userID)
+	user, err := h.users(c).GetByID(userID)
 	if err != nil {
-		h.logger.Error("Failed to get user profile", zap.Error(err), zap.Int("user_id", userID))
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to retrieve user profile",
-		})
+		middleware.Logger(c).Error("Failed to get user profile", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to retrieve user profile")
 		return
 	}
 
 	if user == nil {
-		c.JSON(http.StatusNotFound, ErrorResponse{
-			Error:   "user_not_found",
-			Message: "User not found",
-		})
+		RespondError(c, http.StatusNotFound, "user_not_found", "User not found")
 		return
 	}
 
-	c.JSON(http.StatusOK, user.ToResponse())
+	respondFields(c, http.StatusOK, user.ToResponse(), fields)
 }
 
-// UpdateProfile godoc
-// @Summary Update current user profile
-// @Description Update the profile of the currently authenticated user
+// ExportProfile godoc
+// @Summary Export current user's data
+// @Description Download everything stored about the currently authenticated user, without credentials, as a JSON file
 // @Tags users
-// @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Param user body models.UpdateUserRequest true "User update data"
-// @Success 200 {object} models.UserResponse
-// @Failure 400 {object} ErrorResponse
+// @Success 200 {object} models.UserDataBundle
 // @Failure 401 {object} ErrorResponse
-// @Failure 409 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
-// @Router /users/profile [put]
-func (h *UserHandler) UpdateProfile(c *gin.Context) {
+// @Router /users/profile/export [get]
+func (h *UserHandler) ExportProfile(c *gin.Context) {
 	userID, exists := middleware.GetUserID(c)
 	if !exists {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{
-			Error:   "unauthorized",
-			Message: "User not authenticated",
-		})
+		RespondError(c, http.StatusUnauthorized, "unauthorized", "User not authenticated")
 		return
 	}
 
-	var req models.UpdateUserRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		h.logger.Warn("Invalid update request", zap.Error(err))
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "validation_error",
-			Message: err.Error(),
-		})
+	h.exportUserData(c, userID)
+}
+
+// ExportUser godoc
+// @Summary Export a user's data
+// @Description Download everything stored about a user, without credentials, as a JSON file (admin only)
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} models.UserDataBundle
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/export [get]
+func (h *UserHandler) ExportUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, "invalid_user_id", "Invalid user ID format")
 		return
 	}
 
-	user, err := h.userService.Update(userID, &req)
+	h.exportUserData(c, userID)
+}
+
+// exportUserData responds with the user's data bundle as a file download
+func (h *UserHandler) exportUserData(c *gin.Context, userID int) {
+	bundle, err := h.users(c).ExportUserData(userID)
 	if err != nil {
-		h.logger.Error("Failed to update user", zap.Error(err), zap.Int("user_id", userID))
-		status := http.StatusInternalServerError
-		if err.Error() == "username already exists" || err.Error() == "email already exists" {
-			status = http.StatusConflict
+		if errors.Is(err, services.ErrUserNotFound) {
+			RespondError(c, http.StatusNotFound, "user_not_found", "User not found")
+			return
 		}
-		c.JSON(status, ErrorResponse{
-			Error:   "update_failed",
-			Message: err.Error(),
-		})
+		middleware.Logger(c).Error("Failed to export user data", zap.Error(err), zap.Int("target_user_id", userID))
+		RespondError(c, http.StatusInternalServerError, "export_failed", "Failed to export user data")
 		return
 	}
 
-	h.logger.Info("User profile updated", zap.Int("user_id", userID))
-	c.JSON(http.StatusOK, user.ToResponse())
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.json"`, userID))
+	Respond(c, http.StatusOK, bundle)
 }
 
-// ListUsers godoc
-// @Summary List users
-// @Description Get a paginated list of users (admin only)
+// UpdateProfile godoc
+// @Summary Replace current user profile
+// @Description Replace the profile of the currently authenticated user. All fields but the password are required; an omitted full name is cleared.
 // @Tags users
+// @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Param page query int false "Page number" default(1)
-// @Param limit query int false "Items per page" default(10)
-// @Param username query string false "Filter by username"
-// @Param email query string false "Filter by email"
-// @Param is_active query bool false "Filter by active status"
-// @Param is_admin query bool false "Filter by admin status"
-// @Param search query string false "Search in username, email, and full name"
-// @Success 200 {object} database.PaginatedResponse
+// @Param user body models.ReplaceProfileRequest true "User data"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
-// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
-// @Router /users [get]
-func (h *UserHandler) ListUsers(c *gin.Context) {
-	// Parse pagination parameters
-	pagination := &database.Paginate{
-		Page:  1,
-		Limit: 10,
-	}
-
-	if page, err := strconv.Atoi(c.DefaultQuery("page", "1")); err == nil && page > 0 {
-		pagination.Page = page
+// @Router /users/profile [put]
+func (h *UserHandler) UpdateProfile(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		RespondError(c, http.StatusUnauthorized, "unauthorized", "User not authenticated")
+		return
 	}
 
-	if limit, err := strconv.Atoi(c.DefaultQuery("limit", "10")); err == nil && limit > 0 {
-		pagination.Limit = limit
+	var req models.ReplaceProfileRequest
+	if err := bindBody(c, &req); err != nil {
+		middleware.Logger(c).Warn("Invalid update request", zap.Error(err))
+		respondBindingError(c, err)
+		return
 	}
 
-	// Parse filter parameters
-	filter := &models.UserFilter{}
+	h.updateProfile(c, userID, req.UpdateRequest())
+}
 
-	if username := c.Query("username"); username != "" {
-		filter.Username = &username
+// PatchProfile godoc
+// @Summary Update current user profile
+// @Description Update the given fields of the profile of the currently authenticated user
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param user body models.UpdateProfileRequest true "User update data"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/profile [patch]
+func (h *UserHandler) PatchProfile(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		RespondError(c, http.StatusUnauthorized, "unauthorized", "User not authenticated")
+		return
 	}
 
-	if email := c.Query("email"); email != "" {
-		filter.Email = &email
+	var req models.UpdateProfileRequest
+	if err := bindBody(c, &req); err != nil {
+		middleware.Logger(c).Warn("Invalid update request", zap.Error(err))
+		respondBindingError(c, err)
+		return
 	}
 
-	if isActiveStr := c.Query("is_active"); isActiveStr != "" {
-		if isActive, err := strconv.ParseBool(isActiveStr); err == nil {
-			filter.IsActive = &isActive
-		}
-	}
+	h.updateProfile(c, userID, req.UpdateRequest())
+}
 
-	if isAdminStr := c.Query("is_admin"); isAdminStr != "" {
-		if isAdmin, err := strconv.ParseBool(isAdminStr); err == nil {
-			filter.IsAdmin = &isAdmin
+// updateProfile applies an update to the current user's profile and
+// responds with the result
+func (h *UserHandler) updateProfile(c *gin.Context, userID int, req *models.UpdateUserRequest) {
+	user, err := h.users(c).Update(userID, req)
+	if err != nil {
+		var conflict *services.ConflictError
+		if errors.As(err, &conflict) {
+			middleware.Logger(c).Warn("Profile update conflicts with an existing user", zap.String("field", conflict.Field))
+			respondConflict(c, conflict)
+			return
 		}
+		if respondPasswordError(c, err) {
+			return
+		}
+		middleware.Logger(c).Error("Failed to update user", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "update_failed", err.Error())
+		return
 	}
 
-	if search := c.Query("search"); search != "" {
-		filter.Search = &search
+	middleware.Logger(c).Info("User profile updated")
+	Respond(c, http.StatusOK, user.ToResponse())
+}
+
+// ConfirmEmail godoc
+// @Summary Confirm email change
+// @Description Replace the current user's email with the pending one, using the token sent to the new address
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param token query string true "Confirmation token"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 410 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/me/confirm-email [get]
+func (h *UserHandler) ConfirmEmail(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		RespondError(c, http.StatusUnauthorized, "unauthorized", "User not authenticated")
+		return
 	}
 
-	users, err := h.userService.List(filter, pagination)
-	if err != nil {
-		h.logger.Error("Failed to list users", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to retrieve users",
-		})
+	token := c.Query("token")
+	if token == "" {
+		RespondError(c, http.StatusBadRequest, "invalid_request", "Missing token")
 		return
 	}
 
-	// Convert to response format
-	userResponses := make([]*models.UserResponse, len(users))
-	for i, user := range users {
-		userResponses[i] = user.ToResponse()
+	user, err := h.users(c).ConfirmEmail(userID, token)
+	if err != nil {
+		var conflict *services.ConflictError
+		switch {
+		case errors.Is(err, services.ErrInvalidEmailChangeToken):
+			RespondError(c, http.StatusBadRequest, "invalid_token", "Invalid email confirmation token")
+		case errors.Is(err, services.ErrEmailChangeExpired):
+			RespondError(c, http.StatusGone, "token_expired", "Email confirmation token has expired")
+		case errors.As(err, &conflict):
+			respondConflict(c, conflict)
+		case errors.Is(err, services.ErrUserNotFound):
+			RespondError(c, http.StatusNotFound, "user_not_found", "User not found")
+		default:
+			middleware.Logger(c).Error("Failed to confirm email change", zap.Error(err))
+			RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to confirm email change")
+		}
+		return
 	}
 
-	c.JSON(http.StatusOK, database.PaginatedResponse{
-		Data:       userResponses,
-		Pagination: pagination,
-	})
+	middleware.Logger(c).Info("Email change confirmed")
+	Respond(c, http.StatusOK, user.ToResponse())
 }
 
-// GetUser godoc
-// @Summary Get user by ID
-// @Description Get a user by their ID (admin only)
+// DeleteAccount godoc
+// @Summary Request account deletion
+// @Description Deactivate the current user's account and delete it after a grace period, during which the deletion can be cancelled
 // @Tags users
 // @Produce json
 // @Security BearerAuth
-// @Param id path int true "User ID"
-// @Success 200 {object} models.UserResponse
-// @Failure 400 {object} ErrorResponse
+// @Success 202 {object} models.UserResponse
 // @Failure 401 {object} ErrorResponse
 // @Failure 403 {object} ErrorResponse
-// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
-// @Router /users/{id} [get]
-func (h *UserHandler) GetUser(c *gin.Context) {
-	userID, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "invalid_user_id",
-			Message: "Invalid user ID format",
-		})
+// @Router /users/me [delete]
+func (h *UserHandler) DeleteAccount(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		RespondError(c, http.StatusUnauthorized, "unauthorized", "User not authenticated")
 		return
 	}
 
-	user, err := h.userService.GetByID(userID)
+	user, err := h.users(c).ScheduleDeletion(userID)
 	if err != nil {
-		h.logger.Error("Failed to get user", zap.Error(err), zap.Int("user_id", userID))
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to retrieve user",
-		})
+		switch {
+		case errors.Is(err, services.ErrAccountInactive), errors.Is(err, services.ErrAccountSuspended):
+			RespondError(c, http.StatusConflict, "account_inactive", "Inactive accounts cannot request deletion")
+		case errors.Is(err, services.ErrUserNotFound):
+			RespondError(c, http.StatusNotFound, "user_not_found", "User not found")
+		default:
+			middleware.Logger(c).Error("Failed to schedule account deletion", zap.Error(err))
+			RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to schedule account deletion")
+		}
 		return
 	}
 
-	if user == nil {
-		c.JSON(http.StatusNotFound, ErrorResponse{
-			Error:   "user_not_found",
-			Message: "User not found",
-		})
+	middleware.Logger(c).Info("Account deletion requested")
+	Respond(c, http.StatusAccepted, user.ToResponse())
+}
+
+// AnonymizeAccount godoc
+// @Summary Anonymize current user's account
+// @Description Scrub the personal data of the currently authenticated user at once, keeping the account's records. The account can no longer log in.
+// @Tags users
+// @Security BearerAuth
+// @Success 204
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/me/anonymize [post]
+func (h *UserHandler) AnonymizeAccount(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		RespondError(c, http.StatusUnauthorized, "unauthorized", "User not authenticated")
 		return
 	}
 
-	c.JSON(http.StatusOK, user.ToResponse())
+	h.anonymize(c, userID, userID)
 }
 
-// UpdateUser godoc
-// @Summary Update user by ID
-// @Description Update a user by their ID (admin only)
+// AnonymizeUser godoc
+// @Summary Anonymize user by ID
+// @Description Scrub the personal data of a user, keeping the account's records (admin only). The account can no longer log in.
 // @Tags users
-// @Accept json
-// @Produce json
 // @Security BearerAuth
 // @Param id path int true "User ID"
-// @Param user body models.UpdateUserRequest true "User update data"
-// @Success 200 {object} models.UserResponse
+// @Success 204
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
 // @Failure 403 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
 // @Failure 409 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
-// @Router /users/{id} [put]
-func (h *UserHandler) UpdateUser(c *gin.Context) {
+// @Router /users/{id}/anonymize [post]
+func (h *UserHandler) AnonymizeUser(c *gin.Context) {
 	userID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "invalid_user_id",
-			Message: "Invalid user ID format",
-		})
+		RespondError(c, http.StatusBadRequest, "invalid_user_id", "Invalid user ID format")
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	h.anonymize(c, userID, adminID)
+}
+
+// anonymize anonymizes the user on behalf of actorID and responds with the
+// outcome
+func (h *UserHandler) anonymize(c *gin.Context, userID, actorID int) {
+	if err := h.users(c).Anonymize(userID, actorID); err != nil {
+		switch {
+		case errors.Is(err, services.ErrLastAdmin):
+			RespondError(c, http.StatusConflict, "last_admin", "Cannot anonymize the last admin")
+		case errors.Is(err, services.ErrUserNotFound):
+			RespondError(c, http.StatusNotFound, "user_not_found", "User not found")
+		default:
+			middleware.Logger(c).Error("Failed to anonymize user", zap.Error(err), zap.Int("target_user_id", userID))
+			RespondError(c, http.StatusInternalServerError, "anonymization_failed", "Failed to anonymize user")
+		}
 		return
 	}
 
-	var req models.UpdateUserRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		h.logger.Warn("Invalid update request", zap.Error(err))
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "validation_error",
-			Message: err.Error(),
-		})
+	middleware.Logger(c).Info("User anonymized", zap.Int("target_user_id", userID))
+	c.Status(http.StatusNoContent)
+}
+
+// CancelAccountDeletion godoc
+// @Summary Cancel account deletion
+// @Description Cancel the scheduled deletion of the current user's account and reactivate it. Takes an access token or the cancel token from login.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.UserResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/me/cancel-deletion [post]
+func (h *UserHandler) CancelAccountDeletion(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		RespondError(c, http.StatusUnauthorized, "unauthorized", "User not authenticated")
 		return
 	}
 
-	user, err := h.userService.Update(userID, &req)
+	user, err := h.users(c).CancelDeletion(userID)
 	if err != nil {
-		h.logger.Error("Failed to update user", zap.Error(err), zap.Int("user_id", userID))
-		status := http.StatusInternalServerError
-		if err.Error() == "user not found" {
-			status = http.StatusNotFound
-		} else if err.Error() == "username already exists" || err.Error() == "email already exists" {
-			status = http.StatusConflict
+		switch {
+		case errors.Is(err, services.ErrDeletionNotScheduled):
+			RespondError(c, http.StatusConflict, "deletion_not_scheduled", "Account deletion is not scheduled")
+		case errors.Is(err, services.ErrUserNotFound):
+			RespondError(c, http.StatusNotFound, "user_not_found", "User not found")
+		default:
+			middleware.Logger(c).Error("Failed to cancel account deletion", zap.Error(err))
+			RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to cancel account deletion")
 		}
-		c.JSON(status, ErrorResponse{
-			Error:   "update_failed",
-			Message: err.Error(),
-		})
 		return
 	}
 
-	h.logger.Info("User updated by admin", zap.Int("user_id", userID))
-	c.JSON(http.StatusOK, user.ToResponse())
+	middleware.Logger(c).Info("Account deletion cancelled")
+	Respond(c, http.StatusOK, user.ToResponse())
 }
 
-// DeleteUser godoc
-// @Summary Delete user by ID
-// @Description Delete a user by their ID (admin only)
+// UploadAvatar godoc
+// @Summary Upload current user avatar
+// @Description Upload a PNG, JPEG or GIF avatar for the currently authenticated user
 // @Tags users
+// @Accept multipart/form-data
 // @Produce json
 // @Security BearerAuth
-// @Param id path int true "User ID"
-// @Success 204 "No Content"
+// @Param avatar formData file true "Avatar image"
+// @Success 200 {object} models.UserResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
-// @Failure 403 {object} ErrorResponse
-// @Failure 404 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
-// @Router /users/{id} [delete]
-func (h *UserHandler) DeleteUser(c *gin.Context) {
-	userID, err := strconv.Atoi(c.Param("id"))
+// @Router /users/me/avatar [post]
+func (h *UserHandler) UploadAvatar(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		RespondError(c, http.StatusUnauthorized, "unauthorized", "User not authenticated")
+		return
+	}
+
+	file, err := c.FormFile("avatar")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "invalid_user_id",
-			Message: "Invalid user ID format",
-		})
+		if middleware.IsRequestTooLarge(err) {
+			respondBindingError(c, err)
+			return
+		}
+		RespondError(c, http.StatusBadRequest, "invalid_avatar", "An avatar image is required in the \"avatar\" form field")
 		return
 	}
 
-	// Prevent self-deletion
-	currentUserID, _ := middleware.GetUserID(c)
-	if currentUserID == userID {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error:   "self_deletion_not_allowed",
-			Message: "Cannot delete your own account",
-		})
+	data, err := readFormFile(file)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to read avatar upload", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to read avatar")
 		return
 	}
 
-	err = h.userService.Delete(userID)
+	user, err := h.users(c).SetAvatar(c.Request.Context(), userID, data)
 	if err != nil {
-		h.logger.Error("Failed to delete user", zap.Error(err), zap.Int("user_id", userID))
-		status := http.StatusInternalServerError
-		if err.Error() == "user not found" {
-			status = http.StatusNotFound
+		var avatarErr *services.AvatarError
+		switch {
+		case errors.As(err, &avatarErr):
+			RespondError(c, http.StatusBadRequest, "invalid_avatar", avatarErr.Message)
+		case errors.Is(err, services.ErrUserNotFound):
+			RespondError(c, http.StatusNotFound, "not_found", "User not found")
+		default:
+			middleware.Logger(c).Error("Failed to update avatar", zap.Error(err))
+			RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to update avatar")
 		}
-		c.JSON(status, ErrorResponse{
-			Error:   "deletion_failed",
-			Message: err.Error(),
-		})
 		return
 	}
 
-	h.logger.Info("User deleted by admin", zap.Int("user_id", userID))
-	c.Status(http.StatusNoContent)
+	middleware.Logger(c).Info("User avatar uploaded")
+	Respond(c, http.StatusOK, user.ToResponse())
 }
 
-// ErrorResponse represents an error response
-type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message"`
+// SetupTwoFactor godoc
+// @Summary Start two-factor setup
+// @Description Generate a TOTP secret for the current user, returned with an otpauth URL and QR code to add to an authenticator app
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.TwoFactorSetupResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/me/2fa/setup [post]
+func (h *UserHandler) SetupTwoFactor(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		RespondError(c, http.StatusUnauthorized, "unauthorized", "User not authenticated")
+		return
+	}
+
+	setup, err := h.users(c).SetupTwoFactor(userID)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	Respond(c, http.StatusOK, models.TwoFactorSetupResponse{
+		Secret:     setup.Secret,
+		OTPAuthURL: setup.OTPAuthURL,
+		QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(setup.QRCode),
+	})
+}
+
+// EnableTwoFactor godoc
+// @Summary Enable two-factor authentication
+// @Description Confirm two-factor setup with a code from the authenticator app. The response carries one-time recovery codes, shown only once.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param code body models.TwoFactorEnableRequest true "TOTP code"
+// @Success 200 {object} models.TwoFactorEnableResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/me/2fa/enable [post]
+func (h *UserHandler) EnableTwoFactor(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		RespondError(c, http.StatusUnauthorized, "unauthorized", "User not authenticated")
+		return
+	}
+
+	var req models.TwoFactorEnableRequest
+	if err := bindBody(c, &req); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	user, codes, err := h.users(c).EnableTwoFactor(userID, req.Code)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidTwoFactorCode) {
+			RespondError(c, http.StatusBadRequest, "invalid_two_factor_code", "Invalid two-factor code")
+			return
+		}
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	middleware.Logger(c).Info("Two-factor authentication enabled")
+	Respond(c, http.StatusOK, models.TwoFactorEnableResponse{
+		User:          user.ToResponse(),
+		RecoveryCodes: codes,
+	})
+}
+
+// respondTwoFactorError maps a two-factor service error to a response
+func respondTwoFactorError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrTwoFactorDisabled):
+		RespondError(c, http.StatusNotImplemented, "two_factor_disabled", err.Error())
+	case errors.Is(err, services.ErrTwoFactorAlreadyEnabled):
+		RespondError(c, http.StatusConflict, "two_factor_already_enabled", err.Error())
+	case errors.Is(err, services.ErrTwoFactorNotSetUp):
+		RespondError(c, http.StatusBadRequest, "two_factor_not_set_up", err.Error())
+	case errors.Is(err, services.ErrTwoFactorNotEnabled), errors.Is(err, services.ErrInvalidCredentials):
+		RespondError(c, http.StatusUnauthorized, "authentication_failed", "Invalid credentials")
+	case errors.Is(err, services.ErrUserNotFound):
+		RespondError(c, http.StatusNotFound, "user_not_found", "User not found")
+	default:
+		middleware.Logger(c).Error("Two-factor operation failed", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "internal_error", "Two-factor operation failed")
+	}
+}
+
+// readFormFile reads an uploaded file into memory
+func readFormFile(file *multipart.FileHeader) ([]byte, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// users returns the user service logging with the request-scoped logger and
+// running its queries under the request context, so they stop when the
+// request times out. Under an impersonation token, the service attributes
+// audit records to the impersonating admin.
+func (h *UserHandler) users(c *gin.Context) services.UserServiceInterface {
+	ctx := services.ContextWithClientIP(c.Request.Context(), c.ClientIP())
+	if adminID, ok := middleware.GetImpersonatedBy(c); ok {
+		ctx = services.ContextWithImpersonator(ctx, adminID)
+	}
+	return h.userService.WithLogger(middleware.Logger(c)).WithContext(ctx)
+}
+
+// ListUsers godoc
+// @Summary List users
+// @Description Get a paginated list of users (admin only)
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(10)
+// @Param username query string false "Filter by username"
+// @Param email query string false "Filter by email"
+// @Param is_active query bool false "Filter by active status"
+// @Param is_admin query bool false "Filter by admin status"
+// @Param search query string false "Search in username, email, and full name"
+// @Param created_at query string false "Filter by creation time, e.g. gte:2024-01-01"
+// @Param created_after query string false "Only users created at or after this RFC 3339 time"
+// @Param created_before query string false "Only users created at or before this RFC 3339 time"
+// @Param last_login_before query string false "Only users who haven't logged in since this RFC 3339 time, including those who never did"
+// @Param inactive_days query int false "Only users who haven't logged in for this many days, including those who never did"
+// @Param fields query string false "Comma-separated fields to return for each user, e.g. id,username"
+// @Success 200 {object} database.PaginatedResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users [get]
+func (h *UserHandler) ListUsers(c *gin.Context) {
+	var query models.ListUsersQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	// Parse pagination parameters; limits over the configured maximum are
+	// capped by the query
+	defaultLimit, _ := database.PaginationLimits()
+	pagination := &database.Paginate{
+		Page:  1,
+		Limit: defaultLimit,
+	}
+	if query.Page != nil {
+		pagination.Page = *query.Page
+	}
+	if query.Limit != nil {
+		pagination.Limit = *query.Limit
+	}
+
+	// Parse filter parameters
+	filter, err := parseUserFilter(c, query)
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, "invalid_filter", err.Error())
+		return
+	}
+	fields, ok := parseUserFields(c)
+	if !ok {
+		return
+	}
+
+	users, err := h.users(c).List(filter, pagination)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to list users", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to retrieve users")
+		return
+	}
+
+	// Convert to response format
+	userResponses := make([]interface{}, len(users))
+	for i, user := range users {
+		userResponses[i], err = selectFields(user.ToResponse(), fields)
+		if err != nil {
+			RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to encode users")
+			return
+		}
+	}
+
+	Respond(c, http.StatusOK, database.PaginatedResponse{
+		Data:       userResponses,
+		Pagination: pagination,
+	})
+}
+
+// Search result limits
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchUsers godoc
+// @Summary Search users
+// @Description Search users by username, email and full name, most relevant first (admin only)
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param q query string true "Search query"
+// @Param limit query int false "Maximum number of results" default(20)
+// @Success 200 {object} models.UserSearchResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/search [get]
+func (h *UserHandler) SearchUsers(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		RespondError(c, http.StatusBadRequest, "invalid_request", "Missing search query")
+		return
+	}
+
+	limit := defaultSearchLimit
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		limit = min(l, maxSearchLimit)
+	}
+
+	users, err := h.users(c).Search(query, limit)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to search users", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to search users")
+		return
+	}
+
+	userResponses := make([]*models.UserResponse, len(users))
+	for i, user := range users {
+		userResponses[i] = user.ToResponse()
+	}
+
+	Respond(c, http.StatusOK, models.UserSearchResponse{Data: userResponses})
+}
+
+// BatchGetUsers godoc
+// @Summary Get users by IDs
+// @Description Get several users at once, keyed by ID. IDs that don't exist are left out of the response. (admin only)
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.BatchGetUsersRequest true "User IDs"
+// @Success 200 {object} models.BatchGetUsersResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/batch-get [post]
+func (h *UserHandler) BatchGetUsers(c *gin.Context) {
+	var req models.BatchGetUsersRequest
+	if err := bindBody(c, &req); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	ids := uniqueIDs(req.IDs)
+	if len(ids) > h.batchGetMaxIDs {
+		RespondError(c, http.StatusBadRequest, "too_many_ids",
+			fmt.Sprintf("At most %d IDs can be requested at once, got %d", h.batchGetMaxIDs, len(ids)))
+		return
+	}
+
+	users, err := h.users(c).GetByIDs(ids)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to batch get users", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to retrieve users")
+		return
+	}
+
+	response := models.BatchGetUsersResponse{Data: make(map[int]*models.UserResponse, len(users))}
+	for _, user := range users {
+		response.Data[user.ID] = user.ToResponse()
+	}
+	Respond(c, http.StatusOK, response)
+}
+
+// uniqueIDs returns ids without duplicates, in their first-seen order
+func uniqueIDs(ids []int) []int {
+	seen := make(map[int]bool, len(ids))
+	unique := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// GetUser godoc
+// @Summary Get user by ID
+// @Description Get a user by their ID (admin only)
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param fields query string false "Comma-separated fields to return, e.g. id,username"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id} [get]
+func (h *UserHandler) GetUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, "invalid_user_id", "Invalid user ID format")
+		return
+	}
+	fields, ok := parseUserFields(c)
+	if !ok {
+		return
+	}
+
+	user, err := h.users(c).GetByID(userID)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to get user", zap.Error(err), zap.Int("target_user_id", userID))
+		RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to retrieve user")
+		return
+	}
+
+	if user == nil {
+		RespondError(c, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+
+	respondFields(c, http.StatusOK, user.ToResponse(), fields)
+}
+
+// UpdateUser godoc
+// @Summary Replace user by ID
+// @Description Replace a user by their ID (admin only). All fields but the password are required; an omitted full name is cleared.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param user body models.ReplaceUserRequest true "User data"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id} [put]
+func (h *UserHandler) UpdateUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, "invalid_user_id", "Invalid user ID format")
+		return
+	}
+
+	var req models.ReplaceUserRequest
+	if err := bindBody(c, &req); err != nil {
+		middleware.Logger(c).Warn("Invalid update request", zap.Error(err))
+		respondBindingError(c, err)
+		return
+	}
+
+	h.updateUser(c, userID, req.UpdateRequest())
+}
+
+// PatchUser godoc
+// @Summary Patch user by ID
+// @Description Update the given fields of a user (admin only). A body of type application/json-patch+json is an RFC 6902 JSON Patch instead, which may only change username, email, full_name and is_active; other fields may be tested.
+// @Tags users
+// @Accept json
+// @Accept application/json-patch+json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param user body models.UpdateUserRequest true "User update data"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id} [patch]
+func (h *UserHandler) PatchUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, "invalid_user_id", "Invalid user ID format")
+		return
+	}
+
+	if c.ContentType() != models.JSONPatchContentType {
+		var req models.UpdateUserRequest
+		if err := bindBody(c, &req); err != nil {
+			middleware.Logger(c).Warn("Invalid update request", zap.Error(err))
+			respondBindingError(c, err)
+			return
+		}
+		h.updateUser(c, userID, &req)
+		return
+	}
+
+	patch, err := c.GetRawData()
+	if err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	user, err := h.users(c).GetByID(userID)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to get user", zap.Error(err), zap.Int("target_user_id", userID))
+		RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to retrieve user")
+		return
+	}
+	if user == nil {
+		RespondError(c, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+
+	doc, err := user.ApplyPatch(patch)
+	if err != nil {
+		var protected *models.ProtectedFieldError
+		switch {
+		case errors.As(err, &protected):
+			RespondError(c, http.StatusUnprocessableEntity, "protected_field", err.Error())
+		case errors.Is(err, models.ErrPatchTestFailed):
+			RespondError(c, http.StatusConflict, "patch_test_failed", err.Error())
+		default:
+			RespondError(c, http.StatusBadRequest, "invalid_patch", err.Error())
+		}
+		return
+	}
+	if err := binding.Validator.ValidateStruct(doc); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	h.updateUser(c, userID, doc.UpdateRequest(user))
+}
+
+// updateUser applies an admin's update to a user and responds with the
+// result
+func (h *UserHandler) updateUser(c *gin.Context, userID int, req *models.UpdateUserRequest) {
+	user, err := h.users(c).Update(userID, req)
+	if err != nil {
+		var conflict *services.ConflictError
+		if errors.As(err, &conflict) {
+			middleware.Logger(c).Warn("User update conflicts with an existing user", zap.String("field", conflict.Field), zap.Int("target_user_id", userID))
+			respondConflict(c, conflict)
+			return
+		}
+		if respondPasswordError(c, err) {
+			return
+		}
+		middleware.Logger(c).Error("Failed to update user", zap.Error(err), zap.Int("target_user_id", userID))
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrUserNotFound) {
+			status = http.StatusNotFound
+		}
+		RespondError(c, status, "update_failed", err.Error())
+		return
+	}
+
+	middleware.Logger(c).Info("User updated by admin", zap.Int("target_user_id", userID))
+	Respond(c, http.StatusOK, user.ToResponse())
+}
+
+// DeleteUser godoc
+// @Summary Delete user by ID
+// @Description Delete a user by their ID (admin only)
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 204 "No Content"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id} [delete]
+func (h *UserHandler) DeleteUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, "invalid_user_id", "Invalid user ID format")
+		return
+	}
+
+	if h.rejectSelfDeletion(c, userID) {
+		return
+	}
+
+	err = h.users(c).Delete(userID)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to delete user", zap.Error(err), zap.Int("target_user_id", userID))
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrUserNotFound) {
+			status = http.StatusNotFound
+		}
+		RespondError(c, status, "deletion_failed", err.Error())
+		return
+	}
+
+	middleware.Logger(c).Info("User deleted by admin", zap.Int("target_user_id", userID))
+	c.Status(http.StatusNoContent)
+}
+
+// BatchDeleteUsers godoc
+// @Summary Delete users by IDs
+// @Description Delete several users at once in one transaction (admin only). IDs that don't exist are skipped and reported.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.BatchDeleteUsersRequest true "User IDs"
+// @Success 200 {object} models.BatchDeleteUsersResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users [delete]
+func (h *UserHandler) BatchDeleteUsers(c *gin.Context) {
+	var req models.BatchDeleteUsersRequest
+	if err := bindBody(c, &req); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	ids := uniqueIDs(req.IDs)
+	if len(ids) > h.batchDeleteMaxIDs {
+		RespondError(c, http.StatusBadRequest, "too_many_ids",
+			fmt.Sprintf("At most %d IDs can be deleted at once, got %d", h.batchDeleteMaxIDs, len(ids)))
+		return
+	}
+	if h.rejectSelfDeletion(c, ids...) {
+		return
+	}
+
+	deleted, skipped, err := h.users(c).BulkDelete(ids)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to batch delete users", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "deletion_failed", "Failed to delete users")
+		return
+	}
+
+	middleware.Logger(c).Info("Users deleted by admin", zap.Int("deleted", deleted), zap.Ints("skipped_user_ids", skipped))
+	Respond(c, http.StatusOK, models.BatchDeleteUsersResponse{Deleted: deleted, Skipped: skipped})
+}
+
+// rejectSelfDeletion responds with an error and returns true when ids
+// include the current user, who can't delete their own account this way
+func (h *UserHandler) rejectSelfDeletion(c *gin.Context, ids ...int) bool {
+	currentUserID, _ := middleware.GetUserID(c)
+	for _, id := range ids {
+		if id == currentUserID {
+			RespondError(c, http.StatusBadRequest, "self_deletion_not_allowed", "Cannot delete your own account")
+			return true
+		}
+	}
+	return false
+}
+
+// ImpersonateUser godoc
+// @Summary Impersonate user by ID
+// @Description Issue a short-lived token acting as a user, for support and debugging (admin only). The token cannot perform destructive admin actions.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} models.ImpersonationResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/impersonate [post]
+func (h *UserHandler) ImpersonateUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, "invalid_user_id", "Invalid user ID format")
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	user, err := h.users(c).Impersonate(userID, adminID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrCannotImpersonate):
+			RespondError(c, http.StatusBadRequest, "impersonation_not_allowed", "Cannot impersonate yourself or an inactive user")
+		case errors.Is(err, services.ErrUserNotFound):
+			RespondError(c, http.StatusNotFound, "user_not_found", "User not found")
+		default:
+			middleware.Logger(c).Error("Failed to impersonate user", zap.Error(err), zap.Int("target_user_id", userID))
+			RespondError(c, http.StatusInternalServerError, "impersonation_failed", "Failed to impersonate user")
+		}
+		return
+	}
+
+	token, err := h.jwtService.GenerateImpersonationToken(user, adminID)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to generate impersonation token", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "token_generation_failed", "Failed to generate authentication token")
+		return
+	}
+
+	Respond(c, http.StatusOK, models.ImpersonationResponse{
+		User:           user.ToResponse(),
+		Token:          token,
+		ExpiresIn:      int(h.jwtService.ImpersonationTTL().Seconds()),
+		ImpersonatedBy: adminID,
+	})
+}
+
+// PromoteUser godoc
+// @Summary Promote user to admin
+// @Description Grant a user the admin role (admin only)
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/promote [post]
+func (h *UserHandler) PromoteUser(c *gin.Context) {
+	h.setAdmin(c, true)
+}
+
+// DemoteUser godoc
+// @Summary Demote admin user
+// @Description Revoke a user's admin role (admin only). The last admin cannot be demoted.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/demote [post]
+func (h *UserHandler) DemoteUser(c *gin.Context) {
+	h.setAdmin(c, false)
+}
+
+// setAdmin grants or revokes the admin role of the user in the path on
+// behalf of the authenticated admin
+func (h *UserHandler) setAdmin(c *gin.Context, isAdmin bool) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, "invalid_user_id", "Invalid user ID format")
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	user, err := h.users(c).SetAdmin(userID, isAdmin, adminID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrLastAdmin):
+			RespondError(c, http.StatusConflict, "last_admin", "Cannot demote the last admin")
+		case errors.Is(err, services.ErrUserNotFound):
+			RespondError(c, http.StatusNotFound, "user_not_found", "User not found")
+		default:
+			middleware.Logger(c).Error("Failed to change admin role", zap.Error(err), zap.Int("target_user_id", userID))
+			RespondError(c, http.StatusInternalServerError, "role_change_failed", "Failed to change admin role")
+		}
+		return
+	}
+
+	Respond(c, http.StatusOK, user.ToResponse())
+}
+
+// UpdateUserStatus godoc
+// @Summary Change user status
+// @Description Activate, deactivate or suspend a user (admin only). Suspended and inactive users can't log in; activating a user cancels their scheduled deletion.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param status body models.UpdateStatusRequest true "New status"
+// @Success 200 {object} models.UserResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/{id}/status [put]
+func (h *UserHandler) UpdateUserStatus(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, "invalid_user_id", "Invalid user ID format")
+		return
+	}
+
+	var req models.UpdateStatusRequest
+	if err := bindBody(c, &req); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	user, err := h.users(c).SetStatus(userID, req.Status, adminID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			RespondError(c, http.StatusNotFound, "user_not_found", "User not found")
+		default:
+			middleware.Logger(c).Error("Failed to change user status", zap.Error(err), zap.Int("target_user_id", userID))
+			RespondError(c, http.StatusInternalServerError, "status_change_failed", "Failed to change user status")
+		}
+		return
+	}
+
+	Respond(c, http.StatusOK, user.ToResponse())
+}
+
+// parseUserFilter parses the ListUsers query parameters into a filter.
+// username, email, is_active and is_admin without an operator prefix keep
+// their original substring and boolean matching; any other parameter must be
+// an "op:value" condition on a field in models.UserFilterFields.
+// created_after and created_before are RFC 3339 times bounding the creation
+// time; last_login_before and inactive_days select users who haven't logged
+// in since a time or for a number of days.
+func parseUserFilter(c *gin.Context, query models.ListUsersQuery) (*models.UserFilter, error) {
+	filter := &models.UserFilter{}
+	if query.Search != "" {
+		filter.Search = &query.Search
+	}
+	conditions := make(map[string][]string)
+
+	for key, values := range c.Request.URL.Query() {
+		value := values[0]
+		if key == "page" || key == "limit" || key == "search" || key == "fields" {
+			continue
+		}
+		if _, _, hasOp := models.SplitOperator(value); hasOp || len(values) > 1 {
+			conditions[key] = values
+			continue
+		}
+
+		switch key {
+		case "username":
+			if value != "" {
+				filter.Username = &value
+			}
+		case "email":
+			if value != "" {
+				filter.Email = &value
+			}
+		case "is_active", "is_admin":
+			if value == "" {
+				continue
+			}
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid boolean %q", key, value)
+			}
+			if key == "is_active" {
+				filter.IsActive = &b
+			} else {
+				filter.IsAdmin = &b
+			}
+		case "created_after", "created_before", "last_login_before":
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid RFC 3339 time %q", key, value)
+			}
+			switch key {
+			case "created_after":
+				filter.CreatedAfter = &t
+			case "created_before":
+				filter.CreatedBefore = &t
+			default:
+				filter.LastLoginBefore = &t
+			}
+		case "inactive_days":
+			days, err := strconv.Atoi(value)
+			if err != nil || days < 1 {
+				return nil, fmt.Errorf("%s: must be a positive number of days, got %q", key, value)
+			}
+			filter.InactiveDays = &days
+		default:
+			conditions[key] = values
+		}
+	}
+
+	if filter.CreatedAfter != nil && filter.CreatedBefore != nil && filter.CreatedAfter.After(*filter.CreatedBefore) {
+		return nil, errors.New("created_after must not be after created_before")
+	}
+
+	var err error
+	filter.Conditions, err = models.UserFilterFields.ParseAll(conditions)
+	return filter, err
 }
@@ -2,19 +2,35 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"gin-service/internal/api/middleware"
+	"gin-service/internal/config"
 	"gin-service/internal/database"
 	"gin-service/internal/models"
+	"gin-service/internal/repository"
+	"gin-service/internal/services"
+	"gin-service/internal/storage"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
 	"go.uber.org/zap"
 )
 
@@ -65,14 +81,140 @@ func (m *MockUserService) Delete(id int) error {
 	return args.Error(0)
 }
 
-func (m *MockUserService) Authenticate(username, password string) (*models.User, error) {
-	args := m.Called(username, password)
+func (m *MockUserService) Authenticate(identifier string, identifierType models.IdentifierType, password string) (*models.User, error) {
+	args := m.Called(identifier, identifierType, password)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
+func (m *MockUserService) SetAvatar(ctx context.Context, id int, data []byte) (*models.User, error) {
+	args := m.Called(id, data)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserService) SetupTwoFactor(id int) (*services.TwoFactorSetup, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*services.TwoFactorSetup), args.Error(1)
+}
+
+func (m *MockUserService) EnableTwoFactor(id int, code string) (*models.User, []string, error) {
+	args := m.Called(id, code)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).(*models.User), args.Get(1).([]string), args.Error(2)
+}
+
+func (m *MockUserService) VerifyTwoFactor(id int, challengeID, code string) (*models.User, error) {
+	args := m.Called(id, challengeID, code)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserService) GetByIDs(ids []int) ([]*models.User, error) {
+	args := m.Called(ids)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.User), args.Error(1)
+}
+
+func (m *MockUserService) Search(query string, limit int) ([]*models.User, error) {
+	args := m.Called(query, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.User), args.Error(1)
+}
+
+func (m *MockUserService) ConfirmEmail(id int, token string) (*models.User, error) {
+	args := m.Called(id, token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserService) Impersonate(targetID, adminID int) (*models.User, error) {
+	args := m.Called(targetID, adminID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserService) BulkDelete(ids []int) (int, []int, error) {
+	args := m.Called(ids)
+	var skipped []int
+	if args.Get(1) != nil {
+		skipped = args.Get(1).([]int)
+	}
+	return args.Int(0), skipped, args.Error(2)
+}
+
+func (m *MockUserService) ScheduleDeletion(id int) (*models.User, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserService) Anonymize(id, actorID int) error {
+	args := m.Called(id, actorID)
+	return args.Error(0)
+}
+
+func (m *MockUserService) ExportUserData(id int) (*models.UserDataBundle, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.UserDataBundle), args.Error(1)
+}
+
+func (m *MockUserService) CancelDeletion(id int) (*models.User, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserService) SetStatus(id int, status models.Status, adminID int) (*models.User, error) {
+	args := m.Called(id, status, adminID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserService) SetAdmin(id int, isAdmin bool, adminID int) (*models.User, error) {
+	args := m.Called(id, isAdmin, adminID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserService) WithLogger(logger *zap.Logger) services.UserServiceInterface {
+	return m
+}
+
+func (m *MockUserService) WithContext(ctx context.Context) services.UserServiceInterface {
+	return m
+}
+
 func (m *MockUserService) List(filter *models.UserFilter, pagination *database.Paginate) ([]*models.User, error) {
 	args := m.Called(filter, pagination)
 	if args.Get(0) == nil {
@@ -99,6 +241,45 @@ func (m *MockJWTService) ValidateToken(tokenString string) (*middleware.Claims,
 	return args.Get(0).(*middleware.Claims), args.Error(1)
 }
 
+func (m *MockJWTService) GenerateChallengeToken(user *models.User) (string, error) {
+	args := m.Called(user)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockJWTService) ValidateChallengeToken(tokenString string) (*middleware.Claims, error) {
+	args := m.Called(tokenString)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*middleware.Claims), args.Error(1)
+}
+
+func (m *MockJWTService) ChallengeTTL() time.Duration {
+	return 5 * time.Minute
+}
+
+func (m *MockJWTService) GenerateImpersonationToken(user *models.User, adminID int) (string, error) {
+	args := m.Called(user, adminID)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockJWTService) ImpersonationTTL() time.Duration {
+	return 15 * time.Minute
+}
+
+func (m *MockJWTService) GenerateCancelDeletionToken(user *models.User) (string, error) {
+	args := m.Called(user)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockJWTService) ValidateCancelDeletionToken(tokenString string) (*middleware.Claims, error) {
+	args := m.Called(tokenString)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*middleware.Claims), args.Error(1)
+}
+
 func setupUserHandler() (*UserHandler, *MockUserService, *MockJWTService) {
 	mockUserService := &MockUserService{}
 	mockJWTService := &MockJWTService{}
@@ -142,6 +323,8 @@ func TestUserHandler_Register_Success(t *testing.T) {
 
 	// Assert response
 	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "/api/v1/users/1", w.Header().Get("Location"))
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
 
 	var response models.UserResponse
 	err := json.Unmarshal(w.Body.Bytes(), &response)
@@ -154,35 +337,52 @@ func TestUserHandler_Register_Success(t *testing.T) {
 }
 
 func TestUserHandler_Register_ConflictError(t *testing.T) {
-	handler, mockUserService, _ := setupUserHandler()
-
-	createReq := &models.CreateUserRequest{
-		Username: "testuser",
-		Email:    "test@example.com",
-		Password: "password123",
+	tests := []struct {
+		name    string
+		err     error
+		field   string
+		message string
+	}{
+		{"username taken", repository.ErrDuplicateUsername, "username", "username already exists"},
+		{"email taken", fmt.Errorf("lost the race: %w", repository.ErrDuplicateEmail), "email", "email already exists"},
 	}
 
-	mockUserService.On("Create", mock.AnythingOfType("*models.CreateUserRequest")).Return((*models.User)(nil), errors.New("username already exists"))
-
-	gin.SetMode(gin.TestMode)
-	router := gin.New()
-	router.POST("/auth/register", handler.Register)
-
-	reqBody, _ := json.Marshal(createReq)
-	req, _ := http.NewRequest("POST", "/auth/register", bytes.NewBuffer(reqBody))
-	req.Header.Set("Content-Type", "application/json")
-	w := httptest.NewRecorder()
-	router.ServeHTTP(w, req)
-
-	// Assert response
-	assert.Equal(t, http.StatusConflict, w.Code)
-
-	var response ErrorResponse
-	err := json.Unmarshal(w.Body.Bytes(), &response)
-	assert.NoError(t, err)
-	assert.Equal(t, "registration_failed", response.Error)
-
-	mockUserService.AssertExpectations(t)
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mockUserService, _ := setupUserHandler()
+
+			createReq := &models.CreateUserRequest{
+				Username: "testuser",
+				Email:    "test@example.com",
+				Password: "password123",
+			}
+
+			mockUserService.On("Create", mock.AnythingOfType("*models.CreateUserRequest")).Return((*models.User)(nil), tt.err)
+
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.POST("/auth/register", handler.Register)
+
+			reqBody, _ := json.Marshal(createReq)
+			req, _ := http.NewRequest("POST", "/auth/register", bytes.NewBuffer(reqBody))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			// The conflicting field is named for the client to highlight
+			assert.Equal(t, http.StatusConflict, w.Code)
+
+			var response ErrorResponse
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			assert.NoError(t, err)
+			assert.Equal(t, "conflict", response.Error)
+			assert.Equal(t, tt.field, response.Field)
+			assert.Equal(t, tt.message, response.Message)
+
+			mockUserService.AssertExpectations(t)
+		})
+	}
 }
 
 func TestUserHandler_Login_Success(t *testing.T) {
@@ -203,7 +403,7 @@ func TestUserHandler_Login_Success(t *testing.T) {
 		IsAdmin:  false,
 	}
 
-	mockUserService.On("Authenticate", "testuser", "password123").Return(mockUser, nil)
+	mockUserService.On("Authenticate", "testuser", models.IdentifierAuto, "password123").Return(mockUser, nil)
 	mockJWTService.On("GenerateToken", mockUser).Return("mock-jwt-token", nil)
 
 	gin.SetMode(gin.TestMode)
@@ -226,6 +426,16 @@ func TestUserHandler_Login_Success(t *testing.T) {
 	assert.Equal(t, mockUser.ID, response.User.ID)
 	assert.Equal(t, mockUser.Username, response.User.Username)
 
+	// The token stays at the top level, next to the user as every other
+	// endpoint returns it
+	var raw map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &raw))
+	assert.Len(t, raw, 2)
+	assert.JSONEq(t, `"mock-jwt-token"`, string(raw["token"]))
+	expected, err := json.Marshal(mockUser.ToResponse())
+	require.NoError(t, err)
+	assert.JSONEq(t, string(expected), string(raw["user"]))
+
 	mockUserService.AssertExpectations(t)
 	mockJWTService.AssertExpectations(t)
 }
@@ -238,7 +448,7 @@ func TestUserHandler_Login_InvalidCredentials(t *testing.T) {
 		Password: "wrongpassword",
 	}
 
-	mockUserService.On("Authenticate", "testuser", "wrongpassword").Return((*models.User)(nil), errors.New("invalid credentials"))
+	mockUserService.On("Authenticate", "testuser", models.IdentifierAuto, "wrongpassword").Return((*models.User)(nil), services.ErrInvalidCredentials)
 
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
@@ -261,6 +471,62 @@ func TestUserHandler_Login_InvalidCredentials(t *testing.T) {
 	mockUserService.AssertExpectations(t)
 }
 
+func TestUserHandler_Login_Throttled(t *testing.T) {
+	handler, mockUserService, _ := setupUserHandler()
+
+	// The service rejects the attempt before checking the credentials
+	mockUserService.On("Authenticate", "testuser", models.IdentifierAuto, "wrongpassword").
+		Return((*models.User)(nil), &services.LoginThrottledError{RetryAfter: 59500 * time.Millisecond})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/login", handler.Login)
+
+	reqBody, _ := json.Marshal(models.LoginRequest{Username: "testuser", Password: "wrongpassword"})
+	req, _ := http.NewRequest("POST", "/auth/login", bytes.NewBuffer(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	var response ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "too_many_login_attempts", response.Error)
+}
+
+func TestUserHandler_Login_AccountStatus(t *testing.T) {
+	tests := []struct {
+		err           error
+		expectedError string
+	}{
+		{services.ErrAccountInactive, "account_inactive"},
+		{services.ErrAccountSuspended, "account_suspended"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expectedError, func(t *testing.T) {
+			handler, mockUserService, _ := setupUserHandler()
+			mockUserService.On("Authenticate", "testuser", models.IdentifierAuto, "password123").Return((*models.User)(nil), tt.err)
+
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.POST("/auth/login", handler.Login)
+
+			req, _ := http.NewRequest("POST", "/auth/login", strings.NewReader(`{"username": "testuser", "password": "password123"}`))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			var response ErrorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.expectedError, response.Error)
+		})
+	}
+}
+
 func TestUserHandler_GetProfile_Success(t *testing.T) {
 	handler, mockUserService, _ := setupUserHandler()
 
@@ -301,6 +567,203 @@ func TestUserHandler_GetProfile_Success(t *testing.T) {
 	mockUserService.AssertExpectations(t)
 }
 
+func TestUserHandler_ConfirmEmail(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedError  string
+	}{
+		{"confirmed", nil, http.StatusOK, ""},
+		{"wrong token", services.ErrInvalidEmailChangeToken, http.StatusBadRequest, "invalid_token"},
+		{"expired token", services.ErrEmailChangeExpired, http.StatusGone, "token_expired"},
+		{"email taken", repository.ErrDuplicateEmail, http.StatusConflict, "conflict"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mockUserService, _ := setupUserHandler()
+			if tt.err != nil {
+				mockUserService.On("ConfirmEmail", 1, "token").Return(nil, tt.err)
+			} else {
+				mockUserService.On("ConfirmEmail", 1, "token").Return(&models.User{ID: 1, Email: "new@example.com"}, nil)
+			}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.GET("/users/me/confirm-email", func(c *gin.Context) {
+				c.Set("user_id", 1)
+				handler.ConfirmEmail(c)
+			})
+
+			req, _ := http.NewRequest("GET", "/users/me/confirm-email?token=token", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedError != "" {
+				var response ErrorResponse
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, tt.expectedError, response.Error)
+			} else {
+				var response models.UserResponse
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, "new@example.com", response.Email)
+			}
+			mockUserService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUserHandler_DeleteAccount(t *testing.T) {
+	scheduledAt := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		serviceMethod  string
+		user           *models.User
+		err            error
+		expectedStatus int
+		expectedError  string
+	}{
+		{"scheduled", "DELETE", "/users/me", "ScheduleDeletion", &models.User{ID: 1, DeletionScheduledAt: &scheduledAt}, nil, http.StatusAccepted, ""},
+		{"inactive", "DELETE", "/users/me", "ScheduleDeletion", nil, services.ErrAccountInactive, http.StatusConflict, "account_inactive"},
+		{"cancelled", "POST", "/users/me/cancel-deletion", "CancelDeletion", &models.User{ID: 1, IsActive: true}, nil, http.StatusOK, ""},
+		{"not scheduled", "POST", "/users/me/cancel-deletion", "CancelDeletion", nil, services.ErrDeletionNotScheduled, http.StatusConflict, "deletion_not_scheduled"},
+		// Wrapped errors keep their status
+		{"schedule not found", "DELETE", "/users/me", "ScheduleDeletion", nil, fmt.Errorf("failed to schedule deletion: %w", services.ErrUserNotFound), http.StatusNotFound, "user_not_found"},
+		{"cancel not found", "POST", "/users/me/cancel-deletion", "CancelDeletion", nil, fmt.Errorf("failed to cancel deletion: %w", services.ErrUserNotFound), http.StatusNotFound, "user_not_found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, mockUserService, _ := setupUserHandler()
+			if tt.err != nil {
+				mockUserService.On(tt.serviceMethod, 1).Return(nil, tt.err)
+			} else {
+				mockUserService.On(tt.serviceMethod, 1).Return(tt.user, nil)
+			}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				c.Set("user_id", 1)
+			})
+			router.DELETE("/users/me", handler.DeleteAccount)
+			router.POST("/users/me/cancel-deletion", handler.CancelAccountDeletion)
+
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedError != "" {
+				var response ErrorResponse
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, tt.expectedError, response.Error)
+			} else {
+				var response models.UserResponse
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.Equal(t, tt.user.IsActive, response.IsActive)
+				if tt.user.DeletionScheduledAt != nil {
+					require.NotNil(t, response.DeletionScheduledAt)
+					assert.True(t, scheduledAt.Equal(*response.DeletionScheduledAt))
+				} else {
+					assert.Nil(t, response.DeletionScheduledAt)
+				}
+			}
+			mockUserService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUserHandler_FieldSelection(t *testing.T) {
+	handler, mockUserService, _ := setupUserHandler()
+	mockUser := &models.User{ID: 1, Username: "testuser", Email: "test@example.com", Password: "hash", IsActive: true}
+	mockUserService.On("GetByID", 1).Return(mockUser, nil)
+	mockUserService.On("List", mock.Anything, mock.Anything).Return([]*models.User{mockUser}, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/users/profile", func(c *gin.Context) {
+		c.Set("user_id", 1)
+		handler.GetProfile(c)
+	})
+	router.GET("/users/:id", handler.GetUser)
+	router.GET("/users", handler.ListUsers)
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, path := range []string{"/users/profile?fields=id,username", "/users/1?fields=id,%20username"} {
+		w := get(path)
+		require.Equal(t, http.StatusOK, w.Code, path)
+		assert.JSONEq(t, `{"id": 1, "username": "testuser"}`, w.Body.String(), path)
+	}
+
+	w := get("/users?fields=id,email")
+	require.Equal(t, http.StatusOK, w.Code)
+	var page struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, []map[string]interface{}{{"id": float64(1), "email": "test@example.com"}}, page.Data)
+
+	// Unknown fields, including credentials which aren't part of a user
+	// response, are rejected
+	for _, path := range []string{"/users/1?fields=id,nickname", "/users/profile?fields=password", "/users?fields=password_hash", "/users/1?fields="} {
+		w := get(path)
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+		assert.Contains(t, w.Body.String(), "invalid_fields", path)
+	}
+}
+
+func TestUserHandler_GetProfile_Versions(t *testing.T) {
+	handler, mockUserService, _ := setupUserHandler()
+	mockUser := &models.User{ID: 1, Username: "testuser", Email: "test@example.com", IsActive: true}
+	mockUserService.On("GetByID", 1).Return(mockUser, nil)
+	mockUserService.On("GetByID", 2).Return(nil, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	for _, version := range []string{middleware.APIv1, middleware.APIv2} {
+		version := version
+		router.GET("/api/"+version+"/users/profile", middleware.APIVersion(version), func(c *gin.Context) {
+			userID, _ := strconv.Atoi(c.Query("as"))
+			c.Set("user_id", userID)
+			handler.GetProfile(c)
+		})
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// v1 responds with the user as is, v2 within a data envelope
+	w := get("/api/v1/users/profile?as=1&fields=id,username")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id": 1, "username": "testuser"}`, w.Body.String())
+
+	w = get("/api/v2/users/profile?as=1&fields=id,username")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"data": {"id": 1, "username": "testuser"}}`, w.Body.String())
+
+	// Errors mirror the envelope in v2
+	w = get("/api/v1/users/profile?as=2")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"error": "user_not_found", "message": "User not found"}`, w.Body.String())
+
+	w = get("/api/v2/users/profile?as=2")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"error": {"code": "user_not_found", "message": "User not found"}}`, w.Body.String())
+}
+
 func TestUserHandler_GetProfile_Unauthorized(t *testing.T) {
 	handler, _, _ := setupUserHandler()
 
@@ -321,12 +784,12 @@ func TestUserHandler_GetProfile_Unauthorized(t *testing.T) {
 	assert.Equal(t, "unauthorized", response.Error)
 }
 
-func TestUserHandler_UpdateProfile_Success(t *testing.T) {
+func TestUserHandler_PatchProfile_Success(t *testing.T) {
 	handler, mockUserService, _ := setupUserHandler()
 
 	newFullName := "Updated User"
-	updateReq := models.UpdateUserRequest{
-		FullName: &newFullName,
+	updateReq := models.UpdateProfileRequest{
+		FullName: models.NullableValue(newFullName),
 	}
 
 	updatedUser := &models.User{
@@ -342,14 +805,14 @@ func TestUserHandler_UpdateProfile_Success(t *testing.T) {
 
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
-	router.PUT("/users/profile", func(c *gin.Context) {
+	router.PATCH("/users/profile", func(c *gin.Context) {
 		// Simulate authenticated user context
 		c.Set("user_id", 1)
-		handler.UpdateProfile(c)
+		handler.PatchProfile(c)
 	})
 
 	reqBody, _ := json.Marshal(updateReq)
-	req, _ := http.NewRequest("PUT", "/users/profile", bytes.NewBuffer(reqBody))
+	req, _ := http.NewRequest("PATCH", "/users/profile", bytes.NewBuffer(reqBody))
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -364,4 +827,1556 @@ func TestUserHandler_UpdateProfile_Success(t *testing.T) {
 	assert.Equal(t, *updatedUser.FullName, *response.FullName)
 
 	mockUserService.AssertExpectations(t)
-}
\ No newline at end of file
+}
+func TestUserHandler_Login_Identifier(t *testing.T) {
+	handler, mockUserService, mockJWTService := setupUserHandler()
+
+	user := &models.User{ID: 1, Username: "me@home", Email: "test@example.com", IsActive: true}
+	mockUserService.On("Authenticate", "me@home", models.IdentifierUsername, "password123").Return(user, nil)
+	mockJWTService.On("GenerateToken", user).Return("token", nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/login", handler.Login)
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"identifier": "me@home", "identifier_type": "username", "password": "password123"}`, http.StatusOK},
+		{`{"identifier": "me@home", "identifier_type": "phone", "password": "password123"}`, http.StatusBadRequest},
+		{`{"password": "password123"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/auth/login", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.status, w.Code, tt.body)
+	}
+
+	mockUserService.AssertExpectations(t)
+}
+
+func TestUserHandler_Register_BodyTooLarge(t *testing.T) {
+	handler, mockUserService, _ := setupUserHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.MaxSizeMiddleware(32))
+	router.POST("/auth/register", handler.Register)
+
+	reqBody, _ := json.Marshal(models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	req, _ := http.NewRequest("POST", "/auth/register", bytes.NewBuffer(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	// Lie about the body size so the Content-Length check passes
+	req.ContentLength = 16
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Assert response
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	var response ErrorResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "request_too_large", response.Error)
+
+	mockUserService.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestUserHandler_Register_StrictJSON(t *testing.T) {
+	handler, mockUserService, _ := setupUserHandler()
+	mockUserService.On("Create", mock.AnythingOfType("*models.CreateUserRequest")).Return(&models.User{ID: 1, Username: "testuser", Email: "test@example.com"}, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/register", middleware.StrictJSON(), handler.Register)
+	router.POST("/lenient/register", handler.Register)
+
+	register := func(path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// A misspelt field is rejected, naming it
+	w := register("/auth/register", `{"username":"testuser","emial":"test@example.com","password":"password123"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var response ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "unknown_field", response.Error)
+	assert.Contains(t, response.Message, `"emial"`)
+	mockUserService.AssertNotCalled(t, "Create", mock.Anything)
+
+	// Declared fields still bind and validate
+	w = register("/auth/register", `{"username":"testuser","email":"not-an-email","password":"password123"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "validation_error")
+
+	w = register("/auth/register", `{"username":"testuser","email":"test@example.com","password":"password123","full_name":"Test User"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	mockUserService.AssertCalled(t, "Create", mock.MatchedBy(func(req *models.CreateUserRequest) bool {
+		return req.Email == "test@example.com" && req.FullName != nil && *req.FullName == "Test User"
+	}))
+
+	// Routes without StrictJSON ignore unknown fields
+	w = register("/lenient/register", `{"username":"testuser","email":"test@example.com","password":"password123","extra":true}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+}
+
+func TestUserHandler_Register_InMemoryStore(t *testing.T) {
+	// Exercise the real service against the in-memory store, no database needed
+	userService := services.NewUserService(repository.NewMemoryStore(), zap.NewNop())
+	handler := NewUserHandler(userService, &MockJWTService{}, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/register", handler.Register)
+
+	register := func() *httptest.ResponseRecorder {
+		reqBody, _ := json.Marshal(&models.CreateUserRequest{
+			Username: "testuser",
+			Email:    "test@example.com",
+			Password: "password123",
+		})
+		req, _ := http.NewRequest("POST", "/auth/register", bytes.NewBuffer(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := register()
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response models.UserResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.NotZero(t, response.ID)
+	assert.Equal(t, "testuser", response.Username)
+
+	// Registering the same user again conflicts
+	w = register()
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestUserHandler_Register_MultibytePassword(t *testing.T) {
+	userService := services.NewUserService(repository.NewMemoryStore(), zap.NewNop())
+	handler := NewUserHandler(userService, &MockJWTService{}, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/register", handler.Register)
+
+	// 30 characters pass the binding rules, but are 90 bytes, past bcrypt's
+	// limit
+	reqBody, _ := json.Marshal(&models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: strings.Repeat("密", 30),
+	})
+	req, _ := http.NewRequest("POST", "/auth/register", bytes.NewBuffer(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var response ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "validation_error", response.Error)
+	assert.Equal(t, "password must be at most 72 bytes", response.Message)
+}
+
+func TestUserHandler_ListUsers_Conditions(t *testing.T) {
+	mockUserService := &MockUserService{}
+	handler := NewUserHandler(mockUserService, &MockJWTService{}, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/users", handler.ListUsers)
+
+	mockUserService.On("List", mock.MatchedBy(func(filter *models.UserFilter) bool {
+		return filter.Username != nil && *filter.Username == "ali" &&
+			len(filter.Conditions) == 2 &&
+			filter.Conditions[0].Field == "created_at" && filter.Conditions[0].Operator == models.OpGte &&
+			filter.Conditions[1].Field == "is_admin" && filter.Conditions[1].Value == true
+	}), mock.Anything).Return([]*models.User{}, nil)
+
+	req, _ := http.NewRequest("GET", "/users?page=1&username=ali&created_at=gte:2024-01-01&is_admin=eq:true", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockUserService.AssertExpectations(t)
+}
+
+func TestUserHandler_ListUsers_CreatedRange(t *testing.T) {
+	mockUserService := &MockUserService{}
+	handler := NewUserHandler(mockUserService, &MockJWTService{}, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/users", handler.ListUsers)
+
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2024, 1, 31, 23, 59, 59, 0, time.UTC)
+	mockUserService.On("List", mock.MatchedBy(func(filter *models.UserFilter) bool {
+		return filter.CreatedAfter != nil && filter.CreatedAfter.Equal(after) &&
+			filter.CreatedBefore != nil && filter.CreatedBefore.Equal(before) &&
+			filter.IsAdmin != nil && !*filter.IsAdmin
+	}), mock.Anything).Return([]*models.User{}, nil)
+
+	req, _ := http.NewRequest("GET", "/users?created_after=2024-01-01T00:00:00Z&created_before=2024-01-31T23:59:59Z&is_admin=false", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockUserService.AssertExpectations(t)
+
+	for _, query := range []string{
+		"created_after=2024-01-01",
+		"created_before=yesterday",
+		"created_after=2024-02-01T00:00:00Z&created_before=2024-01-01T00:00:00Z",
+	} {
+		req, _ := http.NewRequest("GET", "/users?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+
+		var response ErrorResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "invalid_filter", response.Error)
+	}
+}
+
+func TestUserHandler_ListUsers_LastLogin(t *testing.T) {
+	mockUserService := &MockUserService{}
+	handler := NewUserHandler(mockUserService, &MockJWTService{}, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/users", handler.ListUsers)
+
+	before := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mockUserService.On("List", mock.MatchedBy(func(filter *models.UserFilter) bool {
+		return filter.LastLoginBefore != nil && filter.LastLoginBefore.Equal(before) &&
+			filter.InactiveDays != nil && *filter.InactiveDays == 90
+	}), mock.Anything).Return([]*models.User{}, nil)
+
+	req, _ := http.NewRequest("GET", "/users?last_login_before=2024-01-01T00:00:00Z&inactive_days=90", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockUserService.AssertExpectations(t)
+
+	for _, query := range []string{"inactive_days=0", "inactive_days=month", "last_login_before=2024-01-01"} {
+		req, _ := http.NewRequest("GET", "/users?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestUserHandler_ListUsers_InvalidFilter(t *testing.T) {
+	mockUserService := &MockUserService{}
+	handler := NewUserHandler(mockUserService, &MockJWTService{}, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/users", handler.ListUsers)
+
+	for _, query := range []string{
+		"unknown=eq:1",
+		"created_at=between:2024-01-01",
+		"is_admin=like:true",
+		"created_at=gte:not-a-date",
+		"password_hash=eq:x",
+	} {
+		req, _ := http.NewRequest("GET", "/users?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+
+		var response ErrorResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "invalid_filter", response.Error)
+	}
+
+	mockUserService.AssertNotCalled(t, "List", mock.Anything, mock.Anything)
+}
+
+func TestUserHandler_ListUsers_InvalidQuery(t *testing.T) {
+	mockUserService := &MockUserService{}
+	handler := NewUserHandler(mockUserService, &MockJWTService{}, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/users", handler.ListUsers)
+
+	tests := []struct {
+		query string
+		code  string
+	}{
+		{"page=0", "validation_error"},
+		{"page=abc", "validation_error"},
+		{"limit=-5", "validation_error"},
+		{"search=" + strings.Repeat("a", 101), "validation_error"},
+		{"username=" + strings.Repeat("a", 256), "validation_error"},
+		{"is_active=maybe", "invalid_filter"},
+		{"is_admin=2", "invalid_filter"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/users?"+tt.query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, tt.query)
+		var response ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, tt.code, response.Error, tt.query)
+	}
+
+	mockUserService.AssertNotCalled(t, "List", mock.Anything, mock.Anything)
+}
+
+func TestUserHandler_ListUsers_Defaults(t *testing.T) {
+	mockUserService := &MockUserService{}
+	handler := NewUserHandler(mockUserService, &MockJWTService{}, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/users", handler.ListUsers)
+
+	mockUserService.On("List", mock.MatchedBy(func(filter *models.UserFilter) bool {
+		return filter.Search == nil && filter.IsActive == nil && len(filter.Conditions) == 0
+	}), mock.MatchedBy(func(p *database.Paginate) bool {
+		return p.Page == 1 && p.Limit == 10
+	})).Return([]*models.User{}, nil).Twice()
+	mockUserService.On("List", mock.MatchedBy(func(filter *models.UserFilter) bool {
+		return *filter.Search == "ali" && *filter.IsActive
+	}), mock.MatchedBy(func(p *database.Paginate) bool {
+		return p.Page == 2 && p.Limit == 25
+	})).Return([]*models.User{}, nil).Once()
+
+	for _, query := range []string{"", "search=&is_active=", "page=2&limit=25&search=ali&is_active=true"} {
+		req, _ := http.NewRequest("GET", "/users?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, query)
+	}
+
+	mockUserService.AssertExpectations(t)
+}
+
+func TestUserHandler_ListUsers_Envelope(t *testing.T) {
+	mockUserService := &MockUserService{}
+	handler := NewUserHandler(mockUserService, &MockJWTService{}, zap.NewNop())
+	mockUserService.On("List", mock.Anything, mock.Anything).Return([]*models.User{{ID: 1, Username: "testuser"}}, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.ResponseEnvelope(false))
+	router.GET("/users", handler.ListUsers)
+	list := func(accept string) map[string]interface{} {
+		req, _ := http.NewRequest("GET", "/users?fields=id", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body
+	}
+
+	// Pagination goes in meta
+	body := list("application/json; envelope=true")
+	assert.Equal(t, []interface{}{map[string]interface{}{"id": float64(1)}}, body["data"])
+	assert.NotContains(t, body, "pagination")
+	require.Contains(t, body, "meta")
+	pagination := body["meta"].(map[string]interface{})["pagination"].(map[string]interface{})
+	assert.Equal(t, float64(1), pagination["page"])
+
+	body = list("application/json")
+	assert.Contains(t, body, "pagination")
+	assert.NotContains(t, body, "meta")
+}
+
+func TestUserHandler_ListUsers_ConfiguredLimits(t *testing.T) {
+	database.SetPaginationLimits(3, 5)
+	defer database.SetPaginationLimits(database.DefaultPaginationLimit, database.MaxPaginationLimit)
+
+	userService := services.NewUserService(repository.NewMemoryStore(), zap.NewNop())
+	for i := 0; i < 7; i++ {
+		_, err := userService.Create(&models.CreateUserRequest{
+			Username: fmt.Sprintf("user%d", i),
+			Email:    fmt.Sprintf("user%d@example.com", i),
+			Password: "password123",
+		})
+		require.NoError(t, err)
+	}
+	handler := NewUserHandler(userService, &MockJWTService{}, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/users", handler.ListUsers)
+
+	tests := []struct {
+		query string
+		limit int
+		count int
+	}{
+		{"", 3, 3},
+		{"limit=4", 4, 4},
+		{"limit=500", 5, 5},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/users?"+tt.query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, tt.query)
+
+		var response struct {
+			Data       []models.UserResponse `json:"data"`
+			Pagination database.Paginate     `json:"pagination"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, tt.limit, response.Pagination.Limit, tt.query)
+		assert.Len(t, response.Data, tt.count, tt.query)
+		assert.Equal(t, 7, response.Pagination.Total, tt.query)
+	}
+}
+
+// setupAvatarRouter serves avatar uploads for user 1, backed by the real
+// service, the in-memory store and a local blob store in a temp dir
+func setupAvatarRouter(t *testing.T) (*gin.Engine, *services.UserService, string) {
+	dir := t.TempDir()
+	userService := services.NewUserService(repository.NewMemoryStore(), zap.NewNop())
+	userService.SetAvatarStore(storage.NewLocalStore(dir, "/uploads"), services.AvatarLimits{
+		MaxSize:   64 * 1024,
+		MaxWidth:  256,
+		MaxHeight: 256,
+	})
+	_, err := userService.Create(&models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	require.NoError(t, err)
+
+	handler := NewUserHandler(userService, &MockJWTService{}, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/users/me/avatar", func(c *gin.Context) {
+		// Simulate authenticated user context
+		c.Set("user_id", 1)
+		handler.UploadAvatar(c)
+	})
+	return router, userService, dir
+}
+
+func pngImage(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))))
+	return buf.Bytes()
+}
+
+func uploadAvatar(router *gin.Engine, data []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("avatar", "avatar.png")
+	part.Write(data)
+	form.Close()
+
+	req, _ := http.NewRequest("POST", "/users/me/avatar", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUserHandler_UploadAvatar_Success(t *testing.T) {
+	router, userService, dir := setupAvatarRouter(t)
+	avatar := pngImage(t, 64, 64)
+
+	w := uploadAvatar(router, avatar)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.UserResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.NotNil(t, response.AvatarURL)
+	assert.Regexp(t, `^/uploads/avatars/1\?v=\w+$`, *response.AvatarURL)
+
+	stored, err := os.ReadFile(filepath.Join(dir, "avatars", "1"))
+	require.NoError(t, err)
+	assert.Equal(t, avatar, stored)
+
+	user, err := userService.GetByID(1)
+	require.NoError(t, err)
+	assert.Equal(t, response.AvatarURL, user.AvatarURL)
+
+	// Deleting the user deletes the avatar
+	require.NoError(t, userService.Delete(1))
+	_, err = os.Stat(filepath.Join(dir, "avatars", "1"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUserHandler_UploadAvatar_Rejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data func(t *testing.T) []byte
+	}{
+		{"oversized file", func(t *testing.T) []byte {
+			return append(pngImage(t, 16, 16), make([]byte, 64*1024)...)
+		}},
+		{"oversized dimensions", func(t *testing.T) []byte { return pngImage(t, 512, 16) }},
+		{"non-image payload", func(t *testing.T) []byte { return []byte("<html><script>alert(1)</script></html>") }},
+		{"truncated image", func(t *testing.T) []byte { return pngImage(t, 16, 16)[:40] }},
+		{"empty file", func(t *testing.T) []byte { return nil }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, userService, dir := setupAvatarRouter(t)
+
+			w := uploadAvatar(router, tt.data(t))
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			var response ErrorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, "invalid_avatar", response.Error)
+
+			// Nothing is stored
+			_, err := os.Stat(filepath.Join(dir, "avatars", "1"))
+			assert.True(t, os.IsNotExist(err))
+			user, err := userService.GetByID(1)
+			require.NoError(t, err)
+			assert.Nil(t, user.AvatarURL)
+		})
+	}
+}
+
+func TestUserHandler_UploadAvatar_UserNotFound(t *testing.T) {
+	router, userService, _ := setupAvatarRouter(t)
+	require.NoError(t, userService.Delete(1))
+
+	w := uploadAvatar(router, pngImage(t, 16, 16))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var response ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "not_found", response.Error)
+}
+
+func TestUserHandler_UploadAvatar_MissingFile(t *testing.T) {
+	router, _, _ := setupAvatarRouter(t)
+
+	req, _ := http.NewRequest("POST", "/users/me/avatar", bytes.NewBufferString("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_avatar")
+}
+
+// twoFactorClient drives the 2FA endpoints of a router backed by the real
+// user and JWT services
+type twoFactorClient struct {
+	t      *testing.T
+	router *gin.Engine
+}
+
+func setupTwoFactorRouter(t *testing.T) *twoFactorClient {
+	userService := services.NewUserService(repository.NewMemoryStore(), zap.NewNop())
+	secretCipher, err := services.NewSecretCipher("test-encryption-key")
+	require.NoError(t, err)
+	userService.SetTwoFactor(services.TwoFactorOptions{Issuer: "gin-service", Cipher: secretCipher, Skew: 1})
+
+	_, err = userService.Create(&models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	require.NoError(t, err)
+
+	cfg := &config.Config{
+		JWT:       config.JWTConfig{Secret: "test-secret", ExpirationTime: config.Duration(time.Hour), Issuer: "gin-service"},
+		TwoFactor: config.TwoFactorConfig{ChallengeTTL: time.Minute},
+	}
+	jwtService := middleware.NewJWTService(cfg, zap.NewNop())
+	handler := NewUserHandler(userService, jwtService, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/auth/login", handler.Login)
+	router.POST("/auth/2fa/verify", handler.VerifyTwoFactor)
+	router.POST("/users/me/cancel-deletion", middleware.CancelDeletionAuthMiddleware(jwtService, middleware.AuthMiddleware(jwtService)), handler.CancelAccountDeletion)
+	users := router.Group("/users", middleware.AuthMiddleware(jwtService))
+	users.GET("/profile", handler.GetProfile)
+	users.DELETE("/me", handler.DeleteAccount)
+	users.POST("/me/2fa/setup", handler.SetupTwoFactor)
+	users.POST("/me/2fa/enable", handler.EnableTwoFactor)
+
+	return &twoFactorClient{t: t, router: router}
+}
+
+// post sends body as JSON with an optional bearer token, decoding the
+// response into out when given
+func (c *twoFactorClient) post(path, token string, body, out interface{}) int {
+	reqBody, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	c.router.ServeHTTP(w, req)
+
+	if out != nil {
+		require.NoError(c.t, json.Unmarshal(w.Body.Bytes(), out), w.Body.String())
+	}
+	return w.Code
+}
+
+func (c *twoFactorClient) login() map[string]interface{} {
+	var response map[string]interface{}
+	code := c.post("/auth/login", "", models.LoginRequest{Username: "testuser", Password: "password123"}, &response)
+	require.Equal(c.t, http.StatusOK, code)
+	return response
+}
+
+// enable sets up and enables 2FA, returning the secret and recovery codes
+func (c *twoFactorClient) enable() (string, []string) {
+	token := c.login()["token"].(string)
+
+	var setup models.TwoFactorSetupResponse
+	require.Equal(c.t, http.StatusOK, c.post("/users/me/2fa/setup", token, nil, &setup))
+
+	totpCode, err := totp.GenerateCode(setup.Secret, time.Now())
+	require.NoError(c.t, err)
+	var enabled models.TwoFactorEnableResponse
+	require.Equal(c.t, http.StatusOK, c.post("/users/me/2fa/enable", token, models.TwoFactorEnableRequest{Code: totpCode}, &enabled))
+
+	return setup.Secret, enabled.RecoveryCodes
+}
+
+func TestUserHandler_SetupTwoFactor(t *testing.T) {
+	client := setupTwoFactorRouter(t)
+	token := client.login()["token"].(string)
+
+	var setup models.TwoFactorSetupResponse
+	code := client.post("/users/me/2fa/setup", token, nil, &setup)
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, setup.Secret)
+	assert.True(t, strings.HasPrefix(setup.OTPAuthURL, "otpauth://totp/"))
+	assert.True(t, strings.HasPrefix(setup.QRCode, "data:image/png;base64,"))
+}
+
+func TestUserHandler_EnableTwoFactor(t *testing.T) {
+	client := setupTwoFactorRouter(t)
+	token := client.login()["token"].(string)
+
+	var setup models.TwoFactorSetupResponse
+	require.Equal(t, http.StatusOK, client.post("/users/me/2fa/setup", token, nil, &setup))
+
+	// A wrong code leaves 2FA off
+	var errResponse ErrorResponse
+	code := client.post("/users/me/2fa/enable", token, models.TwoFactorEnableRequest{Code: "000000"}, &errResponse)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_two_factor_code", errResponse.Error)
+
+	totpCode, err := totp.GenerateCode(setup.Secret, time.Now())
+	require.NoError(t, err)
+	var enabled models.TwoFactorEnableResponse
+	code = client.post("/users/me/2fa/enable", token, models.TwoFactorEnableRequest{Code: totpCode}, &enabled)
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, enabled.User.TOTPEnabled)
+	assert.Len(t, enabled.RecoveryCodes, 10)
+
+	// Once enabled, setup can't be restarted
+	code = client.post("/users/me/2fa/setup", token, nil, &errResponse)
+	assert.Equal(t, http.StatusConflict, code)
+}
+
+func TestUserHandler_Login_RequiresSecondFactor(t *testing.T) {
+	client := setupTwoFactorRouter(t)
+	secret, _ := client.enable()
+
+	// The password alone yields a challenge, not an access token
+	response := client.login()
+	assert.Equal(t, true, response["two_factor_required"])
+	assert.NotContains(t, response, "token")
+	challenge := response["challenge_token"].(string)
+	assert.EqualValues(t, 60, response["expires_in"])
+
+	req, _ := http.NewRequest("GET", "/users/profile", nil)
+	req.Header.Set("Authorization", "Bearer "+challenge)
+	w := httptest.NewRecorder()
+	client.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "a challenge token is not an access token")
+
+	var errResponse ErrorResponse
+	code := client.post("/auth/2fa/verify", "", models.TwoFactorVerifyRequest{ChallengeToken: challenge, Code: "000000"}, &errResponse)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "invalid_two_factor_code", errResponse.Error)
+
+	totpCode, err := totp.GenerateCode(secret, time.Now())
+	require.NoError(t, err)
+	var loginResponse models.LoginResponse
+	code = client.post("/auth/2fa/verify", "", models.TwoFactorVerifyRequest{ChallengeToken: challenge, Code: totpCode}, &loginResponse)
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, loginResponse.Token)
+	assert.Equal(t, "testuser", loginResponse.User.Username)
+
+	// An access token can't stand in for a challenge
+	code = client.post("/auth/2fa/verify", "", models.TwoFactorVerifyRequest{ChallengeToken: loginResponse.Token, Code: totpCode}, &errResponse)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "invalid_challenge", errResponse.Error)
+}
+
+// scheduleDeletion requests the deletion of the user's account
+func (c *twoFactorClient) scheduleDeletion(token string) {
+	req, _ := http.NewRequest("DELETE", "/users/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	c.router.ServeHTTP(w, req)
+	require.Equal(c.t, http.StatusAccepted, w.Code, w.Body.String())
+}
+
+func TestUserHandler_Login_DeletionPending(t *testing.T) {
+	client := setupTwoFactorRouter(t)
+	client.scheduleDeletion(client.login()["token"].(string))
+
+	// The login is refused, handing out a token for cancelling the deletion
+	var pending models.DeletionPendingResponse
+	code := client.post("/auth/login", "", models.LoginRequest{Username: "testuser", Password: "password123"}, &pending)
+	require.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "account_deletion_scheduled", pending.Error)
+	assert.NotEmpty(t, pending.CancelToken)
+	assert.False(t, pending.DeletionScheduledAt.IsZero())
+	assert.Equal(t, 60, pending.ExpiresIn)
+
+	// The cancel token is no access token
+	req, _ := http.NewRequest("GET", "/users/profile", nil)
+	req.Header.Set("Authorization", "Bearer "+pending.CancelToken)
+	w := httptest.NewRecorder()
+	client.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var cancelled models.UserResponse
+	require.Equal(t, http.StatusOK, client.post("/users/me/cancel-deletion", pending.CancelToken, nil, &cancelled))
+	assert.True(t, cancelled.IsActive)
+
+	// The reactivated account logs in again
+	assert.NotEmpty(t, client.login()["token"])
+}
+
+func TestUserHandler_VerifyTwoFactor_DeletionPending(t *testing.T) {
+	client := setupTwoFactorRouter(t)
+	_, recoveryCodes := client.enable()
+	challenge := client.login()["challenge_token"].(string)
+	var loginResponse models.LoginResponse
+	require.Equal(t, http.StatusOK, client.post("/auth/2fa/verify", "", models.TwoFactorVerifyRequest{ChallengeToken: challenge, Code: recoveryCodes[0]}, &loginResponse))
+	client.scheduleDeletion(loginResponse.Token)
+
+	// The password alone doesn't yield a cancel token
+	response := client.login()
+	assert.Equal(t, true, response["two_factor_required"])
+	assert.NotContains(t, response, "cancel_token")
+
+	var pending models.DeletionPendingResponse
+	code := client.post("/auth/2fa/verify", "", models.TwoFactorVerifyRequest{ChallengeToken: response["challenge_token"].(string), Code: recoveryCodes[1]}, &pending)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.NotEmpty(t, pending.CancelToken)
+}
+
+func TestUserHandler_VerifyTwoFactor_RecoveryCodeWorksOnce(t *testing.T) {
+	client := setupTwoFactorRouter(t)
+	_, recoveryCodes := client.enable()
+
+	verify := func() int {
+		challenge := client.login()["challenge_token"].(string)
+		return client.post("/auth/2fa/verify", "", models.TwoFactorVerifyRequest{ChallengeToken: challenge, Code: recoveryCodes[0]}, nil)
+	}
+
+	assert.Equal(t, http.StatusOK, verify())
+	assert.Equal(t, http.StatusUnauthorized, verify())
+}
+
+func TestUserHandler_VerifyTwoFactor_ChallengeAndCodeWorkOnce(t *testing.T) {
+	client := setupTwoFactorRouter(t)
+	secret, recoveryCodes := client.enable()
+
+	totpCode, err := totp.GenerateCode(secret, time.Now())
+	require.NoError(t, err)
+	challenge := client.login()["challenge_token"].(string)
+	require.Equal(t, http.StatusOK, client.post("/auth/2fa/verify", "", models.TwoFactorVerifyRequest{ChallengeToken: challenge, Code: totpCode}, nil))
+
+	// The challenge completed its login
+	var errResponse ErrorResponse
+	code := client.post("/auth/2fa/verify", "", models.TwoFactorVerifyRequest{ChallengeToken: challenge, Code: recoveryCodes[0]}, &errResponse)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "invalid_challenge", errResponse.Error)
+
+	// And the TOTP code can't be replayed with a new one
+	challenge = client.login()["challenge_token"].(string)
+	code = client.post("/auth/2fa/verify", "", models.TwoFactorVerifyRequest{ChallengeToken: challenge, Code: totpCode}, &errResponse)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "invalid_two_factor_code", errResponse.Error)
+}
+
+func TestUserHandler_VerifyTwoFactor_LimitsWrongCodes(t *testing.T) {
+	client := setupTwoFactorRouter(t)
+	_, recoveryCodes := client.enable()
+
+	var errResponse ErrorResponse
+	guess := func(challenge string) {
+		for i := 0; i < 5; i++ {
+			code := client.post("/auth/2fa/verify", "", models.TwoFactorVerifyRequest{ChallengeToken: challenge, Code: "000000"}, &errResponse)
+			require.Equal(t, http.StatusUnauthorized, code)
+			assert.Equal(t, "invalid_two_factor_code", errResponse.Error)
+		}
+	}
+
+	// A challenge takes five wrong codes, then is refused
+	challenge := client.login()["challenge_token"].(string)
+	guess(challenge)
+	code := client.post("/auth/2fa/verify", "", models.TwoFactorVerifyRequest{ChallengeToken: challenge, Code: recoveryCodes[0]}, &errResponse)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "invalid_challenge", errResponse.Error)
+	guess(client.login()["challenge_token"].(string))
+
+	// And after ten wrong codes, the user's codes are refused for a while,
+	// whatever the challenge
+	challenge = client.login()["challenge_token"].(string)
+	reqBody, _ := json.Marshal(models.TwoFactorVerifyRequest{ChallengeToken: challenge, Code: recoveryCodes[0]})
+	req, _ := http.NewRequest("POST", "/auth/2fa/verify", bytes.NewBuffer(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	client.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "too_many_login_attempts")
+}
+
+// setupImpersonationRouter returns a router backed by the real services
+// with the admin routes, and the store with an admin and another user
+func setupImpersonationRouter(t *testing.T) (*gin.Engine, *repository.MemoryStore, *middleware.JWTService) {
+	store := repository.NewMemoryStore()
+	userService := services.NewUserService(store, zap.NewNop())
+	for _, username := range []string{"admin", "otheradmin"} {
+		_, err := userService.CreateAdmin(&models.CreateUserRequest{
+			Username: username,
+			Email:    username + "@example.com",
+			Password: "password123",
+		}, true)
+		require.NoError(t, err)
+	}
+
+	cfg := &config.Config{JWT: config.JWTConfig{Secret: "test-secret", ExpirationTime: config.Duration(time.Hour), ImpersonationExpiration: config.Duration(10 * time.Minute), Issuer: "gin-service"}}
+	jwtService := middleware.NewJWTService(cfg, zap.NewNop())
+	handler := NewUserHandler(userService, jwtService, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/users/profile/export", middleware.AuthMiddleware(jwtService), handler.ExportProfile)
+	admin := router.Group("/users", middleware.AuthMiddleware(jwtService), middleware.AdminMiddleware())
+	admin.GET("/:id/export", handler.ExportUser)
+	admin.DELETE("", middleware.DenyImpersonation(), handler.BatchDeleteUsers)
+	admin.DELETE("/:id", middleware.DenyImpersonation(), handler.DeleteUser)
+	admin.POST("/:id/impersonate", middleware.DenyImpersonation(), handler.ImpersonateUser)
+	admin.POST("/:id/promote", middleware.DenyImpersonation(), handler.PromoteUser)
+	admin.POST("/:id/demote", middleware.DenyImpersonation(), handler.DemoteUser)
+	admin.PUT("/:id/status", middleware.DenyImpersonation(), handler.UpdateUserStatus)
+	admin.POST("/:id/anonymize", middleware.DenyImpersonation(), handler.AnonymizeUser)
+	router.POST("/users/me/anonymize", middleware.AuthMiddleware(jwtService), middleware.DenyImpersonation(), handler.AnonymizeAccount)
+
+	return router, store, jwtService
+}
+
+func TestUserHandler_ImpersonateUser(t *testing.T) {
+	router, store, jwtService := setupImpersonationRouter(t)
+	adminToken, err := jwtService.GenerateToken(&models.User{ID: 1, Username: "admin", IsAdmin: true})
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("POST", "/users/2/impersonate", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response models.ImpersonationResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 2, response.User.ID)
+	assert.Equal(t, 1, response.ImpersonatedBy)
+	assert.Equal(t, 600, response.ExpiresIn)
+
+	claims, err := jwtService.ValidateToken(response.Token)
+	require.NoError(t, err)
+	assert.Equal(t, 2, claims.UserID)
+	assert.Equal(t, 1, claims.ImpersonatedBy)
+	assert.Equal(t, 10*time.Minute, claims.ExpiresAt.Sub(claims.IssuedAt.Time))
+
+	entries := store.AuditLogEntries()
+	entry := entries[len(entries)-1]
+	assert.Equal(t, models.AuditActionUserImpersonated, entry.Action)
+	assert.Equal(t, 2, *entry.UserID)
+	assert.Equal(t, 1, *entry.ActorID)
+}
+
+func TestUserHandler_ImpersonationTokenCannotDeleteUsers(t *testing.T) {
+	router, store, jwtService := setupImpersonationRouter(t)
+	// otheradmin is an admin, so only the impersonation check stands in the way
+	token, err := jwtService.GenerateImpersonationToken(&models.User{ID: 2, Username: "otheradmin", IsAdmin: true}, 1)
+	require.NoError(t, err)
+
+	for _, route := range [][2]string{{"DELETE", "/users/1"}, {"POST", "/users/1/impersonate"}} {
+		req, _ := http.NewRequest(route[0], route[1], nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code, route[1])
+		assert.Contains(t, w.Body.String(), "impersonation_forbidden", route[1])
+	}
+
+	user, err := store.Users().FindByID(1)
+	require.NoError(t, err)
+	assert.NotNil(t, user)
+}
+
+func TestUserHandler_ExportUserData(t *testing.T) {
+	router, _, jwtService := setupImpersonationRouter(t)
+	get := func(path string, user *models.User) *httptest.ResponseRecorder {
+		token, err := jwtService.GenerateToken(user)
+		require.NoError(t, err)
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	admin := &models.User{ID: 1, Username: "admin", IsAdmin: true}
+
+	// Users export their own data
+	w := get("/users/profile/export", &models.User{ID: 2, Username: "otheradmin"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, `attachment; filename="user-2-export.json"`, w.Header().Get("Content-Disposition"))
+	assert.NotContains(t, w.Body.String(), "password")
+
+	var bundle models.UserDataBundle
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &bundle))
+	assert.Equal(t, "otheradmin", bundle.Profile.Username)
+	require.NotEmpty(t, bundle.AuditLogs)
+	assert.Equal(t, models.AuditActionUserCreated, bundle.AuditLogs[0].Action)
+
+	// Admins can export anyone
+	w = get("/users/2/export", admin)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &bundle))
+	assert.Equal(t, 2, bundle.Profile.ID)
+
+	w = get("/users/99/export", admin)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Other users can only export themselves
+	w = get("/users/1/export", &models.User{ID: 2, Username: "otheradmin"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestUserHandler_Anonymize(t *testing.T) {
+	router, store, jwtService := setupImpersonationRouter(t)
+	post := func(path string, user *models.User) *httptest.ResponseRecorder {
+		token, err := jwtService.GenerateToken(user)
+		require.NoError(t, err)
+		req, _ := http.NewRequest("POST", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	admin := &models.User{ID: 1, Username: "admin", IsAdmin: true}
+
+	w := post("/users/2/anonymize", admin)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	user, err := store.Users().FindByID(2)
+	require.NoError(t, err)
+	assert.Equal(t, "deleted_2", user.Username)
+	assert.False(t, user.IsAdmin)
+
+	entries := store.AuditLogEntries()
+	entry := entries[len(entries)-1]
+	assert.Equal(t, models.AuditActionUserAnonymized, entry.Action)
+	assert.Equal(t, 1, *entry.ActorID)
+
+	// The last admin can't anonymize themselves
+	w = post("/users/me/anonymize", admin)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "last_admin")
+
+	w = post("/users/99/anonymize", admin)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUserHandler_PromoteAndDemoteUser(t *testing.T) {
+	router, store, jwtService := setupImpersonationRouter(t)
+	adminToken, err := jwtService.GenerateToken(&models.User{ID: 1, Username: "admin", IsAdmin: true})
+	require.NoError(t, err)
+
+	post := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, nil)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("/users/2/demote")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response models.UserResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.False(t, response.IsAdmin)
+
+	entries := store.AuditLogEntries()
+	entry := entries[len(entries)-1]
+	assert.Equal(t, models.AuditActionUserDemoted, entry.Action)
+	assert.Equal(t, 2, *entry.UserID)
+	assert.Equal(t, 1, *entry.ActorID)
+
+	// The only admin left can't be demoted
+	w = post("/users/1/demote")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "last_admin")
+
+	w = post("/users/2/promote")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.True(t, response.IsAdmin)
+
+	entries = store.AuditLogEntries()
+	assert.Equal(t, models.AuditActionUserPromoted, entries[len(entries)-1].Action)
+
+	w = post("/users/99/promote")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUserHandler_UpdateUserStatus(t *testing.T) {
+	router, store, jwtService := setupImpersonationRouter(t)
+	adminToken, err := jwtService.GenerateToken(&models.User{ID: 1, Username: "admin", IsAdmin: true})
+	require.NoError(t, err)
+
+	put := func(path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := put("/users/2/status", `{"status": "suspended"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response models.UserResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.StatusSuspended, response.Status)
+	assert.False(t, response.IsActive)
+
+	entries := store.AuditLogEntries()
+	entry := entries[len(entries)-1]
+	assert.Equal(t, models.AuditActionStatusChanged, entry.Action)
+	assert.Equal(t, 1, *entry.ActorID)
+
+	w = put("/users/2/status", `{"status": "banned"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = put("/users/99/status", `{"status": "active"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUserHandler_BatchDeleteUsers(t *testing.T) {
+	router, store, jwtService := setupImpersonationRouter(t)
+	adminToken, err := jwtService.GenerateToken(&models.User{ID: 1, Username: "admin", IsAdmin: true})
+	require.NoError(t, err)
+
+	batchDelete := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("DELETE", "/users", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The caller can't delete themselves, so nothing is deleted
+	w := batchDelete(`{"ids": [2, 1]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "self_deletion_not_allowed")
+
+	w = batchDelete(`{"ids": [2, 99, 2]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response models.BatchDeleteUsersResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 1, response.Deleted)
+	assert.Equal(t, []int{99}, response.Skipped)
+
+	user, err := store.Users().FindByID(2)
+	require.NoError(t, err)
+	assert.Nil(t, user)
+
+	w = batchDelete(`{"ids": []}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestUserHandler_WhoAmI(t *testing.T) {
+	cfg := &config.Config{JWT: config.JWTConfig{Secret: "test-secret", ExpirationTime: config.Duration(time.Hour), Issuer: "gin-service", Audience: "gin-service"}}
+	jwtService := middleware.NewJWTService(cfg, zap.NewNop())
+	handler, _, _ := setupUserHandler()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/auth/whoami", middleware.AuthMiddleware(jwtService), handler.WhoAmI)
+
+	token, err := jwtService.GenerateToken(&models.User{ID: 7, Username: "testuser", Email: "test@example.com", IsAdmin: true})
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/auth/whoami", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response models.WhoAmIResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 7, response.UserID)
+	assert.Equal(t, "testuser", response.Username)
+	assert.Equal(t, "test@example.com", response.Email)
+	assert.True(t, response.IsAdmin)
+	assert.Zero(t, response.ImpersonatedBy)
+	assert.Equal(t, "gin-service", response.Issuer)
+	assert.Equal(t, []string{"gin-service"}, response.Audience)
+	assert.Equal(t, time.Hour, response.ExpiresAt.Sub(response.IssuedAt))
+	assert.InDelta(t, 3600, response.ExpiresIn, 2)
+
+	// Without a token
+	req, _ = http.NewRequest("GET", "/auth/whoami", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestUserHandler_SearchUsers(t *testing.T) {
+	handler, mockUserService, _ := setupUserHandler()
+	mockUserService.On("Search", "alex", 20).Return([]*models.User{{ID: 1, Username: "alex"}, {ID: 2, Username: "alexandra"}}, nil)
+	mockUserService.On("Search", "alex", 100).Return([]*models.User{}, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/users/search", handler.SearchUsers)
+
+	req, _ := http.NewRequest("GET", "/users/search?q=+alex+", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response models.UserSearchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Data, 2)
+	assert.Equal(t, "alex", response.Data[0].Username)
+
+	// The limit is capped
+	req, _ = http.NewRequest("GET", "/users/search?q=alex&limit=1000", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("GET", "/users/search?q=", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockUserService.AssertExpectations(t)
+}
+
+func TestUserHandler_BatchGetUsers(t *testing.T) {
+	handler, mockUserService, _ := setupUserHandler()
+	// Duplicates are dropped before the lookup
+	mockUserService.On("GetByIDs", []int{3, 1, 42}).Return([]*models.User{
+		{ID: 1, Username: "first"},
+		{ID: 3, Username: "third"},
+	}, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/users/batch-get", handler.BatchGetUsers)
+
+	req, _ := http.NewRequest("POST", "/users/batch-get", bytes.NewBufferString(`{"ids":[3,1,3,42]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response models.BatchGetUsersResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Data, 2)
+	assert.Equal(t, "first", response.Data[1].Username)
+	assert.Equal(t, "third", response.Data[3].Username)
+	assert.NotContains(t, response.Data, 42)
+
+	mockUserService.AssertExpectations(t)
+}
+
+func TestUserHandler_BatchGetUsers_Rejects(t *testing.T) {
+	handler, mockUserService, _ := setupUserHandler()
+	handler.SetBatchGetMaxIDs(2)
+	mockUserService.On("GetByIDs", []int{1, 2}).Return([]*models.User{}, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/users/batch-get", handler.BatchGetUsers)
+
+	tests := []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"ids":[1,2,3]}`, http.StatusBadRequest, "too_many_ids"},
+		{`{"ids":[]}`, http.StatusBadRequest, "validation_error"},
+		{`{"ids":[1,0]}`, http.StatusBadRequest, "validation_error"},
+		// The cap counts distinct IDs
+		{`{"ids":[1,2,2,1]}`, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/users/batch-get", bytes.NewBufferString(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.status, w.Code, tt.body)
+		if tt.code != "" {
+			var response ErrorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.code, response.Error, tt.body)
+		}
+	}
+}
+
+// setupPatchRouter serves JSON Patch requests for users, backed by the real
+// service and the in-memory store holding user 1
+func setupPatchRouter(t *testing.T) (*gin.Engine, *services.UserService) {
+	userService := services.NewUserService(repository.NewMemoryStore(), zap.NewNop())
+	fullName := "Test User"
+	_, err := userService.Create(&models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+		FullName: &fullName,
+	})
+	require.NoError(t, err)
+	handler := NewUserHandler(userService, &MockJWTService{}, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PATCH("/users/:id", middleware.StrictJSON(), handler.PatchUser)
+	return router, userService
+}
+
+// patchUser sends a JSON Patch for user 1
+func patchUser(router *gin.Engine, patch string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("PATCH", "/users/1", strings.NewReader(patch))
+	req.Header.Set("Content-Type", models.JSONPatchContentType)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUserHandler_PatchUser_Replace(t *testing.T) {
+	router, userService := setupPatchRouter(t)
+
+	w := patchUser(router, `[
+		{"op": "test", "path": "/username", "value": "testuser"},
+		{"op": "replace", "path": "/username", "value": "renamed"},
+		{"op": "replace", "path": "/is_active", "value": false}
+	]`)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response models.UserResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "renamed", response.Username)
+	assert.False(t, response.IsActive)
+
+	user, err := userService.GetByID(1)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", user.Username)
+	assert.False(t, user.IsActive)
+}
+
+func TestUserHandler_PatchUser_RemoveOptionalField(t *testing.T) {
+	router, userService := setupPatchRouter(t)
+
+	w := patchUser(router, `[{"op": "remove", "path": "/full_name"}]`)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	user, err := userService.GetByID(1)
+	require.NoError(t, err)
+	assert.Nil(t, user.FullName)
+	assert.Equal(t, "testuser", user.Username)
+}
+
+func TestUserHandler_PatchUser_FailedTest(t *testing.T) {
+	router, userService := setupPatchRouter(t)
+
+	w := patchUser(router, `[
+		{"op": "test", "path": "/username", "value": "someoneelse"},
+		{"op": "replace", "path": "/username", "value": "renamed"}
+	]`)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var response ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "patch_test_failed", response.Error)
+
+	user, err := userService.GetByID(1)
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", user.Username)
+}
+
+func TestUserHandler_PatchUser_Rejects(t *testing.T) {
+	router, _ := setupPatchRouter(t)
+
+	tests := []struct {
+		patch  string
+		status int
+		code   string
+	}{
+		{`[{"op": "replace", "path": "/id", "value": 2}]`, http.StatusUnprocessableEntity, "protected_field"},
+		{`[{"op": "add", "path": "/password_hash", "value": "x"}]`, http.StatusUnprocessableEntity, "protected_field"},
+		{`[{"op": "remove", "path": "/created_at"}]`, http.StatusUnprocessableEntity, "protected_field"},
+		{`[{"op": "move", "from": "/is_admin", "path": "/is_active"}]`, http.StatusUnprocessableEntity, "protected_field"},
+		{`[{"op": "remove", "path": "/username"}]`, http.StatusBadRequest, "validation_error"},
+		{`[{"op": "replace", "path": "/email", "value": "not-an-email"}]`, http.StatusBadRequest, "validation_error"},
+		{`[{"op": "replace", "path": "/nickname", "value": "x"}]`, http.StatusUnprocessableEntity, "protected_field"},
+		{`{"username": "renamed"}`, http.StatusBadRequest, "invalid_patch"},
+	}
+
+	for _, tt := range tests {
+		w := patchUser(router, tt.patch)
+
+		assert.Equal(t, tt.status, w.Code, tt.patch)
+		var response ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, tt.code, response.Error, tt.patch)
+	}
+}
+
+func TestUserHandler_PatchUser_PartialUpdate(t *testing.T) {
+	router, userService := setupPatchRouter(t)
+
+	// Without the JSON Patch content type, the body is a partial update
+	req, _ := http.NewRequest("PATCH", "/users/1", strings.NewReader(`{"username": "renamed"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	user, err := userService.GetByID(1)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", user.Username)
+}
+
+func TestUserHandler_PatchUser_UnknownField(t *testing.T) {
+	router, userService := setupPatchRouter(t)
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PATCH", "/users/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := patch(`{"username": "renamed", "fullname": "Renamed"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	var response ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "unknown_field", response.Error)
+	assert.Contains(t, response.Message, `"fullname"`)
+	user, err := userService.GetByID(1)
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", user.Username, "nothing is applied")
+
+	// An explicit null still clears a nullable field
+	w = patch(`{"full_name": null}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	user, err = userService.GetByID(1)
+	require.NoError(t, err)
+	assert.Nil(t, user.FullName)
+}
+
+func TestUserHandler_Profile_CannotDeactivate(t *testing.T) {
+	userService := services.NewUserService(repository.NewMemoryStore(), zap.NewNop())
+	_, err := userService.Create(&models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	require.NoError(t, err)
+	handler := NewUserHandler(userService, &MockJWTService{}, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	asUser := func(next gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Set("user_id", 1)
+			next(c)
+		}
+	}
+	router.PUT("/users/profile", asUser(handler.UpdateProfile))
+	router.PATCH("/users/profile", asUser(handler.PatchProfile))
+	router.PATCH("/strict/users/profile", middleware.StrictJSON(), asUser(handler.PatchProfile))
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// is_active is ignored, the rest of the update applied
+	w := send("PATCH", "/users/profile", `{"full_name": "Test User", "is_active": false}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = send("PUT", "/users/profile", `{"username": "renamed", "email": "test@example.com", "is_active": false}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	user, err := userService.GetByID(1)
+	require.NoError(t, err)
+	assert.True(t, user.IsActive)
+	assert.Equal(t, "renamed", user.Username)
+
+	// Routes with strict JSON reject it as an unknown field
+	w = send("PATCH", "/strict/users/profile", `{"is_active": false}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	var response ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "unknown_field", response.Error)
+
+	user, err = userService.GetByID(1)
+	require.NoError(t, err)
+	assert.True(t, user.IsActive)
+}
+
+func TestUserHandler_UpdateUser_ReplacesUser(t *testing.T) {
+	userService := services.NewUserService(repository.NewMemoryStore(), zap.NewNop())
+	fullName := "Test User"
+	_, err := userService.Create(&models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+		FullName: &fullName,
+	})
+	require.NoError(t, err)
+	handler := NewUserHandler(userService, &MockJWTService{}, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PUT("/users/:id", handler.UpdateUser)
+	router.PUT("/users/profile", func(c *gin.Context) {
+		c.Set("user_id", 1)
+		handler.UpdateProfile(c)
+	})
+
+	// Partial bodies are for PATCH
+	for _, path := range []string{"/users/1", "/users/profile"} {
+		req, _ := http.NewRequest("PUT", path, strings.NewReader(`{"username": "renamed"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+		var response ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "validation_error", response.Error, path)
+	}
+
+	// The omitted full name is cleared
+	req, _ := http.NewRequest("PUT", "/users/1", strings.NewReader(
+		`{"username": "renamed", "email": "test@example.com", "is_active": false}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	user, err := userService.GetByID(1)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", user.Username)
+	assert.False(t, user.IsActive)
+	assert.Nil(t, user.FullName)
+	assert.NoError(t, user.CheckPassword("password123"))
+}
+
+// msgpackRequest sends body encoded as MessagePack, asking for a MessagePack
+// response
+func msgpackRequest(t *testing.T, router *gin.Engine, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var data []byte
+	require.NoError(t, codec.NewEncoderBytes(&data, msgpackHandle).Encode(body))
+	req, _ := http.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/msgpack")
+	req.Header.Set("Accept", "application/msgpack")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUserHandler_PatchUser_MessagePack(t *testing.T) {
+	router, userService := setupPatchRouter(t)
+
+	// Bound like JSON, down to the explicit null clearing the full name
+	w := msgpackRequest(t, router, "PATCH", "/users/1", map[string]interface{}{"username": "renamed", "full_name": nil})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/msgpack", w.Header().Get("Content-Type"))
+	var response map[string]interface{}
+	require.NoError(t, codec.NewDecoderBytes(w.Body.Bytes(), msgpackHandle).Decode(&response))
+	assert.Equal(t, "renamed", response["username"])
+	assert.NotContains(t, response, "full_name")
+
+	user, err := userService.GetByID(1)
+	require.NoError(t, err)
+	assert.Nil(t, user.FullName)
+
+	// Unknown fields are rejected on strict routes, and errors are
+	// MessagePack too
+	w = msgpackRequest(t, router, "PATCH", "/users/1", map[string]interface{}{"nickname": "x"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var errResponse ErrorResponse
+	require.NoError(t, codec.NewDecoderBytes(w.Body.Bytes(), msgpackHandle).Decode(&errResponse))
+	assert.Equal(t, "unknown_field", errResponse.Error)
+
+	// A map cut off before its only entry
+	req, _ := http.NewRequest("PATCH", "/users/1", bytes.NewReader([]byte{0x81}))
+	req.Header.Set("Content-Type", "application/msgpack")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid MessagePack body")
+}
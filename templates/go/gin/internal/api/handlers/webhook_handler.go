@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"gin-service/internal/api/middleware"
+	"gin-service/internal/models"
+	"gin-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// WebhookHandler handles the admin endpoints registering webhooks
+type WebhookHandler struct {
+	webhooks *services.WebhookService
+}
+
+// NewWebhookHandler creates a new webhook handler
+func NewWebhookHandler(webhooks *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{webhooks: webhooks}
+}
+
+// CreateWebhook godoc
+// @Summary Register a webhook
+// @Description Register a URL notified of user events (admin only). The secret signing deliveries is generated unless given, and only returned here.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param webhook body models.CreateWebhookRequest true "Webhook"
+// @Success 201 {object} models.WebhookCreatedResponse
+// @Header 201 {string} Location "Path of the created webhook"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/webhooks [post]
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	var req models.CreateWebhookRequest
+	if err := bindBody(c, &req); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	webhook, err := h.webhooks.Create(&req)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to create webhook", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "webhook_creation_failed", "Failed to create webhook")
+		return
+	}
+
+	RespondCreated(c, "/api/v1/admin/webhooks/"+strconv.Itoa(webhook.ID), models.WebhookCreatedResponse{Webhook: webhook, Secret: webhook.Secret})
+}
+
+// ListWebhooks godoc
+// @Summary List webhooks
+// @Description List the registered webhooks (admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.WebhookListResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/webhooks [get]
+func (h *WebhookHandler) ListWebhooks(c *gin.Context) {
+	webhooks, err := h.webhooks.List()
+	if err != nil {
+		middleware.Logger(c).Error("Failed to list webhooks", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to list webhooks")
+		return
+	}
+	if webhooks == nil {
+		webhooks = []*models.Webhook{}
+	}
+
+	Respond(c, http.StatusOK, models.WebhookListResponse{Data: webhooks})
+}
+
+// GetWebhook godoc
+// @Summary Get a webhook
+// @Description Get a registered webhook by ID (admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} models.Webhook
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/webhooks/{id} [get]
+func (h *WebhookHandler) GetWebhook(c *gin.Context) {
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	webhook, err := h.webhooks.Get(id)
+	if err != nil {
+		respondWebhookError(c, err, id)
+		return
+	}
+
+	Respond(c, http.StatusOK, webhook)
+}
+
+// UpdateWebhook godoc
+// @Summary Update a webhook
+// @Description Change a webhook's URL, secret, event types or active flag (admin only). Omitted fields are left unchanged.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Param webhook body models.UpdateWebhookRequest true "Webhook changes"
+// @Success 200 {object} models.Webhook
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/webhooks/{id} [put]
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateWebhookRequest
+	if err := bindBody(c, &req); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	webhook, err := h.webhooks.Update(id, &req)
+	if err != nil {
+		respondWebhookError(c, err, id)
+		return
+	}
+
+	Respond(c, http.StatusOK, webhook)
+}
+
+// DeleteWebhook godoc
+// @Summary Delete a webhook
+// @Description Delete a webhook along with its deliveries (admin only)
+// @Tags admin
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /admin/webhooks/{id} [delete]
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.webhooks.Delete(id); err != nil {
+		respondWebhookError(c, err, id)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListDeliveries godoc
+// @Summary List webhook deliveries
+// @Description List the latest 100 webhook deliveries, optionally of one webhook or with one status; dead deliveries failed on every attempt (admin only)
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param webhook_id query int false "Webhook ID"
+// @Param status query string false "Delivery status" Enums(pending, delivered, dead)
+// @Success 200 {object} models.WebhookDeliveryListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/webhooks/deliveries [get]
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	var id int
+	if value := c.Query("webhook_id"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			RespondError(c, http.StatusBadRequest, "invalid_filter", "webhook_id must be a positive integer")
+			return
+		}
+		id = parsed
+	}
+
+	status := c.Query("status")
+	switch status {
+	case "", models.WebhookDeliveryPending, models.WebhookDeliveryDelivered, models.WebhookDeliveryDead:
+	default:
+		RespondError(c, http.StatusBadRequest, "invalid_filter", "status must be pending, delivered or dead")
+		return
+	}
+
+	deliveries, err := h.webhooks.ListDeliveries(id, status)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to list webhook deliveries", zap.Error(err))
+		RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to list webhook deliveries")
+		return
+	}
+	if deliveries == nil {
+		deliveries = []*models.WebhookDelivery{}
+	}
+
+	Respond(c, http.StatusOK, models.WebhookDeliveryListResponse{Data: deliveries})
+}
+
+// webhookID parses the webhook ID path parameter, responding 400 when it
+// is invalid
+func webhookID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		RespondError(c, http.StatusBadRequest, "invalid_webhook_id", "Invalid webhook ID format")
+		return 0, false
+	}
+	return id, true
+}
+
+// respondWebhookError responds to an error of the webhook service
+func respondWebhookError(c *gin.Context, err error, id int) {
+	if errors.Is(err, services.ErrWebhookNotFound) {
+		RespondError(c, http.StatusNotFound, "webhook_not_found", "Webhook not found")
+		return
+	}
+	middleware.Logger(c).Error("Webhook operation failed", zap.Error(err), zap.Int("webhook_id", id))
+	RespondError(c, http.StatusInternalServerError, "internal_error", "Failed to process webhook")
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+	"gin-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func setupWebhookRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	handler := NewWebhookHandler(services.NewWebhookService(repository.NewMemoryStore(), zap.NewNop()))
+
+	router := gin.New()
+	router.POST("/webhooks", handler.CreateWebhook)
+	router.GET("/webhooks", handler.ListWebhooks)
+	router.GET("/webhooks/deliveries", handler.ListDeliveries)
+	router.GET("/webhooks/:id", handler.GetWebhook)
+	router.PUT("/webhooks/:id", handler.UpdateWebhook)
+	router.DELETE("/webhooks/:id", handler.DeleteWebhook)
+	return router
+}
+
+func serveWebhookRequest(router *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestWebhookHandler_CRUD(t *testing.T) {
+	router := setupWebhookRouter()
+
+	w := serveWebhookRequest(router, "POST", "/webhooks", `{"url":"https://example.com/hooks","event_types":["user.created"]}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created models.WebhookCreatedResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.Secret, "the generated secret is returned on creation")
+	assert.True(t, created.Active)
+	assert.Equal(t, fmt.Sprintf("/api/v1/admin/webhooks/%d", created.ID), w.Header().Get("Location"))
+
+	// The secret isn't shown again
+	path := fmt.Sprintf("/webhooks/%d", created.ID)
+	w = serveWebhookRequest(router, "GET", path, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "secret")
+
+	w = serveWebhookRequest(router, "PUT", path, `{"active":false,"event_types":["user.updated","user.deleted"]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var updated models.Webhook
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.False(t, updated.Active)
+	assert.Equal(t, "https://example.com/hooks", updated.URL)
+	assert.Equal(t, []string{"user.updated", "user.deleted"}, []string(updated.EventTypes))
+
+	w = serveWebhookRequest(router, "GET", "/webhooks", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list models.WebhookListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list.Data, 1)
+
+	w = serveWebhookRequest(router, "DELETE", path, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = serveWebhookRequest(router, "DELETE", path, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = serveWebhookRequest(router, "GET", path, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestWebhookHandler_CreateWebhook_Invalid(t *testing.T) {
+	router := setupWebhookRouter()
+
+	for _, body := range []string{
+		`{"event_types":["user.created"]}`,
+		`{"url":"ftp://example.com/hooks","event_types":["user.created"]}`,
+		`{"url":"https://example.com/hooks","event_types":[]}`,
+		`{"url":"https://example.com/hooks","event_types":["user.logged_in"]}`,
+		`{"url":"https://example.com/hooks","event_types":["user.created"],"secret":"short"}`,
+	} {
+		w := serveWebhookRequest(router, "POST", "/webhooks", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+func TestWebhookHandler_ListDeliveries(t *testing.T) {
+	router := setupWebhookRouter()
+
+	w := serveWebhookRequest(router, "GET", "/webhooks/deliveries?status=dead", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"data":[]}`, w.Body.String())
+
+	w = serveWebhookRequest(router, "GET", "/webhooks/deliveries?status=lost", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serveWebhookRequest(router, "GET", "/webhooks/deliveries?webhook_id=abc", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
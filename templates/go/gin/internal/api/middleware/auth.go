@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -11,6 +13,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
@@ -18,54 +21,190 @@ import (
 type JWTServiceInterface interface {
 	GenerateToken(user *models.User) (string, error)
 	ValidateToken(tokenString string) (*Claims, error)
+	// GenerateChallengeToken issues a short-lived token proving the user
+	// passed the password step of a login requiring a second factor
+	GenerateChallengeToken(user *models.User) (string, error)
+	ValidateChallengeToken(tokenString string) (*Claims, error)
+	ChallengeTTL() time.Duration
+	// GenerateImpersonationToken issues a short-lived access token for user
+	// on behalf of the admin adminID
+	GenerateImpersonationToken(user *models.User, adminID int) (string, error)
+	ImpersonationTTL() time.Duration
+	// GenerateCancelDeletionToken issues a short-lived token letting a user
+	// whose account is pending deletion cancel it, and do nothing else
+	GenerateCancelDeletionToken(user *models.User) (string, error)
+	ValidateCancelDeletionToken(tokenString string) (*Claims, error)
 }
 
+// PurposeTwoFactor marks challenge tokens, which only grant completing a
+// two-factor login
+const PurposeTwoFactor = "2fa"
+
+// PurposeCancelDeletion marks tokens which only grant cancelling the
+// account's scheduled deletion
+const PurposeCancelDeletion = "cancel_deletion"
+
+// ErrWrongTokenPurpose is returned when a token is used for something other
+// than what it was issued for
+var ErrWrongTokenPurpose = errors.New("token was issued for another purpose")
+
+// ErrForeignToken is returned for a validly signed token whose audience or
+// issuer is not this service's, typically one minted by another service
+// sharing the signing secret. It wraps jwt.ErrTokenInvalidAudience or
+// jwt.ErrTokenInvalidIssuer, telling it apart from jwt.ErrTokenExpired.
+var ErrForeignToken = errors.New("token was issued by or for another service")
+
+// ErrUnknownKeyID is returned for a token whose kid header names no key in
+// the keyset, such as a key retired after a rotation
+var ErrUnknownKeyID = errors.New("token signed with an unknown key")
+
 // Claims represents JWT claims
 type Claims struct {
 	UserID   int    `json:"user_id"`
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	IsAdmin  bool   `json:"is_admin"`
+	// Purpose is empty for access tokens
+	Purpose string `json:"purpose,omitempty"`
+	// ImpersonatedBy is the ID of the admin acting as the user, if any
+	ImpersonatedBy int `json:"impersonated_by,omitempty"`
 	jwt.RegisteredClaims
 }
 
 // JWTService handles JWT operations
 type JWTService struct {
-	secret     []byte
-	expiration time.Duration
-	issuer     string
-	logger     *zap.Logger
+	// keys holds the verification keys by key ID. Tokens without a kid are
+	// checked against the key with the empty ID, the legacy secret.
+	keys map[string][]byte
+	// currentKeyID names the key signing new tokens
+	currentKeyID string
+	expiration   time.Duration
+	// maxExpiration caps the lifetime of every token, whatever lifetime is
+	// asked for; 0 leaves lifetimes uncapped
+	maxExpiration time.Duration
+	challengeTTL  time.Duration
+	// impersonationTTL is the lifetime of impersonation tokens
+	impersonationTTL time.Duration
+	issuer           string
+	audience         string
+	leeway           time.Duration
+	logger           *zap.Logger
 }
 
 // NewJWTService creates a new JWT service
 func NewJWTService(cfg *config.Config, logger *zap.Logger) *JWTService {
+	challengeTTL := cfg.TwoFactor.ChallengeTTL
+	if challengeTTL <= 0 {
+		challengeTTL = 5 * time.Minute
+	}
+	impersonationTTL := cfg.JWT.ImpersonationExpiration.Duration()
+	if impersonationTTL <= 0 {
+		impersonationTTL = 15 * time.Minute
+	}
+
+	keys := make(map[string][]byte, len(cfg.JWT.Keys)+1)
+	if cfg.JWT.Secret != "" {
+		keys[""] = []byte(cfg.JWT.Secret)
+	}
+	for kid, secret := range cfg.JWT.Keys {
+		keys[kid] = []byte(secret)
+	}
+
 	return &JWTService{
-		secret:     []byte(cfg.JWT.Secret),
-		expiration: time.Duration(cfg.JWT.ExpirationTime) * time.Second,
-		issuer:     cfg.JWT.Issuer,
-		logger:     logger,
+		keys:             keys,
+		currentKeyID:     cfg.JWT.CurrentKey,
+		expiration:       cfg.JWT.ExpirationTime.Duration(),
+		maxExpiration:    cfg.JWT.MaxExpirationTime.Duration(),
+		challengeTTL:     challengeTTL,
+		impersonationTTL: impersonationTTL,
+		leeway:           cfg.JWT.Leeway.Duration(),
+		issuer:           cfg.JWT.Issuer,
+		audience:         cfg.JWT.Audience,
+		logger:           logger,
 	}
 }
 
 // GenerateToken generates a JWT token for a user
 func (j *JWTService) GenerateToken(user *models.User) (string, error) {
-	now := time.Now()
-	claims := &Claims{
+	return j.generate(user, "", j.expiration)
+}
+
+// GenerateChallengeToken generates a two-factor challenge token for a user,
+// with a unique ID by which its attempts are tracked
+func (j *JWTService) GenerateChallengeToken(user *models.User) (string, error) {
+	claims := j.userClaims(user, PurposeTwoFactor)
+	claims.ID = uuid.NewString()
+	return j.sign(claims, j.challengeTTL)
+}
+
+// ChallengeTTL returns the lifetime of challenge tokens
+func (j *JWTService) ChallengeTTL() time.Duration {
+	return j.challengeTTL
+}
+
+// GenerateImpersonationToken generates an access token for user carrying
+// the ID of the admin impersonating them
+func (j *JWTService) GenerateImpersonationToken(user *models.User, adminID int) (string, error) {
+	claims := j.userClaims(user, "")
+	claims.ImpersonatedBy = adminID
+	return j.sign(claims, j.impersonationTTL)
+}
+
+// GenerateCancelDeletionToken generates a token for user only good for
+// cancelling their account's deletion, living as long as a challenge token
+func (j *JWTService) GenerateCancelDeletionToken(user *models.User) (string, error) {
+	return j.generate(user, PurposeCancelDeletion, j.challengeTTL)
+}
+
+// ImpersonationTTL returns the lifetime of impersonation tokens
+func (j *JWTService) ImpersonationTTL() time.Duration {
+	return j.impersonationTTL
+}
+
+// generate signs a token for user with the given purpose and lifetime
+func (j *JWTService) generate(user *models.User, purpose string, ttl time.Duration) (string, error) {
+	return j.sign(j.userClaims(user, purpose), ttl)
+}
+
+// userClaims returns the claims identifying user, for a token with purpose
+func (j *JWTService) userClaims(user *models.User, purpose string) *Claims {
+	var audience jwt.ClaimStrings
+	if j.audience != "" {
+		audience = jwt.ClaimStrings{j.audience}
+	}
+
+	return &Claims{
 		UserID:   user.ID,
 		Username: user.Username,
 		Email:    user.Email,
 		IsAdmin:  user.IsAdmin,
+		Purpose:  purpose,
 		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    j.issuer,
-			Subject:   strconv.Itoa(user.ID),
-			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(j.expiration)),
-			NotBefore: jwt.NewNumericDate(now),
+			Issuer:   j.issuer,
+			Subject:  strconv.Itoa(user.ID),
+			Audience: audience,
 		},
 	}
+}
+
+// sign sets the validity period of claims to start now and last ttl, at
+// most the maximum expiration, then signs them. The configured expirations
+// are checked against the maximum at startup.
+func (j *JWTService) sign(claims *Claims, ttl time.Duration) (string, error) {
+	if j.maxExpiration > 0 && ttl > j.maxExpiration {
+		ttl = j.maxExpiration
+	}
+
+	now := time.Now()
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	claims.NotBefore = jwt.NewNumericDate(now)
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(j.secret)
+	if j.currentKeyID != "" {
+		token.Header["kid"] = j.currentKeyID
+	}
+	tokenString, err := token.SignedString(j.keys[j.currentKeyID])
 	if err != nil {
 		j.logger.Error("Failed to generate JWT token", zap.Error(err))
 		return "", err
@@ -74,21 +213,63 @@ func (j *JWTService) GenerateToken(user *models.User) (string, error) {
 	return tokenString, nil
 }
 
-// ValidateToken validates a JWT token and returns the claims
+// ValidateToken validates an access token and returns the claims.
+// Challenge tokens are rejected.
 func (j *JWTService) ValidateToken(tokenString string) (*Claims, error) {
+	return j.validate(tokenString, "")
+}
+
+// ValidateChallengeToken validates a two-factor challenge token and returns
+// the claims
+func (j *JWTService) ValidateChallengeToken(tokenString string) (*Claims, error) {
+	return j.validate(tokenString, PurposeTwoFactor)
+}
+
+// ValidateCancelDeletionToken validates a token for cancelling an account's
+// deletion and returns the claims
+func (j *JWTService) ValidateCancelDeletionToken(tokenString string) (*Claims, error) {
+	return j.validate(tokenString, PurposeCancelDeletion)
+}
+
+// validate validates a token issued for purpose and returns the claims. The
+// token's issuer and audience must match the service's, when configured.
+// Expiry, not-before and issue times are checked with the leeway.
+func (j *JWTService) validate(tokenString, purpose string) (*Claims, error) {
+	opts := []jwt.ParserOption{jwt.WithLeeway(j.leeway), jwt.WithIssuedAt()}
+	if j.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(j.issuer))
+	}
+	if j.audience != "" {
+		opts = append(opts, jwt.WithAudience(j.audience))
+	}
+
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, jwt.ErrSignatureInvalid
 		}
-		return j.secret, nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		key, ok := j.keys[kid]
+		if !ok {
+			return nil, ErrUnknownKeyID
+		}
+		return key, nil
+	}, opts...)
 
 	if err != nil {
+		if errors.Is(err, jwt.ErrTokenInvalidAudience) || errors.Is(err, jwt.ErrTokenInvalidIssuer) {
+			err = fmt.Errorf("%w: %w", ErrForeignToken, err)
+			j.logger.Warn("Token from another service rejected", zap.Error(err))
+			return nil, err
+		}
 		j.logger.Debug("Token validation failed", zap.Error(err))
 		return nil, err
 	}
 
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		if claims.Purpose != purpose {
+			j.logger.Debug("Token validation failed", zap.Error(ErrWrongTokenPurpose))
+			return nil, ErrWrongTokenPurpose
+		}
 		return claims, nil
 	}
 
@@ -100,42 +281,76 @@ func AuthMiddleware(jwtService JWTServiceInterface) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":   "unauthorized",
-				"message": "authorization header is required",
-			})
-			c.Abort()
+			AbortWithError(c, http.StatusUnauthorized, "unauthorized", "authorization header is required")
 			return
 		}
 
 		// Extract token from "Bearer <token>"
 		tokenParts := strings.SplitN(authHeader, " ", 2)
 		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":   "unauthorized",
-				"message": "invalid authorization header format",
-			})
-			c.Abort()
+			AbortWithError(c, http.StatusUnauthorized, "unauthorized", "invalid authorization header format")
 			return
 		}
 
 		token := tokenParts[1]
 		claims, err := jwtService.ValidateToken(token)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":   "unauthorized",
-				"message": "invalid or expired token",
-			})
-			c.Abort()
+			AbortWithError(c, http.StatusUnauthorized, "unauthorized", "invalid or expired token")
+			return
+		}
+
+		setAuthContext(c, claims)
+
+		c.Next()
+	}
+}
+
+// CancelDeletionAuthMiddleware authenticates requests carrying a token for
+// cancelling the account's deletion, which login hands out to accounts
+// pending deletion. Requests with any other token are passed to next, the
+// authentication middleware.
+func CancelDeletionAuthMiddleware(jwtService JWTServiceInterface, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenParts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+			next(c)
+			return
+		}
+
+		claims, err := jwtService.ValidateCancelDeletionToken(tokenParts[1])
+		if err != nil {
+			next(c)
 			return
 		}
 
-		// Set user information in context
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("email", claims.Email)
-		c.Set("is_admin", claims.IsAdmin)
-		c.Set("claims", claims)
+		setAuthContext(c, claims)
+
+		c.Next()
+	}
+}
+
+// setAuthContext sets the authenticated user's information in the context
+func setAuthContext(c *gin.Context, claims *Claims) {
+	c.Set("user_id", claims.UserID)
+	c.Set("username", claims.Username)
+	c.Set("email", claims.Email)
+	c.Set("is_admin", claims.IsAdmin)
+	c.Set("claims", claims)
+	if claims.ImpersonatedBy != 0 {
+		c.Set("impersonated_by", claims.ImpersonatedBy)
+	}
+	setUserLogger(c, claims)
+}
+
+// DenyImpersonation forbids the route to impersonation tokens, so that an
+// admin acting as another user can't perform destructive actions with
+// that user's privileges
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := GetImpersonatedBy(c); ok {
+			AbortWithError(c, http.StatusForbidden, "impersonation_forbidden", "this action is not allowed while impersonating a user")
+			return
+		}
 
 		c.Next()
 	}
@@ -146,11 +361,7 @@ func AdminMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		isAdmin, exists := c.Get("is_admin")
 		if !exists || !isAdmin.(bool) {
-			c.JSON(http.StatusForbidden, gin.H{
-				"error":   "forbidden",
-				"message": "admin privileges required",
-			})
-			c.Abort()
+			AbortWithError(c, http.StatusForbidden, "forbidden", "admin privileges required")
 			return
 		}
 
@@ -181,12 +392,7 @@ func OptionalAuthMiddleware(jwtService *JWTService) gin.HandlerFunc {
 			return
 		}
 
-		// Set user information in context
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("email", claims.Email)
-		c.Set("is_admin", claims.IsAdmin)
-		c.Set("claims", claims)
+		setAuthContext(c, claims)
 
 		c.Next()
 	}
@@ -210,6 +416,16 @@ func GetUsername(c *gin.Context) (string, bool) {
 	return username.(string), true
 }
 
+// GetImpersonatedBy gets the ID of the admin impersonating the user, if the
+// request was made with an impersonation token
+func GetImpersonatedBy(c *gin.Context) (int, bool) {
+	adminID, exists := c.Get("impersonated_by")
+	if !exists {
+		return 0, false
+	}
+	return adminID.(int), true
+}
+
 // GetClaims gets the JWT claims from the context
 func GetClaims(c *gin.Context) (*Claims, bool) {
 	claims, exists := c.Get("claims")
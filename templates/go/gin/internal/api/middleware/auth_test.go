@@ -0,0 +1,356 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gin-service/internal/config"
+	"gin-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newTestJWTService() *JWTService {
+	cfg := &config.Config{JWT: config.JWTConfig{
+		Secret:                  "test-secret",
+		ExpirationTime:          config.Duration(time.Hour),
+		ImpersonationExpiration: config.Duration(10 * time.Minute),
+		Issuer:                  "test",
+		Audience:                "test-service",
+	}}
+	return NewJWTService(cfg, zap.NewNop())
+}
+
+func TestJWTService_GenerateImpersonationToken(t *testing.T) {
+	jwtService := newTestJWTService()
+
+	token, err := jwtService.GenerateImpersonationToken(&models.User{ID: 42, Username: "testuser"}, 1)
+	require.NoError(t, err)
+
+	claims, err := jwtService.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, 42, claims.UserID)
+	assert.Equal(t, 1, claims.ImpersonatedBy)
+	assert.Equal(t, 10*time.Minute, claims.ExpiresAt.Sub(claims.IssuedAt.Time))
+	assert.Equal(t, 10*time.Minute, jwtService.ImpersonationTTL())
+
+	// Regular access tokens carry no impersonator and last longer
+	token, err = jwtService.GenerateToken(&models.User{ID: 42, Username: "testuser"})
+	require.NoError(t, err)
+	claims, err = jwtService.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Zero(t, claims.ImpersonatedBy)
+	assert.Equal(t, time.Hour, claims.ExpiresAt.Sub(claims.IssuedAt.Time))
+}
+
+func TestJWTService_ValidateToken_AudienceAndIssuer(t *testing.T) {
+	jwtService := newTestJWTService()
+	user := &models.User{ID: 42, Username: "testuser"}
+
+	token, err := jwtService.GenerateToken(user)
+	require.NoError(t, err)
+	claims, err := jwtService.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"test-service"}, []string(claims.Audience))
+	assert.Equal(t, "test", claims.Issuer)
+
+	// Services sharing the secret but not the audience or issuer
+	for name, cfg := range map[string]config.JWTConfig{
+		"audience": {Secret: "test-secret", ExpirationTime: config.Duration(time.Hour), Issuer: "test", Audience: "other-service"},
+		"issuer":   {Secret: "test-secret", ExpirationTime: config.Duration(time.Hour), Issuer: "other", Audience: "test-service"},
+	} {
+		other := NewJWTService(&config.Config{JWT: cfg}, zap.NewNop())
+		foreignToken, err := other.GenerateToken(user)
+		require.NoError(t, err)
+
+		_, err = jwtService.ValidateToken(foreignToken)
+		assert.ErrorIs(t, err, ErrForeignToken, name)
+		assert.NotErrorIs(t, err, jwt.ErrTokenExpired, name)
+	}
+}
+
+func TestJWTService_ValidateToken_DistinguishesExpiry(t *testing.T) {
+	jwtService := newTestJWTService()
+
+	audienceToken, err := NewJWTService(&config.Config{JWT: config.JWTConfig{
+		Secret: "test-secret", ExpirationTime: config.Duration(time.Hour), Issuer: "test", Audience: "other-service",
+	}}, zap.NewNop()).GenerateToken(&models.User{ID: 42})
+	require.NoError(t, err)
+	_, err = jwtService.ValidateToken(audienceToken)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+
+	expiredToken, err := jwtService.sign(jwtService.userClaims(&models.User{ID: 42}, ""), -time.Minute)
+	require.NoError(t, err)
+	_, err = jwtService.ValidateToken(expiredToken)
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+	assert.NotErrorIs(t, err, ErrForeignToken)
+}
+
+func TestJWTService_ValidateToken_Leeway(t *testing.T) {
+	strict := newTestJWTService()
+	lenient := newTestJWTService()
+	lenient.leeway = 30 * time.Second
+
+	// Tokens from a server whose clock runs 10 seconds off either way
+	expired, err := strict.sign(strict.userClaims(&models.User{ID: 42}, ""), -10*time.Second)
+	require.NoError(t, err)
+	claims := strict.userClaims(&models.User{ID: 42}, "")
+	issued := time.Now().Add(10 * time.Second)
+	claims.IssuedAt = jwt.NewNumericDate(issued)
+	claims.NotBefore = jwt.NewNumericDate(issued)
+	claims.ExpiresAt = jwt.NewNumericDate(issued.Add(time.Hour))
+	notYetValid, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(strict.keys[""])
+	require.NoError(t, err)
+
+	_, err = strict.ValidateToken(expired)
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+	_, err = strict.ValidateToken(notYetValid)
+	assert.ErrorIs(t, err, jwt.ErrTokenNotValidYet)
+
+	_, err = lenient.ValidateToken(expired)
+	assert.NoError(t, err)
+	_, err = lenient.ValidateToken(notYetValid)
+	assert.NoError(t, err)
+
+	// Drift beyond the leeway is still rejected
+	longExpired, err := strict.sign(strict.userClaims(&models.User{ID: 42}, ""), -time.Minute)
+	require.NoError(t, err)
+	_, err = lenient.ValidateToken(longExpired)
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+
+	claims = strict.userClaims(&models.User{ID: 42}, "")
+	issued = time.Now().Add(time.Minute)
+	claims.NotBefore = jwt.NewNumericDate(issued)
+	claims.ExpiresAt = jwt.NewNumericDate(issued.Add(time.Hour))
+	longNotYetValid, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(strict.keys[""])
+	require.NoError(t, err)
+	_, err = lenient.ValidateToken(longNotYetValid)
+	assert.ErrorIs(t, err, jwt.ErrTokenNotValidYet)
+
+	// So is a token claiming to be issued in the future
+	claims = strict.userClaims(&models.User{ID: 42}, "")
+	claims.IssuedAt = jwt.NewNumericDate(issued)
+	claims.NotBefore = nil
+	futureIssued, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(strict.keys[""])
+	require.NoError(t, err)
+	_, err = lenient.ValidateToken(futureIssued)
+	assert.ErrorIs(t, err, jwt.ErrTokenUsedBeforeIssued)
+}
+
+func TestJWTService_KeyRotation(t *testing.T) {
+	newService := func(keys map[string]string, current string) *JWTService {
+		return NewJWTService(&config.Config{JWT: config.JWTConfig{
+			Keys:           keys,
+			CurrentKey:     current,
+			ExpirationTime: config.Duration(time.Hour),
+			Issuer:         "test",
+			Audience:       "test-service",
+		}}, zap.NewNop())
+	}
+	user := &models.User{ID: 42, Username: "testuser"}
+
+	before := newService(map[string]string{"k1": "old-secret"}, "k1")
+	oldToken, err := before.GenerateToken(user)
+	require.NoError(t, err)
+
+	// During the rotation both keys validate, but new tokens use the new one
+	during := newService(map[string]string{"k1": "old-secret", "k2": "new-secret"}, "k2")
+	newToken, err := during.GenerateToken(user)
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, "k2", parsed.Header["kid"])
+
+	for _, token := range []string{oldToken, newToken} {
+		claims, err := during.ValidateToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, 42, claims.UserID)
+	}
+
+	// Once the old key is retired its tokens are rejected
+	after := newService(map[string]string{"k2": "new-secret"}, "k2")
+	_, err = after.ValidateToken(oldToken)
+	assert.ErrorIs(t, err, ErrUnknownKeyID)
+	_, err = after.ValidateToken(newToken)
+	assert.NoError(t, err)
+}
+
+func TestJWTService_KeyRotation_LegacySecret(t *testing.T) {
+	legacy := newTestJWTService()
+	legacyToken, err := legacy.GenerateToken(&models.User{ID: 42})
+	require.NoError(t, err)
+
+	// Tokens without a kid are checked against jwt.secret while it is set
+	cfg := &config.Config{JWT: config.JWTConfig{
+		Secret:         "test-secret",
+		Keys:           map[string]string{"k1": "new-secret"},
+		CurrentKey:     "k1",
+		ExpirationTime: config.Duration(time.Hour),
+		Issuer:         "test",
+		Audience:       "test-service",
+	}}
+	_, err = NewJWTService(cfg, zap.NewNop()).ValidateToken(legacyToken)
+	assert.NoError(t, err)
+
+	cfg.JWT.Secret = ""
+	_, err = NewJWTService(cfg, zap.NewNop()).ValidateToken(legacyToken)
+	assert.ErrorIs(t, err, ErrUnknownKeyID)
+}
+
+func TestNewJWTService_DefaultImpersonationTTL(t *testing.T) {
+	jwtService := NewJWTService(&config.Config{}, zap.NewNop())
+
+	assert.Equal(t, 15*time.Minute, jwtService.ImpersonationTTL())
+}
+
+func TestJWTService_MaxExpiration(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	cfg := &config.Config{JWT: config.JWTConfig{
+		Secret:                  "test-secret",
+		ExpirationTime:          config.Duration(365 * 24 * time.Hour),
+		MaxExpirationTime:       config.Duration(24 * time.Hour),
+		ImpersonationExpiration: config.Duration(10 * time.Minute),
+	}}
+	jwtService := NewJWTService(cfg, zap.New(core))
+	user := &models.User{ID: 42, Username: "testuser"}
+
+	// A year-long expiration, which config validation rejects, is still
+	// clamped, without a warning per token
+	token, err := jwtService.GenerateToken(user)
+	require.NoError(t, err)
+	claims, err := jwtService.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, claims.ExpiresAt.Sub(claims.IssuedAt.Time))
+	assert.Zero(t, logs.Len())
+
+	// Lifetimes within the cap are honored
+	token, err = jwtService.GenerateImpersonationToken(user, 1)
+	require.NoError(t, err)
+	claims, err = jwtService.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, claims.ExpiresAt.Sub(claims.IssuedAt.Time))
+
+	cfg.JWT.ExpirationTime = config.Duration(time.Hour)
+	jwtService = NewJWTService(cfg, zap.New(core))
+	token, err = jwtService.GenerateToken(user)
+	require.NoError(t, err)
+	claims, err = jwtService.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, claims.ExpiresAt.Sub(claims.IssuedAt.Time))
+}
+
+func setupImpersonationRouter(logger *zap.Logger) (*gin.Engine, *JWTService) {
+	jwtService := newTestJWTService()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestLogger(logger))
+	router.Use(AuthMiddleware(jwtService))
+	router.GET("/profile", func(c *gin.Context) {
+		adminID, _ := GetImpersonatedBy(c)
+		Logger(c).Info("handler log")
+		c.JSON(http.StatusOK, gin.H{"impersonated_by": adminID})
+	})
+	router.DELETE("/users/:id", DenyImpersonation(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	return router, jwtService
+}
+
+func TestAuthMiddleware_ExposesImpersonator(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	router, jwtService := setupImpersonationRouter(zap.New(core))
+
+	token, err := jwtService.GenerateImpersonationToken(&models.User{ID: 42, Username: "testuser"}, 1)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/profile", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"impersonated_by": 1}`, w.Body.String())
+
+	entries := logs.FilterMessage("handler log").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, int64(42), entries[0].ContextMap()["user_id"])
+	assert.Equal(t, int64(1), entries[0].ContextMap()["impersonated_by"])
+}
+
+func TestDenyImpersonation(t *testing.T) {
+	router, jwtService := setupImpersonationRouter(zap.NewNop())
+	admin := &models.User{ID: 2, Username: "otheradmin", IsAdmin: true}
+
+	impersonationToken, err := jwtService.GenerateImpersonationToken(admin, 1)
+	require.NoError(t, err)
+	accessToken, err := jwtService.GenerateToken(admin)
+	require.NoError(t, err)
+
+	for token, status := range map[string]int{
+		impersonationToken: http.StatusForbidden,
+		accessToken:        http.StatusNoContent,
+	} {
+		req, _ := http.NewRequest("DELETE", "/users/3", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, status, w.Code)
+	}
+}
+
+func TestCancelDeletionAuthMiddleware(t *testing.T) {
+	jwtService := newTestJWTService()
+	requireAuth := AuthMiddleware(jwtService)
+	router := gin.New()
+	router.POST("/me/cancel-deletion", CancelDeletionAuthMiddleware(jwtService, requireAuth), func(c *gin.Context) {
+		userID, _ := GetUserID(c)
+		c.JSON(http.StatusOK, gin.H{"user_id": userID})
+	})
+	router.GET("/profile", requireAuth, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	user := &models.User{ID: 42, Username: "testuser"}
+
+	cancelToken, err := jwtService.GenerateCancelDeletionToken(user)
+	require.NoError(t, err)
+	accessToken, err := jwtService.GenerateToken(user)
+	require.NoError(t, err)
+	challengeToken, err := jwtService.GenerateChallengeToken(user)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"cancel token cancels", "POST", "/me/cancel-deletion", cancelToken, http.StatusOK},
+		{"access token cancels", "POST", "/me/cancel-deletion", accessToken, http.StatusOK},
+		{"challenge token refused", "POST", "/me/cancel-deletion", challengeToken, http.StatusUnauthorized},
+		{"cancel token refused elsewhere", "GET", "/profile", cancelToken, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusOK && tt.path == "/me/cancel-deletion" {
+				assert.JSONEq(t, `{"user_id": 42}`, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gin-service/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// neverCompressed are content types that are always streamed uncompressed
+var neverCompressed = []string{"text/event-stream"}
+
+// Compression gzip-compresses responses for clients that accept it.
+// Responses smaller than cfg.MinSize, responses with an excluded content type
+// or an existing Content-Encoding, and responses flushed before reaching
+// cfg.MinSize, such as Server-Sent Events, are sent uncompressed.
+func Compression(cfg config.CompressionConfig) gin.HandlerFunc {
+	level := cfg.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	pool := &sync.Pool{
+		New: func() interface{} {
+			gz, _ := gzip.NewWriterLevel(nil, level)
+			return gz
+		},
+	}
+
+	excluded := append(append([]string(nil), neverCompressed...), cfg.ExcludedContentTypes...)
+
+	return func(c *gin.Context) {
+		for _, prefix := range cfg.ExcludedPaths {
+			if strings.HasPrefix(c.Request.URL.Path, prefix) {
+				c.Next()
+				return
+			}
+		}
+
+		c.Header("Vary", "Accept-Encoding")
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) || c.GetHeader("Upgrade") != "" {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{
+			ResponseWriter: c.Writer,
+			minSize:        cfg.MinSize,
+			excluded:       excluded,
+			pool:           pool,
+		}
+		c.Writer = w
+		defer func() {
+			w.close()
+			c.Writer = w.ResponseWriter
+		}()
+
+		c.Next()
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		params = strings.ReplaceAll(params, " ", "")
+		if q, ok := strings.CutPrefix(params, "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressWriter buffers a response until it reaches the minimum size, then
+// decides whether to compress it
+type compressWriter struct {
+	gin.ResponseWriter
+	minSize  int
+	excluded []string
+	pool     *sync.Pool
+
+	buf     bytes.Buffer
+	decided bool
+	// buffered is set once a write was buffered, which starts the response
+	// as far as the handlers are concerned
+	buffered bool
+	gz       *gzip.Writer
+}
+
+// Written reports whether the response was started, including by a write
+// still held in the buffer, so that no other status or body is sent after it
+func (w *compressWriter) Written() bool {
+	return w.buffered || w.ResponseWriter.Written()
+}
+
+// Size returns the number of body bytes written, including those held in
+// the buffer
+func (w *compressWriter) Size() int {
+	if !w.buffered || w.decided {
+		return w.ResponseWriter.Size()
+	}
+	return w.buf.Len()
+}
+
+// Write buffers data until the compression decision is made
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.decided {
+		return w.write(data)
+	}
+
+	w.buffered = true
+	w.buf.Write(data)
+	if w.buf.Len() < w.minSize {
+		return len(data), nil
+	}
+
+	w.decide(true)
+	if err := w.flushBuffer(); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// WriteString buffers s until the compression decision is made
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends buffered data. Flushing before the minimum size is reached
+// marks the response as streamed, so it is sent uncompressed.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(false)
+		_ = w.flushBuffer()
+	}
+	if w.gz != nil {
+		_ = w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide chooses whether to compress the response, setting its headers
+func (w *compressWriter) decide(large bool) {
+	w.decided = true
+	if !large || !w.compressible() {
+		return
+	}
+
+	header := w.Header()
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+
+	w.gz = w.pool.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+}
+
+// compressible reports whether the response headers allow compression
+func (w *compressWriter) compressible() bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	status := w.Status()
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+
+	contentType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	for _, prefix := range w.excluded {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// flushBuffer writes the buffered data through the chosen writer
+func (w *compressWriter) flushBuffer() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// write writes data compressed or not, according to the decision
+func (w *compressWriter) write(data []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// close writes a response that stayed under the minimum size uncompressed,
+// or finishes the gzip stream
+func (w *compressWriter) close() {
+	if !w.decided {
+		w.decide(false)
+		_ = w.flushBuffer()
+		return
+	}
+	if w.gz != nil {
+		_ = w.gz.Close()
+		w.gz.Reset(nil)
+		w.pool.Put(w.gz)
+		w.gz = nil
+	}
+}
@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gin-service/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func setupCompressionRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Compression(config.CompressionConfig{
+		Enabled:              true,
+		MinSize:              1024,
+		ExcludedContentTypes: []string{"image/"},
+	}))
+
+	largeItems := make([]gin.H, 100)
+	for i := range largeItems {
+		largeItems[i] = gin.H{"username": "user", "email": "user@example.com"}
+	}
+	router.GET("/large", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": largeItems})
+	})
+	router.GET("/small", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	router.GET("/image", func(c *gin.Context) {
+		c.Data(http.StatusOK, "image/png", make([]byte, 4096))
+	})
+	router.GET("/events", func(c *gin.Context) {
+		for i := 0; i < 3; i++ {
+			c.SSEvent("message", strings.Repeat("x", 1024))
+			c.Writer.Flush()
+		}
+	})
+	return router
+}
+
+func getCompressed(router *gin.Engine, path, acceptEncoding string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCompression_LargeJSONIsGzipped(t *testing.T) {
+	router := setupCompressionRouter()
+
+	w := getCompressed(router, "/large", "gzip, deflate, br")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+
+	gz, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(body), `{"data":[{"email":"user@example.com"`))
+	assert.Greater(t, len(body), 1024)
+}
+
+func TestCompression_SmallJSONIsNotGzipped(t *testing.T) {
+	router := setupCompressionRouter()
+
+	w := getCompressed(router, "/small", "gzip")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.JSONEq(t, `{"status": "ok"}`, w.Body.String())
+}
+
+func TestCompression_HonorsAcceptEncoding(t *testing.T) {
+	router := setupCompressionRouter()
+
+	for _, acceptEncoding := range []string{"", "br", "gzip;q=0", "identity"} {
+		w := getCompressed(router, "/large", acceptEncoding)
+
+		assert.Empty(t, w.Header().Get("Content-Encoding"), acceptEncoding)
+		assert.True(t, strings.HasPrefix(w.Body.String(), `{"data":`), acceptEncoding)
+	}
+}
+
+func TestCompression_ExcludedContentType(t *testing.T) {
+	router := setupCompressionRouter()
+
+	w := getCompressed(router, "/image", "gzip")
+
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Len(t, w.Body.Bytes(), 4096)
+}
+
+func TestCompression_ServerSentEventsAreNotGzipped(t *testing.T) {
+	router := setupCompressionRouter()
+
+	w := getCompressed(router, "/events", "gzip")
+
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, 3, strings.Count(w.Body.String(), "event:message"))
+}
+
+func TestCompression_BufferedResponseIsStarted(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ErrorHandler(zap.NewNop(), false))
+	var restored bool
+	router.Use(func(c *gin.Context) {
+		writer := c.Writer
+		c.Next()
+		restored = c.Writer == writer
+	})
+	router.Use(Compression(config.CompressionConfig{Enabled: true, MinSize: 1024}))
+	router.Use(TimeoutMiddleware(20 * time.Millisecond))
+	router.GET("/slow", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		time.Sleep(50 * time.Millisecond)
+	})
+	router.GET("/panic", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		panic("boom")
+	})
+
+	// A response held in the buffer past the deadline is not replaced by
+	// the timeout response
+	w := getCompressed(router, "/slow", "gzip")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"status":"ok"}`, w.Body.String())
+	assert.True(t, restored, "the compressing writer is removed once done")
+
+	// Nor by the error response of a later panic
+	w = getCompressed(router, "/panic", "gzip")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"status":"ok"}`, w.Body.String())
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	assert.True(t, acceptsGzip("gzip"))
+	assert.True(t, acceptsGzip("deflate, GZIP;q=0.5"))
+	assert.True(t, acceptsGzip("*"))
+	assert.False(t, acceptsGzip(""))
+	assert.False(t, acceptsGzip("br, deflate"))
+	assert.False(t, acceptsGzip("gzip; q=0"))
+}
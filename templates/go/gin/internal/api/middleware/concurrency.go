@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// concurrencyRetryAfter is sent in the Retry-After header of requests shed
+// by Concurrency, in seconds. In-flight requests finish quickly, so clients
+// need not wait long.
+const concurrencyRetryAfter = "1"
+
+// Concurrency caps the requests handled at once at max, answering the
+// requests beyond it with 503 Service Unavailable instead of letting a
+// burst of slow requests pile up goroutines and queue for database
+// connections. Health checks are never shed, so probes keep answering under
+// load. A max of 0 or less leaves requests uncapped.
+func Concurrency(max int) gin.HandlerFunc {
+	if max <= 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	slots := make(chan struct{}, max)
+	return func(c *gin.Context) {
+		if isHealthCheck(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+		default:
+			c.Header("Retry-After", concurrencyRetryAfter)
+			AbortWithError(c, http.StatusServiceUnavailable, "service_unavailable", "The service is overloaded. Please try again later.")
+			return
+		}
+		defer func() { <-slots }()
+
+		c.Next()
+	}
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"gin-service/internal/config"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupCORS sets up CORS middleware with the origins allowed in the service's
+// environment. Cross-origin requests from other origins are rejected with a
+// 403 JSON error.
+func SetupCORS(cfg *config.Config) gin.HandlerFunc {
+	origins := newOriginMatcher(cfg.CORS.OriginsFor(cfg.Service.Environment))
+
+	corsConfig := cors.Config{
+		AllowMethods:     cfg.CORS.AllowedMethods,
+		AllowHeaders:     cfg.CORS.AllowedHeaders,
+		ExposeHeaders:    cfg.CORS.ExposedHeaders,
+		AllowCredentials: cfg.CORS.AllowedCredentials,
+		MaxAge:           time.Duration(cfg.CORS.MaxAge) * time.Second,
+	}
+	if origins.any {
+		corsConfig.AllowAllOrigins = true
+	} else {
+		corsConfig.AllowOriginFunc = origins.allows
+	}
+	handler := cors.New(corsConfig)
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin != "" && !sameOrigin(c.Request, origin) && !origins.allows(origin) {
+			AbortWithError(c, http.StatusForbidden, "origin_not_allowed", "Cross-origin requests from "+origin+" are not allowed")
+			return
+		}
+
+		handler(c)
+	}
+}
+
+// sameOrigin reports whether origin is the server's own origin, which
+// browsers may send on same-origin requests
+func sameOrigin(r *http.Request, origin string) bool {
+	return origin == "http://"+r.Host || origin == "https://"+r.Host
+}
+
+// originMatcher matches request origins against allowed origin patterns
+type originMatcher struct {
+	any      bool
+	exact    map[string]bool
+	patterns []*regexp.Regexp
+}
+
+// newOriginMatcher compiles allowed origins. "*" allows any origin, and a
+// "*" in a host, as in "https://*.example.com", matches one or more
+// subdomain labels. Patterns without a scheme match http and https.
+func newOriginMatcher(origins []string) *originMatcher {
+	m := &originMatcher{exact: make(map[string]bool)}
+	for _, origin := range origins {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		switch {
+		case origin == "*":
+			m.any = true
+		case strings.Contains(origin, "*"):
+			m.patterns = append(m.patterns, wildcardOrigin(origin))
+		default:
+			m.exact[origin] = true
+		}
+	}
+	return m
+}
+
+// wildcardOrigin compiles a wildcard origin pattern into an anchored regexp
+func wildcardOrigin(pattern string) *regexp.Regexp {
+	scheme := `https?://`
+	if i := strings.Index(pattern, "://"); i >= 0 {
+		scheme = regexp.QuoteMeta(pattern[:i+3])
+		pattern = pattern[i+3:]
+	}
+
+	host := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, `[a-z0-9-]+(\.[a-z0-9-]+)*`)
+	return regexp.MustCompile(`^` + scheme + host + `$`)
+}
+
+// allows reports whether origin is allowed
+func (m *originMatcher) allows(origin string) bool {
+	if m.any {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if m.exact[origin] {
+		return true
+	}
+	for _, pattern := range m.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gin-service/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupCORSRouter(environment string, cors config.CORSConfig) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(SetupCORS(&config.Config{
+		Service: config.ServiceConfig{Environment: environment},
+		CORS:    cors,
+	}))
+	router.GET("/test", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func corsRequest(router *gin.Engine, method, origin string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, "/test", nil)
+	req.Host = "api.example.com"
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+var testCORSConfig = config.CORSConfig{
+	AllowedOrigins:     []string{"https://app.example.com", "https://*.example.org"},
+	AllowedMethods:     []string{"GET", "POST"},
+	AllowedHeaders:     []string{"Authorization", "Content-Type"},
+	AllowedCredentials: true,
+	MaxAge:             600,
+}
+
+func TestSetupCORS_AllowsListedOrigin(t *testing.T) {
+	router := setupCORSRouter("development", testCORSConfig)
+
+	w := corsRequest(router, http.MethodGet, "https://app.example.com")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestSetupCORS_WildcardSubdomains(t *testing.T) {
+	router := setupCORSRouter("development", testCORSConfig)
+
+	for _, origin := range []string{"https://app.example.org", "https://a.b.example.org", "https://APP.example.org"} {
+		w := corsRequest(router, http.MethodGet, origin)
+		assert.Equal(t, http.StatusOK, w.Code, origin)
+		assert.Equal(t, origin, w.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+
+	for _, origin := range []string{
+		"https://example.org",
+		"http://app.example.org",
+		"https://app.example.org.evil.com",
+		"https://evilexample.org",
+	} {
+		w := corsRequest(router, http.MethodGet, origin)
+		assert.Equal(t, http.StatusForbidden, w.Code, origin)
+	}
+}
+
+func TestSetupCORS_RejectsDisallowedOrigin(t *testing.T) {
+	router := setupCORSRouter("development", testCORSConfig)
+
+	w := corsRequest(router, http.MethodGet, "https://evil.com")
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, w.Body.String(), "origin_not_allowed")
+}
+
+func TestSetupCORS_IgnoresNonCORSRequests(t *testing.T) {
+	router := setupCORSRouter("development", testCORSConfig)
+
+	assert.Equal(t, http.StatusOK, corsRequest(router, http.MethodGet, "").Code)
+	assert.Equal(t, http.StatusOK, corsRequest(router, http.MethodGet, "https://api.example.com").Code)
+}
+
+func TestSetupCORS_PreflightHonorsMaxAge(t *testing.T) {
+	router := setupCORSRouter("development", testCORSConfig)
+
+	w := corsRequest(router, http.MethodOptions, "https://app.example.com")
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, "GET,POST", w.Header().Get("Access-Control-Allow-Methods"))
+	// Credentialed preflights get the requesting origin, never "*"
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestSetupCORS_EnvironmentOrigins(t *testing.T) {
+	cors := testCORSConfig
+	cors.EnvironmentOrigins = map[string][]string{
+		"production": {"https://www.example.com"},
+	}
+	router := setupCORSRouter("production", cors)
+
+	assert.Equal(t, http.StatusOK, corsRequest(router, http.MethodGet, "https://www.example.com").Code)
+	assert.Equal(t, http.StatusForbidden, corsRequest(router, http.MethodGet, "https://app.example.com").Code)
+}
+
+func TestSetupCORS_AnyOrigin(t *testing.T) {
+	router := setupCORSRouter("development", config.CORSConfig{AllowedOrigins: []string{"*"}})
+
+	w := corsRequest(router, http.MethodGet, "https://anywhere.com")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// envelopeKey is the gin context key marking requests whose responses are
+// wrapped in an envelope
+const envelopeKey = "response_envelope"
+
+// EnvelopeParam is the Accept header parameter asking for responses with or
+// without an envelope, as in "Accept: application/json; envelope=true"
+const EnvelopeParam = "envelope"
+
+// ResponseEnvelope decides whether the responses to a request are wrapped in
+// an envelope, {"data": ..., "meta": ...} for successes and {"error": ...}
+// for errors. They are when enabled, unless the Accept header's
+// EnvelopeParam says otherwise, and always under API v2.
+func ResponseEnvelope(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		enveloped := enabled
+		if requested, ok := acceptedEnvelope(c.GetHeader("Accept")); ok {
+			enveloped = requested
+		}
+		// Requests turned away before reaching a route group, say by rate
+		// limiting, are told apart by path
+		if strings.HasPrefix(c.Request.URL.Path, "/api/"+APIv2+"/") {
+			enveloped = true
+		}
+		c.Set(envelopeKey, enveloped)
+		c.Next()
+	}
+}
+
+// acceptedEnvelope returns the EnvelopeParam of the first media range of
+// accept carrying a valid one, or false when none does
+func acceptedEnvelope(accept string) (bool, bool) {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		value, ok := params[EnvelopeParam]
+		if !ok {
+			continue
+		}
+		if enveloped, err := strconv.ParseBool(value); err == nil {
+			return enveloped, true
+		}
+	}
+	return false, false
+}
+
+// IsEnveloped reports whether the responses to the request are wrapped in an
+// envelope, as decided by ResponseEnvelope or for routes of API v2
+func IsEnveloped(c *gin.Context) bool {
+	if version, _ := GetAPIVersion(c); version == APIv2 {
+		return true
+	}
+	return c.GetBool(envelopeKey)
+}
+
+// errorEnvelope returns the error response body, built by errorBody, as sent
+// for the request: as is, or enveloped under "error" with its code in "code"
+func errorEnvelope(c *gin.Context, body gin.H) interface{} {
+	if !IsEnveloped(c) {
+		return body
+	}
+	detail := make(gin.H, len(body))
+	for key, value := range body {
+		if key == "error" {
+			key = "code"
+		}
+		detail[key] = value
+	}
+	return gin.H{"error": detail}
+}
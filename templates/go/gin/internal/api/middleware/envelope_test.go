@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseEnvelope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setup := func(enabled bool) *gin.Engine {
+		router := gin.New()
+		router.Use(ResponseEnvelope(enabled))
+		fail := func(c *gin.Context) { AbortWithError(c, http.StatusForbidden, "forbidden", "Forbidden") }
+		router.GET("/api/v1/test", fail)
+		router.GET("/api/v2/test", fail)
+		return router
+	}
+	bare := `{"error": "forbidden", "message": "Forbidden"}`
+	enveloped := `{"error": {"code": "forbidden", "message": "Forbidden"}}`
+
+	tests := []struct {
+		name    string
+		enabled bool
+		path    string
+		accept  string
+		want    string
+	}{
+		{"disabled", false, "/api/v1/test", "", bare},
+		{"enabled", true, "/api/v1/test", "application/json", enveloped},
+		{"asked for", false, "/api/v1/test", "text/html, application/json; envelope=1", enveloped},
+		{"declined", true, "/api/v1/test", "application/json; envelope=false", bare},
+		{"invalid parameter", false, "/api/v1/test", "application/json; envelope=maybe", bare},
+		{"v2", false, "/api/v2/test", "application/json; envelope=false", enveloped},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			setup(tt.enabled).ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			assert.JSONEq(t, tt.want, w.Body.String())
+		})
+	}
+}
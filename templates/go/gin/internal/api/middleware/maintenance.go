@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gin-service/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MaintenanceTogglePath is the admin endpoint switching maintenance mode,
+// which stays reachable so that maintenance can be ended
+const MaintenanceTogglePath = "/api/v1/admin/maintenance"
+
+// defaultMaintenanceMessage is sent with 503 responses unless another
+// message is configured
+const defaultMaintenanceMessage = "The service is down for maintenance. Please try again later."
+
+// Maintenance holds the maintenance mode switch and message, which can be
+// changed at runtime
+type Maintenance struct {
+	enabled     atomic.Bool
+	message     atomic.Pointer[string]
+	retryAfter  string
+	allowAdmins bool
+}
+
+// NewMaintenance creates a maintenance switch starting in the configured state
+func NewMaintenance(cfg config.MaintenanceConfig) *Maintenance {
+	retryAfter := cfg.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = time.Minute
+	}
+
+	m := &Maintenance{
+		retryAfter:  strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))),
+		allowAdmins: cfg.AllowAdmins,
+	}
+	m.enabled.Store(cfg.Enabled)
+	m.SetMessage(cfg.Message)
+	return m
+}
+
+// Enabled reports whether the service is in maintenance
+func (m *Maintenance) Enabled() bool {
+	return m.enabled.Load()
+}
+
+// SetEnabled switches maintenance mode on or off
+func (m *Maintenance) SetEnabled(enabled bool) {
+	m.enabled.Store(enabled)
+}
+
+// Message returns the message sent with 503 responses
+func (m *Maintenance) Message() string {
+	return *m.message.Load()
+}
+
+// SetMessage replaces the message sent with 503 responses; an empty message
+// restores the default one
+func (m *Maintenance) SetMessage(message string) {
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	m.message.Store(&message)
+}
+
+// MaintenanceMode answers requests with 503 Service Unavailable while the
+// service is in maintenance. Health checks and the maintenance toggle pass
+// through, and so do requests with an admin's own access token when admins
+// are allowed.
+func MaintenanceMode(m *Maintenance, jwtService JWTServiceInterface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !m.Enabled() || maintenanceExempt(c.Request.URL.Path) || (m.allowAdmins && isAdminRequest(c, jwtService)) {
+			c.Next()
+			return
+		}
+
+		c.Header("Retry-After", m.retryAfter)
+		AbortWithError(c, http.StatusServiceUnavailable, "maintenance", m.Message())
+	}
+}
+
+// maintenanceExempt reports whether path stays available during maintenance
+func maintenanceExempt(path string) bool {
+	return isHealthCheck(path) || path == MaintenanceTogglePath
+}
+
+// isHealthCheck reports whether path is one of the health check endpoints,
+// which must keep answering however busy the service is
+func isHealthCheck(path string) bool {
+	return strings.HasPrefix(path, "/health") || path == "/live" || path == "/ready"
+}
+
+// isAdminRequest reports whether the request carries a valid access token of
+// an admin. Impersonation tokens don't count, since they act as the user.
+func isAdminRequest(c *gin.Context, jwtService JWTServiceInterface) bool {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	claims, err := jwtService.ValidateToken(token)
+	return err == nil && claims.IsAdmin && claims.ImpersonatedBy == 0
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gin-service/internal/config"
+	"gin-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupMaintenanceRouter(cfg config.MaintenanceConfig) (*gin.Engine, *Maintenance, *JWTService) {
+	gin.SetMode(gin.TestMode)
+	jwtService := newTestJWTService()
+	maintenance := NewMaintenance(cfg)
+
+	router := gin.New()
+	router.Use(MaintenanceMode(maintenance, jwtService))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	for _, path := range []string{"/health", "/health/detailed", "/live", "/ready", MaintenanceTogglePath, "/api/v1/users"} {
+		router.GET(path, ok)
+	}
+	return router, maintenance, jwtService
+}
+
+func maintenanceRequest(router *gin.Engine, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMaintenanceMode_BlocksRoutes(t *testing.T) {
+	router, _, _ := setupMaintenanceRouter(config.MaintenanceConfig{Enabled: true, RetryAfter: 90 * time.Second})
+
+	w := maintenanceRequest(router, "/api/v1/users", "")
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "90", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error":"maintenance","message":"The service is down for maintenance. Please try again later."}`, w.Body.String())
+}
+
+func TestMaintenanceMode_Message(t *testing.T) {
+	router, maintenance, _ := setupMaintenanceRouter(config.MaintenanceConfig{Enabled: true, Message: "Upgrading the database, back at 14:00 UTC."})
+
+	w := maintenanceRequest(router, "/api/v1/users", "")
+	assert.JSONEq(t, `{"error":"maintenance","message":"Upgrading the database, back at 14:00 UTC."}`, w.Body.String())
+
+	maintenance.SetMessage("Almost done.")
+	w = maintenanceRequest(router, "/api/v1/users", "")
+	assert.JSONEq(t, `{"error":"maintenance","message":"Almost done."}`, w.Body.String())
+
+	// An empty message restores the default one
+	maintenance.SetMessage("")
+	assert.Equal(t, "The service is down for maintenance. Please try again later.", maintenance.Message())
+}
+
+func TestMaintenanceMode_HealthChecksPass(t *testing.T) {
+	router, _, _ := setupMaintenanceRouter(config.MaintenanceConfig{Enabled: true})
+
+	for _, path := range []string{"/health", "/health/detailed", "/live", "/ready", MaintenanceTogglePath} {
+		w := maintenanceRequest(router, path, "")
+		assert.Equal(t, http.StatusOK, w.Code, path)
+	}
+}
+
+func TestMaintenanceMode_Admins(t *testing.T) {
+	admin := &models.User{ID: 1, Username: "admin", IsAdmin: true}
+	user := &models.User{ID: 2, Username: "user"}
+
+	router, _, jwtService := setupMaintenanceRouter(config.MaintenanceConfig{Enabled: true, AllowAdmins: true})
+	adminToken, err := jwtService.GenerateToken(admin)
+	require.NoError(t, err)
+	userToken, err := jwtService.GenerateToken(user)
+	require.NoError(t, err)
+	impersonationToken, err := jwtService.GenerateImpersonationToken(&models.User{ID: 3, Username: "other", IsAdmin: true}, admin.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, maintenanceRequest(router, "/api/v1/users", adminToken).Code)
+	assert.Equal(t, http.StatusServiceUnavailable, maintenanceRequest(router, "/api/v1/users", userToken).Code)
+	assert.Equal(t, http.StatusServiceUnavailable, maintenanceRequest(router, "/api/v1/users", impersonationToken).Code)
+	assert.Equal(t, http.StatusServiceUnavailable, maintenanceRequest(router, "/api/v1/users", "invalid").Code)
+
+	router, _, jwtService = setupMaintenanceRouter(config.MaintenanceConfig{Enabled: true, AllowAdmins: false})
+	adminToken, err = jwtService.GenerateToken(admin)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusServiceUnavailable, maintenanceRequest(router, "/api/v1/users", adminToken).Code)
+}
+
+func TestMaintenanceMode_Toggle(t *testing.T) {
+	router, maintenance, _ := setupMaintenanceRouter(config.MaintenanceConfig{})
+
+	assert.Equal(t, http.StatusOK, maintenanceRequest(router, "/api/v1/users", "").Code)
+
+	maintenance.SetEnabled(true)
+	w := maintenanceRequest(router, "/api/v1/users", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	maintenance.SetEnabled(false)
+	assert.Equal(t, http.StatusOK, maintenanceRequest(router, "/api/v1/users", "").Code)
+}
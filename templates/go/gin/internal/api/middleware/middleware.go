@@ -2,39 +2,151 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gin-service/internal/config"
+	"gin-service/internal/database"
 
-	"github.com/gin-contrib/cors"
+	"github.com/gin-contrib/requestid"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"golang.org/x/time/rate"
 )
 
-// SetupCORS sets up CORS middleware
-func SetupCORS(cfg *config.Config) gin.HandlerFunc {
-	corsConfig := cors.Config{
-		AllowOrigins:     cfg.CORS.AllowedOrigins,
-		AllowMethods:     cfg.CORS.AllowedMethods,
-		AllowHeaders:     cfg.CORS.AllowedHeaders,
-		ExposeHeaders:    cfg.CORS.ExposedHeaders,
-		AllowCredentials: cfg.CORS.AllowedCredentials,
-		MaxAge:           time.Duration(cfg.CORS.MaxAge) * time.Second,
+// requestIDHeader is the header carrying the request ID
+const requestIDHeader = "X-Request-ID"
+
+// correlationIDHeader is an alternative incoming header carrying the ID
+// assigned by an upstream service
+const correlationIDHeader = "X-Correlation-ID"
+
+// maxRequestIDLength is the longest incoming request ID that is reused
+const maxRequestIDLength = 128
+
+// RequestID assigns every request an ID, echoed in the X-Request-ID response
+// header. An ID assigned upstream in X-Request-ID or X-Correlation-ID is
+// reused if valid, so traces can be followed across services; otherwise a
+// new one is generated.
+func RequestID() gin.HandlerFunc {
+	assign := requestid.New(requestid.WithCustomHeaderStrKey(requestIDHeader))
+
+	return func(c *gin.Context) {
+		// requestid reuses any X-Request-ID, so only leave a valid one
+		if incoming := incomingRequestID(c.Request.Header); incoming != "" {
+			c.Request.Header.Set(requestIDHeader, incoming)
+		} else {
+			c.Request.Header.Del(requestIDHeader)
+		}
+
+		assign(c)
+	}
+}
+
+// incomingRequestID returns the first valid upstream request ID in header
+func incomingRequestID(header http.Header) string {
+	for _, name := range []string{requestIDHeader, correlationIDHeader} {
+		if id := header.Get(name); validRequestID(id) {
+			return id
+		}
+	}
+	return ""
+}
+
+// validRequestID reports whether id is safe to reuse: not empty, not too
+// long and limited to characters that cannot forge log lines or headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
 	}
+	return true
+}
+
+// loggerKey is the gin context key for the request-scoped logger
+const loggerKey = "logger"
 
-	return cors.New(corsConfig)
+// errorCodeKey is the gin context key for the machine error code of the
+// response, logged with the request
+const errorCodeKey = "error_code"
+
+// SlowRequests holds the latency above which RequestLogger logs a request
+// as slow. It is safe for concurrent use, so the threshold can change while
+// requests are served.
+type SlowRequests struct {
+	threshold atomic.Int64
 }
 
-// RequestLogger creates a structured logging middleware
+// NewSlowRequests creates a slow request threshold; 0 disables it
+func NewSlowRequests(threshold time.Duration) *SlowRequests {
+	s := &SlowRequests{}
+	s.SetThreshold(threshold)
+	return s
+}
+
+// Threshold returns the latency above which requests are slow, or 0
+func (s *SlowRequests) Threshold() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return time.Duration(s.threshold.Load())
+}
+
+// SetThreshold changes the latency above which requests are slow
+func (s *SlowRequests) SetThreshold(threshold time.Duration) {
+	s.threshold.Store(int64(threshold))
+}
+
+// RequestLogger creates a structured logging middleware. It stores a child
+// logger tagged with the request ID assigned by RequestID, trace ID, method
+// and path in the context, so everything logged through Logger can be
+// correlated with the request's access log. The trace ID is propagated from
+// a traceparent header, falling back to the request ID. Every request is
+// logged at info level, or above for errors.
 func RequestLogger(logger *zap.Logger) gin.HandlerFunc {
+	return RequestLoggerWithSlowRequests(logger, nil)
+}
+
+// RequestLoggerWithSlowRequests creates a RequestLogger that logs requests
+// slower than slow's threshold at warn level with "slow": true, and faster
+// ones at debug level, surfacing latency outliers without logging every
+// request. Error responses are logged at warn or error level whatever their
+// latency. Without a threshold, requests are logged like RequestLogger does.
+func RequestLoggerWithSlowRequests(logger *zap.Logger, slow *SlowRequests) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 		path := c.Request.URL.Path
 		raw := c.Request.URL.RawQuery
+		method := c.Request.Method
+
+		requestID := requestid.Get(c)
+		traceID := requestTraceID(c)
+		c.Set(traceIDKey, traceID)
+
+		c.Set(loggerKey, logger.With(
+			zap.String("request_id", requestID),
+			zap.String("trace_id", traceID),
+			zap.String("method", method),
+			zap.String("path", path),
+		))
+		// Queries run for the request log its ID
+		c.Request = c.Request.WithContext(database.ContextWithRequestID(c.Request.Context(), requestID))
 
 		// Process request
 		c.Next()
@@ -44,26 +156,33 @@ func RequestLogger(logger *zap.Logger) gin.HandlerFunc {
 		latency := end.Sub(start)
 
 		clientIP := c.ClientIP()
-		method := c.Request.Method
 		statusCode := c.Writer.Status()
 		bodySize := c.Writer.Size()
 		userAgent := c.Request.UserAgent()
-		requestID := c.GetString("X-Request-ID")
 
 		if raw != "" {
 			path = path + "?" + raw
 		}
 
-		// Log level based on status code
+		// Log level based on latency, then status code
 		logLevel := zap.InfoLevel
+		isSlow := false
+		if threshold := slow.Threshold(); threshold > 0 {
+			isSlow = latency > threshold
+			logLevel = zap.DebugLevel
+			if isSlow {
+				logLevel = zap.WarnLevel
+			}
+		}
 		if statusCode >= 400 && statusCode < 500 {
 			logLevel = zap.WarnLevel
 		} else if statusCode >= 500 {
 			logLevel = zap.ErrorLevel
 		}
 
-		logger.Log(logLevel, "HTTP Request",
+		fields := []zap.Field{
 			zap.String("request_id", requestID),
+			zap.String("trace_id", traceID),
 			zap.String("method", method),
 			zap.String("path", path),
 			zap.Int("status", statusCode),
@@ -71,26 +190,122 @@ func RequestLogger(logger *zap.Logger) gin.HandlerFunc {
 			zap.String("client_ip", clientIP),
 			zap.Int("body_size", bodySize),
 			zap.String("user_agent", userAgent),
-		)
+		}
+		if userID, ok := GetUserID(c); ok {
+			fields = append(fields, zap.Int("user_id", userID))
+		}
+		if code, ok := GetErrorCode(c); ok {
+			fields = append(fields, zap.String("error_code", code))
+		}
+		if version, ok := GetAPIVersion(c); ok {
+			fields = append(fields, zap.String("api_version", version))
+		}
+		if isSlow {
+			fields = append(fields, zap.Bool("slow", true))
+		}
+
+		logger.Log(logLevel, "HTTP Request", fields...)
+	}
+}
+
+// SetErrorCode records the machine error code of an error response, the
+// error field of its body, to be logged with the request
+func SetErrorCode(c *gin.Context, code string) {
+	c.Set(errorCodeKey, code)
+}
+
+// GetErrorCode gets the error code recorded by SetErrorCode
+func GetErrorCode(c *gin.Context) (string, bool) {
+	code, exists := c.Get(errorCodeKey)
+	if !exists {
+		return "", false
+	}
+	return code.(string), true
+}
+
+// GetRequestID gets the request ID assigned by RequestID, or "" outside it
+func GetRequestID(c *gin.Context) string {
+	return requestid.Get(c)
+}
+
+// AbortWithError aborts the request with an error response carrying the
+// machine error code, message and request ID, recording the code for the
+// request log
+func AbortWithError(c *gin.Context, status int, code, message string) {
+	SetErrorCode(c, code)
+	c.AbortWithStatusJSON(status, errorEnvelope(c, errorBody(c, code, message)))
+}
+
+// errorBody builds the body of an error response, matching
+// handlers.ErrorResponse. It is sent through errorEnvelope.
+func errorBody(c *gin.Context, code, message string) gin.H {
+	body := gin.H{
+		"error":   code,
+		"message": message,
+	}
+	if requestID := GetRequestID(c); requestID != "" {
+		body["request_id"] = requestID
+	}
+	return body
+}
+
+// Logger returns the request-scoped logger, which logs with the request ID,
+// method, path and, once authenticated, user ID. Outside RequestLogger it
+// returns the global logger.
+func Logger(c *gin.Context) *zap.Logger {
+	return contextLogger(c, zap.L())
+}
+
+// setUserLogger adds the authenticated user's ID, and the impersonating
+// admin's ID if any, to the request-scoped logger
+func setUserLogger(c *gin.Context, claims *Claims) {
+	fields := []zap.Field{zap.Int("user_id", claims.UserID)}
+	if claims.ImpersonatedBy != 0 {
+		fields = append(fields, zap.Int("impersonated_by", claims.ImpersonatedBy))
+	}
+	c.Set(loggerKey, Logger(c).With(fields...))
+}
+
+// contextLogger returns the request-scoped logger, or fallback if there is none
+func contextLogger(c *gin.Context, fallback *zap.Logger) *zap.Logger {
+	if logger, ok := c.Get(loggerKey); ok {
+		if logger, ok := logger.(*zap.Logger); ok {
+			return logger
+		}
 	}
+	return fallback
 }
 
-// ErrorHandler handles panics and errors
-func ErrorHandler(logger *zap.Logger) gin.HandlerFunc {
+// ErrorHandler handles panics and errors. Recovered panics are logged with
+// their stack trace and request ID. When exposeStack is set, which must never
+// be the case in production, the stack is also returned in the response.
+func ErrorHandler(logger *zap.Logger, exposeStack bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
 			if err := recover(); err != nil {
-				logger.Error("Panic recovered",
+				stack := debug.Stack()
+
+				// The scoped logger already carries the request ID
+				panicLogger := contextLogger(c, logger.With(zap.String("request_id", requestid.Get(c))))
+				panicLogger.Error("Panic recovered",
 					zap.Any("error", err),
 					zap.String("path", c.Request.URL.Path),
 					zap.String("method", c.Request.Method),
+					zap.ByteString("stack", stack),
 				)
 
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error":   "internal_server_error",
-					"message": "An internal server error occurred",
-				})
-				c.Abort()
+				// Too late to send an error once the response has started
+				if c.Writer.Written() {
+					c.Abort()
+					return
+				}
+
+				response := errorBody(c, "internal_server_error", "An internal server error occurred")
+				if exposeStack {
+					response["stack"] = strings.Split(strings.TrimSpace(string(stack)), "\n")
+				}
+				SetErrorCode(c, "internal_server_error")
+				c.AbortWithStatusJSON(http.StatusInternalServerError, errorEnvelope(c, response))
 			}
 		}()
 
@@ -105,6 +320,10 @@ type RateLimiter struct {
 	rate     rate.Limit
 	burst    int
 	cleanup  time.Duration
+
+	// warnTokens is the number of remaining tokens below which allowed
+	// requests are warned that they approach the limit
+	warnTokens float64
 }
 
 // NewRateLimiter creates a new rate limiter
@@ -136,6 +355,50 @@ func (rl *RateLimiter) getLimiter(key string) *rate.Limiter {
 	return limiter
 }
 
+// SetWarnThreshold makes allowed requests carry a warning once fewer than
+// fraction of the burst remain
+func (rl *RateLimiter) SetWarnThreshold(fraction float64) {
+	rl.warnTokens = fraction * float64(rl.burst)
+}
+
+// RateLimitStatus is the quota of a key after a request
+type RateLimitStatus struct {
+	Allowed bool
+	// Limit is the burst, the most requests allowed at once
+	Limit int
+	// Remaining is the number of requests allowed right away
+	Remaining int
+	// RetryAfter is how long a denied request should wait before retrying
+	RetryAfter time.Duration
+	// Warn is set on allowed requests that approach the limit
+	Warn bool
+}
+
+// Take takes a token for a request for key, reporting whether the request is
+// allowed along with the remaining quota. A denied request gives its token
+// back, and learns from the reservation when one becomes available.
+func (rl *RateLimiter) Take(key string) RateLimitStatus {
+	limiter := rl.getLimiter(key)
+	status := RateLimitStatus{Limit: rl.burst}
+
+	now := time.Now()
+	reservation := limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return status
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		status.RetryAfter = delay
+		return status
+	}
+
+	tokens := limiter.TokensAt(now)
+	status.Allowed = true
+	status.Remaining = max(int(tokens), 0)
+	status.Warn = tokens < rl.warnTokens
+	return status
+}
+
 // cleanupRoutine periodically removes unused limiters
 func (rl *RateLimiter) cleanupRoutine() {
 	ticker := time.NewTicker(rl.cleanup)
@@ -168,21 +431,29 @@ func RateLimit(cfg *config.Config) gin.HandlerFunc {
 	}
 
 	limiter := NewRateLimiter(cfg.Rate.RPS, cfg.Rate.Burst, window)
+	limiter.SetWarnThreshold(cfg.Rate.WarnThreshold)
 
 	return func(c *gin.Context) {
 		// Use client IP as the key
 		key := c.ClientIP()
 
-		// Check if request is allowed
-		if !limiter.getLimiter(key).Allow() {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":   "rate_limit_exceeded",
-				"message": "Rate limit exceeded. Please try again later.",
-			})
-			c.Abort()
+		// Check if request is allowed, telling the client its quota either way
+		status := limiter.Take(key)
+		c.Header("X-RateLimit-Limit", strconv.Itoa(status.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(status.Remaining))
+		if !status.Allowed {
+			if status.RetryAfter > 0 {
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(status.RetryAfter.Seconds()))))
+			}
+			AbortWithError(c, http.StatusTooManyRequests, "rate_limit_exceeded", "Rate limit exceeded. Please try again later.")
 			return
 		}
 
+		// Let well-behaved clients slow down before they are rejected
+		if status.Warn {
+			c.Header("X-RateLimit-Warning", "true")
+		}
+
 		c.Next()
 	}
 }
@@ -222,19 +493,108 @@ func NoCache() gin.HandlerFunc {
 	}
 }
 
-// MaxSizeMiddleware limits request body size
+// maxSizeKey holds the request body size limit in effect. Each size limit
+// middleware run replaces it, so the innermost one wins.
+const maxSizeKey = "max_size"
+
+// MaxSizeMiddleware limits request body size. It can be applied globally and
+// again on a route group, which can both raise and lower the global limit.
+// The limit is enforced as the body is read, once every middleware has run:
+// reads fail with *http.MaxBytesError past the limit, and at once when the
+// declared Content-Length exceeds it. Handlers respond with 413 on such
+// errors, see IsRequestTooLarge.
 func MaxSizeMiddleware(maxSize int64) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if c.Request.ContentLength > maxSize {
-			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
-				"error":   "request_too_large",
-				"message": fmt.Sprintf("Request body too large. Maximum size is %d bytes", maxSize),
-			})
-			c.Abort()
+		_, limited := c.Get(maxSizeKey)
+		c.Set(maxSizeKey, maxSize)
+		if !limited && c.Request.Body != nil {
+			c.Request.Body = &limitedBody{c: c, body: c.Request.Body, declared: c.Request.ContentLength}
+		}
+		c.Next()
+	}
+}
+
+// MaxSize returns the request body size limit in effect, if any
+func MaxSize(c *gin.Context) (int64, bool) {
+	value, ok := c.Get(maxSizeKey)
+	maxSize, _ := value.(int64)
+	return maxSize, ok
+}
+
+// limitedBody is a request body limited to the size in effect when it is
+// first read
+type limitedBody struct {
+	c        *gin.Context
+	body     io.ReadCloser
+	declared int64
+	limited  io.ReadCloser
+}
+
+// Read reads from the body, failing with *http.MaxBytesError past the limit
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.limited == nil {
+		maxSize, _ := MaxSize(b.c)
+		if b.declared > maxSize {
+			return 0, &http.MaxBytesError{Limit: maxSize}
+		}
+		// Requests lying about Content-Length are caught by MaxBytesReader
+		b.limited = http.MaxBytesReader(b.c.Writer, b.body, maxSize)
+	}
+	return b.limited.Read(p)
+}
+
+// Close closes the body
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
+
+// IsRequestTooLarge reports whether err was caused by a request body
+// exceeding the limit set by MaxSizeMiddleware
+func IsRequestTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// PoolChecker reports whether the database connection pool is exhausted
+type PoolChecker interface {
+	Exhausted() bool
+}
+
+// PoolExhaustionGuard sheds load with 503 Service Unavailable while the
+// database connection pool is exhausted, instead of letting requests queue
+// for a connection until they time out
+func PoolExhaustionGuard(pool PoolChecker, retryAfter time.Duration) gin.HandlerFunc {
+	retryAfterSeconds := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+
+	return func(c *gin.Context) {
+		if pool.Exhausted() {
+			c.Header("Retry-After", retryAfterSeconds)
+			AbortWithError(c, http.StatusServiceUnavailable, "service_unavailable", "The service is overloaded. Please try again later.")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// BreakerChecker reports whether the database circuit breaker is open
+type BreakerChecker interface {
+	Open() bool
+}
+
+// CircuitBreakerGuard fails requests at once with 503 Service Unavailable
+// while the database circuit breaker is open, instead of running handlers
+// whose queries would fail anyway
+func CircuitBreakerGuard(breaker BreakerChecker, retryAfter time.Duration) gin.HandlerFunc {
+	retryAfterSeconds := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+
+	return func(c *gin.Context) {
+		if breaker.Open() {
+			c.Header("Retry-After", retryAfterSeconds)
+			AbortWithError(c, http.StatusServiceUnavailable, "service_unavailable", "The database is unavailable. Please try again later.")
 			return
 		}
 
-		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize)
 		c.Next()
 	}
 }
@@ -245,11 +605,7 @@ func RequireContentType(contentType string) gin.HandlerFunc {
 		if c.Request.Method == "POST" || c.Request.Method == "PUT" || c.Request.Method == "PATCH" {
 			ct := c.GetHeader("Content-Type")
 			if ct != contentType {
-				c.JSON(http.StatusUnsupportedMediaType, gin.H{
-					"error":   "unsupported_media_type",
-					"message": fmt.Sprintf("Content-Type must be %s", contentType),
-				})
-				c.Abort()
+				AbortWithError(c, http.StatusUnsupportedMediaType, "unsupported_media_type", fmt.Sprintf("Content-Type must be %s", contentType))
 				return
 			}
 		}
@@ -257,34 +613,130 @@ func RequireContentType(contentType string) gin.HandlerFunc {
 	}
 }
 
-// TimeoutMiddleware adds request timeout
+// strictJSONKey marks requests whose JSON body must not carry unknown fields
+const strictJSONKey = "strict_json"
+
+// StrictJSON makes the handlers of a route reject JSON bodies with fields
+// the request type does not declare, instead of silently ignoring them, so
+// client typos surface as errors
+func StrictJSON() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(strictJSONKey, true)
+		c.Next()
+	}
+}
+
+// IsStrictJSON reports whether StrictJSON applies to the request
+func IsStrictJSON(c *gin.Context) bool {
+	return c.GetBool(strictJSONKey)
+}
+
+// TimeoutMiddleware bounds request handling to timeout. The handler runs on
+// the request goroutine with a context that expires after timeout, so
+// context-aware work such as database queries stops at the deadline. If the
+// handler has not started its response by then, whatever it writes later is
+// discarded and a 408 is sent instead; a response already under way is left
+// to complete.
 func TimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Create a context with timeout
 		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
 		defer cancel()
 
-		// Replace the request context
 		c.Request = c.Request.WithContext(ctx)
 
-		// Channel to signal when the request is done
-		done := make(chan struct{})
+		w := &timeoutWriter{ResponseWriter: c.Writer, ctx: ctx}
+		c.Writer = w
+		defer func() { c.Writer = w.ResponseWriter }()
 
-		go func() {
-			defer close(done)
-			c.Next()
-		}()
+		c.Next()
 
-		select {
-		case <-done:
-			// Request completed normally
-		case <-ctx.Done():
-			// Request timed out
-			c.JSON(http.StatusRequestTimeout, gin.H{
-				"error":   "request_timeout",
-				"message": "Request timed out",
-			})
-			c.Abort()
+		if w.timeout() {
+			c.Writer = w.ResponseWriter
+			AbortWithError(c, http.StatusRequestTimeout, "request_timeout", "Request timed out")
 		}
 	}
 }
+
+// timeoutWriter passes writes through until the request deadline. Once the
+// deadline has passed without a response being started, it drops all writes
+// so that only the timeout response reaches the client.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	ctx context.Context
+
+	mu       sync.Mutex
+	timedOut bool
+}
+
+// WriteHeader records the status code unless the request timed out
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.expired() {
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// WriteHeaderNow sends the headers unless the request timed out
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.expired() {
+		return
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// Write writes data unless the request timed out
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.expired() {
+		return 0, http.ErrHandlerTimeout
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString writes s unless the request timed out
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.expired() {
+		return 0, http.ErrHandlerTimeout
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Flush flushes buffered data unless the request timed out
+func (w *timeoutWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.expired() {
+		return
+	}
+	w.ResponseWriter.Flush()
+}
+
+// timeout reports whether the timeout response should be sent, which is the
+// case when the deadline passed before the handler started its response
+func (w *timeoutWriter) timeout() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.expired()
+}
+
+// expired reports, and latches, whether the deadline passed before the
+// response was started. It must be called with mu held.
+func (w *timeoutWriter) expired() bool {
+	if !w.timedOut && !w.ResponseWriter.Written() && errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		w.timedOut = true
+	}
+	return w.timedOut
+}
@@ -0,0 +1,629 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gin-service/internal/config"
+	"gin-service/internal/database"
+	"gin-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMaxSizeMiddleware_GroupOverridesGlobalLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(MaxSizeMiddleware(8))
+
+	readBody := func(c *gin.Context) {
+		if _, err := io.ReadAll(c.Request.Body); err != nil {
+			c.Status(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+	router.POST("/small", readBody)
+	large := router.Group("/large")
+	large.Use(MaxSizeMiddleware(1024))
+	large.POST("", readBody)
+
+	body := strings.Repeat("x", 64)
+
+	req, _ := http.NewRequest("POST", "/small", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	req, _ = http.NewRequest("POST", "/large", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestMaxSizeMiddleware_InnermostLimitApplies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(MaxSizeMiddleware(1024))
+
+	var limit int64
+	readBody := func(c *gin.Context) {
+		limit, _ = MaxSize(c)
+		if _, err := io.ReadAll(c.Request.Body); err != nil {
+			assert.True(t, IsRequestTooLarge(err))
+			c.Status(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+	small := router.Group("/small")
+	small.Use(MaxSizeMiddleware(8))
+	small.POST("", readBody)
+
+	post := func(contentLength int64) int {
+		req, _ := http.NewRequest("POST", "/small", strings.NewReader(strings.Repeat("x", 64)))
+		req.ContentLength = contentLength
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Bodies are cut off at the group's lower limit, whether they declare
+	// their length or lie about it
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(64))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(4))
+	assert.Equal(t, int64(8), limit)
+}
+
+func TestIsRequestTooLarge(t *testing.T) {
+	w := httptest.NewRecorder()
+	body := http.MaxBytesReader(w, io.NopCloser(strings.NewReader("too long")), 2)
+
+	_, err := io.ReadAll(body)
+
+	assert.True(t, IsRequestTooLarge(err))
+	assert.False(t, IsRequestTooLarge(io.ErrUnexpectedEOF))
+}
+
+// fakePool is a PoolChecker with a fixed state
+type fakePool struct {
+	exhausted bool
+}
+
+func (f *fakePool) Exhausted() bool {
+	return f.exhausted
+}
+
+func TestPoolExhaustionGuard(t *testing.T) {
+	pool := &fakePool{}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(PoolExhaustionGuard(pool, 1500*time.Millisecond))
+	router.GET("/users", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/users", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	pool.exhausted = true
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "service_unavailable")
+}
+
+type fakeBreaker struct {
+	open bool
+}
+
+func (f *fakeBreaker) Open() bool {
+	return f.open
+}
+
+func TestCircuitBreakerGuard(t *testing.T) {
+	breaker := &fakeBreaker{}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CircuitBreakerGuard(breaker, 10*time.Second))
+	router.GET("/users", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/users", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	breaker.open = true
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "service_unavailable")
+}
+
+func setupRateLimitRouter(warnThreshold float64) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RateLimit(&config.Config{Rate: config.RateConfig{
+		Enabled:       true,
+		RPS:           1,
+		Burst:         10,
+		Window:        "1m",
+		WarnThreshold: warnThreshold,
+	}}))
+	router.GET("/users", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func TestRateLimit_WarnsBeforeLimit(t *testing.T) {
+	router := setupRateLimitRouter(0.2)
+
+	req, _ := http.NewRequest("GET", "/users", nil)
+	for i := 1; i <= 11; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		switch {
+		case i <= 8:
+			// At least 20% of the burst remains
+			assert.Equal(t, http.StatusOK, w.Code, i)
+			assert.Empty(t, w.Header().Get("X-RateLimit-Warning"), i)
+		case i <= 10:
+			assert.Equal(t, http.StatusOK, w.Code, i)
+			assert.Equal(t, "true", w.Header().Get("X-RateLimit-Warning"), i)
+		default:
+			assert.Equal(t, http.StatusTooManyRequests, w.Code, i)
+			assert.Contains(t, w.Body.String(), "rate_limit_exceeded")
+		}
+	}
+}
+
+func TestRateLimit_WarningDisabled(t *testing.T) {
+	router := setupRateLimitRouter(0)
+
+	req, _ := http.NewRequest("GET", "/users", nil)
+	for i := 1; i <= 10; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code, i)
+		assert.Empty(t, w.Header().Get("X-RateLimit-Warning"), i)
+	}
+}
+
+func TestRateLimit_QuotaHeaders(t *testing.T) {
+	router := setupRateLimitRouter(0)
+
+	req, _ := http.NewRequest("GET", "/users", nil)
+	for i := 1; i <= 10; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code, i)
+		assert.Equal(t, "10", w.Header().Get("X-RateLimit-Limit"), i)
+		assert.Equal(t, strconv.Itoa(10-i), w.Header().Get("X-RateLimit-Remaining"), i)
+		assert.Empty(t, w.Header().Get("Retry-After"), i)
+	}
+
+	// The burst is used up, and a token comes back every second
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "10", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
+
+func setupRequestIDRouter(logger *zap.Logger) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestID())
+	router.Use(RequestLogger(logger))
+	router.GET("/test", func(c *gin.Context) {
+		Logger(c).Info("handler log")
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func TestRequestLogger_PropagatesIncomingRequestID(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	router := setupRequestIDRouter(zap.New(core))
+
+	req, _ := http.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, "req-123", w.Header().Get("X-Request-ID"))
+
+	entries := logs.All()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "handler log", entries[0].Message)
+	assert.Equal(t, "HTTP Request", entries[1].Message)
+	for _, entry := range entries {
+		assert.Equal(t, "req-123", entry.ContextMap()["request_id"], entry.Message)
+	}
+}
+
+func TestRequestLogger_GeneratesRequestID(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	router := setupRequestIDRouter(zap.New(core))
+
+	req, _ := http.NewRequest("GET", "/test", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	requestID := w.Header().Get("X-Request-ID")
+	require.NotEmpty(t, requestID)
+
+	// Both the handler's entry and the access log carry the generated ID
+	entries := logs.All()
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.Equal(t, requestID, entry.ContextMap()["request_id"], entry.Message)
+	}
+}
+
+// slowDB is a database.DBInterface whose Get takes a millisecond
+type slowDB struct {
+	database.DBInterface
+}
+
+func (slowDB) Get(dest interface{}, query string, args ...interface{}) error {
+	time.Sleep(time.Millisecond)
+	return nil
+}
+
+func TestRequestLogger_SlowQueriesCarryRequestID(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	queries := database.NewQueryLogger(slowDB{}, time.Nanosecond, zap.New(core))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestID())
+	router.Use(RequestLogger(zap.NewNop()))
+	router.GET("/test", func(c *gin.Context) {
+		var id int
+		_ = queries.GetContext(c.Request.Context(), &id, "SELECT 1")
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := logs.FilterMessage("Slow query").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "req-123", entries[0].ContextMap()["request_id"])
+}
+
+func TestRequestLoggerWithSlowRequests(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	slow := NewSlowRequests(50 * time.Millisecond)
+	router := gin.New()
+	router.Use(RequestID(), RequestLoggerWithSlowRequests(zap.New(core), slow))
+	router.GET("/fast", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.GET("/slow", func(c *gin.Context) {
+		time.Sleep(60 * time.Millisecond)
+		c.Status(http.StatusOK)
+	})
+	router.GET("/fail", func(c *gin.Context) {
+		AbortWithError(c, http.StatusInternalServerError, "internal_error", "Failed")
+	})
+	get := func(path string) observer.LoggedEntry {
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		entries := logs.TakeAll()
+		require.Len(t, entries, 1)
+		return entries[0]
+	}
+
+	entry := get("/fast")
+	assert.Equal(t, zapcore.DebugLevel, entry.Level)
+	assert.NotContains(t, entry.ContextMap(), "slow")
+
+	entry = get("/slow")
+	assert.Equal(t, zapcore.WarnLevel, entry.Level)
+	assert.Equal(t, true, entry.ContextMap()["slow"])
+
+	// Errors are logged at their own level however fast
+	entry = get("/fail")
+	assert.Equal(t, zapcore.ErrorLevel, entry.Level)
+
+	// Without a threshold, every request is logged at info level
+	slow.SetThreshold(0)
+	entry = get("/slow")
+	assert.Equal(t, zapcore.InfoLevel, entry.Level)
+	assert.NotContains(t, entry.ContextMap(), "slow")
+}
+
+func TestRequestLogger_LogsErrorCode(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	router := gin.New()
+	router.Use(RequestID(), RequestLogger(zap.New(core)))
+	router.GET("/ok", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.GET("/fail", func(c *gin.Context) {
+		AbortWithError(c, http.StatusConflict, "update_failed", "email already exists")
+	})
+
+	req, _ := http.NewRequest("GET", "/fail", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"error":"update_failed","message":"email already exists","request_id":"req-123"}`, w.Body.String())
+	entries := logs.All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, zapcore.WarnLevel, entries[0].Level)
+	assert.Equal(t, "update_failed", entries[0].ContextMap()["error_code"])
+
+	// Successful requests log no code
+	req, _ = http.NewRequest("GET", "/ok", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	entries = logs.All()
+	require.Len(t, entries, 2)
+	assert.NotContains(t, entries[1].ContextMap(), "error_code")
+}
+
+func TestRequestID_UsesCorrelationID(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	router := setupRequestIDRouter(zap.New(core))
+
+	req, _ := http.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-Correlation-ID", "corr-456")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, "corr-456", w.Header().Get("X-Request-ID"))
+	for _, entry := range logs.All() {
+		assert.Equal(t, "corr-456", entry.ContextMap()["request_id"], entry.Message)
+	}
+}
+
+func TestRequestID_PrefersRequestID(t *testing.T) {
+	router := setupRequestIDRouter(zap.NewNop())
+
+	req, _ := http.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	req.Header.Set("X-Correlation-ID", "corr-456")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, "req-123", w.Header().Get("X-Request-ID"))
+}
+
+func TestRequestID_ReplacesInvalidID(t *testing.T) {
+	router := setupRequestIDRouter(zap.NewNop())
+
+	for _, invalid := range []string{
+		"req 123",
+		"req-123\nlevel=error",
+		"<script>",
+		strings.Repeat("a", 129),
+	} {
+		req, _ := http.NewRequest("GET", "/test", nil)
+		req.Header.Set("X-Request-ID", invalid)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		requestID := w.Header().Get("X-Request-ID")
+		assert.NotEmpty(t, requestID, invalid)
+		assert.NotEqual(t, invalid, requestID, invalid)
+		assert.True(t, validRequestID(requestID), invalid)
+	}
+}
+
+func TestRequestID_FallsBackToValidCorrelationID(t *testing.T) {
+	router := setupRequestIDRouter(zap.NewNop())
+
+	req, _ := http.NewRequest("GET", "/test", nil)
+	req.Header.Set("X-Request-ID", "not valid")
+	req.Header.Set("X-Correlation-ID", "corr-456")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, "corr-456", w.Header().Get("X-Request-ID"))
+}
+
+func TestValidRequestID(t *testing.T) {
+	assert.True(t, validRequestID("0f8fad5b-d9cb-469f-a165-70867728950e"))
+	assert.True(t, validRequestID("1-5759e988:gateway_01.eu"))
+	assert.True(t, validRequestID(strings.Repeat("a", 128)))
+	assert.False(t, validRequestID(""))
+	assert.False(t, validRequestID(strings.Repeat("a", 129)))
+	assert.False(t, validRequestID("id with spaces"))
+	assert.False(t, validRequestID("id\r\nX-Injected: 1"))
+	assert.False(t, validRequestID("idé"))
+}
+
+func TestLogger_WithoutRequestLogger(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	assert.Same(t, zap.L(), Logger(c))
+}
+
+func TestLogger_ScopedToAuthenticatedRequest(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	cfg := &config.Config{JWT: config.JWTConfig{Secret: "test-secret", ExpirationTime: config.Duration(time.Hour), Issuer: "test"}}
+	jwtService := NewJWTService(cfg, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestID())
+	router.Use(RequestLogger(zap.New(core)))
+	router.GET("/profile", AuthMiddleware(jwtService), func(c *gin.Context) {
+		Logger(c).Info("handler log")
+		c.Status(http.StatusOK)
+	})
+
+	token, err := jwtService.GenerateToken(&models.User{ID: 42, Username: "testuser"})
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/profile?verbose=1", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	entries := logs.FilterMessage("handler log").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, map[string]interface{}{
+		"request_id": "req-123",
+		"trace_id":   "req-123",
+		"method":     "GET",
+		"path":       "/profile",
+		"user_id":    int64(42),
+	}, entries[0].ContextMap())
+
+	// The access log names the user too
+	access := logs.FilterMessage("HTTP Request").All()
+	require.Len(t, access, 1)
+	assert.Equal(t, int64(42), access[0].ContextMap()["user_id"])
+	assert.Equal(t, "/profile?verbose=1", access[0].ContextMap()["path"])
+}
+
+func setupPanicRouter(logger *zap.Logger, exposeStack bool) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ErrorHandler(logger, exposeStack))
+	router.Use(RequestID())
+	router.Use(RequestLogger(logger))
+	router.GET("/panic", func(c *gin.Context) {
+		panic("something broke")
+	})
+	return router
+}
+
+func TestErrorHandler_LogsStackAndRequestID(t *testing.T) {
+	core, logs := observer.New(zapcore.ErrorLevel)
+	router := setupPanicRouter(zap.New(core), false)
+
+	req, _ := http.NewRequest("GET", "/panic", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	entries := logs.FilterMessage("Panic recovered").All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "req-123", fields["request_id"])
+	assert.Equal(t, "something broke", fields["error"])
+	assert.Contains(t, fields["stack"], "TestErrorHandler_LogsStackAndRequestID")
+}
+
+func TestErrorHandler_StackHiddenByDefault(t *testing.T) {
+	router := setupPanicRouter(zap.NewNop(), false)
+
+	req, _ := http.NewRequest("GET", "/panic", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"error": "internal_server_error", "message": "An internal server error occurred", "request_id": "req-123"}`, w.Body.String())
+}
+
+func TestErrorHandler_ExposesStack(t *testing.T) {
+	router := setupPanicRouter(zap.NewNop(), true)
+
+	req, _ := http.NewRequest("GET", "/panic", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response struct {
+		Error   string   `json:"error"`
+		Message string   `json:"message"`
+		Stack   []string `json:"stack"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "internal_server_error", response.Error)
+	assert.NotEmpty(t, response.Message)
+	assert.Contains(t, strings.Join(response.Stack, "\n"), "TestErrorHandler_ExposesStack")
+}
+
+func setupTimeoutRouter(timeout time.Duration) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(TimeoutMiddleware(timeout))
+	router.GET("/fast", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	// slow waits for the deadline like a cancelled query, then reports the
+	// error it got
+	router.GET("/slow", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": c.Request.Context().Err().Error()})
+	})
+	// streaming starts its response before the deadline
+	router.GET("/streaming", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		c.Writer.WriteHeaderNow()
+		<-c.Request.Context().Done()
+		c.String(http.StatusOK, "done")
+	})
+	return router
+}
+
+func TestTimeoutMiddleware_CompletesBeforeDeadline(t *testing.T) {
+	router := setupTimeoutRouter(time.Second)
+
+	req, _ := http.NewRequest("GET", "/fast", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status": "ok"}`, w.Body.String())
+}
+
+func TestTimeoutMiddleware_HandlerObservesDeadline(t *testing.T) {
+	router := setupTimeoutRouter(10 * time.Millisecond)
+
+	req, _ := http.NewRequest("GET", "/slow", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Only the timeout response is written, not the handler's late error
+	assert.Equal(t, http.StatusRequestTimeout, w.Code)
+	assert.JSONEq(t, `{"error": "request_timeout", "message": "Request timed out"}`, w.Body.String())
+}
+
+func TestTimeoutMiddleware_KeepsStartedResponse(t *testing.T) {
+	router := setupTimeoutRouter(10 * time.Millisecond)
+
+	req, _ := http.NewRequest("GET", "/streaming", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "done", w.Body.String())
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi2"
+	"github.com/getkin/kin-openapi/openapi2conv"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// OpenAPIValidator validates requests against an OpenAPI specification
+type OpenAPIValidator struct {
+	router routers.Router
+}
+
+// NewOpenAPIValidator loads the spec at specPath and creates a validator for it.
+// Both Swagger 2.0 documents, as generated by swag, and OpenAPI 3 documents
+// are supported.
+func NewOpenAPIValidator(specPath string) (*OpenAPIValidator, error) {
+	data, err := os.ReadFile(specPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenAPI spec: %w", err)
+	}
+
+	doc, err := loadOpenAPISpec(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OpenAPI spec: %w", err)
+	}
+
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI spec: %w", err)
+	}
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build OpenAPI router: %w", err)
+	}
+
+	return &OpenAPIValidator{router: router}, nil
+}
+
+// loadOpenAPISpec parses a Swagger 2.0 or OpenAPI 3 document
+func loadOpenAPISpec(data []byte) (*openapi3.T, error) {
+	var version struct {
+		Swagger string `json:"swagger"`
+	}
+	if err := json.Unmarshal(data, &version); err != nil {
+		return nil, err
+	}
+
+	var doc *openapi3.T
+	if version.Swagger != "" {
+		var doc2 openapi2.T
+		if err := json.Unmarshal(data, &doc2); err != nil {
+			return nil, err
+		}
+		converted, err := openapi2conv.ToV3(&doc2)
+		if err != nil {
+			return nil, err
+		}
+		doc = converted
+	} else {
+		loaded, err := openapi3.NewLoader().LoadFromData(data)
+		if err != nil {
+			return nil, err
+		}
+		doc = loaded
+	}
+
+	// Match on the base path only, so the spec's host does not have to
+	// match the host the service is reached through
+	for _, server := range doc.Servers {
+		if u, err := url.Parse(server.URL); err == nil {
+			server.URL = u.Path
+		}
+	}
+
+	return doc, nil
+}
+
+// Middleware returns a handler validating request parameters and bodies
+// against the spec. Requests to operations not described by the spec are
+// passed through unchanged.
+func (v *OpenAPIValidator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route, pathParams, err := v.router.FindRoute(c.Request)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				// Authentication is enforced by AuthMiddleware
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+				// The spec describes JSON bodies; MessagePack bodies are
+				// validated as handlers bind them
+				ExcludeRequestBody: c.ContentType() == binding.MIMEMSGPACK || c.ContentType() == binding.MIMEMSGPACK2,
+			},
+		}
+
+		if err := openapi3filter.ValidateRequest(c.Request.Context(), input); err != nil {
+			if IsRequestTooLarge(err) {
+				AbortWithError(c, http.StatusRequestEntityTooLarge, "request_too_large", "Request body too large")
+				return
+			}
+
+			SetErrorCode(c, "validation_error")
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorEnvelope(c, openAPIErrorResponse(c, err)))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// openAPIErrorResponse builds a response body for a validation error. Schema
+// violations carry the JSON pointer of the offending value.
+func openAPIErrorResponse(c *gin.Context, err error) gin.H {
+	response := errorBody(c, "validation_error", err.Error())
+
+	var requestErr *openapi3filter.RequestError
+	if !errors.As(err, &requestErr) {
+		return response
+	}
+
+	location := "request body"
+	if requestErr.Parameter != nil {
+		location = fmt.Sprintf("%s parameter %q", requestErr.Parameter.In, requestErr.Parameter.Name)
+		response["parameter"] = requestErr.Parameter.Name
+		response["in"] = requestErr.Parameter.In
+	}
+
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		pointer := jsonPointer(schemaErr.JSONPointer())
+		response["pointer"] = pointer
+		response["message"] = fmt.Sprintf("%s: %s: %s", location, pointer, schemaErr.Reason)
+	}
+
+	return response
+}
+
+// jsonPointer formats path segments as an RFC 6901 JSON pointer
+func jsonPointer(segments []string) string {
+	escaper := strings.NewReplacer("~", "~0", "/", "~1")
+
+	var b strings.Builder
+	for _, segment := range segments {
+		b.WriteByte('/')
+		b.WriteString(escaper.Replace(segment))
+	}
+	if b.Len() == 0 {
+		return "/"
+	}
+	return b.String()
+}
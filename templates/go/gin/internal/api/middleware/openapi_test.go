@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSwaggerSpec is a minimal swag-style Swagger 2.0 document
+const testSwaggerSpec = `{
+	"swagger": "2.0",
+	"info": {"title": "Gin REST API", "version": "1.0"},
+	"host": "localhost:8080",
+	"basePath": "/api/v1",
+	"paths": {
+		"/auth/register": {
+			"post": {
+				"consumes": ["application/json"],
+				"parameters": [{
+					"in": "body",
+					"name": "user",
+					"required": true,
+					"schema": {"$ref": "#/definitions/models.CreateUserRequest"}
+				}],
+				"responses": {"201": {"description": "Created"}}
+			}
+		},
+		"/users/{id}": {
+			"get": {
+				"parameters": [{"in": "path", "name": "id", "required": true, "type": "integer"}],
+				"responses": {"200": {"description": "OK"}}
+			}
+		}
+	},
+	"definitions": {
+		"models.CreateUserRequest": {
+			"type": "object",
+			"required": ["username", "email", "password"],
+			"properties": {
+				"username": {"type": "string", "minLength": 3, "maxLength": 50},
+				"email": {"type": "string"},
+				"password": {"type": "string", "minLength": 8},
+				"profile": {
+					"type": "object",
+					"properties": {"age": {"type": "integer", "minimum": 0}}
+				}
+			}
+		}
+	}
+}`
+
+func setupOpenAPIRouter(t *testing.T) *gin.Engine {
+	specPath := filepath.Join(t.TempDir(), "swagger.json")
+	require.NoError(t, os.WriteFile(specPath, []byte(testSwaggerSpec), 0o644))
+
+	validator, err := NewOpenAPIValidator(specPath)
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	v1 := router.Group("/api/v1")
+	v1.Use(validator.Middleware())
+
+	// Echo the body to check it is still readable after validation
+	v1.POST("/auth/register", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Data(http.StatusCreated, "application/json", body)
+	})
+	v1.GET("/users/:id", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	v1.GET("/undocumented", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func TestOpenAPIValidator_ConformantBody(t *testing.T) {
+	router := setupOpenAPIRouter(t)
+
+	body := `{"username":"testuser","email":"test@example.com","password":"password123"}`
+	req, _ := http.NewRequest("POST", "/api/v1/auth/register", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, body, w.Body.String())
+}
+
+func TestOpenAPIValidator_NonConformantBody(t *testing.T) {
+	router := setupOpenAPIRouter(t)
+
+	tests := []struct {
+		name    string
+		body    string
+		pointer string
+	}{
+		{
+			name:    "too short",
+			body:    `{"username":"ab","email":"test@example.com","password":"password123"}`,
+			pointer: "/username",
+		},
+		{
+			name:    "missing required property",
+			body:    `{"username":"testuser","password":"password123"}`,
+			pointer: "/email",
+		},
+		{
+			name:    "nested value",
+			body:    `{"username":"testuser","email":"test@example.com","password":"password123","profile":{"age":-1}}`,
+			pointer: "/profile/age",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/api/v1/auth/register", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+
+			var response map[string]string
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, "validation_error", response["error"])
+			assert.Equal(t, tt.pointer, response["pointer"])
+			assert.Contains(t, response["message"], tt.pointer)
+		})
+	}
+}
+
+func TestOpenAPIValidator_InvalidPathParameter(t *testing.T) {
+	router := setupOpenAPIRouter(t)
+
+	req, _ := http.NewRequest("GET", "/api/v1/users/abc", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "id", response["parameter"])
+	assert.Equal(t, "path", response["in"])
+}
+
+func TestOpenAPIValidator_UndocumentedRoutePassesThrough(t *testing.T) {
+	router := setupOpenAPIRouter(t)
+
+	req, _ := http.NewRequest("GET", "/api/v1/undocumented", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestJSONPointer(t *testing.T) {
+	assert.Equal(t, "/", jsonPointer(nil))
+	assert.Equal(t, "/a~1b/c~0d/0", jsonPointer([]string{"a/b", "c~d", "0"}))
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// SlidingLog limits each key to a number of events in any window, keeping
+// the time of each allowed event. Unlike the token buckets of RateLimiter it
+// never lets a burst through at the edge of a window, which suits rare and
+// sensitive requests such as logins.
+type SlidingLog struct {
+	mu     sync.Mutex
+	events map[string][]time.Time
+	limit  int
+	window time.Duration
+
+	// lastSweep is when keys with no events left in the window were last
+	// dropped, done at most once per window
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewSlidingLog creates a sliding log allowing limit events per key in any
+// window
+func NewSlidingLog(limit int, window time.Duration) *SlidingLog {
+	return &SlidingLog{
+		events: make(map[string][]time.Time),
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+	}
+}
+
+// Allow records an event for key if fewer than the limit happened in the
+// last window. A denied event is not recorded, and learns how long until the
+// oldest event leaves the window.
+func (l *SlidingLog) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	cutoff := now.Add(-l.window)
+	if now.Sub(l.lastSweep) >= l.window {
+		l.sweep(cutoff)
+		l.lastSweep = now
+	}
+
+	events := prune(l.events[key], cutoff)
+	if len(events) >= l.limit {
+		l.events[key] = events
+		return false, events[0].Sub(cutoff)
+	}
+	l.events[key] = append(events, now)
+	return true, 0
+}
+
+// sweep drops the keys with no events after cutoff
+func (l *SlidingLog) sweep(cutoff time.Time) {
+	for key, events := range l.events {
+		if len(prune(events, cutoff)) == 0 {
+			delete(l.events, key)
+		}
+	}
+}
+
+// prune drops the events at or before cutoff, which are in order
+func prune(events []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(events) && !events[i].After(cutoff) {
+		i++
+	}
+	return events[i:]
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlidingLog(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	log := NewSlidingLog(3, time.Minute)
+	log.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		allowed, _ := log.Allow("10.0.0.1")
+		assert.True(t, allowed, "attempt %d", i+1)
+		now = now.Add(10 * time.Second)
+	}
+
+	// The first attempt leaves the window 60s after it was made
+	allowed, retryAfter := log.Allow("10.0.0.1")
+	assert.False(t, allowed)
+	assert.Equal(t, 30*time.Second, retryAfter)
+
+	// Other keys have their own log
+	allowed, _ = log.Allow("10.0.0.2")
+	assert.True(t, allowed)
+
+	// Denied attempts are not recorded, so the window slides on
+	now = now.Add(30 * time.Second)
+	allowed, _ = log.Allow("10.0.0.1")
+	assert.True(t, allowed)
+	allowed, retryAfter = log.Allow("10.0.0.1")
+	assert.False(t, allowed)
+	assert.Equal(t, 10*time.Second, retryAfter)
+}
+
+func TestSlidingLog_Sweep(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	log := NewSlidingLog(1, time.Minute)
+	log.now = func() time.Time { return now }
+
+	log.Allow("10.0.0.1")
+	log.Allow("10.0.0.2")
+	assert.Len(t, log.events, 2)
+
+	now = now.Add(2 * time.Minute)
+	log.Allow("10.0.0.3")
+	assert.Len(t, log.events, 1)
+	assert.Contains(t, log.events, "10.0.0.3")
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// traceparentHeader is the W3C Trace Context header, set by OpenTelemetry
+// instrumented clients and proxies
+const traceparentHeader = "traceparent"
+
+// traceIDKey is the gin context key for the request's trace ID
+const traceIDKey = "trace_id"
+
+// requestTraceID returns the trace ID of the request: the one propagated in
+// its traceparent header by a traced caller, else the request ID, so that
+// every request's logs share a trace ID either way
+func requestTraceID(c *gin.Context) string {
+	if id, ok := parseTraceparent(c.GetHeader(traceparentHeader)); ok {
+		return id
+	}
+	return GetRequestID(c)
+}
+
+// parseTraceparent returns the trace ID of a version 00 traceparent header,
+// "00-<trace ID>-<parent ID>-<flags>", if valid
+func parseTraceparent(header string) (string, bool) {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return "", false
+	}
+	id := parts[1]
+	if len(id) != 32 || !isLowerHex(id) || id == strings.Repeat("0", 32) {
+		return "", false
+	}
+	if len(parts[2]) != 16 || !isLowerHex(parts[2]) || len(parts[3]) != 2 || !isLowerHex(parts[3]) {
+		return "", false
+	}
+	return id, true
+}
+
+// isLowerHex reports whether s is only lowercase hex digits
+func isLowerHex(s string) bool {
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// GetTraceID gets the trace ID RequestLogger gives the request, logged as
+// trace_id by the access log and the request-scoped logger
+func GetTraceID(c *gin.Context) string {
+	return c.GetString(traceIDKey)
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestLogger_TraceID(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	router := setupRequestIDRouter(zap.New(core))
+
+	tests := []struct {
+		name        string
+		traceparent string
+		want        string
+	}{
+		{"propagated", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"invalid falls back to the request ID", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "req-123"},
+		{"missing falls back to the request ID", "", "req-123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/test", nil)
+			req.Header.Set("X-Request-ID", "req-123")
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			// The handler's entry carries the trace ID of the access log
+			entries := logs.TakeAll()
+			require.Len(t, entries, 2)
+			assert.Equal(t, "handler log", entries[0].Message)
+			assert.Equal(t, "HTTP Request", entries[1].Message)
+			for _, entry := range entries {
+				assert.Equal(t, tt.want, entry.ContextMap()["trace_id"], entry.Message)
+			}
+		})
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	id, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", id)
+
+	for _, header := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bz-01",
+	} {
+		_, ok := parseTraceparent(header)
+		assert.False(t, ok, header)
+	}
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gin-service/internal/config"
+	"gin-service/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTrustedHeaderRouter(t *testing.T) (*gin.Engine, *JWTService) {
+	proxies, err := config.TrustedHeaderAuthConfig{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.5"}}.Networks()
+	require.NoError(t, err)
+
+	jwtService := newTestJWTService()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(TrustedHeaderAuthMiddleware(proxies, AuthMiddleware(jwtService)))
+	router.GET("/me", func(c *gin.Context) {
+		userID, _ := GetUserID(c)
+		email, _ := c.Get("email")
+		c.JSON(http.StatusOK, gin.H{"user_id": userID, "email": email, "is_admin": IsAdmin(c)})
+	})
+	return router, jwtService
+}
+
+func TestTrustedHeaderAuthMiddleware_TrustedProxy(t *testing.T) {
+	router, _ := setupTrustedHeaderRouter(t)
+
+	for _, remoteAddr := range []string{"10.1.2.3:41000", "192.168.1.5:41000"} {
+		req := httptest.NewRequest("GET", "/me", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(HeaderAuthUserID, "42")
+		req.Header.Set(HeaderAuthEmail, "jdoe@example.com")
+		req.Header.Set(HeaderAuthRoles, "user, Admin")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code, remoteAddr)
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, float64(42), body["user_id"])
+		assert.Equal(t, "jdoe@example.com", body["email"])
+		assert.Equal(t, true, body["is_admin"])
+	}
+
+	// A trusted proxy must still send a valid user ID
+	req := httptest.NewRequest("GET", "/me", nil)
+	req.RemoteAddr = "10.1.2.3:41000"
+	req.Header.Set(HeaderAuthUserID, "jdoe")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestTrustedHeaderAuthMiddleware_UntrustedSource(t *testing.T) {
+	router, jwtService := setupTrustedHeaderRouter(t)
+
+	// Forwarding headers don't make a client a trusted proxy
+	req := httptest.NewRequest("GET", "/me", nil)
+	req.RemoteAddr = "203.0.113.7:41000"
+	req.Header.Set("X-Forwarded-For", "10.1.2.3")
+	req.Header.Set(HeaderAuthUserID, "1")
+	req.Header.Set(HeaderAuthRoles, "admin")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Not even alongside a valid token
+	token, err := jwtService.GenerateToken(&models.User{ID: 7, Username: "testuser"})
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Without identity headers, requests authenticate with a JWT as usual
+	req = httptest.NewRequest("GET", "/me", nil)
+	req.RemoteAddr = "203.0.113.7:41000"
+	req.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, float64(7), body["user_id"])
+	assert.Equal(t, false, body["is_admin"])
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// API versions, each served under /api/<version>
+const (
+	APIv1 = "v1"
+	APIv2 = "v2"
+)
+
+// apiVersionKey is the gin context key for the API version of the route
+const apiVersionKey = "api_version"
+
+// APIRequests counts the requests to the versioned API by version and
+// response status, showing which clients are left on an old version
+var APIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gin_service",
+	Name:      "api_requests_total",
+	Help:      "Requests to the versioned API, by API version and response status.",
+}, []string{"version", "status"})
+
+// APIVersion marks the requests of a route group as made to version of the
+// API, for handlers to shape their responses by. The version is added to the
+// request-scoped logger and the request log line, and counted in
+// APIRequests.
+func APIVersion(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(apiVersionKey, version)
+		c.Set(loggerKey, Logger(c).With(zap.String("api_version", version)))
+
+		c.Next()
+
+		APIRequests.WithLabelValues(version, strconv.Itoa(c.Writer.Status())).Inc()
+	}
+}
+
+// GetAPIVersion gets the API version set by APIVersion
+func GetAPIVersion(c *gin.Context) (string, bool) {
+	version, exists := c.Get(apiVersionKey)
+	if !exists {
+		return "", false
+	}
+	return version.(string), true
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAPIVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	core, logs := observer.New(zapcore.InfoLevel)
+	router := gin.New()
+	router.Use(RequestID(), RequestLogger(zap.New(core)))
+	router.GET("/unversioned", func(c *gin.Context) {
+		_, ok := GetAPIVersion(c)
+		assert.False(t, ok)
+		c.Status(http.StatusOK)
+	})
+	for _, version := range []string{APIv1, APIv2} {
+		version := version
+		router.GET("/api/"+version+"/test", APIVersion(version), func(c *gin.Context) {
+			got, ok := GetAPIVersion(c)
+			assert.True(t, ok)
+			assert.Equal(t, version, got)
+			Logger(c).Info("Handled")
+			c.Status(http.StatusOK)
+		})
+	}
+	before := testutil.ToFloat64(APIRequests.WithLabelValues(APIv2, "200"))
+
+	req, _ := http.NewRequest("GET", "/api/v2/test", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Both the handler's entry and the request line carry the version
+	entries := logs.All()
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.Equal(t, APIv2, entry.ContextMap()["api_version"], entry.Message)
+	}
+	assert.Equal(t, before+1, testutil.ToFloat64(APIRequests.WithLabelValues(APIv2, "200")))
+
+	req, _ = http.NewRequest("GET", "/unversioned", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	entries = logs.All()
+	require.Len(t, entries, 3)
+	assert.NotContains(t, entries[2].ContextMap(), "api_version")
+}
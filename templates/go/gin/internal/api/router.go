@@ -1,50 +1,199 @@
 package api
 
 import (
+	"database/sql"
+	"net/http"
 	"time"
 
 	"gin-service/internal/api/handlers"
 	"gin-service/internal/api/middleware"
 	"gin-service/internal/config"
 	"gin-service/internal/database"
+	"gin-service/internal/graph"
+	"gin-service/internal/lifecycle"
+	"gin-service/internal/mail"
+	"gin-service/internal/repository"
 	"gin-service/internal/services"
+	"gin-service/internal/storage"
 
-	"github.com/gin-contrib/requestid"
+	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"go.uber.org/zap"
 )
 
-// NewRouter creates and configures the main router
-func NewRouter(cfg *config.Config, db *database.DB, logger *zap.Logger) *gin.Engine {
+// NewRouter creates and configures the main router. Users are cached in
+// userCache unless it is nil. Readiness follows the service's lifecycle
+// phases.
+func NewRouter(cfg *config.Config, db *database.DB, userCache *services.UserCache, phases *lifecycle.State, logger *zap.Logger) *gin.Engine {
 	// Set Gin mode based on environment
 	if cfg.Service.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
 	// Create router
-	router := gin.New()
+	router, err := newEngine(cfg)
+	if err != nil {
+		logger.Fatal("Invalid trusted proxies", zap.Error(err))
+	}
 
 	// Initialize JWT service
 	jwtService := middleware.NewJWTService(cfg, logger)
+	requireAuth := middleware.AuthMiddleware(jwtService)
+	optionalAuth := middleware.OptionalAuthMiddleware(jwtService)
+
+	// Behind an authenticating gateway, its identity headers stand in for
+	// JWTs on requests coming from the gateway
+	if cfg.TrustedHeaderAuth.Enabled {
+		proxies, err := cfg.TrustedHeaderAuth.Networks()
+		if err != nil {
+			logger.Fatal("Invalid trusted proxies", zap.Error(err))
+		}
+		requireAuth = middleware.TrustedHeaderAuthMiddleware(proxies, requireAuth)
+		optionalAuth = middleware.TrustedHeaderAuthMiddleware(proxies, optionalAuth)
+	}
 
 	// Initialize services
-	userService := services.NewUserService(db, logger)
+	queryLogger := database.NewQueryLogger(db, cfg.Database.SlowQueryThreshold, logger)
+	var storeDB database.DBInterface = queryLogger
+	// After repeated connection errors, database calls fail at once instead
+	// of each waiting for the connection timeout
+	var breaker *database.CircuitBreaker
+	if cfg.Database.CircuitBreaker.Enabled {
+		breaker = database.NewCircuitBreaker(cfg.Database.CircuitBreaker.FailureThreshold, cfg.Database.CircuitBreaker.Cooldown)
+		storeDB = database.NewCircuitBreakerDB(queryLogger, breaker)
+	}
+	store := repository.NewSQLStore(storeDB)
+	store.SetRetryPolicy(database.RetryPolicy{
+		MaxAttempts: cfg.Database.Retry.MaxAttempts,
+		BaseDelay:   cfg.Database.Retry.BaseDelay,
+		MaxDelay:    cfg.Database.Retry.MaxDelay,
+	})
+	// Rank user searches with pg_trgm when the migration could install it
+	trigram, err := db.HasExtension("pg_trgm")
+	if err != nil {
+		logger.Warn("Failed to detect pg_trgm, user search falls back to substring matching", zap.Error(err))
+	} else if !trigram {
+		logger.Warn("pg_trgm is not installed, user search falls back to substring matching")
+	}
+	store.SetTrigramSearch(trigram)
+	userService := services.NewUserService(store, logger)
+	userService.SetCache(userCache)
+	// Logins are throttled per client IP, over REST and GraphQL alike
+	if cfg.Rate.Login.Enabled {
+		userService.SetLoginThrottle(middleware.NewSlidingLog(cfg.Rate.Login.MaxAttempts, cfg.Rate.Login.Window))
+	}
+
+	// List endpoints share the configured page sizes
+	database.SetPaginationLimits(cfg.Pagination.DefaultLimit, cfg.Pagination.MaxLimit)
+
+	// Uploaded avatars are kept on local disk and served by the router
+	blobs := storage.NewLocalStore(cfg.Storage.LocalDir, cfg.Storage.BaseURL)
+	userService.SetAvatarStore(blobs, services.AvatarLimits{
+		MaxSize:   cfg.Avatar.MaxSize,
+		MaxWidth:  cfg.Avatar.MaxWidth,
+		MaxHeight: cfg.Avatar.MaxHeight,
+	})
+
+	// TOTP secrets are encrypted at rest; 2FA stays off without a key
+	if cfg.TwoFactor.EncryptionKey != "" {
+		secretCipher, err := services.NewSecretCipher(cfg.TwoFactor.EncryptionKey)
+		if err != nil {
+			logger.Fatal("Failed to initialize two-factor encryption", zap.Error(err))
+		}
+		userService.SetTwoFactor(services.TwoFactorOptions{
+			Issuer:        cfg.TwoFactor.Issuer,
+			Cipher:        secretCipher,
+			Skew:          cfg.TwoFactor.Skew,
+			ChallengeTTL:  cfg.TwoFactor.ChallengeTTL,
+			MaxAttempts:   cfg.TwoFactor.MaxAttempts,
+			MaxFailures:   cfg.TwoFactor.MaxFailures,
+			FailureWindow: cfg.TwoFactor.FailureWindow,
+		})
+	}
+
+	// Emails are logged unless a mail server is configured
+	var mailer mail.Sender = mail.NewLogSender(logger)
+	switch cfg.Mail.Driver {
+	case "smtp":
+		mailer = mail.NewSMTPSender(cfg.Mail.SMTP.Host, cfg.Mail.SMTP.Port, cfg.Mail.SMTP.Username, cfg.Mail.SMTP.Password, cfg.Mail.From)
+	case "none":
+		mailer = mail.NopSender{}
+	}
+	userService.SetMailer(mailer)
+
+	// Email changes are confirmed with a link sent to the new address
+	userService.SetEmailChange(services.EmailChangeOptions{
+		TTL:        cfg.EmailChange.TokenTTL,
+		ConfirmURL: cfg.EmailChange.ConfirmURL,
+	})
+
+	// Requested account deletions can be cancelled during the grace period
+	userService.SetDeletionGracePeriod(cfg.Deletion.GracePeriod.Duration())
 
 	// Initialize handlers
 	healthHandler := handlers.NewHealthHandler(db, logger)
+	healthHandler.SetMigrator(db)
+	healthHandler.SetLifecycle(phases)
+	if breaker != nil {
+		healthHandler.SetCircuitBreaker(breaker)
+	}
+	healthHandler.SetProcessLimits(handlers.ProcessLimits{
+		MaxMemoryBytes: uint64(cfg.Health.MaxMemoryMB) * 1024 * 1024,
+		MaxGoroutines:  cfg.Health.MaxGoroutines,
+		FailReadiness:  cfg.Health.FailReadiness,
+	})
+	healthHandler.SetCheckTimeout(cfg.Health.CheckTimeout.Duration())
 	userHandler := handlers.NewUserHandler(userService, jwtService, logger)
+	userHandler.SetBatchGetMaxIDs(cfg.Users.BatchGetMaxIDs)
+	userHandler.SetBatchDeleteMaxIDs(cfg.Users.BatchDeleteMaxIDs)
+	webhookHandler := handlers.NewWebhookHandler(services.NewWebhookService(store, logger))
+
+	// Maintenance mode and its message follow the config file, and admins
+	// can change them in between
+	maintenance := middleware.NewMaintenance(cfg.Maintenance)
+	maintenanceHandler := handlers.NewMaintenanceHandler(maintenance)
+	fileMaintenance, fileMessage := cfg.Maintenance.Enabled, cfg.Maintenance.Message
+	// Requests slower than the threshold are logged as such, and the
+	// threshold follows the config file
+	slowRequests := middleware.NewSlowRequests(cfg.Log.SlowRequestThreshold.Duration())
+	config.Watch(func(reloaded *config.Config) {
+		if threshold := reloaded.Log.SlowRequestThreshold.Duration(); threshold != slowRequests.Threshold() {
+			slowRequests.SetThreshold(threshold)
+			logger.Info("Slow request threshold changed by config reload", zap.Duration("threshold", threshold))
+		}
+		if reloaded.Maintenance.Message != fileMessage {
+			fileMessage = reloaded.Maintenance.Message
+			maintenance.SetMessage(fileMessage)
+			logger.Info("Maintenance message changed by config reload", zap.String("message", maintenance.Message()))
+		}
+		if reloaded.Maintenance.Enabled != fileMaintenance {
+			fileMaintenance = reloaded.Maintenance.Enabled
+			maintenance.SetEnabled(fileMaintenance)
+			logger.Warn("Maintenance mode switched by config reload", zap.Bool("enabled", fileMaintenance))
+		}
+	}, func(err error) {
+		logger.Error("Ignoring invalid config reload", zap.Error(err))
+	})
 
 	// Global middleware
-	router.Use(middleware.ErrorHandler(logger))
-	router.Use(requestid.New())
-	router.Use(middleware.RequestLogger(logger))
+	router.Use(middleware.ErrorHandler(logger, cfg.Service.Environment != "production"))
+	router.Use(middleware.RequestID())
+	router.Use(middleware.ResponseEnvelope(cfg.Server.ResponseEnvelope))
+	router.Use(middleware.RequestLoggerWithSlowRequests(logger, slowRequests))
 	router.Use(middleware.SecurityHeaders())
 	router.Use(middleware.SetupCORS(cfg))
+	router.Use(middleware.MaintenanceMode(maintenance, jwtService))
+	if cfg.Server.Compression.Enabled {
+		router.Use(middleware.Compression(cfg.Server.Compression))
+	}
 	router.Use(middleware.RateLimit(cfg))
-	router.Use(middleware.MaxSizeMiddleware(10 * 1024 * 1024)) // 10MB max request size
+	router.Use(middleware.Concurrency(cfg.Server.MaxConcurrentRequests))
+	router.Use(middleware.MaxSizeMiddleware(cfg.Server.BodyLimits.Default))
 	router.Use(middleware.TimeoutMiddleware(30 * time.Second)) // 30 second timeout
 
 	// Health check endpoints (no auth required)
@@ -54,47 +203,113 @@ func NewRouter(cfg *config.Config, db *database.DB, logger *zap.Logger) *gin.Eng
 	router.GET("/live", healthHandler.Liveness)
 
 	// Metrics endpoint for Prometheus
-	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/metrics", gin.WrapH(newMetricsHandler(db.DB.DB)))
+
+	// Uploaded files, without directory listings
+	router.StaticFS(cfg.Storage.BaseURL, gin.Dir(blobs.Dir(), false))
 
 	// Swagger documentation (only in non-production)
 	if cfg.Service.Environment != "production" {
 		router.GET("/docs/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 	}
 
+	// OpenAPI request validation, enabled per route group
+	var openAPI *middleware.OpenAPIValidator
+	if len(cfg.OpenAPI.ValidateGroups) > 0 {
+		var err error
+		openAPI, err = middleware.NewOpenAPIValidator(cfg.OpenAPI.SpecPath)
+		if err != nil {
+			logger.Fatal("Failed to load OpenAPI spec", zap.String("path", cfg.OpenAPI.SpecPath), zap.Error(err))
+		}
+	}
+
 	// API v1 routes
 	v1 := router.Group("/api/v1")
+	poolMonitor := database.NewPoolMonitor(db.Stats, cfg.Database.PoolWaitThreshold)
+	poolGuard := middleware.PoolExhaustionGuard(poolMonitor, cfg.Database.PoolRetryAfter)
+	v1.Use(middleware.APIVersion(middleware.APIv1), poolGuard)
+	if breaker != nil {
+		v1.Use(middleware.CircuitBreakerGuard(breaker, cfg.Database.CircuitBreaker.RetryAfter))
+	}
+	// Routes taking user fields reject unknown ones, catching client typos
+	strictJSON := middleware.StrictJSON()
 	{
 		// Authentication routes (no auth required)
 		auth := v1.Group("/auth")
+		auth.Use(middleware.MaxSizeMiddleware(cfg.Server.BodyLimits.Auth))
+		if cfg.OpenAPI.Validates("auth") {
+			auth.Use(openAPI.Middleware())
+		}
 		{
-			auth.POST("/register", userHandler.Register)
+			auth.POST("/register", strictJSON, userHandler.Register)
 			auth.POST("/login", userHandler.Login)
+			auth.POST("/2fa/verify", userHandler.VerifyTwoFactor)
+			auth.GET("/whoami", requireAuth, userHandler.WhoAmI)
 		}
 
 		// User routes
 		users := v1.Group("/users")
 		{
+			// Accounts pending deletion can't log in: their login hands out a
+			// token only good for cancelling the deletion, taken here besides
+			// access tokens
+			cancelDeletion := []gin.HandlerFunc{middleware.CancelDeletionAuthMiddleware(jwtService, requireAuth)}
+			if cfg.OpenAPI.Validates("users") {
+				cancelDeletion = append(cancelDeletion, openAPI.Middleware())
+			}
+			users.POST("/me/cancel-deletion", append(cancelDeletion, userHandler.CancelAccountDeletion)...)
+
 			// Protected routes (require authentication)
-			users.Use(middleware.AuthMiddleware(jwtService))
+			users.Use(requireAuth)
+			if cfg.OpenAPI.Validates("users") {
+				users.Use(openAPI.Middleware())
+			}
 
 			// User profile routes (accessible by authenticated users)
-			users.GET("/profile", userHandler.GetProfile)
-			users.PUT("/profile", userHandler.UpdateProfile)
+			registerProfileRoutes(users, userHandler, strictJSON)
 
 			// Admin-only routes
 			adminUsers := users.Group("")
 			adminUsers.Use(middleware.AdminMiddleware())
 			{
 				adminUsers.GET("", userHandler.ListUsers)
+				adminUsers.GET("/search", userHandler.SearchUsers)
+				adminUsers.POST("/batch-get", userHandler.BatchGetUsers)
 				adminUsers.GET("/:id", userHandler.GetUser)
-				adminUsers.PUT("/:id", userHandler.UpdateUser)
-				adminUsers.DELETE("/:id", userHandler.DeleteUser)
+				adminUsers.GET("/:id/export", userHandler.ExportUser)
+
+				// Destructive actions need the admin's own token
+				denyImpersonation := middleware.DenyImpersonation()
+				adminUsers.PUT("/:id", denyImpersonation, strictJSON, userHandler.UpdateUser)
+				adminUsers.PATCH("/:id", denyImpersonation, strictJSON, userHandler.PatchUser)
+				adminUsers.DELETE("", denyImpersonation, userHandler.BatchDeleteUsers)
+				adminUsers.DELETE("/:id", denyImpersonation, userHandler.DeleteUser)
+				adminUsers.POST("/:id/impersonate", denyImpersonation, userHandler.ImpersonateUser)
+				adminUsers.POST("/:id/promote", denyImpersonation, userHandler.PromoteUser)
+				adminUsers.POST("/:id/demote", denyImpersonation, userHandler.DemoteUser)
+				adminUsers.PUT("/:id/status", denyImpersonation, userHandler.UpdateUserStatus)
+				adminUsers.POST("/:id/anonymize", denyImpersonation, userHandler.AnonymizeUser)
 			}
 		}
 
+		// Admin routes
+		admin := v1.Group("/admin")
+		admin.Use(requireAuth, middleware.AdminMiddleware(), middleware.DenyImpersonation())
+		{
+			admin.GET("/maintenance", maintenanceHandler.GetMaintenance)
+			admin.PUT("/maintenance", maintenanceHandler.SetMaintenance)
+
+			admin.POST("/webhooks", webhookHandler.CreateWebhook)
+			admin.GET("/webhooks", webhookHandler.ListWebhooks)
+			admin.GET("/webhooks/deliveries", webhookHandler.ListDeliveries)
+			admin.GET("/webhooks/:id", webhookHandler.GetWebhook)
+			admin.PUT("/webhooks/:id", webhookHandler.UpdateWebhook)
+			admin.DELETE("/webhooks/:id", webhookHandler.DeleteWebhook)
+		}
+
 		// Example of a protected route group
 		protected := v1.Group("/protected")
-		protected.Use(middleware.AuthMiddleware(jwtService))
+		protected.Use(requireAuth)
 		{
 			protected.GET("/example", func(c *gin.Context) {
 				userID, _ := middleware.GetUserID(c)
@@ -109,7 +324,7 @@ func NewRouter(cfg *config.Config, db *database.DB, logger *zap.Logger) *gin.Eng
 		}
 
 		// Example of an optional auth route
-		v1.GET("/public", middleware.OptionalAuthMiddleware(jwtService), func(c *gin.Context) {
+		v1.GET("/public", optionalAuth, func(c *gin.Context) {
 			response := gin.H{"message": "This is a public endpoint"}
 
 			if userID, exists := middleware.GetUserID(c); exists {
@@ -120,17 +335,91 @@ func NewRouter(cfg *config.Config, db *database.DB, logger *zap.Logger) *gin.Eng
 		})
 	}
 
+	// API v2 routes. Handlers shared with v1 respond in v2's shape, wrapped
+	// in a data envelope; routes are added here as they are ported, and
+	// change shape in v2 only.
+	v2 := router.Group("/api/v2")
+	v2.Use(middleware.APIVersion(middleware.APIv2), poolGuard)
+	if breaker != nil {
+		v2.Use(middleware.CircuitBreakerGuard(breaker, cfg.Database.CircuitBreaker.RetryAfter))
+	}
+	{
+		users := v2.Group("/users")
+		users.Use(requireAuth)
+		{
+			users.GET("/profile", userHandler.GetProfile)
+		}
+	}
+
+	// GraphQL endpoint, sharing the user service and JWT auth with the REST API
+	if cfg.GraphQL.Enabled {
+		development := cfg.Service.Environment != "production"
+		graphQL := graph.Handler(graph.NewServer(userService, jwtService, development, logger))
+		router.GET("/graphql", optionalAuth, graphQL)
+		router.POST("/graphql", optionalAuth, graphQL)
+
+		if cfg.GraphQL.Playground && development {
+			router.GET("/graphql/playground", gin.WrapH(playground.Handler("GraphQL playground", "/graphql")))
+		}
+	}
+
 	// 404 handler
 	router.NoRoute(func(c *gin.Context) {
-		c.JSON(404, gin.H{
-			"error":   "not_found",
-			"message": "The requested resource was not found",
-		})
+		middleware.AbortWithError(c, http.StatusNotFound, "not_found", "The requested resource was not found")
 	})
 
 	return router
 }
 
+// newEngine creates the gin engine, taking the client IP from forwarding
+// headers only on requests from the configured trusted proxies. Otherwise
+// anyone could send a new X-Forwarded-For with each login attempt and
+// dodge the per-IP login throttle.
+func newEngine(cfg *config.Config) (*gin.Engine, error) {
+	router := gin.New()
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+// registerProfileRoutes registers the routes on which authenticated users
+// manage their own account. Impersonation tokens may read the profile, but
+// not change the credentials, email, avatar or 2FA, nor delete the account,
+// so that an admin acting as the user can't take the account over.
+func registerProfileRoutes(users gin.IRoutes, userHandler *handlers.UserHandler, strictJSON gin.HandlerFunc) {
+	denyImpersonation := middleware.DenyImpersonation()
+	users.GET("/profile", userHandler.GetProfile)
+	users.GET("/profile/export", userHandler.ExportProfile)
+	users.PUT("/profile", denyImpersonation, strictJSON, userHandler.UpdateProfile)
+	users.PATCH("/profile", denyImpersonation, strictJSON, userHandler.PatchProfile)
+	users.GET("/me/confirm-email", denyImpersonation, userHandler.ConfirmEmail)
+	users.DELETE("/me", denyImpersonation, userHandler.DeleteAccount)
+	users.POST("/me/anonymize", denyImpersonation, userHandler.AnonymizeAccount)
+	users.POST("/me/avatar", denyImpersonation, userHandler.UploadAvatar)
+	users.POST("/me/2fa/setup", denyImpersonation, userHandler.SetupTwoFactor)
+	users.POST("/me/2fa/enable", denyImpersonation, userHandler.EnableTwoFactor)
+}
+
+// newMetricsHandler serves the metrics of the service, including connection
+// pool stats of db, transaction retries and user cache hits. They are
+// gathered by a registry of the handler's own rather than the global one, so
+// that building several routers, such as in tests, doesn't register them
+// twice.
+func newMetricsHandler(db *sql.DB) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, "gin_service"),
+		database.TransactionRetries,
+		repository.QueryDuration,
+		services.CacheLookups,
+		middleware.APIRequests,
+	)
+	return promhttp.InstrumentMetricHandler(registry, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+}
+
 // SetupRoutes is an alternative function for setting up routes if you prefer
 // to separate route definition from router creation
 func SetupRoutes(router *gin.Engine, cfg *config.Config, db *database.DB, logger *zap.Logger) {
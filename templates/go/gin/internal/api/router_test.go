@@ -0,0 +1,143 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gin-service/internal/api/handlers"
+	"gin-service/internal/api/middleware"
+	"gin-service/internal/config"
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+	"gin-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNewMetricsHandler_CanBeBuiltTwice(t *testing.T) {
+	// Opening doesn't connect, and pool stats need no connection
+	db, err := sql.Open("postgres", "postgres://localhost/gin_service?sslmode=disable")
+	require.NoError(t, err)
+	defer db.Close()
+
+	newMetricsHandler(db)
+	var handler http.Handler
+	require.NotPanics(t, func() { handler = newMetricsHandler(db) })
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `go_sql_max_open_connections{db_name="gin_service"}`)
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+}
+
+func TestNewEngine_LoginThrottleIgnoresForwardedFor(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		lastCode       int
+	}{
+		// Rotating the header from an untrusted peer doesn't dodge the throttle
+		{"untrusted peer", nil, http.StatusTooManyRequests},
+		// Behind a trusted proxy, the header tells the clients apart
+		{"trusted proxy", []string{"192.0.2.0/24"}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Server: config.ServerConfig{TrustedProxies: tt.trustedProxies}}
+			userService := services.NewUserService(repository.NewMemoryStore(), zap.NewNop())
+			userService.SetLoginThrottle(middleware.NewSlidingLog(3, time.Minute))
+			handler := handlers.NewUserHandler(userService, middleware.NewJWTService(cfg, zap.NewNop()), zap.NewNop())
+
+			gin.SetMode(gin.TestMode)
+			router, err := newEngine(cfg)
+			require.NoError(t, err)
+			router.POST("/auth/login", handler.Login)
+
+			var code int
+			for i := 0; i < 4; i++ {
+				body := `{"username": "testuser", "password": "wrong-password"}`
+				req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i+1))
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				code = w.Code
+			}
+			assert.Equal(t, tt.lastCode, code)
+		})
+	}
+}
+
+func TestNewEngine_InvalidTrustedProxy(t *testing.T) {
+	_, err := newEngine(&config.Config{Server: config.ServerConfig{TrustedProxies: []string{"not-an-ip"}}})
+	assert.Error(t, err)
+}
+
+func TestRegisterProfileRoutes_DenyImpersonation(t *testing.T) {
+	cfg := &config.Config{JWT: config.JWTConfig{Secret: "test-secret", ExpirationTime: config.Duration(time.Hour)}}
+	jwtService := middleware.NewJWTService(cfg, zap.NewNop())
+	userService := services.NewUserService(repository.NewMemoryStore(), zap.NewNop())
+	user, err := userService.Create(&models.CreateUserRequest{Username: "testuser", Email: "test@example.com", Password: "password123"})
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	users := router.Group("/users", middleware.AuthMiddleware(jwtService))
+	registerProfileRoutes(users, handlers.NewUserHandler(userService, jwtService, zap.NewNop()), middleware.StrictJSON())
+
+	impersonationToken, err := jwtService.GenerateImpersonationToken(user, 99)
+	require.NoError(t, err)
+	accessToken, err := jwtService.GenerateToken(user)
+	require.NoError(t, err)
+
+	send := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	denied := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"PUT", "/users/profile", `{"email": "admin@example.com"}`},
+		{"PATCH", "/users/profile", `{"password": "takenover123"}`},
+		{"GET", "/users/me/confirm-email?token=abc", ""},
+		{"DELETE", "/users/me", ""},
+		{"POST", "/users/me/anonymize", ""},
+		{"POST", "/users/me/avatar", ""},
+		{"POST", "/users/me/2fa/setup", ""},
+		{"POST", "/users/me/2fa/enable", `{"code": "123456"}`},
+	}
+	for _, tt := range denied {
+		w := send(tt.method, tt.path, impersonationToken, tt.body)
+		assert.Equal(t, http.StatusForbidden, w.Code, "%s %s", tt.method, tt.path)
+		assert.Contains(t, w.Body.String(), "impersonation_forbidden", "%s %s", tt.method, tt.path)
+	}
+
+	// Impersonation tokens still read the profile
+	assert.Equal(t, http.StatusOK, send("GET", "/users/profile", impersonationToken, "").Code)
+
+	// The user's own token still updates it
+	assert.Equal(t, http.StatusOK, send("PATCH", "/users/profile", accessToken, `{"full_name": "Test User"}`).Code)
+
+	unchanged, err := userService.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "test@example.com", unchanged.Email)
+	assert.NoError(t, unchanged.CheckPassword("password123"))
+}
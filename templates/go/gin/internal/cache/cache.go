@@ -0,0 +1,25 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrMiss is returned by Get for keys that aren't cached
+var ErrMiss = errors.New("cache miss")
+
+// Cache stores values under string keys for a limited time. Implementations
+// must be safe for concurrent use.
+type Cache interface {
+	// Get returns the value cached under key, or ErrMiss
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set caches value under key for ttl, replacing any cached value
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the values cached under keys. Deleting a missing key is
+	// not an error.
+	Delete(ctx context.Context, keys ...string) error
+	// Incr increments the integer under key, which never expires, and
+	// returns its new value. A missing key counts from 0.
+	Incr(ctx context.Context, key string) (int64, error)
+}
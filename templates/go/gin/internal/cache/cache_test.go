@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gin-service/internal/config"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCacheContract checks the behavior every Cache implementation shares
+func testCacheContract(t *testing.T, c Cache, expire func(time.Duration)) {
+	ctx := context.Background()
+
+	_, err := c.Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrMiss)
+
+	require.NoError(t, c.Set(ctx, "key", []byte("value"), time.Minute))
+	value, err := c.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	require.NoError(t, c.Set(ctx, "key", []byte("replaced"), time.Minute))
+	value, err = c.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("replaced"), value)
+
+	require.NoError(t, c.Delete(ctx, "key", "missing"))
+	_, err = c.Get(ctx, "key")
+	assert.ErrorIs(t, err, ErrMiss)
+	require.NoError(t, c.Delete(ctx))
+
+	n, err := c.Incr(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	n, err = c.Incr(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	value, err = c.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), value)
+
+	require.NoError(t, c.Set(ctx, "short", []byte("value"), time.Second))
+	expire(2 * time.Second)
+	_, err = c.Get(ctx, "short")
+	assert.ErrorIs(t, err, ErrMiss, "values expire after their TTL")
+	_, err = c.Get(ctx, "counter")
+	assert.NoError(t, err, "counters don't expire")
+}
+
+func TestMemoryCache_Contract(t *testing.T) {
+	c := NewMemoryCache()
+	testCacheContract(t, c, func(d time.Duration) {
+		// Age the entries rather than sleep
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for key, entry := range c.entries {
+			if entry.expiresAt.IsZero() {
+				continue
+			}
+			entry.expiresAt = entry.expiresAt.Add(-d)
+			c.entries[key] = entry
+		}
+	})
+}
+
+func TestMemoryCache_Sweep(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache()
+	require.NoError(t, c.Set(ctx, "short", []byte("value"), time.Second))
+	require.NoError(t, c.Set(ctx, "long", []byte("value"), time.Hour))
+	_, err := c.Incr(ctx, "counter")
+	require.NoError(t, err)
+
+	c.Sweep(time.Now().Add(time.Minute))
+	assert.Len(t, c.entries, 2)
+	assert.NotContains(t, c.entries, "short")
+}
+
+func TestRedisCache_Contract(t *testing.T) {
+	server := miniredis.RunT(t)
+	c := NewRedisCache(config.RedisConfig{URL: server.Addr()}, time.Second)
+	defer c.Close()
+
+	require.NoError(t, c.Ping(context.Background()))
+	testCacheContract(t, c, server.FastForward)
+}
+
+func TestRedisCache_Unavailable(t *testing.T) {
+	server := miniredis.RunT(t)
+	c := NewRedisCache(config.RedisConfig{URL: server.Addr()}, 100*time.Millisecond)
+	defer c.Close()
+	server.Close()
+
+	// Failures are errors, not misses, so callers can tell them apart
+	_, err := c.Get(context.Background(), "key")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrMiss)
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gin-service/internal/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisCache is a Cache kept in Redis, shared by every instance of the
+// service
+type RedisCache struct {
+	client *redis.Client
+}
+
+// NewRedisCache creates a cache in the configured Redis database. Every
+// command, including connecting, gives up after timeout, so that a Redis
+// outage slows requests down by at most that much.
+func NewRedisCache(cfg config.RedisConfig, timeout time.Duration) *RedisCache {
+	return &RedisCache{
+		client: redis.NewClient(&redis.Options{
+			Addr:         cfg.URL,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			DialTimeout:  timeout,
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+			PoolTimeout:  timeout,
+		}),
+	}
+}
+
+// Ping checks the connection to Redis
+func (c *RedisCache) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+// Close closes the connections to Redis
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
+
+// Get returns the value cached under key, or ErrMiss
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := c.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrMiss
+	}
+	return value, err
+}
+
+// Set caches value under key for ttl
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, key, value, ttl).Err()
+}
+
+// Delete removes the values cached under keys
+func (c *RedisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.client.Del(ctx, keys...).Err()
+}
+
+// Incr increments the integer under key
+func (c *RedisCache) Incr(ctx context.Context, key string) (int64, error) {
+	return c.client.Incr(ctx, key).Result()
+}
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORSConfig_Validate(t *testing.T) {
+	assert.NoError(t, CORSConfig{AllowedOrigins: []string{"*"}}.Validate("development"))
+	assert.NoError(t, CORSConfig{AllowedOrigins: []string{"https://*.example.com"}, AllowedCredentials: true}.Validate("development"))
+
+	// Browsers reject a wildcard origin on credentialed requests
+	assert.Error(t, CORSConfig{AllowedOrigins: []string{"*"}, AllowedCredentials: true}.Validate("development"))
+	assert.EqualError(t, CORSConfig{AllowedOrigins: []string{"https://app.example.com", " * "}, AllowedCredentials: true}.Validate("development"),
+		`cors: allowed_origins " * " cannot be combined with allowed_credentials in development; list the origins explicitly`)
+
+	assert.NoError(t, CORSConfig{AllowedOrigins: []string{"*"}, MaxAge: 0}.Validate("development"))
+	assert.EqualError(t, CORSConfig{AllowedOrigins: []string{"*"}, MaxAge: -1}.Validate("development"), "cors.max_age: must not be negative, got -1")
+
+	cors := CORSConfig{
+		AllowedOrigins:     []string{"*"},
+		EnvironmentOrigins: map[string][]string{"production": {"https://app.example.com"}},
+		AllowedCredentials: true,
+	}
+	assert.NoError(t, cors.Validate("production"))
+	assert.Error(t, cors.Validate("staging"))
+}
+
+func TestCORSConfig_OriginsFor(t *testing.T) {
+	cors := CORSConfig{
+		AllowedOrigins:     []string{"*"},
+		EnvironmentOrigins: map[string][]string{"production": {"https://app.example.com"}},
+	}
+
+	assert.Equal(t, []string{"https://app.example.com"}, cors.OriginsFor("production"))
+	assert.Equal(t, []string{"*"}, cors.OriginsFor("development"))
+}
+
+func TestTLSConfig_Validate(t *testing.T) {
+	// Plain HTTP by default
+	assert.False(t, TLSConfig{}.Enabled)
+	assert.NoError(t, TLSConfig{}.Validate("production"))
+
+	assert.NoError(t, TLSConfig{Enabled: true, CertFile: "tls.crt", KeyFile: "tls.key", RedirectPort: "8081"}.Validate("production"))
+	assert.NoError(t, TLSConfig{Enabled: true, SelfSigned: true}.Validate("development"))
+
+	assert.Error(t, TLSConfig{Enabled: true, CertFile: "tls.crt"}.Validate("development"))
+	assert.Error(t, TLSConfig{Enabled: true, SelfSigned: true}.Validate("production"))
+	assert.Error(t, TLSConfig{RedirectPort: "8081"}.Validate("development"))
+
+	// Enabled without a certificate, or a certificate without enabled
+	assert.Error(t, TLSConfig{Enabled: true}.Validate("development"))
+	assert.Error(t, TLSConfig{CertFile: "tls.crt", KeyFile: "tls.key"}.Validate("development"))
+}
+
+func TestLogConfig_Validate(t *testing.T) {
+	for _, format := range []string{"", "json", "console"} {
+		assert.NoError(t, LogConfig{Format: format}.Validate(), format)
+	}
+	assert.Error(t, LogConfig{Format: "logfmt"}.Validate())
+	assert.EqualError(t, LogConfig{SlowRequestThreshold: Duration(-time.Second)}.Validate(), "log.slow_request_threshold: must not be negative, got -1s")
+	assert.NoError(t, LogConfig{Sampling: LogSamplingConfig{Enabled: true, Initial: 100, Thereafter: 100}}.Validate())
+	assert.Error(t, LogConfig{Rotation: LogRotationConfig{MaxBackups: -1}}.Validate())
+	assert.EqualError(t, LogConfig{Sampling: LogSamplingConfig{Enabled: true, Thereafter: 100}}.Validate(), "log.sampling.initial: must be positive, got 0")
+	assert.EqualError(t, LogConfig{Sampling: LogSamplingConfig{Enabled: true, Initial: 100}}.Validate(), "log.sampling.thereafter: must be positive, got 0")
+}
+
+func TestLoginRateConfig_Validate(t *testing.T) {
+	assert.NoError(t, LoginRateConfig{}.Validate())
+	assert.NoError(t, LoginRateConfig{Enabled: true, MaxAttempts: 20, Window: 5 * time.Minute}.Validate())
+	assert.EqualError(t, LoginRateConfig{Enabled: true, Window: time.Minute}.Validate(), "rate.login.max_attempts: must be positive, got 0")
+	assert.EqualError(t, LoginRateConfig{Enabled: true, MaxAttempts: 20}.Validate(), "rate.login.window: must be positive, got 0s")
+}
+
+func TestBreakerConfig_Validate(t *testing.T) {
+	assert.NoError(t, BreakerConfig{}.Validate())
+	assert.NoError(t, BreakerConfig{Enabled: true, FailureThreshold: 5, Cooldown: 10 * time.Second, RetryAfter: 10 * time.Second}.Validate())
+	assert.EqualError(t, BreakerConfig{Enabled: true, Cooldown: time.Second, RetryAfter: time.Second}.Validate(), "database.circuit_breaker.failure_threshold: must be positive, got 0")
+	assert.EqualError(t, BreakerConfig{Enabled: true, FailureThreshold: 5, RetryAfter: time.Second}.Validate(), "database.circuit_breaker.cooldown: must be positive, got 0s")
+	assert.EqualError(t, BreakerConfig{Enabled: true, FailureThreshold: 5, Cooldown: time.Second}.Validate(), "database.circuit_breaker.retry_after: must be positive, got 0s")
+}
+
+func TestJWTConfig_Validate(t *testing.T) {
+	assert.NoError(t, JWTConfig{Secret: "secret"}.Validate())
+	assert.NoError(t, JWTConfig{Keys: map[string]string{"k1": "old", "k2": "new"}, CurrentKey: "k2"}.Validate())
+
+	assert.Error(t, JWTConfig{Keys: map[string]string{"k1": "old"}}.Validate())
+	assert.Error(t, JWTConfig{Keys: map[string]string{"k1": "old"}, CurrentKey: "k2"}.Validate())
+	assert.Error(t, JWTConfig{Keys: map[string]string{"k1": ""}, CurrentKey: "k1"}.Validate())
+
+	// Token lifetimes can't exceed the cap
+	capped := JWTConfig{Secret: "secret", ExpirationTime: Duration(time.Hour), ImpersonationExpiration: Duration(15 * time.Minute), MaxExpirationTime: Duration(24 * time.Hour)}
+	assert.NoError(t, capped.Validate())
+	capped.ExpirationTime = Duration(25 * time.Hour)
+	assert.EqualError(t, capped.Validate(), "jwt.expiration_time: must be at most jwt.max_expiration_time (24h0m0s), got 25h0m0s")
+	capped.ExpirationTime = Duration(time.Hour)
+	capped.ImpersonationExpiration = Duration(48 * time.Hour)
+	assert.EqualError(t, capped.Validate(), "jwt.impersonation_expiration: must be at most jwt.max_expiration_time (24h0m0s), got 48h0m0s")
+
+	// Key IDs come out of the config file lowercased
+	cfg, err := decodeYAML(t, `
+jwt:
+  keys:
+    Key-2024: "secret"
+  current_key: "Key-2024"
+`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"key-2024": "secret"}, cfg.JWT.Keys)
+	assert.EqualError(t, cfg.JWT.Validate(), `jwt.current_key: no key "Key-2024" in jwt.keys (key IDs are lowercased)`)
+}
+
+func TestTrustedHeaderAuthConfig_Validate(t *testing.T) {
+	assert.NoError(t, TrustedHeaderAuthConfig{}.Validate())
+	assert.NoError(t, TrustedHeaderAuthConfig{Enabled: true, TrustedProxies: []string{"10.0.0.0/8", "192.168.1.5", "::1"}}.Validate())
+
+	// Off by default, but never trusting everyone once enabled
+	assert.EqualError(t, TrustedHeaderAuthConfig{Enabled: true}.Validate(),
+		"trusted_header_auth.trusted_proxies: required when enabled")
+	assert.Error(t, TrustedHeaderAuthConfig{Enabled: true, TrustedProxies: []string{"gateway.internal"}}.Validate())
+
+	networks, err := TrustedHeaderAuthConfig{TrustedProxies: []string{"192.168.1.5"}}.Networks()
+	require.NoError(t, err)
+	require.Len(t, networks, 1)
+	assert.Equal(t, "192.168.1.5/32", networks[0].String())
+}
+
+func TestOutboxConfig_Validate(t *testing.T) {
+	assert.NoError(t, OutboxConfig{}.Validate())
+	assert.NoError(t, OutboxConfig{Enabled: true, MaxAttempts: 10}.Validate())
+	assert.EqualError(t, OutboxConfig{Enabled: true}.Validate(), "outbox.max_attempts: must be positive, got 0")
+}
+
+func TestWebhookConfig_Validate(t *testing.T) {
+	outbox := OutboxConfig{Enabled: true}
+	webhooks := WebhookConfig{
+		Enabled:      true,
+		PollInterval: Duration(time.Second),
+		BatchSize:    50,
+		Concurrency:  4,
+		Timeout:      Duration(10 * time.Second),
+		MaxAttempts:  8,
+		BaseBackoff:  Duration(30 * time.Second),
+		MaxBackoff:   Duration(30 * time.Minute),
+	}
+	assert.NoError(t, webhooks.Validate(outbox))
+	assert.NoError(t, WebhookConfig{}.Validate(OutboxConfig{}))
+
+	// Events only reach webhooks through the outbox
+	assert.EqualError(t, webhooks.Validate(OutboxConfig{}), "webhooks: delivering webhooks needs outbox.enabled")
+
+	invalid := webhooks
+	invalid.MaxAttempts = 0
+	assert.Error(t, invalid.Validate(outbox))
+	invalid = webhooks
+	invalid.MaxBackoff = Duration(time.Second)
+	assert.Error(t, invalid.Validate(outbox))
+
+	endpoint := WebhookEndpointConfig{URL: "https://example.com/hooks", Secret: "webhook-secret-0123", EventTypes: []string{"user.created"}}
+	webhooks.Endpoints = []WebhookEndpointConfig{endpoint}
+	assert.NoError(t, webhooks.Validate(outbox))
+
+	for _, invalid := range []WebhookEndpointConfig{
+		{URL: "example.com/hooks", Secret: endpoint.Secret, EventTypes: endpoint.EventTypes},
+		{URL: endpoint.URL, Secret: "short", EventTypes: endpoint.EventTypes},
+		{URL: endpoint.URL, Secret: endpoint.Secret},
+		{URL: endpoint.URL, Secret: endpoint.Secret, EventTypes: []string{"user.logged_in"}},
+	} {
+		webhooks.Endpoints = []WebhookEndpointConfig{invalid}
+		assert.Error(t, webhooks.Validate(outbox), invalid.URL)
+	}
+}
+
+func TestCacheConfig_Validate(t *testing.T) {
+	redis := RedisConfig{URL: "localhost:6379"}
+	cache := CacheConfig{Enabled: true, TTL: Duration(5 * time.Minute), Timeout: Duration(100 * time.Millisecond)}
+	assert.NoError(t, cache.Validate(redis))
+	assert.NoError(t, CacheConfig{}.Validate(RedisConfig{}))
+
+	assert.EqualError(t, cache.Validate(RedisConfig{}), "cache: caching users needs redis.url")
+	invalid := cache
+	invalid.TTL = 0
+	assert.Error(t, invalid.Validate(redis))
+	invalid = cache
+	invalid.ListTTL = Duration(-time.Second)
+	assert.Error(t, invalid.Validate(redis))
+
+	// The memory backend needs no Redis, but sweeping
+	memory := cache
+	memory.Backend = CacheBackendMemory
+	memory.SweepInterval = Duration(time.Minute)
+	assert.NoError(t, memory.Validate(RedisConfig{}))
+	memory.SweepInterval = 0
+	assert.Error(t, memory.Validate(RedisConfig{}))
+	invalid = cache
+	invalid.Backend = "memcached"
+	assert.EqualError(t, invalid.Validate(redis), `cache.backend: must be "redis" or "memory", got "memcached"`)
+}
+
+func TestPasswordConfig_Validate(t *testing.T) {
+	argon2 := Argon2idConfig{Time: 3, Memory: 64 * 1024, Threads: 2, KeyLength: 32, SaltLength: 16}
+
+	assert.NoError(t, PasswordConfig{Algorithm: "bcrypt", BcryptCost: 10}.Validate())
+	assert.NoError(t, PasswordConfig{Algorithm: "argon2id", Argon2: argon2}.Validate())
+
+	assert.Error(t, PasswordConfig{Algorithm: "scrypt", BcryptCost: 10}.Validate())
+	assert.Error(t, PasswordConfig{Algorithm: "bcrypt", BcryptCost: 3}.Validate())
+	assert.Error(t, PasswordConfig{Algorithm: "bcrypt", BcryptCost: 32}.Validate())
+	assert.Error(t, PasswordConfig{Algorithm: "argon2id"}.Validate())
+
+	argon2.SaltLength = 4
+	assert.Error(t, PasswordConfig{Algorithm: "argon2id", Argon2: argon2}.Validate())
+}
+
+func TestPaginationConfig_Validate(t *testing.T) {
+	assert.NoError(t, PaginationConfig{DefaultLimit: 10, MaxLimit: 100}.Validate())
+	assert.NoError(t, PaginationConfig{DefaultLimit: 50, MaxLimit: 50}.Validate())
+
+	assert.EqualError(t, PaginationConfig{DefaultLimit: 200, MaxLimit: 100}.Validate(),
+		"pagination.default_limit: must not exceed max_limit 100, got 200")
+	assert.Error(t, PaginationConfig{DefaultLimit: 0, MaxLimit: 100}.Validate())
+	assert.Error(t, PaginationConfig{DefaultLimit: 10, MaxLimit: 0}.Validate())
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// Duration is a time.Duration read from configuration either as a Go
+// duration string such as "10s" or "500ms", or, for settings that used to be
+// plain integers, as a bare number of seconds
+type Duration time.Duration
+
+// Duration returns d as a time.Duration
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+// String formats d like a time.Duration
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// durationType is the reflect.Type of Duration
+var durationType = reflect.TypeOf(Duration(0))
+
+// durationDecodeHook decodes Duration settings from duration strings and
+// numbers of seconds, which may come as strings from environment variables
+func durationDecodeHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != durationType {
+		return data, nil
+	}
+
+	switch value := data.(type) {
+	case Duration:
+		return value, nil
+	case time.Duration:
+		return Duration(value), nil
+	case string:
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			return secondsToDuration(seconds), nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q: expected a duration such as \"10s\" or a number of seconds", value)
+		}
+		return Duration(d), nil
+	}
+
+	number := reflect.ValueOf(data)
+	switch number.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return secondsToDuration(float64(number.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return secondsToDuration(float64(number.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return secondsToDuration(number.Float()), nil
+	}
+	return data, nil
+}
+
+// secondsToDuration converts a number of seconds to a Duration
+func secondsToDuration(seconds float64) Duration {
+	return Duration(seconds * float64(time.Second))
+}
+
+// decodeHook is the viper decode hook for Config, handling Duration settings
+// along with viper's default duration and comma-separated list handling
+var decodeHook = mapstructure.ComposeDecodeHookFunc(
+	durationDecodeHook,
+	mapstructure.StringToTimeDurationHookFunc(),
+	mapstructure.StringToSliceHookFunc(","),
+)
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeYAML decodes a configuration file the way Load does
+func decodeYAML(t *testing.T, yaml string) (*Config, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	require.NoError(t, v.ReadConfig(strings.NewReader(yaml)))
+
+	var cfg Config
+	err := v.Unmarshal(&cfg, viper.DecodeHook(decodeHook))
+	return &cfg, err
+}
+
+func TestDuration_IntegerAndStringFormsMatch(t *testing.T) {
+	integers, err := decodeYAML(t, `
+server:
+  read_timeout: 10
+  write_timeout: 10
+  idle_timeout: 120
+database:
+  conn_max_lifetime: 300
+jwt:
+  expiration_time: 3600
+  impersonation_expiration: 900
+`)
+	require.NoError(t, err)
+
+	strs, err := decodeYAML(t, `
+server:
+  read_timeout: "10s"
+  write_timeout: "10000ms"
+  idle_timeout: "2m"
+database:
+  conn_max_lifetime: "5m"
+jwt:
+  expiration_time: "1h"
+  impersonation_expiration: "15m"
+`)
+	require.NoError(t, err)
+
+	assert.Equal(t, integers, strs)
+	assert.Equal(t, 10*time.Second, integers.Server.ReadTimeout.Duration())
+	assert.Equal(t, 2*time.Minute, integers.Server.IdleTimeout.Duration())
+	assert.Equal(t, 5*time.Minute, integers.Database.ConnMaxLifetime.Duration())
+	assert.Equal(t, time.Hour, integers.JWT.ExpirationTime.Duration())
+	assert.Equal(t, 15*time.Minute, integers.JWT.ImpersonationExpiration.Duration())
+}
+
+func TestDuration_SubSecondAndEnvironmentForms(t *testing.T) {
+	cfg, err := decodeYAML(t, `
+server:
+  read_timeout: "500ms"
+  write_timeout: 0.5
+  idle_timeout: "30"
+`)
+	require.NoError(t, err)
+
+	assert.Equal(t, 500*time.Millisecond, cfg.Server.ReadTimeout.Duration())
+	assert.Equal(t, 500*time.Millisecond, cfg.Server.WriteTimeout.Duration())
+	// Environment variables arrive as strings
+	assert.Equal(t, 30*time.Second, cfg.Server.IdleTimeout.Duration())
+}
+
+func TestDuration_Invalid(t *testing.T) {
+	_, err := decodeYAML(t, `
+server:
+  read_timeout: "ten seconds"
+`)
+
+	assert.ErrorContains(t, err, `invalid duration "ten seconds"`)
+}
+
+func TestConfig_Validate_DurationsMustBePositive(t *testing.T) {
+	cfg, err := decodeYAML(t, `
+server:
+  read_header_timeout: "5s"
+  read_timeout: "10s"
+  write_timeout: "10s"
+  idle_timeout: "-1m"
+database:
+  conn_max_lifetime: "5m"
+jwt:
+  expiration_time: "1h"
+  max_expiration_time: "24h"
+  impersonation_expiration: "15m"
+storage:
+  driver: "local"
+mail:
+  driver: "log"
+password_hash:
+  algorithm: "bcrypt"
+  bcrypt_cost: 10
+pagination:
+  default_limit: 10
+  max_limit: 100
+account_deletion:
+  grace_period: "720h"
+  reap_interval: "1h"
+health:
+  check_timeout: "2s"
+`)
+	require.NoError(t, err)
+
+	assert.EqualError(t, cfg.Validate(), "server.idle_timeout: must be positive, got -1m0s")
+
+	cfg.Server.IdleTimeout = Duration(2 * time.Minute)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Server.ReadTimeout = Duration(time.Second)
+	assert.EqualError(t, cfg.Validate(), "server.read_timeout: must be at least server.read_header_timeout (5s), got 1s")
+	cfg.Server.ReadTimeout = Duration(10 * time.Second)
+
+	cfg.JWT.ExpirationTime = 0
+	assert.EqualError(t, cfg.Validate(), "jwt.expiration_time: must be positive, got 0s")
+	cfg.JWT.ExpirationTime = Duration(time.Hour)
+	cfg.JWT.MaxExpirationTime = 0
+	assert.EqualError(t, cfg.Validate(), "jwt.max_expiration_time: must be positive, got 0s")
+	cfg.JWT.MaxExpirationTime = Duration(24 * time.Hour)
+	cfg.Health.CheckTimeout = 0
+	assert.EqualError(t, cfg.Validate(), "health.check_timeout: must be positive, got 0s")
+	cfg.Health.CheckTimeout = Duration(2 * time.Second)
+
+	// Leeway may be zero, but not negative
+	cfg.JWT.ExpirationTime = Duration(time.Hour)
+	assert.NoError(t, cfg.Validate())
+	cfg.JWT.Leeway = Duration(-time.Second)
+	assert.EqualError(t, cfg.Validate(), "jwt.leeway: must not be negative, got -1s")
+}
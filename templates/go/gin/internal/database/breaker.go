@@ -0,0 +1,318 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// ErrCircuitOpen is returned instead of running a query while the circuit
+// breaker is open
+var ErrCircuitOpen = errors.New("database circuit breaker is open")
+
+// BreakerState is the state of a CircuitBreaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets calls through, counting consecutive failures
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails calls at once with ErrCircuitOpen until the cooldown
+	// is over
+	BreakerOpen
+	// BreakerHalfOpen lets a single trial call through once the cooldown is
+	// over, failing the others with ErrCircuitOpen. The trial closes the
+	// breaker, or opens it again when it fails.
+	BreakerHalfOpen
+)
+
+// String returns the state as reported by health checks
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker stops calling the database after a number of consecutive
+// failures, failing calls at once for a cooldown instead of letting each wait
+// for the connection timeout. Connection errors and timeouts are failures.
+// Errors in the query itself, such as constraint violations, show the
+// database answering and count as successes, while calls cancelled by their
+// caller say nothing of the database and aren't counted.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	// probing is set while the trial call of a half-open breaker runs
+	probing bool
+	now     func() time.Time
+}
+
+// NewCircuitBreaker creates a closed circuit breaker opening after threshold
+// consecutive failures, for cooldown
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// State returns the breaker's state, half-open once the cooldown of an open
+// breaker is over
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.currentState()
+}
+
+// Open reports whether calls currently fail with ErrCircuitOpen, that is
+// while the breaker is open and while the trial call of a half-open breaker
+// runs
+func (b *CircuitBreaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.currentState()
+	return state == BreakerOpen || (state == BreakerHalfOpen && b.probing)
+}
+
+// Do runs fn unless the breaker is open, in which case it returns
+// ErrCircuitOpen without calling fn
+func (b *CircuitBreaker) Do(fn func() error) error {
+	probe, ok := b.allow()
+	if !ok {
+		return ErrCircuitOpen
+	}
+
+	recorded := false
+	defer func() {
+		// A panicking trial call must not keep the breaker half-open with
+		// every other call failing
+		if !recorded && probe {
+			b.mu.Lock()
+			b.probing = false
+			b.mu.Unlock()
+		}
+	}()
+
+	err := fn()
+	b.record(err, probe)
+	recorded = true
+	return err
+}
+
+// allow reports whether a call may run, and whether it is the trial call of
+// a half-open breaker
+func (b *CircuitBreaker) allow() (probe, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState() {
+	case BreakerOpen:
+		return false, false
+	case BreakerHalfOpen:
+		if b.probing {
+			return false, false
+		}
+		b.probing = true
+		return true, true
+	default:
+		return false, true
+	}
+}
+
+// record counts the outcome of a call, probe telling whether it was the
+// trial call of a half-open breaker
+func (b *CircuitBreaker) record(err error, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+	state := b.currentState()
+	if state == BreakerOpen || (state == BreakerHalfOpen && !probe) {
+		// The call started before the breaker opened
+		return
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		// The caller gave up, leaving a half-open breaker to the next trial
+		return
+	case !IsBreakerFailure(err):
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// codeQueryCanceled is the Postgres error code of statements cancelled by
+// statement_timeout
+const codeQueryCanceled = "57014"
+
+// IsBreakerFailure reports whether err shows the database as unavailable: a
+// connection error, or a call timing out, whether at its deadline or at the
+// database's statement timeout
+func IsBreakerFailure(err error) bool {
+	if IsConnectionError(err) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == codeQueryCanceled
+}
+
+// currentState moves an open breaker to half-open once its cooldown is over.
+// The caller must hold mu.
+func (b *CircuitBreaker) currentState() BreakerState {
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		b.state = BreakerHalfOpen
+	}
+	return b.state
+}
+
+// CircuitBreakerDB wraps a DBInterface, running its queries and transactions
+// through a CircuitBreaker
+type CircuitBreakerDB struct {
+	DBInterface
+	breaker *CircuitBreaker
+}
+
+var _ ContextQueryer = (*CircuitBreakerDB)(nil)
+
+// NewCircuitBreakerDB creates a new circuit breaking wrapper around db
+func NewCircuitBreakerDB(db DBInterface, breaker *CircuitBreaker) *CircuitBreakerDB {
+	return &CircuitBreakerDB{
+		DBInterface: db,
+		breaker:     breaker,
+	}
+}
+
+// Get executes a single-row query unless the breaker is open
+func (d *CircuitBreakerDB) Get(dest interface{}, query string, args ...interface{}) error {
+	return d.breaker.Do(func() error { return d.DBInterface.Get(dest, query, args...) })
+}
+
+// Select executes a multi-row query unless the breaker is open
+func (d *CircuitBreakerDB) Select(dest interface{}, query string, args ...interface{}) error {
+	return d.breaker.Do(func() error { return d.DBInterface.Select(dest, query, args...) })
+}
+
+// Exec executes a statement unless the breaker is open
+func (d *CircuitBreakerDB) Exec(query string, args ...interface{}) (result sql.Result, err error) {
+	err = d.breaker.Do(func() error {
+		result, err = d.DBInterface.Exec(query, args...)
+		return err
+	})
+	return result, err
+}
+
+// NamedExec executes a named statement unless the breaker is open
+func (d *CircuitBreakerDB) NamedExec(query string, arg interface{}) (result sql.Result, err error) {
+	err = d.breaker.Do(func() error {
+		result, err = d.DBInterface.NamedExec(query, arg)
+		return err
+	})
+	return result, err
+}
+
+// NamedQuery executes a named query unless the breaker is open
+func (d *CircuitBreakerDB) NamedQuery(query string, arg interface{}) (rows *sqlx.Rows, err error) {
+	err = d.breaker.Do(func() error {
+		rows, err = d.DBInterface.NamedQuery(query, arg)
+		return err
+	})
+	return rows, err
+}
+
+// GetContext executes a single-row query under ctx unless the breaker is open
+func (d *CircuitBreakerDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	db, ok := d.DBInterface.(ContextQueryer)
+	if !ok {
+		return d.Get(dest, query, args...)
+	}
+	return d.breaker.Do(func() error { return db.GetContext(ctx, dest, query, args...) })
+}
+
+// SelectContext executes a multi-row query under ctx unless the breaker is
+// open
+func (d *CircuitBreakerDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	db, ok := d.DBInterface.(ContextQueryer)
+	if !ok {
+		return d.Select(dest, query, args...)
+	}
+	return d.breaker.Do(func() error { return db.SelectContext(ctx, dest, query, args...) })
+}
+
+// ExecContext executes a statement under ctx unless the breaker is open
+func (d *CircuitBreakerDB) ExecContext(ctx context.Context, query string, args ...interface{}) (result sql.Result, err error) {
+	db, ok := d.DBInterface.(ContextQueryer)
+	if !ok {
+		return d.Exec(query, args...)
+	}
+	err = d.breaker.Do(func() error {
+		result, err = db.ExecContext(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
+
+// NamedExecContext executes a named statement under ctx unless the breaker
+// is open
+func (d *CircuitBreakerDB) NamedExecContext(ctx context.Context, query string, arg interface{}) (result sql.Result, err error) {
+	db, ok := d.DBInterface.(ContextQueryer)
+	if !ok {
+		return d.NamedExec(query, arg)
+	}
+	err = d.breaker.Do(func() error {
+		result, err = db.NamedExecContext(ctx, query, arg)
+		return err
+	})
+	return result, err
+}
+
+// NamedQueryContext executes a named query under ctx unless the breaker is
+// open
+func (d *CircuitBreakerDB) NamedQueryContext(ctx context.Context, query string, arg interface{}) (rows *sqlx.Rows, err error) {
+	db, ok := d.DBInterface.(ContextQueryer)
+	if !ok {
+		return d.NamedQuery(query, arg)
+	}
+	err = d.breaker.Do(func() error {
+		rows, err = db.NamedQueryContext(ctx, query, arg)
+		return err
+	})
+	return rows, err
+}
+
+// Transaction runs fn within a transaction unless the breaker is open. The
+// transaction's queries aren't counted one by one, only its outcome.
+func (d *CircuitBreakerDB) Transaction(fn func(*sqlx.Tx) error) error {
+	return d.breaker.Do(func() error { return d.DBInterface.Transaction(fn) })
+}
+
+// WrapTx wraps the queries of tx like those of the database the breaker
+// wraps; the breaker itself only counts the transaction's outcome
+func (d *CircuitBreakerDB) WrapTx(tx *sqlx.Tx) ContextQueryer {
+	return WrapTx(d.DBInterface, tx)
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// downDB is a DBInterface whose Get fails with err, counting its calls
+type downDB struct {
+	DBInterface
+	err   error
+	calls int
+}
+
+func (d *downDB) Get(dest interface{}, query string, args ...interface{}) error {
+	d.calls++
+	return d.err
+}
+
+// hangingDB is a DBInterface whose queries hang until their context is done,
+// like those of a database that accepts connections but never answers
+type hangingDB struct {
+	DBInterface
+}
+
+func (h *hangingDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (h *hangingDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (h *hangingDB) NamedQueryContext(ctx context.Context, query string, arg interface{}) (*sqlx.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (h *hangingDB) NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (h *hangingDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// setupBreaker returns a breaker opening after threshold failures for a
+// minute, on a clock advanced by the returned function
+func setupBreaker(threshold int) (*CircuitBreaker, func(time.Duration)) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(threshold, time.Minute)
+	breaker.now = func() time.Time { return now }
+	return breaker, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCircuitBreaker_OpensAfterThreshold(t *testing.T) {
+	breaker, _ := setupBreaker(3)
+	failing := func() error { return driver.ErrBadConn }
+
+	for i := 0; i < 2; i++ {
+		assert.ErrorIs(t, breaker.Do(failing), driver.ErrBadConn)
+	}
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	assert.ErrorIs(t, breaker.Do(failing), driver.ErrBadConn)
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	// Open, calls fail at once
+	called := false
+	err := breaker.Do(func() error { called = true; return nil })
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.False(t, called)
+}
+
+func TestCircuitBreaker_CountsConsecutiveConnectionErrors(t *testing.T) {
+	breaker, _ := setupBreaker(2)
+
+	// A success resets the count
+	_ = breaker.Do(func() error { return driver.ErrBadConn })
+	_ = breaker.Do(func() error { return nil })
+	_ = breaker.Do(func() error { return driver.ErrBadConn })
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	// So does an error in the query itself
+	_ = breaker.Do(func() error { return sql.ErrNoRows })
+	_ = breaker.Do(func() error { return driver.ErrBadConn })
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	_ = breaker.Do(func() error { return driver.ErrBadConn })
+	assert.Equal(t, BreakerOpen, breaker.State())
+}
+
+func TestCircuitBreaker_HalfOpensAfterCooldown(t *testing.T) {
+	breaker, advance := setupBreaker(1)
+	_ = breaker.Do(func() error { return driver.ErrBadConn })
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	advance(59 * time.Second)
+	assert.Equal(t, BreakerOpen, breaker.State())
+	advance(time.Second)
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+
+	// A failing trial call opens the breaker for another cooldown
+	assert.ErrorIs(t, breaker.Do(func() error { return driver.ErrBadConn }), driver.ErrBadConn)
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	// A succeeding one closes it
+	advance(time.Minute)
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+	assert.NoError(t, breaker.Do(func() error { return nil }))
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestCircuitBreaker_CountsTimeouts(t *testing.T) {
+	breaker, _ := setupBreaker(2)
+
+	_ = breaker.Do(func() error { return context.DeadlineExceeded })
+	_ = breaker.Do(func() error { return &pq.Error{Code: "57014"} })
+	assert.Equal(t, BreakerOpen, breaker.State())
+}
+
+func TestCircuitBreaker_IgnoresCancelledCalls(t *testing.T) {
+	breaker, advance := setupBreaker(2)
+
+	// A cancelled call doesn't reset the count
+	_ = breaker.Do(func() error { return driver.ErrBadConn })
+	_ = breaker.Do(func() error { return context.Canceled })
+	_ = breaker.Do(func() error { return driver.ErrBadConn })
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	// Nor does it close a half-open breaker, leaving the trial to the next
+	// call
+	advance(time.Minute)
+	_ = breaker.Do(func() error { return context.Canceled })
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+	assert.False(t, breaker.Open())
+}
+
+func TestCircuitBreaker_HalfOpenAdmitsSingleTrial(t *testing.T) {
+	breaker, advance := setupBreaker(1)
+	_ = breaker.Do(func() error { return driver.ErrBadConn })
+	advance(time.Minute)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- breaker.Do(func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	// Other calls fail at once while the trial runs
+	called := false
+	assert.ErrorIs(t, breaker.Do(func() error { called = true; return nil }), ErrCircuitOpen)
+	assert.False(t, called)
+	assert.True(t, breaker.Open())
+
+	close(release)
+	assert.NoError(t, <-done)
+	assert.Equal(t, BreakerClosed, breaker.State())
+	assert.False(t, breaker.Open())
+}
+
+func TestCircuitBreaker_PanickingTrialEnds(t *testing.T) {
+	breaker, advance := setupBreaker(1)
+	_ = breaker.Do(func() error { return driver.ErrBadConn })
+	advance(time.Minute)
+
+	assert.Panics(t, func() {
+		_ = breaker.Do(func() error { panic("boom") })
+	})
+	assert.NoError(t, breaker.Do(func() error { return nil }))
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestBreakerState_String(t *testing.T) {
+	assert.Equal(t, "closed", BreakerClosed.String())
+	assert.Equal(t, "open", BreakerOpen.String())
+	assert.Equal(t, "half-open", BreakerHalfOpen.String())
+}
+
+func TestCircuitBreakerDB_FailsFastWhileOpen(t *testing.T) {
+	db := &downDB{err: sql.ErrConnDone}
+	breaker, advance := setupBreaker(2)
+	breakerDB := NewCircuitBreakerDB(db, breaker)
+
+	var id int
+	for i := 0; i < 2; i++ {
+		assert.ErrorIs(t, breakerDB.Get(&id, "SELECT 1"), sql.ErrConnDone)
+	}
+	for i := 0; i < 5; i++ {
+		assert.ErrorIs(t, breakerDB.Get(&id, "SELECT 1"), ErrCircuitOpen)
+	}
+	assert.Equal(t, 2, db.calls)
+
+	// Back up by the end of the cooldown
+	db.err = nil
+	advance(time.Minute)
+	assert.NoError(t, breakerDB.Get(&id, "SELECT 1"))
+	assert.Equal(t, 3, db.calls)
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestCircuitBreakerDB_OpensOnHangingDatabase(t *testing.T) {
+	breaker, _ := setupBreaker(2)
+	breakerDB := NewCircuitBreakerDB(&hangingDB{}, breaker)
+
+	query := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		var id int
+		return breakerDB.GetContext(ctx, &id, "SELECT 1")
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.ErrorIs(t, query(), context.DeadlineExceeded)
+	}
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	// No more waiting for the deadline
+	start := time.Now()
+	assert.ErrorIs(t, query(), ErrCircuitOpen)
+	assert.Less(t, time.Since(start), 10*time.Millisecond)
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ContextQueryer is a Queryer whose queries can also run under a context,
+// which cancels them when it is done
+type ContextQueryer interface {
+	Queryer
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	NamedQueryContext(ctx context.Context, query string, arg interface{}) (*sqlx.Rows, error)
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// BindContext returns a Queryer running q's queries under ctx, so that they
+// stop once the request they serve is cancelled or times out. Queryers that
+// can't take a context, such as test doubles, are returned as is.
+func BindContext(ctx context.Context, q Queryer) Queryer {
+	if tx, ok := q.(*sqlx.Tx); ok {
+		q = txQueryer{tx}
+	}
+	cq, ok := q.(ContextQueryer)
+	if !ok {
+		return q
+	}
+	return &boundQueryer{ctx: ctx, q: cq}
+}
+
+// TxWrapper is implemented by DBInterface wrappers that also wrap the
+// queries run within the transactions they start, since those go through
+// the *sqlx.Tx rather than the wrapper
+type TxWrapper interface {
+	WrapTx(tx *sqlx.Tx) ContextQueryer
+}
+
+// WrapTx returns the Queryer to run tx's queries through, wrapped like db's
+// own queries when db is a TxWrapper
+func WrapTx(db DBInterface, tx *sqlx.Tx) ContextQueryer {
+	if wrapper, ok := db.(TxWrapper); ok {
+		return wrapper.WrapTx(tx)
+	}
+	return txQueryer{tx}
+}
+
+// txQueryer adds the NamedQueryContext method *sqlx.Tx lacks
+type txQueryer struct {
+	*sqlx.Tx
+}
+
+func (t txQueryer) NamedQueryContext(ctx context.Context, query string, arg interface{}) (*sqlx.Rows, error) {
+	return sqlx.NamedQueryContext(ctx, t.Tx, query, arg)
+}
+
+// boundQueryer runs every query of a ContextQueryer under a fixed context
+type boundQueryer struct {
+	ctx context.Context
+	q   ContextQueryer
+}
+
+func (b *boundQueryer) Get(dest interface{}, query string, args ...interface{}) error {
+	return b.q.GetContext(b.ctx, dest, query, args...)
+}
+
+func (b *boundQueryer) Select(dest interface{}, query string, args ...interface{}) error {
+	return b.q.SelectContext(b.ctx, dest, query, args...)
+}
+
+func (b *boundQueryer) NamedQuery(query string, arg interface{}) (*sqlx.Rows, error) {
+	return b.q.NamedQueryContext(b.ctx, query, arg)
+}
+
+func (b *boundQueryer) NamedExec(query string, arg interface{}) (sql.Result, error) {
+	return b.q.NamedExecContext(b.ctx, query, arg)
+}
+
+func (b *boundQueryer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return b.q.ExecContext(b.ctx, query, args...)
+}
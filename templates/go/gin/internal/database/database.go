@@ -2,8 +2,9 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"time"
+	"sync"
 
 	"gin-service/internal/config"
 
@@ -11,17 +12,23 @@ import (
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 )
 
-// DBInterface defines the methods required for database operations
-type DBInterface interface {
+// Queryer is the set of query methods shared by *DB and *sqlx.Tx, so that
+// service code can run either standalone or inside a transaction
+type Queryer interface {
 	Get(dest interface{}, query string, args ...interface{}) error
 	Select(dest interface{}, query string, args ...interface{}) error
 	NamedQuery(query string, arg interface{}) (*sqlx.Rows, error)
 	NamedExec(query string, arg interface{}) (sql.Result, error)
 	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// DBInterface defines the methods required for database operations
+type DBInterface interface {
+	Queryer
 	Query(query string, args ...interface{}) (*sql.Rows, error)
 	QueryRow(query string, args ...interface{}) *sql.Row
 	Queryx(query string, args ...interface{}) (*sqlx.Rows, error)
@@ -36,8 +43,16 @@ type DBInterface interface {
 // DB wraps sqlx.DB with additional functionality
 type DB struct {
 	*sqlx.DB
+	maxIdleConns int
 }
 
+// Keep the interfaces in step with the types handed to repositories and
+// handlers, so mocks built against them can't drift from production
+var (
+	_ DBInterface = (*DB)(nil)
+	_ Queryer     = (*sqlx.Tx)(nil)
+)
+
 // Initialize creates a new database connection
 func Initialize(cfg *config.Config) (*DB, error) {
 	db, err := sqlx.Open("postgres", cfg.Database.URL)
@@ -48,14 +63,14 @@ func Initialize(cfg *config.Config) (*DB, error) {
 	// Configure connection pool
 	db.SetMaxOpenConns(cfg.Database.MaxOpenConns)
 	db.SetMaxIdleConns(cfg.Database.MaxIdleConns)
-	db.SetConnMaxLifetime(time.Duration(cfg.Database.ConnMaxLifetime) * time.Second)
+	db.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime.Duration())
 
 	// Test connection
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return &DB{db}, nil
+	return &DB{DB: db, maxIdleConns: cfg.Database.MaxIdleConns}, nil
 }
 
 // Close closes the database connection
@@ -68,32 +83,77 @@ func (db *DB) Health() error {
 	return db.Ping()
 }
 
-// RunMigrations runs database migrations
-func RunMigrations(databaseURL string) error {
-	zap.L().Info("Running database migrations")
+// HasExtension reports whether the Postgres extension name is installed in
+// the database
+func (db *DB) HasExtension(name string) (bool, error) {
+	var exists bool
+	err := db.Get(&exists, `SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = $1)`, name)
+	return exists, err
+}
 
-	// Open database connection for migrations
-	db, err := sql.Open("postgres", databaseURL)
+// MigrationVersion reads the current migration version and dirty flag from
+// the schema_migrations table kept by golang-migrate. Unlike
+// Migrator.MigrationVersion it uses the pool, so it is cheap enough for
+// health checks. A database without migrations reports version 0.
+func (db *DB) MigrationVersion() (uint, bool, error) {
+	var row struct {
+		Version int64 `db:"version"`
+		Dirty   bool  `db:"dirty"`
+	}
+	err := db.Get(&row, `SELECT version, dirty FROM schema_migrations LIMIT 1`)
 	if err != nil {
-		return fmt.Errorf("failed to open database for migrations: %w", err)
+		var pqErr *pq.Error
+		if errors.Is(err, sql.ErrNoRows) || (errors.As(err, &pqErr) && pqErr.Code == "42P01") {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("failed to get migration version: %w", err)
 	}
-	defer db.Close()
+	return uint(row.Version), row.Dirty, nil
+}
 
-	// Create postgres driver instance
-	driver, err := postgres.WithInstance(db, &postgres.Config{})
-	if err != nil {
-		return fmt.Errorf("failed to create postgres driver: %w", err)
+// Migration failure policies
+const (
+	MigrationOnFailureFatal = "fatal"
+	MigrationOnFailureWarn  = "warn"
+	MigrationOnFailureSkip  = "skip"
+)
+
+// Migrator runs golang-migrate migrations from a directory against a database
+type Migrator struct {
+	databaseURL string
+	sourceURL   string
+}
+
+// NewMigrator creates a new migrator reading migrations from path
+func NewMigrator(databaseURL, path string) *Migrator {
+	return &Migrator{
+		databaseURL: databaseURL,
+		sourceURL:   "file://" + path,
 	}
+}
 
-	// Create migrate instance
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://migrations",
-		"postgres",
-		driver,
-	)
+// Reconnect discards idle connections, which may be stale after a database
+// restart, and pings to establish a fresh one
+func (db *DB) Reconnect() error {
+	db.SetMaxIdleConns(0)
+	db.SetMaxIdleConns(db.maxIdleConns)
+	return db.Ping()
+}
+
+// RunMigrations runs database migrations from the default migrations directory
+func RunMigrations(databaseURL string) error {
+	return NewMigrator(databaseURL, "migrations").RunMigrations()
+}
+
+// RunMigrations applies all pending up migrations
+func (mg *Migrator) RunMigrations() error {
+	zap.L().Info("Running database migrations")
+
+	m, err := mg.open()
 	if err != nil {
-		return fmt.Errorf("failed to create migrate instance: %w", err)
+		return err
 	}
+	defer m.Close()
 
 	// Run migrations
 	if err := m.Up(); err != nil {
@@ -108,8 +168,100 @@ func RunMigrations(databaseURL string) error {
 	return nil
 }
 
-// Transaction executes a function within a database transaction
-func (db *DB) Transaction(fn func(*sqlx.Tx) error) error {
+// RunMigrationsWithPolicy runs migrations and applies the configured failure
+// policy. It only returns an error when the failure should be fatal, which is
+// always the case in production regardless of the policy.
+func (mg *Migrator) RunMigrationsWithPolicy(onFailure, environment string, logger *zap.Logger) error {
+	err := mg.RunMigrations()
+	if err == nil {
+		return nil
+	}
+
+	if environment == "production" {
+		onFailure = MigrationOnFailureFatal
+	}
+
+	switch onFailure {
+	case MigrationOnFailureWarn:
+		logger.Warn("Migrations failed, continuing startup", zap.Error(err))
+		return nil
+	case MigrationOnFailureSkip:
+		logger.Info("Migrations failed, skipping", zap.Error(err))
+		return nil
+	default:
+		return err
+	}
+}
+
+// RunMigrationsDown rolls back the given number of migrations
+func (mg *Migrator) RunMigrationsDown(steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("steps must be positive, got %d", steps)
+	}
+
+	m, err := mg.open()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Steps(-steps); err != nil {
+		return fmt.Errorf("failed to roll back migrations: %w", err)
+	}
+
+	zap.L().Info("Migrations rolled back", zap.Int("steps", steps))
+	return nil
+}
+
+// MigrationVersion returns the current migration version and whether the
+// database is in a dirty state. A database without migrations reports
+// version 0.
+func (mg *Migrator) MigrationVersion() (uint, bool, error) {
+	m, err := mg.open()
+	if err != nil {
+		return 0, false, err
+	}
+	defer m.Close()
+
+	version, dirty, err := m.Version()
+	if err != nil {
+		if err == migrate.ErrNilVersion {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("failed to get migration version: %w", err)
+	}
+
+	return version, dirty, nil
+}
+
+// open creates a migrate instance backed by a dedicated database connection
+func (mg *Migrator) open() (*migrate.Migrate, error) {
+	// Open database connection for migrations
+	db, err := sql.Open("postgres", mg.databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database for migrations: %w", err)
+	}
+
+	// Create postgres driver instance
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create postgres driver: %w", err)
+	}
+
+	// Create migrate instance
+	m, err := migrate.NewWithDatabaseInstance(mg.sourceURL, "postgres", driver)
+	if err != nil {
+		driver.Close()
+		return nil, fmt.Errorf("failed to create migrate instance: %w", err)
+	}
+
+	return m, nil
+}
+
+// Transaction executes a function within a database transaction. The
+// transaction is committed if fn succeeds and rolled back otherwise.
+func (db *DB) Transaction(fn func(*sqlx.Tx) error) (err error) {
 	tx, err := db.Beginx()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
@@ -130,10 +282,39 @@ func (db *DB) Transaction(fn func(*sqlx.Tx) error) error {
 	return err
 }
 
-// Paginate represents pagination parameters
+// Default page size limits, used until SetPaginationLimits is called
+const (
+	DefaultPaginationLimit = 10
+	MaxPaginationLimit     = 100
+)
+
+var (
+	paginationMu           sync.RWMutex
+	paginationDefaultLimit = DefaultPaginationLimit
+	paginationMaxLimit     = MaxPaginationLimit
+)
+
+// SetPaginationLimits sets the page size CalculateOffset applies when no
+// limit is given, and the largest limit it allows
+func SetPaginationLimits(defaultLimit, maxLimit int) {
+	paginationMu.Lock()
+	defer paginationMu.Unlock()
+	paginationDefaultLimit = defaultLimit
+	paginationMaxLimit = maxLimit
+}
+
+// PaginationLimits returns the limits set by SetPaginationLimits
+func PaginationLimits() (defaultLimit, maxLimit int) {
+	paginationMu.RLock()
+	defer paginationMu.RUnlock()
+	return paginationDefaultLimit, paginationMaxLimit
+}
+
+// Paginate represents pagination parameters. A zero Limit stands for the
+// default page size.
 type Paginate struct {
 	Page    int  `json:"page" form:"page" binding:"min=1"`
-	Limit   int  `json:"limit" form:"limit" binding:"min=1,max=100"`
+	Limit   int  `json:"limit" form:"limit" binding:"min=1"`
 	Offset  int  `json:"-"`
 	Total   int  `json:"total"`
 	Pages   int  `json:"pages"`
@@ -141,16 +322,18 @@ type Paginate struct {
 	HasPrev bool `json:"has_prev"`
 }
 
-// CalculateOffset calculates the offset for pagination
+// CalculateOffset calculates the offset for pagination, applying the
+// default page size to a missing limit and capping it at the maximum
 func (p *Paginate) CalculateOffset() {
+	defaultLimit, maxLimit := PaginationLimits()
 	if p.Page < 1 {
 		p.Page = 1
 	}
 	if p.Limit < 1 {
-		p.Limit = 10
+		p.Limit = defaultLimit
 	}
-	if p.Limit > 100 {
-		p.Limit = 100
+	if p.Limit > maxLimit {
+		p.Limit = maxLimit
 	}
 	p.Offset = (p.Page - 1) * p.Limit
 }
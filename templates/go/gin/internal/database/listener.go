@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// Reconnection backoff and keepalive of a Listener
+const (
+	listenerMinReconnect = 100 * time.Millisecond
+	listenerMaxReconnect = time.Minute
+	listenerPingInterval = 90 * time.Second
+)
+
+// Listener receives the notifications sent on a Postgres channel with
+// NOTIFY, over a connection of its own that it reopens when it drops
+type Listener struct {
+	url     string
+	channel string
+	logger  *zap.Logger
+}
+
+// NewListener creates a listener on channel of the database at url
+func NewListener(url, channel string, logger *zap.Logger) *Listener {
+	return &Listener{
+		url:     url,
+		channel: channel,
+		logger:  logger.With(zap.String("component", "listener"), zap.String("channel", channel)),
+	}
+}
+
+// Run calls handle with the payload of each notification until ctx is
+// cancelled. Notifications sent while the connection is down are lost, so
+// whatever they would have invalidated has to expire on its own.
+func (l *Listener) Run(ctx context.Context, handle func(payload string)) {
+	listener := pq.NewListener(l.url, listenerMinReconnect, listenerMaxReconnect, l.logEvent)
+	defer listener.Close()
+	if err := listener.Listen(l.channel); err != nil {
+		l.logger.Error("Failed to listen for notifications", zap.Error(err))
+		return
+	}
+
+	// Pings detect a dead connection that no notification would reveal
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification := <-listener.Notify:
+			// A nil notification follows a reconnection, reported by
+			// logEvent
+			if notification != nil {
+				handle(notification.Extra)
+			}
+		case <-ticker.C:
+			go listener.Ping()
+		}
+	}
+}
+
+// logEvent logs the changes of the listener's connection
+func (l *Listener) logEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventConnected:
+		l.logger.Info("Listening for notifications")
+	case pq.ListenerEventDisconnected:
+		l.logger.Warn("Lost the connection listening for notifications", zap.Error(err))
+	case pq.ListenerEventReconnected:
+		l.logger.Warn("Reconnected to listen for notifications; those sent meanwhile were lost")
+	case pq.ListenerEventConnectionAttemptFailed:
+		l.logger.Warn("Failed to connect to listen for notifications", zap.Error(err))
+	}
+}
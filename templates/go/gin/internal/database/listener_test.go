@@ -0,0 +1,71 @@
+package database_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"gin-service/internal/database"
+	"gin-service/internal/database/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// TestMain stops the embedded test database once the tests are done
+func TestMain(m *testing.M) {
+	testutil.Main(m)
+}
+
+func TestListener(t *testing.T) {
+	db := testutil.Open(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	payloads := make(chan string, 10)
+	listener := database.NewListener(testutil.URL(t), "user_changes", zap.NewNop())
+	go listener.Run(ctx, func(payload string) { payloads <- payload })
+
+	// Notifications sent before the listener is connected are lost, so
+	// notify until one arrives
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(5 * time.Second)
+notify:
+	for {
+		select {
+		case payload := <-payloads:
+			assert.Equal(t, "42", payload)
+			break notify
+		case <-ticker.C:
+			_, err := db.Exec(`SELECT pg_notify('user_changes', '42')`)
+			require.NoError(t, err)
+		case <-timeout:
+			t.Fatal("no notification received")
+		}
+	}
+
+	// Writes to users notify with the user's ID once committed
+	var id int
+	require.NoError(t, db.QueryRow(`
+		INSERT INTO users (username, email, password_hash)
+		VALUES ('listener_test', 'listener_test@example.com', 'hash')
+		RETURNING id`).Scan(&id))
+	_, err := db.Exec(`DELETE FROM users WHERE id = $1`, id)
+	require.NoError(t, err)
+	for received := 0; received < 2; {
+		select {
+		case payload := <-payloads:
+			// Skip the test notifications still in flight
+			if payload == "42" {
+				continue
+			}
+			assert.Equal(t, strconv.Itoa(id), payload)
+			received++
+		case <-time.After(5 * time.Second):
+			t.Fatal("no notification of the user write received")
+		}
+	}
+}
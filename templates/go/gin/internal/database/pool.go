@@ -0,0 +1,52 @@
+package database
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// PoolMonitor detects connection pool exhaustion from sql.DBStats.
+//
+// The pool is considered exhausted when every connection is in use and new
+// callers have queued for a connection since the last check, or when the
+// average time spent waiting for a connection since the last check exceeds
+// the configured threshold.
+type PoolMonitor struct {
+	stats         func() sql.DBStats
+	waitThreshold time.Duration
+
+	mu               sync.Mutex
+	lastWaitCount    int64
+	lastWaitDuration time.Duration
+}
+
+// NewPoolMonitor creates a new pool monitor reading stats from the given function
+func NewPoolMonitor(stats func() sql.DBStats, waitThreshold time.Duration) *PoolMonitor {
+	return &PoolMonitor{
+		stats:         stats,
+		waitThreshold: waitThreshold,
+	}
+}
+
+// Exhausted reports whether the connection pool is currently exhausted
+func (p *PoolMonitor) Exhausted() bool {
+	stats := p.stats()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	newWaits := stats.WaitCount - p.lastWaitCount
+	newWaitDuration := stats.WaitDuration - p.lastWaitDuration
+	p.lastWaitCount = stats.WaitCount
+	p.lastWaitDuration = stats.WaitDuration
+
+	if newWaits <= 0 {
+		return false
+	}
+
+	saturated := stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections
+	slowAcquire := p.waitThreshold > 0 && newWaitDuration/time.Duration(newWaits) > p.waitThreshold
+
+	return saturated || slowAcquire
+}
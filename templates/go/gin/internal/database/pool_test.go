@@ -0,0 +1,37 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolMonitor_Exhausted(t *testing.T) {
+	stats := sql.DBStats{MaxOpenConnections: 2}
+	monitor := NewPoolMonitor(func() sql.DBStats { return stats }, 100*time.Millisecond)
+
+	// Idle pool
+	assert.False(t, monitor.Exhausted())
+
+	// All connections in use with new waiters
+	stats.InUse = 2
+	stats.WaitCount = 3
+	stats.WaitDuration = 30 * time.Millisecond
+	assert.True(t, monitor.Exhausted())
+
+	// Saturated but nobody queued since the last check
+	assert.False(t, monitor.Exhausted())
+
+	// Free connections but slow acquisition on average
+	stats.InUse = 1
+	stats.WaitCount = 4
+	stats.WaitDuration = 530 * time.Millisecond
+	assert.True(t, monitor.Exhausted())
+
+	// Fast acquisition with free connections
+	stats.WaitCount = 5
+	stats.WaitDuration = 540 * time.Millisecond
+	assert.False(t, monitor.Exhausted())
+}
@@ -0,0 +1,244 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+type contextKey string
+
+const requestIDKey contextKey = "request_id"
+
+// ContextWithRequestID returns a copy of ctx carrying the given request ID
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestIDFromContext extracts the request ID from the context, if any
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// QueryLogger wraps a DBInterface and logs the duration of every query, and
+// the number of rows affected by every statement, including those run within
+// transactions through WrapTx. Queries slower than the threshold are logged
+// at warn level, faster ones at debug level. Only the parameterized query is
+// logged, never the arguments.
+type QueryLogger struct {
+	DBInterface
+	logger    *zap.Logger
+	threshold time.Duration
+	ctx       context.Context
+}
+
+var (
+	_ DBInterface = (*QueryLogger)(nil)
+	_ TxWrapper   = (*QueryLogger)(nil)
+)
+
+// NewQueryLogger creates a new query logging wrapper around db
+func NewQueryLogger(db DBInterface, threshold time.Duration, logger *zap.Logger) *QueryLogger {
+	return &QueryLogger{
+		DBInterface: db,
+		logger:      logger,
+		threshold:   threshold,
+		ctx:         context.Background(),
+	}
+}
+
+// WithContext returns a copy of the logger bound to ctx, so that log lines
+// carry the request ID stored in it
+func (q *QueryLogger) WithContext(ctx context.Context) *QueryLogger {
+	clone := *q
+	clone.ctx = ctx
+	return &clone
+}
+
+// Get logs and executes a single-row query
+func (q *QueryLogger) Get(dest interface{}, query string, args ...interface{}) error {
+	defer q.observe(q.ctx, "get", query, time.Now())
+	return q.DBInterface.Get(dest, query, args...)
+}
+
+// Select logs and executes a multi-row query
+func (q *QueryLogger) Select(dest interface{}, query string, args ...interface{}) error {
+	defer q.observe(q.ctx, "select", query, time.Now())
+	return q.DBInterface.Select(dest, query, args...)
+}
+
+// Exec logs and executes a statement
+func (q *QueryLogger) Exec(query string, args ...interface{}) (result sql.Result, err error) {
+	defer q.observeExec(q.ctx, "exec", query, time.Now(), &result)
+	return q.DBInterface.Exec(query, args...)
+}
+
+// NamedExec logs and executes a named statement
+func (q *QueryLogger) NamedExec(query string, arg interface{}) (result sql.Result, err error) {
+	defer q.observeExec(q.ctx, "named_exec", query, time.Now(), &result)
+	return q.DBInterface.NamedExec(query, arg)
+}
+
+// NamedQuery logs and executes a named query
+func (q *QueryLogger) NamedQuery(query string, arg interface{}) (*sqlx.Rows, error) {
+	defer q.observe(q.ctx, "named_query", query, time.Now())
+	return q.DBInterface.NamedQuery(query, arg)
+}
+
+// GetContext logs and executes a single-row query under ctx
+func (q *QueryLogger) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	defer q.observe(ctx, "get", query, time.Now())
+	if db, ok := q.DBInterface.(ContextQueryer); ok {
+		return db.GetContext(ctx, dest, query, args...)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return q.DBInterface.Get(dest, query, args...)
+}
+
+// SelectContext logs and executes a multi-row query under ctx
+func (q *QueryLogger) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	defer q.observe(ctx, "select", query, time.Now())
+	if db, ok := q.DBInterface.(ContextQueryer); ok {
+		return db.SelectContext(ctx, dest, query, args...)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return q.DBInterface.Select(dest, query, args...)
+}
+
+// ExecContext logs and executes a statement under ctx
+func (q *QueryLogger) ExecContext(ctx context.Context, query string, args ...interface{}) (result sql.Result, err error) {
+	defer q.observeExec(ctx, "exec", query, time.Now(), &result)
+	if db, ok := q.DBInterface.(ContextQueryer); ok {
+		return db.ExecContext(ctx, query, args...)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return q.DBInterface.Exec(query, args...)
+}
+
+// NamedExecContext logs and executes a named statement under ctx
+func (q *QueryLogger) NamedExecContext(ctx context.Context, query string, arg interface{}) (result sql.Result, err error) {
+	defer q.observeExec(ctx, "named_exec", query, time.Now(), &result)
+	if db, ok := q.DBInterface.(ContextQueryer); ok {
+		return db.NamedExecContext(ctx, query, arg)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return q.DBInterface.NamedExec(query, arg)
+}
+
+// NamedQueryContext logs and executes a named query under ctx
+func (q *QueryLogger) NamedQueryContext(ctx context.Context, query string, arg interface{}) (*sqlx.Rows, error) {
+	defer q.observe(ctx, "named_query", query, time.Now())
+	if db, ok := q.DBInterface.(ContextQueryer); ok {
+		return db.NamedQueryContext(ctx, query, arg)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return q.DBInterface.NamedQuery(query, arg)
+}
+
+// WrapTx returns a Queryer logging the queries run within tx like the
+// logger's own
+func (q *QueryLogger) WrapTx(tx *sqlx.Tx) ContextQueryer {
+	return &txLogger{tx: WrapTx(q.DBInterface, tx), logger: q}
+}
+
+// txLogger logs the queries run within a transaction through its QueryLogger
+type txLogger struct {
+	tx     ContextQueryer
+	logger *QueryLogger
+}
+
+func (t *txLogger) Get(dest interface{}, query string, args ...interface{}) error {
+	return t.GetContext(t.logger.ctx, dest, query, args...)
+}
+
+func (t *txLogger) Select(dest interface{}, query string, args ...interface{}) error {
+	return t.SelectContext(t.logger.ctx, dest, query, args...)
+}
+
+func (t *txLogger) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.ExecContext(t.logger.ctx, query, args...)
+}
+
+func (t *txLogger) NamedExec(query string, arg interface{}) (sql.Result, error) {
+	return t.NamedExecContext(t.logger.ctx, query, arg)
+}
+
+func (t *txLogger) NamedQuery(query string, arg interface{}) (*sqlx.Rows, error) {
+	return t.NamedQueryContext(t.logger.ctx, query, arg)
+}
+
+func (t *txLogger) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	defer t.logger.observe(ctx, "get", query, time.Now())
+	return t.tx.GetContext(ctx, dest, query, args...)
+}
+
+func (t *txLogger) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	defer t.logger.observe(ctx, "select", query, time.Now())
+	return t.tx.SelectContext(ctx, dest, query, args...)
+}
+
+func (t *txLogger) ExecContext(ctx context.Context, query string, args ...interface{}) (result sql.Result, err error) {
+	defer t.logger.observeExec(ctx, "exec", query, time.Now(), &result)
+	return t.tx.ExecContext(ctx, query, args...)
+}
+
+func (t *txLogger) NamedExecContext(ctx context.Context, query string, arg interface{}) (result sql.Result, err error) {
+	defer t.logger.observeExec(ctx, "named_exec", query, time.Now(), &result)
+	return t.tx.NamedExecContext(ctx, query, arg)
+}
+
+func (t *txLogger) NamedQueryContext(ctx context.Context, query string, arg interface{}) (*sqlx.Rows, error) {
+	defer t.logger.observe(ctx, "named_query", query, time.Now())
+	return t.tx.NamedQueryContext(ctx, query, arg)
+}
+
+// observeExec logs a statement like observe, with the number of rows it
+// affected when it succeeded. result points to the statement's result, which
+// is only set once the deferred call runs.
+func (q *QueryLogger) observeExec(ctx context.Context, operation, query string, start time.Time, result *sql.Result) {
+	var fields []zap.Field
+	if *result != nil {
+		if rows, err := (*result).RowsAffected(); err == nil {
+			fields = append(fields, zap.Int64("rows_affected", rows))
+		}
+	}
+	q.observe(ctx, operation, query, start, fields...)
+}
+
+// observe logs the query duration at a level depending on the threshold
+func (q *QueryLogger) observe(ctx context.Context, operation, query string, start time.Time, extra ...zap.Field) {
+	duration := time.Since(start)
+
+	fields := []zap.Field{
+		zap.String("operation", operation),
+		zap.String("query", query),
+		zap.Duration("duration", duration),
+	}
+	fields = append(fields, extra...)
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		fields = append(fields, zap.String("request_id", requestID))
+	}
+
+	if q.threshold > 0 && duration > q.threshold {
+		q.logger.Warn("Slow query", fields...)
+		return
+	}
+	q.logger.Debug("Query executed", fields...)
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// slowDB is a DBInterface whose Get sleeps for a fixed delay
+type slowDB struct {
+	DBInterface
+	delay time.Duration
+}
+
+func (s *slowDB) Get(dest interface{}, query string, args ...interface{}) error {
+	time.Sleep(s.delay)
+	return nil
+}
+
+func (s *slowDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	time.Sleep(s.delay)
+	return driver.RowsAffected(3), nil
+}
+
+func setupQueryLogger(delay, threshold time.Duration) (*QueryLogger, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	return NewQueryLogger(&slowDB{delay: delay}, threshold, zap.New(core)), logs
+}
+
+func TestQueryLogger_SlowQueryWarns(t *testing.T) {
+	queryLogger, logs := setupQueryLogger(30*time.Millisecond, 10*time.Millisecond)
+
+	ctx := ContextWithRequestID(context.Background(), "req-123")
+	var id int
+	err := queryLogger.WithContext(ctx).Get(&id, "SELECT id FROM users WHERE username = $1", "secret-value")
+
+	assert.NoError(t, err)
+	entries := logs.FilterMessage("Slow query").All()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, zapcore.WarnLevel, entries[0].Level)
+
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "SELECT id FROM users WHERE username = $1", fields["query"])
+	assert.Equal(t, "req-123", fields["request_id"])
+	assert.NotContains(t, fields, "args")
+}
+
+func TestQueryLogger_FastQueryLogsDebug(t *testing.T) {
+	queryLogger, logs := setupQueryLogger(0, time.Second)
+
+	var id int
+	err := queryLogger.Get(&id, "SELECT id FROM users WHERE id = $1", 1)
+
+	assert.NoError(t, err)
+	assert.Empty(t, logs.FilterMessage("Slow query").All())
+
+	entries := logs.FilterMessage("Query executed").All()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, zapcore.DebugLevel, entries[0].Level)
+	assert.NotContains(t, entries[0].ContextMap(), "request_id")
+}
+
+func TestQueryLogger_BoundContextCancelsQuery(t *testing.T) {
+	queryLogger, logs := setupQueryLogger(time.Second, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var id int
+	start := time.Now()
+	err := BindContext(ctx, queryLogger).Get(&id, "SELECT id FROM users WHERE id = $1", 1)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Len(t, logs.FilterMessage("Query executed").All(), 1)
+}
+
+func TestQueryLogger_ExecLogsRowsAffected(t *testing.T) {
+	queryLogger, logs := setupQueryLogger(0, time.Second)
+
+	_, err := queryLogger.Exec("UPDATE users SET is_active = $1 WHERE id = $2", false, 1)
+
+	assert.NoError(t, err)
+	entries := logs.FilterMessage("Query executed").All()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, zapcore.DebugLevel, entries[0].Level)
+	assert.Equal(t, int64(3), entries[0].ContextMap()["rows_affected"])
+
+	var id int
+	assert.NoError(t, queryLogger.Get(&id, "SELECT id FROM users WHERE id = $1", 1))
+	assert.NotContains(t, logs.FilterMessage("Query executed").All()[1].ContextMap(), "rows_affected")
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RetryPolicy retries operations failing with connection-level errors using
+// capped exponential backoff
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// Do runs fn, retrying it while it fails with a connection error and attempts
+// remain. Logical errors such as constraint violations are returned at once.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	return p.DoWhen(ctx, IsConnectionError, fn)
+}
+
+// DoWhen runs fn, retrying it while it fails with an error for which
+// retryable returns true and attempts remain. Other errors are returned at
+// once. Once ctx is done, the backoff between attempts is cut short and
+// ctx's error returned.
+func (p RetryPolicy) DoWhen(ctx context.Context, retryable func(error) bool, fn func() error) error {
+	delay := p.BaseDelay
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !retryable(err) || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
+
+// Postgres error codes of transactions aborted because they conflicted with a
+// concurrent one, which succeed when retried
+const (
+	codeSerializationFailure = "40001"
+	codeDeadlockDetected     = "40P01"
+)
+
+// TransactionRetries counts transactions retried after a serialization
+// failure or deadlock, by error code
+var TransactionRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gin_service",
+	Name:      "db_transaction_retries_total",
+	Help:      "Transactions retried after a serialization failure or deadlock.",
+}, []string{"code"})
+
+// IsSerializationFailure reports whether err aborted a transaction because of
+// a serialization failure or deadlock with a concurrent transaction
+func IsSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == codeSerializationFailure || pqErr.Code == codeDeadlockDetected
+}
+
+// RecordTransactionRetry counts a retry of a transaction aborted by err
+func RecordTransactionRetry(err error) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		TransactionRetries.WithLabelValues(string(pqErr.Code)).Inc()
+	}
+}
+
+// IsConnectionError reports whether err was caused by a lost or refused
+// database connection, as opposed to an error in the query itself
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr *net.OpError
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code.Class() == "08": // connection_exception
+			return true
+		case pqErr.Code == "57P01", pqErr.Code == "57P02", pqErr.Code == "57P03": // admin/crash shutdown, cannot connect now
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,296 @@
+// Package testutil connects tests to a real Postgres database, so that SQL
+// the in-memory store can't vouch for, such as INSERT ... RETURNING, is
+// tested as it runs in production. Each test binary uses the database at
+// TEST_DATABASE_URL when it is set, and otherwise starts an embedded Postgres
+// server on first use. The server's binaries are only downloaded when
+// TEST_EMBEDDED_POSTGRES_DOWNLOAD is set, so tests needing a database are
+// skipped offline until they are cached. Packages using it must run their
+// tests through Main.
+package testutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gin-service/internal/database"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/jmoiron/sqlx"
+)
+
+// DatabaseURLEnv names the environment variable holding the URL of an
+// existing test database, used instead of the embedded server
+const DatabaseURLEnv = "TEST_DATABASE_URL"
+
+// DownloadEnv names the environment variable allowing the embedded server's
+// binaries to be downloaded when they aren't cached yet
+const DownloadEnv = "TEST_EMBEDDED_POSTGRES_DOWNLOAD"
+
+// embeddedVersion is the Postgres version the service is deployed with
+const embeddedVersion = embeddedpostgres.V15
+
+// errNoDatabase is returned by setup when there is neither a test database
+// nor cached binaries, and downloading them isn't allowed
+var errNoDatabase = fmt.Errorf("no test database: set %s, or %s=1 to download embedded Postgres", DatabaseURLEnv, DownloadEnv)
+
+// embeddedStartTimeout bounds the embedded server's startup, which includes
+// downloading the Postgres binaries when they aren't cached
+const embeddedStartTimeout = 2 * time.Minute
+
+var (
+	mainRunning bool
+
+	setupOnce   sync.Once
+	databaseURL string
+	embedded    *embeddedpostgres.EmbeddedPostgres
+	runtimeDir  string
+	setupErr    error
+)
+
+// Main runs the tests of a package using testutil and stops the embedded
+// server, if one was started, before exiting. Call it from TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		testutil.Main(m)
+//	}
+func Main(m *testing.M) {
+	mainRunning = true
+	code := m.Run()
+	if err := stopEmbedded(); err != nil {
+		fmt.Fprintf(os.Stderr, "testutil: failed to stop embedded Postgres: %v\n", err)
+		if code == 0 {
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// URL returns the URL of the migrated test database, starting the embedded
+// server on first use. The test is skipped if there is no database to use,
+// and fails if the database can't be set up.
+func URL(t testing.TB) string {
+	t.Helper()
+	if !mainRunning {
+		t.Fatal("testutil: call testutil.Main from the package's TestMain")
+	}
+
+	setupOnce.Do(func() {
+		setupErr = setup()
+	})
+	if errors.Is(setupErr, errNoDatabase) {
+		t.Skip(setupErr)
+	}
+	if setupErr != nil {
+		t.Fatalf("failed to set up test database: %v", setupErr)
+	}
+	return databaseURL
+}
+
+// Open connects to the test database and closes the connection when the
+// test ends. Tests share the database, so they must clean up what they
+// write; Tx does it for them.
+func Open(t testing.TB) *database.DB {
+	t.Helper()
+	conn, err := sqlx.Connect("postgres", URL(t))
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &database.DB{DB: conn}
+}
+
+// setup finds or starts the test database and migrates it
+func setup() error {
+	databaseURL = os.Getenv(DatabaseURLEnv)
+	if databaseURL == "" {
+		if !embeddedCached() && os.Getenv(DownloadEnv) == "" {
+			return errNoDatabase
+		}
+		if err := startEmbedded(); err != nil {
+			return fmt.Errorf("failed to start embedded Postgres (set %s to use an existing database instead): %w", DatabaseURLEnv, err)
+		}
+	}
+
+	path, err := migrationsPath()
+	if err != nil {
+		return err
+	}
+	if err := database.NewMigrator(databaseURL, path).RunMigrations(); err != nil {
+		return fmt.Errorf("failed to migrate: %w", err)
+	}
+	return nil
+}
+
+// embeddedCacheDir returns the directory the embedded server's binaries are
+// cached in, shared across runs
+func embeddedCacheDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".embedded-postgres-go"
+	}
+	return filepath.Join(home, ".embedded-postgres-go")
+}
+
+// embeddedCached reports whether the embedded server's binaries were
+// downloaded already
+func embeddedCached() bool {
+	archives, _ := filepath.Glob(filepath.Join(embeddedCacheDir(), "embedded-postgres-binaries-*-"+string(embeddedVersion)+".txz"))
+	return len(archives) > 0
+}
+
+// startEmbedded starts a Postgres server of the version the service is
+// deployed with, on a free port and in a fresh data directory
+func startEmbedded() error {
+	port, err := freePort()
+	if err != nil {
+		return err
+	}
+	runtimeDir, err = os.MkdirTemp("", "testutil-postgres-")
+	if err != nil {
+		return err
+	}
+
+	var logs bytes.Buffer
+	config := embeddedpostgres.DefaultConfig().
+		Version(embeddedVersion).
+		CachePath(embeddedCacheDir()).
+		Port(port).
+		Database("gin_service_test").
+		RuntimePath(runtimeDir).
+		StartTimeout(embeddedStartTimeout).
+		Logger(&logs)
+	server := embeddedpostgres.NewDatabase(config)
+	if err := server.Start(); err != nil {
+		os.RemoveAll(runtimeDir)
+		if logs.Len() > 0 {
+			return fmt.Errorf("%w\n%s", err, logs.String())
+		}
+		return err
+	}
+
+	embedded = server
+	databaseURL = config.GetConnectionURL() + "?sslmode=disable"
+	return nil
+}
+
+// stopEmbedded stops the embedded server, if one was started, and removes
+// its data
+func stopEmbedded() error {
+	if embedded == nil {
+		return nil
+	}
+	err := embedded.Stop()
+	os.RemoveAll(runtimeDir)
+	return err
+}
+
+// freePort returns a TCP port nothing listens on. Test binaries of several
+// packages run at once, so each server needs its own.
+func freePort() (uint32, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return uint32(l.Addr().(*net.TCPAddr).Port), nil
+}
+
+// migrationsPath returns the migrations directory at the module root, found
+// by walking up from the working directory, which go test sets to the
+// package under test
+func migrationsPath() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return filepath.Join(dir, "migrations"), nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("no go.mod above the working directory")
+		}
+		dir = parent
+	}
+}
+
+// TxDB is a database whose queries all run within one transaction, rolled
+// back when the test ends. Transactions begun through Transaction are
+// savepoints within it, so they roll back on failure as they would on their
+// own. A TxDB must not be used concurrently, and now() is the same
+// throughout, since Postgres fixes it at the start of the transaction.
+type TxDB struct {
+	*sqlx.Tx
+	db         *database.DB
+	savepoints int
+}
+
+var _ database.DBInterface = (*TxDB)(nil)
+
+// Tx returns a TxDB on the test database. Tests using it see the database
+// as other tests left it, which is empty when they all use Tx, and leave it
+// as they found it.
+func Tx(t testing.TB) *TxDB {
+	t.Helper()
+	db := Open(t)
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatalf("failed to begin test transaction: %v", err)
+	}
+	// Registered after Open's cleanup, so it runs before the connection
+	// closes
+	t.Cleanup(func() { tx.Rollback() })
+	return &TxDB{Tx: tx, db: db}
+}
+
+// Transaction runs fn within a savepoint, released if fn succeeds and rolled
+// back to otherwise
+func (db *TxDB) Transaction(fn func(*sqlx.Tx) error) (err error) {
+	db.savepoints++
+	savepoint := fmt.Sprintf("testutil_%d", db.savepoints)
+	if _, err := db.Tx.Exec("SAVEPOINT " + savepoint); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			db.Tx.Exec("ROLLBACK TO SAVEPOINT " + savepoint)
+			panic(p)
+		} else if err != nil {
+			db.Tx.Exec("ROLLBACK TO SAVEPOINT " + savepoint)
+		} else {
+			_, err = db.Tx.Exec("RELEASE SAVEPOINT " + savepoint)
+		}
+	}()
+
+	return fn(db.Tx)
+}
+
+// Beginx fails: a separate transaction wouldn't see the test's writes. Use
+// Transaction instead.
+func (db *TxDB) Beginx() (*sqlx.Tx, error) {
+	return nil, errors.New("testutil: Beginx is not supported within a test transaction, use Transaction")
+}
+
+// Health pings the test database
+func (db *TxDB) Health() error {
+	return db.db.Ping()
+}
+
+// Ping pings the test database
+func (db *TxDB) Ping() error {
+	return db.db.Ping()
+}
+
+// Close does nothing; the transaction is rolled back when the test ends
+func (db *TxDB) Close() error {
+	return nil
+}
@@ -0,0 +1,154 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Event is a domain event published after the change it describes commits
+type Event struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	AggregateID int             `json:"aggregate_id"`
+	Payload     json.RawMessage `json:"payload"`
+	OccurredAt  time.Time       `json:"occurred_at"`
+}
+
+// Publisher publishes events. Delivery is at least once, so an event may be
+// published again after a failure; consumers deduplicate on Event.ID.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Handler handles a published event
+type Handler func(ctx context.Context, event Event) error
+
+// deliveredRetention is how long the handlers that handled an event are
+// remembered after it last failed. An event failing here may be published
+// by another instance, or given up on, and never come back to drop its
+// record.
+const deliveredRetention = time.Hour
+
+// delivery records the indexes of the handlers that handled an event, as of
+// its last publish
+type delivery struct {
+	handlers map[int]bool
+	at       time.Time
+}
+
+// Bus is an in-process Publisher that dispatches events to the handlers
+// subscribed to their type, each in its own goroutine, so a slow or failing
+// handler doesn't hold up or stop the others. The handlers that succeeded
+// are recorded per event, so that publishing the event again after a failure
+// only runs the handlers that failed. Records expire after
+// deliveredRetention, after which all the event's handlers run again.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+
+	deliveredMu sync.Mutex
+	// delivered holds, for each event some handler failed, the handlers
+	// that succeeded
+	delivered map[int64]*delivery
+	retention time.Duration
+}
+
+// NewBus creates a new event bus
+func NewBus() *Bus {
+	return &Bus{
+		handlers:  make(map[string][]Handler),
+		delivered: make(map[int64]*delivery),
+		retention: deliveredRetention,
+	}
+}
+
+// Subscribe registers handler for events of eventType
+func (b *Bus) Subscribe(eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Publish runs the handlers subscribed to the event's type concurrently,
+// skipping those that already handled it, and waits for them. It returns
+// the errors of the handlers that failed, which run again when the event is
+// published again. Events without an ID aren't recorded, so all their
+// handlers run each time.
+func (b *Bus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	handlers := b.handlers[event.Type]
+	b.mu.RUnlock()
+
+	delivered := b.deliveredTo(event.ID)
+	errs := make([]error, len(handlers))
+	var wg sync.WaitGroup
+	for i, handler := range handlers {
+		if delivered[i] {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, handler Handler) {
+			defer wg.Done()
+			errs[i] = handler(ctx, event)
+		}(i, handler)
+	}
+	wg.Wait()
+
+	if err := b.record(event, delivered, errs); err != nil {
+		return fmt.Errorf("failed to handle %s event %d: %w", event.Type, event.ID, err)
+	}
+	return nil
+}
+
+// deliveredTo returns the indexes of the handlers that already handled the
+// event with id, unless its record expired
+func (b *Bus) deliveredTo(id int64) map[int]bool {
+	b.deliveredMu.Lock()
+	defer b.deliveredMu.Unlock()
+
+	delivered := make(map[int]bool)
+	if record, ok := b.delivered[id]; ok && time.Since(record.at) <= b.retention {
+		for i := range record.handlers {
+			delivered[i] = true
+		}
+	}
+	return delivered
+}
+
+// record records the handlers that handled event, given those that already
+// had and the errors of the others, returning the errors joined. The record
+// is dropped once every handler succeeded, and expired records of other
+// events along the way.
+func (b *Bus) record(event Event, delivered map[int]bool, errs []error) error {
+	var failed []error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		} else {
+			delivered[i] = true
+		}
+	}
+
+	if event.ID != 0 {
+		now := time.Now()
+		b.deliveredMu.Lock()
+		for id, record := range b.delivered {
+			if now.Sub(record.at) > b.retention {
+				delete(b.delivered, id)
+			}
+		}
+		if len(failed) == 0 {
+			delete(b.delivered, event.ID)
+		} else {
+			b.delivered[event.ID] = &delivery{handlers: delivered, at: now}
+		}
+		b.deliveredMu.Unlock()
+	}
+
+	return errors.Join(failed...)
+}
@@ -0,0 +1,112 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingHandler counts its calls, failing with the next error of fail
+type countingHandler struct {
+	calls atomic.Int32
+	fail  []error
+}
+
+func (h *countingHandler) handle(ctx context.Context, event Event) error {
+	call := int(h.calls.Add(1))
+	if call <= len(h.fail) {
+		return h.fail[call-1]
+	}
+	return nil
+}
+
+func TestBus_RetriesOnlyFailedHandlers(t *testing.T) {
+	bus := NewBus()
+	succeeding := &countingHandler{}
+	failing := &countingHandler{fail: []error{errors.New("unavailable")}}
+	bus.Subscribe("user.created", succeeding.handle)
+	bus.Subscribe("user.created", failing.handle)
+	bus.Subscribe("user.deleted", succeeding.handle)
+
+	event := Event{ID: 1, Type: "user.created"}
+	err := bus.Publish(context.Background(), event)
+	assert.ErrorContains(t, err, "unavailable")
+	assert.Equal(t, int32(1), succeeding.calls.Load())
+	assert.Equal(t, int32(1), failing.calls.Load())
+
+	// Publishing again runs only the handler that failed
+	require.NoError(t, bus.Publish(context.Background(), event))
+	assert.Equal(t, int32(1), succeeding.calls.Load())
+	assert.Equal(t, int32(2), failing.calls.Load())
+
+	// Other events run every handler
+	require.NoError(t, bus.Publish(context.Background(), Event{ID: 2, Type: "user.created"}))
+	assert.Equal(t, int32(2), succeeding.calls.Load())
+	assert.Equal(t, int32(3), failing.calls.Load())
+}
+
+func TestBus_EventsWithoutIDAreNotRecorded(t *testing.T) {
+	bus := NewBus()
+	succeeding := &countingHandler{}
+	failing := &countingHandler{fail: []error{errors.New("unavailable")}}
+	bus.Subscribe("user.created", succeeding.handle)
+	bus.Subscribe("user.created", failing.handle)
+
+	event := Event{Type: "user.created"}
+	assert.Error(t, bus.Publish(context.Background(), event))
+	require.NoError(t, bus.Publish(context.Background(), event))
+	assert.Equal(t, int32(2), succeeding.calls.Load())
+}
+
+func TestBus_ExpiresDeliveryRecords(t *testing.T) {
+	bus := NewBus()
+	bus.retention = 10 * time.Millisecond
+	bus.Subscribe("user.created", (&countingHandler{}).handle)
+	bus.Subscribe("user.created", (&countingHandler{fail: []error{errors.New("unavailable")}}).handle)
+
+	// The event failed here and is never published here again, for
+	// example because another instance delivered it
+	assert.Error(t, bus.Publish(context.Background(), Event{ID: 1, Type: "user.created"}))
+	bus.deliveredMu.Lock()
+	assert.Len(t, bus.delivered, 1)
+	bus.deliveredMu.Unlock()
+
+	// Its record expires, and is dropped by the next publish
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, bus.Publish(context.Background(), Event{ID: 2, Type: "user.created"}))
+	bus.deliveredMu.Lock()
+	assert.Empty(t, bus.delivered)
+	bus.deliveredMu.Unlock()
+}
+
+func TestBus_RunsHandlersConcurrently(t *testing.T) {
+	bus := NewBus()
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	// The first handler blocks until the second one runs
+	bus.Subscribe("user.created", func(ctx context.Context, event Event) error {
+		<-release
+		return nil
+	})
+	bus.Subscribe("user.created", func(ctx context.Context, event Event) error {
+		close(started)
+		return errors.New("unavailable")
+	})
+
+	done := make(chan error)
+	go func() { done <- bus.Publish(context.Background(), Event{ID: 1, Type: "user.created"}) }()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("the second handler waited for the first")
+	}
+	close(release)
+	assert.ErrorContains(t, <-done, "unavailable")
+}
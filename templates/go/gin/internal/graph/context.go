@@ -0,0 +1,50 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"gin-service/internal/api/middleware"
+	"gin-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// claimsKey is the context key for the authenticated user's claims
+type claimsKey struct{}
+
+// WithClaims returns a copy of ctx carrying the authenticated user's claims
+func WithClaims(ctx context.Context, claims *middleware.Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the authenticated user's claims, if any
+func ClaimsFromContext(ctx context.Context) (*middleware.Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*middleware.Claims)
+	return claims, ok
+}
+
+// Handler adapts the GraphQL server to gin, passing on the claims set by
+// OptionalAuthMiddleware so the @auth and @admin directives can check them,
+// and the client IP by which logins are throttled. Bodies declaring a length
+// over the size limit are refused before the server reads them.
+func Handler(srv http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := services.ContextWithClientIP(c.Request.Context(), c.ClientIP())
+		if claims, ok := middleware.GetClaims(c); ok {
+			ctx = WithClaims(ctx, claims)
+			if claims.ImpersonatedBy != 0 {
+				ctx = services.ContextWithImpersonator(ctx, claims.ImpersonatedBy)
+			}
+		}
+		c.Request = c.Request.WithContext(ctx)
+
+		if maxSize, ok := middleware.MaxSize(c); ok && c.Request.ContentLength > maxSize {
+			middleware.AbortWithError(c, http.StatusRequestEntityTooLarge, "request_too_large",
+				fmt.Sprintf("Request body too large. Maximum size is %d bytes", maxSize))
+			return
+		}
+		srv.ServeHTTP(c.Writer, c.Request)
+	}
+}
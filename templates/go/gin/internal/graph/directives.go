@@ -0,0 +1,41 @@
+package graph
+
+import (
+	"context"
+
+	"gin-service/internal/services"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// authDirective implements @auth, requiring an authenticated user
+func authDirective(ctx context.Context, obj interface{}, next graphql.Resolver) (interface{}, error) {
+	if _, ok := ClaimsFromContext(ctx); !ok {
+		return nil, newError(ctx, CodeUnauthorized, "User not authenticated")
+	}
+	return next(ctx)
+}
+
+// adminDirective implements @admin, requiring an authenticated admin user.
+// Like the admin REST routes that write, admin mutations are forbidden to
+// impersonation tokens, so that an admin acting as another admin can't
+// modify users with that admin's privileges.
+func adminDirective(ctx context.Context, obj interface{}, next graphql.Resolver) (interface{}, error) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return nil, newError(ctx, CodeUnauthorized, "User not authenticated")
+	}
+	if !claims.IsAdmin {
+		return nil, newError(ctx, CodeForbidden, "admin privileges required")
+	}
+	if _, impersonating := services.ImpersonatorFromContext(ctx); impersonating && isMutation(ctx) {
+		return nil, newError(ctx, CodeImpersonationForbidden, "this action is not allowed while impersonating a user")
+	}
+	return next(ctx)
+}
+
+// isMutation reports whether the operation being executed is a mutation
+func isMutation(ctx context.Context) bool {
+	return graphql.GetOperationContext(ctx).Operation.Operation == ast.Mutation
+}
@@ -0,0 +1,73 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"gin-service/internal/services"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"go.uber.org/zap"
+)
+
+// Error codes reported in the "code" extension. They mirror the error
+// field of REST error responses.
+const (
+	CodeValidation      = "validation_error"
+	CodeUnauthorized    = "unauthorized"
+	CodeForbidden       = "forbidden"
+	CodeUserNotFound    = "user_not_found"
+	CodeRegistration    = "registration_failed"
+	CodeAuthentication  = "authentication_failed"
+	CodeTokenGeneration = "token_generation_failed"
+	CodeUpdate          = "update_failed"
+	CodeConflict        = "conflict"
+	CodeInternal        = "internal_error"
+	CodeTwoFactor       = "two_factor_required"
+	CodeLoginThrottled  = "too_many_login_attempts"
+
+	CodeImpersonationForbidden = "impersonation_forbidden"
+)
+
+// newError creates a GraphQL error for the field being resolved
+func newError(ctx context.Context, code, message string) *gqlerror.Error {
+	return &gqlerror.Error{
+		Path:    graphql.GetPath(ctx),
+		Message: message,
+		Extensions: map[string]interface{}{
+			"code": code,
+		},
+	}
+}
+
+// newConflictError creates a GraphQL error for a username or email taken by
+// another user, naming the field in the "field" extension
+func newConflictError(ctx context.Context, conflict *services.ConflictError) *gqlerror.Error {
+	err := newError(ctx, CodeConflict, conflict.Error())
+	err.Extensions["field"] = conflict.Field
+	return err
+}
+
+// newThrottledError creates a GraphQL error for a login rejected by the
+// login throttle, with the seconds to wait in the "retryAfter" extension
+func newThrottledError(ctx context.Context, throttled *services.LoginThrottledError) *gqlerror.Error {
+	err := newError(ctx, CodeLoginThrottled, "Too many login attempts. Please try again later.")
+	err.Extensions["retryAfter"] = int(math.Ceil(throttled.RetryAfter.Seconds()))
+	return err
+}
+
+// errorPresenter passes GraphQL errors through and hides the details of
+// unexpected errors, as the REST handlers do
+func errorPresenter(logger *zap.Logger) graphql.ErrorPresenterFunc {
+	return func(ctx context.Context, err error) *gqlerror.Error {
+		var gqlErr *gqlerror.Error
+		if errors.As(err, &gqlErr) {
+			return graphql.DefaultErrorPresenter(ctx, err)
+		}
+
+		logger.Error("GraphQL resolver failed", zap.Error(err))
+		return newError(ctx, CodeInternal, "An internal error occurred")
+	}
+}
@@ -0,0 +1,100 @@
+package graph
+
+//go:generate go run github.com/99designs/gqlgen generate
+
+import (
+	"context"
+	"errors"
+
+	"gin-service/internal/api/middleware"
+	"gin-service/internal/graph/generated"
+	"gin-service/internal/models"
+	"gin-service/internal/services"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
+)
+
+// Resolver resolves GraphQL operations by delegating to the services used
+// by the REST handlers
+type Resolver struct {
+	userService services.UserServiceInterface
+	jwtService  middleware.JWTServiceInterface
+	logger      *zap.Logger
+}
+
+// NewServer creates the GraphQL server. Introspection is enabled only when
+// introspection is set.
+func NewServer(userService services.UserServiceInterface, jwtService middleware.JWTServiceInterface, introspection bool, logger *zap.Logger) *handler.Server {
+	resolver := &Resolver{
+		userService: userService,
+		jwtService:  jwtService,
+		logger:      logger,
+	}
+
+	schema := generated.NewExecutableSchema(generated.Config{
+		Resolvers: resolver,
+		Directives: generated.DirectiveRoot{
+			Auth:  authDirective,
+			Admin: adminDirective,
+		},
+	})
+
+	srv := handler.New(schema)
+	srv.AddTransport(transport.GET{})
+	srv.AddTransport(transport.POST{})
+	if introspection {
+		srv.Use(extension.Introspection{})
+	}
+	srv.SetErrorPresenter(errorPresenter(logger))
+
+	return srv
+}
+
+// users returns the user service running its queries under ctx, the
+// request context, so they stop when the request times out
+func (r *Resolver) users(ctx context.Context) services.UserServiceInterface {
+	return r.userService.WithContext(ctx)
+}
+
+// user looks up a user by ID, reporting a missing user as an error
+func (r *Resolver) user(ctx context.Context, id int) (*models.User, error) {
+	user, err := r.users(ctx).GetByID(id)
+	if err != nil {
+		r.logger.Error("Failed to get user", zap.Error(err), zap.Int("user_id", id))
+		return nil, newError(ctx, CodeInternal, "Failed to retrieve user")
+	}
+	if user == nil {
+		return nil, newError(ctx, CodeUserNotFound, "User not found")
+	}
+	return user, nil
+}
+
+// update validates and applies an update to a user
+func (r *Resolver) update(ctx context.Context, id int, req *models.UpdateUserRequest) (*models.User, error) {
+	if err := binding.Validator.ValidateStruct(req); err != nil {
+		return nil, newError(ctx, CodeValidation, err.Error())
+	}
+
+	user, err := r.users(ctx).Update(id, req)
+	if err != nil {
+		r.logger.Error("Failed to update user", zap.Error(err), zap.Int("user_id", id))
+		var conflict *services.ConflictError
+		var invalid *models.PasswordError
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			return nil, newError(ctx, CodeUserNotFound, "User not found")
+		case errors.As(err, &conflict):
+			return nil, newConflictError(ctx, conflict)
+		case errors.As(err, &invalid):
+			return nil, newError(ctx, CodeValidation, invalid.Error())
+		}
+		return nil, err
+	}
+
+	r.logger.Info("User updated", zap.Int("user_id", id))
+	return user, nil
+}
@@ -0,0 +1,362 @@
+package graph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gin-service/internal/api/middleware"
+	"gin-service/internal/config"
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+	"gin-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// graphQLResponse is the response to a GraphQL request
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+// testServer serves /graphql the way the router does, backed by an in-memory store
+type testServer struct {
+	router      *gin.Engine
+	userService *services.UserService
+	jwtService  *middleware.JWTService
+}
+
+func newTestServer() *testServer {
+	cfg := &config.Config{JWT: config.JWTConfig{Secret: "test-secret", ExpirationTime: config.Duration(time.Hour), Issuer: "test"}}
+	userService := services.NewUserService(repository.NewMemoryStore(), zap.NewNop())
+	jwtService := middleware.NewJWTService(cfg, zap.NewNop())
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.MaxSizeMiddleware(4096))
+	router.POST("/graphql", middleware.OptionalAuthMiddleware(jwtService), Handler(NewServer(userService, jwtService, false, zap.NewNop())))
+
+	return &testServer{router: router, userService: userService, jwtService: jwtService}
+}
+
+// do runs a GraphQL operation, authenticated as user when user is set
+func (s *testServer) do(t *testing.T, user *models.User, query string, variables map[string]interface{}) graphQLResponse {
+	var token string
+	if user != nil {
+		var err error
+		token, err = s.jwtService.GenerateToken(user)
+		require.NoError(t, err)
+	}
+	return s.doWithToken(t, token, query, variables)
+}
+
+// doWithToken runs a GraphQL operation, authenticated with token when it is
+// set
+func (s *testServer) doWithToken(t *testing.T, token, query string, variables map[string]interface{}) graphQLResponse {
+	body, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	req, _ := http.NewRequest("POST", "/graphql", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "192.0.2.1:1234"
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response graphQLResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func (s *testServer) createUser(t *testing.T, username string, admin bool) *models.User {
+	req := &models.CreateUserRequest{
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "password123",
+	}
+	var user *models.User
+	var err error
+	if admin {
+		user, err = s.userService.CreateAdmin(req, true)
+	} else {
+		user, err = s.userService.Create(req)
+	}
+	require.NoError(t, err)
+	return user
+}
+
+const registerMutation = `mutation($input: RegisterInput!) {
+	register(input: $input) { id username email isAdmin }
+}`
+
+func TestGraphQL_Register(t *testing.T) {
+	s := newTestServer()
+	input := map[string]interface{}{
+		"input": map[string]interface{}{
+			"username": "testuser",
+			"email":    "test@example.com",
+			"password": "password123",
+		},
+	}
+
+	response := s.do(t, nil, registerMutation, input)
+	require.Empty(t, response.Errors)
+
+	var data struct {
+		Register struct {
+			ID       int    `json:"id"`
+			Username string `json:"username"`
+			IsAdmin  bool   `json:"isAdmin"`
+		} `json:"register"`
+	}
+	require.NoError(t, json.Unmarshal(response.Data, &data))
+	assert.NotZero(t, data.Register.ID)
+	assert.Equal(t, "testuser", data.Register.Username)
+	assert.False(t, data.Register.IsAdmin)
+
+	// Registering the same user again fails with the REST error code and
+	// the conflicting field
+	response = s.do(t, nil, registerMutation, input)
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, CodeConflict, response.Errors[0].Extensions["code"])
+	assert.Equal(t, "username", response.Errors[0].Extensions["field"])
+	assert.Equal(t, "username already exists", response.Errors[0].Message)
+}
+
+func TestGraphQL_Register_ValidationError(t *testing.T) {
+	s := newTestServer()
+
+	response := s.do(t, nil, registerMutation, map[string]interface{}{
+		"input": map[string]interface{}{
+			"username": "ab",
+			"email":    "not-an-email",
+			"password": "short",
+		},
+	})
+
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, CodeValidation, response.Errors[0].Extensions["code"])
+}
+
+func TestGraphQL_Login(t *testing.T) {
+	s := newTestServer()
+	s.createUser(t, "testuser", false)
+
+	query := `mutation($input: LoginInput!) { login(input: $input) { token user { username } } }`
+
+	response := s.do(t, nil, query, map[string]interface{}{
+		"input": map[string]interface{}{"username": "testuser", "password": "password123"},
+	})
+	require.Empty(t, response.Errors)
+
+	var data struct {
+		Login struct {
+			Token string `json:"token"`
+		} `json:"login"`
+	}
+	require.NoError(t, json.Unmarshal(response.Data, &data))
+	claims, err := s.jwtService.ValidateToken(data.Login.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", claims.Username)
+
+	response = s.do(t, nil, query, map[string]interface{}{
+		"input": map[string]interface{}{"username": "testuser", "password": "wrong-password"},
+	})
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, CodeAuthentication, response.Errors[0].Extensions["code"])
+}
+
+func TestGraphQL_Login_Throttled(t *testing.T) {
+	s := newTestServer()
+	s.userService.SetLoginThrottle(middleware.NewSlidingLog(3, time.Minute))
+	s.createUser(t, "testuser", false)
+
+	query := `mutation($input: LoginInput!) { login(input: $input) { token } }`
+	login := func(password string) graphQLResponse {
+		return s.do(t, nil, query, map[string]interface{}{
+			"input": map[string]interface{}{"username": "testuser", "password": password},
+		})
+	}
+
+	for i := 0; i < 3; i++ {
+		response := login("wrong-password")
+		require.Len(t, response.Errors, 1)
+		assert.Equal(t, CodeAuthentication, response.Errors[0].Extensions["code"])
+	}
+
+	// Further attempts from the IP are rejected, even with the right password
+	response := login("password123")
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, CodeLoginThrottled, response.Errors[0].Extensions["code"])
+	assert.EqualValues(t, 60, response.Errors[0].Extensions["retryAfter"])
+}
+
+func TestGraphQL_Me_RequiresAuth(t *testing.T) {
+	s := newTestServer()
+	user := s.createUser(t, "testuser", false)
+
+	response := s.do(t, nil, `{ me { username } }`, nil)
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, CodeUnauthorized, response.Errors[0].Extensions["code"])
+
+	response = s.do(t, user, `{ me { username } }`, nil)
+	require.Empty(t, response.Errors)
+	assert.JSONEq(t, `{"me": {"username": "testuser"}}`, string(response.Data))
+}
+
+func TestGraphQL_Users_RequiresAdmin(t *testing.T) {
+	s := newTestServer()
+	user := s.createUser(t, "testuser", false)
+	admin := s.createUser(t, "admin", true)
+
+	query := `{ users(filter: {isAdmin: false}, pagination: {page: 1, limit: 5}) {
+		data { username }
+		pagination { total }
+	} }`
+
+	response := s.do(t, user, query, nil)
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, CodeForbidden, response.Errors[0].Extensions["code"])
+
+	response = s.do(t, admin, query, nil)
+	require.Empty(t, response.Errors)
+	assert.JSONEq(t, `{"users": {"data": [{"username": "testuser"}], "pagination": {"total": 1}}}`, string(response.Data))
+}
+
+func TestGraphQL_UpdateUser(t *testing.T) {
+	s := newTestServer()
+	s.createUser(t, "testuser", false)
+	admin := s.createUser(t, "admin", true)
+
+	query := `mutation($id: Int!, $input: UpdateUserInput!) { updateUser(id: $id, input: $input) { fullName } }`
+
+	response := s.do(t, admin, query, map[string]interface{}{
+		"id":    1,
+		"input": map[string]interface{}{"fullName": "Test User"},
+	})
+	require.Empty(t, response.Errors)
+	assert.JSONEq(t, `{"updateUser": {"fullName": "Test User"}}`, string(response.Data))
+
+	// Leaving fullName out keeps it, while null clears it
+	response = s.do(t, admin, query, map[string]interface{}{
+		"id":    1,
+		"input": map[string]interface{}{"isActive": true},
+	})
+	require.Empty(t, response.Errors)
+	assert.JSONEq(t, `{"updateUser": {"fullName": "Test User"}}`, string(response.Data))
+
+	response = s.do(t, admin, query, map[string]interface{}{
+		"id":    1,
+		"input": map[string]interface{}{"fullName": nil},
+	})
+	require.Empty(t, response.Errors)
+	assert.JSONEq(t, `{"updateUser": {"fullName": null}}`, string(response.Data))
+
+	response = s.do(t, admin, query, map[string]interface{}{
+		"id":    999,
+		"input": map[string]interface{}{"fullName": "Nobody"},
+	})
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, CodeUserNotFound, response.Errors[0].Extensions["code"])
+}
+
+func TestGraphQL_UpdateProfile_ForbiddenToImpersonation(t *testing.T) {
+	s := newTestServer()
+	user := s.createUser(t, "testuser", false)
+	admin := s.createUser(t, "admin", true)
+
+	token, err := s.jwtService.GenerateImpersonationToken(user, admin.ID)
+	require.NoError(t, err)
+
+	query := `mutation($input: UpdateUserInput!) { updateProfile(input: $input) { email } }`
+	response := s.doWithToken(t, token, query, map[string]interface{}{
+		"input": map[string]interface{}{"email": "admin-controlled@example.com"},
+	})
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, CodeImpersonationForbidden, response.Errors[0].Extensions["code"])
+
+	user, err = s.userService.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Nil(t, user.PendingEmail)
+}
+
+func TestGraphQL_UpdateUser_ForbiddenToImpersonation(t *testing.T) {
+	s := newTestServer()
+	user := s.createUser(t, "testuser", false)
+	admin := s.createUser(t, "admin", true)
+	other := s.createUser(t, "other", true)
+
+	// An admin impersonating another admin carries admin claims
+	token, err := s.jwtService.GenerateImpersonationToken(other, admin.ID)
+	require.NoError(t, err)
+
+	query := `mutation($id: Int!, $input: UpdateUserInput!) { updateUser(id: $id, input: $input) { isActive } }`
+	response := s.doWithToken(t, token, query, map[string]interface{}{
+		"id":    user.ID,
+		"input": map[string]interface{}{"isActive": false},
+	})
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, CodeImpersonationForbidden, response.Errors[0].Extensions["code"])
+
+	user, err = s.userService.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.True(t, user.IsActive)
+
+	// Admin queries stay available, as on the REST API
+	response = s.doWithToken(t, token, `{ users { data { id } } }`, nil)
+	assert.Empty(t, response.Errors)
+}
+
+func TestGraphQL_UpdateProfile_CannotDeactivate(t *testing.T) {
+	s := newTestServer()
+	user := s.createUser(t, "testuser", false)
+
+	query := `mutation($input: UpdateUserInput!) { updateProfile(input: $input) { fullName } }`
+	response := s.do(t, user, query, map[string]interface{}{
+		"input": map[string]interface{}{"fullName": "Test User", "isActive": false},
+	})
+	require.Len(t, response.Errors, 1)
+	assert.Equal(t, CodeForbidden, response.Errors[0].Extensions["code"])
+
+	user, err := s.userService.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.True(t, user.IsActive)
+	assert.Nil(t, user.FullName, "nothing is applied")
+}
+
+func TestErrorPresenter_HidesUnexpectedErrors(t *testing.T) {
+	present := errorPresenter(zap.NewNop())
+
+	err := present(context.Background(), errors.New("pq: connection refused"))
+
+	assert.Equal(t, "An internal error occurred", err.Message)
+	assert.Equal(t, CodeInternal, err.Extensions["code"])
+}
+
+func TestGraphQL_BodyTooLarge(t *testing.T) {
+	server := newTestServer()
+
+	body, _ := json.Marshal(map[string]interface{}{"query": "{ me { id } }" + strings.Repeat(" ", 4096)})
+	req, _ := http.NewRequest("POST", "/graphql", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "request_too_large")
+}
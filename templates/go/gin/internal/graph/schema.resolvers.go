@@ -0,0 +1,149 @@
+package graph
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.43
+
+import (
+	"context"
+	"errors"
+	"gin-service/internal/database"
+	"gin-service/internal/graph/generated"
+	"gin-service/internal/graph/model"
+	"gin-service/internal/models"
+	"gin-service/internal/services"
+
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
+)
+
+// Register is the resolver for the register field.
+func (r *mutationResolver) Register(ctx context.Context, input models.CreateUserRequest) (*models.User, error) {
+	if err := binding.Validator.ValidateStruct(&input); err != nil {
+		return nil, newError(ctx, CodeValidation, err.Error())
+	}
+
+	user, err := r.users(ctx).Create(&input)
+	if err != nil {
+		r.logger.Error("Failed to create user", zap.Error(err))
+		var conflict *services.ConflictError
+		if errors.As(err, &conflict) {
+			return nil, newConflictError(ctx, conflict)
+		}
+		var invalid *models.PasswordError
+		if errors.As(err, &invalid) {
+			return nil, newError(ctx, CodeValidation, invalid.Error())
+		}
+		return nil, err
+	}
+
+	r.logger.Info("User registered successfully", zap.Int("user_id", user.ID))
+	return user, nil
+}
+
+// Login is the resolver for the login field.
+func (r *mutationResolver) Login(ctx context.Context, input models.LoginRequest) (*model.AuthPayload, error) {
+	if err := binding.Validator.ValidateStruct(&input); err != nil {
+		return nil, newError(ctx, CodeValidation, err.Error())
+	}
+
+	user, err := r.users(ctx).Authenticate(input.Username, models.IdentifierAuto, input.Password)
+	if err != nil {
+		var throttled *services.LoginThrottledError
+		if errors.As(err, &throttled) {
+			return nil, newThrottledError(ctx, throttled)
+		}
+		r.logger.Warn("Authentication failed", zap.Error(err), zap.String("username", input.Username))
+		return nil, newError(ctx, CodeAuthentication, "Invalid credentials")
+	}
+
+	// The second factor is only supported by the REST login
+	if user.TOTPEnabled {
+		return nil, newError(ctx, CodeTwoFactor, "Two-factor authentication required, log in with POST /api/v1/auth/login")
+	}
+
+	token, err := r.jwtService.GenerateToken(user)
+	if err != nil {
+		r.logger.Error("Failed to generate token", zap.Error(err))
+		return nil, newError(ctx, CodeTokenGeneration, "Failed to generate authentication token")
+	}
+
+	r.logger.Info("User logged in successfully", zap.Int("user_id", user.ID))
+	return &model.AuthPayload{User: user, Token: token}, nil
+}
+
+// UpdateProfile is the resolver for the updateProfile field.
+func (r *mutationResolver) UpdateProfile(ctx context.Context, input models.UpdateUserRequest) (*models.User, error) {
+	// As on the REST API, an admin impersonating the user can't change the
+	// email or password and take the account over
+	if _, impersonating := services.ImpersonatorFromContext(ctx); impersonating {
+		return nil, newError(ctx, CodeImpersonationForbidden, "this action is not allowed while impersonating a user")
+	}
+	// Users can't deactivate, and lock out, themselves
+	if input.IsActive != nil {
+		return nil, newError(ctx, CodeForbidden, "isActive can only be changed by admins, with updateUser")
+	}
+
+	claims, _ := ClaimsFromContext(ctx)
+	return r.update(ctx, claims.UserID, &input)
+}
+
+// UpdateUser is the resolver for the updateUser field.
+func (r *mutationResolver) UpdateUser(ctx context.Context, id int, input models.UpdateUserRequest) (*models.User, error) {
+	return r.update(ctx, id, &input)
+}
+
+// Me is the resolver for the me field.
+func (r *queryResolver) Me(ctx context.Context) (*models.User, error) {
+	claims, _ := ClaimsFromContext(ctx)
+	return r.user(ctx, claims.UserID)
+}
+
+// User is the resolver for the user field.
+func (r *queryResolver) User(ctx context.Context, id int) (*models.User, error) {
+	return r.user(ctx, id)
+}
+
+// Users is the resolver for the users field.
+func (r *queryResolver) Users(ctx context.Context, filter *models.UserFilter, pagination *model.PaginationInput) (*model.UserPage, error) {
+	defaultLimit, _ := database.PaginationLimits()
+	paginate := &database.Paginate{Page: 1, Limit: defaultLimit}
+	if pagination != nil {
+		if pagination.Page != nil {
+			paginate.Page = *pagination.Page
+		}
+		if pagination.Limit != nil {
+			paginate.Limit = *pagination.Limit
+		}
+	}
+
+	users, err := r.users(ctx).List(filter, paginate)
+	if err != nil {
+		r.logger.Error("Failed to list users", zap.Error(err))
+		return nil, newError(ctx, CodeInternal, "Failed to retrieve users")
+	}
+
+	return &model.UserPage{Data: users, Pagination: paginate}, nil
+}
+
+// FullName is the resolver for the fullName field.
+func (r *updateUserInputResolver) FullName(ctx context.Context, obj *models.UpdateUserRequest, data *string) error {
+	// Only called for fields present in the input, so nil is an explicit null
+	obj.FullName = models.NullableFromPtr(data)
+	return nil
+}
+
+// Mutation returns generated.MutationResolver implementation.
+func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResolver{r} }
+
+// Query returns generated.QueryResolver implementation.
+func (r *Resolver) Query() generated.QueryResolver { return &queryResolver{r} }
+
+// UpdateUserInput returns generated.UpdateUserInputResolver implementation.
+func (r *Resolver) UpdateUserInput() generated.UpdateUserInputResolver {
+	return &updateUserInputResolver{r}
+}
+
+type mutationResolver struct{ *Resolver }
+type queryResolver struct{ *Resolver }
+type updateUserInputResolver struct{ *Resolver }
@@ -0,0 +1,30 @@
+package httpserver
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// RequestCounter is an http.Handler counting the requests in flight through
+// the handler it wraps, so that shutdown can report how many it drained
+type RequestCounter struct {
+	handler  http.Handler
+	inFlight atomic.Int64
+}
+
+// CountRequests wraps handler in a RequestCounter
+func CountRequests(handler http.Handler) *RequestCounter {
+	return &RequestCounter{handler: handler}
+}
+
+// ServeHTTP serves the request with the wrapped handler
+func (c *RequestCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+	c.handler.ServeHTTP(w, r)
+}
+
+// InFlight returns how many requests are being served
+func (c *RequestCounter) InFlight() int64 {
+	return c.inFlight.Load()
+}
@@ -0,0 +1,34 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestCounter_CountsRequestsInFlight(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	counter := CountRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	}))
+
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			counter.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			done <- struct{}{}
+		}()
+	}
+	<-entered
+	<-entered
+	assert.Equal(t, int64(2), counter.InFlight())
+
+	close(release)
+	<-done
+	<-done
+	assert.Equal(t, int64(0), counter.InFlight())
+}
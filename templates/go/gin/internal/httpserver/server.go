@@ -0,0 +1,25 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"gin-service/internal/config"
+)
+
+// New creates the HTTP server for handler with the timeouts of cfg.
+//
+// ReadHeaderTimeout bounds how long a client may take to send the request
+// headers, before any handler runs, so that slow-loris clients trickling
+// headers can't hold connections open. ReadTimeout bounds reading the whole
+// request, headers and body, and so must be at least ReadHeaderTimeout; it
+// also caps how long handlers may take to read large uploads.
+func New(cfg config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout.Duration(),
+		ReadTimeout:       cfg.ReadTimeout.Duration(),
+		WriteTimeout:      cfg.WriteTimeout.Duration(),
+		IdleTimeout:       cfg.IdleTimeout.Duration(),
+	}
+}
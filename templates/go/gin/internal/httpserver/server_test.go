@@ -0,0 +1,56 @@
+package httpserver
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gin-service/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew_SetsTimeouts(t *testing.T) {
+	server := New(config.ServerConfig{
+		Port:              "8080",
+		ReadHeaderTimeout: config.Duration(5 * time.Second),
+		ReadTimeout:       config.Duration(10 * time.Second),
+		WriteTimeout:      config.Duration(10 * time.Second),
+		IdleTimeout:       config.Duration(2 * time.Minute),
+	}, http.NotFoundHandler())
+
+	assert.Equal(t, ":8080", server.Addr)
+	assert.Equal(t, 5*time.Second, server.ReadHeaderTimeout)
+	assert.Equal(t, 10*time.Second, server.ReadTimeout)
+	assert.Equal(t, 10*time.Second, server.WriteTimeout)
+	assert.Equal(t, 2*time.Minute, server.IdleTimeout)
+}
+
+func TestNew_ClosesSlowHeaderConnections(t *testing.T) {
+	server := New(config.ServerConfig{
+		ReadHeaderTimeout: config.Duration(100 * time.Millisecond),
+		ReadTimeout:       config.Duration(time.Minute),
+	}, http.NotFoundHandler())
+	ts := httptest.NewUnstartedServer(server.Handler)
+	ts.Config = server
+	ts.Start()
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Start the headers and never finish them
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n"))
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	start := time.Now()
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err, "the server closes the connection")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
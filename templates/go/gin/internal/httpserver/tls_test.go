@@ -0,0 +1,162 @@
+package httpserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gin-service/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfSignedCertificate(t *testing.T) {
+	cert, err := SelfSignedCertificate([]string{"localhost", "127.0.0.1"}, time.Hour)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.NoError(t, leaf.VerifyHostname("localhost"))
+	assert.NoError(t, leaf.VerifyHostname("127.0.0.1"))
+	assert.Error(t, leaf.VerifyHostname("example.com"))
+	assert.WithinDuration(t, time.Now().Add(time.Hour), leaf.NotAfter, time.Minute)
+}
+
+func TestNewTLSConfig_ServesHTTP2(t *testing.T) {
+	tlsConfig, reloader, err := NewTLSConfig(config.TLSConfig{Enabled: true, SelfSigned: true})
+	require.NoError(t, err)
+	assert.Nil(t, reloader)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	}))
+	server.TLS = tlsConfig
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/2.0", string(body))
+}
+
+// writeCertificate writes a new self-signed certificate and its key to
+// certFile and keyFile, returning the certificate
+func writeCertificate(t *testing.T, certFile, keyFile string) tls.Certificate {
+	cert, err := SelfSignedCertificate([]string{"localhost", "127.0.0.1"}, time.Hour)
+	require.NoError(t, err)
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600))
+	return cert
+}
+
+func TestNewTLSConfig_LoadsCertificateFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	cert := writeCertificate(t, certFile, keyFile)
+
+	tlsConfig, reloader, err := NewTLSConfig(config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile})
+
+	require.NoError(t, err)
+	require.NotNil(t, reloader)
+	served, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, cert.Certificate[0], served.Certificate[0])
+
+	// A missing or broken certificate fails at startup
+	_, _, err = NewTLSConfig(config.TLSConfig{Enabled: true, CertFile: filepath.Join(dir, "missing.crt"), KeyFile: keyFile})
+	assert.ErrorContains(t, err, "failed to load TLS certificate")
+}
+
+func TestCertReloader_ReloadsServedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	first := writeCertificate(t, certFile, keyFile)
+
+	tlsConfig, reloader, err := NewTLSConfig(config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+
+	// A plain http.Server, as httptest would add its own certificate
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "ok")
+		}),
+		TLSConfig: tlsConfig,
+	}
+	go func() { _ = server.ServeTLS(listener, "", "") }()
+	defer server.Close()
+	url := "https://" + listener.Addr().String()
+
+	// servedCertificate makes a TLS request on a new connection, returning
+	// the certificate the server presented
+	servedCertificate := func() []byte {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		}}
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(body))
+		return resp.TLS.PeerCertificates[0].Raw
+	}
+
+	assert.Equal(t, first.Certificate[0], servedCertificate())
+
+	second := writeCertificate(t, certFile, keyFile)
+	require.NoError(t, reloader.Reload())
+	assert.Equal(t, second.Certificate[0], servedCertificate())
+
+	// A broken certificate is refused and the current one kept
+	require.NoError(t, os.WriteFile(certFile, []byte("not a certificate"), 0o600))
+	assert.Error(t, reloader.Reload())
+	assert.Equal(t, second.Certificate[0], servedCertificate())
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		host      string
+		httpsPort string
+		want      string
+	}{
+		{"example.com", "443", "https://example.com/api/v1/users?page=2"},
+		{"example.com:80", "443", "https://example.com/api/v1/users?page=2"},
+		{"localhost:8081", "8443", "https://localhost:8443/api/v1/users?page=2"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://"+tt.host+"/api/v1/users?page=2", nil)
+		w := httptest.NewRecorder()
+		RedirectToHTTPS(tt.httpsPort).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusPermanentRedirect, w.Code, tt.host)
+		assert.Equal(t, tt.want, w.Header().Get("Location"), tt.host)
+	}
+}
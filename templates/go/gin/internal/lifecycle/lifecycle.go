@@ -0,0 +1,61 @@
+package lifecycle
+
+import "sync/atomic"
+
+// Phase is a stage in the life of the service process
+type Phase int32
+
+// The phases of the service, in order. Only PhaseReady serves traffic.
+const (
+	// PhaseStarting is from process start until migrations run
+	PhaseStarting Phase = iota
+	// PhaseMigrating is while migrations run and the server is set up
+	PhaseMigrating
+	// PhaseReady is once the server listens, until shutdown begins
+	PhaseReady
+	// PhaseDraining is from the start of graceful shutdown, so load
+	// balancers stop sending traffic before connections close
+	PhaseDraining
+)
+
+// String returns the name of the phase
+func (p Phase) String() string {
+	switch p {
+	case PhaseStarting:
+		return "starting"
+	case PhaseMigrating:
+		return "migrating"
+	case PhaseReady:
+		return "ready"
+	case PhaseDraining:
+		return "draining"
+	default:
+		return "unknown"
+	}
+}
+
+// State holds the current phase of the service. It is safe for concurrent
+// use.
+type State struct {
+	phase atomic.Int32
+}
+
+// NewState returns a state in PhaseStarting
+func NewState() *State {
+	return &State{}
+}
+
+// Set moves the service to phase
+func (s *State) Set(phase Phase) {
+	s.phase.Store(int32(phase))
+}
+
+// Phase returns the current phase
+func (s *State) Phase() Phase {
+	return Phase(s.phase.Load())
+}
+
+// Ready reports whether the service is in PhaseReady
+func (s *State) Ready() bool {
+	return s.Phase() == PhaseReady
+}
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"fmt"
+
+	"gin-service/internal/config"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Log formats
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// New builds the service logger. Production starts from zap's production
+// settings and other environments from its development settings; an explicit
+// cfg.Format then overrides the encoding, so either can be had anywhere.
+func New(cfg config.LogConfig, environment string) (*zap.Logger, error) {
+	return NewAtLevel(cfg, environment, zap.NewAtomicLevelAt(ParseLevel(cfg.Level)))
+}
+
+// NewAtLevel builds the service logger like New, logging at level instead of
+// cfg.Level, so that the level can be changed while the logger is in use
+func NewAtLevel(cfg config.LogConfig, environment string, level zap.AtomicLevel) (*zap.Logger, error) {
+	zapConfig := newConfig(cfg, environment)
+	zapConfig.Level = level
+	_, files := splitOutputs(cfg.Output)
+	options, err := fileOptions(zapConfig, files, cfg.Rotation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build logger: %w", err)
+	}
+	logger, err := zapConfig.Build(append(options, samplingOptions(cfg, environment)...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build logger: %w", err)
+	}
+	return logger, nil
+}
+
+// newConfig returns the zap configuration for cfg in environment. zap's own
+// sampling is off, as it would sample errors too; see samplingOptions.
+func newConfig(cfg config.LogConfig, environment string) zap.Config {
+	var zapConfig zap.Config
+	if environment == "production" {
+		zapConfig = zap.NewProductionConfig()
+		zapConfig.Sampling = nil
+	} else {
+		zapConfig = zap.NewDevelopmentConfig()
+	}
+
+	zapConfig.Level = zap.NewAtomicLevelAt(ParseLevel(cfg.Level))
+
+	switch cfg.Format {
+	case FormatJSON:
+		zapConfig.Encoding = FormatJSON
+		zapConfig.EncoderConfig = zap.NewProductionEncoderConfig()
+	case FormatConsole:
+		zapConfig.Encoding = FormatConsole
+		zapConfig.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	}
+
+	// Files are written by fileOptions, with rotation
+	if len(cfg.Output) > 0 {
+		zapConfig.OutputPaths, _ = splitOutputs(cfg.Output)
+	}
+
+	return zapConfig
+}
+
+// ParseLevel parses a log level name, defaulting to info
+func ParseLevel(level string) zapcore.Level {
+	switch level {
+	case "debug":
+		return zap.DebugLevel
+	case "info":
+		return zap.InfoLevel
+	case "warn":
+		return zap.WarnLevel
+	case "error":
+		return zap.ErrorLevel
+	default:
+		return zap.InfoLevel
+	}
+}
@@ -0,0 +1,184 @@
+package logging
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gin-service/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNewConfig_FormatAndOutput(t *testing.T) {
+	for _, environment := range []string{"production", "development"} {
+		for _, format := range []string{FormatJSON, FormatConsole} {
+			for _, output := range []string{"stdout", "stderr"} {
+				zapConfig := newConfig(config.LogConfig{Format: format, Output: []string{output}}, environment)
+
+				name := environment + "/" + format + "/" + output
+				assert.Equal(t, format, zapConfig.Encoding, name)
+				assert.Equal(t, []string{output}, zapConfig.OutputPaths, name)
+			}
+		}
+	}
+
+	// Files are left to fileOptions
+	zapConfig := newConfig(config.LogConfig{Output: []string{"stdout", "/var/log/gin-service.log"}}, "production")
+	assert.Equal(t, []string{"stdout"}, zapConfig.OutputPaths)
+	zapConfig = newConfig(config.LogConfig{Output: []string{"/var/log/gin-service.log"}}, "production")
+	assert.Empty(t, zapConfig.OutputPaths)
+}
+
+func TestNewConfig_FormatDefaultsToEnvironment(t *testing.T) {
+	assert.Equal(t, FormatJSON, newConfig(config.LogConfig{}, "production").Encoding)
+	assert.Equal(t, FormatConsole, newConfig(config.LogConfig{}, "development").Encoding)
+
+	// zap's default output is kept
+	assert.Equal(t, []string{"stderr"}, newConfig(config.LogConfig{}, "production").OutputPaths)
+}
+
+func TestSamplingOptions(t *testing.T) {
+	sampling := config.LogSamplingConfig{Enabled: true, Initial: 100, Thereafter: 100}
+	assert.Len(t, samplingOptions(config.LogConfig{Sampling: sampling}, "production"), 1)
+	assert.Empty(t, samplingOptions(config.LogConfig{}, "production"))
+
+	// Development never samples
+	assert.Empty(t, samplingOptions(config.LogConfig{Sampling: sampling}, "development"))
+
+	// zap's sampler, which would drop errors too, is never used
+	assert.Nil(t, newConfig(config.LogConfig{Sampling: sampling}, "production").Sampling)
+}
+
+func TestNewSampledCore(t *testing.T) {
+	observed, logs := observer.New(zap.DebugLevel)
+	logger := zap.New(newSampledCore(observed, config.LogSamplingConfig{Enabled: true, Initial: 2, Thereafter: 5})).
+		With(zap.String("service", "gin-service"))
+
+	for i := 0; i < 12; i++ {
+		logger.Info("request")
+		logger.Warn("slow request")
+		logger.Error("failed request")
+	}
+
+	// The first 2 info entries, then every 5th of the rest: the 7th and 12th
+	assert.Equal(t, 4, logs.FilterMessage("request").Len())
+	assert.Equal(t, 12, logs.FilterMessage("slow request").Len())
+	assert.Equal(t, 12, logs.FilterMessage("failed request").Len())
+	assert.Equal(t, "gin-service", logs.All()[0].ContextMap()["service"])
+}
+
+// logToFile logs one entry with a logger built for cfg, writing to a
+// temporary file, and returns the file's contents
+func logToFile(t *testing.T, cfg config.LogConfig, environment string) string {
+	path := filepath.Join(t.TempDir(), "service.log")
+	cfg.Output = []string{path}
+	logger, err := New(cfg, environment)
+	require.NoError(t, err)
+
+	logger.Info("hello", zap.String("key", "value"))
+	require.NoError(t, logger.Sync())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestNew_JSONInDevelopment(t *testing.T) {
+	output := logToFile(t, config.LogConfig{Format: FormatJSON}, "development")
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(output), &entry), output)
+	assert.Equal(t, "hello", entry["msg"])
+	assert.Equal(t, "value", entry["key"])
+	assert.Equal(t, "info", entry["level"])
+}
+
+func TestNew_ConsoleInProduction(t *testing.T) {
+	output := logToFile(t, config.LogConfig{Format: FormatConsole}, "production")
+
+	assert.False(t, json.Valid([]byte(output)), output)
+	assert.Contains(t, output, "\tINFO\t")
+	assert.True(t, strings.HasSuffix(strings.TrimSpace(output), `hello	{"key": "value"}`), output)
+}
+
+func TestNew_RespectsLevel(t *testing.T) {
+	output := logToFile(t, config.LogConfig{Level: "warn", Format: FormatJSON}, "production")
+
+	assert.Empty(t, output)
+}
+
+func TestNewAtLevel(t *testing.T) {
+	level := zap.NewAtomicLevelAt(zap.WarnLevel)
+	path := filepath.Join(t.TempDir(), "service.log")
+	logger, err := NewAtLevel(config.LogConfig{Level: "debug", Format: FormatJSON, Output: []string{path}}, "production", level)
+	require.NoError(t, err)
+
+	// The level given wins over the configured one, and can be changed
+	logger.Info("hidden")
+	level.SetLevel(zap.InfoLevel)
+	logger.Info("shown")
+	require.NoError(t, logger.Sync())
+
+	output, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(output), "hidden")
+	assert.Contains(t, string(output), "shown")
+}
+
+func TestNew_InvalidOutput(t *testing.T) {
+	_, err := New(config.LogConfig{Output: []string{filepath.Join(t.TempDir(), "missing", "service.log")}}, "production")
+
+	assert.Error(t, err)
+}
+
+func TestNew_SeveralFileOutputs(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "service.log"), filepath.Join(dir, "copy.log")}
+	logger, err := New(config.LogConfig{Format: FormatJSON, Output: paths}, "production")
+	require.NoError(t, err)
+
+	logger.Info("hello")
+	require.NoError(t, logger.Sync())
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `"msg":"hello"`, path)
+	}
+}
+
+func TestNew_RotatesFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "service.log")
+	logger, err := New(config.LogConfig{
+		Format:   FormatJSON,
+		Output:   []string{path},
+		Rotation: config.LogRotationConfig{MaxSize: 1},
+	}, "production")
+	require.NoError(t, err)
+
+	// 2MB of entries overflow the 1MB file
+	message := strings.Repeat("x", 1024)
+	for i := 0; i < 2048; i++ {
+		logger.Info(message)
+	}
+	require.NoError(t, logger.Sync())
+
+	rotated, err := filepath.Glob(filepath.Join(filepath.Dir(path), "service-*.log"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, rotated)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), int64(1024*1024))
+}
+
+func TestParseLevel(t *testing.T) {
+	assert.Equal(t, zap.DebugLevel, ParseLevel("debug"))
+	assert.Equal(t, zap.ErrorLevel, ParseLevel("error"))
+	assert.Equal(t, zap.InfoLevel, ParseLevel("verbose"))
+}
@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+
+	"gin-service/internal/config"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Standard stream outputs; any other output is a file path
+const (
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+)
+
+// splitOutputs splits outputs into standard streams, which zap writes to
+// itself, and file paths, which are rotated
+func splitOutputs(outputs []string) (streams, files []string) {
+	streams = []string{}
+	for _, output := range outputs {
+		if output == OutputStdout || output == OutputStderr {
+			streams = append(streams, output)
+		} else {
+			files = append(files, output)
+		}
+	}
+	return streams, files
+}
+
+// fileOptions returns the options writing the entries of zapConfig to each
+// of files as well, rotated as rotation says. The files are opened up front
+// so that a bad path fails the build rather than every write.
+func fileOptions(zapConfig zap.Config, files []string, rotation config.LogRotationConfig) ([]zap.Option, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	writers := make([]zapcore.WriteSyncer, 0, len(files))
+	for _, path := range files {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		file.Close()
+
+		writers = append(writers, zapcore.AddSync(&lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    rotation.MaxSize,
+			MaxAge:     rotation.MaxAge,
+			MaxBackups: rotation.MaxBackups,
+			Compress:   rotation.Compress,
+		}))
+	}
+
+	fileCore := zapcore.NewCore(newEncoder(zapConfig), zapcore.NewMultiWriteSyncer(writers...), zapConfig.Level)
+	return []zap.Option{zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, fileCore)
+	})}, nil
+}
+
+// newEncoder returns the encoder zap builds for zapConfig
+func newEncoder(zapConfig zap.Config) zapcore.Encoder {
+	if zapConfig.Encoding == FormatConsole {
+		return zapcore.NewConsoleEncoder(zapConfig.EncoderConfig)
+	}
+	return zapcore.NewJSONEncoder(zapConfig.EncoderConfig)
+}
@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"time"
+
+	"gin-service/internal/config"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// samplingOptions returns the options sampling the entries below warn level
+// as cfg.Sampling says. Only production samples.
+func samplingOptions(cfg config.LogConfig, environment string) []zap.Option {
+	if environment != "production" || !cfg.Sampling.Enabled {
+		return nil
+	}
+	return []zap.Option{zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return newSampledCore(core, cfg.Sampling)
+	})}
+}
+
+// newSampledCore samples the entries of core below warn level, passing
+// warnings and errors through so that failures are never dropped
+func newSampledCore(core zapcore.Core, sampling config.LogSamplingConfig) zapcore.Core {
+	below := levelFilterCore{Core: core, enabled: func(l zapcore.Level) bool { return l < zapcore.WarnLevel }}
+	above := levelFilterCore{Core: core, enabled: func(l zapcore.Level) bool { return l >= zapcore.WarnLevel }}
+	return zapcore.NewTee(
+		zapcore.NewSamplerWithOptions(below, time.Second, sampling.Initial, sampling.Thereafter),
+		above,
+	)
+}
+
+// levelFilterCore passes on only the entries of the levels enabled allows
+type levelFilterCore struct {
+	zapcore.Core
+	enabled func(zapcore.Level) bool
+}
+
+func (c levelFilterCore) Enabled(level zapcore.Level) bool {
+	return c.enabled(level) && c.Core.Enabled(level)
+}
+
+func (c levelFilterCore) With(fields []zapcore.Field) zapcore.Core {
+	return levelFilterCore{Core: c.Core.With(fields), enabled: c.enabled}
+}
+
+func (c levelFilterCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.enabled(entry.Level) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
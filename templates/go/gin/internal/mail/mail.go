@@ -0,0 +1,134 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// Message is an email with a plain-text body and an optional HTML
+// alternative
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+	// HTML is the HTML version of Body, sent alongside it when set
+	HTML string
+}
+
+// Sender delivers emails
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NopSender discards emails
+type NopSender struct{}
+
+// Send does nothing
+func (NopSender) Send(ctx context.Context, msg Message) error {
+	return nil
+}
+
+// LogSender logs emails instead of delivering them, for development and for
+// deployments without a mail server
+type LogSender struct {
+	logger *zap.Logger
+}
+
+// NewLogSender creates a sender that logs emails with logger
+func NewLogSender(logger *zap.Logger) *LogSender {
+	return &LogSender{logger: logger}
+}
+
+// Send logs the email. Only the plain-text body is logged.
+func (s *LogSender) Send(ctx context.Context, msg Message) error {
+	s.logger.Info("Email not delivered, mail driver is log",
+		zap.String("to", msg.To),
+		zap.String("subject", msg.Subject),
+		zap.String("body", msg.Body),
+		zap.Bool("html", msg.HTML != ""),
+	)
+	return nil
+}
+
+// SMTPSender delivers emails through an SMTP server
+type SMTPSender struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPSender creates a sender delivering through the server at host and
+// port, authenticating when username is set
+func NewSMTPSender(host string, port int, username, password, from string) *SMTPSender {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPSender{
+		addr: net.JoinHostPort(host, strconv.Itoa(port)),
+		auth: auth,
+		from: from,
+	}
+}
+
+// Send delivers the email
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	data, err := buildMessage(s.from, msg)
+	if err != nil {
+		return fmt.Errorf("failed to build email to %s: %w", msg.To, err)
+	}
+	if err := smtp.SendMail(s.addr, s.auth, s.from, []string{msg.To}, data); err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+// buildMessage formats msg as a MIME message from from. With an HTML
+// version the message is multipart/alternative, plain text first so that
+// clients prefer the HTML.
+func buildMessage(from string, msg Message) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTML == "" {
+		b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+		b.WriteString(msg.Body)
+		return b.Bytes(), nil
+	}
+
+	var parts bytes.Buffer
+	writer := multipart.NewWriter(&parts)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", msg.Body},
+		{"text/html; charset=UTF-8", msg.HTML},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", writer.Boundary())
+	b.Write(parts.Bytes())
+	return b.Bytes(), nil
+}
@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	msg, err := Render("email_change_confirm", "new@example.com", map[string]string{
+		"Username":  "<jdoe>",
+		"Link":      "https://example.com/confirm-email?token=abc",
+		"ExpiresAt": "Mon, 02 Jan 2006 15:04:05 UTC",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "new@example.com", msg.To)
+	assert.Equal(t, "Confirm your new email address", msg.Subject)
+	assert.Contains(t, msg.Body, "<jdoe>")
+	assert.Contains(t, msg.Body, "https://example.com/confirm-email?token=abc")
+	assert.Contains(t, msg.HTML, "&lt;jdoe&gt;")
+	assert.NotContains(t, msg.HTML, "<jdoe>")
+}
+
+func TestRender_UnknownTemplate(t *testing.T) {
+	_, err := Render("missing", "user@example.com", nil)
+	assert.Error(t, err)
+}
+
+func TestBuildMessage(t *testing.T) {
+	plain, err := buildMessage("from@example.com", Message{To: "to@example.com", Subject: "Hi", Body: "Hello"})
+	require.NoError(t, err)
+	assert.Contains(t, string(plain), "Content-Type: text/plain; charset=UTF-8\r\n\r\nHello")
+
+	alternative, err := buildMessage("from@example.com", Message{To: "to@example.com", Subject: "Hi", Body: "Hello", HTML: "<p>Hello</p>"})
+	require.NoError(t, err)
+	s := string(alternative)
+	assert.Contains(t, s, "Content-Type: multipart/alternative; boundary=")
+	assert.Less(t, strings.Index(s, "text/plain"), strings.Index(s, "text/html"))
+	assert.Contains(t, s, "<p>Hello</p>")
+}
@@ -0,0 +1,72 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// templateFS holds the email templates. The email NAME is rendered from
+// NAME.txt, a text template of the plain-text body that also defines the
+// "subject" template, and the optional NAME.html, an HTML template of the
+// HTML body.
+//
+//go:embed templates
+var templateFS embed.FS
+
+var (
+	textTemplates = map[string]*texttemplate.Template{}
+	htmlTemplates = map[string]*htmltemplate.Template{}
+)
+
+func init() {
+	files, err := fs.Glob(templateFS, "templates/*")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range files {
+		ext := path.Ext(file)
+		name := strings.TrimSuffix(path.Base(file), ext)
+		switch ext {
+		case ".txt":
+			textTemplates[name] = texttemplate.Must(texttemplate.ParseFS(templateFS, file))
+		case ".html":
+			htmlTemplates[name] = htmltemplate.Must(htmltemplate.ParseFS(templateFS, file))
+		}
+	}
+}
+
+// Render renders the email name to to with data
+func Render(name, to string, data interface{}) (Message, error) {
+	text, ok := textTemplates[name]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown email template %q", name)
+	}
+
+	var subject, body bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, fmt.Errorf("failed to render subject of %s: %w", name, err)
+	}
+	if err := text.Execute(&body, data); err != nil {
+		return Message{}, fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	msg := Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    body.String(),
+	}
+
+	if html, ok := htmlTemplates[name]; ok {
+		var b bytes.Buffer
+		if err := html.Execute(&b, data); err != nil {
+			return Message{}, fmt.Errorf("failed to render HTML of %s: %w", name, err)
+		}
+		msg.HTML = b.String()
+	}
+	return msg, nil
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Audit log actions
+const (
+	AuditActionUserCreated      = "user.created"
+	AuditActionTwoFactorEnabled = "user.2fa_enabled"
+	AuditActionRecoveryCodeUsed = "user.recovery_code_used"
+	AuditActionUserImpersonated = "user.impersonated"
+	AuditActionUserPromoted     = "user.promoted"
+	AuditActionUserDemoted      = "user.demoted"
+	AuditActionStatusChanged    = "user.status_changed"
+
+	AuditActionEmailChangeRequested = "user.email_change_requested"
+	AuditActionEmailChanged         = "user.email_changed"
+
+	AuditActionDeletionScheduled = "user.deletion_scheduled"
+	AuditActionDeletionCancelled = "user.deletion_cancelled"
+	AuditActionUserAnonymized    = "user.anonymized"
+)
+
+// AuditLog represents an entry in the audit trail
+type AuditLog struct {
+	ID        int       `json:"id" db:"id"`
+	UserID    *int      `json:"user_id,omitempty" db:"user_id"`
+	ActorID   *int      `json:"actor_id,omitempty" db:"actor_id"`
+	Action    string    `json:"action" db:"action"`
+	Details   *string   `json:"details,omitempty" db:"details"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TableName returns the table name for the AuditLog model
+func (a *AuditLog) TableName() string {
+	return "audit_logs"
+}
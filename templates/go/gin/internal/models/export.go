@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// UserDataBundle is everything stored about a user, exported for data
+// subject access requests. Credentials are left out: the password hash, the
+// TOTP secret and the hashes of the email change token and recovery codes.
+type UserDataBundle struct {
+	ExportedAt time.Time     `json:"exported_at"`
+	Profile    *UserResponse `json:"profile"`
+	// EmailChangeExpiresAt is when the pending email change expires, if any
+	EmailChangeExpiresAt *time.Time `json:"email_change_expires_at,omitempty"`
+	// RecoveryCodes lists when the user's 2FA recovery codes were issued
+	// and used
+	RecoveryCodes []RecoveryCode `json:"recovery_codes"`
+	// AuditLogs holds the audit entries about the user or performed by them,
+	// oldest first
+	AuditLogs []*AuditLog `json:"audit_logs"`
+}
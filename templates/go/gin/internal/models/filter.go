@@ -0,0 +1,213 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operator is a comparison operator in a filter condition
+type Operator string
+
+// Supported filter operators
+const (
+	OpEq   Operator = "eq"
+	OpNe   Operator = "ne"
+	OpGt   Operator = "gt"
+	OpGte  Operator = "gte"
+	OpLt   Operator = "lt"
+	OpLte  Operator = "lte"
+	OpLike Operator = "like"
+)
+
+// FieldType is the type of a filterable field's values
+type FieldType int
+
+// Filterable field types
+const (
+	FieldString FieldType = iota
+	FieldBool
+	FieldInt
+	FieldTime
+)
+
+// FilterField describes a filterable field and the operators allowed on it
+type FilterField struct {
+	Type      FieldType
+	Operators []Operator
+}
+
+// Allows reports whether op may be used on the field
+func (f FilterField) Allows(op Operator) bool {
+	for _, allowed := range f.Operators {
+		if allowed == op {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterFields whitelists the fields that may be filtered on, keyed by name
+type FilterFields map[string]FilterField
+
+// Condition is a typed comparison of a field against a value. Value is a
+// string, bool, int or time.Time according to the field's type.
+type Condition struct {
+	Field    string
+	Operator Operator
+	Value    interface{}
+}
+
+// FilterError reports an invalid filter condition
+type FilterError struct {
+	Field   string
+	Message string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("invalid filter on %q: %s", e.Field, e.Message)
+}
+
+// UserFilterFields are the user fields that may be filtered with conditions.
+// Field names are also the column names, so only whitelisted names ever
+// reach a query.
+var UserFilterFields = FilterFields{
+	"id":         {Type: FieldInt, Operators: []Operator{OpEq, OpNe, OpGt, OpGte, OpLt, OpLte}},
+	"username":   {Type: FieldString, Operators: []Operator{OpEq, OpNe, OpLike}},
+	"email":      {Type: FieldString, Operators: []Operator{OpEq, OpNe, OpLike}},
+	"full_name":  {Type: FieldString, Operators: []Operator{OpEq, OpNe, OpLike}},
+	"is_active":  {Type: FieldBool, Operators: []Operator{OpEq, OpNe}},
+	"is_admin":   {Type: FieldBool, Operators: []Operator{OpEq, OpNe}},
+	"created_at": {Type: FieldTime, Operators: []Operator{OpEq, OpNe, OpGt, OpGte, OpLt, OpLte}},
+	"updated_at": {Type: FieldTime, Operators: []Operator{OpEq, OpNe, OpGt, OpGte, OpLt, OpLte}},
+	"last_login": {Type: FieldTime, Operators: []Operator{OpEq, OpNe, OpGt, OpGte, OpLt, OpLte}},
+}
+
+// SplitOperator splits an "op:value" filter expression. It reports false
+// when the expression has no operator prefix, so plain values such as
+// timestamps containing colons are left intact.
+func SplitOperator(expr string) (Operator, string, bool) {
+	prefix, value, found := strings.Cut(expr, ":")
+	if !found || prefix == "" {
+		return "", expr, false
+	}
+	for _, r := range prefix {
+		if r < 'a' || r > 'z' {
+			return "", expr, false
+		}
+	}
+	return Operator(prefix), value, true
+}
+
+// Parse parses a filter expression on field into a typed condition. The
+// expression is "op:value", or a bare value compared with eq.
+func (f FilterFields) Parse(field, expr string) (Condition, error) {
+	op, raw, ok := SplitOperator(expr)
+	if !ok {
+		op = OpEq
+	}
+
+	spec, err := f.lookup(field, op)
+	if err != nil {
+		return Condition{}, err
+	}
+
+	value, err := parseFilterValue(spec.Type, raw)
+	if err != nil {
+		return Condition{}, &FilterError{Field: field, Message: err.Error()}
+	}
+
+	return Condition{Field: field, Operator: op, Value: value}, nil
+}
+
+// ParseAll parses the filter expressions in values, keyed by field name.
+// Conditions are returned in field name order so the generated queries are
+// stable.
+func (f FilterFields) ParseAll(values map[string][]string) ([]Condition, error) {
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var conditions []Condition
+	for _, field := range fields {
+		for _, expr := range values[field] {
+			cond, err := f.Parse(field, expr)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, cond)
+		}
+	}
+	return conditions, nil
+}
+
+// Validate checks that cond names a whitelisted field, uses an operator
+// allowed on it and carries a value of the field's type
+func (f FilterFields) Validate(cond Condition) error {
+	field, err := f.lookup(cond.Field, cond.Operator)
+	if err != nil {
+		return err
+	}
+
+	var typeOK bool
+	switch field.Type {
+	case FieldString:
+		_, typeOK = cond.Value.(string)
+	case FieldBool:
+		_, typeOK = cond.Value.(bool)
+	case FieldInt:
+		_, typeOK = cond.Value.(int)
+	case FieldTime:
+		_, typeOK = cond.Value.(time.Time)
+	}
+	if !typeOK {
+		return &FilterError{Field: cond.Field, Message: fmt.Sprintf("unexpected value type %T", cond.Value)}
+	}
+	return nil
+}
+
+// lookup returns the whitelisted field, checking that op is allowed on it
+func (f FilterFields) lookup(name string, op Operator) (FilterField, error) {
+	field, ok := f[name]
+	if !ok {
+		return FilterField{}, &FilterError{Field: name, Message: "unknown field"}
+	}
+	if !field.Allows(op) {
+		return FilterField{}, &FilterError{Field: name, Message: fmt.Sprintf("operator %q is not allowed", op)}
+	}
+	return field, nil
+}
+
+// parseFilterValue parses raw as a value of type t. Times are RFC 3339
+// timestamps or dates.
+func parseFilterValue(t FieldType, raw string) (interface{}, error) {
+	switch t {
+	case FieldBool:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", raw)
+		}
+		return value, nil
+	case FieldInt:
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", raw)
+		}
+		return value, nil
+	case FieldTime:
+		if value, err := time.Parse(time.RFC3339, raw); err == nil {
+			return value, nil
+		}
+		value, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a date or RFC 3339 timestamp", raw)
+		}
+		return value, nil
+	default:
+		return raw, nil
+	}
+}
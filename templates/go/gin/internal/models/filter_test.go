@@ -0,0 +1,102 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterFields_Parse_Operators(t *testing.T) {
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		field string
+		expr  string
+		want  Condition
+	}{
+		{"is_admin", "eq:true", Condition{"is_admin", OpEq, true}},
+		{"is_active", "ne:false", Condition{"is_active", OpNe, false}},
+		{"id", "gt:10", Condition{"id", OpGt, 10}},
+		{"created_at", "gte:2024-01-01", Condition{"created_at", OpGte, date}},
+		{"created_at", "lt:2024-01-01T00:00:00Z", Condition{"created_at", OpLt, date}},
+		{"last_login", "lte:2024-01-01", Condition{"last_login", OpLte, date}},
+		{"email", "like:example.com", Condition{"email", OpLike, "example.com"}},
+		// A bare value compares with eq
+		{"username", "alice", Condition{"username", OpEq, "alice"}},
+		// Only the first colon separates the operator from the value
+		{"full_name", "eq:a:b", Condition{"full_name", OpEq, "a:b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field+"="+tt.expr, func(t *testing.T) {
+			cond, err := UserFilterFields.Parse(tt.field, tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, cond)
+		})
+	}
+}
+
+func TestFilterFields_Parse_BareTimestamp(t *testing.T) {
+	// The colons in a timestamp are not an operator prefix
+	cond, err := UserFilterFields.Parse("created_at", "2024-01-01T10:30:00Z")
+
+	require.NoError(t, err)
+	assert.Equal(t, OpEq, cond.Operator)
+	assert.Equal(t, time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC), cond.Value)
+}
+
+func TestFilterFields_Parse_Rejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		expr  string
+	}{
+		{"unknown field", "password_hash", "eq:x"},
+		{"unknown operator", "created_at", "between:2024-01-01"},
+		{"operator not allowed on field", "is_admin", "like:true"},
+		{"ordering on strings", "username", "gt:a"},
+		{"invalid boolean", "is_admin", "eq:yes please"},
+		{"invalid integer", "id", "gt:1 OR 1=1"},
+		{"invalid time", "created_at", "gte:yesterday"},
+		{"injection in field name", "id; DROP TABLE users; --", "eq:1"},
+		{"quoted field name", `"is_admin"`, "eq:true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := UserFilterFields.Parse(tt.field, tt.expr)
+
+			var filterErr *FilterError
+			require.ErrorAs(t, err, &filterErr)
+			assert.Equal(t, tt.field, filterErr.Field)
+		})
+	}
+}
+
+func TestFilterFields_ParseAll(t *testing.T) {
+	conditions, err := UserFilterFields.ParseAll(map[string][]string{
+		"is_admin":   {"eq:false"},
+		"created_at": {"gte:2024-01-01", "lt:2024-02-01"},
+	})
+
+	require.NoError(t, err)
+	require.Len(t, conditions, 3)
+	// Sorted by field name, keeping the order of repeated fields
+	assert.Equal(t, "created_at", conditions[0].Field)
+	assert.Equal(t, OpGte, conditions[0].Operator)
+	assert.Equal(t, OpLt, conditions[1].Operator)
+	assert.Equal(t, "is_admin", conditions[2].Field)
+
+	_, err = UserFilterFields.ParseAll(map[string][]string{"nope": {"eq:1"}})
+	assert.Error(t, err)
+}
+
+func TestFilterFields_Validate(t *testing.T) {
+	assert.NoError(t, UserFilterFields.Validate(Condition{"id", OpGte, 3}))
+	assert.Error(t, UserFilterFields.Validate(Condition{"id", OpGte, "3"}))
+	assert.Error(t, UserFilterFields.Validate(Condition{"id", OpEq, nil}))
+	assert.Error(t, UserFilterFields.Validate(Condition{"id", Operator("= 1 OR 1"), 3}))
+	assert.Error(t, UserFilterFields.Validate(Condition{"1=1 OR id", OpEq, 3}))
+}
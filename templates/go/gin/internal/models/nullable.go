@@ -0,0 +1,69 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Nullable is an optional request field that tells an absent value apart
+// from an explicit null, which asks to clear the field
+type Nullable[T any] struct {
+	// Set reports whether the field was present, null or not
+	Set bool
+	// Valid reports whether the field holds Value rather than null
+	Valid bool
+	Value T
+}
+
+// NullableValue returns a Nullable set to value
+func NullableValue[T any](value T) Nullable[T] {
+	return Nullable[T]{Set: true, Valid: true, Value: value}
+}
+
+// NullableNull returns a Nullable set to null
+func NullableNull[T any]() Nullable[T] {
+	return Nullable[T]{Set: true}
+}
+
+// NullableFromPtr returns a Nullable set to *value, or to null when value is
+// nil
+func NullableFromPtr[T any](value *T) Nullable[T] {
+	if value == nil {
+		return NullableNull[T]()
+	}
+	return NullableValue(*value)
+}
+
+// Ptr returns a pointer to the value, or nil when the field is null or absent
+func (n Nullable[T]) Ptr() *T {
+	if !n.Valid {
+		return nil
+	}
+	value := n.Value
+	return &value
+}
+
+// UnmarshalJSON marks the field as set, and valid unless it is null. It is
+// only called for fields present in the document.
+func (n *Nullable[T]) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		n.Valid = false
+		var zero T
+		n.Value = zero
+		return nil
+	}
+	if err := json.Unmarshal(data, &n.Value); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
+
+// MarshalJSON encodes the value, or null when the field isn't valid
+func (n Nullable[T]) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(n.Value)
+}
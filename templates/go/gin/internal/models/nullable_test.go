@@ -0,0 +1,45 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateUserRequest_FullName(t *testing.T) {
+	tests := []struct {
+		body  string
+		set   bool
+		valid bool
+		value string
+	}{
+		{`{"username": "renamed"}`, false, false, ""},
+		{`{"full_name": null}`, true, false, ""},
+		{`{"full_name": "John Smith"}`, true, true, "John Smith"},
+		{`{"full_name": ""}`, true, true, ""},
+	}
+
+	for _, tt := range tests {
+		var req UpdateUserRequest
+		require.NoError(t, json.Unmarshal([]byte(tt.body), &req), tt.body)
+
+		assert.Equal(t, tt.set, req.FullName.Set, tt.body)
+		assert.Equal(t, tt.valid, req.FullName.Valid, tt.body)
+		assert.Equal(t, tt.value, req.FullName.Value, tt.body)
+	}
+
+	var req UpdateUserRequest
+	assert.Error(t, json.Unmarshal([]byte(`{"full_name": 42}`), &req))
+}
+
+func TestNullable_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal(map[string]Nullable[string]{
+		"null":  NullableNull[string](),
+		"value": NullableValue("John Smith"),
+	})
+
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"null": null, "value": "John Smith"}`, string(data))
+}
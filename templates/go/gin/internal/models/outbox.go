@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Outbox event types
+const (
+	EventUserCreated = "user.created"
+	EventUserUpdated = "user.updated"
+	EventUserDeleted = "user.deleted"
+)
+
+// OutboxEvent is an event written in the same transaction as the change it
+// describes, waiting to be published
+type OutboxEvent struct {
+	ID          int64      `json:"id" db:"id"`
+	EventType   string     `json:"event_type" db:"event_type"`
+	AggregateID int        `json:"aggregate_id" db:"aggregate_id"`
+	Payload     string     `json:"payload" db:"payload"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	ClaimedBy   *string    `json:"-" db:"claimed_by"`
+	ClaimedAt   *time.Time `json:"-" db:"claimed_at"`
+	SentAt      *time.Time `json:"sent_at,omitempty" db:"sent_at"`
+	// DeadAt is when publishing the event was given up on, after too many
+	// failed attempts
+	DeadAt    *time.Time `json:"dead_at,omitempty" db:"dead_at"`
+	Attempts  int        `json:"attempts" db:"attempts"`
+	LastError *string    `json:"last_error,omitempty" db:"last_error"`
+}
+
+// TableName returns the table name for the OutboxEvent model
+func (e *OutboxEvent) TableName() string {
+	return "outbox"
+}
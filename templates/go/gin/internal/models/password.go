@@ -0,0 +1,193 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrPasswordMismatch is returned when a password doesn't match its hash
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// PasswordHasher hashes passwords and checks them against hashes. Verify
+// accepts hashes of every supported algorithm, so that switching algorithms
+// keeps existing passwords working.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash, password string) error
+	// NeedsRehash reports whether hash was made with another algorithm or
+	// weaker parameters than the hasher's, and should be replaced
+	NeedsRehash(hash string) bool
+}
+
+var (
+	passwordHasherMu sync.RWMutex
+	passwordHasher   PasswordHasher = NewBcryptHasher(bcrypt.DefaultCost)
+)
+
+// SetPasswordHasher sets the hasher used by User.SetPassword and
+// User.CheckPassword. It defaults to bcrypt with the default cost.
+func SetPasswordHasher(hasher PasswordHasher) {
+	passwordHasherMu.Lock()
+	defer passwordHasherMu.Unlock()
+	passwordHasher = hasher
+}
+
+// currentPasswordHasher returns the hasher set by SetPasswordHasher
+func currentPasswordHasher() PasswordHasher {
+	passwordHasherMu.RLock()
+	defer passwordHasherMu.RUnlock()
+	return passwordHasher
+}
+
+// SimulatePasswordCheck takes about as long as checking password against a
+// hash of the current hasher, so that logins of unknown users can't be told
+// apart by their response time
+func SimulatePasswordCheck(password string) {
+	_, _ = currentPasswordHasher().Hash(password)
+}
+
+// BcryptHasher hashes passwords with bcrypt
+type BcryptHasher struct {
+	Cost int
+}
+
+// NewBcryptHasher creates a bcrypt hasher with cost
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{Cost: cost}
+}
+
+// Hash hashes password with bcrypt
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify checks password against a hash of any supported algorithm
+func (h *BcryptHasher) Verify(hash, password string) error {
+	return verifyPasswordHash(hash, password)
+}
+
+// NeedsRehash reports whether hash is not a bcrypt hash of at least the
+// hasher's cost
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.Cost
+}
+
+// Argon2idParams are the Argon2id cost parameters. Memory is in KiB.
+type Argon2idParams struct {
+	Time       uint32
+	Memory     uint32
+	Threads    uint8
+	KeyLength  uint32
+	SaltLength uint32
+}
+
+// Argon2idHasher hashes passwords with Argon2id, encoding them in the PHC
+// string format: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+type Argon2idHasher struct {
+	Params Argon2idParams
+}
+
+// NewArgon2idHasher creates an Argon2id hasher with params
+func NewArgon2idHasher(params Argon2idParams) *Argon2idHasher {
+	return &Argon2idHasher{Params: params}
+}
+
+// Hash hashes password with Argon2id and a random salt
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.Params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Params.Time, h.Params.Memory, h.Params.Threads, h.Params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Params.Memory, h.Params.Time, h.Params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks password against a hash of any supported algorithm
+func (h *Argon2idHasher) Verify(hash, password string) error {
+	return verifyPasswordHash(hash, password)
+}
+
+// NeedsRehash reports whether hash is not an Argon2id hash at least as
+// costly as the hasher's parameters
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, _, _, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+	return params.Time < h.Params.Time ||
+		params.Memory < h.Params.Memory ||
+		params.Threads < h.Params.Threads ||
+		params.KeyLength < h.Params.KeyLength ||
+		params.SaltLength < h.Params.SaltLength
+}
+
+// verifyPasswordHash checks password against a bcrypt or Argon2id hash,
+// telling them apart by their prefix
+func verifyPasswordHash(hash, password string) error {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return ErrPasswordMismatch
+			}
+			return err
+		}
+		return nil
+	}
+
+	params, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return err
+	}
+	candidate := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, candidate) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// decodeArgon2idHash parses an Argon2id hash produced by Argon2idHasher
+func decodeArgon2idHash(hash string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errors.New("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
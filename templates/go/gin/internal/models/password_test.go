@@ -0,0 +1,103 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2idParams are cheap parameters to keep the tests fast
+var testArgon2idParams = Argon2idParams{Time: 1, Memory: 1024, Threads: 1, KeyLength: 32, SaltLength: 16}
+
+func TestPasswordHashers_RoundTrip(t *testing.T) {
+	hashers := map[string]PasswordHasher{
+		"bcrypt":   NewBcryptHasher(bcrypt.MinCost),
+		"argon2id": NewArgon2idHasher(testArgon2idParams),
+	}
+
+	for name, hasher := range hashers {
+		t.Run(name, func(t *testing.T) {
+			hash, err := hasher.Hash("password123")
+			require.NoError(t, err)
+
+			assert.NoError(t, hasher.Verify(hash, "password123"))
+			assert.ErrorIs(t, hasher.Verify(hash, "wrongpassword"), ErrPasswordMismatch)
+			assert.False(t, hasher.NeedsRehash(hash))
+
+			// Salted: the same password hashes differently each time
+			again, err := hasher.Hash("password123")
+			require.NoError(t, err)
+			assert.NotEqual(t, hash, again)
+		})
+	}
+}
+
+func TestPasswordHashers_VerifyEachOthersHashes(t *testing.T) {
+	bcryptHasher := NewBcryptHasher(bcrypt.MinCost)
+	argon2Hasher := NewArgon2idHasher(testArgon2idParams)
+
+	bcryptHash, err := bcryptHasher.Hash("password123")
+	require.NoError(t, err)
+	argon2Hash, err := argon2Hasher.Hash("password123")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(argon2Hash, "$argon2id$v=19$m=1024,t=1,p=1$"), argon2Hash)
+
+	assert.NoError(t, argon2Hasher.Verify(bcryptHash, "password123"))
+	assert.NoError(t, bcryptHasher.Verify(argon2Hash, "password123"))
+
+	// A hash of the other algorithm is always replaced
+	assert.True(t, argon2Hasher.NeedsRehash(bcryptHash))
+	assert.True(t, bcryptHasher.NeedsRehash(argon2Hash))
+}
+
+func TestPasswordHashers_NeedsRehashWhenWeaker(t *testing.T) {
+	bcryptHash, err := NewBcryptHasher(bcrypt.MinCost).Hash("password123")
+	require.NoError(t, err)
+	assert.True(t, NewBcryptHasher(bcrypt.MinCost+1).NeedsRehash(bcryptHash))
+
+	argon2Hash, err := NewArgon2idHasher(testArgon2idParams).Hash("password123")
+	require.NoError(t, err)
+	stronger := testArgon2idParams
+	stronger.Memory *= 2
+	assert.True(t, NewArgon2idHasher(stronger).NeedsRehash(argon2Hash))
+
+	// Weaker parameters than the hash's don't downgrade it
+	weaker := testArgon2idParams
+	weaker.KeyLength = 16
+	assert.False(t, NewArgon2idHasher(weaker).NeedsRehash(argon2Hash))
+}
+
+func TestArgon2idHasher_RejectsMalformedHashes(t *testing.T) {
+	hasher := NewArgon2idHasher(testArgon2idParams)
+
+	for _, hash := range []string{
+		"$argon2id$v=19$m=1024,t=1,p=1$salt",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=x,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$!!!$a2V5",
+	} {
+		err := hasher.Verify(hash, "password123")
+		assert.Error(t, err, hash)
+		assert.NotErrorIs(t, err, ErrPasswordMismatch, hash)
+		assert.True(t, hasher.NeedsRehash(hash), hash)
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	assert.NoError(t, ValidatePassword("password123"))
+	assert.NoError(t, ValidatePassword(strings.Repeat("a", PasswordMaxLength)))
+	// The minimum counts characters: 8 three-byte characters are enough
+	assert.NoError(t, ValidatePassword(strings.Repeat("密", 8)))
+
+	var invalid *PasswordError
+	assert.ErrorAs(t, ValidatePassword("short"), &invalid)
+	assert.ErrorAs(t, ValidatePassword(strings.Repeat("a", PasswordMaxLength+1)), &invalid)
+	// The maximum counts bytes, which bcrypt is limited by: 25 three-byte
+	// characters are 75 bytes
+	err := ValidatePassword(strings.Repeat("密", 25))
+	require.ErrorAs(t, err, &invalid)
+	assert.EqualError(t, err, "password must be at most 72 bytes")
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// TwoFactorSetupResponse carries a new TOTP secret for the user to add to an
+// authenticator app, either by scanning the QR code or typing the secret
+type TwoFactorSetupResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+	// QRCode is the otpauth URL as a PNG data URL
+	QRCode string `json:"qr_code"`
+}
+
+// TwoFactorEnableRequest represents the request payload confirming 2FA setup
+type TwoFactorEnableRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TwoFactorEnableResponse carries the recovery codes, which are shown only
+// once
+type TwoFactorEnableResponse struct {
+	User          *UserResponse `json:"user"`
+	RecoveryCodes []string      `json:"recovery_codes"`
+}
+
+// TwoFactorChallengeResponse is returned by login instead of a token when
+// the user has 2FA enabled
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int    `json:"expires_in"`
+}
+
+// TwoFactorVerifyRequest represents the request payload completing a login
+// with a TOTP or recovery code
+type TwoFactorVerifyRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// RecoveryCode is a hashed one-time code that can stand in for a TOTP code
+type RecoveryCode struct {
+	ID        int64      `json:"id" db:"id"`
+	UserID    int        `json:"user_id" db:"user_id"`
+	CodeHash  string     `json:"-" db:"code_hash"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" db:"used_at"`
+}
+
+// TableName returns the table name for the RecoveryCode model
+func (r *RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
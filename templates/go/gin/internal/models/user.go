@@ -4,8 +4,7 @@ import (
 	"database/sql/driver"
 	"fmt"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
+	"unicode/utf8"
 )
 
 // User represents a user in the system
@@ -20,6 +19,28 @@ type User struct {
 	CreatedAt time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
 	LastLogin *time.Time `json:"last_login,omitempty" db:"last_login"`
+	AvatarURL *string    `json:"avatar_url,omitempty" db:"avatar_url"`
+	// Status is the account's lifecycle state. IsActive is kept in step with
+	// it, true exactly when the status is active; use SetStatus or SetActive
+	// to change either.
+	Status Status `json:"status" db:"status"`
+	// TOTPSecret is the encrypted TOTP secret, set by 2FA setup. It is only
+	// checked at login once TOTPEnabled is set.
+	TOTPSecret  *string `json:"-" db:"totp_secret"`
+	TOTPEnabled bool    `json:"totp_enabled" db:"totp_enabled"`
+	// TOTPLastStep is the time step of the last TOTP code accepted at login,
+	// which no code of that step or earlier may log in again
+	TOTPLastStep *int64 `json:"-" db:"totp_last_step"`
+	// PendingEmail is the address the user asked to change to, which
+	// replaces Email once confirmed with the token whose hash is stored in
+	// EmailChangeTokenHash
+	PendingEmail         *string    `json:"pending_email,omitempty" db:"pending_email"`
+	EmailChangeTokenHash *string    `json:"-" db:"email_change_token_hash"`
+	EmailChangeExpiresAt *time.Time `json:"-" db:"email_change_expires_at"`
+	// DeletionScheduledAt is when the account is deleted, set when the user
+	// asks for its deletion. The account stays inactive until then, and its
+	// login only hands out a token for cancelling the deletion.
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty" db:"deletion_scheduled_at"`
 }
 
 // CreateUserRequest represents the request payload for creating a user
@@ -30,27 +51,196 @@ type CreateUserRequest struct {
 	FullName *string `json:"full_name,omitempty"`
 }
 
+// UpdateStatusRequest represents the request payload for changing a user's
+// status
+type UpdateStatusRequest struct {
+	Status Status `json:"status" binding:"required,oneof=active inactive suspended"`
+}
+
 // UpdateUserRequest represents the request payload for updating a user
 type UpdateUserRequest struct {
 	Username *string `json:"username,omitempty" binding:"omitempty,min=3,max=50"`
 	Email    *string `json:"email,omitempty" binding:"omitempty,email"`
 	Password *string `json:"password,omitempty" binding:"omitempty,min=8"`
-	FullName *string `json:"full_name,omitempty"`
-	IsActive *bool   `json:"is_active,omitempty"`
+	// FullName is cleared by an explicit null
+	FullName Nullable[string] `json:"full_name"`
+	IsActive *bool            `json:"is_active,omitempty"`
+}
+
+// UpdateProfileRequest represents the request payload for users updating
+// their own profile. Unlike UpdateUserRequest it has no is_active, so that
+// users can't deactivate, and lock out, themselves; only admins can.
+type UpdateProfileRequest struct {
+	Username *string `json:"username,omitempty" binding:"omitempty,min=3,max=50"`
+	Email    *string `json:"email,omitempty" binding:"omitempty,email"`
+	Password *string `json:"password,omitempty" binding:"omitempty,min=8"`
+	// FullName is cleared by an explicit null
+	FullName Nullable[string] `json:"full_name"`
+}
+
+// UpdateRequest returns the update setting the fields of the profile update
+func (r *UpdateProfileRequest) UpdateRequest() *UpdateUserRequest {
+	return &UpdateUserRequest{
+		Username: r.Username,
+		Email:    r.Email,
+		Password: r.Password,
+		FullName: r.FullName,
+	}
+}
+
+// ReplaceProfileRequest represents the request payload for users replacing
+// their own profile with PUT, like ReplaceUserRequest without is_active
+type ReplaceProfileRequest struct {
+	Username string  `json:"username" binding:"required,min=3,max=50"`
+	Email    string  `json:"email" binding:"required,email"`
+	Password *string `json:"password,omitempty" binding:"omitempty,min=8"`
+	FullName *string `json:"full_name"`
+}
+
+// UpdateRequest returns the update setting every field of the replacement
+func (r *ReplaceProfileRequest) UpdateRequest() *UpdateUserRequest {
+	return &UpdateUserRequest{
+		Username: &r.Username,
+		Email:    &r.Email,
+		Password: r.Password,
+		FullName: NullableFromPtr(r.FullName),
+	}
+}
+
+// ReplaceUserRequest represents the request payload for replacing a user
+// with PUT. Every field is required except the password, which is only
+// changed when given, and the full name, which is cleared when omitted.
+type ReplaceUserRequest struct {
+	Username string  `json:"username" binding:"required,min=3,max=50"`
+	Email    string  `json:"email" binding:"required,email"`
+	Password *string `json:"password,omitempty" binding:"omitempty,min=8"`
+	FullName *string `json:"full_name"`
+	IsActive *bool   `json:"is_active" binding:"required"`
 }
 
+// UpdateRequest returns the update setting every field of the replacement
+func (r *ReplaceUserRequest) UpdateRequest() *UpdateUserRequest {
+	return &UpdateUserRequest{
+		Username: &r.Username,
+		Email:    &r.Email,
+		Password: r.Password,
+		FullName: NullableFromPtr(r.FullName),
+		IsActive: r.IsActive,
+	}
+}
+
+// ClearEmailChange drops a pending email change along with its token
+func (u *User) ClearEmailChange() {
+	u.PendingEmail = nil
+	u.EmailChangeTokenHash = nil
+	u.EmailChangeExpiresAt = nil
+}
+
+// IdentifierType tells how a login identifier names a user
+type IdentifierType string
+
+// Login identifier types. IdentifierAuto treats identifiers containing an @
+// as emails, falling back to the other type when nothing matches.
+const (
+	IdentifierAuto     IdentifierType = "auto"
+	IdentifierEmail    IdentifierType = "email"
+	IdentifierUsername IdentifierType = "username"
+)
+
 // LoginRequest represents the request payload for user login
 type LoginRequest struct {
-	Username string `json:"username" binding:"required"`
+	// Identifier is the username or email of the user
+	Identifier string `json:"identifier" binding:"required_without=Username"`
+	// IdentifierType defaults to auto
+	IdentifierType IdentifierType `json:"identifier_type" binding:"omitempty,oneof=auto email username"`
+	// Username is the identifier of clients that don't send Identifier
+	Username string `json:"username" binding:"required_without=Identifier"`
 	Password string `json:"password" binding:"required"`
 }
 
+// LoginIdentifier returns the identifier of the user logging in, and how it
+// names them
+func (r *LoginRequest) LoginIdentifier() (string, IdentifierType) {
+	identifier := r.Identifier
+	if identifier == "" {
+		identifier = r.Username
+	}
+	identifierType := r.IdentifierType
+	if identifierType == "" {
+		identifierType = IdentifierAuto
+	}
+	return identifier, identifierType
+}
+
 // LoginResponse represents the response payload for user login
 type LoginResponse struct {
 	User  *UserResponse `json:"user"`
 	Token string        `json:"token"`
 }
 
+// DeletionPendingResponse is returned by login, with 403 Forbidden, when
+// the account's deletion is scheduled. The cancel token is only good for
+// cancelling the deletion.
+type DeletionPendingResponse struct {
+	Error               string    `json:"error"`
+	Message             string    `json:"message"`
+	DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+	CancelToken         string    `json:"cancel_token"`
+	ExpiresIn           int       `json:"expires_in"`
+}
+
+// ImpersonationResponse represents a token letting an admin act as a user
+type ImpersonationResponse struct {
+	User           *UserResponse `json:"user"`
+	Token          string        `json:"token"`
+	ExpiresIn      int           `json:"expires_in"`
+	ImpersonatedBy int           `json:"impersonated_by"`
+}
+
+// WhoAmIResponse reports the claims the server decoded from the request's
+// access token
+type WhoAmIResponse struct {
+	UserID         int       `json:"user_id"`
+	Username       string    `json:"username"`
+	Email          string    `json:"email"`
+	IsAdmin        bool      `json:"is_admin"`
+	ImpersonatedBy int       `json:"impersonated_by,omitempty"`
+	Issuer         string    `json:"issuer"`
+	Audience       []string  `json:"audience"`
+	IssuedAt       time.Time `json:"issued_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	// ExpiresIn is the remaining validity in seconds
+	ExpiresIn int `json:"expires_in"`
+}
+
+// BatchGetUsersRequest asks for several users at once
+type BatchGetUsersRequest struct {
+	IDs []int `json:"ids" binding:"required,min=1,dive,min=1"`
+}
+
+// BatchGetUsersResponse maps the requested IDs that exist to their users.
+// Missing IDs are left out.
+type BatchGetUsersResponse struct {
+	Data map[int]*UserResponse `json:"data"`
+}
+
+// BatchDeleteUsersRequest asks to delete several users at once
+type BatchDeleteUsersRequest struct {
+	IDs []int `json:"ids" binding:"required,min=1,dive,min=1"`
+}
+
+// BatchDeleteUsersResponse summarizes a batch delete. IDs that didn't exist
+// are skipped.
+type BatchDeleteUsersResponse struct {
+	Deleted int   `json:"deleted"`
+	Skipped []int `json:"skipped"`
+}
+
+// UserSearchResponse lists search results, most relevant first
+type UserSearchResponse struct {
+	Data []*UserResponse `json:"data"`
+}
+
 // UserResponse represents a user response without sensitive data
 type UserResponse struct {
 	ID        int        `json:"id"`
@@ -58,10 +248,19 @@ type UserResponse struct {
 	Email     string     `json:"email"`
 	FullName  *string    `json:"full_name,omitempty"`
 	IsActive  bool       `json:"is_active"`
+	Status    Status     `json:"status"`
 	IsAdmin   bool       `json:"is_admin"`
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
 	LastLogin *time.Time `json:"last_login,omitempty"`
+	AvatarURL *string    `json:"avatar_url,omitempty"`
+	// TOTPEnabled reports whether login requires a second factor
+	TOTPEnabled bool `json:"totp_enabled"`
+	// PendingEmail is the new address awaiting confirmation, if any
+	PendingEmail *string `json:"pending_email,omitempty"`
+	// DeletionScheduledAt is when the account is deleted, if its deletion
+	// was requested
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
 }
 
 // ToResponse converts a User to UserResponse
@@ -72,26 +271,68 @@ func (u *User) ToResponse() *UserResponse {
 		Email:     u.Email,
 		FullName:  u.FullName,
 		IsActive:  u.IsActive,
+		Status:    u.Status,
 		IsAdmin:   u.IsAdmin,
 		CreatedAt: u.CreatedAt,
 		UpdatedAt: u.UpdatedAt,
 		LastLogin: u.LastLogin,
+		AvatarURL: u.AvatarURL,
+
+		TOTPEnabled:         u.TOTPEnabled,
+		PendingEmail:        u.PendingEmail,
+		DeletionScheduledAt: u.DeletionScheduledAt,
+	}
+}
+
+// Password policy limits. The minimum counts characters, like the min=8
+// binding rule of the request types; the maximum counts bytes, since bcrypt
+// refuses passwords past 72 bytes. Binding rules can only count characters,
+// so the maximum is left to ValidatePassword.
+const (
+	PasswordMinLength = 8
+	PasswordMaxLength = 72
+)
+
+// PasswordError reports a password breaking the password policy
+type PasswordError struct {
+	Message string
+}
+
+func (e *PasswordError) Error() string {
+	return e.Message
+}
+
+// ValidatePassword checks a plaintext password against the password policy,
+// returning a *PasswordError if it breaks it
+func ValidatePassword(password string) error {
+	if utf8.RuneCountInString(password) < PasswordMinLength {
+		return &PasswordError{Message: fmt.Sprintf("password must be at least %d characters", PasswordMinLength)}
+	}
+	if len(password) > PasswordMaxLength {
+		return &PasswordError{Message: fmt.Sprintf("password must be at most %d bytes", PasswordMaxLength)}
 	}
+	return nil
 }
 
 // SetPassword hashes and sets the user's password
 func (u *User) SetPassword(password string) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := currentPasswordHasher().Hash(password)
 	if err != nil {
 		return fmt.Errorf("failed to hash password: %w", err)
 	}
-	u.Password = string(hashedPassword)
+	u.Password = hashedPassword
 	return nil
 }
 
 // CheckPassword checks if the provided password matches the user's password
 func (u *User) CheckPassword(password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
+	return currentPasswordHasher().Verify(u.Password, password)
+}
+
+// PasswordNeedsRehash reports whether the user's password hash should be
+// replaced by one made with the current algorithm and parameters
+func (u *User) PasswordNeedsRehash() bool {
+	return currentPasswordHasher().NeedsRehash(u.Password)
 }
 
 // BeforeInsert sets default values before inserting
@@ -102,6 +343,34 @@ func (u *User) BeforeInsert() {
 	if !u.IsActive {
 		u.IsActive = true
 	}
+	if u.Status == "" {
+		u.Status = StatusActive
+	}
+}
+
+// SetStatus changes the user's status along with IsActive
+func (u *User) SetStatus(status Status) {
+	u.Status = status
+	u.IsActive = status == StatusActive
+}
+
+// DeletionPending reports whether the account is inactive because its
+// deletion is scheduled
+func (u *User) DeletionPending() bool {
+	return u.Status == StatusInactive && u.DeletionScheduledAt != nil
+}
+
+// SetActive activates or deactivates the user. Suspended users stay
+// suspended; only a status change lifts a suspension.
+func (u *User) SetActive(active bool) {
+	if u.Status == StatusSuspended {
+		return
+	}
+	if active {
+		u.SetStatus(StatusActive)
+	} else {
+		u.SetStatus(StatusInactive)
+	}
 }
 
 // BeforeUpdate sets updated_at before updating
@@ -151,6 +420,18 @@ func (s Status) IsValid() bool {
 	}
 }
 
+// ListUsersQuery holds the plain query parameters of the user list, checked
+// before the filter conditions are parsed. Omitted parameters fall back to
+// the defaults.
+type ListUsersQuery struct {
+	Page   *int   `form:"page" binding:"omitempty,min=1"`
+	Limit  *int   `form:"limit" binding:"omitempty,min=1"`
+	Search string `form:"search" binding:"max=100"`
+	// Username and email may also be "op:value" conditions
+	Username string `form:"username" binding:"max=255"`
+	Email    string `form:"email" binding:"max=255"`
+}
+
 // UserFilter represents filters for user queries
 type UserFilter struct {
 	Username *string `json:"username,omitempty" form:"username"`
@@ -158,4 +439,32 @@ type UserFilter struct {
 	IsActive *bool   `json:"is_active,omitempty" form:"is_active"`
 	IsAdmin  *bool   `json:"is_admin,omitempty" form:"is_admin"`
 	Search   *string `json:"search,omitempty" form:"search"`
+	// CreatedAfter and CreatedBefore bound the creation time, inclusively
+	CreatedAfter  *time.Time `json:"created_after,omitempty" form:"-"`
+	CreatedBefore *time.Time `json:"created_before,omitempty" form:"-"`
+	// LastLoginBefore and InactiveDays select users who haven't logged in
+	// since a time, or for a number of days. Users who never logged in are
+	// included.
+	LastLoginBefore *time.Time `json:"last_login_before,omitempty" form:"-"`
+	InactiveDays    *int       `json:"inactive_days,omitempty" form:"-"`
+	// Conditions are comparisons on UserFilterFields, combined with AND
+	Conditions []Condition `json:"-" form:"-"`
+}
+
+// LastLoginCutoff returns the time users must not have logged in since,
+// the earlier of LastLoginBefore and InactiveDays days before now. It
+// reports false when neither is set.
+func (f *UserFilter) LastLoginCutoff(now time.Time) (time.Time, bool) {
+	var cutoff time.Time
+	set := false
+	if f.LastLoginBefore != nil {
+		cutoff, set = *f.LastLoginBefore, true
+	}
+	if f.InactiveDays != nil {
+		inactiveSince := now.AddDate(0, 0, -*f.InactiveDays)
+		if !set || inactiveSince.Before(cutoff) {
+			cutoff, set = inactiveSince, true
+		}
+	}
+	return cutoff, set
 }
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// JSONPatchContentType is the media type of RFC 6902 JSON Patch documents
+const JSONPatchContentType = "application/json-patch+json"
+
+var (
+	// ErrInvalidPatch is returned for a malformed JSON Patch, or one that
+	// doesn't apply to the user document
+	ErrInvalidPatch = errors.New("invalid JSON patch")
+	// ErrPatchTestFailed is returned when a test operation of a JSON Patch
+	// doesn't match the user document
+	ErrPatchTestFailed = errors.New("JSON patch test operation failed")
+)
+
+// ProtectedFieldError is returned when a JSON Patch changes a field that
+// can't be patched
+type ProtectedFieldError struct {
+	Field string
+}
+
+func (e *ProtectedFieldError) Error() string {
+	return fmt.Sprintf("field %q cannot be patched", e.Field)
+}
+
+// patchableUserFields are the fields of the user document a JSON Patch may
+// change. Every other field may only be tested.
+var patchableUserFields = map[string]bool{
+	"username":  true,
+	"email":     true,
+	"full_name": true,
+	"is_active": true,
+}
+
+// UserPatchDocument holds the patchable fields of a patched user document,
+// with the binding rules of UpdateUserRequest
+type UserPatchDocument struct {
+	Username string  `json:"username" binding:"required,min=3,max=50"`
+	Email    string  `json:"email" binding:"required,email"`
+	FullName *string `json:"full_name"`
+	IsActive *bool   `json:"is_active" binding:"required"`
+}
+
+// ApplyPatch applies an RFC 6902 JSON Patch to the user as returned by the
+// API. Operations changing anything but the patchable fields are rejected
+// with a ProtectedFieldError before the patch is applied.
+func (u *User) ApplyPatch(patchJSON []byte) (*UserPatchDocument, error) {
+	patch, err := jsonpatch.DecodePatch(patchJSON)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	for _, op := range patch {
+		if err := checkPatchOperation(op); err != nil {
+			return nil, err
+		}
+	}
+
+	doc, err := json.Marshal(u.ToResponse())
+	if err != nil {
+		return nil, err
+	}
+	patched, err := patch.Apply(doc)
+	if err != nil {
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			return nil, fmt.Errorf("%w: %v", ErrPatchTestFailed, err)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	var result UserPatchDocument
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return &result, nil
+}
+
+// checkPatchOperation rejects operations that change a field which isn't
+// patchable. A move also changes the field it moves from.
+func checkPatchOperation(op jsonpatch.Operation) error {
+	var paths []string
+	switch op.Kind() {
+	case "test":
+		return nil
+	case "move":
+		from, err := op.From()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+		paths = append(paths, from)
+	}
+
+	path, err := op.Path()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	paths = append(paths, path)
+
+	for _, path := range paths {
+		field := patchField(path)
+		if !patchableUserFields[field] {
+			return &ProtectedFieldError{Field: field}
+		}
+	}
+	return nil
+}
+
+// patchField returns the top-level field a JSON Pointer refers to
+func patchField(pointer string) string {
+	field := strings.SplitN(strings.TrimPrefix(pointer, "/"), "/", 2)[0]
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(field)
+}
+
+// UpdateRequest returns an update request with the fields of the document
+// that differ from user. A removed full name is cleared.
+func (d *UserPatchDocument) UpdateRequest(user *User) *UpdateUserRequest {
+	req := &UpdateUserRequest{}
+	if d.Username != user.Username {
+		req.Username = &d.Username
+	}
+	if d.Email != user.Email {
+		req.Email = &d.Email
+	}
+	if (d.FullName == nil) != (user.FullName == nil) ||
+		(d.FullName != nil && *d.FullName != *user.FullName) {
+		req.FullName = NullableFromPtr(d.FullName)
+	}
+	if *d.IsActive != user.IsActive {
+		req.IsActive = d.IsActive
+	}
+	return req
+}
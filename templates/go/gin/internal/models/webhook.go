@@ -0,0 +1,103 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Webhook delivery statuses. A delivery is dead once it failed on every
+// attempt, and is left for admins to inspect.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryDead      = "dead"
+)
+
+// Webhook is an HTTP callback notified of the user events it subscribes to
+type Webhook struct {
+	ID  int    `json:"id" db:"id"`
+	URL string `json:"url" db:"url"`
+	// Secret signs the deliveries; it is only returned on creation
+	Secret     string         `json:"-" db:"secret"`
+	EventTypes pq.StringArray `json:"event_types" db:"event_types"`
+	Active     bool           `json:"active" db:"active"`
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// TableName returns the table name for the Webhook model
+func (w *Webhook) TableName() string {
+	return "webhooks"
+}
+
+// Subscribes reports whether the webhook is active and subscribed to
+// eventType
+func (w *Webhook) Subscribes(eventType string) bool {
+	if !w.Active {
+		return false
+	}
+	for _, subscribed := range w.EventTypes {
+		if subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is an event queued for delivery to a webhook
+type WebhookDelivery struct {
+	ID        int64  `json:"id" db:"id"`
+	WebhookID int    `json:"webhook_id" db:"webhook_id"`
+	EventID   int64  `json:"event_id" db:"event_id"`
+	EventType string `json:"event_type" db:"event_type"`
+	// Payload is the request body, exactly as signed
+	Payload        string     `json:"payload" db:"payload"`
+	Status         string     `json:"status" db:"status"`
+	Attempts       int        `json:"attempts" db:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" db:"next_attempt_at"`
+	LastError      *string    `json:"last_error,omitempty" db:"last_error"`
+	ResponseStatus *int       `json:"response_status,omitempty" db:"response_status"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty" db:"delivered_at"`
+}
+
+// TableName returns the table name for the WebhookDelivery model
+func (d *WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// CreateWebhookRequest represents the request payload for registering a
+// webhook. A secret is generated when none is given.
+type CreateWebhookRequest struct {
+	URL        string   `json:"url" binding:"required,http_url,max=2048"`
+	Secret     string   `json:"secret" binding:"omitempty,min=16,max=255"`
+	EventTypes []string `json:"event_types" binding:"required,min=1,dive,oneof=user.created user.updated user.deleted"`
+	Active     *bool    `json:"active"`
+}
+
+// UpdateWebhookRequest represents the request payload for changing a
+// webhook. Omitted fields are left unchanged.
+type UpdateWebhookRequest struct {
+	URL        *string  `json:"url,omitempty" binding:"omitempty,http_url,max=2048"`
+	Secret     *string  `json:"secret,omitempty" binding:"omitempty,min=16,max=255"`
+	EventTypes []string `json:"event_types,omitempty" binding:"omitempty,min=1,dive,oneof=user.created user.updated user.deleted"`
+	Active     *bool    `json:"active,omitempty"`
+}
+
+// WebhookCreatedResponse returns a new webhook with its secret, which isn't
+// shown again
+type WebhookCreatedResponse struct {
+	*Webhook
+	Secret string `json:"secret"`
+}
+
+// WebhookListResponse lists webhooks in id order
+type WebhookListResponse struct {
+	Data []*Webhook `json:"data"`
+}
+
+// WebhookDeliveryListResponse lists webhook deliveries, newest first
+type WebhookDeliveryListResponse struct {
+	Data []*WebhookDelivery `json:"data"`
+}
@@ -0,0 +1,152 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"gin-service/internal/config"
+	"gin-service/internal/events"
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Poller publishes the events recorded in the outbox. Events are claimed
+// before publishing, so several instances can poll the same outbox without
+// publishing an event twice while its claim is held.
+type Poller struct {
+	repo      repository.OutboxRepository
+	publisher events.Publisher
+	cfg       config.OutboxConfig
+	owner     string
+	logger    *zap.Logger
+}
+
+// NewPoller creates a new outbox poller
+func NewPoller(repo repository.OutboxRepository, publisher events.Publisher, cfg config.OutboxConfig, logger *zap.Logger) *Poller {
+	hostname, _ := os.Hostname()
+	return &Poller{
+		repo:      repo,
+		publisher: publisher,
+		cfg:       cfg,
+		owner:     fmt.Sprintf("%s-%s", hostname, uuid.NewString()),
+		logger:    logger.With(zap.String("component", "outbox")),
+	}
+}
+
+// Run polls the outbox every cfg.PollInterval until ctx is cancelled
+func (p *Poller) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := p.PollOnce(ctx); err != nil {
+			p.logger.Error("Failed to poll outbox", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain polls until the outbox has no unsent events left, a poll fails, or
+// ctx is done, returning how many events were published. It is meant for
+// shutdown, after the server stopped taking requests, so their events are
+// published before exiting rather than on the next start. Subscribers
+// handle the events under ctx, so it must not be the context that stopped
+// Run.
+func (p *Poller) Drain(ctx context.Context) (int, error) {
+	total := 0
+	for ctx.Err() == nil {
+		published, err := p.PollOnce(ctx)
+		total += published
+		if err != nil {
+			return total, err
+		}
+		if published == 0 {
+			return total, nil
+		}
+	}
+	return total, ctx.Err()
+}
+
+// PollOnce claims a batch of unsent events and publishes them in id order,
+// returning how many were published. Publishing stops at the first failure;
+// the failed event and the rest of the batch are released so they are
+// retried in order on a later poll. An event failing on its
+// cfg.MaxAttempts-th attempt is left dead instead, and publishing goes on
+// with the next, so that a poison event doesn't hold up the outbox for good.
+func (p *Poller) PollOnce(ctx context.Context) (int, error) {
+	claimed, err := p.repo.Claim(p.owner, p.cfg.BatchSize, p.cfg.ClaimLease)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim outbox events: %w", err)
+	}
+
+	published := 0
+	for i, event := range claimed {
+		if err := p.publisher.Publish(ctx, toEvent(event)); err != nil {
+			fields := []zap.Field{
+				zap.Error(err),
+				zap.Int64("event_id", event.ID),
+				zap.String("event_type", event.EventType),
+				zap.Int("attempts", event.Attempts),
+			}
+			if event.Attempts >= p.cfg.MaxAttempts {
+				p.markDead(event, err, fields)
+				continue
+			}
+			p.logger.Warn("Failed to publish outbox event", fields...)
+			p.release(claimed[i:], err)
+			return published, nil
+		}
+
+		if err := p.repo.MarkSent(event.ID, p.owner); err != nil {
+			// The event was published, so it may be published again once its
+			// claim expires; consumers deduplicate on the event ID
+			p.release(claimed[i+1:], err)
+			return published + 1, fmt.Errorf("failed to mark outbox event %d sent: %w", event.ID, err)
+		}
+		published++
+	}
+
+	return published, nil
+}
+
+// markDead gives up on an event that failed on its last attempt. It is
+// logged with its payload, so it can be replayed by hand.
+func (p *Poller) markDead(event *models.OutboxEvent, cause error, fields []zap.Field) {
+	p.logger.Error("Outbox event dead-lettered", append(fields, zap.String("payload", event.Payload))...)
+	if err := p.repo.MarkDead(event.ID, p.owner, cause); err != nil {
+		// The event is retried once its claim expires
+		p.logger.Warn("Failed to mark outbox event dead", zap.Error(err), zap.Int64("event_id", event.ID))
+	}
+}
+
+// release gives up the claims on events so they can be retried
+func (p *Poller) release(pending []*models.OutboxEvent, cause error) {
+	for _, event := range pending {
+		if err := p.repo.Release(event.ID, p.owner, cause); err != nil {
+			// The claim expires after the lease anyway
+			p.logger.Warn("Failed to release outbox event", zap.Error(err), zap.Int64("event_id", event.ID))
+		}
+	}
+}
+
+// toEvent converts an outbox row to the event it records
+func toEvent(event *models.OutboxEvent) events.Event {
+	return events.Event{
+		ID:          event.ID,
+		Type:        event.EventType,
+		AggregateID: event.AggregateID,
+		Payload:     json.RawMessage(event.Payload),
+		OccurredAt:  event.CreatedAt,
+	}
+}
@@ -0,0 +1,292 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gin-service/internal/config"
+	"gin-service/internal/events"
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+	"gin-service/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var testConfig = config.OutboxConfig{
+	Enabled:      true,
+	PollInterval: 10 * time.Millisecond,
+	BatchSize:    100,
+	ClaimLease:   time.Minute,
+	MaxAttempts:  3,
+}
+
+// recordingPublisher records published events, failing those in fail
+type recordingPublisher struct {
+	mu        sync.Mutex
+	published []events.Event
+	fail      map[int64]bool
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, event events.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.fail[event.ID] {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, event)
+	return nil
+}
+
+func (p *recordingPublisher) ids() []int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ids := make([]int64, len(p.published))
+	for i, event := range p.published {
+		ids[i] = event.ID
+	}
+	return ids
+}
+
+func createUsers(t *testing.T, store repository.Store, usernames ...string) {
+	userService := services.NewUserService(store, zap.NewNop())
+	for _, username := range usernames {
+		_, err := userService.Create(&models.CreateUserRequest{
+			Username: username,
+			Email:    username + "@example.com",
+			Password: "password123",
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestPoller_PublishesCommittedEvents(t *testing.T) {
+	store := repository.NewMemoryStore()
+	createUsers(t, store, "alice")
+
+	// The event is written with the user, unsent
+	pending := store.OutboxEvents()
+	require.Len(t, pending, 1)
+	assert.Nil(t, pending[0].SentAt)
+
+	publisher := &recordingPublisher{}
+	poller := NewPoller(store.Outbox(), publisher, testConfig, zap.NewNop())
+
+	published, err := poller.PollOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, published)
+	require.Len(t, publisher.published, 1)
+	event := publisher.published[0]
+	assert.Equal(t, models.EventUserCreated, event.Type)
+	assert.JSONEq(t, pending[0].Payload, string(event.Payload))
+
+	// The event is marked sent and not published again
+	assert.NotNil(t, store.OutboxEvents()[0].SentAt)
+	published, err = poller.PollOnce(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, published)
+}
+
+func TestPoller_SkipsRolledBackEvents(t *testing.T) {
+	store := repository.NewMemoryStore()
+
+	err := store.Transaction(func(tx repository.Store) error {
+		if err := tx.Outbox().Add(&models.OutboxEvent{EventType: models.EventUserCreated, Payload: `{}`}); err != nil {
+			return err
+		}
+		return assert.AnError
+	})
+	require.ErrorIs(t, err, assert.AnError)
+
+	publisher := &recordingPublisher{}
+	published, err := NewPoller(store.Outbox(), publisher, testConfig, zap.NewNop()).PollOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Zero(t, published)
+	assert.Empty(t, publisher.published)
+}
+
+func TestPoller_RetriesInOrderAfterFailure(t *testing.T) {
+	store := repository.NewMemoryStore()
+	createUsers(t, store, "alice", "bob", "carol")
+
+	publisher := &recordingPublisher{fail: map[int64]bool{2: true}}
+	poller := NewPoller(store.Outbox(), publisher, testConfig, zap.NewNop())
+
+	published, err := poller.PollOnce(context.Background())
+
+	// Publishing stops at the failed event so later events stay behind it
+	require.NoError(t, err)
+	assert.Equal(t, 1, published)
+	assert.Equal(t, []int64{1}, publisher.ids())
+
+	failed := store.OutboxEvents()[1]
+	assert.Nil(t, failed.SentAt)
+	require.NotNil(t, failed.LastError)
+	assert.Contains(t, *failed.LastError, "broker unavailable")
+
+	publisher.fail = nil
+	published, err = poller.PollOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, published)
+	assert.Equal(t, []int64{1, 2, 3}, publisher.ids())
+}
+
+func TestPoller_DeadLettersPoisonEvents(t *testing.T) {
+	store := repository.NewMemoryStore()
+	createUsers(t, store, "alice", "bob", "carol")
+
+	core, logs := observer.New(zapcore.WarnLevel)
+	publisher := &recordingPublisher{fail: map[int64]bool{2: true}}
+	poller := NewPoller(store.Outbox(), publisher, testConfig, zap.New(core))
+
+	// The event holds up the ones behind it until its last attempt
+	for attempt := 1; attempt < testConfig.MaxAttempts; attempt++ {
+		_, err := poller.PollOnce(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1}, publisher.ids())
+	}
+
+	published, err := poller.PollOnce(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, published)
+	assert.Equal(t, []int64{1, 3}, publisher.ids())
+
+	dead := store.OutboxEvents()[1]
+	assert.Nil(t, dead.SentAt)
+	assert.NotNil(t, dead.DeadAt)
+	assert.Equal(t, testConfig.MaxAttempts, dead.Attempts)
+	require.NotNil(t, dead.LastError)
+	assert.Contains(t, *dead.LastError, "broker unavailable")
+
+	// The dead event is logged with its payload, and not retried
+	entries := logs.FilterMessage("Outbox event dead-lettered").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, dead.Payload, entries[0].ContextMap()["payload"])
+
+	published, err = poller.PollOnce(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, published)
+	assert.Equal(t, testConfig.MaxAttempts, store.OutboxEvents()[1].Attempts)
+}
+
+func TestPoller_ConcurrentPollersDoNotDoublePublish(t *testing.T) {
+	store := repository.NewMemoryStore()
+	for i := 0; i < 50; i++ {
+		require.NoError(t, store.Outbox().Add(&models.OutboxEvent{EventType: models.EventUserUpdated, AggregateID: i, Payload: `{}`}))
+	}
+
+	cfg := testConfig
+	cfg.BatchSize = 5
+	publisher := &recordingPublisher{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		poller := NewPoller(store.Outbox(), publisher, cfg, zap.NewNop())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				published, err := poller.PollOnce(context.Background())
+				if err != nil || published == 0 {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	ids := publisher.ids()
+	assert.Len(t, ids, 50)
+	seen := make(map[int64]bool)
+	for _, id := range ids {
+		assert.False(t, seen[id], "event %d published twice", id)
+		seen[id] = true
+	}
+}
+
+func TestPoller_RunStopsOnCancel(t *testing.T) {
+	store := repository.NewMemoryStore()
+	createUsers(t, store, "alice")
+
+	publisher := &recordingPublisher{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewPoller(store.Outbox(), publisher, testConfig, zap.NewNop()).Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return len(publisher.ids()) == 1 }, time.Second, 5*time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("poller did not stop")
+	}
+}
+
+func TestPoller_DrainPublishesEverything(t *testing.T) {
+	store := repository.NewMemoryStore()
+	for i := 0; i < 12; i++ {
+		require.NoError(t, store.Outbox().Add(&models.OutboxEvent{EventType: models.EventUserUpdated, AggregateID: i, Payload: `{}`}))
+	}
+
+	cfg := testConfig
+	cfg.BatchSize = 5
+	publisher := &recordingPublisher{}
+
+	published, err := NewPoller(store.Outbox(), publisher, cfg, zap.NewNop()).Drain(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 12, published)
+	assert.Len(t, publisher.ids(), 12)
+}
+
+func TestPoller_DrainStopsAtFailure(t *testing.T) {
+	store := repository.NewMemoryStore()
+	createUsers(t, store, "alice", "bob")
+
+	publisher := &recordingPublisher{fail: map[int64]bool{2: true}}
+	done := make(chan struct{})
+	var published int
+	var err error
+	go func() {
+		published, err = NewPoller(store.Outbox(), publisher, testConfig, zap.NewNop()).Drain(context.Background())
+		close(done)
+	}()
+
+	// A failing event is left in the outbox rather than retried forever
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("drain did not stop")
+	}
+	require.NoError(t, err)
+	assert.Equal(t, 1, published)
+	assert.Nil(t, store.OutboxEvents()[1].SentAt)
+}
+
+func TestPoller_DrainStopsOnCancel(t *testing.T) {
+	store := repository.NewMemoryStore()
+	createUsers(t, store, "alice")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	published, err := NewPoller(store.Outbox(), &recordingPublisher{}, testConfig, zap.NewNop()).Drain(ctx)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, published)
+}
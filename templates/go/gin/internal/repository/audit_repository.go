@@ -0,0 +1,42 @@
+package repository
+
+import "gin-service/internal/models"
+
+// AuditLogRepository persists audit log entries
+type AuditLogRepository interface {
+	Create(entry *models.AuditLog) error
+	// ListByUser returns the entries about the user or performed by them,
+	// oldest first
+	ListByUser(userID int) ([]*models.AuditLog, error)
+}
+
+// sqlAuditLogRepository is an AuditLogRepository backed by a SQLStore
+type sqlAuditLogRepository struct {
+	store *SQLStore
+}
+
+// Create inserts an audit log entry
+func (r *sqlAuditLogRepository) Create(entry *models.AuditLog) error {
+	query := `
+		INSERT INTO audit_logs (user_id, actor_id, action, details)
+		VALUES (:user_id, :actor_id, :action, :details)`
+
+	_, err := r.store.q.NamedExec(query, entry)
+	return err
+}
+
+// ListByUser selects the entries whose subject or actor is the user
+func (r *sqlAuditLogRepository) ListByUser(userID int) ([]*models.AuditLog, error) {
+	query := `
+		SELECT id, user_id, actor_id, action, details, created_at
+		FROM audit_logs
+		WHERE user_id = $1 OR actor_id = $1
+		ORDER BY created_at, id`
+
+	var entries []*models.AuditLog
+	err := r.store.read(func() error {
+		entries = nil
+		return r.store.q.Select(&entries, query, userID)
+	})
+	return entries, err
+}
@@ -0,0 +1,784 @@
+package repository
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gin-service/internal/database"
+	"gin-service/internal/database/testutil"
+	"gin-service/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain stops the embedded test database once the tests are done
+func TestMain(m *testing.M) {
+	testutil.Main(m)
+}
+
+// testUserRepositoryContract checks the behavior every UserRepository
+// implementation must share, so tests written against the in-memory store
+// hold for the SQL store too
+func testUserRepositoryContract(t *testing.T, newRepo func(t *testing.T) UserRepository) {
+	newUser := func(username string, createdAt time.Time) *models.User {
+		return &models.User{
+			Username:  username,
+			Email:     username + "@example.com",
+			Password:  "hash",
+			IsActive:  true,
+			Status:    models.StatusActive,
+			CreatedAt: createdAt,
+			UpdatedAt: createdAt,
+		}
+	}
+
+	t.Run("create and find", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("testuser", time.Now())
+
+		require.NoError(t, repo.Create(user))
+		assert.NotZero(t, user.ID)
+
+		found, err := repo.FindByID(user.ID)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		assert.Equal(t, "testuser", found.Username)
+
+		found, err = repo.FindByUsername("testuser")
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		assert.Equal(t, user.ID, found.ID)
+
+		found, err = repo.FindByEmail("testuser@example.com")
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		assert.Equal(t, user.ID, found.ID)
+	})
+
+	t.Run("find missing returns nil", func(t *testing.T) {
+		repo := newRepo(t)
+
+		found, err := repo.FindByID(42)
+		assert.NoError(t, err)
+		assert.Nil(t, found)
+
+		found, err = repo.FindByUsername("nobody")
+		assert.NoError(t, err)
+		assert.Nil(t, found)
+	})
+
+	t.Run("find by ids", func(t *testing.T) {
+		repo := newRepo(t)
+		first := newUser("first", time.Now())
+		require.NoError(t, repo.Create(first))
+		second := newUser("second", time.Now())
+		require.NoError(t, repo.Create(second))
+
+		found, err := repo.FindByIDs([]int{second.ID, 999999, first.ID, second.ID})
+		require.NoError(t, err)
+		require.Len(t, found, 2)
+		assert.Equal(t, first.ID, found[0].ID)
+		assert.Equal(t, second.ID, found[1].ID)
+
+		found, err = repo.FindByIDs(nil)
+		require.NoError(t, err)
+		assert.Empty(t, found)
+	})
+
+	t.Run("duplicate username", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.Create(newUser("testuser", time.Now())))
+
+		duplicate := newUser("testuser", time.Now())
+		duplicate.Email = "other@example.com"
+
+		assert.ErrorIs(t, repo.Create(duplicate), ErrDuplicateUsername)
+	})
+
+	t.Run("duplicate email", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.Create(newUser("testuser", time.Now())))
+
+		duplicate := newUser("other", time.Now())
+		duplicate.Email = "testuser@example.com"
+
+		assert.ErrorIs(t, repo.Create(duplicate), ErrDuplicateEmail)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("testuser", time.Now())
+		require.NoError(t, repo.Create(user))
+		other := newUser("other", time.Now())
+		require.NoError(t, repo.Create(other))
+
+		user.Username = "renamed"
+		require.NoError(t, repo.Update(user))
+
+		found, err := repo.FindByID(user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "renamed", found.Username)
+
+		user.Username = "other"
+		assert.ErrorIs(t, repo.Update(user), ErrDuplicateUsername)
+
+		missing := newUser("missing", time.Now())
+		missing.ID = 42
+		assert.ErrorIs(t, repo.Update(missing), ErrUserNotFound)
+	})
+
+	t.Run("update password hash", func(t *testing.T) {
+		createdAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+		repo := newRepo(t)
+		user := newUser("testuser", createdAt)
+		require.NoError(t, repo.Create(user))
+
+		require.NoError(t, repo.UpdatePasswordHash(user.ID, "newhash"))
+
+		found, err := repo.FindByID(user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "newhash", found.Password)
+		assert.True(t, found.UpdatedAt.Equal(createdAt), "rehashing leaves updated_at alone")
+	})
+
+	t.Run("use TOTP step", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("testuser", time.Now())
+		require.NoError(t, repo.Create(user))
+
+		used, err := repo.UseTOTPStep(user.ID, 100)
+		require.NoError(t, err)
+		assert.True(t, used)
+
+		// The same step or an earlier one is refused, a later one accepted
+		for _, step := range []int64{100, 99} {
+			used, err = repo.UseTOTPStep(user.ID, step)
+			require.NoError(t, err)
+			assert.False(t, used, step)
+		}
+		used, err = repo.UseTOTPStep(user.ID, 101)
+		require.NoError(t, err)
+		assert.True(t, used)
+
+		// Saving the user leaves the step alone
+		require.NoError(t, repo.Update(user))
+		found, err := repo.FindByID(user.ID)
+		require.NoError(t, err)
+		require.NotNil(t, found.TOTPLastStep)
+		assert.Equal(t, int64(101), *found.TOTPLastStep)
+
+		used, err = repo.UseTOTPStep(42, 100)
+		require.NoError(t, err)
+		assert.False(t, used)
+	})
+
+	t.Run("find deletion due", func(t *testing.T) {
+		repo := newRepo(t)
+		now := time.Now().Truncate(time.Second)
+		for i, offset := range []time.Duration{time.Hour, -time.Minute, -time.Hour} {
+			user := newUser(fmt.Sprintf("user%d", i+1), now)
+			require.NoError(t, repo.Create(user))
+			scheduledAt := now.Add(offset)
+			user.DeletionScheduledAt = &scheduledAt
+			require.NoError(t, repo.Update(user))
+		}
+		require.NoError(t, repo.Create(newUser("kept", now)))
+
+		due, err := repo.FindDeletionDue(now)
+
+		require.NoError(t, err)
+		require.Len(t, due, 2)
+		assert.Equal(t, "user3", due[0].Username)
+		assert.Equal(t, "user2", due[1].Username)
+	})
+
+	t.Run("set admin", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("testuser", time.Now())
+		require.NoError(t, repo.Create(user))
+
+		count, err := repo.CountAdmins()
+		require.NoError(t, err)
+		assert.Equal(t, 0, count)
+
+		require.NoError(t, repo.SetAdmin(user.ID, true, time.Now()))
+
+		found, err := repo.FindByID(user.ID)
+		require.NoError(t, err)
+		assert.True(t, found.IsAdmin)
+		count, err = repo.CountAdmins()
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		assert.ErrorIs(t, repo.SetAdmin(42, true, time.Now()), ErrUserNotFound)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		repo := newRepo(t)
+		user := newUser("testuser", time.Now())
+		require.NoError(t, repo.Create(user))
+
+		require.NoError(t, repo.Delete(user.ID))
+		assert.ErrorIs(t, repo.Delete(user.ID), ErrUserNotFound)
+	})
+
+	t.Run("list paginates newest first", func(t *testing.T) {
+		repo := newRepo(t)
+		base := time.Now().Truncate(time.Second)
+		for i := 1; i <= 5; i++ {
+			require.NoError(t, repo.Create(newUser(fmt.Sprintf("user%d", i), base.Add(time.Duration(i)*time.Minute))))
+		}
+
+		pagination := &database.Paginate{Page: 2, Limit: 2}
+		page, err := repo.List(nil, pagination)
+
+		require.NoError(t, err)
+		assert.Equal(t, 5, pagination.Total)
+		assert.Equal(t, 3, pagination.Pages)
+		require.Len(t, page, 2)
+		assert.Equal(t, "user3", page[0].Username)
+		assert.Equal(t, "user2", page[1].Username)
+
+		pagination = &database.Paginate{Page: 9, Limit: 2}
+		page, err = repo.List(nil, pagination)
+
+		require.NoError(t, err)
+		assert.Empty(t, page)
+		assert.Equal(t, 5, pagination.Total)
+	})
+
+	t.Run("list filters", func(t *testing.T) {
+		repo := newRepo(t)
+		fullName := "Jane Smith"
+		alice := newUser("Alice", time.Now())
+		bob := newUser("bob", time.Now())
+		bob.IsActive = false
+		bob.FullName = &fullName
+		require.NoError(t, repo.Create(alice))
+		require.NoError(t, repo.Create(bob))
+
+		username := "ALI"
+		page, err := repo.List(&models.UserFilter{Username: &username}, &database.Paginate{Page: 1, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assert.Equal(t, "Alice", page[0].Username)
+
+		inactive := false
+		page, err = repo.List(&models.UserFilter{IsActive: &inactive}, &database.Paginate{Page: 1, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assert.Equal(t, "bob", page[0].Username)
+
+		search := "smith"
+		page, err = repo.List(&models.UserFilter{Search: &search}, &database.Paginate{Page: 1, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assert.Equal(t, "bob", page[0].Username)
+	})
+
+	t.Run("list created range", func(t *testing.T) {
+		repo := newRepo(t)
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i, username := range []string{"december", "january", "february"} {
+			require.NoError(t, repo.Create(newUser(username, start.AddDate(0, i-1, 15))))
+		}
+
+		after, before := start, start.AddDate(0, 1, 0)
+		page, err := repo.List(&models.UserFilter{CreatedAfter: &after, CreatedBefore: &before}, &database.Paginate{Page: 1, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assert.Equal(t, "january", page[0].Username)
+
+		page, err = repo.List(&models.UserFilter{CreatedAfter: &after}, &database.Paginate{Page: 1, Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, page, 2)
+	})
+
+	t.Run("list inactive", func(t *testing.T) {
+		repo := newRepo(t)
+		for _, username := range []string{"recent", "dormant", "never"} {
+			require.NoError(t, repo.Create(newUser(username, time.Now().AddDate(-1, 0, 0))))
+		}
+		recent, err := repo.FindByUsername("recent")
+		require.NoError(t, err)
+		require.NoError(t, repo.UpdateLastLogin(recent.ID, time.Now().AddDate(0, 0, -1)))
+		dormant, err := repo.FindByUsername("dormant")
+		require.NoError(t, err)
+		require.NoError(t, repo.UpdateLastLogin(dormant.ID, time.Now().AddDate(0, -3, 0)))
+
+		days := 30
+		page, err := repo.List(&models.UserFilter{InactiveDays: &days}, &database.Paginate{Page: 1, Limit: 10})
+		require.NoError(t, err)
+		var usernames []string
+		for _, user := range page {
+			usernames = append(usernames, user.Username)
+		}
+		assert.ElementsMatch(t, []string{"dormant", "never"}, usernames)
+	})
+
+	t.Run("search ranks by relevance", func(t *testing.T) {
+		repo := newRepo(t)
+		fullName := "Alex Smith"
+		jdoe := newUser("jdoe", time.Now())
+		jdoe.FullName = &fullName
+		require.NoError(t, repo.Create(jdoe))
+		require.NoError(t, repo.Create(newUser("alexandra", time.Now())))
+		require.NoError(t, repo.Create(newUser("Alex", time.Now())))
+		require.NoError(t, repo.Create(newUser("bob", time.Now())))
+
+		usernames := func(query string, limit int) []string {
+			users, err := repo.Search(query, limit)
+			require.NoError(t, err)
+			names := make([]string, len(users))
+			for i, user := range users {
+				names[i] = user.Username
+			}
+			return names
+		}
+
+		// The exact username match ranks above the prefix and the full name
+		assert.Equal(t, []string{"Alex", "alexandra", "jdoe"}, usernames("alex", 10))
+		assert.Equal(t, []string{"Alex", "alexandra"}, usernames("alex", 2))
+		assert.Equal(t, []string{"jdoe"}, usernames("smith", 10))
+		assert.Equal(t, []string{"bob"}, usernames("bob@example.com", 10))
+
+		// Wildcards match literally
+		assert.Empty(t, usernames("%", 10))
+	})
+
+	t.Run("list conditions", func(t *testing.T) {
+		repo := newRepo(t)
+		base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i := 1; i <= 4; i++ {
+			require.NoError(t, repo.Create(newUser(fmt.Sprintf("user%d", i), base.AddDate(0, 0, i))))
+		}
+		fullName := "50% Off_Sale"
+		sale := newUser("sale", base)
+		sale.FullName = &fullName
+		require.NoError(t, repo.Create(sale))
+
+		usernames := func(conditions ...models.Condition) []string {
+			page, err := repo.List(&models.UserFilter{Conditions: conditions}, &database.Paginate{Page: 1, Limit: 10})
+			require.NoError(t, err)
+			names := make([]string, len(page))
+			for i, user := range page {
+				names[i] = user.Username
+			}
+			return names
+		}
+		cond := func(field string, op models.Operator, value interface{}) models.Condition {
+			return models.Condition{Field: field, Operator: op, Value: value}
+		}
+
+		assert.Equal(t, []string{"user2"}, usernames(cond("created_at", models.OpEq, base.AddDate(0, 0, 2))))
+		assert.Equal(t, []string{"user4", "user3", "user1", "sale"}, usernames(cond("username", models.OpNe, "user2")))
+		assert.Equal(t, []string{"user4"}, usernames(cond("created_at", models.OpGt, base.AddDate(0, 0, 3))))
+		assert.Equal(t, []string{"user4", "user3"}, usernames(cond("created_at", models.OpGte, base.AddDate(0, 0, 3))))
+		assert.Equal(t, []string{"sale"}, usernames(cond("created_at", models.OpLt, base.AddDate(0, 0, 1))))
+		assert.Equal(t, []string{"user1", "sale"}, usernames(cond("created_at", models.OpLte, base.AddDate(0, 0, 1))))
+		assert.Equal(t, []string{"user3", "user2"}, usernames(
+			cond("created_at", models.OpGte, base.AddDate(0, 0, 2)),
+			cond("created_at", models.OpLt, base.AddDate(0, 0, 4)),
+		))
+
+		// like is a case-insensitive substring match with literal wildcards
+		assert.Equal(t, []string{"user4", "user3", "user2", "user1"}, usernames(cond("email", models.OpLike, "USER")))
+		assert.Equal(t, []string{"sale"}, usernames(cond("full_name", models.OpLike, "0% off_")))
+		assert.Empty(t, usernames(cond("full_name", models.OpLike, "5_%")))
+
+		// Comparisons with NULL columns never match
+		assert.Empty(t, usernames(cond("last_login", models.OpNe, base)))
+	})
+}
+
+func TestInMemoryUserStore_Contract(t *testing.T) {
+	testUserRepositoryContract(t, func(t *testing.T) UserRepository {
+		return NewInMemoryUserStore()
+	})
+}
+
+// TestSQLUserRepository_Contract runs against the test database, whose
+// tables are truncated before each case
+func TestSQLUserRepository_Contract(t *testing.T) {
+	db := testutil.Open(t)
+	store := NewSQLStore(db)
+	testUserRepositoryContract(t, func(t *testing.T) UserRepository {
+		_, err := db.Exec("TRUNCATE users, audit_logs, outbox RESTART IDENTITY CASCADE")
+		require.NoError(t, err)
+		return store.Users()
+	})
+}
+
+// testOutboxRepositoryContract checks the claim semantics every
+// OutboxRepository implementation must share
+func testOutboxRepositoryContract(t *testing.T, newRepo func(t *testing.T) OutboxRepository) {
+	add := func(t *testing.T, repo OutboxRepository, n int) {
+		for i := 1; i <= n; i++ {
+			event := &models.OutboxEvent{EventType: models.EventUserCreated, AggregateID: i, Payload: `{}`}
+			require.NoError(t, repo.Add(event))
+			require.NotZero(t, event.ID)
+		}
+	}
+	ids := func(events []*models.OutboxEvent) []int64 {
+		ids := make([]int64, len(events))
+		for i, event := range events {
+			ids[i] = event.ID
+		}
+		return ids
+	}
+
+	t.Run("claims in id order", func(t *testing.T) {
+		repo := newRepo(t)
+		add(t, repo, 3)
+
+		claimed, err := repo.Claim("a", 2, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1, 2}, ids(claimed))
+		assert.Equal(t, 1, claimed[0].Attempts)
+		assert.Equal(t, models.EventUserCreated, claimed[0].EventType)
+		assert.JSONEq(t, `{}`, claimed[0].Payload)
+	})
+
+	t.Run("claims are exclusive while leased", func(t *testing.T) {
+		repo := newRepo(t)
+		add(t, repo, 3)
+
+		first, err := repo.Claim("a", 2, time.Minute)
+		require.NoError(t, err)
+		second, err := repo.Claim("b", 10, time.Minute)
+		require.NoError(t, err)
+
+		assert.Equal(t, []int64{1, 2}, ids(first))
+		assert.Equal(t, []int64{3}, ids(second))
+	})
+
+	t.Run("expired claims are reclaimed", func(t *testing.T) {
+		repo := newRepo(t)
+		add(t, repo, 1)
+
+		_, err := repo.Claim("a", 10, time.Millisecond)
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+
+		claimed, err := repo.Claim("b", 10, time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1}, ids(claimed))
+		assert.Equal(t, 2, claimed[0].Attempts)
+	})
+
+	t.Run("sent events are not claimed again", func(t *testing.T) {
+		repo := newRepo(t)
+		add(t, repo, 2)
+
+		claimed, err := repo.Claim("a", 10, time.Millisecond)
+		require.NoError(t, err)
+		require.NoError(t, repo.MarkSent(claimed[0].ID, "a"))
+		// Only the claim owner can mark an event sent
+		require.NoError(t, repo.MarkSent(claimed[1].ID, "b"))
+		time.Sleep(5 * time.Millisecond)
+
+		claimed, err = repo.Claim("a", 10, time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{2}, ids(claimed))
+	})
+
+	t.Run("released events are claimed again", func(t *testing.T) {
+		repo := newRepo(t)
+		add(t, repo, 1)
+
+		claimed, err := repo.Claim("a", 10, time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, repo.Release(claimed[0].ID, "a", assert.AnError))
+
+		claimed, err = repo.Claim("b", 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, claimed, 1)
+		require.NotNil(t, claimed[0].LastError)
+		assert.Equal(t, assert.AnError.Error(), *claimed[0].LastError)
+	})
+
+	t.Run("dead events are not claimed again", func(t *testing.T) {
+		repo := newRepo(t)
+		add(t, repo, 2)
+
+		claimed, err := repo.Claim("a", 10, time.Millisecond)
+		require.NoError(t, err)
+		require.NoError(t, repo.MarkDead(claimed[0].ID, "a", assert.AnError))
+		// Only the claim owner can mark an event dead
+		require.NoError(t, repo.MarkDead(claimed[1].ID, "b", assert.AnError))
+		time.Sleep(5 * time.Millisecond)
+
+		claimed, err = repo.Claim("a", 10, time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{2}, ids(claimed))
+	})
+}
+
+func TestMemoryOutboxRepository_Contract(t *testing.T) {
+	testOutboxRepositoryContract(t, func(t *testing.T) OutboxRepository {
+		return NewMemoryStore().Outbox()
+	})
+}
+
+// TestSQLOutboxRepository_Contract runs against the test database
+func TestSQLOutboxRepository_Contract(t *testing.T) {
+	db := testutil.Open(t)
+	store := NewSQLStore(db)
+	testOutboxRepositoryContract(t, func(t *testing.T) OutboxRepository {
+		_, err := db.Exec("TRUNCATE outbox RESTART IDENTITY")
+		require.NoError(t, err)
+		return store.Outbox()
+	})
+}
+
+// testRecoveryCodeRepositoryContract checks the one-time use semantics
+// every RecoveryCodeRepository implementation must share
+func testRecoveryCodeRepositoryContract(t *testing.T, newStore func(t *testing.T) Store) {
+	setup := func(t *testing.T) (RecoveryCodeRepository, int) {
+		store := newStore(t)
+		user := &models.User{Username: "testuser", Email: "test@example.com", Password: "hash"}
+		user.BeforeInsert()
+		require.NoError(t, store.Users().Create(user))
+		return store.RecoveryCodes(), user.ID
+	}
+
+	t.Run("codes are consumed once", func(t *testing.T) {
+		repo, userID := setup(t)
+		require.NoError(t, repo.Replace(userID, []string{"hash-a", "hash-b"}))
+
+		consumed, err := repo.Consume(userID, "hash-a")
+		require.NoError(t, err)
+		assert.True(t, consumed)
+
+		consumed, err = repo.Consume(userID, "hash-a")
+		require.NoError(t, err)
+		assert.False(t, consumed)
+
+		consumed, err = repo.Consume(userID, "hash-b")
+		require.NoError(t, err)
+		assert.True(t, consumed)
+	})
+
+	t.Run("unknown codes and other users' codes are rejected", func(t *testing.T) {
+		repo, userID := setup(t)
+		require.NoError(t, repo.Replace(userID, []string{"hash-a"}))
+
+		consumed, err := repo.Consume(userID, "hash-x")
+		require.NoError(t, err)
+		assert.False(t, consumed)
+
+		consumed, err = repo.Consume(userID+1, "hash-a")
+		require.NoError(t, err)
+		assert.False(t, consumed)
+	})
+
+	t.Run("replace discards previous codes", func(t *testing.T) {
+		repo, userID := setup(t)
+		require.NoError(t, repo.Replace(userID, []string{"hash-a"}))
+		require.NoError(t, repo.Replace(userID, []string{"hash-b"}))
+
+		consumed, err := repo.Consume(userID, "hash-a")
+		require.NoError(t, err)
+		assert.False(t, consumed)
+
+		consumed, err = repo.Consume(userID, "hash-b")
+		require.NoError(t, err)
+		assert.True(t, consumed)
+	})
+
+	t.Run("list by user", func(t *testing.T) {
+		repo, userID := setup(t)
+		require.NoError(t, repo.Replace(userID, []string{"hash-a", "hash-b"}))
+		_, err := repo.Consume(userID, "hash-a")
+		require.NoError(t, err)
+
+		codes, err := repo.ListByUser(userID)
+		require.NoError(t, err)
+		require.Len(t, codes, 2)
+		used := 0
+		for _, code := range codes {
+			assert.Equal(t, userID, code.UserID)
+			if code.UsedAt != nil {
+				used++
+			}
+		}
+		assert.Equal(t, 1, used)
+
+		codes, err = repo.ListByUser(userID + 1)
+		require.NoError(t, err)
+		assert.Empty(t, codes)
+	})
+}
+
+func TestMemoryRecoveryCodeRepository_Contract(t *testing.T) {
+	testRecoveryCodeRepositoryContract(t, func(t *testing.T) Store {
+		return NewMemoryStore()
+	})
+}
+
+// TestSQLRecoveryCodeRepository_Contract runs against the test database
+func TestSQLRecoveryCodeRepository_Contract(t *testing.T) {
+	db := testutil.Open(t)
+	store := NewSQLStore(db)
+	testRecoveryCodeRepositoryContract(t, func(t *testing.T) Store {
+		_, err := db.Exec("TRUNCATE users, recovery_codes RESTART IDENTITY CASCADE")
+		require.NoError(t, err)
+		return store
+	})
+}
+
+// testWebhookRepositoryContract checks the queueing and claim semantics
+// every WebhookRepository implementation must share
+func testWebhookRepositoryContract(t *testing.T, newRepo func(t *testing.T) WebhookRepository) {
+	create := func(t *testing.T, repo WebhookRepository, active bool, eventTypes ...string) *models.Webhook {
+		now := time.Now()
+		webhook := &models.Webhook{
+			URL:        "https://example.com/hooks",
+			Secret:     "webhook-secret-0123",
+			EventTypes: eventTypes,
+			Active:     active,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		require.NoError(t, repo.Create(webhook))
+		require.NotZero(t, webhook.ID)
+		return webhook
+	}
+	queue := func(t *testing.T, repo WebhookRepository, webhookID int, eventID int64, at time.Time) {
+		require.NoError(t, repo.AddDelivery(&models.WebhookDelivery{
+			WebhookID:     webhookID,
+			EventID:       eventID,
+			EventType:     models.EventUserCreated,
+			Payload:       `{}`,
+			Status:        models.WebhookDeliveryPending,
+			NextAttemptAt: at,
+		}))
+	}
+
+	t.Run("create, update and delete", func(t *testing.T) {
+		repo := newRepo(t)
+		webhook := create(t, repo, true, models.EventUserCreated)
+
+		webhook.EventTypes = []string{models.EventUserUpdated, models.EventUserDeleted}
+		webhook.Active = false
+		require.NoError(t, repo.Update(webhook))
+
+		found, err := repo.FindByID(webhook.ID)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		assert.Equal(t, []string{models.EventUserUpdated, models.EventUserDeleted}, []string(found.EventTypes))
+		assert.False(t, found.Active)
+		assert.Equal(t, "webhook-secret-0123", found.Secret)
+
+		require.NoError(t, repo.Delete(webhook.ID))
+		found, err = repo.FindByID(webhook.ID)
+		require.NoError(t, err)
+		assert.Nil(t, found)
+		assert.ErrorIs(t, repo.Delete(webhook.ID), ErrWebhookNotFound)
+		assert.ErrorIs(t, repo.Update(webhook), ErrWebhookNotFound)
+	})
+
+	t.Run("list subscribed", func(t *testing.T) {
+		repo := newRepo(t)
+		subscribed := create(t, repo, true, models.EventUserCreated, models.EventUserDeleted)
+		create(t, repo, true, models.EventUserUpdated)
+		create(t, repo, false, models.EventUserCreated)
+
+		webhooks, err := repo.ListSubscribed(models.EventUserCreated)
+		require.NoError(t, err)
+		require.Len(t, webhooks, 1)
+		assert.Equal(t, subscribed.ID, webhooks[0].ID)
+
+		all, err := repo.List()
+		require.NoError(t, err)
+		assert.Len(t, all, 3)
+	})
+
+	t.Run("deliveries are queued once per event", func(t *testing.T) {
+		repo := newRepo(t)
+		webhook := create(t, repo, true, models.EventUserCreated)
+		queue(t, repo, webhook.ID, 1, time.Now())
+		queue(t, repo, webhook.ID, 1, time.Now())
+		queue(t, repo, webhook.ID, 2, time.Now())
+
+		deliveries, err := repo.ListDeliveries(webhook.ID, "", 10)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 2)
+		// Newest first
+		assert.Equal(t, int64(2), deliveries[0].EventID)
+	})
+
+	t.Run("claims due deliveries while leased", func(t *testing.T) {
+		repo := newRepo(t)
+		webhook := create(t, repo, true, models.EventUserCreated)
+		now := time.Now()
+		queue(t, repo, webhook.ID, 1, now.Add(-time.Minute))
+		queue(t, repo, webhook.ID, 2, now.Add(time.Hour))
+
+		claimed, err := repo.ClaimDeliveries(now, 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, claimed, 1)
+		assert.Equal(t, int64(1), claimed[0].EventID)
+		assert.Equal(t, 1, claimed[0].Attempts)
+
+		// Leased until the attempt is saved
+		again, err := repo.ClaimDeliveries(now, 10, time.Minute)
+		require.NoError(t, err)
+		assert.Empty(t, again)
+
+		status := 200
+		deliveredAt := now
+		claimed[0].Status = models.WebhookDeliveryDelivered
+		claimed[0].ResponseStatus = &status
+		claimed[0].DeliveredAt = &deliveredAt
+		require.NoError(t, repo.SaveDeliveryAttempt(claimed[0]))
+
+		delivered, err := repo.ListDeliveries(0, models.WebhookDeliveryDelivered, 10)
+		require.NoError(t, err)
+		require.Len(t, delivered, 1)
+		require.NotNil(t, delivered[0].ResponseStatus)
+		assert.Equal(t, 200, *delivered[0].ResponseStatus)
+
+		// Delivered deliveries aren't claimed again
+		again, err = repo.ClaimDeliveries(now.Add(2*time.Hour), 10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, again, 1)
+		assert.Equal(t, int64(2), again[0].EventID)
+	})
+}
+
+func TestMemoryWebhookRepository_Contract(t *testing.T) {
+	testWebhookRepositoryContract(t, func(t *testing.T) WebhookRepository {
+		return NewMemoryStore().Webhooks()
+	})
+}
+
+// TestSQLWebhookRepository_Contract runs against the test database
+func TestSQLWebhookRepository_Contract(t *testing.T) {
+	db := testutil.Open(t)
+	store := NewSQLStore(db)
+	testWebhookRepositoryContract(t, func(t *testing.T) WebhookRepository {
+		_, err := db.Exec("TRUNCATE webhooks, webhook_deliveries RESTART IDENTITY CASCADE")
+		require.NoError(t, err)
+		return store.Webhooks()
+	})
+}
+
+func TestMemoryStore_TransactionRollsBack(t *testing.T) {
+	store := NewMemoryStore()
+
+	err := store.Transaction(func(tx Store) error {
+		if err := tx.Users().Create(&models.User{Username: "testuser", Email: "test@example.com"}); err != nil {
+			return err
+		}
+		return assert.AnError
+	})
+
+	assert.ErrorIs(t, err, assert.AnError)
+
+	user, err := store.Users().FindByUsername("testuser")
+	assert.NoError(t, err)
+	assert.Nil(t, user)
+}
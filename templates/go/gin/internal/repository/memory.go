@@ -0,0 +1,967 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gin-service/internal/database"
+	"gin-service/internal/models"
+)
+
+// MemoryStore is an in-memory Store for tests and demos.
+//
+// Transactions roll back on error but are not isolated from concurrent
+// callers.
+type MemoryStore struct {
+	users *InMemoryUserStore
+
+	mu            sync.Mutex
+	auditLogs     []models.AuditLog
+	outbox        []*models.OutboxEvent
+	recoveryCodes []models.RecoveryCode
+
+	webhooks          []models.Webhook
+	webhookDeliveries []models.WebhookDelivery
+	nextWebhookID     int
+	nextDeliveryID    int64
+}
+
+// NewMemoryStore creates a new, empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users: NewInMemoryUserStore(),
+	}
+}
+
+// Users returns the user repository
+func (s *MemoryStore) Users() UserRepository {
+	return s.users
+}
+
+// AuditLogs returns the audit log repository
+func (s *MemoryStore) AuditLogs() AuditLogRepository {
+	return &memoryAuditLogRepository{store: s}
+}
+
+// Outbox returns the outbox repository
+func (s *MemoryStore) Outbox() OutboxRepository {
+	return &memoryOutboxRepository{store: s}
+}
+
+// RecoveryCodes returns the recovery code repository
+func (s *MemoryStore) RecoveryCodes() RecoveryCodeRepository {
+	return &memoryRecoveryCodeRepository{store: s}
+}
+
+// Webhooks returns the webhook repository
+func (s *MemoryStore) Webhooks() WebhookRepository {
+	return &memoryWebhookRepository{store: s}
+}
+
+// OutboxEvents returns a copy of the outbox events
+func (s *MemoryStore) OutboxEvents() []models.OutboxEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := make([]models.OutboxEvent, len(s.outbox))
+	for i, event := range s.outbox {
+		events[i] = *event
+	}
+	return events
+}
+
+// AuditLogEntries returns a copy of the recorded audit log entries
+func (s *MemoryStore) AuditLogEntries() []models.AuditLog {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]models.AuditLog(nil), s.auditLogs...)
+}
+
+// TransactionWithRetry runs fn like Transaction. Memory transactions never
+// conflict, so there is nothing to retry.
+func (s *MemoryStore) TransactionWithRetry(fn func(tx Store) error, maxRetries int) error {
+	return s.Transaction(fn)
+}
+
+// WithContext returns the store itself, since its operations never block
+func (s *MemoryStore) WithContext(ctx context.Context) Store {
+	return s
+}
+
+// Transaction runs fn, restoring the previous state if it returns an error
+func (s *MemoryStore) Transaction(fn func(tx Store) error) error {
+	users := s.users.snapshot()
+	s.mu.Lock()
+	auditLogs := len(s.auditLogs)
+	outbox := len(s.outbox)
+	recoveryCodes := append([]models.RecoveryCode(nil), s.recoveryCodes...)
+	webhooks := append([]models.Webhook(nil), s.webhooks...)
+	webhookDeliveries := append([]models.WebhookDelivery(nil), s.webhookDeliveries...)
+	s.mu.Unlock()
+
+	if err := fn(s); err != nil {
+		s.users.restore(users)
+		s.mu.Lock()
+		s.auditLogs = s.auditLogs[:auditLogs]
+		s.outbox = s.outbox[:outbox]
+		s.recoveryCodes = recoveryCodes
+		s.webhooks = webhooks
+		s.webhookDeliveries = webhookDeliveries
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// InMemoryUserStore is a UserRepository backed by a map. It enforces the
+// same uniqueness and not-found semantics as the SQL implementation, so
+// tests against it are meaningful for production behavior.
+type InMemoryUserStore struct {
+	mu     sync.Mutex
+	users  map[int]models.User
+	nextID int
+}
+
+// NewInMemoryUserStore creates a new, empty in-memory user store
+func NewInMemoryUserStore() *InMemoryUserStore {
+	return &InMemoryUserStore{
+		users: make(map[int]models.User),
+	}
+}
+
+// userSnapshot is the state of an InMemoryUserStore at a point in time
+type userSnapshot struct {
+	users  map[int]models.User
+	nextID int
+}
+
+// snapshot copies the store's state
+func (s *InMemoryUserStore) snapshot() userSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make(map[int]models.User, len(s.users))
+	for id, user := range s.users {
+		users[id] = user
+	}
+	return userSnapshot{users: users, nextID: s.nextID}
+}
+
+// restore resets the store to a snapshot
+func (s *InMemoryUserStore) restore(snap userSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users = snap.users
+	s.nextID = snap.nextID
+}
+
+// Create stores a new user and sets its ID, enforcing unique usernames and emails
+func (s *InMemoryUserStore) Create(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkUnique(user); err != nil {
+		return err
+	}
+
+	s.nextID++
+	user.ID = s.nextID
+	s.users[user.ID] = *user
+	return nil
+}
+
+// FindByID retrieves a user by ID
+func (s *InMemoryUserStore) FindByID(id int) (*models.User, error) {
+	return s.findOne(func(u *models.User) bool { return u.ID == id }), nil
+}
+
+// FindByIDs retrieves the users with the given IDs in ID order
+func (s *InMemoryUserStore) FindByIDs(ids []int) ([]*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[int]bool, len(ids))
+	users := []*models.User{}
+	for _, id := range ids {
+		if user, ok := s.users[id]; ok && !seen[id] {
+			seen[id] = true
+			users = append(users, &user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// FindByUsername retrieves a user by username
+func (s *InMemoryUserStore) FindByUsername(username string) (*models.User, error) {
+	return s.findOne(func(u *models.User) bool { return u.Username == username }), nil
+}
+
+// FindByEmail retrieves a user by email
+func (s *InMemoryUserStore) FindByEmail(email string) (*models.User, error) {
+	return s.findOne(func(u *models.User) bool { return u.Email == email }), nil
+}
+
+// findOne returns a copy of the first user matching match
+func (s *InMemoryUserStore) findOne(match func(u *models.User) bool) *models.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if match(&user) {
+			return &user
+		}
+	}
+	return nil
+}
+
+// Update saves the user's modifiable fields, returning ErrUserNotFound if it
+// does not exist
+func (s *InMemoryUserStore) Update(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.users[user.ID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if err := s.checkUnique(user); err != nil {
+		return err
+	}
+
+	existing.Username = user.Username
+	existing.Email = user.Email
+	existing.Password = user.Password
+	existing.FullName = user.FullName
+	existing.IsActive = user.IsActive
+	existing.Status = user.Status
+	existing.AvatarURL = user.AvatarURL
+	existing.TOTPSecret = user.TOTPSecret
+	existing.TOTPEnabled = user.TOTPEnabled
+	existing.PendingEmail = user.PendingEmail
+	existing.EmailChangeTokenHash = user.EmailChangeTokenHash
+	existing.EmailChangeExpiresAt = user.EmailChangeExpiresAt
+	existing.DeletionScheduledAt = user.DeletionScheduledAt
+	existing.UpdatedAt = user.UpdatedAt
+	s.users[user.ID] = existing
+	return nil
+}
+
+// checkUnique mirrors the unique constraints of the users table
+func (s *InMemoryUserStore) checkUnique(user *models.User) error {
+	for id, other := range s.users {
+		if id == user.ID {
+			continue
+		}
+		if other.Username == user.Username {
+			return ErrDuplicateUsername
+		}
+		if other.Email == user.Email {
+			return ErrDuplicateEmail
+		}
+	}
+	return nil
+}
+
+// Delete deletes a user, returning ErrUserNotFound if it does not exist
+func (s *InMemoryUserStore) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return ErrUserNotFound
+	}
+	delete(s.users, id)
+	return nil
+}
+
+// List retrieves users with filtering and pagination, newest first
+func (s *InMemoryUserStore) List(filter *models.UserFilter, pagination *database.Paginate) ([]*models.User, error) {
+	pagination.CalculateOffset()
+
+	if filter != nil {
+		for _, cond := range filter.Conditions {
+			if err := models.UserFilterFields.Validate(cond); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	s.mu.Lock()
+	var matched []*models.User
+	for _, user := range s.users {
+		if matchesFilter(&user, filter) {
+			user := user
+			matched = append(matched, &user)
+		}
+	}
+	s.mu.Unlock()
+
+	// Break created_at ties by ID so pages are deterministic
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+			return matched[i].CreatedAt.After(matched[j].CreatedAt)
+		}
+		return matched[i].ID > matched[j].ID
+	})
+
+	pagination.SetTotal(len(matched))
+
+	start := pagination.Offset
+	if start > len(matched) {
+		start = len(matched)
+	}
+	end := start + pagination.Limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	return matched[start:end], nil
+}
+
+// Search ranks users the way the SQL store does without pg_trgm
+func (s *InMemoryUserStore) Search(query string, limit int) ([]*models.User, error) {
+	type ranked struct {
+		user *models.User
+		rank int
+	}
+
+	s.mu.Lock()
+	var matched []ranked
+	for _, user := range s.users {
+		if rank, ok := searchRank(&user, query); ok {
+			user := user
+			matched = append(matched, ranked{&user, rank})
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if len(a.user.Username) != len(b.user.Username) {
+			return len(a.user.Username) < len(b.user.Username)
+		}
+		return a.user.ID < b.user.ID
+	})
+
+	users := make([]*models.User, 0, limit)
+	for i := 0; i < len(matched) && i < limit; i++ {
+		users = append(users, matched[i].user)
+	}
+	return users, nil
+}
+
+// searchRank mirrors the ranking of the SQL search, reporting whether user
+// matches query at all
+func searchRank(user *models.User, query string) (int, bool) {
+	query = strings.ToLower(query)
+	username := strings.ToLower(user.Username)
+	email := strings.ToLower(user.Email)
+	fullName := ""
+	if user.FullName != nil {
+		fullName = strings.ToLower(*user.FullName)
+	}
+
+	switch {
+	case username == query || email == query:
+		return 0, true
+	case strings.HasPrefix(username, query):
+		return 1, true
+	case strings.Contains(username, query) || strings.Contains(email, query):
+		return 2, true
+	case strings.Contains(fullName, query):
+		return 3, true
+	}
+	return 0, false
+}
+
+// matchesFilter applies filter the way buildWhereClause does in SQL
+func matchesFilter(user *models.User, filter *models.UserFilter) bool {
+	if filter == nil {
+		return true
+	}
+
+	contains := func(s, substr string) bool {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+	}
+
+	if filter.Username != nil && !contains(user.Username, *filter.Username) {
+		return false
+	}
+	if filter.Email != nil && !contains(user.Email, *filter.Email) {
+		return false
+	}
+	if filter.IsActive != nil && user.IsActive != *filter.IsActive {
+		return false
+	}
+	if filter.IsAdmin != nil && user.IsAdmin != *filter.IsAdmin {
+		return false
+	}
+	if filter.Search != nil {
+		fullName := ""
+		if user.FullName != nil {
+			fullName = *user.FullName
+		}
+		if !contains(user.Username, *filter.Search) && !contains(user.Email, *filter.Search) && !contains(fullName, *filter.Search) {
+			return false
+		}
+	}
+	if filter.CreatedAfter != nil && user.CreatedAt.Before(*filter.CreatedAfter) {
+		return false
+	}
+	if filter.CreatedBefore != nil && user.CreatedAt.After(*filter.CreatedBefore) {
+		return false
+	}
+	if cutoff, ok := filter.LastLoginCutoff(time.Now()); ok && user.LastLogin != nil && !user.LastLogin.Before(cutoff) {
+		return false
+	}
+	for _, cond := range filter.Conditions {
+		if !matchesCondition(user, cond) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesCondition evaluates a validated condition the way SQL does: like
+// is a case-insensitive substring match, and comparisons with a NULL column
+// never match
+func matchesCondition(user *models.User, cond models.Condition) bool {
+	var fieldValue interface{}
+	switch cond.Field {
+	case "id":
+		fieldValue = user.ID
+	case "username":
+		fieldValue = user.Username
+	case "email":
+		fieldValue = user.Email
+	case "full_name":
+		if user.FullName == nil {
+			return false
+		}
+		fieldValue = *user.FullName
+	case "is_active":
+		fieldValue = user.IsActive
+	case "is_admin":
+		fieldValue = user.IsAdmin
+	case "created_at":
+		fieldValue = user.CreatedAt
+	case "updated_at":
+		fieldValue = user.UpdatedAt
+	case "last_login":
+		if user.LastLogin == nil {
+			return false
+		}
+		fieldValue = *user.LastLogin
+	default:
+		return false
+	}
+
+	if cond.Operator == models.OpLike {
+		return strings.Contains(strings.ToLower(fieldValue.(string)), strings.ToLower(cond.Value.(string)))
+	}
+
+	var cmp int
+	switch v := fieldValue.(type) {
+	case int:
+		cmp = v - cond.Value.(int)
+	case string:
+		cmp = strings.Compare(v, cond.Value.(string))
+	case bool:
+		if v != cond.Value.(bool) {
+			cmp = 1
+		}
+	case time.Time:
+		cmp = v.Compare(cond.Value.(time.Time))
+	}
+
+	switch cond.Operator {
+	case models.OpEq:
+		return cmp == 0
+	case models.OpNe:
+		return cmp != 0
+	case models.OpGt:
+		return cmp > 0
+	case models.OpGte:
+		return cmp >= 0
+	case models.OpLt:
+		return cmp < 0
+	case models.OpLte:
+		return cmp <= 0
+	}
+	return false
+}
+
+// AdminExists reports whether any admin user exists
+func (s *InMemoryUserStore) AdminExists() (bool, error) {
+	return s.findOne(func(u *models.User) bool { return u.IsAdmin }) != nil, nil
+}
+
+// FindDeletionDue returns the users whose scheduled deletion is due at at
+func (s *InMemoryUserStore) FindDeletionDue(at time.Time) ([]*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var users []*models.User
+	for _, user := range s.users {
+		if user.DeletionScheduledAt != nil && !user.DeletionScheduledAt.After(at) {
+			user := user
+			users = append(users, &user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].DeletionScheduledAt.Equal(*users[j].DeletionScheduledAt) {
+			return users[i].DeletionScheduledAt.Before(*users[j].DeletionScheduledAt)
+		}
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+// CountAdmins returns the number of admin users
+func (s *InMemoryUserStore) CountAdmins() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, user := range s.users {
+		if user.IsAdmin {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// SetAdmin grants or revokes the user's admin role, returning ErrUserNotFound
+// if it does not exist
+func (s *InMemoryUserStore) SetAdmin(id int, isAdmin bool, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.IsAdmin = isAdmin
+	user.UpdatedAt = at
+	s.users[id] = user
+	return nil
+}
+
+// UpdateLastLogin sets the user's last login timestamp
+func (s *InMemoryUserStore) UpdateLastLogin(id int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[id]; ok {
+		user.LastLogin = &at
+		s.users[id] = user
+	}
+	return nil
+}
+
+// UpdatePasswordHash replaces the user's password hash
+func (s *InMemoryUserStore) UpdatePasswordHash(id int, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[id]; ok {
+		user.Password = hash
+		s.users[id] = user
+	}
+	return nil
+}
+
+// UseTOTPStep records step as the user's last TOTP step if it is later than
+// the last one
+func (s *InMemoryUserStore) UseTOTPStep(id int, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || (user.TOTPLastStep != nil && *user.TOTPLastStep >= step) {
+		return false, nil
+	}
+	user.TOTPLastStep = &step
+	s.users[id] = user
+	return true, nil
+}
+
+// memoryAuditLogRepository is an AuditLogRepository backed by a MemoryStore
+type memoryAuditLogRepository struct {
+	store *MemoryStore
+}
+
+// Create stores an audit log entry
+func (r *memoryAuditLogRepository) Create(entry *models.AuditLog) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	entry.ID = len(r.store.auditLogs) + 1
+	entry.CreatedAt = time.Now()
+	r.store.auditLogs = append(r.store.auditLogs, *entry)
+	return nil
+}
+
+// ListByUser returns the entries whose subject or actor is the user
+func (r *memoryAuditLogRepository) ListByUser(userID int) ([]*models.AuditLog, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var entries []*models.AuditLog
+	for _, entry := range r.store.auditLogs {
+		if (entry.UserID != nil && *entry.UserID == userID) || (entry.ActorID != nil && *entry.ActorID == userID) {
+			entry := entry
+			entries = append(entries, &entry)
+		}
+	}
+	return entries, nil
+}
+
+// memoryOutboxRepository is an OutboxRepository backed by a MemoryStore
+type memoryOutboxRepository struct {
+	store *MemoryStore
+}
+
+// Add stores an outbox event
+func (r *memoryOutboxRepository) Add(event *models.OutboxEvent) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	event.ID = int64(len(r.store.outbox) + 1)
+	event.CreatedAt = time.Now()
+	stored := *event
+	r.store.outbox = append(r.store.outbox, &stored)
+	return nil
+}
+
+// Claim claims unsent events in id order
+func (r *memoryOutboxRepository) Claim(owner string, limit int, lease time.Duration) ([]*models.OutboxEvent, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	now := time.Now()
+	var claimed []*models.OutboxEvent
+	for _, event := range r.store.outbox {
+		if len(claimed) == limit {
+			break
+		}
+		if event.SentAt != nil || event.DeadAt != nil || (event.ClaimedAt != nil && now.Sub(*event.ClaimedAt) <= lease) {
+			continue
+		}
+
+		claimedBy := owner
+		claimedAt := now
+		event.ClaimedBy = &claimedBy
+		event.ClaimedAt = &claimedAt
+		event.Attempts++
+
+		copied := *event
+		claimed = append(claimed, &copied)
+	}
+	return claimed, nil
+}
+
+// MarkSent marks an event as published
+func (r *memoryOutboxRepository) MarkSent(id int64, owner string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if event := r.claimedBy(id, owner); event != nil {
+		sentAt := time.Now()
+		event.SentAt = &sentAt
+	}
+	return nil
+}
+
+// Release clears the claim on an event, recording why publishing failed
+func (r *memoryOutboxRepository) Release(id int64, owner string, cause error) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if event := r.claimedBy(id, owner); event != nil && event.SentAt == nil {
+		lastError := cause.Error()
+		event.ClaimedBy = nil
+		event.ClaimedAt = nil
+		event.LastError = &lastError
+	}
+	return nil
+}
+
+// MarkDead marks an event as dead, recording why publishing it failed
+func (r *memoryOutboxRepository) MarkDead(id int64, owner string, cause error) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if event := r.claimedBy(id, owner); event != nil && event.SentAt == nil {
+		lastError := cause.Error()
+		deadAt := time.Now()
+		event.ClaimedBy = nil
+		event.ClaimedAt = nil
+		event.DeadAt = &deadAt
+		event.LastError = &lastError
+	}
+	return nil
+}
+
+// claimedBy returns the event with id if owner holds its claim
+func (r *memoryOutboxRepository) claimedBy(id int64, owner string) *models.OutboxEvent {
+	if id < 1 || id > int64(len(r.store.outbox)) {
+		return nil
+	}
+	event := r.store.outbox[id-1]
+	if event.ClaimedBy == nil || *event.ClaimedBy != owner {
+		return nil
+	}
+	return event
+}
+
+// memoryRecoveryCodeRepository is a RecoveryCodeRepository backed by a
+// MemoryStore
+type memoryRecoveryCodeRepository struct {
+	store *MemoryStore
+}
+
+// Replace discards the user's codes and stores the new hashes
+func (r *memoryRecoveryCodeRepository) Replace(userID int, hashes []string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	codes := r.store.recoveryCodes[:0:0]
+	for _, code := range r.store.recoveryCodes {
+		if code.UserID != userID {
+			codes = append(codes, code)
+		}
+	}
+
+	now := time.Now()
+	for _, hash := range hashes {
+		codes = append(codes, models.RecoveryCode{
+			ID:        int64(len(codes) + 1),
+			UserID:    userID,
+			CodeHash:  hash,
+			CreatedAt: now,
+		})
+	}
+	r.store.recoveryCodes = codes
+	return nil
+}
+
+// Consume marks the user's unused code with the given hash as used
+func (r *memoryRecoveryCodeRepository) Consume(userID int, hash string) (bool, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for i := range r.store.recoveryCodes {
+		code := &r.store.recoveryCodes[i]
+		if code.UserID == userID && code.CodeHash == hash && code.UsedAt == nil {
+			now := time.Now()
+			code.UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ListByUser returns the user's codes
+func (r *memoryRecoveryCodeRepository) ListByUser(userID int) ([]models.RecoveryCode, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var codes []models.RecoveryCode
+	for _, code := range r.store.recoveryCodes {
+		if code.UserID == userID {
+			codes = append(codes, code)
+		}
+	}
+	return codes, nil
+}
+
+// memoryWebhookRepository is a WebhookRepository backed by a MemoryStore
+type memoryWebhookRepository struct {
+	store *MemoryStore
+}
+
+// Create stores a webhook and sets its ID
+func (r *memoryWebhookRepository) Create(webhook *models.Webhook) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	r.store.nextWebhookID++
+	webhook.ID = r.store.nextWebhookID
+	r.store.webhooks = append(r.store.webhooks, copyWebhook(webhook))
+	return nil
+}
+
+// FindByID retrieves a webhook by ID
+func (r *memoryWebhookRepository) FindByID(id int) (*models.Webhook, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, webhook := range r.store.webhooks {
+		if webhook.ID == id {
+			found := copyWebhook(&webhook)
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+// List returns all webhooks in id order
+func (r *memoryWebhookRepository) List() ([]*models.Webhook, error) {
+	return r.list(func(*models.Webhook) bool { return true }), nil
+}
+
+// ListSubscribed returns the active webhooks subscribed to eventType
+func (r *memoryWebhookRepository) ListSubscribed(eventType string) ([]*models.Webhook, error) {
+	return r.list(func(webhook *models.Webhook) bool { return webhook.Subscribes(eventType) }), nil
+}
+
+// list returns copies of the webhooks matching match
+func (r *memoryWebhookRepository) list(match func(*models.Webhook) bool) []*models.Webhook {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var webhooks []*models.Webhook
+	for _, webhook := range r.store.webhooks {
+		if match(&webhook) {
+			found := copyWebhook(&webhook)
+			webhooks = append(webhooks, &found)
+		}
+	}
+	return webhooks
+}
+
+// Update replaces a webhook, returning ErrWebhookNotFound if it does not
+// exist
+func (r *memoryWebhookRepository) Update(webhook *models.Webhook) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for i := range r.store.webhooks {
+		if r.store.webhooks[i].ID == webhook.ID {
+			r.store.webhooks[i] = copyWebhook(webhook)
+			return nil
+		}
+	}
+	return ErrWebhookNotFound
+}
+
+// Delete deletes a webhook and its deliveries, returning ErrWebhookNotFound
+// if it does not exist
+func (r *memoryWebhookRepository) Delete(id int) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	webhooks := r.store.webhooks[:0:0]
+	for _, webhook := range r.store.webhooks {
+		if webhook.ID != id {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	if len(webhooks) == len(r.store.webhooks) {
+		return ErrWebhookNotFound
+	}
+	r.store.webhooks = webhooks
+
+	deliveries := r.store.webhookDeliveries[:0:0]
+	for _, delivery := range r.store.webhookDeliveries {
+		if delivery.WebhookID != id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	r.store.webhookDeliveries = deliveries
+	return nil
+}
+
+// copyWebhook copies a webhook, so that the stored event types aren't
+// shared with callers
+func copyWebhook(webhook *models.Webhook) models.Webhook {
+	copied := *webhook
+	copied.EventTypes = append(copied.EventTypes[:0:0], webhook.EventTypes...)
+	return copied
+}
+
+// AddDelivery stores a pending delivery, ignoring duplicates
+func (r *memoryWebhookRepository) AddDelivery(delivery *models.WebhookDelivery) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, queued := range r.store.webhookDeliveries {
+		if queued.WebhookID == delivery.WebhookID && queued.EventID == delivery.EventID {
+			return nil
+		}
+	}
+
+	r.store.nextDeliveryID++
+	delivery.ID = r.store.nextDeliveryID
+	delivery.CreatedAt = time.Now()
+	r.store.webhookDeliveries = append(r.store.webhookDeliveries, *delivery)
+	return nil
+}
+
+// ClaimDeliveries claims pending deliveries due at now in id order
+func (r *memoryWebhookRepository) ClaimDeliveries(now time.Time, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var claimed []*models.WebhookDelivery
+	for i := range r.store.webhookDeliveries {
+		if len(claimed) == limit {
+			break
+		}
+		delivery := &r.store.webhookDeliveries[i]
+		if delivery.Status != models.WebhookDeliveryPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+
+		delivery.NextAttemptAt = now.Add(lease)
+		delivery.Attempts++
+
+		copied := *delivery
+		claimed = append(claimed, &copied)
+	}
+	return claimed, nil
+}
+
+// SaveDeliveryAttempt updates the delivery with the attempt's outcome
+func (r *memoryWebhookRepository) SaveDeliveryAttempt(delivery *models.WebhookDelivery) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for i := range r.store.webhookDeliveries {
+		stored := &r.store.webhookDeliveries[i]
+		if stored.ID == delivery.ID {
+			stored.Status = delivery.Status
+			stored.NextAttemptAt = delivery.NextAttemptAt
+			stored.LastError = delivery.LastError
+			stored.ResponseStatus = delivery.ResponseStatus
+			stored.DeliveredAt = delivery.DeliveredAt
+			return nil
+		}
+	}
+	return nil
+}
+
+// ListDeliveries returns deliveries by webhook and status, newest first
+func (r *memoryWebhookRepository) ListDeliveries(webhookID int, status string, limit int) ([]*models.WebhookDelivery, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var deliveries []*models.WebhookDelivery
+	for i := len(r.store.webhookDeliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		delivery := r.store.webhookDeliveries[i]
+		if (webhookID == 0 || delivery.WebhookID == webhookID) && (status == "" || delivery.Status == status) {
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"time"
+
+	"gin-service/internal/database"
+	"gin-service/internal/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// QueryDuration observes how long user repository operations take, by
+// operation name. Operations are labeled with fixed names rather than their
+// SQL, keeping the number of series small.
+var QueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "gin_service",
+	Name:      "db_query_duration_seconds",
+	Help:      "Duration of database operations on users, by operation.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"operation"})
+
+// observeQuery records the time since start under operation. It is meant to
+// be deferred at the start of the operation.
+func observeQuery(operation string, start time.Time) {
+	QueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// timedUserRepository is a UserRepository recording the duration of every
+// operation of the repository it wraps
+type timedUserRepository struct {
+	next UserRepository
+}
+
+var _ UserRepository = timedUserRepository{}
+
+func (r timedUserRepository) Create(user *models.User) error {
+	defer observeQuery("create", time.Now())
+	return r.next.Create(user)
+}
+
+func (r timedUserRepository) FindByID(id int) (*models.User, error) {
+	defer observeQuery("get_by_id", time.Now())
+	return r.next.FindByID(id)
+}
+
+func (r timedUserRepository) FindByIDs(ids []int) ([]*models.User, error) {
+	defer observeQuery("get_by_ids", time.Now())
+	return r.next.FindByIDs(ids)
+}
+
+func (r timedUserRepository) FindByUsername(username string) (*models.User, error) {
+	defer observeQuery("get_by_username", time.Now())
+	return r.next.FindByUsername(username)
+}
+
+func (r timedUserRepository) FindByEmail(email string) (*models.User, error) {
+	defer observeQuery("get_by_email", time.Now())
+	return r.next.FindByEmail(email)
+}
+
+func (r timedUserRepository) Update(user *models.User) error {
+	defer observeQuery("update", time.Now())
+	return r.next.Update(user)
+}
+
+func (r timedUserRepository) Delete(id int) error {
+	defer observeQuery("delete", time.Now())
+	return r.next.Delete(id)
+}
+
+func (r timedUserRepository) List(filter *models.UserFilter, pagination *database.Paginate) ([]*models.User, error) {
+	defer observeQuery("list", time.Now())
+	return r.next.List(filter, pagination)
+}
+
+func (r timedUserRepository) Search(query string, limit int) ([]*models.User, error) {
+	defer observeQuery("search", time.Now())
+	return r.next.Search(query, limit)
+}
+
+func (r timedUserRepository) AdminExists() (bool, error) {
+	defer observeQuery("admin_exists", time.Now())
+	return r.next.AdminExists()
+}
+
+func (r timedUserRepository) FindDeletionDue(at time.Time) ([]*models.User, error) {
+	defer observeQuery("find_deletion_due", time.Now())
+	return r.next.FindDeletionDue(at)
+}
+
+func (r timedUserRepository) CountAdmins() (int, error) {
+	defer observeQuery("count_admins", time.Now())
+	return r.next.CountAdmins()
+}
+
+func (r timedUserRepository) SetAdmin(id int, isAdmin bool, at time.Time) error {
+	defer observeQuery("set_admin", time.Now())
+	return r.next.SetAdmin(id, isAdmin, at)
+}
+
+func (r timedUserRepository) UpdateLastLogin(id int, at time.Time) error {
+	defer observeQuery("update_last_login", time.Now())
+	return r.next.UpdateLastLogin(id, at)
+}
+
+func (r timedUserRepository) UpdatePasswordHash(id int, hash string) error {
+	defer observeQuery("update_password_hash", time.Now())
+	return r.next.UpdatePasswordHash(id, hash)
+}
+
+func (r timedUserRepository) UseTOTPStep(id int, step int64) (bool, error) {
+	defer observeQuery("use_totp_step", time.Now())
+	return r.next.UseTOTPStep(id, step)
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gin-service/internal/models"
+)
+
+// OutboxRepository persists outbox events and hands them out to publishers
+type OutboxRepository interface {
+	// Add writes an event. Within a transaction it is only visible to
+	// publishers once the transaction commits.
+	Add(event *models.OutboxEvent) error
+	// Claim claims up to limit unsent events that aren't dead for owner, in
+	// id order, counting an attempt for each. Events
+	// claimed by another owner are skipped until their claim is older than
+	// lease, so a crashed publisher's events are eventually retried.
+	Claim(owner string, limit int, lease time.Duration) ([]*models.OutboxEvent, error)
+	// MarkSent marks an event claimed by owner as published
+	MarkSent(id int64, owner string) error
+	// Release gives up owner's claim on an event after a failed publish
+	Release(id int64, owner string, cause error) error
+	// MarkDead gives up on publishing an event claimed by owner, recording
+	// why its last attempt failed. Dead events are never claimed again.
+	MarkDead(id int64, owner string, cause error) error
+}
+
+// sqlOutboxRepository is an OutboxRepository backed by a SQLStore
+type sqlOutboxRepository struct {
+	store *SQLStore
+}
+
+// Add inserts an outbox event and sets its ID
+func (r *sqlOutboxRepository) Add(event *models.OutboxEvent) error {
+	query := `
+		INSERT INTO outbox (event_type, aggregate_id, payload)
+		VALUES (:event_type, :aggregate_id, :payload)
+		RETURNING id`
+
+	rows, err := r.store.q.NamedQuery(query, event)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&event.ID); err != nil {
+			return fmt.Errorf("failed to scan outbox event ID: %w", err)
+		}
+	}
+
+	return rows.Err()
+}
+
+// Claim claims unsent events. SKIP LOCKED lets concurrent pollers claim
+// disjoint batches without waiting on each other.
+func (r *sqlOutboxRepository) Claim(owner string, limit int, lease time.Duration) ([]*models.OutboxEvent, error) {
+	query := `
+		UPDATE outbox
+		SET claimed_by = $1, claimed_at = NOW(), attempts = attempts + 1
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE sent_at IS NULL AND dead_at IS NULL
+				AND (claimed_at IS NULL OR claimed_at < NOW() - $2::bigint * INTERVAL '1 millisecond')
+			ORDER BY id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`
+
+	var events []*models.OutboxEvent
+	if err := r.store.q.Select(&events, query, owner, lease.Milliseconds(), limit); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not preserve the subquery's order
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
+
+// MarkSent marks an event as published
+func (r *sqlOutboxRepository) MarkSent(id int64, owner string) error {
+	query := `UPDATE outbox SET sent_at = NOW() WHERE id = $1 AND claimed_by = $2`
+	_, err := r.store.q.Exec(query, id, owner)
+	return err
+}
+
+// Release clears the claim on an event, recording why publishing failed
+func (r *sqlOutboxRepository) Release(id int64, owner string, cause error) error {
+	query := `
+		UPDATE outbox
+		SET claimed_by = NULL, claimed_at = NULL, last_error = $3
+		WHERE id = $1 AND claimed_by = $2 AND sent_at IS NULL`
+	_, err := r.store.q.Exec(query, id, owner, cause.Error())
+	return err
+}
+
+// MarkDead marks an event as dead, recording why publishing it failed
+func (r *sqlOutboxRepository) MarkDead(id int64, owner string, cause error) error {
+	query := `
+		UPDATE outbox
+		SET claimed_by = NULL, claimed_at = NULL, dead_at = NOW(), last_error = $3
+		WHERE id = $1 AND claimed_by = $2 AND sent_at IS NULL`
+	_, err := r.store.q.Exec(query, id, owner, cause.Error())
+	return err
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"fmt"
+
+	"gin-service/internal/models"
+)
+
+// RecoveryCodeRepository persists users' hashed 2FA recovery codes
+type RecoveryCodeRepository interface {
+	// Replace discards the user's codes, used or not, and stores hashes
+	Replace(userID int, hashes []string) error
+	// Consume marks the user's unused code with the given hash as used. It
+	// reports false if there is no such code, including when it was already
+	// used, so each code works once even under concurrent logins.
+	Consume(userID int, hash string) (bool, error)
+	// ListByUser returns the user's codes, used or not, oldest first
+	ListByUser(userID int) ([]models.RecoveryCode, error)
+}
+
+// sqlRecoveryCodeRepository is a RecoveryCodeRepository backed by a SQLStore
+type sqlRecoveryCodeRepository struct {
+	store *SQLStore
+}
+
+// Replace deletes the user's codes and inserts the new hashes
+func (r *sqlRecoveryCodeRepository) Replace(userID int, hashes []string) error {
+	if _, err := r.store.q.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		query := `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+		if _, err := r.store.q.Exec(query, userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Consume marks an unused code as used in a single conditional update
+func (r *sqlRecoveryCodeRepository) Consume(userID int, hash string) (bool, error) {
+	query := `
+		UPDATE recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	result, err := r.store.q.Exec(query, userID, hash)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected == 1, nil
+}
+
+// ListByUser selects the user's codes
+func (r *sqlRecoveryCodeRepository) ListByUser(userID int) ([]models.RecoveryCode, error) {
+	query := `
+		SELECT id, user_id, code_hash, created_at, used_at
+		FROM recovery_codes
+		WHERE user_id = $1
+		ORDER BY created_at, id`
+
+	var codes []models.RecoveryCode
+	err := r.store.read(func() error {
+		codes = nil
+		return r.store.q.Select(&codes, query, userID)
+	})
+	return codes, err
+}
@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+
+	"gin-service/internal/database"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Store provides the repositories and runs units of work across them
+type Store interface {
+	Users() UserRepository
+	AuditLogs() AuditLogRepository
+	Outbox() OutboxRepository
+	RecoveryCodes() RecoveryCodeRepository
+	Webhooks() WebhookRepository
+	// Transaction runs fn with a store whose repositories all write within a
+	// single transaction. A store already bound to a transaction joins it.
+	Transaction(fn func(tx Store) error) error
+	// TransactionWithRetry runs fn like Transaction, rolling back and running
+	// it again up to maxRetries times when it is aborted by a serialization
+	// failure or deadlock. A store already bound to a transaction joins it
+	// without retrying, leaving retries to whoever started it.
+	TransactionWithRetry(fn func(tx Store) error, maxRetries int) error
+	// WithContext returns a store whose queries are cancelled when ctx is
+	// done, typically when the request they serve times out
+	WithContext(ctx context.Context) Store
+}
+
+// SQLStore is a Store backed by a sqlx database
+type SQLStore struct {
+	db    database.DBInterface
+	q     database.Queryer
+	tx    *sqlx.Tx
+	ctx   context.Context
+	retry database.RetryPolicy
+	// trigram enables ranking user searches with pg_trgm
+	trigram bool
+}
+
+// NewSQLStore creates a new SQL store
+func NewSQLStore(db database.DBInterface) *SQLStore {
+	return &SQLStore{
+		db: db,
+		q:  db,
+	}
+}
+
+// SetRetryPolicy sets the policy used to retry read queries that fail with
+// connection errors
+func (s *SQLStore) SetRetryPolicy(policy database.RetryPolicy) {
+	s.retry = policy
+}
+
+// SetTrigramSearch makes user searches use the pg_trgm extension, which
+// must be installed
+func (s *SQLStore) SetTrigramSearch(enabled bool) {
+	s.trigram = enabled
+}
+
+// Users returns the user repository, which records the duration of its
+// operations in QueryDuration
+func (s *SQLStore) Users() UserRepository {
+	return timedUserRepository{next: &sqlUserRepository{store: s}}
+}
+
+// AuditLogs returns the audit log repository
+func (s *SQLStore) AuditLogs() AuditLogRepository {
+	return &sqlAuditLogRepository{store: s}
+}
+
+// Outbox returns the outbox repository
+func (s *SQLStore) Outbox() OutboxRepository {
+	return &sqlOutboxRepository{store: s}
+}
+
+// RecoveryCodes returns the recovery code repository
+func (s *SQLStore) RecoveryCodes() RecoveryCodeRepository {
+	return &sqlRecoveryCodeRepository{store: s}
+}
+
+// Webhooks returns the webhook repository
+func (s *SQLStore) Webhooks() WebhookRepository {
+	return &sqlWebhookRepository{store: s}
+}
+
+// Transaction runs fn within a database transaction
+func (s *SQLStore) Transaction(fn func(tx Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	return s.db.Transaction(func(tx *sqlx.Tx) error {
+		return fn(s.withTx(tx))
+	})
+}
+
+// TransactionWithRetry runs fn within a database transaction, retrying with
+// the store's backoff delays when the transaction is aborted by a
+// serialization failure or deadlock. Other errors are returned at once.
+func (s *SQLStore) TransactionWithRetry(fn func(tx Store) error, maxRetries int) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	policy := s.retry
+	policy.MaxAttempts = maxRetries + 1
+
+	var lastErr error
+	return policy.DoWhen(s.retryContext(), database.IsSerializationFailure, func() error {
+		if lastErr != nil {
+			database.RecordTransactionRetry(lastErr)
+		}
+		if s.ctx != nil {
+			if err := s.ctx.Err(); err != nil {
+				return err
+			}
+		}
+		lastErr = s.Transaction(fn)
+		return lastErr
+	})
+}
+
+// WithContext returns a copy of the store running its queries under ctx,
+// including those of transactions it starts
+func (s *SQLStore) WithContext(ctx context.Context) Store {
+	return &SQLStore{
+		db:    s.db,
+		q:     database.BindContext(ctx, s.q),
+		tx:    s.tx,
+		ctx:   ctx,
+		retry: s.retry,
+
+		trigram: s.trigram,
+	}
+}
+
+// withTx returns a copy of the store whose queries run within tx, through
+// the same wrappers, such as the query logger, as the store's own
+func (s *SQLStore) withTx(tx *sqlx.Tx) *SQLStore {
+	var q database.Queryer = database.WrapTx(s.db, tx)
+	if s.ctx != nil {
+		q = database.BindContext(s.ctx, q)
+	}
+	return &SQLStore{
+		db:    s.db,
+		q:     q,
+		tx:    tx,
+		ctx:   s.ctx,
+		retry: s.retry,
+
+		trigram: s.trigram,
+	}
+}
+
+// retryContext returns the store's context, which cuts retries short, or
+// the background context when it has none
+func (s *SQLStore) retryContext() context.Context {
+	if s.ctx != nil {
+		return s.ctx
+	}
+	return context.Background()
+}
+
+// read runs a read query, retrying on connection errors. Queries inside a
+// transaction are never retried since the transaction is lost with its
+// connection, and none are retried once the store's context is done.
+func (s *SQLStore) read(fn func() error) error {
+	if s.tx != nil {
+		return fn()
+	}
+	return s.retry.Do(s.retryContext(), func() error {
+		if s.ctx != nil {
+			if err := s.ctx.Err(); err != nil {
+				return err
+			}
+		}
+		return fn()
+	})
+}
@@ -0,0 +1,505 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gin-service/internal/database"
+	"gin-service/internal/models"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrUserNotFound is returned when a user to modify does not exist
+	ErrUserNotFound = errors.New("user not found")
+	// ErrDuplicateUsername is returned when a username is already taken
+	ErrDuplicateUsername = &ConflictError{Field: "username"}
+	// ErrDuplicateEmail is returned when an email is already taken
+	ErrDuplicateEmail = &ConflictError{Field: "email"}
+)
+
+// ConflictError reports a unique field of a user whose value another user
+// already has. It is always ErrDuplicateUsername or ErrDuplicateEmail, so
+// errors.As tells which field conflicts where errors.Is tests for one.
+type ConflictError struct {
+	// Field is the JSON name of the conflicting field
+	Field string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Field + " already exists"
+}
+
+// uniqueViolation is the Postgres error code for unique constraint violations
+const uniqueViolation = "23505"
+
+// translateError maps unique constraint violations on the users table to
+// repository errors
+func translateError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		switch pqErr.Constraint {
+		case "users_username_key":
+			return ErrDuplicateUsername
+		case "users_email_key":
+			return ErrDuplicateEmail
+		}
+	}
+	return err
+}
+
+// UserRepository persists users. Find methods return a nil user and no error
+// when no user matches.
+type UserRepository interface {
+	Create(user *models.User) error
+	FindByID(id int) (*models.User, error)
+	// FindByIDs returns the users with the given IDs in ID order, skipping
+	// IDs that don't exist
+	FindByIDs(ids []int) ([]*models.User, error)
+	FindByUsername(username string) (*models.User, error)
+	FindByEmail(email string) (*models.User, error)
+	Update(user *models.User) error
+	Delete(id int) error
+	// List returns a page of users matching filter and sets the total on pagination
+	List(filter *models.UserFilter, pagination *database.Paginate) ([]*models.User, error)
+	// Search returns up to limit users matching query, most relevant first:
+	// exact username or email matches, then username prefixes, then
+	// substrings of the username or email, then of the full name
+	Search(query string, limit int) ([]*models.User, error)
+	AdminExists() (bool, error)
+	// FindDeletionDue returns the users whose scheduled deletion is due at
+	// at, earliest first
+	FindDeletionDue(at time.Time) ([]*models.User, error)
+	// CountAdmins returns the number of admin users. Within a transaction the
+	// admins are locked until it ends.
+	CountAdmins() (int, error)
+	// SetAdmin grants or revokes the user's admin role, returning
+	// ErrUserNotFound if it does not exist
+	SetAdmin(id int, isAdmin bool, at time.Time) error
+	UpdateLastLogin(id int, at time.Time) error
+	// UpdatePasswordHash replaces the user's password hash without touching
+	// updated_at, for rehashing with newer parameters
+	UpdatePasswordHash(id int, hash string) error
+	// UseTOTPStep records step as the user's last accepted TOTP step if it
+	// is later than the last one, reporting whether it was, so each code is
+	// accepted once even under concurrent logins
+	UseTOTPStep(id int, step int64) (bool, error)
+}
+
+// sqlUserRepository is a UserRepository backed by a SQLStore
+type sqlUserRepository struct {
+	store *SQLStore
+}
+
+// Create inserts a new user and sets its ID
+func (r *sqlUserRepository) Create(user *models.User) error {
+	query := `
+		INSERT INTO users (username, email, password_hash, full_name, is_active, status, is_admin, created_at, updated_at)
+		VALUES (:username, :email, :password_hash, :full_name, :is_active, :status, :is_admin, :created_at, :updated_at)
+		RETURNING id`
+
+	rows, err := r.store.q.NamedQuery(query, user)
+	if err != nil {
+		return translateError(err)
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&user.ID); err != nil {
+			return fmt.Errorf("failed to scan user ID: %w", err)
+		}
+	}
+
+	return translateError(rows.Err())
+}
+
+// FindByID retrieves a user by ID
+func (r *sqlUserRepository) FindByID(id int) (*models.User, error) {
+	return r.findOne(`SELECT * FROM users WHERE id = $1`, id)
+}
+
+// FindByIDs retrieves the users with the given IDs in one query
+func (r *sqlUserRepository) FindByIDs(ids []int) ([]*models.User, error) {
+	users := []*models.User{}
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	query := `SELECT * FROM users WHERE id = ANY($1) ORDER BY id`
+	err := r.store.read(func() error {
+		users = nil
+		return r.store.q.Select(&users, query, pq.Array(ids))
+	})
+	return users, err
+}
+
+// FindByUsername retrieves a user by username
+func (r *sqlUserRepository) FindByUsername(username string) (*models.User, error) {
+	return r.findOne(`SELECT * FROM users WHERE username = $1`, username)
+}
+
+// FindByEmail retrieves a user by email
+func (r *sqlUserRepository) FindByEmail(email string) (*models.User, error) {
+	return r.findOne(`SELECT * FROM users WHERE email = $1`, email)
+}
+
+// findOne retrieves the single user matching query
+func (r *sqlUserRepository) findOne(query string, arg interface{}) (*models.User, error) {
+	var user models.User
+
+	err := r.store.read(func() error { return r.store.q.Get(&user, query, arg) })
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// Update saves the user's modifiable fields, returning ErrUserNotFound if it
+// does not exist
+func (r *sqlUserRepository) Update(user *models.User) error {
+	query := `
+		UPDATE users
+		SET username = :username, email = :email, password_hash = :password_hash,
+			full_name = :full_name, is_active = :is_active, status = :status, avatar_url = :avatar_url,
+			totp_secret = :totp_secret, totp_enabled = :totp_enabled,
+			pending_email = :pending_email, email_change_token_hash = :email_change_token_hash,
+			email_change_expires_at = :email_change_expires_at,
+			deletion_scheduled_at = :deletion_scheduled_at, updated_at = :updated_at
+		WHERE id = :id`
+
+	result, err := r.store.q.NamedExec(query, user)
+	if err != nil {
+		return translateError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// Delete deletes a user, returning ErrUserNotFound if it does not exist
+func (r *sqlUserRepository) Delete(id int) error {
+	query := `DELETE FROM users WHERE id = $1`
+
+	result, err := r.store.q.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// userWithTotal is a user row carrying the total number of matching rows,
+// computed with a window function in the same query
+type userWithTotal struct {
+	models.User
+	TotalCount int `db:"total_count"`
+}
+
+// List retrieves users with filtering and pagination
+func (r *sqlUserRepository) List(filter *models.UserFilter, pagination *database.Paginate) ([]*models.User, error) {
+	pagination.CalculateOffset()
+
+	// Build query with filters
+	whereClause, args, err := buildWhereClause(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get users and the total count in a single round-trip so that the total
+	// is always consistent with the returned page
+	query := fmt.Sprintf(`
+		SELECT *, COUNT(*) OVER() AS total_count FROM users %s
+		ORDER BY created_at DESC, id DESC
+		LIMIT %d OFFSET %d`,
+		whereClause, pagination.Limit, pagination.Offset)
+
+	var rows []*userWithTotal
+	err = r.store.read(func() error {
+		rows = nil // sqlx appends to the slice, so start each attempt empty
+		return r.store.q.Select(&rows, query, args...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*models.User, len(rows))
+	for i, row := range rows {
+		users[i] = &row.User
+	}
+
+	if len(rows) > 0 {
+		pagination.SetTotal(rows[0].TotalCount)
+		return users, nil
+	}
+
+	// A page past the end returns no rows and therefore no total
+	total := 0
+	if pagination.Offset > 0 {
+		countQuery := "SELECT COUNT(*) FROM users" + whereClause
+		if err := r.store.read(func() error { return r.store.q.Get(&total, countQuery, args...) }); err != nil {
+			return nil, fmt.Errorf("failed to count users: %w", err)
+		}
+	}
+	pagination.SetTotal(total)
+
+	return users, nil
+}
+
+// sqlSearchRank orders search results by how the query matches, as documented
+// on UserRepository.Search. $1 is the query, $2 its escaped prefix pattern
+// and $3 its escaped substring pattern.
+const sqlSearchRank = `CASE
+			WHEN lower(username) = lower($1) OR lower(email) = lower($1) THEN 0
+			WHEN username ILIKE $2 THEN 1
+			WHEN username ILIKE $3 OR email ILIKE $3 THEN 2
+			WHEN full_name ILIKE $3 THEN 3
+			ELSE 4
+		END`
+
+// Search retrieves users matching query by relevance. With pg_trgm, ties
+// are broken by trigram similarity and misspelled queries still match;
+// without it only substrings match.
+func (r *sqlUserRepository) Search(query string, limit int) ([]*models.User, error) {
+	escaped := likeEscaper.Replace(query)
+	args := []interface{}{query, escaped + "%", "%" + escaped + "%", limit}
+
+	var sqlQuery string
+	if r.store.trigram {
+		sqlQuery = `
+		SELECT * FROM users
+		WHERE username ILIKE $3 OR email ILIKE $3 OR full_name ILIKE $3
+			OR username % $1 OR full_name % $1
+		ORDER BY ` + sqlSearchRank + `,
+			GREATEST(similarity(username, $1), similarity(COALESCE(full_name, ''), $1)) DESC, id
+		LIMIT $4`
+	} else {
+		sqlQuery = `
+		SELECT * FROM users
+		WHERE username ILIKE $3 OR email ILIKE $3 OR full_name ILIKE $3
+		ORDER BY ` + sqlSearchRank + `, length(username), id
+		LIMIT $4`
+	}
+
+	var users []*models.User
+	err := r.store.read(func() error {
+		users = nil
+		return r.store.q.Select(&users, sqlQuery, args...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// AdminExists reports whether any admin user exists
+func (r *sqlUserRepository) AdminExists() (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS(SELECT 1 FROM users WHERE is_admin = TRUE)`
+
+	err := r.store.read(func() error { return r.store.q.Get(&exists, query) })
+	return exists, err
+}
+
+// FindDeletionDue returns the users whose scheduled deletion is due at at
+func (r *sqlUserRepository) FindDeletionDue(at time.Time) ([]*models.User, error) {
+	query := `
+		SELECT * FROM users
+		WHERE deletion_scheduled_at <= $1
+		ORDER BY deletion_scheduled_at, id`
+
+	var users []*models.User
+	err := r.store.read(func() error {
+		users = nil
+		return r.store.q.Select(&users, query, at)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// CountAdmins returns the number of admin users, locking their rows when run
+// in a transaction so concurrent demotions are serialized
+func (r *sqlUserRepository) CountAdmins() (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM (SELECT id FROM users WHERE is_admin = TRUE FOR UPDATE) admins`
+
+	err := r.store.q.Get(&count, query)
+	return count, err
+}
+
+// SetAdmin grants or revokes the user's admin role, returning ErrUserNotFound
+// if it does not exist
+func (r *sqlUserRepository) SetAdmin(id int, isAdmin bool, at time.Time) error {
+	query := `UPDATE users SET is_admin = $1, updated_at = $2 WHERE id = $3`
+	result, err := r.store.q.Exec(query, isAdmin, at, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// UpdateLastLogin sets the user's last login timestamp
+func (r *sqlUserRepository) UpdateLastLogin(id int, at time.Time) error {
+	query := `UPDATE users SET last_login = $1 WHERE id = $2`
+	_, err := r.store.q.Exec(query, at, id)
+	return err
+}
+
+// UpdatePasswordHash replaces the user's password hash
+func (r *sqlUserRepository) UpdatePasswordHash(id int, hash string) error {
+	query := `UPDATE users SET password_hash = $1 WHERE id = $2`
+	_, err := r.store.q.Exec(query, hash, id)
+	return err
+}
+
+// UseTOTPStep records the step in a single conditional update
+func (r *sqlUserRepository) UseTOTPStep(id int, step int64) (bool, error) {
+	query := `
+		UPDATE users SET totp_last_step = $1
+		WHERE id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)`
+
+	result, err := r.store.q.Exec(query, step, id)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected == 1, nil
+}
+
+// sqlOperators maps filter operators to their SQL comparison operators
+var sqlOperators = map[models.Operator]string{
+	models.OpEq:   "=",
+	models.OpNe:   "<>",
+	models.OpGt:   ">",
+	models.OpGte:  ">=",
+	models.OpLt:   "<",
+	models.OpLte:  "<=",
+	models.OpLike: "ILIKE",
+}
+
+// likeEscaper escapes the ILIKE wildcards so like conditions match literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// buildWhereClause builds the WHERE clause for user queries. Conditions are
+// validated against models.UserFilterFields, and every value is passed as
+// a query argument.
+func buildWhereClause(filter *models.UserFilter) (string, []interface{}, error) {
+	if filter == nil {
+		return "", nil, nil
+	}
+
+	var conditions []string
+	var args []interface{}
+	argCount := 0
+
+	if filter.Username != nil {
+		argCount++
+		conditions = append(conditions, fmt.Sprintf("username ILIKE $%d", argCount))
+		args = append(args, "%"+*filter.Username+"%")
+	}
+
+	if filter.Email != nil {
+		argCount++
+		conditions = append(conditions, fmt.Sprintf("email ILIKE $%d", argCount))
+		args = append(args, "%"+*filter.Email+"%")
+	}
+
+	if filter.IsActive != nil {
+		argCount++
+		conditions = append(conditions, fmt.Sprintf("is_active = $%d", argCount))
+		args = append(args, *filter.IsActive)
+	}
+
+	if filter.IsAdmin != nil {
+		argCount++
+		conditions = append(conditions, fmt.Sprintf("is_admin = $%d", argCount))
+		args = append(args, *filter.IsAdmin)
+	}
+
+	if filter.Search != nil {
+		argCount++
+		searchCondition := fmt.Sprintf("(username ILIKE $%d OR email ILIKE $%d OR full_name ILIKE $%d)", argCount, argCount, argCount)
+		conditions = append(conditions, searchCondition)
+		args = append(args, "%"+*filter.Search+"%")
+	}
+
+	if filter.CreatedAfter != nil {
+		argCount++
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", argCount))
+		args = append(args, *filter.CreatedAfter)
+	}
+
+	if filter.CreatedBefore != nil {
+		argCount++
+		conditions = append(conditions, fmt.Sprintf("created_at <= $%d", argCount))
+		args = append(args, *filter.CreatedBefore)
+	}
+
+	// A NULL last_login means the user never logged in, which counts as
+	// inactive; comparing it alone would drop those users, since a
+	// comparison with NULL is never true
+	if cutoff, ok := filter.LastLoginCutoff(time.Now()); ok {
+		argCount++
+		conditions = append(conditions, fmt.Sprintf("(last_login IS NULL OR last_login < $%d)", argCount))
+		args = append(args, cutoff)
+	}
+
+	for _, cond := range filter.Conditions {
+		if err := models.UserFilterFields.Validate(cond); err != nil {
+			return "", nil, err
+		}
+
+		value := cond.Value
+		if cond.Operator == models.OpLike {
+			value = "%" + likeEscaper.Replace(value.(string)) + "%"
+		}
+
+		argCount++
+		conditions = append(conditions, fmt.Sprintf("%s %s $%d", cond.Field, sqlOperators[cond.Operator], argCount))
+		args = append(args, value)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil, nil
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND "), args, nil
+}
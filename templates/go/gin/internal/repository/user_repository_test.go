@@ -0,0 +1,618 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gin-service/internal/database"
+	"gin-service/internal/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// MockDB is a mock database for testing
+type MockDB struct {
+	mock.Mock
+}
+
+var _ database.DBInterface = (*MockDB)(nil)
+
+func (m *MockDB) Get(dest interface{}, query string, args ...interface{}) error {
+	mockArgs := m.Called(dest, query, args)
+	return mockArgs.Error(0)
+}
+
+func (m *MockDB) Select(dest interface{}, query string, args ...interface{}) error {
+	mockArgs := m.Called(dest, query, args)
+	return mockArgs.Error(0)
+}
+
+func (m *MockDB) NamedQuery(query string, arg interface{}) (*sqlx.Rows, error) {
+	mockArgs := m.Called(query, arg)
+	if mockArgs.Get(0) == nil {
+		return nil, mockArgs.Error(1)
+	}
+	return mockArgs.Get(0).(*sqlx.Rows), mockArgs.Error(1)
+}
+
+func (m *MockDB) NamedExec(query string, arg interface{}) (sql.Result, error) {
+	mockArgs := m.Called(query, arg)
+	if mockArgs.Get(0) == nil {
+		return nil, mockArgs.Error(1)
+	}
+	return mockArgs.Get(0).(sql.Result), mockArgs.Error(1)
+}
+
+func (m *MockDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	mockArgs := m.Called(query, args)
+	if mockArgs.Get(0) == nil {
+		return nil, mockArgs.Error(1)
+	}
+	return mockArgs.Get(0).(sql.Result), mockArgs.Error(1)
+}
+
+func (m *MockDB) Health() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	mockArgs := m.Called(query, args)
+	if mockArgs.Get(0) == nil {
+		return nil, mockArgs.Error(1)
+	}
+	return mockArgs.Get(0).(*sql.Rows), mockArgs.Error(1)
+}
+
+func (m *MockDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	// For mocking purposes, we'll return nil since sql.Row is not easily mockable
+	// In real tests, we should use sqlx methods instead
+	return nil
+}
+
+func (m *MockDB) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+	mockArgs := m.Called(query, args)
+	if mockArgs.Get(0) == nil {
+		return nil, mockArgs.Error(1)
+	}
+	return mockArgs.Get(0).(*sqlx.Rows), mockArgs.Error(1)
+}
+
+func (m *MockDB) QueryRowx(query string, args ...interface{}) *sqlx.Row {
+	// For mocking purposes, we'll return nil since sqlx.Row is not easily mockable
+	// In real tests, we should use other methods instead
+	return nil
+}
+
+func (m *MockDB) Beginx() (*sqlx.Tx, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*sqlx.Tx), args.Error(1)
+}
+
+func (m *MockDB) Close() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockDB) Ping() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockDB) Transaction(fn func(*sqlx.Tx) error) error {
+	args := m.Called(fn)
+	return args.Error(0)
+}
+
+func setupSQLStore() (*SQLStore, *MockDB) {
+	mockDB := &MockDB{}
+	store := NewSQLStore(mockDB)
+	return store, mockDB
+}
+
+func TestSQLUserRepository_List_TotalMatchesRows(t *testing.T) {
+	store, mockDB := setupSQLStore()
+
+	mockDB.On("Select", mock.Anything, mock.MatchedBy(func(query string) bool {
+		return strings.Contains(query, "COUNT(*) OVER() AS total_count")
+	}), []interface{}(nil)).
+		Return(nil).Run(func(args mock.Arguments) {
+		// Simulate a static table of three users
+		dest := args.Get(0).(*[]*userWithTotal)
+		for i := 1; i <= 3; i++ {
+			*dest = append(*dest, &userWithTotal{
+				User:       models.User{ID: i, Username: fmt.Sprintf("user%d", i)},
+				TotalCount: 3,
+			})
+		}
+	})
+
+	pagination := &database.Paginate{Page: 1, Limit: 10}
+
+	// Execute the test
+	users, err := store.Users().List(nil, pagination)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Len(t, users, 3)
+	assert.Equal(t, 3, pagination.Total)
+	assert.Equal(t, 1, pagination.Pages)
+	assert.False(t, pagination.HasNext)
+	assert.Equal(t, "user1", users[0].Username)
+
+	mockDB.AssertExpectations(t)
+}
+
+func TestSQLUserRepository_List_PagePastEnd(t *testing.T) {
+	store, mockDB := setupSQLStore()
+
+	mockDB.On("Select", mock.Anything, mock.Anything, []interface{}(nil)).Return(nil)
+	mockDB.On("Get", mock.Anything, "SELECT COUNT(*) FROM users", []interface{}(nil)).
+		Return(nil).Run(func(args mock.Arguments) {
+		dest := args.Get(0).(*int)
+		*dest = 3
+	})
+
+	pagination := &database.Paginate{Page: 5, Limit: 10}
+
+	// Execute the test
+	users, err := store.Users().List(nil, pagination)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+	assert.Equal(t, 3, pagination.Total)
+
+	mockDB.AssertExpectations(t)
+}
+
+func TestSQLUserRepository_Create_TranslatesUniqueViolations(t *testing.T) {
+	tests := []struct {
+		constraint string
+		want       error
+	}{
+		{"users_username_key", ErrDuplicateUsername},
+		{"users_email_key", ErrDuplicateEmail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			store, mockDB := setupSQLStore()
+			// A concurrent insert won the race for the unique value
+			mockDB.On("NamedQuery", mock.Anything, mock.Anything).
+				Return(nil, &pq.Error{Code: "23505", Constraint: tt.constraint})
+
+			err := store.Users().Create(&models.User{Username: "testuser", Email: "test@example.com"})
+
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+
+	// Other unique violations are passed through
+	store, mockDB := setupSQLStore()
+	mockDB.On("NamedQuery", mock.Anything, mock.Anything).
+		Return(nil, &pq.Error{Code: "23505", Constraint: "users_pkey"})
+	err := store.Users().Create(&models.User{Username: "testuser", Email: "test@example.com"})
+	var pqErr *pq.Error
+	assert.ErrorAs(t, err, &pqErr)
+}
+
+func TestSQLUserRepository_Update_NoRowsAffected(t *testing.T) {
+	store, mockDB := setupSQLStore()
+	// The user was deleted after it was read
+	mockDB.On("NamedExec", mock.Anything, mock.Anything).Return(driver.RowsAffected(0), nil)
+
+	err := store.Users().Update(&models.User{ID: 42, Username: "stale", Email: "stale@example.com"})
+
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	mockDB.AssertExpectations(t)
+}
+
+func TestSQLUserRepository_FindByID_RetriesOnConnectionError(t *testing.T) {
+	store, mockDB := setupSQLStore()
+	store.SetRetryPolicy(database.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	// First call fails with a dropped connection, the retry succeeds
+	mockDB.On("Get", mock.Anything, "SELECT * FROM users WHERE id = $1", []interface{}{1}).
+		Return(driver.ErrBadConn).Once()
+	mockDB.On("Get", mock.Anything, "SELECT * FROM users WHERE id = $1", []interface{}{1}).
+		Return(nil).Once().Run(func(args mock.Arguments) {
+		dest := args.Get(0).(*models.User)
+		*dest = models.User{ID: 1, Username: "testuser"}
+	})
+
+	// Execute the test
+	user, err := store.Users().FindByID(1)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.NotNil(t, user)
+	assert.Equal(t, "testuser", user.Username)
+
+	mockDB.AssertNumberOfCalls(t, "Get", 2)
+	mockDB.AssertExpectations(t)
+}
+
+func TestSQLUserRepository_FindByID_GivesUpAfterMaxAttempts(t *testing.T) {
+	store, mockDB := setupSQLStore()
+	store.SetRetryPolicy(database.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	mockDB.On("Get", mock.Anything, "SELECT * FROM users WHERE id = $1", []interface{}{1}).
+		Return(driver.ErrBadConn)
+
+	// Execute the test
+	user, err := store.Users().FindByID(1)
+
+	// Assertions
+	assert.ErrorIs(t, err, driver.ErrBadConn)
+	assert.Nil(t, user)
+
+	mockDB.AssertNumberOfCalls(t, "Get", 3)
+}
+
+func TestSQLUserRepository_RecordsQueryDuration(t *testing.T) {
+	store, mockDB := setupSQLStore()
+
+	mockDB.On("Get", mock.Anything, "SELECT * FROM users WHERE id = $1", []interface{}{1}).Return(sql.ErrNoRows)
+	mockDB.On("Get", mock.Anything, mock.MatchedBy(func(query string) bool {
+		return strings.Contains(query, "is_admin = TRUE")
+	}), []interface{}(nil)).Return(nil)
+
+	findByID := histogramCount(t, "get_by_id")
+	adminExists := histogramCount(t, "admin_exists")
+
+	_, err := store.Users().FindByID(1)
+	assert.NoError(t, err)
+	_, err = store.Users().AdminExists()
+	assert.NoError(t, err)
+
+	// Each operation is observed under its name, whatever its SQL
+	assert.Equal(t, findByID+1, histogramCount(t, "get_by_id"))
+	assert.Equal(t, adminExists+1, histogramCount(t, "admin_exists"))
+
+	mockDB.AssertExpectations(t)
+}
+
+// histogramCount returns the number of observations of operation in
+// QueryDuration
+func histogramCount(t *testing.T, operation string) uint64 {
+	var metric dto.Metric
+	require.NoError(t, QueryDuration.WithLabelValues(operation).(prometheus.Histogram).Write(&metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestSQLUserRepository_FindByID_DoesNotRetryLogicalErrors(t *testing.T) {
+	store, mockDB := setupSQLStore()
+	store.SetRetryPolicy(database.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	mockDB.On("Get", mock.Anything, "SELECT * FROM users WHERE id = $1", []interface{}{1}).
+		Return(&pq.Error{Code: "23505"}) // unique_violation
+
+	// Execute the test
+	user, err := store.Users().FindByID(1)
+
+	// Assertions
+	assert.Error(t, err)
+	assert.Nil(t, user)
+
+	mockDB.AssertNumberOfCalls(t, "Get", 1)
+}
+
+func TestSQLUserRepository_FindByID_StopsRetryingWhenContextDone(t *testing.T) {
+	store, mockDB := setupSQLStore()
+	store.SetRetryPolicy(database.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	// The request times out while the first attempt fails
+	ctx, cancel := context.WithCancel(context.Background())
+	mockDB.On("Get", mock.Anything, "SELECT * FROM users WHERE id = $1", []interface{}{1}).
+		Return(driver.ErrBadConn).Run(func(mock.Arguments) { cancel() })
+
+	// Execute the test
+	user, err := store.WithContext(ctx).Users().FindByID(1)
+
+	// Assertions
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, user)
+
+	mockDB.AssertNumberOfCalls(t, "Get", 1)
+}
+
+func TestSQLUserRepository_FindByID_CutsBackoffShortWhenContextDone(t *testing.T) {
+	store, mockDB := setupSQLStore()
+	store.SetRetryPolicy(database.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour})
+
+	// The request times out while waiting to retry
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	mockDB.On("Get", mock.Anything, "SELECT * FROM users WHERE id = $1", []interface{}{1}).
+		Return(driver.ErrBadConn)
+
+	start := time.Now()
+	user, err := store.WithContext(ctx).Users().FindByID(1)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, user)
+	assert.Less(t, time.Since(start), time.Second)
+	mockDB.AssertNumberOfCalls(t, "Get", 1)
+}
+
+func TestSQLStore_TransactionWithRetry_RetriesConflicts(t *testing.T) {
+	store, mockDB := setupSQLStore()
+	store.SetRetryPolicy(database.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	serializationRetries := testutil.ToFloat64(database.TransactionRetries.WithLabelValues("40001"))
+	deadlockRetries := testutil.ToFloat64(database.TransactionRetries.WithLabelValues("40P01"))
+
+	// Each failed attempt is rolled back by Transaction before the next
+	mockDB.On("Transaction", mock.Anything).Return(&pq.Error{Code: "40001"}).Once() // serialization_failure
+	mockDB.On("Transaction", mock.Anything).Return(&pq.Error{Code: "40P01"}).Once() // deadlock_detected
+	mockDB.On("Transaction", mock.Anything).Return(nil).Once()
+
+	// Execute the test
+	err := store.TransactionWithRetry(func(tx Store) error { return nil }, 3)
+
+	// Assertions
+	assert.NoError(t, err)
+	mockDB.AssertNumberOfCalls(t, "Transaction", 3)
+	assert.Equal(t, serializationRetries+1, testutil.ToFloat64(database.TransactionRetries.WithLabelValues("40001")))
+	assert.Equal(t, deadlockRetries+1, testutil.ToFloat64(database.TransactionRetries.WithLabelValues("40P01")))
+}
+
+func TestSQLStore_TransactionWithRetry_GivesUpAfterMaxRetries(t *testing.T) {
+	store, mockDB := setupSQLStore()
+
+	mockDB.On("Transaction", mock.Anything).Return(&pq.Error{Code: "40001"})
+
+	// Execute the test
+	err := store.TransactionWithRetry(func(tx Store) error { return nil }, 2)
+
+	// Assertions
+	assert.True(t, database.IsSerializationFailure(err))
+	mockDB.AssertNumberOfCalls(t, "Transaction", 3)
+}
+
+func TestSQLStore_TransactionWithRetry_DoesNotRetryOtherErrors(t *testing.T) {
+	store, mockDB := setupSQLStore()
+
+	for _, txErr := range []error{&pq.Error{Code: "23505"}, driver.ErrBadConn, assert.AnError} {
+		mockDB.On("Transaction", mock.Anything).Return(txErr).Once()
+
+		// Execute the test
+		err := store.TransactionWithRetry(func(tx Store) error { return nil }, 3)
+
+		// Assertions
+		assert.ErrorIs(t, err, txErr)
+	}
+	mockDB.AssertNumberOfCalls(t, "Transaction", 3)
+}
+
+func TestSQLStore_TransactionWithRetry_StopsWhenContextDone(t *testing.T) {
+	store, mockDB := setupSQLStore()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mockDB.On("Transaction", mock.Anything).Return(&pq.Error{Code: "40001"}).Run(func(mock.Arguments) { cancel() })
+
+	// Execute the test
+	err := store.WithContext(ctx).TransactionWithRetry(func(tx Store) error { return nil }, 3)
+
+	// Assertions
+	assert.ErrorIs(t, err, context.Canceled)
+	mockDB.AssertNumberOfCalls(t, "Transaction", 1)
+}
+
+func TestBuildWhereClause_Operators(t *testing.T) {
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		cond  models.Condition
+		where string
+		arg   interface{}
+	}{
+		{models.Condition{Field: "is_admin", Operator: models.OpEq, Value: true}, " WHERE is_admin = $1", true},
+		{models.Condition{Field: "is_active", Operator: models.OpNe, Value: false}, " WHERE is_active <> $1", false},
+		{models.Condition{Field: "id", Operator: models.OpGt, Value: 10}, " WHERE id > $1", 10},
+		{models.Condition{Field: "created_at", Operator: models.OpGte, Value: date}, " WHERE created_at >= $1", date},
+		{models.Condition{Field: "updated_at", Operator: models.OpLt, Value: date}, " WHERE updated_at < $1", date},
+		{models.Condition{Field: "last_login", Operator: models.OpLte, Value: date}, " WHERE last_login <= $1", date},
+		{models.Condition{Field: "email", Operator: models.OpLike, Value: "example"}, " WHERE email ILIKE $1", "%example%"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.cond.Operator), func(t *testing.T) {
+			where, args, err := buildWhereClause(&models.UserFilter{Conditions: []models.Condition{tt.cond}})
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.where, where)
+			assert.Equal(t, []interface{}{tt.arg}, args)
+		})
+	}
+}
+
+func TestBuildWhereClause_CombinesWithLegacyFilters(t *testing.T) {
+	search := "smith"
+	filter := &models.UserFilter{
+		Search: &search,
+		Conditions: []models.Condition{
+			{Field: "created_at", Operator: models.OpGte, Value: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{Field: "is_admin", Operator: models.OpEq, Value: false},
+		},
+	}
+
+	where, args, err := buildWhereClause(filter)
+
+	require.NoError(t, err)
+	assert.Equal(t, " WHERE (username ILIKE $1 OR email ILIKE $1 OR full_name ILIKE $1) AND created_at >= $2 AND is_admin = $3", where)
+	assert.Len(t, args, 3)
+}
+
+func TestBuildWhereClause_CreatedRange(t *testing.T) {
+	isActive := true
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	filter := &models.UserFilter{
+		IsActive:      &isActive,
+		CreatedAfter:  &after,
+		CreatedBefore: &before,
+		Conditions: []models.Condition{
+			{Field: "is_admin", Operator: models.OpEq, Value: false},
+		},
+	}
+
+	where, args, err := buildWhereClause(filter)
+
+	require.NoError(t, err)
+	assert.Equal(t, " WHERE is_active = $1 AND created_at >= $2 AND created_at <= $3 AND is_admin = $4", where)
+	assert.Equal(t, []interface{}{true, after, before, false}, args)
+}
+
+func TestBuildWhereClause_LastLogin(t *testing.T) {
+	before := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	isAdmin := false
+	filter := &models.UserFilter{IsAdmin: &isAdmin, LastLoginBefore: &before}
+
+	where, args, err := buildWhereClause(filter)
+
+	require.NoError(t, err)
+	// Users who never logged in are kept
+	assert.Equal(t, " WHERE is_admin = $1 AND (last_login IS NULL OR last_login < $2)", where)
+	assert.Equal(t, []interface{}{false, before}, args)
+
+	// The earlier of the two cutoffs applies
+	days := 30
+	filter.InactiveDays = &days
+	_, args, err = buildWhereClause(filter)
+	require.NoError(t, err)
+	assert.Equal(t, before, args[1])
+
+	filter.LastLoginBefore = nil
+	_, args, err = buildWhereClause(filter)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, -30), args[1].(time.Time), time.Minute)
+}
+
+func TestBuildWhereClause_InjectionAttempts(t *testing.T) {
+	// Values are always passed as arguments, never interpolated
+	payload := "'; DROP TABLE users; --"
+	where, args, err := buildWhereClause(&models.UserFilter{Conditions: []models.Condition{
+		{Field: "username", Operator: models.OpEq, Value: payload},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, " WHERE username = $1", where)
+	assert.Equal(t, []interface{}{payload}, args)
+
+	// like wildcards in values match literally
+	_, args, err = buildWhereClause(&models.UserFilter{Conditions: []models.Condition{
+		{Field: "email", Operator: models.OpLike, Value: `100%_\`},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{`%100\%\_\\%`}, args)
+
+	// Field names and operators outside the whitelist never reach the query
+	for _, cond := range []models.Condition{
+		{Field: "id = 1 OR 1", Operator: models.OpEq, Value: 1},
+		{Field: "password_hash", Operator: models.OpEq, Value: "x"},
+		{Field: "id", Operator: models.Operator("= 1 OR id"), Value: 1},
+		{Field: "id", Operator: models.OpEq, Value: "1 OR 1=1"},
+	} {
+		_, _, err := buildWhereClause(&models.UserFilter{Conditions: []models.Condition{cond}})
+		assert.Error(t, err, "condition %+v", cond)
+	}
+}
+
+func TestSQLUserRepository_List_InvalidCondition(t *testing.T) {
+	store, mockDB := setupSQLStore()
+
+	filter := &models.UserFilter{Conditions: []models.Condition{{Field: "password_hash", Operator: models.OpEq, Value: "x"}}}
+	_, err := store.Users().List(filter, &database.Paginate{Page: 1, Limit: 10})
+
+	var filterErr *models.FilterError
+	assert.ErrorAs(t, err, &filterErr)
+	mockDB.AssertNotCalled(t, "Select", mock.Anything, mock.Anything, mock.Anything)
+}
+
+// execConnector connects to a stand-in database whose statements each affect
+// one row after a delay, so that real transactions can be run without one
+type execConnector struct {
+	delay time.Duration
+}
+
+func (c execConnector) Connect(context.Context) (driver.Conn, error) { return execConn(c), nil }
+func (c execConnector) Driver() driver.Driver                        { return c }
+func (c execConnector) Open(string) (driver.Conn, error)             { return execConn(c), nil }
+
+type execConn struct {
+	delay time.Duration
+}
+
+func (c execConn) Prepare(string) (driver.Stmt, error) { return execStmt(c), nil }
+func (c execConn) Close() error                        { return nil }
+func (c execConn) Begin() (driver.Tx, error)           { return c, nil }
+func (c execConn) Commit() error                       { return nil }
+func (c execConn) Rollback() error                     { return nil }
+
+type execStmt struct {
+	delay time.Duration
+}
+
+func (s execStmt) Close() error  { return nil }
+func (s execStmt) NumInput() int { return -1 }
+func (s execStmt) Exec([]driver.Value) (driver.Result, error) {
+	time.Sleep(s.delay)
+	return driver.RowsAffected(1), nil
+}
+func (s execStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, fmt.Errorf("queries are not supported")
+}
+
+func TestSQLStore_Transaction_LogsQueries(t *testing.T) {
+	db := &database.DB{DB: sqlx.NewDb(sql.OpenDB(execConnector{delay: 20 * time.Millisecond}), "postgres")}
+	defer db.Close()
+
+	tests := []struct {
+		name string
+		wrap func(database.DBInterface) database.DBInterface
+	}{
+		{"query logger", func(db database.DBInterface) database.DBInterface { return db }},
+		{"behind circuit breaker", func(db database.DBInterface) database.DBInterface {
+			return database.NewCircuitBreakerDB(db, database.NewCircuitBreaker(5, time.Minute))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.DebugLevel)
+			store := NewSQLStore(tt.wrap(database.NewQueryLogger(db, 10*time.Millisecond, zap.New(core))))
+			ctx := database.ContextWithRequestID(context.Background(), "req-123")
+
+			// Writes run within transactions, not through the logger's Exec
+			err := store.WithContext(ctx).Transaction(func(tx Store) error {
+				return tx.Users().Delete(1)
+			})
+
+			require.NoError(t, err)
+			entries := logs.FilterMessage("Slow query").All()
+			require.Len(t, entries, 1)
+			fields := entries[0].ContextMap()
+			assert.Equal(t, "DELETE FROM users WHERE id = $1", fields["query"])
+			assert.EqualValues(t, 1, fields["rows_affected"])
+			assert.Equal(t, "req-123", fields["request_id"])
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gin-service/internal/models"
+)
+
+// ErrWebhookNotFound is returned when a webhook to modify does not exist
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// WebhookRepository persists webhooks and the deliveries queued for them
+type WebhookRepository interface {
+	Create(webhook *models.Webhook) error
+	// FindByID returns nil if the webhook does not exist
+	FindByID(id int) (*models.Webhook, error)
+	// List returns all webhooks in id order
+	List() ([]*models.Webhook, error)
+	// ListSubscribed returns the active webhooks subscribed to eventType
+	ListSubscribed(eventType string) ([]*models.Webhook, error)
+	Update(webhook *models.Webhook) error
+	// Delete deletes a webhook along with its deliveries
+	Delete(id int) error
+
+	// AddDelivery queues a delivery. A delivery of an event already queued
+	// for the webhook is ignored, so an event published again is delivered
+	// once.
+	AddDelivery(delivery *models.WebhookDelivery) error
+	// ClaimDeliveries claims up to limit pending deliveries due at now, in
+	// id order, counting an attempt and postponing them by lease so other
+	// workers skip them until the attempt's outcome is saved
+	ClaimDeliveries(now time.Time, limit int, lease time.Duration) ([]*models.WebhookDelivery, error)
+	// SaveDeliveryAttempt records the outcome of a delivery attempt: its
+	// status, next attempt, last error and response status
+	SaveDeliveryAttempt(delivery *models.WebhookDelivery) error
+	// ListDeliveries returns up to limit deliveries, newest first, of the
+	// webhook unless webhookID is 0 and with the status unless it is empty
+	ListDeliveries(webhookID int, status string, limit int) ([]*models.WebhookDelivery, error)
+}
+
+// sqlWebhookRepository is a WebhookRepository backed by a SQLStore
+type sqlWebhookRepository struct {
+	store *SQLStore
+}
+
+// Create inserts a webhook and sets its ID
+func (r *sqlWebhookRepository) Create(webhook *models.Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, secret, event_types, active, created_at, updated_at)
+		VALUES (:url, :secret, :event_types, :active, :created_at, :updated_at)
+		RETURNING id`
+
+	rows, err := r.store.q.NamedQuery(query, webhook)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&webhook.ID); err != nil {
+			return fmt.Errorf("failed to scan webhook ID: %w", err)
+		}
+	}
+
+	return rows.Err()
+}
+
+// FindByID retrieves a webhook by ID
+func (r *sqlWebhookRepository) FindByID(id int) (*models.Webhook, error) {
+	var webhook models.Webhook
+
+	err := r.store.read(func() error {
+		return r.store.q.Get(&webhook, `SELECT * FROM webhooks WHERE id = $1`, id)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+// List selects all webhooks
+func (r *sqlWebhookRepository) List() ([]*models.Webhook, error) {
+	var webhooks []*models.Webhook
+	err := r.store.read(func() error {
+		webhooks = nil
+		return r.store.q.Select(&webhooks, `SELECT * FROM webhooks ORDER BY id`)
+	})
+	return webhooks, err
+}
+
+// ListSubscribed selects the active webhooks whose event types include
+// eventType
+func (r *sqlWebhookRepository) ListSubscribed(eventType string) ([]*models.Webhook, error) {
+	query := `SELECT * FROM webhooks WHERE active AND $1 = ANY(event_types) ORDER BY id`
+
+	var webhooks []*models.Webhook
+	err := r.store.read(func() error {
+		webhooks = nil
+		return r.store.q.Select(&webhooks, query, eventType)
+	})
+	return webhooks, err
+}
+
+// Update saves a webhook, returning ErrWebhookNotFound if it does not exist
+func (r *sqlWebhookRepository) Update(webhook *models.Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = :url, secret = :secret, event_types = :event_types, active = :active,
+			updated_at = :updated_at
+		WHERE id = :id`
+
+	result, err := r.store.q.NamedExec(query, webhook)
+	if err != nil {
+		return err
+	}
+	return webhookAffected(result)
+}
+
+// Delete deletes a webhook, returning ErrWebhookNotFound if it does not
+// exist. Its deliveries are deleted by the foreign key.
+func (r *sqlWebhookRepository) Delete(id int) error {
+	result, err := r.store.q.Exec(`DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return webhookAffected(result)
+}
+
+// webhookAffected returns ErrWebhookNotFound if result affected no rows
+func webhookAffected(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// AddDelivery inserts a pending delivery, ignoring duplicates
+func (r *sqlWebhookRepository) AddDelivery(delivery *models.WebhookDelivery) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, status, next_attempt_at)
+		VALUES (:webhook_id, :event_id, :event_type, :payload, :status, :next_attempt_at)
+		ON CONFLICT (webhook_id, event_id) DO NOTHING
+		RETURNING id`
+
+	rows, err := r.store.q.NamedQuery(query, delivery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&delivery.ID); err != nil {
+			return fmt.Errorf("failed to scan webhook delivery ID: %w", err)
+		}
+	}
+
+	return rows.Err()
+}
+
+// ClaimDeliveries claims due deliveries. SKIP LOCKED lets concurrent workers
+// claim disjoint batches without waiting on each other.
+func (r *sqlWebhookRepository) ClaimDeliveries(now time.Time, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries
+		SET next_attempt_at = $1::timestamptz + $2::bigint * INTERVAL '1 millisecond', attempts = attempts + 1
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= $1
+			ORDER BY id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`
+
+	var deliveries []*models.WebhookDelivery
+	if err := r.store.q.Select(&deliveries, query, now, lease.Milliseconds(), limit); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not preserve the subquery's order
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries, nil
+}
+
+// SaveDeliveryAttempt updates the delivery with the attempt's outcome
+func (r *sqlWebhookRepository) SaveDeliveryAttempt(delivery *models.WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = :status, next_attempt_at = :next_attempt_at, last_error = :last_error,
+			response_status = :response_status, delivered_at = :delivered_at
+		WHERE id = :id`
+
+	_, err := r.store.q.NamedExec(query, delivery)
+	return err
+}
+
+// ListDeliveries selects deliveries by webhook and status
+func (r *sqlWebhookRepository) ListDeliveries(webhookID int, status string, limit int) ([]*models.WebhookDelivery, error) {
+	var conditions []string
+	var args []interface{}
+	if webhookID != 0 {
+		args = append(args, webhookID)
+		conditions = append(conditions, fmt.Sprintf("webhook_id = $%d", len(args)))
+	}
+	if status != "" {
+		args = append(args, status)
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+	whereClause := ""
+	if len(conditions) > 0 {
+		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+	query := fmt.Sprintf(`SELECT * FROM webhook_deliveries %s ORDER BY id DESC LIMIT $%d`, whereClause, len(args))
+
+	var deliveries []*models.WebhookDelivery
+	err := r.store.read(func() error {
+		deliveries = nil
+		return r.store.q.Select(&deliveries, query, args...)
+	})
+	return deliveries, err
+}
@@ -0,0 +1,100 @@
+// Package seed loads demo data into an empty database
+package seed
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gin-service/internal/database"
+	"gin-service/internal/models"
+	"gin-service/internal/services"
+
+	"go.uber.org/zap"
+)
+
+//go:embed users.json
+var defaultUsers []byte
+
+// ErrProduction is returned when seeding is attempted in production
+var ErrProduction = errors.New("refusing to seed demo data in production")
+
+// UserCreator creates users. It is implemented by services.UserService.
+type UserCreator interface {
+	List(filter *models.UserFilter, pagination *database.Paginate) ([]*models.User, error)
+	Create(req *models.CreateUserRequest) (*models.User, error)
+	CreateAdmin(req *models.CreateUserRequest, force bool) (*models.User, error)
+}
+
+// User is a demo user
+type User struct {
+	models.CreateUserRequest
+	Admin bool `json:"admin"`
+}
+
+// DefaultUsers returns the demo users embedded in the binary
+func DefaultUsers() ([]User, error) {
+	var users []User
+	if err := json.Unmarshal(defaultUsers, &users); err != nil {
+		return nil, fmt.Errorf("failed to parse demo users: %w", err)
+	}
+	return users, nil
+}
+
+// Seeder inserts demo users through the user service
+type Seeder struct {
+	users  UserCreator
+	logger *zap.Logger
+}
+
+// NewSeeder creates a new seeder
+func NewSeeder(users UserCreator, logger *zap.Logger) *Seeder {
+	return &Seeder{
+		users:  users,
+		logger: logger,
+	}
+}
+
+// Run creates the demo users if there are no regular users yet. Admins are
+// not counted since the initial migration creates a default admin; a demo
+// admin is skipped when an admin already exists. It returns ErrProduction
+// without touching the database in production.
+func (s *Seeder) Run(environment string, users []User) error {
+	if environment == "production" {
+		return ErrProduction
+	}
+
+	regular := false
+	pagination := &database.Paginate{Page: 1, Limit: 1}
+	if _, err := s.users.List(&models.UserFilter{IsAdmin: &regular}, pagination); err != nil {
+		return fmt.Errorf("failed to count users: %w", err)
+	}
+	if pagination.Total > 0 {
+		s.logger.Info("Users already exist, skipping demo data", zap.Int("users", pagination.Total))
+		return nil
+	}
+
+	created := 0
+	for _, user := range users {
+		req := user.CreateUserRequest
+
+		var err error
+		if user.Admin {
+			_, err = s.users.CreateAdmin(&req, false)
+			if errors.Is(err, services.ErrAdminExists) {
+				s.logger.Info("Admin user already exists, skipping demo admin", zap.String("username", user.Username))
+				continue
+			}
+		} else {
+			_, err = s.users.Create(&req)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create demo user %q: %w", user.Username, err)
+		}
+		created++
+	}
+
+	s.logger.Info("Demo data loaded", zap.Int("users", created))
+	return nil
+}
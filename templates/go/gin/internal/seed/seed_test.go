@@ -0,0 +1,112 @@
+package seed
+
+import (
+	"testing"
+
+	"gin-service/internal/database"
+	"gin-service/internal/models"
+	"gin-service/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// MockUserCreator is a mock implementation of UserCreator
+type MockUserCreator struct {
+	mock.Mock
+}
+
+func (m *MockUserCreator) List(filter *models.UserFilter, pagination *database.Paginate) ([]*models.User, error) {
+	args := m.Called(filter, pagination)
+	pagination.SetTotal(args.Int(0))
+	return nil, args.Error(1)
+}
+
+func (m *MockUserCreator) Create(req *models.CreateUserRequest) (*models.User, error) {
+	args := m.Called(req.Username)
+	return &models.User{Username: req.Username}, args.Error(0)
+}
+
+func (m *MockUserCreator) CreateAdmin(req *models.CreateUserRequest, force bool) (*models.User, error) {
+	args := m.Called(req.Username, force)
+	return &models.User{Username: req.Username, IsAdmin: true}, args.Error(0)
+}
+
+// regularUsers matches the filter for non-admin users
+var regularUsers = mock.MatchedBy(func(filter *models.UserFilter) bool {
+	return filter != nil && filter.IsAdmin != nil && !*filter.IsAdmin
+})
+
+func TestDefaultUsers(t *testing.T) {
+	users, err := DefaultUsers()
+
+	require.NoError(t, err)
+	require.NotEmpty(t, users)
+
+	admins := 0
+	for _, user := range users {
+		assert.NoError(t, models.ValidatePassword(user.Password), user.Username)
+		if user.Admin {
+			admins++
+		}
+	}
+	assert.Equal(t, 1, admins)
+}
+
+func TestSeeder_Run_EmptyDatabase(t *testing.T) {
+	users, err := DefaultUsers()
+	require.NoError(t, err)
+
+	mockUsers := &MockUserCreator{}
+	mockUsers.On("List", regularUsers, mock.Anything).Return(0, nil)
+	mockUsers.On("CreateAdmin", "admin", false).Return(nil).Once()
+	mockUsers.On("Create", "alice").Return(nil).Once()
+	mockUsers.On("Create", "bob").Return(nil).Once()
+	mockUsers.On("Create", "carol").Return(nil).Once()
+
+	err = NewSeeder(mockUsers, zap.NewNop()).Run("development", users)
+
+	assert.NoError(t, err)
+	mockUsers.AssertExpectations(t)
+}
+
+func TestSeeder_Run_DefaultAdminExists(t *testing.T) {
+	users, err := DefaultUsers()
+	require.NoError(t, err)
+
+	// The initial migration creates an admin, which doesn't count as data
+	mockUsers := &MockUserCreator{}
+	mockUsers.On("List", regularUsers, mock.Anything).Return(0, nil)
+	mockUsers.On("CreateAdmin", "admin", false).Return(services.ErrAdminExists).Once()
+	mockUsers.On("Create", mock.Anything).Return(nil).Times(3)
+
+	err = NewSeeder(mockUsers, zap.NewNop()).Run("development", users)
+
+	assert.NoError(t, err)
+	mockUsers.AssertExpectations(t)
+}
+
+func TestSeeder_Run_UsersExist(t *testing.T) {
+	users, err := DefaultUsers()
+	require.NoError(t, err)
+
+	mockUsers := &MockUserCreator{}
+	mockUsers.On("List", regularUsers, mock.Anything).Return(1, nil)
+
+	err = NewSeeder(mockUsers, zap.NewNop()).Run("development", users)
+
+	assert.NoError(t, err)
+	mockUsers.AssertNotCalled(t, "Create", mock.Anything)
+	mockUsers.AssertNotCalled(t, "CreateAdmin", mock.Anything, mock.Anything)
+}
+
+func TestSeeder_Run_RefusesProduction(t *testing.T) {
+	mockUsers := &MockUserCreator{}
+
+	err := NewSeeder(mockUsers, zap.NewNop()).Run("production", []User{{}})
+
+	assert.ErrorIs(t, err, ErrProduction)
+	mockUsers.AssertNotCalled(t, "List", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gin-service/internal/models"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrDeletionNotScheduled is returned when cancelling the deletion of an
+	// account whose deletion wasn't requested
+	ErrDeletionNotScheduled = errors.New("account deletion is not scheduled")
+)
+
+// DeletionPendingError is returned when logging in to an account that is
+// inactive because its deletion is scheduled. Like any inactive account it
+// can't log in, and the error matches ErrAccountInactive, but User may be
+// given a token only good for cancelling the deletion.
+type DeletionPendingError struct {
+	User *models.User
+}
+
+func (e *DeletionPendingError) Error() string {
+	return ErrAccountInactive.Error()
+}
+
+func (e *DeletionPendingError) Unwrap() error {
+	return ErrAccountInactive
+}
+
+// defaultDeletionGracePeriod is how long a requested deletion can be
+// cancelled when no grace period is configured
+const defaultDeletionGracePeriod = 30 * 24 * time.Hour
+
+// SetDeletionGracePeriod sets how long a requested account deletion waits,
+// during which the user can cancel it
+func (s *UserService) SetDeletionGracePeriod(period time.Duration) {
+	if period <= 0 {
+		period = defaultDeletionGracePeriod
+	}
+	s.deletionGracePeriod = period
+}
+
+// ScheduleDeletion deactivates the user's account and schedules its deletion
+// after the grace period. Requesting it again keeps the first schedule.
+func (s *UserService) ScheduleDeletion(id int) (*models.User, error) {
+	user, err := s.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if user.DeletionScheduledAt != nil {
+		return user, nil
+	}
+	// Cancelling reactivates the account, which mustn't lift a suspension
+	// or an admin's deactivation
+	if user.Status == models.StatusSuspended {
+		return nil, ErrAccountSuspended
+	}
+	if !user.IsActive {
+		return nil, ErrAccountInactive
+	}
+
+	scheduledAt := time.Now().Add(s.deletionGracePeriod)
+	user.DeletionScheduledAt = &scheduledAt
+	user.SetStatus(models.StatusInactive)
+	user.BeforeUpdate()
+
+	err = s.saveDeletionSchedule(user, models.AuditActionDeletionScheduled, map[string]interface{}{
+		"deletion_scheduled_at": scheduledAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("User deletion scheduled", zap.Int("target_user_id", id), zap.Time("deletion_scheduled_at", scheduledAt))
+	return user, nil
+}
+
+// CancelDeletion cancels the scheduled deletion of the user's account and
+// reactivates it
+func (s *UserService) CancelDeletion(id int) (*models.User, error) {
+	user, err := s.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if user.DeletionScheduledAt == nil {
+		return nil, ErrDeletionNotScheduled
+	}
+
+	user.DeletionScheduledAt = nil
+	user.SetStatus(models.StatusActive)
+	user.BeforeUpdate()
+
+	if err := s.saveDeletionSchedule(user, models.AuditActionDeletionCancelled, nil); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("User deletion cancelled", zap.Int("target_user_id", id))
+	return user, nil
+}
+
+// saveDeletionSchedule saves a change to the user's deletion schedule along
+// with its audit record and event
+func (s *UserService) saveDeletionSchedule(user *models.User, action string, details interface{}) error {
+	return s.inTxWithRetry(func(txService *UserService) error {
+		if err := txService.users.Update(user); err != nil {
+			if errors.Is(err, ErrUserNotFound) {
+				return err
+			}
+			txService.logger.Error("Failed to update user deletion", zap.Error(err), zap.Int("target_user_id", user.ID))
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		if err := txService.audit.Record(action, &user.ID, txService.actor(user.ID), details); err != nil {
+			return err
+		}
+		return txService.outbox.Record(models.EventUserUpdated, user.ID, user.ToResponse())
+	})
+}
+
+// DeleteDueAccounts deletes the accounts whose scheduled deletion is due at
+// now, returning how many were deleted. It stops at the first failure.
+func (s *UserService) DeleteDueAccounts(now time.Time) (int, error) {
+	due, err := s.users.FindDeletionDue(now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find accounts due for deletion: %w", err)
+	}
+
+	deleted := 0
+	for _, user := range due {
+		if err := s.Delete(user.ID); err != nil {
+			// Deleted meanwhile, e.g. by an admin or another instance
+			if errors.Is(err, ErrUserNotFound) {
+				continue
+			}
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// DeletionReaper deletes accounts past their deletion grace period
+type DeletionReaper struct {
+	users    *UserService
+	interval time.Duration
+	logger   *zap.Logger
+}
+
+// NewDeletionReaper creates a reaper deleting due accounts every interval
+func NewDeletionReaper(users *UserService, interval time.Duration, logger *zap.Logger) *DeletionReaper {
+	return &DeletionReaper{
+		users:    users,
+		interval: interval,
+		logger:   logger.With(zap.String("component", "deletion_reaper")),
+	}
+}
+
+// Run deletes due accounts every interval until ctx is cancelled
+func (r *DeletionReaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := r.users.DeleteDueAccounts(time.Now())
+		if err != nil {
+			r.logger.Error("Failed to delete accounts due for deletion", zap.Error(err))
+		}
+		if deleted > 0 {
+			r.logger.Info("Deleted accounts past their grace period", zap.Int("count", deleted))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gin-service/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserService_ScheduleDeletion(t *testing.T) {
+	service, store, users := newMemoryUserService(t, "testuser")
+	service.SetDeletionGracePeriod(48 * time.Hour)
+
+	user, err := service.ScheduleDeletion(users[0].ID)
+
+	require.NoError(t, err)
+	assert.False(t, user.IsActive)
+	require.NotNil(t, user.DeletionScheduledAt)
+	assert.WithinDuration(t, time.Now().Add(48*time.Hour), *user.DeletionScheduledAt, time.Minute)
+
+	entry := lastAuditEntry(t, store)
+	assert.Equal(t, models.AuditActionDeletionScheduled, entry.Action)
+	assert.Equal(t, user.ID, *entry.ActorID)
+
+	// The account can't log in during the grace period
+	_, err = service.Authenticate("testuser", models.IdentifierUsername, "password123")
+	assert.EqualError(t, err, "user account is inactive")
+
+	// Asking again keeps the first schedule
+	again, err := service.ScheduleDeletion(user.ID)
+	require.NoError(t, err)
+	assert.True(t, again.DeletionScheduledAt.Equal(*user.DeletionScheduledAt))
+}
+
+func TestUserService_ScheduleDeletion_InactiveAccount(t *testing.T) {
+	service, _, users := newMemoryUserService(t, "testuser")
+	inactive := false
+	_, err := service.Update(users[0].ID, &models.UpdateUserRequest{IsActive: &inactive})
+	require.NoError(t, err)
+
+	_, err = service.ScheduleDeletion(users[0].ID)
+
+	assert.ErrorIs(t, err, ErrAccountInactive)
+}
+
+func TestUserService_CancelDeletion(t *testing.T) {
+	service, store, users := newMemoryUserService(t, "testuser")
+
+	_, err := service.CancelDeletion(users[0].ID)
+	assert.ErrorIs(t, err, ErrDeletionNotScheduled)
+
+	_, err = service.ScheduleDeletion(users[0].ID)
+	require.NoError(t, err)
+
+	user, err := service.CancelDeletion(users[0].ID)
+
+	require.NoError(t, err)
+	assert.True(t, user.IsActive)
+	assert.Nil(t, user.DeletionScheduledAt)
+
+	assert.Equal(t, models.AuditActionDeletionCancelled, lastAuditEntry(t, store).Action)
+
+	_, err = service.Authenticate("testuser", models.IdentifierUsername, "password123")
+	assert.NoError(t, err)
+}
+
+func TestUserService_Authenticate_DeletionPending(t *testing.T) {
+	service, _, users := newMemoryUserService(t, "testuser")
+	_, err := service.ScheduleDeletion(users[0].ID)
+	require.NoError(t, err)
+
+	// The login is refused, handing out the user for a cancel token
+	user, err := service.Authenticate("testuser", models.IdentifierUsername, "password123")
+	assert.Nil(t, user)
+	assert.ErrorIs(t, err, ErrAccountInactive)
+	var pending *DeletionPendingError
+	require.ErrorAs(t, err, &pending)
+	assert.Equal(t, users[0].ID, pending.User.ID)
+
+	// Once cancelled, the user logs in again
+	_, err = service.CancelDeletion(pending.User.ID)
+	require.NoError(t, err)
+	user, err = service.Authenticate("testuser", models.IdentifierUsername, "password123")
+	require.NoError(t, err)
+	assert.True(t, user.IsActive)
+}
+
+func TestUserService_Authenticate_DeactivatedByAdmin(t *testing.T) {
+	service, _, users := newMemoryUserService(t, "testuser")
+	_, err := service.SetStatus(users[0].ID, models.StatusInactive, 99)
+	require.NoError(t, err)
+
+	_, err = service.Authenticate("testuser", models.IdentifierUsername, "password123")
+	assert.ErrorIs(t, err, ErrAccountInactive)
+	var pending *DeletionPendingError
+	assert.False(t, errors.As(err, &pending))
+}
+
+func TestUserService_DeleteDueAccounts(t *testing.T) {
+	service, _, users := newMemoryUserService(t, "expired", "waiting", "kept")
+	service.SetDeletionGracePeriod(time.Hour)
+	_, err := service.ScheduleDeletion(users[0].ID)
+	require.NoError(t, err)
+	service.SetDeletionGracePeriod(48 * time.Hour)
+	_, err = service.ScheduleDeletion(users[1].ID)
+	require.NoError(t, err)
+
+	deleted, err := service.DeleteDueAccounts(time.Now().Add(2 * time.Hour))
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	expired, err := service.GetByID(users[0].ID)
+	require.NoError(t, err)
+	assert.Nil(t, expired)
+	for _, user := range users[1:] {
+		found, err := service.GetByID(user.ID)
+		require.NoError(t, err)
+		assert.NotNil(t, found, user.Username)
+	}
+
+	// Nothing else is due yet
+	deleted, err = service.DeleteDueAccounts(time.Now().Add(2 * time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"errors"
+
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// ErrLastAdmin is returned when demoting the only remaining admin
+var ErrLastAdmin = errors.New("cannot demote the last admin")
+
+// SetAdmin grants or revokes the admin role of the user id on behalf of the
+// admin adminID, recording it in the audit trail. Demoting the last admin
+// fails with ErrLastAdmin; the admins are counted under lock so concurrent
+// demotions can't remove them all. Setting the role a user already has
+// changes nothing.
+func (s *UserService) SetAdmin(id int, isAdmin bool, adminID int) (*models.User, error) {
+	var user *models.User
+	changed := false
+	err := s.inTxWithRetry(func(txService *UserService) error {
+		var err error
+		changed = false
+		user, err = txService.users.FindByID(id)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return repository.ErrUserNotFound
+		}
+		if user.IsAdmin == isAdmin {
+			return nil
+		}
+
+		if !isAdmin {
+			admins, err := txService.users.CountAdmins()
+			if err != nil {
+				return err
+			}
+			if admins <= 1 {
+				return ErrLastAdmin
+			}
+		}
+
+		user.IsAdmin = isAdmin
+		user.BeforeUpdate()
+		if err := txService.users.SetAdmin(user.ID, isAdmin, user.UpdatedAt); err != nil {
+			return err
+		}
+		changed = true
+
+		action := models.AuditActionUserDemoted
+		if isAdmin {
+			action = models.AuditActionUserPromoted
+		}
+		if err := txService.audit.Record(action, &user.ID, &adminID, map[string]interface{}{
+			"username": user.Username,
+		}); err != nil {
+			return err
+		}
+		return txService.outbox.Record(models.EventUserUpdated, user.ID, user.ToResponse())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if changed {
+		s.logger.Info("User admin role changed", zap.Int("target_user_id", user.ID), zap.Bool("is_admin", isAdmin))
+	}
+	return user, nil
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"testing"
+
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserService_SetAdmin(t *testing.T) {
+	service, store, admin, user := setupImpersonationService(t)
+
+	promoted, err := service.SetAdmin(user.ID, true, admin.ID)
+	require.NoError(t, err)
+	assert.True(t, promoted.IsAdmin)
+
+	entry := lastAuditEntry(t, store)
+	assert.Equal(t, models.AuditActionUserPromoted, entry.Action)
+	assert.Equal(t, user.ID, *entry.UserID)
+	assert.Equal(t, admin.ID, *entry.ActorID)
+
+	// Promoting an admin again changes nothing
+	entriesBefore := len(store.AuditLogEntries())
+	_, err = service.SetAdmin(user.ID, true, admin.ID)
+	require.NoError(t, err)
+	assert.Len(t, store.AuditLogEntries(), entriesBefore)
+
+	demoted, err := service.SetAdmin(admin.ID, false, user.ID)
+	require.NoError(t, err)
+	assert.False(t, demoted.IsAdmin)
+
+	entry = lastAuditEntry(t, store)
+	assert.Equal(t, models.AuditActionUserDemoted, entry.Action)
+	assert.Equal(t, admin.ID, *entry.UserID)
+	assert.Equal(t, user.ID, *entry.ActorID)
+}
+
+func TestUserService_SetAdmin_LastAdmin(t *testing.T) {
+	service, store, admin, _ := setupImpersonationService(t)
+	entriesBefore := len(store.AuditLogEntries())
+
+	_, err := service.SetAdmin(admin.ID, false, admin.ID)
+	assert.ErrorIs(t, err, ErrLastAdmin)
+
+	found, err := service.GetByID(admin.ID)
+	require.NoError(t, err)
+	assert.True(t, found.IsAdmin)
+	assert.Len(t, store.AuditLogEntries(), entriesBefore)
+
+	_, err = service.SetAdmin(42, true, admin.ID)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound)
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"fmt"
+
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// Anonymize scrubs the personal data of the user id on behalf of actorID,
+// the user themselves or an admin, keeping the row so that audit entries
+// and other references to it hold. The username becomes deleted_<id>, the
+// email a unique placeholder, and the full name, password, avatar, 2FA
+// secret and recovery codes are removed. The account is left inactive and
+// loses its admin role; anonymizing the last admin fails with ErrLastAdmin.
+func (s *UserService) Anonymize(id, actorID int) error {
+	err := s.inTxWithRetry(func(txService *UserService) error {
+		user, err := txService.users.FindByID(id)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return repository.ErrUserNotFound
+		}
+
+		if user.IsAdmin {
+			admins, err := txService.users.CountAdmins()
+			if err != nil {
+				return err
+			}
+			if admins <= 1 {
+				return ErrLastAdmin
+			}
+		}
+
+		user.Username = fmt.Sprintf("deleted_%d", user.ID)
+		user.Email = fmt.Sprintf("deleted_%d@anonymized.invalid", user.ID)
+		user.FullName = nil
+		user.Password = ""
+		user.AvatarURL = nil
+		user.TOTPSecret = nil
+		user.TOTPEnabled = false
+		user.PendingEmail = nil
+		user.EmailChangeTokenHash = nil
+		user.EmailChangeExpiresAt = nil
+		user.DeletionScheduledAt = nil
+		user.SetStatus(models.StatusInactive)
+		user.BeforeUpdate()
+		if err := txService.users.Update(user); err != nil {
+			return err
+		}
+		if user.IsAdmin {
+			user.IsAdmin = false
+			if err := txService.users.SetAdmin(user.ID, false, user.UpdatedAt); err != nil {
+				return err
+			}
+		}
+		if err := txService.store.RecoveryCodes().Replace(user.ID, nil); err != nil {
+			return err
+		}
+
+		if err := txService.audit.Record(models.AuditActionUserAnonymized, &user.ID, &actorID, nil); err != nil {
+			return err
+		}
+		return txService.outbox.Record(models.EventUserUpdated, user.ID, user.ToResponse())
+	})
+	if err != nil {
+		return err
+	}
+
+	s.deleteAvatar(id)
+
+	s.logger.Info("User anonymized", zap.Int("target_user_id", id))
+	return nil
+}
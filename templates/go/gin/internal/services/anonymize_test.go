@@ -0,0 +1,51 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserService_Anonymize(t *testing.T) {
+	service, store, admin, user := setupImpersonationService(t)
+	fullName := "Test User"
+	_, err := service.Update(user.ID, &models.UpdateUserRequest{FullName: models.NullableValue(fullName)})
+	require.NoError(t, err)
+	require.NoError(t, store.RecoveryCodes().Replace(user.ID, []string{"hash-a"}))
+
+	require.NoError(t, service.Anonymize(user.ID, user.ID))
+
+	anonymized, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+	deletedName := fmt.Sprintf("deleted_%d", user.ID)
+	assert.Equal(t, deletedName, anonymized.Username)
+	assert.Equal(t, deletedName+"@anonymized.invalid", anonymized.Email)
+	assert.Nil(t, anonymized.FullName)
+	assert.Empty(t, anonymized.Password)
+	assert.Equal(t, models.StatusInactive, anonymized.Status)
+
+	codes, err := store.RecoveryCodes().ListByUser(user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, codes)
+
+	// The account can't log in under either name
+	_, err = service.Authenticate("testuser", models.IdentifierUsername, "password123")
+	assert.Error(t, err)
+	_, err = service.Authenticate(deletedName, models.IdentifierUsername, "password123")
+	assert.Error(t, err)
+
+	// Earlier audit entries still reference the row
+	bundle, err := service.ExportUserData(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.AuditActionUserCreated, bundle.AuditLogs[0].Action)
+	assert.Equal(t, models.AuditActionUserAnonymized, bundle.AuditLogs[len(bundle.AuditLogs)-1].Action)
+
+	// The last admin can't be anonymized
+	assert.ErrorIs(t, service.Anonymize(admin.ID, admin.ID), ErrLastAdmin)
+	assert.ErrorIs(t, service.Anonymize(99, admin.ID), repository.ErrUserNotFound)
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// AuditService records entries in the audit trail
+type AuditService struct {
+	repo   repository.AuditLogRepository
+	logger *zap.Logger
+}
+
+// NewAuditService creates a new audit service
+func NewAuditService(repo repository.AuditLogRepository, logger *zap.Logger) *AuditService {
+	return &AuditService{
+		repo:   repo,
+		logger: logger,
+	}
+}
+
+// Record writes an audit entry. details is marshalled to JSON when not nil.
+func (a *AuditService) Record(action string, userID, actorID *int, details interface{}) error {
+	entry := &models.AuditLog{
+		UserID:  userID,
+		ActorID: actorID,
+		Action:  action,
+	}
+
+	if details != nil {
+		data, err := json.Marshal(details)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit details: %w", err)
+		}
+		detailsJSON := string(data)
+		entry.Details = &detailsJSON
+	}
+
+	if err := a.repo.Create(entry); err != nil {
+		a.logger.Error("Failed to write audit log", zap.Error(err), zap.String("action", action))
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // register the GIF decoder
+	_ "image/jpeg" // register the JPEG decoder
+	_ "image/png"  // register the PNG decoder
+	"net/http"
+	"strconv"
+	"time"
+
+	"gin-service/internal/models"
+	"gin-service/internal/storage"
+
+	"go.uber.org/zap"
+)
+
+// ErrAvatarsDisabled is returned by SetAvatar when no blob store is configured
+var ErrAvatarsDisabled = errors.New("avatar uploads are not configured")
+
+// avatarContentTypes are the accepted avatar image types
+var avatarContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// AvatarLimits bounds the size and dimensions of avatar images
+type AvatarLimits struct {
+	MaxSize   int64
+	MaxWidth  int
+	MaxHeight int
+}
+
+// AvatarError reports an avatar image that was rejected
+type AvatarError struct {
+	Message string
+}
+
+func (e *AvatarError) Error() string {
+	return e.Message
+}
+
+// ValidateAvatar checks that data is a PNG, JPEG or GIF image within limits,
+// returning its content type. The type is sniffed from the data itself, not
+// taken from the client.
+func ValidateAvatar(data []byte, limits AvatarLimits) (string, error) {
+	if len(data) == 0 {
+		return "", &AvatarError{Message: "avatar file is empty"}
+	}
+	if limits.MaxSize > 0 && int64(len(data)) > limits.MaxSize {
+		return "", &AvatarError{Message: fmt.Sprintf("avatar must be at most %d bytes", limits.MaxSize)}
+	}
+
+	contentType := http.DetectContentType(data)
+	if !avatarContentTypes[contentType] {
+		return "", &AvatarError{Message: "avatar must be a PNG, JPEG or GIF image"}
+	}
+
+	// Check the dimensions from the header before decoding, so small files
+	// declaring huge images are never decompressed
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", &AvatarError{Message: "avatar is not a valid image"}
+	}
+	if (limits.MaxWidth > 0 && cfg.Width > limits.MaxWidth) || (limits.MaxHeight > 0 && cfg.Height > limits.MaxHeight) {
+		return "", &AvatarError{Message: fmt.Sprintf("avatar must be at most %dx%d pixels", limits.MaxWidth, limits.MaxHeight)}
+	}
+	if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+		return "", &AvatarError{Message: "avatar is not a valid image"}
+	}
+
+	return contentType, nil
+}
+
+// avatarKey returns the blob key of a user's avatar. Each user has a single
+// key, so a new upload replaces the previous image.
+func avatarKey(userID int) string {
+	return "avatars/" + strconv.Itoa(userID)
+}
+
+// SetAvatarStore configures where avatars are stored and their limits
+func (s *UserService) SetAvatarStore(blobs storage.BlobStore, limits AvatarLimits) {
+	s.blobs = blobs
+	s.avatarLimits = limits
+}
+
+// SetAvatar validates and stores a user's avatar image, saving its URL on
+// the user. Invalid images yield an *AvatarError.
+func (s *UserService) SetAvatar(ctx context.Context, id int, data []byte) (*models.User, error) {
+	if s.blobs == nil {
+		return nil, ErrAvatarsDisabled
+	}
+
+	contentType, err := ValidateAvatar(data, s.avatarLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	url, err := s.blobs.Put(ctx, avatarKey(id), contentType, bytes.NewReader(data))
+	if err != nil {
+		s.logger.Error("Failed to store avatar", zap.Error(err), zap.Int("target_user_id", id))
+		return nil, fmt.Errorf("failed to store avatar: %w", err)
+	}
+
+	// The key is reused, so version the URL to bust caches
+	url += "?v=" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	user.AvatarURL = &url
+	user.BeforeUpdate()
+
+	err = s.inTxWithRetry(func(txService *UserService) error {
+		if err := txService.users.Update(user); err != nil {
+			txService.logger.Error("Failed to save avatar", zap.Error(err), zap.Int("target_user_id", id))
+			return fmt.Errorf("failed to save avatar: %w", err)
+		}
+		return txService.outbox.Record(models.EventUserUpdated, user.ID, user.ToResponse())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("User avatar updated", zap.Int("target_user_id", id), zap.Int("size", len(data)))
+	return user, nil
+}
+
+// deleteAvatar removes a deleted user's avatar. Failures are only logged:
+// the user is already gone and a leftover blob is harmless.
+func (s *UserService) deleteAvatar(id int) {
+	if s.blobs == nil {
+		return
+	}
+	if err := s.blobs.Delete(context.Background(), avatarKey(id)); err != nil {
+		s.logger.Warn("Failed to delete avatar", zap.Error(err), zap.Int("target_user_id", id))
+	}
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// ExportUserData gathers everything stored about the user id, for data
+// subject access requests. It returns repository.ErrUserNotFound if the user
+// does not exist.
+func (s *UserService) ExportUserData(id int) (*models.UserDataBundle, error) {
+	user, err := s.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, repository.ErrUserNotFound
+	}
+
+	codes, err := s.store.RecoveryCodes().ListByUser(id)
+	if err != nil {
+		s.logger.Error("Failed to list recovery codes", zap.Error(err), zap.Int("target_user_id", id))
+		return nil, fmt.Errorf("failed to list recovery codes: %w", err)
+	}
+	auditLogs, err := s.store.AuditLogs().ListByUser(id)
+	if err != nil {
+		s.logger.Error("Failed to list audit logs", zap.Error(err), zap.Int("target_user_id", id))
+		return nil, fmt.Errorf("failed to list audit logs: %w", err)
+	}
+
+	bundle := &models.UserDataBundle{
+		ExportedAt:    time.Now().UTC(),
+		Profile:       user.ToResponse(),
+		RecoveryCodes: codes,
+		AuditLogs:     auditLogs,
+	}
+	if user.PendingEmail != nil {
+		bundle.EmailChangeExpiresAt = user.EmailChangeExpiresAt
+	}
+	// Export empty lists rather than nulls
+	if bundle.RecoveryCodes == nil {
+		bundle.RecoveryCodes = []models.RecoveryCode{}
+	}
+	if bundle.AuditLogs == nil {
+		bundle.AuditLogs = []*models.AuditLog{}
+	}
+
+	s.logger.Info("User data exported", zap.Int("target_user_id", id))
+	return bundle, nil
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserService_ExportUserData(t *testing.T) {
+	service, store, admin, user := setupImpersonationService(t)
+	require.NoError(t, store.RecoveryCodes().Replace(user.ID, []string{"hash-1", "hash-2"}))
+	_, err := service.SetStatus(user.ID, models.StatusSuspended, admin.ID)
+	require.NoError(t, err)
+
+	bundle, err := service.ExportUserData(user.ID)
+
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, bundle.Profile.ID)
+	assert.Equal(t, models.StatusSuspended, bundle.Profile.Status)
+	assert.Len(t, bundle.RecoveryCodes, 2)
+
+	// Entries about the user, whoever performed them
+	var actions []string
+	for _, entry := range bundle.AuditLogs {
+		actions = append(actions, entry.Action)
+	}
+	assert.Equal(t, []string{models.AuditActionUserCreated, models.AuditActionStatusChanged}, actions)
+
+	// Credentials are never exported
+	data, err := json.Marshal(bundle)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "hash-1")
+	assert.NotContains(t, string(data), "password")
+
+	// The admin's export includes what they did to others
+	bundle, err = service.ExportUserData(admin.ID)
+	require.NoError(t, err)
+	assert.Empty(t, bundle.RecoveryCodes)
+	assert.Equal(t, models.AuditActionStatusChanged, bundle.AuditLogs[len(bundle.AuditLogs)-1].Action)
+
+	_, err = service.ExportUserData(99)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound)
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"gin-service/internal/mail"
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrInvalidEmailChangeToken is returned when confirming an email change
+	// with a wrong token, or without a pending change
+	ErrInvalidEmailChangeToken = errors.New("invalid email change token")
+	// ErrEmailChangeExpired is returned when confirming an email change after
+	// its token expired
+	ErrEmailChangeExpired = errors.New("email change token has expired")
+)
+
+// defaultEmailChangeTTL is how long an email change can be confirmed when no
+// TTL is configured
+const defaultEmailChangeTTL = 24 * time.Hour
+
+// EmailChangeOptions configures how email changes are confirmed. The
+// emails are sent with the service's mailer.
+type EmailChangeOptions struct {
+	// TTL is how long the confirmation token stays valid
+	TTL time.Duration
+	// ConfirmURL is the link sent to the new address, with the token added
+	// as the token query parameter. Without it the bare token is sent.
+	ConfirmURL string
+}
+
+// SetEmailChange configures the confirmation of email changes
+func (s *UserService) SetEmailChange(opts EmailChangeOptions) {
+	if opts.TTL <= 0 {
+		opts.TTL = defaultEmailChangeTTL
+	}
+	s.emailChange = opts
+}
+
+// requestEmailChange stores email as the user's pending email along with
+// the hash of a new confirmation token, which it returns. The user is not
+// saved.
+func (s *UserService) requestEmailChange(user *models.User, email string) (string, error) {
+	existingUser, err := s.GetByEmail(email)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("failed to check existing email: %w", err)
+	}
+	if existingUser != nil {
+		return "", repository.ErrDuplicateEmail
+	}
+
+	token, hash, err := newEmailChangeToken()
+	if err != nil {
+		return "", err
+	}
+	expiresAt := time.Now().Add(s.emailChange.TTL)
+
+	user.PendingEmail = &email
+	user.EmailChangeTokenHash = &hash
+	user.EmailChangeExpiresAt = &expiresAt
+	return token, nil
+}
+
+// sendEmailChange sends the confirmation link to the pending address and
+// warns the current one. Failures are only logged: the change stays pending
+// and can be requested again.
+func (s *UserService) sendEmailChange(user *models.User, token string) {
+	link := token
+	if s.emailChange.ConfirmURL != "" {
+		confirmURL, err := url.Parse(s.emailChange.ConfirmURL)
+		if err != nil {
+			s.logger.Error("Invalid email confirmation URL", zap.Error(err))
+			return
+		}
+		query := confirmURL.Query()
+		query.Set("token", token)
+		confirmURL.RawQuery = query.Encode()
+		link = confirmURL.String()
+	}
+
+	emails := []struct {
+		template string
+		to       string
+		data     interface{}
+	}{
+		{"email_change_confirm", *user.PendingEmail, map[string]string{
+			"Username":  user.Username,
+			"Link":      link,
+			"ExpiresAt": user.EmailChangeExpiresAt.UTC().Format(time.RFC1123),
+		}},
+		{"email_change_notice", user.Email, map[string]string{
+			"Username": user.Username,
+			"NewEmail": *user.PendingEmail,
+		}},
+	}
+	for _, email := range emails {
+		msg, err := mail.Render(email.template, email.to, email.data)
+		if err == nil {
+			err = s.mailer.Send(context.Background(), msg)
+		}
+		if err != nil {
+			s.logger.Error("Failed to send email change email", zap.Error(err), zap.Int("target_user_id", user.ID))
+		}
+	}
+}
+
+// ConfirmEmail replaces the user's email with the pending one after checking
+// the token sent to it. An expired token leaves the email unchanged.
+func (s *UserService) ConfirmEmail(id int, token string) (*models.User, error) {
+	user, err := s.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if user.PendingEmail == nil || user.EmailChangeTokenHash == nil ||
+		subtle.ConstantTimeCompare([]byte(hashEmailChangeToken(token)), []byte(*user.EmailChangeTokenHash)) != 1 {
+		return nil, ErrInvalidEmailChangeToken
+	}
+	if user.EmailChangeExpiresAt == nil || time.Now().After(*user.EmailChangeExpiresAt) {
+		return nil, ErrEmailChangeExpired
+	}
+
+	// The address may have been taken since the change was requested
+	existingUser, err := s.GetByEmail(*user.PendingEmail)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to check existing email: %w", err)
+	}
+	if existingUser != nil {
+		return nil, repository.ErrDuplicateEmail
+	}
+
+	oldEmail := user.Email
+	user.Email = *user.PendingEmail
+	user.ClearEmailChange()
+	user.BeforeUpdate()
+
+	err = s.inTxWithRetry(func(txService *UserService) error {
+		if err := txService.users.Update(user); err != nil {
+			if errors.Is(err, repository.ErrDuplicateEmail) {
+				return err
+			}
+			txService.logger.Error("Failed to confirm email change", zap.Error(err), zap.Int("target_user_id", id))
+			return fmt.Errorf("failed to confirm email change: %w", err)
+		}
+		if err := txService.audit.Record(models.AuditActionEmailChanged, &user.ID, txService.actor(user.ID), map[string]interface{}{
+			"old_email": oldEmail,
+			"email":     user.Email,
+		}); err != nil {
+			return err
+		}
+		return txService.outbox.Record(models.EventUserUpdated, user.ID, user.ToResponse())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Email change confirmed", zap.Int("target_user_id", id))
+	return user, nil
+}
+
+// newEmailChangeToken generates a random confirmation token along with its
+// hash
+func newEmailChangeToken() (token, hash string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", fmt.Errorf("failed to generate email change token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashEmailChangeToken(token), nil
+}
+
+// hashEmailChangeToken hashes a confirmation token. Tokens carry 256 random
+// bits, so a fast hash suffices.
+func hashEmailChangeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gin-service/internal/mail"
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingSender keeps the emails it is asked to send
+type recordingSender struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (r *recordingSender) Send(ctx context.Context, msg mail.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, msg)
+	return nil
+}
+
+func setupEmailChangeService(t *testing.T) (*UserService, *repository.MemoryStore, *recordingSender, *models.User) {
+	service, store, users := newMemoryUserService(t, "testuser")
+	sender := &recordingSender{}
+	service.SetMailer(sender)
+	service.SetEmailChange(EmailChangeOptions{
+		TTL:        time.Hour,
+		ConfirmURL: "https://example.com/confirm-email",
+	})
+	return service, store, sender, users[0]
+}
+
+// requestEmailChange changes the user's email, returning the token from the
+// confirmation link
+func requestEmailChange(t *testing.T, service *UserService, sender *recordingSender, id int, email string) string {
+	_, err := service.Update(id, &models.UpdateUserRequest{Email: &email})
+	require.NoError(t, err)
+
+	require.NotEmpty(t, sender.messages)
+	body := sender.messages[len(sender.messages)-2].Body
+	start := strings.Index(body, "https://")
+	require.GreaterOrEqual(t, start, 0, body)
+	link, err := url.Parse(strings.Fields(body[start:])[0])
+	require.NoError(t, err)
+	return link.Query().Get("token")
+}
+
+func TestUserService_Update_RequestsEmailChange(t *testing.T) {
+	service, store, sender, user := setupEmailChangeService(t)
+
+	newEmail := "new@example.com"
+	updated, err := service.Update(user.ID, &models.UpdateUserRequest{Email: &newEmail})
+
+	require.NoError(t, err)
+	assert.Equal(t, "testuser@example.com", updated.Email)
+	require.NotNil(t, updated.PendingEmail)
+	assert.Equal(t, "new@example.com", *updated.ToResponse().PendingEmail)
+
+	stored, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "testuser@example.com", stored.Email)
+	assert.Equal(t, "new@example.com", *stored.PendingEmail)
+
+	// The new address gets the link, the old one a warning
+	require.Len(t, sender.messages, 2)
+	assert.Equal(t, "new@example.com", sender.messages[0].To)
+	assert.Equal(t, "Confirm your new email address", sender.messages[0].Subject)
+	assert.Contains(t, sender.messages[0].Body, "https://example.com/confirm-email?token=")
+	assert.Contains(t, sender.messages[0].HTML, `<a href="https://example.com/confirm-email?token=`)
+	assert.Equal(t, "testuser@example.com", sender.messages[1].To)
+	assert.Equal(t, "Your email address is being changed", sender.messages[1].Subject)
+	assert.Contains(t, sender.messages[1].Body, "new@example.com")
+	assert.NotContains(t, sender.messages[1].Body, "token=")
+
+	assert.Equal(t, models.AuditActionEmailChangeRequested, lastAuditEntry(t, store).Action)
+}
+
+func TestUserService_ConfirmEmail(t *testing.T) {
+	service, store, sender, user := setupEmailChangeService(t)
+	token := requestEmailChange(t, service, sender, user.ID, "new@example.com")
+
+	confirmed, err := service.ConfirmEmail(user.ID, token)
+
+	require.NoError(t, err)
+	assert.Equal(t, "new@example.com", confirmed.Email)
+	assert.Nil(t, confirmed.PendingEmail)
+
+	stored, err := service.GetByEmail("new@example.com")
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Nil(t, stored.EmailChangeTokenHash)
+
+	assert.Equal(t, models.AuditActionEmailChanged, lastAuditEntry(t, store).Action)
+
+	// The token works once
+	_, err = service.ConfirmEmail(user.ID, token)
+	assert.ErrorIs(t, err, ErrInvalidEmailChangeToken)
+}
+
+func TestUserService_ConfirmEmail_ExpiredTokenKeepsEmail(t *testing.T) {
+	service, _, sender, user := setupEmailChangeService(t)
+	token := requestEmailChange(t, service, sender, user.ID, "new@example.com")
+
+	stored, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+	expired := time.Now().Add(-time.Minute)
+	stored.EmailChangeExpiresAt = &expired
+	require.NoError(t, service.users.Update(stored))
+
+	_, err = service.ConfirmEmail(user.ID, token)
+
+	assert.ErrorIs(t, err, ErrEmailChangeExpired)
+	stored, err = service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "testuser@example.com", stored.Email)
+}
+
+func TestUserService_ConfirmEmail_Rejects(t *testing.T) {
+	service, _, sender, user := setupEmailChangeService(t)
+
+	// Nothing pending
+	_, err := service.ConfirmEmail(user.ID, "token")
+	assert.ErrorIs(t, err, ErrInvalidEmailChangeToken)
+
+	_, err = service.ConfirmEmail(999, "token")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	token := requestEmailChange(t, service, sender, user.ID, "new@example.com")
+
+	_, err = service.ConfirmEmail(user.ID, "wrong-token")
+	assert.ErrorIs(t, err, ErrInvalidEmailChangeToken)
+
+	// Someone else took the address in the meantime
+	_, err = service.Create(&models.CreateUserRequest{
+		Username: "otheruser",
+		Email:    "new@example.com",
+		Password: "password123",
+	})
+	require.NoError(t, err)
+
+	_, err = service.ConfirmEmail(user.ID, token)
+	assert.EqualError(t, err, "email already exists")
+	stored, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "testuser@example.com", stored.Email)
+}
+
+func TestUserService_Update_CurrentEmailCancelsChange(t *testing.T) {
+	service, _, sender, user := setupEmailChangeService(t)
+	token := requestEmailChange(t, service, sender, user.ID, "new@example.com")
+
+	oldEmail := "testuser@example.com"
+	updated, err := service.Update(user.ID, &models.UpdateUserRequest{Email: &oldEmail})
+	require.NoError(t, err)
+	assert.Nil(t, updated.PendingEmail)
+
+	_, err = service.ConfirmEmail(user.ID, token)
+	assert.ErrorIs(t, err, ErrInvalidEmailChangeToken)
+}
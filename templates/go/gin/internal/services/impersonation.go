@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"gin-service/internal/models"
+
+	"go.uber.org/zap"
+)
+
+// ErrCannotImpersonate is returned when an admin tries to impersonate
+// themselves or an inactive user
+var ErrCannotImpersonate = errors.New("user cannot be impersonated")
+
+// impersonatorKey is the context key of the impersonating admin's ID
+type impersonatorKey struct{}
+
+// ContextWithImpersonator returns a copy of ctx recording that the request is
+// made by the admin adminID impersonating another user
+func ContextWithImpersonator(ctx context.Context, adminID int) context.Context {
+	return context.WithValue(ctx, impersonatorKey{}, adminID)
+}
+
+// ImpersonatorFromContext returns the ID of the impersonating admin recorded
+// in ctx, if any
+func ImpersonatorFromContext(ctx context.Context) (int, bool) {
+	adminID, ok := ctx.Value(impersonatorKey{}).(int)
+	return adminID, ok
+}
+
+// Impersonate checks that the admin adminID may act as the user targetID and
+// records it in the audit trail. The caller issues the token.
+func (s *UserService) Impersonate(targetID, adminID int) (*models.User, error) {
+	user, err := s.GetByID(targetID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if user.ID == adminID || !user.IsActive {
+		return nil, ErrCannotImpersonate
+	}
+
+	if err := s.audit.Record(models.AuditActionUserImpersonated, &user.ID, &adminID, map[string]interface{}{
+		"username": user.Username,
+	}); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("User impersonated", zap.Int("target_user_id", user.ID), zap.String("username", user.Username))
+	return user, nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupImpersonationService(t *testing.T) (*UserService, *repository.MemoryStore, *models.User, *models.User) {
+	service, store, users := newMemoryUserService(t, "testuser")
+
+	admin, err := service.CreateAdmin(&models.CreateUserRequest{
+		Username: "admin",
+		Email:    "admin@example.com",
+		Password: "password123",
+	}, false)
+	require.NoError(t, err)
+	return service, store, admin, users[0]
+}
+
+func TestUserService_Impersonate_RecordsAudit(t *testing.T) {
+	service, store, admin, user := setupImpersonationService(t)
+
+	impersonated, err := service.Impersonate(user.ID, admin.ID)
+
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, impersonated.ID)
+
+	entry := lastAuditEntry(t, store)
+	assert.Equal(t, models.AuditActionUserImpersonated, entry.Action)
+	assert.Equal(t, user.ID, *entry.UserID)
+	assert.Equal(t, admin.ID, *entry.ActorID)
+}
+
+func TestUserService_Impersonate_Rejects(t *testing.T) {
+	service, store, admin, user := setupImpersonationService(t)
+	inactive := false
+	_, err := service.Update(user.ID, &models.UpdateUserRequest{IsActive: &inactive})
+	require.NoError(t, err)
+	entriesBefore := len(store.AuditLogEntries())
+
+	_, err = service.Impersonate(admin.ID, admin.ID)
+	assert.ErrorIs(t, err, ErrCannotImpersonate)
+
+	_, err = service.Impersonate(user.ID, admin.ID)
+	assert.ErrorIs(t, err, ErrCannotImpersonate)
+
+	_, err = service.Impersonate(999, admin.ID)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	assert.Len(t, store.AuditLogEntries(), entriesBefore)
+}
+
+func TestUserService_WithContext_AttributesAuditToImpersonator(t *testing.T) {
+	service, store, user := setupTwoFactorService(t)
+	ctx := ContextWithImpersonator(context.Background(), 7)
+
+	setup, err := service.WithContext(ctx).SetupTwoFactor(user.ID)
+	require.NoError(t, err)
+	code, err := totp.GenerateCode(setup.Secret, time.Now())
+	require.NoError(t, err)
+	_, _, err = service.WithContext(ctx).EnableTwoFactor(user.ID, code)
+	require.NoError(t, err)
+
+	entry := lastAuditEntry(t, store)
+	assert.Equal(t, models.AuditActionTwoFactorEnabled, entry.Action)
+	assert.Equal(t, user.ID, *entry.UserID)
+	assert.Equal(t, 7, *entry.ActorID)
+}
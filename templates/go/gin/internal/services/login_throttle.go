@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// LoginThrottle limits the login attempts made under a key, such as a client
+// IP. middleware.SlidingLog implements it.
+type LoginThrottle interface {
+	// Allow records an attempt for key if the limit allows it, and otherwise
+	// tells how long until it does
+	Allow(key string) (bool, time.Duration)
+}
+
+// LoginThrottledError is returned for a login attempt rejected by the login
+// throttle, before the credentials are checked
+type LoginThrottledError struct {
+	// RetryAfter is how long until the client may try again
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("too many login attempts, retry after %s", e.RetryAfter)
+}
+
+// clientIPKey is the context key of the client's IP address
+type clientIPKey struct{}
+
+// ContextWithClientIP returns a copy of ctx recording the IP address the
+// request comes from, by which login attempts are throttled
+func ContextWithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIPFromContext returns the client IP recorded in ctx, if any
+func ClientIPFromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(clientIPKey{}).(string)
+	return ip, ok && ip != ""
+}
+
+// SetLoginThrottle limits the login attempts of each client IP with
+// throttle, whichever API they come through. Services without a client IP,
+// such as those of commands, are not throttled.
+func (s *UserService) SetLoginThrottle(throttle LoginThrottle) {
+	s.loginThrottle = throttle
+}
+
+// throttleLogin records a login attempt from the service's client IP,
+// returning a *LoginThrottledError once the IP made too many
+func (s *UserService) throttleLogin() error {
+	if s.loginThrottle == nil || s.clientIP == "" {
+		return nil
+	}
+	if allowed, retryAfter := s.loginThrottle.Allow(s.clientIP); !allowed {
+		s.logger.Warn("Login attempts throttled", zap.String("client_ip", s.clientIP))
+		return &LoginThrottledError{RetryAfter: retryAfter}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// countingThrottle allows limit attempts per key
+type countingThrottle struct {
+	limit    int
+	attempts map[string]int
+}
+
+func (c *countingThrottle) Allow(key string) (bool, time.Duration) {
+	if c.attempts[key] >= c.limit {
+		return false, time.Minute
+	}
+	c.attempts[key]++
+	return true, 0
+}
+
+func TestUserService_Authenticate_Throttled(t *testing.T) {
+	service := NewUserService(repository.NewMemoryStore(), zap.NewNop())
+	service.SetLoginThrottle(&countingThrottle{limit: 3, attempts: map[string]int{}})
+	_, err := service.Create(&models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	require.NoError(t, err)
+
+	fromIP := func(ip string) UserServiceInterface {
+		return service.WithContext(ContextWithClientIP(context.Background(), ip))
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := fromIP("10.0.0.1").Authenticate("testuser", models.IdentifierAuto, "wrongpassword")
+		require.Error(t, err)
+		var throttled *LoginThrottledError
+		assert.False(t, errors.As(err, &throttled))
+	}
+
+	// Further attempts from the IP are rejected, even with the right password
+	_, err = fromIP("10.0.0.1").Authenticate("testuser", models.IdentifierAuto, "password123")
+	var throttled *LoginThrottledError
+	require.ErrorAs(t, err, &throttled)
+	assert.Equal(t, time.Minute, throttled.RetryAfter)
+
+	// Other IPs can still log in, as can callers without a client IP
+	user, err := fromIP("10.0.0.2").Authenticate("testuser", models.IdentifierAuto, "password123")
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", user.Username)
+
+	_, err = service.Authenticate("testuser", models.IdentifierAuto, "password123")
+	assert.NoError(t, err)
+}
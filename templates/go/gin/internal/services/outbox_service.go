@@ -0,0 +1,48 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// OutboxService records events in the transactional outbox. Events must be
+// recorded with a transaction-bound repository so they are only published
+// when the change they describe commits.
+type OutboxService struct {
+	repo   repository.OutboxRepository
+	logger *zap.Logger
+}
+
+// NewOutboxService creates a new outbox service
+func NewOutboxService(repo repository.OutboxRepository, logger *zap.Logger) *OutboxService {
+	return &OutboxService{
+		repo:   repo,
+		logger: logger,
+	}
+}
+
+// Record writes an event to the outbox. payload is marshalled to JSON.
+func (o *OutboxService) Record(eventType string, aggregateID int, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox payload: %w", err)
+	}
+
+	event := &models.OutboxEvent{
+		EventType:   eventType,
+		AggregateID: aggregateID,
+		Payload:     string(data),
+	}
+
+	if err := o.repo.Add(event); err != nil {
+		o.logger.Error("Failed to write outbox event", zap.Error(err), zap.String("event_type", eventType))
+		return fmt.Errorf("failed to write outbox event: %w", err)
+	}
+
+	return nil
+}
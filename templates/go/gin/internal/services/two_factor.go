@@ -0,0 +1,391 @@
+package services
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/png"
+	"strings"
+	"time"
+
+	"gin-service/internal/models"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrTwoFactorDisabled is returned when 2FA is not configured
+	ErrTwoFactorDisabled = errors.New("two-factor authentication is not configured")
+	// ErrTwoFactorAlreadyEnabled is returned when setting up 2FA for a user
+	// who already has it enabled
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTwoFactorNotSetUp is returned when enabling 2FA before setting it up
+	ErrTwoFactorNotSetUp = errors.New("two-factor authentication has not been set up")
+	// ErrTwoFactorNotEnabled is returned when verifying a code for a user
+	// without 2FA
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	// ErrInvalidTwoFactorCode is returned for a wrong, expired or already used
+	// TOTP or recovery code
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+)
+
+// recoveryCodeCount is the number of recovery codes issued when 2FA is enabled
+const recoveryCodeCount = 10
+
+// recoveryCodeEncoding encodes recovery codes in lowercase base32, leaving
+// out l, o, 0 and 1, which are easily confused
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+
+// TwoFactorOptions configures TOTP two-factor authentication
+type TwoFactorOptions struct {
+	// Issuer names the service in authenticator apps
+	Issuer string
+	// Cipher encrypts TOTP secrets at rest
+	Cipher *SecretCipher
+	// Skew is the number of 30s steps a code may be early or late, to
+	// tolerate clock drift between the server and the user's device
+	Skew uint
+	// ChallengeTTL is the lifetime of login challenges, for which the codes
+	// tried with each are remembered
+	ChallengeTTL time.Duration
+	// MaxAttempts is the number of wrong codes after which a challenge is
+	// refused, the user having to log in again
+	MaxAttempts int
+	// MaxFailures is the number of wrong codes a user may enter within
+	// FailureWindow, whatever the challenge, before their codes are refused
+	MaxFailures   int
+	FailureWindow time.Duration
+}
+
+// TwoFactorSetup is a newly generated TOTP secret awaiting confirmation
+type TwoFactorSetup struct {
+	Secret     string
+	OTPAuthURL string
+	// QRCode is a PNG image of the otpauth URL
+	QRCode []byte
+}
+
+// SetTwoFactor enables TOTP two-factor authentication
+func (s *UserService) SetTwoFactor(opts TwoFactorOptions) {
+	s.twoFactor = opts
+	s.twoFactorAttempts = newTwoFactorAttempts(opts)
+}
+
+// SetupTwoFactor generates a new TOTP secret for a user. The secret is saved
+// but only required at login once EnableTwoFactor confirms the user can
+// generate codes with it; repeating the setup replaces it.
+func (s *UserService) SetupTwoFactor(id int) (*TwoFactorSetup, error) {
+	if s.twoFactor.Cipher == nil {
+		return nil, ErrTwoFactorDisabled
+	}
+
+	user, err := s.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      s.twoFactor.Issuer,
+		AccountName: user.Username,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+
+	encrypted, err := s.twoFactor.Cipher.Encrypt(key.Secret())
+	if err != nil {
+		return nil, err
+	}
+	user.TOTPSecret = &encrypted
+	user.BeforeUpdate()
+
+	if err := s.users.Update(user); err != nil {
+		s.logger.Error("Failed to save TOTP secret", zap.Error(err), zap.Int("target_user_id", id))
+		return nil, fmt.Errorf("failed to save TOTP secret: %w", err)
+	}
+
+	qr, err := key.Image(256, 256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render QR code: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, qr); err != nil {
+		return nil, fmt.Errorf("failed to render QR code: %w", err)
+	}
+
+	s.logger.Info("Two-factor setup started", zap.Int("target_user_id", id))
+	return &TwoFactorSetup{
+		Secret:     key.Secret(),
+		OTPAuthURL: key.URL(),
+		QRCode:     buf.Bytes(),
+	}, nil
+}
+
+// EnableTwoFactor turns on 2FA for a user after checking code against the
+// secret from SetupTwoFactor. It returns the user's recovery codes, which
+// are only stored hashed and cannot be retrieved again.
+func (s *UserService) EnableTwoFactor(id int, code string) (*models.User, []string, error) {
+	if s.twoFactor.Cipher == nil {
+		return nil, nil, ErrTwoFactorDisabled
+	}
+
+	user, err := s.GetByID(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		return nil, nil, ErrUserNotFound
+	}
+	if user.TOTPEnabled {
+		return nil, nil, ErrTwoFactorAlreadyEnabled
+	}
+	if user.TOTPSecret == nil {
+		return nil, nil, ErrTwoFactorNotSetUp
+	}
+
+	_, ok, err := s.validateTOTP(user, code)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := newRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user.TOTPEnabled = true
+	user.BeforeUpdate()
+
+	err = s.inTxWithRetry(func(txService *UserService) error {
+		if err := txService.users.Update(user); err != nil {
+			txService.logger.Error("Failed to enable two-factor", zap.Error(err), zap.Int("target_user_id", id))
+			return fmt.Errorf("failed to enable two-factor: %w", err)
+		}
+		if err := txService.store.RecoveryCodes().Replace(id, hashes); err != nil {
+			txService.logger.Error("Failed to save recovery codes", zap.Error(err), zap.Int("target_user_id", id))
+			return fmt.Errorf("failed to save recovery codes: %w", err)
+		}
+		return txService.audit.Record(models.AuditActionTwoFactorEnabled, &user.ID, txService.actor(user.ID), nil)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.logger.Info("Two-factor enabled", zap.Int("target_user_id", id))
+	return user, codes, nil
+}
+
+// VerifyTwoFactor checks the second factor of a login, which is either a
+// current TOTP code or an unused recovery code, entered for the challenge
+// with the given ID. Each challenge completes a single login and is refused
+// with ErrTwoFactorChallengeUsed after too many wrong codes. Too many wrong
+// codes for the user, or attempts from the client IP, are refused with a
+// *LoginThrottledError. A TOTP code is accepted once, as is each recovery
+// code. Accounts pending deletion fail with a *DeletionPendingError once
+// the code is checked.
+func (s *UserService) VerifyTwoFactor(id int, challengeID, code string) (*models.User, error) {
+	if s.twoFactor.Cipher == nil {
+		return nil, ErrTwoFactorDisabled
+	}
+	if err := s.throttleLogin(); err != nil {
+		return nil, err
+	}
+	if err := s.twoFactorAttempts.begin(challengeID, id); err != nil {
+		if errors.Is(err, ErrTwoFactorChallengeUsed) {
+			s.logger.Warn("Two-factor challenge refused", zap.Int("target_user_id", id))
+		} else {
+			s.logger.Warn("Two-factor codes throttled", zap.Int("target_user_id", id))
+		}
+		return nil, err
+	}
+
+	user, err := s.verifyTwoFactorCode(id, code)
+	switch {
+	case err == nil:
+		s.twoFactorAttempts.succeed(id)
+	case errors.Is(err, ErrInvalidTwoFactorCode):
+		s.twoFactorAttempts.fail(challengeID, id)
+	default:
+		s.twoFactorAttempts.abort(challengeID)
+	}
+	if err == nil && user.DeletionPending() {
+		return nil, &DeletionPendingError{User: user}
+	}
+	return user, err
+}
+
+// verifyTwoFactorCode checks code as the second factor of the user's login
+func (s *UserService) verifyTwoFactorCode(id int, code string) (*models.User, error) {
+	user, err := s.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !(user.IsActive || user.DeletionPending()) {
+		return nil, ErrInvalidCredentials
+	}
+	if !user.TOTPEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	step, ok, err := s.validateTOTP(user, code)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		used, err := s.users.UseTOTPStep(id, step)
+		if err != nil {
+			s.logger.Error("Failed to record TOTP step", zap.Error(err), zap.Int("target_user_id", id))
+			return nil, fmt.Errorf("failed to record TOTP step: %w", err)
+		}
+		if !used {
+			s.logger.Warn("Replayed TOTP code refused", zap.Int("target_user_id", id))
+			return nil, ErrInvalidTwoFactorCode
+		}
+		return user, nil
+	}
+
+	err = s.inTxWithRetry(func(txService *UserService) error {
+		consumed, err := txService.store.RecoveryCodes().Consume(id, hashRecoveryCode(code))
+		if err != nil {
+			return fmt.Errorf("failed to check recovery code: %w", err)
+		}
+		if !consumed {
+			return ErrInvalidTwoFactorCode
+		}
+		return txService.audit.Record(models.AuditActionRecoveryCodeUsed, &user.ID, txService.actor(user.ID), nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Recovery code used", zap.Int("target_user_id", id))
+	return user, nil
+}
+
+// totpPeriod is the length of a TOTP time step, in seconds
+const totpPeriod = 30
+
+// validateTOTP checks code against the user's TOTP secret, allowing the
+// configured clock skew, and returns the time step it matched
+func (s *UserService) validateTOTP(user *models.User, code string) (int64, bool, error) {
+	secret, err := s.twoFactor.Cipher.Decrypt(*user.TOTPSecret)
+	if err != nil {
+		s.logger.Error("Failed to decrypt TOTP secret", zap.Error(err), zap.Int("target_user_id", user.ID))
+		return 0, false, err
+	}
+
+	code = strings.TrimSpace(code)
+	now := time.Now().Unix() / totpPeriod
+	skew := int64(s.twoFactor.Skew)
+	for step := now - skew; step <= now+skew; step++ {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			// A secret that isn't valid base32 matches no code
+			return 0, false, nil
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			return step, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// newRecoveryCodes generates n random recovery codes, formatted as two
+// groups of five characters, along with their hashes
+func newRecoveryCodes(n int) (codes, hashes []string, err error) {
+	for i := 0; i < n; i++ {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		encoded := recoveryCodeEncoding.EncodeToString(raw)[:10]
+		code := encoded[:5] + "-" + encoded[5:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case, spaces and dashes
+// as typed by the user. Codes carry 50 random bits, so a fast hash suffices
+// and lets the code be looked up by its hash.
+func hashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// SecretCipher encrypts short secrets with AES-256-GCM
+type SecretCipher struct {
+	aead cipher.AEAD
+}
+
+// NewSecretCipher creates a cipher whose key is derived from passphrase
+func NewSecretCipher(passphrase string) (*SecretCipher, error) {
+	if passphrase == "" {
+		return nil, errors.New("encryption key is empty")
+	}
+
+	key := sha256.Sum256([]byte(passphrase))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretCipher{aead: aead}, nil
+}
+
+// Encrypt encrypts plaintext with a random nonce, returning it base64-encoded
+func (c *SecretCipher) Encrypt(plaintext string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value produced by Encrypt
+func (c *SecretCipher) Decrypt(ciphertext string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret: %w", err)
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return "", errors.New("failed to decrypt secret: too short")
+	}
+
+	nonce, sealed := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTwoFactorChallengeUsed is returned when verifying a code with a
+// challenge that already completed a login, is being verified concurrently
+// or failed too many times
+var ErrTwoFactorChallengeUsed = errors.New("two-factor challenge already used")
+
+const (
+	defaultChallengeTTL    = 5 * time.Minute
+	defaultMaxAttempts     = 5
+	defaultMaxFailures     = 10
+	defaultFailureWindow   = 15 * time.Minute
+	twoFactorSweepInterval = time.Minute
+)
+
+// challengeState is what is known of a challenge tried at least once
+type challengeState struct {
+	failures int
+	// busy is set while a code is checked with the challenge, and stays set
+	// once one was accepted
+	busy    bool
+	expires time.Time
+}
+
+// twoFactorAttempts tracks the codes tried with each challenge and the
+// wrong codes of each user, in memory. A challenge completes a single login
+// and is refused after maxAttempts wrong codes, and a user's codes are
+// refused after maxFailures wrong ones within window, whatever the
+// challenge.
+type twoFactorAttempts struct {
+	challengeTTL time.Duration
+	maxAttempts  int
+	maxFailures  int
+	window       time.Duration
+
+	mu         sync.Mutex
+	challenges map[string]*challengeState
+	// failures holds the times of each user's wrong codes within the window
+	failures  map[int][]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// newTwoFactorAttempts creates a tracker for opts, filling in defaults
+func newTwoFactorAttempts(opts TwoFactorOptions) *twoFactorAttempts {
+	a := &twoFactorAttempts{
+		challengeTTL: opts.ChallengeTTL,
+		maxAttempts:  opts.MaxAttempts,
+		maxFailures:  opts.MaxFailures,
+		window:       opts.FailureWindow,
+		challenges:   make(map[string]*challengeState),
+		failures:     make(map[int][]time.Time),
+		now:          time.Now,
+	}
+	if a.challengeTTL <= 0 {
+		a.challengeTTL = defaultChallengeTTL
+	}
+	if a.maxAttempts <= 0 {
+		a.maxAttempts = defaultMaxAttempts
+	}
+	if a.maxFailures <= 0 {
+		a.maxFailures = defaultMaxFailures
+	}
+	if a.window <= 0 {
+		a.window = defaultFailureWindow
+	}
+	return a
+}
+
+// begin claims challengeID for checking a code of userID, returning
+// ErrTwoFactorChallengeUsed if the challenge can't be used and a
+// *LoginThrottledError if the user entered too many wrong codes. A claimed
+// challenge must be released with fail, abort or succeed.
+func (a *twoFactorAttempts) begin(challengeID string, userID int) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	a.sweep(now)
+
+	if failures := a.recentFailures(userID, now); len(failures) >= a.maxFailures {
+		return &LoginThrottledError{RetryAfter: failures[0].Add(a.window).Sub(now)}
+	}
+
+	challenge, ok := a.challenges[challengeID]
+	if !ok {
+		// The token was issued before now, so it expires before the entry
+		// and an expired challenge is never seen as new
+		challenge = &challengeState{expires: now.Add(a.challengeTTL)}
+		a.challenges[challengeID] = challenge
+	}
+	if challenge.busy || challenge.failures >= a.maxAttempts {
+		return ErrTwoFactorChallengeUsed
+	}
+	challenge.busy = true
+	return nil
+}
+
+// fail releases a challenge after a wrong code, counting it against the
+// challenge and the user
+func (a *twoFactorAttempts) fail(challengeID string, userID int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	if challenge, ok := a.challenges[challengeID]; ok {
+		challenge.busy = false
+		challenge.failures++
+	}
+	a.failures[userID] = append(a.recentFailures(userID, now), now)
+}
+
+// abort releases a challenge whose code could not be checked, such as on a
+// database error, without counting an attempt
+func (a *twoFactorAttempts) abort(challengeID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if challenge, ok := a.challenges[challengeID]; ok {
+		challenge.busy = false
+	}
+}
+
+// succeed leaves a challenge claimed for good after an accepted code, and
+// forgets the user's wrong codes
+func (a *twoFactorAttempts) succeed(userID int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.failures, userID)
+}
+
+// recentFailures returns the user's wrong codes within the window, oldest
+// first. The caller must hold mu.
+func (a *twoFactorAttempts) recentFailures(userID int, now time.Time) []time.Time {
+	failures := a.failures[userID]
+	for len(failures) > 0 && now.Sub(failures[0]) >= a.window {
+		failures = failures[1:]
+	}
+	if len(failures) == 0 {
+		delete(a.failures, userID)
+		return nil
+	}
+	a.failures[userID] = failures
+	return failures
+}
+
+// sweep drops expired challenges and users without recent failures, at
+// most once per sweep interval. The caller must hold mu.
+func (a *twoFactorAttempts) sweep(now time.Time) {
+	if now.Sub(a.lastSweep) < twoFactorSweepInterval {
+		return
+	}
+	a.lastSweep = now
+
+	for id, challenge := range a.challenges {
+		if !now.Before(challenge.expires) {
+			delete(a.challenges, id)
+		}
+	}
+	for userID := range a.failures {
+		a.recentFailures(userID, now)
+	}
+}
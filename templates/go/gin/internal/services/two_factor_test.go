@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func setupTwoFactorService(t *testing.T) (*UserService, *repository.MemoryStore, *models.User) {
+	service, store, users := newMemoryUserService(t, "testuser")
+
+	secretCipher, err := NewSecretCipher("test-encryption-key")
+	require.NoError(t, err)
+	service.SetTwoFactor(TwoFactorOptions{Issuer: "gin-service", Cipher: secretCipher, Skew: 1})
+	return service, store, users[0]
+}
+
+// enableTwoFactor sets up and enables 2FA, returning the secret and
+// recovery codes
+func enableTwoFactor(t *testing.T, service *UserService, id int) (string, []string) {
+	setup, err := service.SetupTwoFactor(id)
+	require.NoError(t, err)
+
+	code, err := totp.GenerateCode(setup.Secret, time.Now())
+	require.NoError(t, err)
+	_, recoveryCodes, err := service.EnableTwoFactor(id, code)
+	require.NoError(t, err)
+	return setup.Secret, recoveryCodes
+}
+
+func TestUserService_SetupTwoFactor(t *testing.T) {
+	service, _, user := setupTwoFactorService(t)
+
+	setup, err := service.SetupTwoFactor(user.ID)
+
+	require.NoError(t, err)
+	assert.NotEmpty(t, setup.Secret)
+	assert.Contains(t, setup.OTPAuthURL, "otpauth://totp/gin-service:testuser")
+	assert.Contains(t, setup.OTPAuthURL, "secret="+setup.Secret)
+	assert.Equal(t, "\x89PNG", string(setup.QRCode[:4]))
+
+	// The secret is stored encrypted, and not yet required at login
+	stored, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.TOTPSecret)
+	assert.NotContains(t, *stored.TOTPSecret, setup.Secret)
+	assert.False(t, stored.TOTPEnabled)
+}
+
+func TestUserService_EnableTwoFactor(t *testing.T) {
+	service, store, user := setupTwoFactorService(t)
+
+	setup, err := service.SetupTwoFactor(user.ID)
+	require.NoError(t, err)
+
+	code, err := totp.GenerateCode(setup.Secret, time.Now())
+	require.NoError(t, err)
+	enabled, recoveryCodes, err := service.EnableTwoFactor(user.ID, code)
+
+	require.NoError(t, err)
+	assert.True(t, enabled.TOTPEnabled)
+	assert.Len(t, recoveryCodes, recoveryCodeCount)
+	assert.Regexp(t, `^[a-z2-9]{5}-[a-z2-9]{5}$`, recoveryCodes[0])
+
+	assert.Equal(t, models.AuditActionTwoFactorEnabled, lastAuditEntry(t, store).Action)
+
+	// Setting up again would silently replace the secret in use
+	_, err = service.SetupTwoFactor(user.ID)
+	assert.ErrorIs(t, err, ErrTwoFactorAlreadyEnabled)
+}
+
+func TestUserService_EnableTwoFactor_Rejects(t *testing.T) {
+	service, _, user := setupTwoFactorService(t)
+
+	_, _, err := service.EnableTwoFactor(user.ID, "123456")
+	assert.ErrorIs(t, err, ErrTwoFactorNotSetUp)
+
+	_, err = service.SetupTwoFactor(user.ID)
+	require.NoError(t, err)
+
+	_, _, err = service.EnableTwoFactor(user.ID, "000000")
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	stored, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.False(t, stored.TOTPEnabled)
+
+	_, err = service.SetupTwoFactor(999)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	_, _, err = service.EnableTwoFactor(999, "123456")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestUserService_VerifyTwoFactor_ClockSkew(t *testing.T) {
+	service, _, user := setupTwoFactorService(t)
+	secret, _ := enableTwoFactor(t, service, user.ID)
+
+	// A device one step behind or ahead is tolerated
+	for _, offset := range []time.Duration{-30 * time.Second, 0, 30 * time.Second} {
+		code, err := totp.GenerateCode(secret, time.Now().Add(offset))
+		require.NoError(t, err)
+
+		_, err = service.VerifyTwoFactor(user.ID, uuid.NewString(), code)
+		assert.NoError(t, err, offset)
+	}
+
+	// Codes further off have expired or are not valid yet
+	for _, offset := range []time.Duration{-90 * time.Second, 90 * time.Second} {
+		code, err := totp.GenerateCode(secret, time.Now().Add(offset))
+		require.NoError(t, err)
+
+		_, err = service.VerifyTwoFactor(user.ID, uuid.NewString(), code)
+		assert.ErrorIs(t, err, ErrInvalidTwoFactorCode, offset)
+	}
+}
+
+func TestUserService_VerifyTwoFactor_RecoveryCodeWorksOnce(t *testing.T) {
+	service, store, user := setupTwoFactorService(t)
+	_, recoveryCodes := enableTwoFactor(t, service, user.ID)
+
+	// Codes are accepted regardless of case and dashes
+	verified, err := service.VerifyTwoFactor(user.ID, uuid.NewString(), "  "+strings.ToUpper(strings.ReplaceAll(recoveryCodes[0], "-", ""))+" ")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, verified.ID)
+
+	assert.Equal(t, models.AuditActionRecoveryCodeUsed, lastAuditEntry(t, store).Action)
+
+	_, err = service.VerifyTwoFactor(user.ID, uuid.NewString(), recoveryCodes[0])
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	// The other codes still work
+	_, err = service.VerifyTwoFactor(user.ID, uuid.NewString(), recoveryCodes[1])
+	assert.NoError(t, err)
+}
+
+func TestUserService_VerifyTwoFactor_TOTPCodeWorksOnce(t *testing.T) {
+	service, _, user := setupTwoFactorService(t)
+	secret, _ := enableTwoFactor(t, service, user.ID)
+
+	code, err := totp.GenerateCode(secret, time.Now())
+	require.NoError(t, err)
+	_, err = service.VerifyTwoFactor(user.ID, uuid.NewString(), code)
+	require.NoError(t, err)
+
+	_, err = service.VerifyTwoFactor(user.ID, uuid.NewString(), code)
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	// Nor is an earlier code still within the skew accepted
+	code, err = totp.GenerateCode(secret, time.Now().Add(-30*time.Second))
+	require.NoError(t, err)
+	_, err = service.VerifyTwoFactor(user.ID, uuid.NewString(), code)
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+}
+
+func TestUserService_VerifyTwoFactor_ChallengeWorksOnce(t *testing.T) {
+	service, _, user := setupTwoFactorService(t)
+	_, recoveryCodes := enableTwoFactor(t, service, user.ID)
+	challengeID := uuid.NewString()
+
+	_, err := service.VerifyTwoFactor(user.ID, challengeID, recoveryCodes[0])
+	require.NoError(t, err)
+
+	_, err = service.VerifyTwoFactor(user.ID, challengeID, recoveryCodes[1])
+	assert.ErrorIs(t, err, ErrTwoFactorChallengeUsed)
+}
+
+func TestUserService_VerifyTwoFactor_DeletionScheduled(t *testing.T) {
+	service, _, user := setupTwoFactorService(t)
+	_, recoveryCodes := enableTwoFactor(t, service, user.ID)
+	_, err := service.ScheduleDeletion(user.ID)
+	require.NoError(t, err)
+
+	// The second factor is checked before the login is refused for a
+	// cancel token
+	_, err = service.VerifyTwoFactor(user.ID, uuid.NewString(), "wrong-code")
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	verified, err := service.VerifyTwoFactor(user.ID, uuid.NewString(), recoveryCodes[0])
+	assert.Nil(t, verified)
+	var pending *DeletionPendingError
+	require.ErrorAs(t, err, &pending)
+	assert.Equal(t, user.ID, pending.User.ID)
+}
+
+func TestUserService_VerifyTwoFactor_LimitsWrongCodes(t *testing.T) {
+	service, _, user := setupTwoFactorService(t)
+	_, recoveryCodes := enableTwoFactor(t, service, user.ID)
+	service.SetTwoFactor(TwoFactorOptions{Cipher: service.twoFactor.Cipher, MaxAttempts: 3, MaxFailures: 4, FailureWindow: time.Minute})
+	now := time.Now()
+	service.twoFactorAttempts.now = func() time.Time { return now }
+
+	// A challenge is refused after three wrong codes, even with a right one
+	first := uuid.NewString()
+	for i := 0; i < 3; i++ {
+		_, err := service.VerifyTwoFactor(user.ID, first, "000000")
+		assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+	}
+	_, err := service.VerifyTwoFactor(user.ID, first, recoveryCodes[0])
+	assert.ErrorIs(t, err, ErrTwoFactorChallengeUsed)
+
+	// The user is refused after four, whatever the challenge
+	_, err = service.VerifyTwoFactor(user.ID, uuid.NewString(), "000000")
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	_, err = service.VerifyTwoFactor(user.ID, uuid.NewString(), recoveryCodes[0])
+	var throttled *LoginThrottledError
+	require.ErrorAs(t, err, &throttled)
+	assert.Equal(t, time.Minute, throttled.RetryAfter)
+
+	// Until the wrong codes are out of the window
+	now = now.Add(time.Minute)
+	_, err = service.VerifyTwoFactor(user.ID, uuid.NewString(), recoveryCodes[0])
+	assert.NoError(t, err)
+}
+
+func TestUserService_VerifyTwoFactor_Throttled(t *testing.T) {
+	service, _, user := setupTwoFactorService(t)
+	_, recoveryCodes := enableTwoFactor(t, service, user.ID)
+	service.SetLoginThrottle(&countingThrottle{limit: 2, attempts: map[string]int{}})
+	fromIP := service.WithContext(ContextWithClientIP(context.Background(), "10.0.0.1"))
+
+	for i := 0; i < 2; i++ {
+		_, err := fromIP.VerifyTwoFactor(user.ID, uuid.NewString(), "000000")
+		assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+	}
+
+	_, err := fromIP.VerifyTwoFactor(user.ID, uuid.NewString(), recoveryCodes[0])
+	var throttled *LoginThrottledError
+	assert.ErrorAs(t, err, &throttled)
+}
+
+func TestUserService_VerifyTwoFactor_NotEnabled(t *testing.T) {
+	service, _, user := setupTwoFactorService(t)
+
+	_, err := service.VerifyTwoFactor(user.ID, uuid.NewString(), "123456")
+
+	assert.ErrorIs(t, err, ErrTwoFactorNotEnabled)
+}
+
+func TestUserService_VerifyTwoFactor_InactiveUser(t *testing.T) {
+	service, _, user := setupTwoFactorService(t)
+	_, recoveryCodes := enableTwoFactor(t, service, user.ID)
+	_, err := service.SetStatus(user.ID, models.StatusInactive, 99)
+	require.NoError(t, err)
+
+	_, err = service.VerifyTwoFactor(user.ID, uuid.NewString(), recoveryCodes[0])
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestUserService_TwoFactorDisabledWithoutCipher(t *testing.T) {
+	service := NewUserService(repository.NewMemoryStore(), zap.NewNop())
+
+	_, err := service.SetupTwoFactor(1)
+
+	assert.ErrorIs(t, err, ErrTwoFactorDisabled)
+}
+
+func TestSecretCipher(t *testing.T) {
+	secretCipher, err := NewSecretCipher("key")
+	require.NoError(t, err)
+
+	encrypted, err := secretCipher.Encrypt("JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	again, err := secretCipher.Encrypt("JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	assert.NotEqual(t, encrypted, again, "nonces are random")
+
+	decrypted, err := secretCipher.Decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", decrypted)
+
+	otherCipher, err := NewSecretCipher("other key")
+	require.NoError(t, err)
+	_, err = otherCipher.Decrypt(encrypted)
+	assert.Error(t, err)
+
+	_, err = NewSecretCipher("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,410 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"gin-service/internal/cache"
+	"gin-service/internal/database"
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheLookups counts the lookups in the user cache by cache, user or
+// user_list, and result, hit, miss or error. The hit ratio is the rate of
+// hits over the rate of all lookups.
+var CacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gin_service",
+	Name:      "cache_lookups_total",
+	Help:      "Lookups in the user cache, by cache and result.",
+}, []string{"cache", "result"})
+
+// Names of the caches in CacheLookups
+const (
+	userCacheName     = "user"
+	userListCacheName = "user_list"
+)
+
+// UserChangesChannel is the Postgres channel notified of every write to a
+// user, with the user's ID, for HandleUserChange
+const UserChangesChannel = "user_changes"
+
+// userListVersionKey holds the version of the cached user lists, which is
+// part of their keys. Bumping it invalidates every cached list at once.
+const userListVersionKey = "user_list:version"
+
+// UserCacheOptions configures what the user cache keeps and for how long
+type UserCacheOptions struct {
+	// TTL is how long users looked up by ID are cached
+	TTL time.Duration
+	// ListTTL is how long pages of user lists are cached; 0 disables
+	// caching lists
+	ListTTL time.Duration
+}
+
+// UserCache caches users by ID for the user service, sparing the database
+// repeated lookups of the same user, and optionally pages of user lists.
+// Concurrent misses on the same user or page share one database query, so
+// an expiry doesn't stampede the database. Cache failures are logged and
+// otherwise ignored, so users are read from the database while the cache is
+// down.
+type UserCache struct {
+	cache  cache.Cache
+	opts   UserCacheOptions
+	logger *zap.Logger
+	// flights coalesces the database reads of concurrent misses, by cache
+	// key
+	flights singleflight.Group
+}
+
+// NewUserCache creates a user cache keeping users in c
+func NewUserCache(c cache.Cache, opts UserCacheOptions, logger *zap.Logger) *UserCache {
+	return &UserCache{
+		cache:  c,
+		opts:   opts,
+		logger: logger.With(zap.String("component", "user_cache")),
+	}
+}
+
+// userVersionKey holds the version of a cached user, which is part of the
+// key it is cached under. Bumping it invalidates the user.
+func userVersionKey(id int) string {
+	return "user:" + strconv.Itoa(id) + ":version"
+}
+
+// version returns the version held under key, or an error when the cache
+// fails. A missing version is 0.
+func (c *UserCache) version(key string) (string, error) {
+	version, err := c.cache.Get(context.Background(), key)
+	if errors.Is(err, cache.ErrMiss) {
+		return "0", nil
+	}
+	return string(version), err
+}
+
+// userKey returns the key the user is cached under, or false when the cache
+// fails. The key is made of the user's current version, so a user read
+// before a write is cached under a key no longer read after it.
+func (c *UserCache) userKey(id int) (string, bool) {
+	version, err := c.version(userVersionKey(id))
+	if err != nil {
+		CacheLookups.WithLabelValues(userCacheName, "error").Inc()
+		c.logger.Warn("Failed to read user version", zap.Error(err), zap.Int("target_user_id", id))
+		return "", false
+	}
+	return "user:" + strconv.Itoa(id) + ":" + version, true
+}
+
+// lookup returns the value cached under key, counting the lookup in
+// CacheLookups. It reports false when the value isn't cached or the cache
+// fails.
+func (c *UserCache) lookup(name, key string) ([]byte, bool) {
+	data, err := c.cache.Get(context.Background(), key)
+	switch {
+	case err == nil:
+		CacheLookups.WithLabelValues(name, "hit").Inc()
+		return data, true
+	case errors.Is(err, cache.ErrMiss):
+		CacheLookups.WithLabelValues(name, "miss").Inc()
+	default:
+		CacheLookups.WithLabelValues(name, "error").Inc()
+		c.logger.Warn("Failed to read cache", zap.Error(err), zap.String("cache", name))
+	}
+	return nil, false
+}
+
+// get returns the user cached under key, or nil when it isn't cached or the
+// cache fails
+func (c *UserCache) get(key string, id int) *models.User {
+	data, ok := c.lookup(userCacheName, key)
+	if !ok {
+		return nil
+	}
+
+	// Users are gob-encoded rather than JSON-encoded, which would drop the
+	// fields hidden from API responses, such as the password hash
+	var user models.User
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&user); err != nil {
+		c.logger.Warn("Failed to decode cached user", zap.Error(err), zap.Int("target_user_id", id))
+		return nil
+	}
+	return &user
+}
+
+// set caches the user under key
+func (c *UserCache) set(key string, user *models.User) {
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(user); err != nil {
+		c.logger.Warn("Failed to encode user for caching", zap.Error(err), zap.Int("target_user_id", user.ID))
+		return
+	}
+	if err := c.cache.Set(context.Background(), key, data.Bytes(), c.opts.TTL); err != nil {
+		c.logger.Warn("Failed to cache user", zap.Error(err), zap.Int("target_user_id", user.ID))
+	}
+}
+
+// cachedUserList is a page of a user list as cached
+type cachedUserList struct {
+	Users []*models.User
+	Total int
+}
+
+// listKey returns the key the page of the list is cached under, or false
+// when the cache fails. The key is made of the current list version and a
+// hash of the filter and page.
+func (c *UserCache) listKey(filter *models.UserFilter, pagination *database.Paginate) (string, bool) {
+	version, err := c.version(userListVersionKey)
+	if err != nil {
+		CacheLookups.WithLabelValues(userListCacheName, "error").Inc()
+		c.logger.Warn("Failed to read user list version", zap.Error(err))
+		return "", false
+	}
+
+	// Conditions are left out of the filter's JSON
+	var conditions []models.Condition
+	if filter != nil {
+		conditions = filter.Conditions
+	}
+	query, err := json.Marshal(struct {
+		Filter     *models.UserFilter
+		Conditions []models.Condition
+		Page       int
+		Limit      int
+	}{filter, conditions, pagination.Page, pagination.Limit})
+	if err != nil {
+		c.logger.Warn("Failed to hash user list query", zap.Error(err))
+		return "", false
+	}
+	hash := sha256.Sum256(query)
+	return "user_list:" + version + ":" + hex.EncodeToString(hash[:]), true
+}
+
+// getList returns the cached page of the list, or false when it isn't
+// cached or the cache fails
+func (c *UserCache) getList(key string) (*cachedUserList, bool) {
+	data, ok := c.lookup(userListCacheName, key)
+	if !ok {
+		return nil, false
+	}
+	var list cachedUserList
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&list); err != nil {
+		c.logger.Warn("Failed to decode cached user list", zap.Error(err))
+		return nil, false
+	}
+	// Gob doesn't tell empty lists from nil ones
+	if list.Users == nil {
+		list.Users = []*models.User{}
+	}
+	return &list, true
+}
+
+// setList caches the page of the list
+func (c *UserCache) setList(key string, list *cachedUserList) {
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(list); err != nil {
+		c.logger.Warn("Failed to encode user list for caching", zap.Error(err))
+		return
+	}
+	if err := c.cache.Set(context.Background(), key, data.Bytes(), c.opts.ListTTL); err != nil {
+		c.logger.Warn("Failed to cache user list", zap.Error(err))
+	}
+}
+
+// invalidate drops the cached users, and every cached list since they may
+// appear in any. A failure leaves them cached until their TTL runs out, so
+// it is logged as an error.
+func (c *UserCache) invalidate(ids ...int) {
+	if c == nil || len(ids) == 0 {
+		return
+	}
+	// Users and lists cached under the previous versions are no longer
+	// read, and expire on their own
+	for _, id := range ids {
+		if _, err := c.cache.Incr(context.Background(), userVersionKey(id)); err != nil {
+			c.logger.Error("Failed to invalidate cached user", zap.Error(err), zap.Int("target_user_id", id))
+		}
+	}
+	if _, err := c.cache.Incr(context.Background(), userListVersionKey); err != nil {
+		c.logger.Error("Failed to invalidate cached user lists", zap.Error(err))
+	}
+}
+
+// HandleUserChange drops a user from the cache on a notification on
+// UserChangesChannel, with the user's ID as payload. Instances keeping the
+// cache in memory thus see the writes of other instances.
+func (c *UserCache) HandleUserChange(payload string) {
+	id, err := strconv.Atoi(payload)
+	if err != nil {
+		c.logger.Warn("Ignoring invalid user change notification", zap.String("payload", payload))
+		return
+	}
+	c.invalidate(id)
+}
+
+// users returns repo looking users up in the cache, or repo itself when
+// the cache is nil. Within a transaction, written collects the users to
+// invalidate once it commits.
+func (c *UserCache) users(repo repository.UserRepository, written *[]int) repository.UserRepository {
+	if c == nil {
+		return repo
+	}
+	return &cachedUserRepository{UserRepository: repo, cache: c, written: written}
+}
+
+// cachedUserRepository is a UserRepository looking users up by ID in a
+// UserCache before the database, and invalidating the users it writes
+type cachedUserRepository struct {
+	repository.UserRepository
+	cache *UserCache
+	// written collects the users written within a transaction, to
+	// invalidate once it commits; nil outside transactions
+	written *[]int
+}
+
+// FindByID returns the cached user, reading and caching it on a miss.
+// Concurrent misses share the first one's read, and its error, such as its
+// request being cancelled. A user read while a write commits is cached
+// under the version the write then bumps, so it is never served stale
+// beyond that. Within a transaction the cache is bypassed: the transaction
+// may see its own uncommitted writes, which must not be cached.
+func (r *cachedUserRepository) FindByID(id int) (*models.User, error) {
+	if r.written != nil {
+		return r.UserRepository.FindByID(id)
+	}
+	key, ok := r.cache.userKey(id)
+	if !ok {
+		return r.UserRepository.FindByID(id)
+	}
+	if user := r.cache.get(key, id); user != nil {
+		return user, nil
+	}
+
+	// The key holds the user's version, so misses after a write don't join
+	// reads in flight since before it
+	shared, err, _ := r.cache.flights.Do(key, func() (interface{}, error) {
+		user, err := r.UserRepository.FindByID(id)
+		if err != nil || user == nil {
+			return user, err
+		}
+		r.cache.set(key, user)
+		return user, nil
+	})
+	user, _ := shared.(*models.User)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	// Each caller gets a copy of its own to modify
+	copied := *user
+	return &copied, nil
+}
+
+// List returns the cached page of the list, reading and caching it on a
+// miss. A page read while a write commits is cached under the list version
+// the write then bumps, so it is never served stale beyond that.
+func (r *cachedUserRepository) List(filter *models.UserFilter, pagination *database.Paginate) ([]*models.User, error) {
+	if r.written != nil || r.cache.opts.ListTTL <= 0 {
+		return r.UserRepository.List(filter, pagination)
+	}
+
+	// Pages are keyed by their effective size
+	pagination.CalculateOffset()
+	key, ok := r.cache.listKey(filter, pagination)
+	if !ok {
+		return r.UserRepository.List(filter, pagination)
+	}
+	if list, ok := r.cache.getList(key); ok {
+		pagination.SetTotal(list.Total)
+		return list.Users, nil
+	}
+
+	// The key holds the list version, so misses after a write don't join
+	// reads in flight since before it
+	shared, err, _ := r.cache.flights.Do(key, func() (interface{}, error) {
+		page := *pagination
+		users, err := r.UserRepository.List(filter, &page)
+		if err != nil {
+			return nil, err
+		}
+		list := &cachedUserList{Users: users, Total: page.Total}
+		r.cache.setList(key, list)
+		return list, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := shared.(*cachedUserList)
+	pagination.SetTotal(list.Total)
+	users := make([]*models.User, len(list.Users))
+	for i, user := range list.Users {
+		copied := *user
+		users[i] = &copied
+	}
+	return users, nil
+}
+
+// Create inserts the user and invalidates the cached lists
+func (r *cachedUserRepository) Create(user *models.User) error {
+	if err := r.UserRepository.Create(user); err != nil {
+		return err
+	}
+	r.invalidate(user.ID)
+	return nil
+}
+
+// Update saves the user and invalidates it
+func (r *cachedUserRepository) Update(user *models.User) error {
+	defer r.invalidate(user.ID)
+	return r.UserRepository.Update(user)
+}
+
+// Delete deletes the user and invalidates it
+func (r *cachedUserRepository) Delete(id int) error {
+	defer r.invalidate(id)
+	return r.UserRepository.Delete(id)
+}
+
+// SetAdmin changes the user's admin role and invalidates it
+func (r *cachedUserRepository) SetAdmin(id int, isAdmin bool, at time.Time) error {
+	defer r.invalidate(id)
+	return r.UserRepository.SetAdmin(id, isAdmin, at)
+}
+
+// UpdateLastLogin records the user's login and invalidates it
+func (r *cachedUserRepository) UpdateLastLogin(id int, at time.Time) error {
+	defer r.invalidate(id)
+	return r.UserRepository.UpdateLastLogin(id, at)
+}
+
+// UpdatePasswordHash replaces the user's password hash and invalidates it
+func (r *cachedUserRepository) UpdatePasswordHash(id int, hash string) error {
+	defer r.invalidate(id)
+	return r.UserRepository.UpdatePasswordHash(id, hash)
+}
+
+// UseTOTPStep records the user's TOTP step and invalidates it
+func (r *cachedUserRepository) UseTOTPStep(id int, step int64) (bool, error) {
+	defer r.invalidate(id)
+	return r.UserRepository.UseTOTPStep(id, step)
+}
+
+// invalidate drops the user from the cache, at once or, within a
+// transaction, once it commits. Users are dropped even when the write
+// fails, which costs at most a cache miss.
+func (r *cachedUserRepository) invalidate(id int) {
+	if r.written != nil {
+		*r.written = append(*r.written, id)
+		return
+	}
+	r.cache.invalidate(id)
+}
@@ -0,0 +1,399 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gin-service/internal/cache"
+	"gin-service/internal/database"
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// setupCachedUserService returns a service caching users in c, and a user it
+// created
+func setupCachedUserService(t *testing.T, c cache.Cache) (*UserService, *repository.MemoryStore, *models.User) {
+	store := repository.NewMemoryStore()
+	service := NewUserService(store, zap.NewNop())
+	service.SetCache(NewUserCache(c, UserCacheOptions{TTL: time.Minute, ListTTL: time.Minute}, zap.NewNop()))
+
+	user, err := service.Create(&models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	require.NoError(t, err)
+	return service, store, user
+}
+
+func TestUserCache_GetByIDReadsThroughCache(t *testing.T) {
+	service, store, user := setupCachedUserService(t, cache.NewMemoryCache())
+
+	cached, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", cached.Username)
+
+	// A write behind the service's back goes unnoticed, showing the second
+	// lookup is served from the cache
+	renamed := *cached
+	renamed.Username = "renamed"
+	require.NoError(t, store.Users().Update(&renamed))
+
+	cached, err = service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", cached.Username)
+	assert.NotEmpty(t, cached.Password, "fields hidden from responses are cached too")
+	assert.NoError(t, cached.CheckPassword("password123"))
+
+	missing, err := service.GetByID(99)
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestUserCache_WritesInvalidate(t *testing.T) {
+	service, _, user := setupCachedUserService(t, cache.NewMemoryCache())
+	_, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+
+	username := "renamed"
+	_, err = service.Update(user.ID, &models.UpdateUserRequest{Username: &username})
+	require.NoError(t, err)
+	cached, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", cached.Username)
+
+	// Logging in records the login
+	assert.Nil(t, cached.LastLogin)
+	_, err = service.Authenticate("renamed", models.IdentifierUsername, "password123")
+	require.NoError(t, err)
+	cached, err = service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, cached.LastLogin)
+
+	// Writes within a transaction are invalidated once it commits
+	require.NoError(t, service.Anonymize(user.ID, user.ID))
+	cached, err = service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusInactive, cached.Status)
+
+	require.NoError(t, service.Delete(user.ID))
+	cached, err = service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Nil(t, cached)
+}
+
+func TestUserCache_SharedBetweenServices(t *testing.T) {
+	c := cache.NewMemoryCache()
+	service, store, user := setupCachedUserService(t, c)
+	_, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+
+	// Another service on the same cache, like the deletion reaper
+	other := NewUserService(store, zap.NewNop())
+	other.SetCache(NewUserCache(c, UserCacheOptions{TTL: time.Minute, ListTTL: time.Minute}, zap.NewNop()))
+	require.NoError(t, other.Delete(user.ID))
+
+	cached, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Nil(t, cached)
+}
+
+func TestUserCache_HandleUserChange(t *testing.T) {
+	c := cache.NewMemoryCache()
+	service, store, user := setupCachedUserService(t, c)
+	_, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+
+	// Another instance renames the user; notifications of other users, or
+	// invalid ones, leave it cached
+	renamed := *user
+	renamed.Username = "renamed"
+	require.NoError(t, store.Users().Update(&renamed))
+	service.cache.HandleUserChange("42")
+	service.cache.HandleUserChange("not an id")
+	cached, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", cached.Username)
+
+	// The notification of the user drops it
+	service.cache.HandleUserChange(strconv.Itoa(user.ID))
+	cached, err = service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", cached.Username)
+}
+
+// downCache is a Cache whose every operation fails, like Redis while it is
+// unreachable
+type downCache struct{}
+
+func (downCache) Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (downCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return errors.New("connection refused")
+}
+
+func (downCache) Delete(ctx context.Context, keys ...string) error {
+	return errors.New("connection refused")
+}
+
+func (downCache) Incr(ctx context.Context, key string) (int64, error) {
+	return 0, errors.New("connection refused")
+}
+
+func TestUserCache_FallsBackToDatabase(t *testing.T) {
+	service, _, user := setupCachedUserService(t, downCache{})
+
+	found, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", found.Username)
+
+	username := "renamed"
+	_, err = service.Update(user.ID, &models.UpdateUserRequest{Username: &username})
+	require.NoError(t, err)
+	found, err = service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", found.Username)
+
+	users, err := service.List(&models.UserFilter{}, &database.Paginate{Page: 1, Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, users, 1)
+}
+
+func TestUserCache_ListReadsThroughCache(t *testing.T) {
+	service, store, _ := setupCachedUserService(t, cache.NewMemoryCache())
+	hits := testutil.ToFloat64(CacheLookups.WithLabelValues(userListCacheName, "hit"))
+	misses := testutil.ToFloat64(CacheLookups.WithLabelValues(userListCacheName, "miss"))
+
+	list := func(filter *models.UserFilter) ([]*models.User, *database.Paginate) {
+		pagination := &database.Paginate{Page: 1, Limit: 10}
+		users, err := service.List(filter, pagination)
+		require.NoError(t, err)
+		return users, pagination
+	}
+	users, pagination := list(&models.UserFilter{})
+	require.Len(t, users, 1)
+	assert.Equal(t, 1, pagination.Total)
+
+	// A user inserted behind the service's back goes unnoticed, showing the
+	// second lookup is served from the cache, total included
+	require.NoError(t, store.Users().Create(&models.User{Username: "hidden", Email: "hidden@example.com", Status: models.StatusActive}))
+	users, pagination = list(&models.UserFilter{})
+	assert.Len(t, users, 1)
+	assert.Equal(t, 1, pagination.Total)
+	assert.Equal(t, hits+1, testutil.ToFloat64(CacheLookups.WithLabelValues(userListCacheName, "hit")))
+	assert.Equal(t, misses+1, testutil.ToFloat64(CacheLookups.WithLabelValues(userListCacheName, "miss")))
+
+	// Other filters and pages are cached apart
+	username := "hidden"
+	users, _ = list(&models.UserFilter{Username: &username})
+	assert.Len(t, users, 1)
+	users, _ = list(&models.UserFilter{Conditions: []models.Condition{{Field: "username", Operator: models.OpEq, Value: "hidden"}}})
+	assert.Len(t, users, 1)
+
+	// Creating a user drops every cached page
+	_, err := service.Create(&models.CreateUserRequest{Username: "created", Email: "created@example.com", Password: "password123"})
+	require.NoError(t, err)
+	users, pagination = list(&models.UserFilter{})
+	assert.Len(t, users, 3)
+	assert.Equal(t, 3, pagination.Total)
+}
+
+func TestUserCache_ListSeesConcurrentWrites(t *testing.T) {
+	service, _, _ := setupCachedUserService(t, cache.NewMemoryCache())
+
+	// Lists read while users are created may be stale, but not once the
+	// creations return
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		i := i
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := service.Create(&models.CreateUserRequest{
+				Username: fmt.Sprintf("user%d", i),
+				Email:    fmt.Sprintf("user%d@example.com", i),
+				Password: "password123",
+			})
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := service.List(&models.UserFilter{}, &database.Paginate{Page: 1, Limit: 100})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	pagination := &database.Paginate{Page: 1, Limit: 100}
+	users, err := service.List(&models.UserFilter{}, pagination)
+	require.NoError(t, err)
+	assert.Len(t, users, 11)
+	assert.Equal(t, 11, pagination.Total)
+}
+
+// slowUserRepository is a UserRepository counting the lookups reaching it,
+// which wait for release
+type slowUserRepository struct {
+	repository.UserRepository
+	release chan struct{}
+	finds   atomic.Int32
+	lists   atomic.Int32
+}
+
+func (r *slowUserRepository) FindByID(id int) (*models.User, error) {
+	r.finds.Add(1)
+	<-r.release
+	return r.UserRepository.FindByID(id)
+}
+
+func (r *slowUserRepository) List(filter *models.UserFilter, pagination *database.Paginate) ([]*models.User, error) {
+	r.lists.Add(1)
+	<-r.release
+	return r.UserRepository.List(filter, pagination)
+}
+
+func TestUserCache_CoalescesMisses(t *testing.T) {
+	store := repository.NewMemoryStore()
+	require.NoError(t, store.Users().Create(&models.User{Username: "testuser", Email: "test@example.com", Status: models.StatusActive}))
+	userCache := NewUserCache(cache.NewMemoryCache(), UserCacheOptions{TTL: time.Minute, ListTTL: time.Minute}, zap.NewNop())
+	slow := &slowUserRepository{UserRepository: store.Users(), release: make(chan struct{})}
+	users := userCache.users(slow, nil)
+
+	// Concurrent misses wait on the first one's query; those arriving after
+	// it returns find the user cached
+	const n = 20
+	var wg sync.WaitGroup
+	found := make([]*models.User, n)
+	for i := 0; i < n; i++ {
+		i := i
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			user, err := users.FindByID(1)
+			assert.NoError(t, err)
+			found[i] = user
+		}()
+		go func() {
+			defer wg.Done()
+			pagination := &database.Paginate{Page: 1, Limit: 10}
+			list, err := users.List(&models.UserFilter{}, pagination)
+			assert.NoError(t, err)
+			assert.Len(t, list, 1)
+			assert.Equal(t, 1, pagination.Total)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(slow.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), slow.finds.Load())
+	assert.Equal(t, int32(1), slow.lists.Load())
+	for _, user := range found {
+		require.NotNil(t, user)
+		assert.Equal(t, "testuser", user.Username)
+	}
+	// Callers get copies of their own
+	found[0].Username = "changed"
+	assert.Equal(t, "testuser", found[1].Username)
+}
+
+func TestUserCache_WritesDontJoinEarlierMisses(t *testing.T) {
+	store := repository.NewMemoryStore()
+	require.NoError(t, store.Users().Create(&models.User{Username: "testuser", Email: "test@example.com", Status: models.StatusActive}))
+	userCache := NewUserCache(cache.NewMemoryCache(), UserCacheOptions{TTL: time.Minute}, zap.NewNop())
+	slow := &slowUserRepository{UserRepository: store.Users(), release: make(chan struct{})}
+	users := userCache.users(slow, nil)
+
+	var wg sync.WaitGroup
+	lookup := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := users.FindByID(1)
+			assert.NoError(t, err)
+		}()
+	}
+	waitForFinds := func(n int32) {
+		deadline := time.Now().Add(5 * time.Second)
+		for slow.finds.Load() < n && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// A lookup in flight when a user is written may have read it before,
+	// so lookups after the write query the database again
+	lookup()
+	waitForFinds(1)
+	user, err := store.Users().FindByID(1)
+	require.NoError(t, err)
+	user.Username = "renamed"
+	require.NoError(t, users.Update(user))
+	lookup()
+	waitForFinds(2)
+	assert.Equal(t, int32(2), slow.finds.Load())
+
+	close(slow.release)
+	wg.Wait()
+}
+
+// lateUserRepository is a UserRepository whose lookups read the user at
+// once but only return it once released, like a query whose result arrives
+// after a concurrent write
+type lateUserRepository struct {
+	repository.UserRepository
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (r *lateUserRepository) FindByID(id int) (*models.User, error) {
+	user, err := r.UserRepository.FindByID(id)
+	r.read <- struct{}{}
+	<-r.release
+	return user, err
+}
+
+func TestUserCache_ReadsBeforeWritesAreNotCached(t *testing.T) {
+	store := repository.NewMemoryStore()
+	require.NoError(t, store.Users().Create(&models.User{Username: "testuser", Email: "test@example.com", Status: models.StatusActive}))
+	userCache := NewUserCache(cache.NewMemoryCache(), UserCacheOptions{TTL: time.Minute}, zap.NewNop())
+	late := &lateUserRepository{UserRepository: store.Users(), read: make(chan struct{}), release: make(chan struct{})}
+	users := userCache.users(late, nil)
+
+	// A lookup reads the user, then the user is renamed before the lookup
+	// caches what it read
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		user, err := users.FindByID(1)
+		assert.NoError(t, err)
+		assert.Equal(t, "testuser", user.Username)
+	}()
+	<-late.read
+
+	user, err := store.Users().FindByID(1)
+	require.NoError(t, err)
+	user.Username = "renamed"
+	require.NoError(t, users.Update(user))
+
+	close(late.release)
+	<-done
+
+	// The stale user isn't served
+	go func() { <-late.read }()
+	found, err := users.FindByID(1)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", found.Username)
+}
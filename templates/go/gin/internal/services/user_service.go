@@ -1,52 +1,316 @@
 package services
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"gin-service/internal/database"
+	"gin-service/internal/mail"
 	"gin-service/internal/models"
+	"gin-service/internal/repository"
+	"gin-service/internal/storage"
 
 	"go.uber.org/zap"
 )
 
+// ConflictError reports the username or email of a user being taken by
+// another user, naming the field
+type ConflictError = repository.ConflictError
+
+// ErrUserNotFound is returned for a user that doesn't exist; match it with
+// errors.Is, as it may be wrapped
+var ErrUserNotFound = repository.ErrUserNotFound
+
+// ErrAdminExists is returned by CreateAdmin when an admin user already exists
+var ErrAdminExists = errors.New("an admin user already exists")
+
+var (
+	// ErrInvalidCredentials is returned for a login with an unknown user or
+	// a wrong password, telling neither apart
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrAccountInactive is returned for users whose account is inactive,
+	// when logging in or requesting the account's deletion
+	ErrAccountInactive = errors.New("user account is inactive")
+	// ErrAccountSuspended is returned for users whose account is suspended,
+	// when logging in or requesting the account's deletion
+	ErrAccountSuspended = errors.New("user account is suspended")
+)
+
+// txMaxRetries is how many times a transaction updating several rows is
+// retried after conflicting with a concurrent one
+const txMaxRetries = 3
+
 // UserServiceInterface defines the methods for user service
 type UserServiceInterface interface {
 	Create(req *models.CreateUserRequest) (*models.User, error)
 	GetByID(id int) (*models.User, error)
+	GetByIDs(ids []int) ([]*models.User, error)
 	GetByUsername(username string) (*models.User, error)
 	GetByEmail(email string) (*models.User, error)
 	List(filter *models.UserFilter, pagination *database.Paginate) ([]*models.User, error)
+	Search(query string, limit int) ([]*models.User, error)
 	Update(id int, req *models.UpdateUserRequest) (*models.User, error)
 	Delete(id int) error
-	Authenticate(username, password string) (*models.User, error)
+	BulkDelete(ids []int) (deleted int, skipped []int, err error)
+	Authenticate(identifier string, identifierType models.IdentifierType, password string) (*models.User, error)
+	SetAvatar(ctx context.Context, id int, data []byte) (*models.User, error)
+	SetupTwoFactor(id int) (*TwoFactorSetup, error)
+	EnableTwoFactor(id int, code string) (*models.User, []string, error)
+	VerifyTwoFactor(id int, challengeID, code string) (*models.User, error)
+	Impersonate(targetID, adminID int) (*models.User, error)
+	SetAdmin(id int, isAdmin bool, adminID int) (*models.User, error)
+	SetStatus(id int, status models.Status, adminID int) (*models.User, error)
+	ConfirmEmail(id int, token string) (*models.User, error)
+	ScheduleDeletion(id int) (*models.User, error)
+	CancelDeletion(id int) (*models.User, error)
+	ExportUserData(id int) (*models.UserDataBundle, error)
+	Anonymize(id, actorID int) error
+	// WithLogger returns the service logging with logger, typically the
+	// request-scoped logger
+	WithLogger(logger *zap.Logger) UserServiceInterface
+	// WithContext returns the service running its queries under ctx, so
+	// they are cancelled with the request
+	WithContext(ctx context.Context) UserServiceInterface
 }
 
 // UserService handles user-related business logic
 type UserService struct {
-	db     database.DBInterface
+	store  repository.Store
+	users  repository.UserRepository
+	audit  *AuditService
+	outbox *OutboxService
 	logger *zap.Logger
+
+	mailer       mail.Sender
+	blobs        storage.BlobStore
+	avatarLimits AvatarLimits
+	twoFactor    TwoFactorOptions
+	emailChange  EmailChangeOptions
+	// twoFactorAttempts tracks the codes tried at login, shared by copies of
+	// the service
+	twoFactorAttempts *twoFactorAttempts
+	// deletionGracePeriod is how long a requested account deletion can be
+	// cancelled
+	deletionGracePeriod time.Duration
+
+	// impersonator is the admin acting as the user, to whom audit records
+	// are attributed
+	impersonator *int
+
+	// loginThrottle limits login attempts per client IP, if set
+	loginThrottle LoginThrottle
+	// clientIP is the IP address of the request the service serves
+	clientIP string
+
+	// cache holds users looked up by ID, nil when caching is disabled
+	cache *UserCache
+	// written collects the users written by a transaction-bound service,
+	// invalidated in the cache once the transaction commits
+	written *[]int
 }
 
 // NewUserService creates a new user service
-func NewUserService(db database.DBInterface, logger *zap.Logger) *UserService {
+func NewUserService(store repository.Store, logger *zap.Logger) *UserService {
 	return &UserService{
-		db:     db,
+		store:  store,
+		users:  store.Users(),
+		audit:  NewAuditService(store.AuditLogs(), logger),
+		outbox: NewOutboxService(store.Outbox(), logger),
 		logger: logger,
+
+		mailer: mail.NewLogSender(logger),
+		emailChange: EmailChangeOptions{
+			TTL: defaultEmailChangeTTL,
+		},
+		deletionGracePeriod: defaultDeletionGracePeriod,
+	}
+}
+
+// WithStore returns a copy of the service using the given store, typically
+// one bound to a transaction, so callers can compose several operations,
+// possibly across services, into one atomic unit
+func (s *UserService) WithStore(store repository.Store) *UserService {
+	return &UserService{
+		store:  store,
+		users:  s.cache.users(store.Users(), s.written),
+		audit:  NewAuditService(store.AuditLogs(), s.logger),
+		outbox: NewOutboxService(store.Outbox(), s.logger),
+		logger: s.logger,
+
+		mailer:       s.mailer,
+		blobs:        s.blobs,
+		avatarLimits: s.avatarLimits,
+		twoFactor:    s.twoFactor,
+		emailChange:  s.emailChange,
+		impersonator: s.impersonator,
+
+		twoFactorAttempts: s.twoFactorAttempts,
+
+		loginThrottle: s.loginThrottle,
+		clientIP:      s.clientIP,
+
+		deletionGracePeriod: s.deletionGracePeriod,
+
+		cache:   s.cache,
+		written: s.written,
+	}
+}
+
+// WithLogger returns a copy of the service that logs with logger
+func (s *UserService) WithLogger(logger *zap.Logger) UserServiceInterface {
+	return &UserService{
+		store:  s.store,
+		users:  s.users,
+		audit:  NewAuditService(s.store.AuditLogs(), logger),
+		outbox: NewOutboxService(s.store.Outbox(), logger),
+		logger: logger,
+
+		mailer:       s.mailer,
+		blobs:        s.blobs,
+		avatarLimits: s.avatarLimits,
+		twoFactor:    s.twoFactor,
+		emailChange:  s.emailChange,
+		impersonator: s.impersonator,
+
+		twoFactorAttempts: s.twoFactorAttempts,
+
+		loginThrottle: s.loginThrottle,
+		clientIP:      s.clientIP,
+
+		deletionGracePeriod: s.deletionGracePeriod,
+
+		cache:   s.cache,
+		written: s.written,
+	}
+}
+
+// WithContext returns a copy of the service whose store runs its queries
+// under ctx. An impersonator carried by ctx becomes the actor of the
+// service's audit records, and a client IP is the key of login throttling.
+func (s *UserService) WithContext(ctx context.Context) UserServiceInterface {
+	service := s.WithStore(s.store.WithContext(ctx))
+	if adminID, ok := ImpersonatorFromContext(ctx); ok {
+		service.impersonator = &adminID
+	}
+	if ip, ok := ClientIPFromContext(ctx); ok {
+		service.clientIP = ip
+	}
+	return service
+}
+
+// SetCache makes the service cache the users it looks up by ID, invalidating
+// those it writes. Every service writing users must share the cache, or
+// their writes go unnoticed until the cached users expire.
+func (s *UserService) SetCache(cache *UserCache) {
+	s.cache = cache
+	s.users = cache.users(s.store.Users(), s.written)
+}
+
+// SetMailer sets the sender of the emails the service sends, which only
+// logs them by default
+func (s *UserService) SetMailer(sender mail.Sender) {
+	s.mailer = sender
+}
+
+// actor returns the ID to record as the actor of an action userID performed
+// themselves, which is the impersonating admin's if there is one
+func (s *UserService) actor(userID int) *int {
+	if s.impersonator != nil {
+		return s.impersonator
+	}
+	return &userID
+}
+
+// inTx runs fn with a transaction-bound service. If the service is already
+// bound to a transaction, fn joins it instead of starting a new one.
+func (s *UserService) inTx(fn func(txService *UserService) error) error {
+	written := s.txWritten()
+	err := s.store.Transaction(func(tx repository.Store) error {
+		return fn(s.withTx(tx, written))
+	})
+	s.invalidateWritten(written)
+	return err
+}
+
+// inTxWithRetry runs fn like inTx, running it again when a concurrent
+// transaction causes a serialization failure or deadlock. fn must only
+// write, so that it can safely run more than once.
+func (s *UserService) inTxWithRetry(fn func(txService *UserService) error) error {
+	written := s.txWritten()
+	err := s.store.TransactionWithRetry(func(tx repository.Store) error {
+		return fn(s.withTx(tx, written))
+	}, txMaxRetries)
+	s.invalidateWritten(written)
+	return err
+}
+
+// txWritten returns where a transaction run by the service collects the
+// users it writes: a new list, or the service's own when it is already bound
+// to the transaction it joins
+func (s *UserService) txWritten() *[]int {
+	if s.written != nil {
+		return s.written
+	}
+	return &[]int{}
+}
+
+// withTx returns the service bound to tx, collecting the users it writes in
+// written
+func (s *UserService) withTx(tx repository.Store, written *[]int) *UserService {
+	txService := s.WithStore(tx)
+	txService.written = written
+	txService.users = s.cache.users(tx.Users(), written)
+	return txService
+}
+
+// invalidateWritten invalidates the cached users written by a transaction
+// once it ends, unless it was joined and ends later. Users are invalidated
+// even when it rolls back, which costs at most cache misses.
+func (s *UserService) invalidateWritten(written *[]int) {
+	if s.written == nil {
+		s.cache.invalidate(*written...)
 	}
 }
 
 // Create creates a new user
 func (s *UserService) Create(req *models.CreateUserRequest) (*models.User, error) {
+	return s.create(req, false)
+}
+
+// CreateAdmin creates a new user with admin privileges. Unless force is set,
+// it returns ErrAdminExists without creating anything when an admin exists.
+func (s *UserService) CreateAdmin(req *models.CreateUserRequest, force bool) (*models.User, error) {
+	if !force {
+		exists, err := s.users.AdminExists()
+		if err != nil {
+			s.logger.Error("Failed to check for existing admin", zap.Error(err))
+			return nil, fmt.Errorf("failed to check for existing admin: %w", err)
+		}
+		if exists {
+			return nil, ErrAdminExists
+		}
+	}
+
+	return s.create(req, true)
+}
+
+// create inserts a new user after checking for conflicts
+func (s *UserService) create(req *models.CreateUserRequest, isAdmin bool) (*models.User, error) {
+	if err := models.ValidatePassword(req.Password); err != nil {
+		return nil, err
+	}
+
 	// Check if username already exists
 	existingUser, err := s.GetByUsername(req.Username)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("failed to check existing username: %w", err)
 	}
 	if existingUser != nil {
-		return nil, fmt.Errorf("username already exists")
+		return nil, repository.ErrDuplicateUsername
 	}
 
 	// Check if email already exists
@@ -55,7 +319,7 @@ func (s *UserService) Create(req *models.CreateUserRequest) (*models.User, error
 		return nil, fmt.Errorf("failed to check existing email: %w", err)
 	}
 	if existingUser != nil {
-		return nil, fmt.Errorf("email already exists")
+		return nil, repository.ErrDuplicateEmail
 	}
 
 	// Create user
@@ -64,7 +328,8 @@ func (s *UserService) Create(req *models.CreateUserRequest) (*models.User, error
 		Email:    req.Email,
 		FullName: req.FullName,
 		IsActive: true,
-		IsAdmin:  false,
+		Status:   models.StatusActive,
+		IsAdmin:  isAdmin,
 	}
 
 	// Hash password
@@ -74,107 +339,95 @@ func (s *UserService) Create(req *models.CreateUserRequest) (*models.User, error
 
 	user.BeforeInsert()
 
-	// Insert user
-	query := `
-		INSERT INTO users (username, email, password_hash, full_name, is_active, is_admin, created_at, updated_at)
-		VALUES (:username, :email, :password_hash, :full_name, :is_active, :is_admin, :created_at, :updated_at)
-		RETURNING id`
-
-	rows, err := s.db.NamedQuery(query, user)
-	if err != nil {
-		s.logger.Error("Failed to create user", zap.Error(err))
-		return nil, fmt.Errorf("failed to create user: %w", err)
-	}
-	defer rows.Close()
-
-	if rows.Next() {
-		if err := rows.Scan(&user.ID); err != nil {
-			return nil, fmt.Errorf("failed to scan user ID: %w", err)
+	// Insert the user, its audit entry and its event atomically
+	err = s.inTx(func(txService *UserService) error {
+		if err := txService.users.Create(user); err != nil {
+			// Lost a race with a concurrent registration: the unique
+			// constraint reports the same conflict as the check above
+			if errors.Is(err, repository.ErrDuplicateUsername) || errors.Is(err, repository.ErrDuplicateEmail) {
+				return err
+			}
+			txService.logger.Error("Failed to create user", zap.Error(err))
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+		if err := txService.audit.Record(models.AuditActionUserCreated, &user.ID, nil, map[string]interface{}{
+			"username": user.Username,
+			"is_admin": user.IsAdmin,
+		}); err != nil {
+			return err
 		}
+		return txService.outbox.Record(models.EventUserCreated, user.ID, user.ToResponse())
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	s.logger.Info("User created", zap.Int("user_id", user.ID), zap.String("username", user.Username))
+	s.logger.Info("User created", zap.Int("user_id", user.ID), zap.String("username", user.Username), zap.Bool("is_admin", user.IsAdmin))
 	return user, nil
 }
 
 // GetByID retrieves a user by ID
 func (s *UserService) GetByID(id int) (*models.User, error) {
-	var user models.User
-	query := `SELECT * FROM users WHERE id = $1`
-
-	err := s.db.Get(&user, query, id)
+	user, err := s.users.FindByID(id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		s.logger.Error("Failed to get user by ID", zap.Error(err), zap.Int("user_id", id))
+		s.logger.Error("Failed to get user by ID", zap.Error(err), zap.Int("target_user_id", id))
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
-	return &user, nil
+	return user, nil
+}
+
+// GetByIDs retrieves the users with the given IDs in ID order. IDs that
+// don't exist are skipped.
+func (s *UserService) GetByIDs(ids []int) ([]*models.User, error) {
+	users, err := s.users.FindByIDs(ids)
+	if err != nil {
+		s.logger.Error("Failed to get users by IDs", zap.Error(err), zap.Int("count", len(ids)))
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+
+	return users, nil
 }
 
 // GetByUsername retrieves a user by username
 func (s *UserService) GetByUsername(username string) (*models.User, error) {
-	var user models.User
-	query := `SELECT * FROM users WHERE username = $1`
-
-	err := s.db.Get(&user, query, username)
+	user, err := s.users.FindByUsername(username)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
 		s.logger.Error("Failed to get user by username", zap.Error(err), zap.String("username", username))
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 // GetByEmail retrieves a user by email
 func (s *UserService) GetByEmail(email string) (*models.User, error) {
-	var user models.User
-	query := `SELECT * FROM users WHERE email = $1`
-
-	err := s.db.Get(&user, query, email)
+	user, err := s.users.FindByEmail(email)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
 		s.logger.Error("Failed to get user by email", zap.Error(err), zap.String("email", email))
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 // List retrieves users with filtering and pagination
 func (s *UserService) List(filter *models.UserFilter, pagination *database.Paginate) ([]*models.User, error) {
-	pagination.CalculateOffset()
-
-	// Build query with filters
-	whereClause, args := s.buildWhereClause(filter)
-
-	// Count total records
-	countQuery := "SELECT COUNT(*) FROM users" + whereClause
-	var total int
-	if err := s.db.Get(&total, countQuery, args...); err != nil {
-		s.logger.Error("Failed to count users", zap.Error(err))
-		return nil, fmt.Errorf("failed to count users: %w", err)
+	users, err := s.users.List(filter, pagination)
+	if err != nil {
+		s.logger.Error("Failed to list users", zap.Error(err))
+		return nil, fmt.Errorf("failed to list users: %w", err)
 	}
-	pagination.SetTotal(total)
 
-	// Get users
-	query := fmt.Sprintf(`
-		SELECT * FROM users %s 
-		ORDER BY created_at DESC 
-		LIMIT %d OFFSET %d`,
-		whereClause, pagination.Limit, pagination.Offset)
+	return users, nil
+}
 
-	var users []*models.User
-	if err := s.db.Select(&users, query, args...); err != nil {
-		s.logger.Error("Failed to list users", zap.Error(err))
-		return nil, fmt.Errorf("failed to list users: %w", err)
+// Search retrieves up to limit users matching query, most relevant first
+func (s *UserService) Search(query string, limit int) ([]*models.User, error) {
+	users, err := s.users.Search(strings.TrimSpace(query), limit)
+	if err != nil {
+		s.logger.Error("Failed to search users", zap.Error(err))
+		return nil, fmt.Errorf("failed to search users: %w", err)
 	}
 
 	return users, nil
@@ -182,13 +435,19 @@ func (s *UserService) List(filter *models.UserFilter, pagination *database.Pagin
 
 // Update updates a user
 func (s *UserService) Update(id int, req *models.UpdateUserRequest) (*models.User, error) {
+	if req.Password != nil {
+		if err := models.ValidatePassword(*req.Password); err != nil {
+			return nil, err
+		}
+	}
+
 	// Get existing user
 	user, err := s.GetByID(id)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
-		return nil, fmt.Errorf("user not found")
+		return nil, repository.ErrUserNotFound
 	}
 
 	// Check for conflicts
@@ -198,29 +457,32 @@ func (s *UserService) Update(id int, req *models.UpdateUserRequest) (*models.Use
 			return nil, fmt.Errorf("failed to check existing username: %w", err)
 		}
 		if existingUser != nil {
-			return nil, fmt.Errorf("username already exists")
+			return nil, repository.ErrDuplicateUsername
 		}
 		user.Username = *req.Username
 	}
 
-	if req.Email != nil && *req.Email != user.Email {
-		existingUser, err := s.GetByEmail(*req.Email)
-		if err != nil && err != sql.ErrNoRows {
-			return nil, fmt.Errorf("failed to check existing email: %w", err)
-		}
-		if existingUser != nil {
-			return nil, fmt.Errorf("email already exists")
+	// A new email only takes effect once confirmed from the new address,
+	// while asking for the current one again drops a pending change
+	var emailChangeToken string
+	if req.Email != nil {
+		if *req.Email == user.Email {
+			user.ClearEmailChange()
+		} else {
+			emailChangeToken, err = s.requestEmailChange(user, *req.Email)
+			if err != nil {
+				return nil, err
+			}
 		}
-		user.Email = *req.Email
 	}
 
-	// Update fields
-	if req.FullName != nil {
-		user.FullName = req.FullName
+	// Update fields; a null full name clears it
+	if req.FullName.Set {
+		user.FullName = req.FullName.Ptr()
 	}
 
 	if req.IsActive != nil {
-		user.IsActive = *req.IsActive
+		user.SetActive(*req.IsActive)
 	}
 
 	if req.Password != nil {
@@ -231,76 +493,137 @@ func (s *UserService) Update(id int, req *models.UpdateUserRequest) (*models.Use
 
 	user.BeforeUpdate()
 
-	// Update in database
-	query := `
-		UPDATE users 
-		SET username = :username, email = :email, password_hash = :password_hash, 
-			full_name = :full_name, is_active = :is_active, updated_at = :updated_at
-		WHERE id = :id`
+	// Update in database along with its event
+	err = s.inTxWithRetry(func(txService *UserService) error {
+		if err := txService.users.Update(user); err != nil {
+			// The user may have been deleted since it was read
+			if errors.Is(err, repository.ErrUserNotFound) || errors.Is(err, repository.ErrDuplicateUsername) || errors.Is(err, repository.ErrDuplicateEmail) {
+				return err
+			}
+			txService.logger.Error("Failed to update user", zap.Error(err), zap.Int("target_user_id", id))
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		if emailChangeToken != "" {
+			if err := txService.audit.Record(models.AuditActionEmailChangeRequested, &user.ID, txService.impersonator, map[string]interface{}{
+				"pending_email": *user.PendingEmail,
+			}); err != nil {
+				return err
+			}
+		}
+		return txService.outbox.Record(models.EventUserUpdated, user.ID, user.ToResponse())
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	if _, err := s.db.NamedExec(query, user); err != nil {
-		s.logger.Error("Failed to update user", zap.Error(err), zap.Int("user_id", id))
-		return nil, fmt.Errorf("failed to update user: %w", err)
+	if emailChangeToken != "" {
+		s.sendEmailChange(user, emailChangeToken)
 	}
 
-	s.logger.Info("User updated", zap.Int("user_id", user.ID), zap.String("username", user.Username))
+	s.logger.Info("User updated", zap.Int("target_user_id", user.ID), zap.String("username", user.Username))
 	return user, nil
 }
 
 // Delete deletes a user
 func (s *UserService) Delete(id int) error {
-	query := `DELETE FROM users WHERE id = $1`
-
-	result, err := s.db.Exec(query, id)
+	err := s.inTx(func(txService *UserService) error {
+		if err := txService.users.Delete(id); err != nil {
+			if errors.Is(err, repository.ErrUserNotFound) {
+				return err
+			}
+			txService.logger.Error("Failed to delete user", zap.Error(err), zap.Int("target_user_id", id))
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+		return txService.outbox.Record(models.EventUserDeleted, id, map[string]int{"id": id})
+	})
 	if err != nil {
-		s.logger.Error("Failed to delete user", zap.Error(err), zap.Int("user_id", id))
-		return fmt.Errorf("failed to delete user: %w", err)
+		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	s.deleteAvatar(id)
+
+	s.logger.Info("User deleted", zap.Int("target_user_id", id))
+	return nil
+}
+
+// BulkDelete deletes the users with the given IDs in one transaction,
+// returning how many were deleted and the IDs skipped because they don't
+// exist. Any other failure deletes nothing.
+func (s *UserService) BulkDelete(ids []int) (deleted int, skipped []int, err error) {
+	var deletedIDs []int
+	err = s.inTx(func(txService *UserService) error {
+		deletedIDs, skipped = nil, []int{}
+		for _, id := range ids {
+			if err := txService.users.Delete(id); err != nil {
+				if errors.Is(err, repository.ErrUserNotFound) {
+					skipped = append(skipped, id)
+					continue
+				}
+				txService.logger.Error("Failed to delete user", zap.Error(err), zap.Int("target_user_id", id))
+				return fmt.Errorf("failed to delete user %d: %w", id, err)
+			}
+			if err := txService.outbox.Record(models.EventUserDeleted, id, map[string]int{"id": id}); err != nil {
+				return err
+			}
+			deletedIDs = append(deletedIDs, id)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return 0, nil, err
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("user not found")
+	for _, id := range deletedIDs {
+		s.deleteAvatar(id)
 	}
 
-	s.logger.Info("User deleted", zap.Int("user_id", id))
-	return nil
+	s.logger.Info("Users deleted", zap.Ints("target_user_ids", deletedIDs), zap.Ints("skipped_user_ids", skipped))
+	return len(deletedIDs), skipped, nil
 }
 
-// Authenticate authenticates a user with username/email and password
-func (s *UserService) Authenticate(username, password string) (*models.User, error) {
-	var user *models.User
-	var err error
-
-	// Try to find by email first, then by username
-	if strings.Contains(username, "@") {
-		user, err = s.GetByEmail(username)
-	} else {
-		user, err = s.GetByUsername(username)
+// Authenticate authenticates a user with a username or email, as told by
+// identifierType, and password. Attempts beyond the login throttle's limit
+// fail with a *LoginThrottledError before the credentials are checked.
+// Accounts pending deletion fail with a *DeletionPendingError.
+func (s *UserService) Authenticate(identifier string, identifierType models.IdentifierType, password string) (*models.User, error) {
+	if err := s.throttleLogin(); err != nil {
+		return nil, err
 	}
 
+	user, err := s.findLoginUser(identifier, identifierType)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
 	if user == nil {
-		return nil, fmt.Errorf("invalid credentials")
-	}
-
-	if !user.IsActive {
-		return nil, fmt.Errorf("user account is inactive")
+		// Spend the time of a password check, as for existing users
+		models.SimulatePasswordCheck(password)
+		return nil, ErrInvalidCredentials
 	}
 
 	// Check password
 	if err := user.CheckPassword(password); err != nil {
-		return nil, fmt.Errorf("invalid credentials")
+		return nil, ErrInvalidCredentials
+	}
+
+	// The account's status is only told to whoever knows the password
+	switch {
+	case user.Status == models.StatusSuspended:
+		return nil, ErrAccountSuspended
+	case user.DeletionPending():
+		return nil, &DeletionPendingError{User: user}
+	case !user.IsActive:
+		return nil, ErrAccountInactive
+	}
+
+	// Upgrade hashes made with another algorithm or weaker parameters while
+	// the password is at hand
+	if user.PasswordNeedsRehash() {
+		s.rehashPassword(user, password)
 	}
 
 	// Update last login
-	if err := s.updateLastLogin(user.ID); err != nil {
+	if err := s.users.UpdateLastLogin(user.ID, time.Now()); err != nil {
 		s.logger.Warn("Failed to update last login", zap.Error(err), zap.Int("user_id", user.ID))
 	}
 
@@ -308,57 +631,43 @@ func (s *UserService) Authenticate(username, password string) (*models.User, err
 	return user, nil
 }
 
-// updateLastLogin updates the user's last login timestamp
-func (s *UserService) updateLastLogin(userID int) error {
-	query := `UPDATE users SET last_login = $1 WHERE id = $2`
-	_, err := s.db.Exec(query, time.Now(), userID)
-	return err
-}
-
-// buildWhereClause builds the WHERE clause for user queries
-func (s *UserService) buildWhereClause(filter *models.UserFilter) (string, []interface{}) {
-	if filter == nil {
-		return "", nil
-	}
-
-	var conditions []string
-	var args []interface{}
-	argCount := 0
-
-	if filter.Username != nil {
-		argCount++
-		conditions = append(conditions, fmt.Sprintf("username ILIKE $%d", argCount))
-		args = append(args, "%"+*filter.Username+"%")
-	}
-
-	if filter.Email != nil {
-		argCount++
-		conditions = append(conditions, fmt.Sprintf("email ILIKE $%d", argCount))
-		args = append(args, "%"+*filter.Email+"%")
-	}
-
-	if filter.IsActive != nil {
-		argCount++
-		conditions = append(conditions, fmt.Sprintf("is_active = $%d", argCount))
-		args = append(args, *filter.IsActive)
-	}
-
-	if filter.IsAdmin != nil {
-		argCount++
-		conditions = append(conditions, fmt.Sprintf("is_admin = $%d", argCount))
-		args = append(args, *filter.IsAdmin)
-	}
-
-	if filter.Search != nil {
-		argCount++
-		searchCondition := fmt.Sprintf("(username ILIKE $%d OR email ILIKE $%d OR full_name ILIKE $%d)", argCount, argCount, argCount)
-		conditions = append(conditions, searchCondition)
-		args = append(args, "%"+*filter.Search+"%")
-	}
-
-	if len(conditions) == 0 {
-		return "", nil
+// findLoginUser looks up the user named by a login identifier. Automatic
+// lookups guess the type from an @ and try the other type when nothing
+// matches, so that usernames may contain an @.
+func (s *UserService) findLoginUser(identifier string, identifierType models.IdentifierType) (*models.User, error) {
+	switch identifierType {
+	case models.IdentifierEmail:
+		return s.GetByEmail(identifier)
+	case models.IdentifierUsername:
+		return s.GetByUsername(identifier)
+	}
+
+	lookups := []func(string) (*models.User, error){s.GetByUsername, s.GetByEmail}
+	if strings.Contains(identifier, "@") {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+	for _, lookup := range lookups {
+		user, err := lookup(identifier)
+		if err != nil || user != nil {
+			return user, err
+		}
 	}
+	return nil, nil
+}
 
-	return " WHERE " + strings.Join(conditions, " AND "), args
+// rehashPassword replaces the user's password hash with one made with the
+// current algorithm and parameters. Failures are only logged: the old hash
+// keeps working.
+func (s *UserService) rehashPassword(user *models.User, password string) {
+	oldHash := user.Password
+	if err := user.SetPassword(password); err != nil {
+		s.logger.Warn("Failed to rehash password", zap.Error(err), zap.Int("user_id", user.ID))
+		return
+	}
+	if err := s.users.UpdatePasswordHash(user.ID, user.Password); err != nil {
+		user.Password = oldHash
+		s.logger.Warn("Failed to store rehashed password", zap.Error(err), zap.Int("user_id", user.ID))
+		return
+	}
+	s.logger.Info("Password rehashed", zap.Int("user_id", user.ID))
 }
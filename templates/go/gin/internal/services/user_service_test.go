@@ -1,15 +1,25 @@
 package services
 
 import (
+	"context"
 	"database/sql"
+	"strings"
 	"testing"
+	"time"
 
+	"gin-service/internal/database"
+	"gin-service/internal/database/testutil"
 	"gin-service/internal/models"
+	"gin-service/internal/repository"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // MockDB is a mock database for testing
@@ -17,6 +27,8 @@ type MockDB struct {
 	mock.Mock
 }
 
+var _ database.DBInterface = (*MockDB)(nil)
+
 func (m *MockDB) Get(dest interface{}, query string, args ...interface{}) error {
 	mockArgs := m.Called(dest, query, args)
 	return mockArgs.Error(0)
@@ -107,7 +119,6 @@ func (m *MockDB) Transaction(fn func(*sqlx.Tx) error) error {
 	return args.Error(0)
 }
 
-
 // MockResult is a mock implementation of sql.Result
 type MockResult struct {
 	mock.Mock
@@ -126,14 +137,248 @@ func (m *MockResult) RowsAffected() (int64, error) {
 func setupUserService() (*UserService, *MockDB) {
 	mockDB := &MockDB{}
 	logger := zap.NewNop()
-	service := NewUserService(mockDB, logger)
+	service := NewUserService(repository.NewSQLStore(mockDB), logger)
 	return service, mockDB
 }
 
+// newMemoryUserService returns a user service on an in-memory store, with a
+// user for each of usernames, whose email is <username>@example.com and
+// password "password123"
+func newMemoryUserService(t *testing.T, usernames ...string) (*UserService, *repository.MemoryStore, []*models.User) {
+	t.Helper()
+	store := repository.NewMemoryStore()
+	service := NewUserService(store, zap.NewNop())
+
+	var users []*models.User
+	for _, username := range usernames {
+		user, err := service.Create(&models.CreateUserRequest{
+			Username: username,
+			Email:    username + "@example.com",
+			Password: "password123",
+		})
+		require.NoError(t, err)
+		users = append(users, user)
+	}
+	return service, store, users
+}
+
+// lastAuditEntry returns the audit entry recorded last in store
+func lastAuditEntry(t *testing.T, store *repository.MemoryStore) models.AuditLog {
+	t.Helper()
+	entries := store.AuditLogEntries()
+	require.NotEmpty(t, entries)
+	return entries[len(entries)-1]
+}
+
+// TestMain stops the embedded test database once the tests are done
+func TestMain(m *testing.M) {
+	testutil.Main(m)
+}
+
 func TestUserService_Create_Success(t *testing.T) {
-	// TODO: Fix this test - sqlx.Rows mocking is complex
-	// Skipping for now to unblock compilation
-	t.Skip("Skipping due to sqlx.Rows mocking complexity")
+	store := repository.NewMemoryStore()
+	service := NewUserService(store, zap.NewNop())
+
+	req := &models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	}
+
+	// Execute the test
+	user, err := service.Create(req)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.NotNil(t, user)
+	assert.NotZero(t, user.ID)
+	assert.True(t, user.IsActive)
+	assert.NoError(t, user.CheckPassword("password123"))
+
+	stored, err := service.GetByUsername("testuser")
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, stored.ID)
+
+	// The audit entry is written with the user
+	entries := store.AuditLogEntries()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, models.AuditActionUserCreated, entries[0].Action)
+	assert.Equal(t, user.ID, *entries[0].UserID)
+
+	// So is its event, waiting to be published
+	events := store.OutboxEvents()
+	require.Len(t, events, 1)
+	assert.Equal(t, models.EventUserCreated, events[0].EventType)
+	assert.Equal(t, user.ID, events[0].AggregateID)
+	assert.Nil(t, events[0].SentAt)
+}
+
+// TestUserService_Create_SQL runs against the test database, covering the
+// INSERT ... RETURNING the in-memory store stands in for. Its writes are rolled back when it ends.
+func TestUserService_Create_SQL(t *testing.T) {
+	store := repository.NewSQLStore(testutil.Tx(t))
+	service := NewUserService(store, zap.NewNop())
+
+	req := &models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	}
+
+	user, err := service.Create(req)
+	require.NoError(t, err)
+	assert.NotZero(t, user.ID)
+	assert.True(t, user.IsActive)
+	assert.NoError(t, user.CheckPassword("password123"))
+
+	stored, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, "testuser", stored.Username)
+	assert.Equal(t, "test@example.com", stored.Email)
+
+	// The audit entry and event are written with the user
+	entries, err := store.AuditLogs().ListByUser(user.ID)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, models.AuditActionUserCreated, entries[0].Action)
+
+	events, err := store.Outbox().Claim("test", 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, models.EventUserCreated, events[0].EventType)
+	assert.Equal(t, user.ID, events[0].AggregateID)
+
+	// The username is taken now
+	_, err = service.Create(req)
+	assert.ErrorIs(t, err, repository.ErrDuplicateUsername)
+}
+
+// TestUserService_Update_LogsSlowQueries_SQL runs against the test database,
+// since the writes of the transactional services only go through the query
+// logger when it wraps the transaction's queries too
+func TestUserService_Update_LogsSlowQueries_SQL(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	// Every query is slow under a threshold of a nanosecond
+	store := repository.NewSQLStore(database.NewQueryLogger(testutil.Tx(t), time.Nanosecond, zap.New(core)))
+	service := NewUserService(store, zap.NewNop())
+
+	user, err := service.Create(&models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	require.NoError(t, err)
+	logs.TakeAll()
+
+	_, err = service.WithContext(database.ContextWithRequestID(context.Background(), "req-123")).
+		Update(user.ID, &models.UpdateUserRequest{FullName: models.NullableValue("Test User")})
+	require.NoError(t, err)
+
+	var update map[string]interface{}
+	for _, entry := range logs.FilterMessage("Slow query").All() {
+		fields := entry.ContextMap()
+		if strings.Contains(fields["query"].(string), "UPDATE users") {
+			update = fields
+		}
+	}
+	require.NotNil(t, update, "the UPDATE run within the transaction is logged")
+	assert.EqualValues(t, 1, update["rows_affected"])
+	assert.Equal(t, "req-123", update["request_id"])
+}
+
+func TestUserService_Create_RollsBackOnAuditFailure(t *testing.T) {
+	store := &failingAuditStore{MemoryStore: repository.NewMemoryStore()}
+	service := NewUserService(store, zap.NewNop())
+
+	req := &models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	}
+
+	// Execute the test
+	user, err := service.Create(req)
+
+	// Assertions
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, user)
+
+	// The user insert is rolled back with the failed audit entry
+	stored, err := service.GetByUsername("testuser")
+	assert.NoError(t, err)
+	assert.Nil(t, stored)
+}
+
+// failingAuditStore is a MemoryStore whose audit log writes fail
+type failingAuditStore struct {
+	*repository.MemoryStore
+}
+
+func (s *failingAuditStore) AuditLogs() repository.AuditLogRepository {
+	return failingAuditLogs{}
+}
+
+func (s *failingAuditStore) Transaction(fn func(tx repository.Store) error) error {
+	return s.MemoryStore.Transaction(func(repository.Store) error {
+		return fn(s)
+	})
+}
+
+type failingAuditLogs struct{}
+
+func (failingAuditLogs) Create(*models.AuditLog) error {
+	return assert.AnError
+}
+
+func (failingAuditLogs) ListByUser(int) ([]*models.AuditLog, error) {
+	return nil, assert.AnError
+}
+
+func TestUserService_Create_DuplicateKeyRace(t *testing.T) {
+	store := &racingStore{MemoryStore: repository.NewMemoryStore()}
+	service := NewUserService(store, zap.NewNop())
+
+	user, err := service.Create(&models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+
+	// The unique constraint catches what the existence check missed, and
+	// the handler answers the conflict with 409
+	assert.Nil(t, user)
+	assert.ErrorIs(t, err, repository.ErrDuplicateUsername)
+	assert.EqualError(t, err, "username already exists")
+}
+
+// racingStore is a MemoryStore where a concurrent registration takes the
+// username between the service's existence check and its insert
+type racingStore struct {
+	*repository.MemoryStore
+}
+
+func (s *racingStore) Users() repository.UserRepository {
+	return racingUsers{s.MemoryStore.Users()}
+}
+
+func (s *racingStore) Transaction(fn func(tx repository.Store) error) error {
+	return s.MemoryStore.Transaction(func(repository.Store) error {
+		return fn(s)
+	})
+}
+
+type racingUsers struct {
+	repository.UserRepository
+}
+
+func (r racingUsers) Create(user *models.User) error {
+	rival := *user
+	rival.Email = "rival@example.com"
+	if err := r.UserRepository.Create(&rival); err != nil {
+		return err
+	}
+	return r.UserRepository.Create(user)
 }
 
 func TestUserService_Create_UsernameExists(t *testing.T) {
@@ -277,7 +522,7 @@ func TestUserService_Authenticate_Success(t *testing.T) {
 		Return(mockResult, nil)
 
 	// Execute the test
-	authenticatedUser, err := service.Authenticate("testuser", "password123")
+	authenticatedUser, err := service.Authenticate("testuser", models.IdentifierAuto, "password123")
 
 	// Assertions
 	assert.NoError(t, err)
@@ -311,7 +556,7 @@ func TestUserService_Authenticate_InvalidCredentials(t *testing.T) {
 	})
 
 	// Execute the test with wrong password
-	authenticatedUser, err := service.Authenticate("testuser", "wrongpassword")
+	authenticatedUser, err := service.Authenticate("testuser", models.IdentifierAuto, "wrongpassword")
 
 	// Assertions
 	assert.Error(t, err)
@@ -322,40 +567,440 @@ func TestUserService_Authenticate_InvalidCredentials(t *testing.T) {
 }
 
 func TestUserService_Delete_Success(t *testing.T) {
-	service, mockDB := setupUserService()
+	store := repository.NewMemoryStore()
+	service := NewUserService(store, zap.NewNop())
 
-	mockResult := &MockResult{}
-	mockResult.On("RowsAffected").Return(int64(1), nil)
+	user, err := service.Create(&models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	require.NoError(t, err)
 
-	mockDB.On("Exec", "DELETE FROM users WHERE id = $1", []interface{}{1}).
-		Return(mockResult, nil)
+	// Execute the test
+	err = service.Delete(user.ID)
+
+	// Assertions
+	assert.NoError(t, err)
+
+	stored, err := service.GetByID(user.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, stored)
+
+	events := store.OutboxEvents()
+	require.Len(t, events, 2)
+	assert.Equal(t, models.EventUserDeleted, events[1].EventType)
+	assert.Equal(t, user.ID, events[1].AggregateID)
+}
+
+func TestUserService_Delete_NotFound(t *testing.T) {
+	store := repository.NewMemoryStore()
+	service := NewUserService(store, zap.NewNop())
 
 	// Execute the test
 	err := service.Delete(1)
 
 	// Assertions
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "user not found")
+
+	// Nothing happened, so there is nothing to publish
+	assert.Empty(t, store.OutboxEvents())
+}
+
+func TestUserService_BulkDelete(t *testing.T) {
+	store := repository.NewMemoryStore()
+	service := NewUserService(store, zap.NewNop())
+
+	var ids []int
+	for _, username := range []string{"first", "second", "kept"} {
+		user, err := service.Create(&models.CreateUserRequest{
+			Username: username,
+			Email:    username + "@example.com",
+			Password: "password123",
+		})
+		require.NoError(t, err)
+		ids = append(ids, user.ID)
+	}
+
+	// Execute the test
+	deleted, skipped, err := service.BulkDelete([]int{ids[0], 42, ids[1]})
+
+	// Assertions
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, []int{42}, skipped)
+
+	for _, id := range ids[:2] {
+		stored, err := service.GetByID(id)
+		assert.NoError(t, err)
+		assert.Nil(t, stored)
+	}
+	kept, err := service.GetByID(ids[2])
 	assert.NoError(t, err)
+	assert.NotNil(t, kept)
+
+	events := store.OutboxEvents()
+	require.Len(t, events, 5)
+	assert.Equal(t, models.EventUserDeleted, events[3].EventType)
+	assert.Equal(t, ids[0], events[3].AggregateID)
+	assert.Equal(t, models.EventUserDeleted, events[4].EventType)
+	assert.Equal(t, ids[1], events[4].AggregateID)
+}
+
+func TestUserService_Update_WritesOutboxEvent(t *testing.T) {
+	store := repository.NewMemoryStore()
+	service := NewUserService(store, zap.NewNop())
+
+	user, err := service.Create(&models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	require.NoError(t, err)
+
+	// Execute the test
+	username := "renamed"
+	_, err = service.Update(user.ID, &models.UpdateUserRequest{Username: &username})
+
+	// Assertions
+	require.NoError(t, err)
+
+	events := store.OutboxEvents()
+	require.Len(t, events, 2)
+	assert.Equal(t, models.EventUserUpdated, events[1].EventType)
+	assert.Equal(t, user.ID, events[1].AggregateID)
+	assert.Contains(t, events[1].Payload, `"username":"renamed"`)
+	assert.Nil(t, events[1].SentAt)
+}
+
+func TestUserService_Create_RollsBackOnOutboxFailure(t *testing.T) {
+	store := &failingOutboxStore{MemoryStore: repository.NewMemoryStore()}
+	service := NewUserService(store, zap.NewNop())
+
+	req := &models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	}
+
+	// Execute the test
+	user, err := service.Create(req)
+
+	// Assertions
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, user)
+
+	// The user and its audit entry are rolled back with the failed event
+	stored, err := service.GetByUsername("testuser")
+	assert.NoError(t, err)
+	assert.Nil(t, stored)
+	assert.Empty(t, store.AuditLogEntries())
+}
+
+// failingOutboxStore is a MemoryStore whose outbox writes fail
+type failingOutboxStore struct {
+	*repository.MemoryStore
+}
+
+func (s *failingOutboxStore) Outbox() repository.OutboxRepository {
+	return failingOutbox{s.MemoryStore.Outbox()}
+}
+
+func (s *failingOutboxStore) Transaction(fn func(tx repository.Store) error) error {
+	return s.MemoryStore.Transaction(func(repository.Store) error {
+		return fn(s)
+	})
+}
+
+type failingOutbox struct {
+	repository.OutboxRepository
+}
+
+func (failingOutbox) Add(*models.OutboxEvent) error {
+	return assert.AnError
+}
+
+func TestUserService_CreateAdmin_AdminExists(t *testing.T) {
+	service, mockDB := setupUserService()
+
+	req := &models.CreateUserRequest{
+		Username: "admin",
+		Email:    "admin@example.com",
+		Password: "password123",
+	}
+
+	mockDB.On("Get", mock.Anything, "SELECT EXISTS(SELECT 1 FROM users WHERE is_admin = TRUE)", []interface{}(nil)).
+		Return(nil).Run(func(args mock.Arguments) {
+		// Simulate an existing admin
+		dest := args.Get(0).(*bool)
+		*dest = true
+	})
+
+	// Execute the test
+	user, err := service.CreateAdmin(req, false)
+
+	// Assertions
+	assert.ErrorIs(t, err, ErrAdminExists)
+	assert.Nil(t, user)
 
 	mockDB.AssertExpectations(t)
-	mockResult.AssertExpectations(t)
 }
 
-func TestUserService_Delete_NotFound(t *testing.T) {
+func TestUserService_CreateAdmin_ForceSkipsAdminCheck(t *testing.T) {
 	service, mockDB := setupUserService()
 
-	mockResult := &MockResult{}
-	mockResult.On("RowsAffected").Return(int64(0), nil)
+	req := &models.CreateUserRequest{
+		Username: "admin",
+		Email:    "admin@example.com",
+		Password: "password123",
+	}
 
-	mockDB.On("Exec", "DELETE FROM users WHERE id = $1", []interface{}{1}).
-		Return(mockResult, nil)
+	// With force the admin check is skipped and the username conflict check runs
+	mockDB.On("Get", mock.Anything, "SELECT * FROM users WHERE username = $1", []interface{}{"admin"}).
+		Return(nil).Run(func(args mock.Arguments) {
+		dest := args.Get(0).(*models.User)
+		*dest = models.User{ID: 1, Username: "admin", IsAdmin: true}
+	})
 
 	// Execute the test
-	err := service.Delete(1)
+	user, err := service.CreateAdmin(req, true)
 
 	// Assertions
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "user not found")
+	assert.Nil(t, user)
+	assert.Contains(t, err.Error(), "username already exists")
 
 	mockDB.AssertExpectations(t)
-	mockResult.AssertExpectations(t)
-}
\ No newline at end of file
+	mockDB.AssertNotCalled(t, "Get", mock.Anything, "SELECT EXISTS(SELECT 1 FROM users WHERE is_admin = TRUE)", mock.Anything)
+}
+
+func TestUserService_CreateAdmin_WeakPassword(t *testing.T) {
+	service, mockDB := setupUserService()
+
+	req := &models.CreateUserRequest{
+		Username: "admin",
+		Email:    "admin@example.com",
+		Password: "short",
+	}
+
+	// Execute the test
+	user, err := service.CreateAdmin(req, true)
+
+	// Assertions
+	assert.Error(t, err)
+	assert.Nil(t, user)
+	assert.Contains(t, err.Error(), "at least 8 characters")
+
+	mockDB.AssertExpectations(t)
+}
+
+func TestUserService_Create_RunsInTransaction(t *testing.T) {
+	service, mockDB := setupUserService()
+
+	req := &models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	}
+
+	mockDB.On("Get", mock.Anything, "SELECT * FROM users WHERE username = $1", []interface{}{"testuser"}).
+		Return(sql.ErrNoRows)
+	mockDB.On("Get", mock.Anything, "SELECT * FROM users WHERE email = $1", []interface{}{"test@example.com"}).
+		Return(sql.ErrNoRows)
+	mockDB.On("Transaction", mock.Anything).Return(assert.AnError)
+
+	// Execute the test
+	user, err := service.Create(req)
+
+	// Assertions
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Nil(t, user)
+
+	// The insert must not bypass the transaction
+	mockDB.AssertNotCalled(t, "NamedQuery", mock.Anything, mock.Anything)
+	mockDB.AssertExpectations(t)
+}
+
+func TestAuditService_Record(t *testing.T) {
+	mockDB := &MockDB{}
+	auditService := NewAuditService(repository.NewSQLStore(mockDB).AuditLogs(), zap.NewNop())
+
+	mockResult := &MockResult{}
+	mockDB.On("NamedExec", mock.Anything, mock.MatchedBy(func(entry *models.AuditLog) bool {
+		return entry.Action == models.AuditActionUserCreated &&
+			*entry.UserID == 7 &&
+			entry.ActorID == nil &&
+			*entry.Details == `{"username":"testuser"}`
+	})).Return(mockResult, nil)
+
+	// Execute the test
+	userID := 7
+	err := auditService.Record(models.AuditActionUserCreated, &userID, nil, map[string]string{"username": "testuser"})
+
+	// Assertions
+	assert.NoError(t, err)
+
+	mockDB.AssertExpectations(t)
+}
+
+func TestUserService_Authenticate_RehashesPassword(t *testing.T) {
+	models.SetPasswordHasher(models.NewBcryptHasher(bcrypt.MinCost))
+	t.Cleanup(func() { models.SetPasswordHasher(models.NewBcryptHasher(bcrypt.DefaultCost)) })
+
+	store := repository.NewMemoryStore()
+	service := NewUserService(store, zap.NewNop())
+	_, err := service.Create(&models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	require.NoError(t, err)
+
+	// Switching to argon2id upgrades the bcrypt hash on the next login
+	models.SetPasswordHasher(models.NewArgon2idHasher(models.Argon2idParams{
+		Time: 1, Memory: 1024, Threads: 1, KeyLength: 32, SaltLength: 16,
+	}))
+	_, err = service.Authenticate("testuser", models.IdentifierAuto, "password123")
+	require.NoError(t, err)
+
+	stored, err := service.GetByUsername("testuser")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.Password, "$argon2id$"), stored.Password)
+	assert.False(t, stored.PasswordNeedsRehash())
+
+	// The new hash works, and is left alone from then on
+	_, err = service.Authenticate("testuser", models.IdentifierAuto, "password123")
+	require.NoError(t, err)
+	again, err := service.GetByUsername("testuser")
+	require.NoError(t, err)
+	assert.Equal(t, stored.Password, again.Password)
+}
+
+func TestUserService_Authenticate_UpgradesBcryptCost(t *testing.T) {
+	models.SetPasswordHasher(models.NewBcryptHasher(bcrypt.MinCost))
+	t.Cleanup(func() { models.SetPasswordHasher(models.NewBcryptHasher(bcrypt.DefaultCost)) })
+
+	store := repository.NewMemoryStore()
+	service := NewUserService(store, zap.NewNop())
+	_, err := service.Create(&models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+	})
+	require.NoError(t, err)
+
+	// A wrong password leaves the outdated hash alone
+	models.SetPasswordHasher(models.NewBcryptHasher(bcrypt.MinCost + 1))
+	_, err = service.Authenticate("testuser", models.IdentifierAuto, "wrongpassword")
+	require.Error(t, err)
+	stored, err := service.GetByUsername("testuser")
+	require.NoError(t, err)
+	cost, err := bcrypt.Cost([]byte(stored.Password))
+	require.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost, cost)
+
+	_, err = service.Authenticate("testuser", models.IdentifierAuto, "password123")
+	require.NoError(t, err)
+
+	stored, err = service.GetByUsername("testuser")
+	require.NoError(t, err)
+	cost, err = bcrypt.Cost([]byte(stored.Password))
+	require.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost+1, cost)
+	assert.NoError(t, stored.CheckPassword("password123"))
+}
+
+func TestUserService_Update_RejectsPasswordPastBcryptLimit(t *testing.T) {
+	service, _, users := newMemoryUserService(t, "testuser")
+
+	// 30 three-byte characters are 90 bytes
+	password := strings.Repeat("密", 30)
+	_, err := service.Update(users[0].ID, &models.UpdateUserRequest{Password: &password})
+
+	var invalid *models.PasswordError
+	assert.ErrorAs(t, err, &invalid)
+	_, err = service.Authenticate("testuser", models.IdentifierUsername, "password123")
+	assert.NoError(t, err)
+}
+
+func TestUserService_Update_FullName(t *testing.T) {
+	service := NewUserService(repository.NewMemoryStore(), zap.NewNop())
+	fullName := "Test User"
+	user, err := service.Create(&models.CreateUserRequest{
+		Username: "testuser",
+		Email:    "test@example.com",
+		Password: "password123",
+		FullName: &fullName,
+	})
+	require.NoError(t, err)
+
+	// Absent leaves the full name alone
+	updated, err := service.Update(user.ID, &models.UpdateUserRequest{})
+	require.NoError(t, err)
+	require.NotNil(t, updated.FullName)
+	assert.Equal(t, "Test User", *updated.FullName)
+
+	// A value sets it, even an empty one
+	updated, err = service.Update(user.ID, &models.UpdateUserRequest{FullName: models.NullableValue("")})
+	require.NoError(t, err)
+	require.NotNil(t, updated.FullName)
+	assert.Equal(t, "", *updated.FullName)
+
+	// Null clears it
+	updated, err = service.Update(user.ID, &models.UpdateUserRequest{FullName: models.NullableNull[string]()})
+	require.NoError(t, err)
+	assert.Nil(t, updated.FullName)
+
+	stored, err := service.GetByID(user.ID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.FullName)
+}
+
+func TestUserService_Authenticate_IdentifierTypes(t *testing.T) {
+	service := NewUserService(repository.NewMemoryStore(), zap.NewNop())
+	for _, req := range []*models.CreateUserRequest{
+		{Username: "testuser", Email: "test@example.com", Password: "password123"},
+		// A username with an @, looking like the email of someone else
+		{Username: "other@example.com", Email: "other@example.org", Password: "password456"},
+	} {
+		_, err := service.Create(req)
+		require.NoError(t, err)
+	}
+
+	tests := []struct {
+		identifier     string
+		identifierType models.IdentifierType
+		password       string
+		username       string
+	}{
+		{"testuser", models.IdentifierUsername, "password123", "testuser"},
+		{"test@example.com", models.IdentifierEmail, "password123", "testuser"},
+		{"testuser", models.IdentifierAuto, "password123", "testuser"},
+		{"test@example.com", models.IdentifierAuto, "password123", "testuser"},
+		{"other@example.com", models.IdentifierUsername, "password456", "other@example.com"},
+		// Nobody has this email, so auto falls back to usernames
+		{"other@example.com", models.IdentifierAuto, "password456", "other@example.com"},
+		{"other@example.org", models.IdentifierEmail, "password456", "other@example.com"},
+	}
+
+	for _, tt := range tests {
+		user, err := service.Authenticate(tt.identifier, tt.identifierType, tt.password)
+		require.NoError(t, err, "%s as %s", tt.identifier, tt.identifierType)
+		assert.Equal(t, tt.username, user.Username, "%s as %s", tt.identifier, tt.identifierType)
+	}
+
+	// Explicit types don't fall back
+	for _, tt := range []struct {
+		identifier     string
+		identifierType models.IdentifierType
+	}{
+		{"test@example.com", models.IdentifierUsername},
+		{"testuser", models.IdentifierEmail},
+		{"other@example.com", models.IdentifierEmail},
+		{"nobody@example.com", models.IdentifierAuto},
+	} {
+		_, err := service.Authenticate(tt.identifier, tt.identifierType, "password123")
+		assert.EqualError(t, err, "invalid credentials", "%s as %s", tt.identifier, tt.identifierType)
+	}
+}
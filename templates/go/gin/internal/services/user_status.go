@@ -0,0 +1,68 @@
+package services
+
+import (
+	"errors"
+
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// ErrInvalidStatus is returned when setting a status that isn't one of the
+// models.Status values
+var ErrInvalidStatus = errors.New("invalid user status")
+
+// SetStatus changes the status of the user id on behalf of the admin
+// adminID, recording it in the audit trail. Activating an account cancels
+// its scheduled deletion. Setting the status a user already has changes
+// nothing.
+func (s *UserService) SetStatus(id int, status models.Status, adminID int) (*models.User, error) {
+	if !status.IsValid() {
+		return nil, ErrInvalidStatus
+	}
+
+	var user *models.User
+	changed := false
+	err := s.inTxWithRetry(func(txService *UserService) error {
+		var err error
+		changed = false
+		user, err = txService.users.FindByID(id)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return repository.ErrUserNotFound
+		}
+		if user.Status == status {
+			return nil
+		}
+
+		previous := user.Status
+		user.SetStatus(status)
+		if status == models.StatusActive {
+			user.DeletionScheduledAt = nil
+		}
+		user.BeforeUpdate()
+		if err := txService.users.Update(user); err != nil {
+			return err
+		}
+		changed = true
+
+		if err := txService.audit.Record(models.AuditActionStatusChanged, &user.ID, &adminID, map[string]interface{}{
+			"from": previous,
+			"to":   status,
+		}); err != nil {
+			return err
+		}
+		return txService.outbox.Record(models.EventUserUpdated, user.ID, user.ToResponse())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if changed {
+		s.logger.Info("User status changed", zap.Int("target_user_id", user.ID), zap.String("status", string(status)))
+	}
+	return user, nil
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"testing"
+
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserService_SetStatus(t *testing.T) {
+	service, store, admin, user := setupImpersonationService(t)
+	assert.Equal(t, models.StatusActive, user.Status)
+
+	suspended, err := service.SetStatus(user.ID, models.StatusSuspended, admin.ID)
+
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusSuspended, suspended.Status)
+	assert.False(t, suspended.IsActive)
+
+	entry := lastAuditEntry(t, store)
+	assert.Equal(t, models.AuditActionStatusChanged, entry.Action)
+	assert.Equal(t, user.ID, *entry.UserID)
+	assert.Equal(t, admin.ID, *entry.ActorID)
+
+	// Suspension isn't lifted by is_active
+	active := true
+	updated, err := service.Update(user.ID, &models.UpdateUserRequest{IsActive: &active})
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusSuspended, updated.Status)
+	assert.False(t, updated.IsActive)
+
+	reactivated, err := service.SetStatus(user.ID, models.StatusActive, admin.ID)
+	require.NoError(t, err)
+	assert.True(t, reactivated.IsActive)
+
+	_, err = service.SetStatus(user.ID, models.Status("banned"), admin.ID)
+	assert.ErrorIs(t, err, ErrInvalidStatus)
+	_, err = service.SetStatus(42, models.StatusSuspended, admin.ID)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound)
+}
+
+func TestUserService_SetStatus_ActivatingCancelsDeletion(t *testing.T) {
+	service, _, admin, user := setupImpersonationService(t)
+	_, err := service.ScheduleDeletion(user.ID)
+	require.NoError(t, err)
+
+	activated, err := service.SetStatus(user.ID, models.StatusActive, admin.ID)
+
+	require.NoError(t, err)
+	assert.True(t, activated.IsActive)
+	assert.Nil(t, activated.DeletionScheduledAt)
+}
+
+func TestUserService_Authenticate_Status(t *testing.T) {
+	tests := []struct {
+		status      models.Status
+		expectedErr error
+	}{
+		{models.StatusActive, nil},
+		{models.StatusInactive, ErrAccountInactive},
+		{models.StatusSuspended, ErrAccountSuspended},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			service, _, admin, user := setupImpersonationService(t)
+			_, err := service.SetStatus(user.ID, tt.status, admin.ID)
+			require.NoError(t, err)
+
+			_, err = service.Authenticate("testuser", models.IdentifierUsername, "password123")
+			if tt.expectedErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.expectedErr)
+			}
+
+			// A wrong password doesn't tell the status
+			_, err = service.Authenticate("testuser", models.IdentifierUsername, "wrongpassword")
+			assert.ErrorIs(t, err, ErrInvalidCredentials)
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gin-service/internal/events"
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// ErrWebhookNotFound is returned for operations on a webhook that does not
+// exist
+var ErrWebhookNotFound = repository.ErrWebhookNotFound
+
+// WebhookEventTypes are the user events webhooks can subscribe to
+var WebhookEventTypes = []string{models.EventUserCreated, models.EventUserUpdated, models.EventUserDeleted}
+
+// SignatureHeader carries the HMAC-SHA256 of a delivery's body, keyed with
+// the webhook's secret, as "sha256=" followed by the hex digest
+const SignatureHeader = "X-Signature"
+
+// webhookSecretBytes is the length of generated webhook secrets, before hex
+// encoding
+const webhookSecretBytes = 32
+
+// webhookDeliveryListLimit caps the deliveries listed at once
+const webhookDeliveryListLimit = 100
+
+// WebhookService manages webhook registrations and queues deliveries of
+// user events to them
+type WebhookService struct {
+	store  repository.Store
+	logger *zap.Logger
+}
+
+// NewWebhookService creates a new webhook service
+func NewWebhookService(store repository.Store, logger *zap.Logger) *WebhookService {
+	return &WebhookService{
+		store:  store,
+		logger: logger,
+	}
+}
+
+// Sign returns the signature of body sent in the SignatureHeader
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Create registers a webhook, generating its secret unless one is given.
+// Webhooks are active unless created otherwise.
+func (s *WebhookService) Create(req *models.CreateWebhookRequest) (*models.Webhook, error) {
+	secret := req.Secret
+	if secret == "" {
+		generated, err := generateWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+		secret = generated
+	}
+
+	now := time.Now()
+	webhook := &models.Webhook{
+		URL:        req.URL,
+		Secret:     secret,
+		EventTypes: req.EventTypes,
+		Active:     req.Active == nil || *req.Active,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := s.store.Webhooks().Create(webhook); err != nil {
+		s.logger.Error("Failed to create webhook", zap.Error(err))
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	s.logger.Info("Webhook created", zap.Int("webhook_id", webhook.ID), zap.Strings("event_types", webhook.EventTypes))
+	return webhook, nil
+}
+
+// generateWebhookSecret returns a random hex-encoded secret
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, webhookSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// EnsureWebhooks registers the webhooks configured outside the API, such as
+// in the config file. A registered webhook with the same URL is updated to
+// the given secret and event types and reactivated, so repeating it is
+// harmless.
+func (s *WebhookService) EnsureWebhooks(reqs []models.CreateWebhookRequest) error {
+	return s.store.Transaction(func(tx repository.Store) error {
+		registered, err := tx.Webhooks().List()
+		if err != nil {
+			return fmt.Errorf("failed to list webhooks: %w", err)
+		}
+		byURL := make(map[string]*models.Webhook, len(registered))
+		for _, webhook := range registered {
+			byURL[webhook.URL] = webhook
+		}
+
+		txService := &WebhookService{store: tx, logger: s.logger}
+		for _, req := range reqs {
+			req := req
+			webhook, ok := byURL[req.URL]
+			if !ok {
+				if _, err := txService.Create(&req); err != nil {
+					return err
+				}
+				continue
+			}
+
+			active := true
+			update := &models.UpdateWebhookRequest{EventTypes: req.EventTypes, Active: &active}
+			if req.Secret != "" {
+				update.Secret = &req.Secret
+			}
+			if _, err := txService.Update(webhook.ID, update); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Get returns a webhook, or ErrWebhookNotFound
+func (s *WebhookService) Get(id int) (*models.Webhook, error) {
+	webhook, err := s.store.Webhooks().FindByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+	if webhook == nil {
+		return nil, ErrWebhookNotFound
+	}
+	return webhook, nil
+}
+
+// List returns all webhooks
+func (s *WebhookService) List() ([]*models.Webhook, error) {
+	webhooks, err := s.store.Webhooks().List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+// Update changes the fields of a webhook present in req
+func (s *WebhookService) Update(id int, req *models.UpdateWebhookRequest) (*models.Webhook, error) {
+	webhook, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.URL != nil {
+		webhook.URL = *req.URL
+	}
+	if req.Secret != nil {
+		webhook.Secret = *req.Secret
+	}
+	if req.EventTypes != nil {
+		webhook.EventTypes = req.EventTypes
+	}
+	if req.Active != nil {
+		webhook.Active = *req.Active
+	}
+	webhook.UpdatedAt = time.Now()
+
+	if err := s.store.Webhooks().Update(webhook); err != nil {
+		if errors.Is(err, ErrWebhookNotFound) {
+			return nil, err
+		}
+		s.logger.Error("Failed to update webhook", zap.Error(err), zap.Int("webhook_id", id))
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+
+	s.logger.Info("Webhook updated", zap.Int("webhook_id", id))
+	return webhook, nil
+}
+
+// Delete deletes a webhook along with its deliveries
+func (s *WebhookService) Delete(id int) error {
+	if err := s.store.Webhooks().Delete(id); err != nil {
+		if errors.Is(err, ErrWebhookNotFound) {
+			return err
+		}
+		s.logger.Error("Failed to delete webhook", zap.Error(err), zap.Int("webhook_id", id))
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+
+	s.logger.Info("Webhook deleted", zap.Int("webhook_id", id))
+	return nil
+}
+
+// ListDeliveries returns the latest deliveries, of the webhook unless
+// webhookID is 0 and with the status unless it is empty
+func (s *WebhookService) ListDeliveries(webhookID int, status string) ([]*models.WebhookDelivery, error) {
+	deliveries, err := s.store.Webhooks().ListDeliveries(webhookID, status, webhookDeliveryListLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// HandleEvent queues a delivery of a published event to every webhook
+// subscribed to it. It is subscribed to the event bus for the
+// WebhookEventTypes; an event published again is not queued twice.
+func (s *WebhookService) HandleEvent(ctx context.Context, event events.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+
+	return s.store.WithContext(ctx).Transaction(func(tx repository.Store) error {
+		webhooks, err := tx.Webhooks().ListSubscribed(event.Type)
+		if err != nil {
+			return fmt.Errorf("failed to find subscribed webhooks: %w", err)
+		}
+
+		now := time.Now()
+		for _, webhook := range webhooks {
+			delivery := &models.WebhookDelivery{
+				WebhookID:     webhook.ID,
+				EventID:       event.ID,
+				EventType:     event.Type,
+				Payload:       string(body),
+				Status:        models.WebhookDeliveryPending,
+				NextAttemptAt: now,
+			}
+			if err := tx.Webhooks().AddDelivery(delivery); err != nil {
+				return fmt.Errorf("failed to queue webhook delivery: %w", err)
+			}
+		}
+		return nil
+	})
+}
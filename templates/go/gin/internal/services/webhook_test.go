@@ -0,0 +1,298 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"gin-service/internal/events"
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var testDeliveryOptions = WebhookDeliveryOptions{
+	BatchSize:   10,
+	Timeout:     time.Second,
+	MaxAttempts: 3,
+	BaseBackoff: time.Minute,
+	MaxBackoff:  time.Hour,
+}
+
+// webhookReceiver is an httptest server recording the deliveries it
+// receives, answering them with status
+type webhookReceiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   []string
+}
+
+func newWebhookReceiver(t *testing.T, status int) *webhookReceiver {
+	receiver := &webhookReceiver{status: status}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		receiver.requests = append(receiver.requests, r)
+		receiver.bodies = append(receiver.bodies, string(body))
+		w.WriteHeader(receiver.status)
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+func (r *webhookReceiver) received() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+// setupWebhook registers a webhook for user.created events at url and
+// queues the delivery of one event to it
+func setupWebhook(t *testing.T, url string) (*WebhookService, *repository.MemoryStore, *models.Webhook) {
+	store := repository.NewMemoryStore()
+	service := NewWebhookService(store, zap.NewNop())
+
+	webhook, err := service.Create(&models.CreateWebhookRequest{
+		URL:        url,
+		Secret:     "webhook-secret-0123",
+		EventTypes: []string{models.EventUserCreated},
+	})
+	require.NoError(t, err)
+
+	event := events.Event{
+		ID:          1,
+		Type:        models.EventUserCreated,
+		AggregateID: 7,
+		Payload:     json.RawMessage(`{"id":7}`),
+		OccurredAt:  time.Now(),
+	}
+	require.NoError(t, service.HandleEvent(context.Background(), event))
+	return service, store, webhook
+}
+
+func TestSign(t *testing.T) {
+	assert.Equal(t, "sha256=70c13e232e9afe3fabac2f0178b1f0b6b2ae7929a0fc7a119e0058de10387492",
+		Sign("webhook-secret", []byte(`{"id":1}`)))
+	assert.NotEqual(t, Sign("webhook-secret", []byte(`{"id":1}`)), Sign("other-secret", []byte(`{"id":1}`)))
+}
+
+func TestWebhookService_CreateGeneratesSecret(t *testing.T) {
+	service := NewWebhookService(repository.NewMemoryStore(), zap.NewNop())
+
+	webhook, err := service.Create(&models.CreateWebhookRequest{
+		URL:        "https://example.com/hooks",
+		EventTypes: []string{models.EventUserCreated},
+	})
+
+	require.NoError(t, err)
+	assert.Len(t, webhook.Secret, 2*webhookSecretBytes)
+	assert.True(t, webhook.Active)
+}
+
+func TestWebhookService_EnsureWebhooks(t *testing.T) {
+	service := NewWebhookService(repository.NewMemoryStore(), zap.NewNop())
+	inactive := false
+	_, err := service.Create(&models.CreateWebhookRequest{
+		URL:        "https://example.com/existing",
+		EventTypes: []string{models.EventUserDeleted},
+		Active:     &inactive,
+	})
+	require.NoError(t, err)
+
+	configured := []models.CreateWebhookRequest{
+		{URL: "https://example.com/existing", Secret: "webhook-secret-0123", EventTypes: []string{models.EventUserCreated}},
+		{URL: "https://example.com/new", Secret: "webhook-secret-4567", EventTypes: []string{models.EventUserUpdated}},
+	}
+	require.NoError(t, service.EnsureWebhooks(configured))
+	// Registering them again at the next startup changes nothing
+	require.NoError(t, service.EnsureWebhooks(configured))
+
+	webhooks, err := service.List()
+	require.NoError(t, err)
+	require.Len(t, webhooks, 2)
+	assert.Equal(t, "webhook-secret-0123", webhooks[0].Secret)
+	assert.Equal(t, []string{models.EventUserCreated}, []string(webhooks[0].EventTypes))
+	assert.True(t, webhooks[0].Active)
+	assert.Equal(t, "https://example.com/new", webhooks[1].URL)
+	assert.Equal(t, "webhook-secret-4567", webhooks[1].Secret)
+}
+
+func TestWebhookService_HandleEvent_QueuesSubscribedOnce(t *testing.T) {
+	store := repository.NewMemoryStore()
+	service := NewWebhookService(store, zap.NewNop())
+	inactive := false
+	for _, req := range []*models.CreateWebhookRequest{
+		{URL: "https://example.com/created", EventTypes: []string{models.EventUserCreated, models.EventUserDeleted}},
+		{URL: "https://example.com/updated", EventTypes: []string{models.EventUserUpdated}},
+		{URL: "https://example.com/inactive", EventTypes: []string{models.EventUserCreated}, Active: &inactive},
+	} {
+		_, err := service.Create(req)
+		require.NoError(t, err)
+	}
+
+	event := events.Event{ID: 5, Type: models.EventUserCreated, AggregateID: 1, Payload: json.RawMessage(`{}`)}
+	require.NoError(t, service.HandleEvent(context.Background(), event))
+	// The outbox may publish an event again
+	require.NoError(t, service.HandleEvent(context.Background(), event))
+
+	deliveries, err := service.ListDeliveries(0, "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, 1, deliveries[0].WebhookID)
+	assert.Equal(t, int64(5), deliveries[0].EventID)
+	assert.Equal(t, models.WebhookDeliveryPending, deliveries[0].Status)
+}
+
+func TestWebhookWorker_Delivers(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusNoContent)
+	service, store, webhook := setupWebhook(t, receiver.URL)
+	worker := NewWebhookWorker(store.Webhooks(), testDeliveryOptions, time.Second, zap.NewNop())
+
+	attempted, err := worker.DeliverDue(context.Background(), time.Now())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, attempted)
+	require.Equal(t, 1, receiver.received())
+	request, body := receiver.requests[0], receiver.bodies[0]
+	assert.Equal(t, http.MethodPost, request.Method)
+	assert.Equal(t, Sign(webhook.Secret, []byte(body)), request.Header.Get(SignatureHeader))
+	assert.Equal(t, models.EventUserCreated, request.Header.Get("X-Webhook-Event"))
+
+	var event events.Event
+	require.NoError(t, json.Unmarshal([]byte(body), &event))
+	assert.Equal(t, int64(1), event.ID)
+	assert.Equal(t, 7, event.AggregateID)
+	assert.JSONEq(t, `{"id":7}`, string(event.Payload))
+
+	deliveries, err := service.ListDeliveries(webhook.ID, models.WebhookDeliveryDelivered)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	require.NotNil(t, deliveries[0].ResponseStatus)
+	assert.Equal(t, http.StatusNoContent, *deliveries[0].ResponseStatus)
+	assert.NotNil(t, deliveries[0].DeliveredAt)
+
+	// Delivered events aren't sent again
+	attempted, err = worker.DeliverDue(context.Background(), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, attempted)
+}
+
+func TestWebhookWorker_RetriesThenDeadLetters(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError)
+	service, store, webhook := setupWebhook(t, receiver.URL)
+	core, logs := observer.New(zapcore.InfoLevel)
+	worker := NewWebhookWorker(store.Webhooks(), testDeliveryOptions, time.Second, zap.New(core))
+	now := time.Now()
+
+	attempted, err := worker.DeliverDue(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, attempted)
+
+	pending, err := service.ListDeliveries(webhook.ID, models.WebhookDeliveryPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, now.Add(time.Minute), pending[0].NextAttemptAt)
+	require.NotNil(t, pending[0].LastError)
+	assert.Contains(t, *pending[0].LastError, "500")
+
+	// Not retried before the backoff
+	attempted, err = worker.DeliverDue(context.Background(), now.Add(30*time.Second))
+	require.NoError(t, err)
+	assert.Zero(t, attempted)
+
+	// The backoff doubles: the third attempt follows the second by 2m
+	now = now.Add(time.Minute)
+	_, err = worker.DeliverDue(context.Background(), now)
+	require.NoError(t, err)
+	pending, err = service.ListDeliveries(webhook.ID, models.WebhookDeliveryPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, now.Add(2*time.Minute), pending[0].NextAttemptAt)
+
+	_, err = worker.DeliverDue(context.Background(), now.Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 3, receiver.received())
+
+	// Failed on every attempt, the delivery is dead and left alone
+	dead, err := service.ListDeliveries(0, models.WebhookDeliveryDead)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, 3, dead[0].Attempts)
+	require.NotNil(t, dead[0].ResponseStatus)
+	assert.Equal(t, http.StatusInternalServerError, *dead[0].ResponseStatus)
+
+	// The dead letter is logged with its payload
+	entries := logs.FilterMessage("Webhook delivery dead-lettered").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, dead[0].Payload, entries[0].ContextMap()["payload"])
+	assert.Equal(t, receiver.URL, entries[0].ContextMap()["url"])
+
+	attempted, err = worker.DeliverDue(context.Background(), now.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, attempted)
+	assert.Equal(t, 3, receiver.received())
+}
+
+func TestWebhookDeliveryOptions_Backoff(t *testing.T) {
+	opts := WebhookDeliveryOptions{BaseBackoff: 30 * time.Second, MaxBackoff: 5 * time.Minute}
+
+	assert.Equal(t, 30*time.Second, opts.backoff(1))
+	assert.Equal(t, time.Minute, opts.backoff(2))
+	assert.Equal(t, 4*time.Minute, opts.backoff(4))
+	assert.Equal(t, 5*time.Minute, opts.backoff(5))
+	assert.Equal(t, 5*time.Minute, opts.backoff(50))
+}
+
+func TestWebhookWorker_BoundsConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	service, store, _ := setupWebhook(t, server.URL)
+	for id := int64(2); id <= 8; id++ {
+		event := events.Event{ID: id, Type: models.EventUserCreated, AggregateID: int(id), Payload: json.RawMessage(`{}`)}
+		require.NoError(t, service.HandleEvent(context.Background(), event))
+	}
+	opts := testDeliveryOptions
+	opts.Concurrency = 3
+	worker := NewWebhookWorker(store.Webhooks(), opts, time.Second, zap.NewNop())
+
+	attempted, err := worker.DeliverDue(context.Background(), time.Now())
+
+	require.NoError(t, err)
+	assert.Equal(t, 8, attempted)
+	assert.Equal(t, 3, maxInFlight)
+	delivered, err := service.ListDeliveries(0, models.WebhookDeliveryDelivered)
+	require.NoError(t, err)
+	assert.Len(t, delivered, 8)
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gin-service/internal/models"
+	"gin-service/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+// WebhookDeliveryOptions configures how deliveries are attempted
+type WebhookDeliveryOptions struct {
+	// BatchSize is how many due deliveries are claimed at once
+	BatchSize int
+	// Concurrency bounds how many deliveries of a batch are attempted at
+	// once; 0 attempts them one at a time
+	Concurrency int
+	// Timeout bounds each delivery request
+	Timeout time.Duration
+	// MaxAttempts is how many times a delivery is attempted before it is
+	// left dead
+	MaxAttempts int
+	// BaseBackoff is the delay before the second attempt, doubled before
+	// each further attempt up to MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+// backoff returns the delay before the attempt following attempt
+func (o WebhookDeliveryOptions) backoff(attempt int) time.Duration {
+	delay := o.BaseBackoff
+	for i := 1; i < attempt && delay < o.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > o.MaxBackoff {
+		delay = o.MaxBackoff
+	}
+	return delay
+}
+
+// WebhookWorker POSTs queued deliveries to their webhooks, retrying failed
+// deliveries with exponential backoff
+type WebhookWorker struct {
+	repo     repository.WebhookRepository
+	client   *http.Client
+	opts     WebhookDeliveryOptions
+	interval time.Duration
+	logger   *zap.Logger
+}
+
+// NewWebhookWorker creates a worker attempting due deliveries every interval
+func NewWebhookWorker(repo repository.WebhookRepository, opts WebhookDeliveryOptions, interval time.Duration, logger *zap.Logger) *WebhookWorker {
+	return &WebhookWorker{
+		repo:     repo,
+		client:   &http.Client{Timeout: opts.Timeout},
+		opts:     opts,
+		interval: interval,
+		logger:   logger.With(zap.String("component", "webhook_worker")),
+	}
+}
+
+// Run attempts due deliveries every interval until ctx is cancelled
+func (w *WebhookWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.DeliverDue(ctx, time.Now()); err != nil {
+			w.logger.Error("Failed to deliver webhooks", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue claims the deliveries due at now and attempts them, up to
+// Concurrency at a time, returning how many were attempted. A failed attempt
+// is retried after a backoff until MaxAttempts, after which the delivery is
+// dead.
+func (w *WebhookWorker) DeliverDue(ctx context.Context, now time.Time) (int, error) {
+	concurrency := w.opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	// The claim outlives the requests of the batch, even when they all time
+	// out
+	rounds := (w.opts.BatchSize + concurrency - 1) / concurrency
+	lease := w.opts.Timeout*time.Duration(rounds) + time.Minute
+	claimed, err := w.repo.ClaimDeliveries(now, w.opts.BatchSize, lease)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+
+	webhooks := make(map[int]*models.Webhook)
+	for _, delivery := range claimed {
+		if _, ok := webhooks[delivery.WebhookID]; ok {
+			continue
+		}
+		webhook, err := w.repo.FindByID(delivery.WebhookID)
+		if err != nil {
+			// The deliveries are attempted again once their claim expires
+			return 0, fmt.Errorf("failed to find webhook %d: %w", delivery.WebhookID, err)
+		}
+		webhooks[delivery.WebhookID] = webhook
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		attempted int
+		firstErr  error
+	)
+	slots := make(chan struct{}, concurrency)
+	for _, delivery := range claimed {
+		webhook := webhooks[delivery.WebhookID]
+		// Deleted meanwhile, along with its deliveries
+		if webhook == nil {
+			continue
+		}
+
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(delivery *models.WebhookDelivery) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			w.attempt(ctx, webhook, delivery, now)
+			err := w.repo.SaveDeliveryAttempt(delivery)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				// The delivery is attempted again once its claim expires
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to save webhook delivery %d: %w", delivery.ID, err)
+				}
+				return
+			}
+			attempted++
+		}(delivery)
+	}
+	wg.Wait()
+
+	return attempted, firstErr
+}
+
+// attempt POSTs the delivery to the webhook, updating the delivery with the
+// outcome. Deliveries to a deactivated webhook are given up at once.
+func (w *WebhookWorker) attempt(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery, now time.Time) {
+	var statusCode int
+	err := fmt.Errorf("webhook is inactive")
+	if webhook.Active {
+		statusCode, err = w.post(ctx, webhook, delivery)
+	}
+	if statusCode != 0 {
+		delivery.ResponseStatus = &statusCode
+	}
+
+	if err == nil {
+		deliveredAt := time.Now()
+		delivery.Status = models.WebhookDeliveryDelivered
+		delivery.DeliveredAt = &deliveredAt
+		delivery.LastError = nil
+		return
+	}
+
+	lastError := err.Error()
+	delivery.LastError = &lastError
+	fields := []zap.Field{
+		zap.Error(err),
+		zap.Int64("delivery_id", delivery.ID),
+		zap.Int("webhook_id", webhook.ID),
+		zap.Int("attempts", delivery.Attempts),
+	}
+	if delivery.Attempts >= w.opts.MaxAttempts || !webhook.Active {
+		// Dead deliveries stay queryable, and are logged with enough to
+		// replay them by hand
+		delivery.Status = models.WebhookDeliveryDead
+		w.logger.Error("Webhook delivery dead-lettered", append(fields,
+			zap.String("url", webhook.URL),
+			zap.String("event_type", delivery.EventType),
+			zap.Int64("event_id", delivery.EventID),
+			zap.String("payload", delivery.Payload),
+		)...)
+		return
+	}
+	delivery.NextAttemptAt = now.Add(w.opts.backoff(delivery.Attempts))
+	w.logger.Warn("Webhook delivery failed, retrying", append(fields, zap.Time("next_attempt_at", delivery.NextAttemptAt))...)
+}
+
+// post sends the delivery's payload signed with the webhook's secret,
+// returning the response status. Only 2xx responses are successful.
+func (w *WebhookWorker) post(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, body))
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.ID, 10))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain some of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrInvalidKey is returned for keys that are empty or escape the store
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// BlobStore stores binary objects, such as uploaded images, under slash
+// separated keys. Implementations must be safe for concurrent use.
+type BlobStore interface {
+	// Put stores data under key, replacing any existing blob, and returns
+	// the URL it is served from
+	Put(ctx context.Context, key, contentType string, data io.Reader) (string, error)
+	// Delete removes the blob under key. Deleting a missing blob is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStore_PutAndDelete(t *testing.T) {
+	dir := t.TempDir()
+	store := NewLocalStore(dir, "/uploads/")
+
+	url, err := store.Put(context.Background(), "avatars/1", "image/png", strings.NewReader("first"))
+	require.NoError(t, err)
+	assert.Equal(t, "/uploads/avatars/1", url)
+
+	// Putting again replaces the blob
+	_, err = store.Put(context.Background(), "avatars/1", "image/png", strings.NewReader("second"))
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(dir, "avatars", "1"))
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Join(dir, "avatars"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, store.Delete(context.Background(), "avatars/1"))
+	_, err = os.Stat(filepath.Join(dir, "avatars", "1"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Deleting a missing blob is not an error
+	assert.NoError(t, store.Delete(context.Background(), "avatars/1"))
+}
+
+func TestLocalStore_RejectsKeysOutsideDir(t *testing.T) {
+	store := NewLocalStore(t.TempDir(), "/uploads")
+
+	for _, key := range []string{"", "../secret", "avatars/../../secret", "/etc/passwd"} {
+		_, err := store.Put(context.Background(), key, "image/png", strings.NewReader("x"))
+		assert.ErrorIs(t, err, ErrInvalidKey, key)
+		assert.ErrorIs(t, store.Delete(context.Background(), key), ErrInvalidKey, key)
+	}
+}
@@ -0,0 +1,5 @@
+-- Drop indexes
+DROP INDEX IF EXISTS idx_outbox_unsent;
+
+-- Drop outbox table
+DROP TABLE IF EXISTS outbox;
@@ -0,0 +1,17 @@
+-- Create outbox table for events written in the same transaction as the
+-- changes they describe, until they are published
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(100) NOT NULL,
+    aggregate_id INTEGER NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+    claimed_by VARCHAR(255),
+    claimed_at TIMESTAMP WITH TIME ZONE,
+    sent_at TIMESTAMP WITH TIME ZONE,
+    attempts INTEGER DEFAULT 0 NOT NULL,
+    last_error TEXT
+);
+
+-- Index the unsent events the poller scans in id order
+CREATE INDEX idx_outbox_unsent ON outbox(id) WHERE sent_at IS NULL;
@@ -0,0 +1,2 @@
+-- Drop the avatar URL
+ALTER TABLE users DROP COLUMN IF EXISTS avatar_url;
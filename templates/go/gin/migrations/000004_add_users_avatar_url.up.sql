@@ -0,0 +1,2 @@
+-- Add the URL of the user's uploaded avatar
+ALTER TABLE users ADD COLUMN avatar_url TEXT;
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS email_change_expires_at;
+ALTER TABLE users DROP COLUMN IF EXISTS email_change_token_hash;
+ALTER TABLE users DROP COLUMN IF EXISTS pending_email;
@@ -0,0 +1,5 @@
+-- Email changes wait for confirmation from the new address. The token is
+-- stored as a SHA-256 hash.
+ALTER TABLE users ADD COLUMN pending_email VARCHAR(255);
+ALTER TABLE users ADD COLUMN email_change_token_hash VARCHAR(64);
+ALTER TABLE users ADD COLUMN email_change_expires_at TIMESTAMP WITH TIME ZONE;
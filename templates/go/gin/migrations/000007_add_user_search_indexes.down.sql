@@ -0,0 +1,4 @@
+-- The extension is left installed, as other objects may depend on it
+DROP INDEX IF EXISTS idx_users_full_name_trgm;
+DROP INDEX IF EXISTS idx_users_email_trgm;
+DROP INDEX IF EXISTS idx_users_username_trgm;
@@ -0,0 +1,6 @@
+-- Accounts whose deletion was requested are deactivated and deleted once
+-- deletion_scheduled_at has passed, unless the user cancels before
+ALTER TABLE users ADD COLUMN deletion_scheduled_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_users_deletion_scheduled_at ON users(deletion_scheduled_at)
+    WHERE deletion_scheduled_at IS NOT NULL;
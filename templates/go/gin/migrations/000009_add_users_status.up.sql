@@ -0,0 +1,8 @@
+-- The status extends is_active with suspension. is_active is kept in step,
+-- true exactly when the status is active.
+ALTER TABLE users ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'active'
+    CHECK (status IN ('active', 'inactive', 'suspended'));
+
+UPDATE users SET status = 'inactive' WHERE NOT is_active;
+
+CREATE INDEX idx_users_status ON users(status);
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS users_notify_changes ON users;
+DROP FUNCTION IF EXISTS notify_user_changes();
@@ -0,0 +1,17 @@
+-- Notify the user_changes channel of every write to a user, with the user's
+-- ID, so that instances caching users in memory drop them. Notifications are
+-- sent when the writing transaction commits, and not at all if it rolls back.
+CREATE FUNCTION notify_user_changes() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('user_changes', OLD.id::text);
+    ELSE
+        PERFORM pg_notify('user_changes', NEW.id::text);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_notify_changes
+    AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION notify_user_changes();
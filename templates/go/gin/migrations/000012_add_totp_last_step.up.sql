@@ -0,0 +1,3 @@
+-- The time step of the last TOTP code accepted at login, so that each code
+-- logs in once however many times it is replayed within its validity
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_outbox_unsent;
+CREATE INDEX idx_outbox_unsent ON outbox(id) WHERE sent_at IS NULL;
+ALTER TABLE outbox DROP COLUMN IF EXISTS dead_at;
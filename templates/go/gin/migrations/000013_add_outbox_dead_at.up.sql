@@ -0,0 +1,7 @@
+-- When an event was given up on after failing to publish outbox.max_attempts
+-- times, so that it no longer holds up the events behind it
+ALTER TABLE outbox ADD COLUMN dead_at TIMESTAMP WITH TIME ZONE;
+
+-- The poller only scans events neither sent nor dead
+DROP INDEX IF EXISTS idx_outbox_unsent;
+CREATE INDEX idx_outbox_unsent ON outbox(id) WHERE sent_at IS NULL AND dead_at IS NULL;